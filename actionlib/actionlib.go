@@ -0,0 +1,275 @@
+// Package actionlib reconstructs the executions of actionlib goals from the goal, cancel, status,
+// feedback, and result topics of an action.
+package actionlib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// Status is the status of a goal from actionlib_msgs/GoalStatus.
+type Status uint8
+
+// The statuses of actionlib_msgs/GoalStatus.
+const (
+	StatusPending Status = iota
+	StatusActive
+	StatusPreempted
+	StatusSucceeded
+	StatusAborted
+	StatusRejected
+	StatusPreempting
+	StatusRecalling
+	StatusRecalled
+	StatusLost
+)
+
+var statusNames = []string{
+	"PENDING",
+	"ACTIVE",
+	"PREEMPTED",
+	"SUCCEEDED",
+	"ABORTED",
+	"REJECTED",
+	"PREEMPTING",
+	"RECALLING",
+	"RECALLED",
+	"LOST",
+}
+
+func (status Status) String() string {
+	if int(status) < len(statusNames) {
+		return statusNames[status]
+	}
+	return fmt.Sprintf("Status(%d)", uint8(status))
+}
+
+// Terminal reports whether the goal is done with status, so its status won't change anymore.
+func (status Status) Terminal() bool {
+	switch status {
+	case StatusPreempted, StatusSucceeded, StatusAborted, StatusRejected, StatusRecalled, StatusLost:
+		return true
+	}
+	return false
+}
+
+type goalID struct {
+	Stamp time.Time `rosbag:"stamp"`
+	ID    string    `rosbag:"id,copy"`
+}
+
+type goalStatus struct {
+	GoalID goalID `rosbag:"goal_id"`
+	Status uint8  `rosbag:"status"`
+	Text   string `rosbag:"text,copy"`
+}
+
+// actionGoal is the part of the <Action>ActionGoal messages that's shared by every action.
+type actionGoal struct {
+	GoalID goalID `rosbag:"goal_id"`
+}
+
+// actionStatus is the part of the <Action>ActionFeedback and <Action>ActionResult messages that's
+// shared by every action.
+type actionStatus struct {
+	Status goalStatus `rosbag:"status"`
+}
+
+type goalStatusArray struct {
+	StatusList []goalStatus `rosbag:"status_list"`
+}
+
+// StatusChange is a change of the status of a goal.
+type StatusChange struct {
+	// Time is the record time of the message that has the new status
+	Time   time.Time
+	Status Status
+}
+
+// Execution is a single goal of an action from its goal to its result. The times are the record
+// times of the messages, and they're zero when the messages are not in the bag.
+type Execution struct {
+	ID string
+	// Stamp is the stamp of the goal ID, which is set by the action client
+	Stamp    time.Time
+	Goal     time.Time
+	Canceled time.Time
+	Result   time.Time
+	// Statuses are the changes of the status in the order of the bag
+	Statuses []StatusChange
+	// Feedback is the number of feedback messages
+	Feedback     int
+	LastFeedback time.Time
+	// Text is the text of the latest status, e.g. the reason of an abort
+	Text string
+}
+
+// Status returns the latest status of the goal, or StatusPending when it doesn't have one.
+func (execution *Execution) Status() Status {
+	if len(execution.Statuses) == 0 {
+		return StatusPending
+	}
+	return execution.Statuses[len(execution.Statuses)-1].Status
+}
+
+// Duration returns the time from the goal to the result, or 0 when either is missing.
+func (execution *Execution) Duration() time.Duration {
+	if execution.Goal.IsZero() || execution.Result.IsZero() {
+		return 0
+	}
+	return execution.Result.Sub(execution.Goal)
+}
+
+func (execution *Execution) setStatus(t time.Time, status goalStatus) {
+	execution.Text = status.Text
+	// status arrays are published periodically, so only the changes are kept
+	if len(execution.Statuses) > 0 && execution.Status() == Status(status.Status) {
+		return
+	}
+	execution.Statuses = append(execution.Statuses, StatusChange{Time: t, Status: Status(status.Status)})
+}
+
+// Tracker correlates the messages of the topics of an action by goal ID.
+type Tracker struct {
+	// Namespace is the namespace of the action topics, e.g. /move_base
+	Namespace  string
+	executions []*Execution
+	byID       map[string]*Execution
+}
+
+// NewTracker creates a tracker for the action in namespace.
+func NewTracker(namespace string) *Tracker {
+	return &Tracker{
+		Namespace: namespace,
+		byID:      make(map[string]*Execution),
+	}
+}
+
+// Topics returns the goal, cancel, status, feedback, and result topics of the action.
+func (tracker *Tracker) Topics() []string {
+	var topics []string
+	for _, name := range []string{"goal", "cancel", "status", "feedback", "result"} {
+		topics = append(topics, tracker.Namespace+"/"+name)
+	}
+	return topics
+}
+
+// Read subscribes to the topics of the action in namespace, runs decoder to the end, and returns
+// the executions of the goals.
+func Read(decoder *rosbag.Decoder, namespace string) ([]*Execution, error) {
+	tracker := NewTracker(namespace)
+	for _, topic := range tracker.Topics() {
+		err := decoder.Subscribe(topic, tracker.Add)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := decoder.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return tracker.Executions(), nil
+}
+
+// Executions returns the executions in the order that their goals first appear in the bag.
+func (tracker *Tracker) Executions() []*Execution {
+	return tracker.executions
+}
+
+// execution returns the execution of id, a new one is created when the goal hasn't been seen.
+func (tracker *Tracker) execution(id goalID) *Execution {
+	execution, ok := tracker.byID[id.ID]
+	if !ok {
+		execution = &Execution{ID: id.ID, Stamp: id.Stamp}
+		tracker.byID[id.ID] = execution
+		tracker.executions = append(tracker.executions, execution)
+	}
+	return execution
+}
+
+// Add adds the message in record to the executions by the topic of record, messages of other
+// topics are ignored. Add has the signature of rosbag.MessageHandler, so it can be passed to
+// Decoder.Subscribe directly.
+func (tracker *Tracker) Add(record *rosbag.RecordMessageData) error {
+	t, err := record.Time()
+	if err != nil {
+		return err
+	}
+
+	switch record.ConnectionHeader().Topic {
+	case tracker.Namespace + "/goal":
+		var msg actionGoal
+		err = record.ViewAs(&msg)
+		if err != nil {
+			return err
+		}
+
+		execution := tracker.execution(msg.GoalID)
+		execution.Goal = t
+		execution.Stamp = msg.GoalID.Stamp
+	case tracker.Namespace + "/cancel":
+		var msg goalID
+		err = record.ViewAs(&msg)
+		if err != nil {
+			return err
+		}
+		tracker.cancel(t, msg)
+	case tracker.Namespace + "/status":
+		var msg goalStatusArray
+		err = record.ViewAs(&msg)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range msg.StatusList {
+			tracker.execution(status.GoalID).setStatus(t, status)
+		}
+	case tracker.Namespace + "/feedback":
+		var msg actionStatus
+		err = record.ViewAs(&msg)
+		if err != nil {
+			return err
+		}
+
+		execution := tracker.execution(msg.Status.GoalID)
+		execution.setStatus(t, msg.Status)
+		execution.Feedback++
+		execution.LastFeedback = t
+	case tracker.Namespace + "/result":
+		var msg actionStatus
+		err = record.ViewAs(&msg)
+		if err != nil {
+			return err
+		}
+
+		execution := tracker.execution(msg.Status.GoalID)
+		execution.setStatus(t, msg.Status)
+		execution.Result = t
+	}
+	return nil
+}
+
+// cancel marks the goals that are canceled by id like the action server does. An empty ID with a
+// zero stamp cancels every goal, and a stamp cancels every goal that's stamped at or before it.
+func (tracker *Tracker) cancel(t time.Time, id goalID) {
+	all := id.ID == "" && isZeroStamp(id.Stamp)
+	for _, execution := range tracker.executions {
+		if !execution.Canceled.IsZero() || execution.Status().Terminal() {
+			continue
+		}
+
+		stamped := !isZeroStamp(id.Stamp) && !execution.Stamp.After(id.Stamp)
+		if all || stamped || (id.ID != "" && execution.ID == id.ID) {
+			execution.Canceled = t
+		}
+	}
+}
+
+// isZeroStamp reports whether t is a zero ROS time, which is decoded as the Unix epoch.
+func isZeroStamp(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
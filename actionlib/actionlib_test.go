@@ -0,0 +1,157 @@
+package actionlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+const goalIDDefinition = `
+MSG: actionlib_msgs/GoalID
+time stamp
+string id
+`
+
+const goalStatusDefinition = `
+MSG: actionlib_msgs/GoalStatus
+actionlib_msgs/GoalID goal_id
+uint8 status
+string text
+` + goalIDDefinition
+
+func appendGoalID(b []byte, stamp int64, id string) []byte {
+	b = appendUint32(b, uint32(stamp))
+	b = appendUint32(b, 0)
+	b = appendUint32(b, uint32(len(id)))
+	return append(b, id...)
+}
+
+func appendGoalStatus(b []byte, stamp int64, id string, status Status, text string) []byte {
+	b = appendGoalID(b, stamp, id)
+	b = append(b, byte(status))
+	b = appendUint32(b, uint32(len(text)))
+	return append(b, text...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var raw [4]byte
+	binary.LittleEndian.PutUint32(raw[:], v)
+	return append(b, raw[:]...)
+}
+
+type goalStatusEntry struct {
+	stamp  int64
+	id     string
+	status Status
+}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	conn := func(topic, typ, def string) uint32 {
+		return builder.Connection("/move"+topic, typ, def)
+	}
+
+	goal := conn("/goal", "test_msgs/MoveActionGoal", "actionlib_msgs/GoalID goal_id\nfloat64 target\n"+goalIDDefinition)
+	cancel := conn("/cancel", "actionlib_msgs/GoalID", "time stamp\nstring id\n")
+	status := conn("/status", "actionlib_msgs/GoalStatusArray", "actionlib_msgs/GoalStatus[] status_list\n"+goalStatusDefinition)
+	feedback := conn("/feedback", "test_msgs/MoveActionFeedback", "actionlib_msgs/GoalStatus status\nfloat64 progress\n"+goalStatusDefinition)
+	result := conn("/result", "test_msgs/MoveActionResult", "actionlib_msgs/GoalStatus status\nfloat64 position\n"+goalStatusDefinition)
+	// an unrelated topic in the same namespace
+	other := conn("/other", "std_msgs/Empty", "")
+
+	write := func(conn uint32, sec int64, raw []byte) {
+		builder.Message(conn, time.Unix(sec, 0), raw)
+	}
+
+	writeStatus := func(sec int64, entries ...goalStatusEntry) {
+		raw := appendUint32(nil, uint32(len(entries)))
+		for _, entry := range entries {
+			raw = appendGoalStatus(raw, entry.stamp, entry.id, entry.status, "")
+		}
+		write(status, sec, raw)
+	}
+
+	progress := make([]byte, 8)
+	write(goal, 10, append(appendGoalID(nil, 9, "a"), progress...))
+	writeStatus(11, goalStatusEntry{9, "a", StatusActive})
+	write(feedback, 12, append(appendGoalStatus(nil, 9, "a", StatusActive, ""), progress...))
+	write(other, 12, nil)
+	write(goal, 13, append(appendGoalID(nil, 13, "b"), progress...))
+	write(feedback, 14, append(appendGoalStatus(nil, 9, "a", StatusActive, ""), progress...))
+	// the status of a is repeated, and b is accepted
+	writeStatus(15, goalStatusEntry{9, "a", StatusActive}, goalStatusEntry{13, "b", StatusActive})
+	write(result, 16, append(appendGoalStatus(nil, 9, "a", StatusSucceeded, "done"), progress...))
+	// cancels every goal that's stamped at or before 13, but a is already done
+	write(cancel, 17, appendGoalID(nil, 13, ""))
+	writeStatus(18, goalStatusEntry{13, "b", StatusPreempting})
+	write(result, 19, append(appendGoalStatus(nil, 13, "b", StatusPreempted, "canceled"), progress...))
+	return builder.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	raw := writeTestBag(t)
+	executions, err := Read(rosbag.NewDecoder(bytes.NewReader(raw)), "/move")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(sec int64) time.Time {
+		return time.Unix(sec, 0)
+	}
+
+	expected := []*Execution{
+		{
+			ID:    "a",
+			Stamp: at(9),
+			Goal:  at(10),
+			// the result is recorded before the cancel
+			Result: at(16),
+			Statuses: []StatusChange{
+				{Time: at(11), Status: StatusActive},
+				{Time: at(16), Status: StatusSucceeded},
+			},
+			Feedback:     2,
+			LastFeedback: at(14),
+			Text:         "done",
+		},
+		{
+			ID:       "b",
+			Stamp:    at(13),
+			Goal:     at(13),
+			Canceled: at(17),
+			Result:   at(19),
+			Statuses: []StatusChange{
+				{Time: at(15), Status: StatusActive},
+				{Time: at(18), Status: StatusPreempting},
+				{Time: at(19), Status: StatusPreempted},
+			},
+			Text: "canceled",
+		},
+	}
+	if diff := cmp.Diff(expected, executions); diff != "" {
+		t.Fatalf("executions are not matched:\n\n%s", diff)
+	}
+
+	if d := executions[0].Duration(); d != 6*time.Second {
+		t.Fatalf("expected a duration of 6s, but got %v", d)
+	}
+
+	if status := executions[1].Status(); status != StatusPreempted || !status.Terminal() {
+		t.Fatalf("expected a terminal PREEMPTED status, but got %v", status)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	if s := StatusRecalled.String(); s != "RECALLED" {
+		t.Fatalf("expected RECALLED, but got %s", s)
+	}
+
+	if s := Status(10).String(); s != "Status(10)" {
+		t.Fatalf("expected Status(10), but got %s", s)
+	}
+}
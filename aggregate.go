@@ -0,0 +1,191 @@
+package rosbag
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	errInvalidWindow     = errors.New("window size and step must be positive")
+	errInvalidPercentile = errors.New("percentiles must be between 0 and 100")
+)
+
+// AggregateOption configures an optional behavior of Series.Aggregate and ReadAggregates.
+type AggregateOption func(*aggregateConfig)
+
+type aggregateConfig struct {
+	step        time.Duration
+	percentiles []float64
+}
+
+// WithWindowStep makes the windows slide by step, so consecutive windows overlap when step is
+// smaller than the window size. The default is the window size, i.e. tumbling windows.
+func WithWindowStep(step time.Duration) AggregateOption {
+	return func(config *aggregateConfig) {
+		config.step = step
+	}
+}
+
+// WithPercentiles computes the given percentiles, between 0 and 100, of every window. The values
+// are interpolated linearly between the closest ranks.
+func WithPercentiles(percentiles ...float64) AggregateOption {
+	return func(config *aggregateConfig) {
+		config.percentiles = percentiles
+	}
+}
+
+// WindowStats are the statistics of the samples in [Start, End). NaN samples are ignored.
+type WindowStats struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Mean  float64   `json:"mean"`
+	// StdDev is the population standard deviation
+	StdDev float64 `json:"stddev"`
+	// Percentiles are in the same order as WithPercentiles
+	Percentiles []float64 `json:"percentiles,omitempty"`
+}
+
+// PathAggregates are the windows of a field path that is aggregated by ReadAggregates.
+type PathAggregates struct {
+	Path    string        `json:"path"`
+	Windows []WindowStats `json:"windows"`
+}
+
+// ReadAggregates subscribes to topic, runs decoder to the end, and aggregates the values of every
+// path over windows of size, see Series.Aggregate.
+func ReadAggregates(decoder *Decoder, topic string, paths []*FieldPath, size time.Duration, opts ...AggregateOption) ([]PathAggregates, error) {
+	// validate the options before reading the bag
+	if _, err := newAggregateConfig(size, opts); err != nil {
+		return nil, err
+	}
+
+	series, err := readSeries(decoder, topic, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]PathAggregates, len(paths))
+	for i, path := range paths {
+		windows, err := series[i].Aggregate(size, opts...)
+		if err != nil {
+			return nil, err
+		}
+		aggregates[i] = PathAggregates{Path: path.String(), Windows: windows}
+	}
+	return aggregates, nil
+}
+
+func newAggregateConfig(size time.Duration, opts []AggregateOption) (*aggregateConfig, error) {
+	config := aggregateConfig{
+		step: size,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if size <= 0 || config.step <= 0 {
+		return nil, errInvalidWindow
+	}
+
+	for _, p := range config.percentiles {
+		if !(p >= 0 && p <= 100) {
+			return nil, errInvalidPercentile
+		}
+	}
+	return &config, nil
+}
+
+// Aggregate computes the statistics of the samples in windows of size. The first window starts
+// at the first sample, and the next ones start every step, see WithWindowStep. Windows without
+// samples are left out, so the result stays compact over gaps in the recording.
+func (series *Series) Aggregate(size time.Duration, opts ...AggregateOption) ([]WindowStats, error) {
+	config, err := newAggregateConfig(size, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	n := series.Len()
+	if n == 0 {
+		return nil, nil
+	}
+
+	var windows []WindowStats
+	var scratch []float64
+	first, last := series.Time[0], series.Time[n-1]
+	for k := 0; ; k++ {
+		start := first.Add(time.Duration(k) * config.step)
+		if start.After(last) {
+			return windows, nil
+		}
+
+		end := start.Add(size)
+		i := sort.Search(n, func(i int) bool { return !series.Time[i].Before(start) })
+		j := sort.Search(n, func(j int) bool { return !series.Time[j].Before(end) })
+
+		scratch = scratch[:0]
+		for _, v := range series.Values[i:j] {
+			if !math.IsNaN(v) {
+				scratch = append(scratch, v)
+			}
+		}
+
+		if len(scratch) == 0 {
+			if j == n {
+				return windows, nil
+			}
+
+			// skip the empty windows to the first window that ends after the next sample
+			next := int((series.Time[j].Sub(first)-size)/config.step) + 1
+			if next > k+1 {
+				k = next - 1
+			}
+			continue
+		}
+
+		windows = append(windows, windowStats(start, end, scratch, config.percentiles))
+	}
+}
+
+// windowStats computes the statistics of values. values is sorted when percentiles are requested.
+func windowStats(start, end time.Time, values []float64, percentiles []float64) WindowStats {
+	stats := WindowStats{
+		Start: start,
+		End:   end,
+		Count: len(values),
+		Min:   math.Inf(1),
+		Max:   math.Inf(-1),
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+	}
+	stats.Mean = sum / float64(len(values))
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(squares / float64(len(values)))
+
+	if len(percentiles) > 0 {
+		sort.Float64s(values)
+		stats.Percentiles = make([]float64, len(percentiles))
+		for i, p := range percentiles {
+			rank := p / 100 * float64(len(values)-1)
+			lo := int(math.Floor(rank))
+			hi := int(math.Ceil(rank))
+			stats.Percentiles[i] = values[lo] + (values[hi]-values[lo])*(rank-float64(lo))
+		}
+	}
+
+	return stats
+}
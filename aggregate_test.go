@@ -0,0 +1,82 @@
+package rosbag
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSeriesAggregate(t *testing.T) {
+	t0 := time.Unix(10, 0)
+	series := Series{}
+	for i, v := range []float64{1, 2, 3, 4, math.NaN(), 6} {
+		series.Time = append(series.Time, t0.Add(time.Duration(i)*time.Second))
+		series.Values = append(series.Values, v)
+	}
+	// a gap of 2 empty windows
+	series.Time = append(series.Time, t0.Add(10*time.Second))
+	series.Values = append(series.Values, 10)
+
+	windows, err := series.Aggregate(2*time.Second, WithPercentiles(0, 50, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []WindowStats{
+		{Start: t0, End: t0.Add(2 * time.Second), Count: 2, Min: 1, Max: 2, Mean: 1.5, StdDev: 0.5, Percentiles: []float64{1, 1.5, 2}},
+		{Start: t0.Add(2 * time.Second), End: t0.Add(4 * time.Second), Count: 2, Min: 3, Max: 4, Mean: 3.5, StdDev: 0.5, Percentiles: []float64{3, 3.5, 4}},
+		{Start: t0.Add(4 * time.Second), End: t0.Add(6 * time.Second), Count: 1, Min: 6, Max: 6, Mean: 6, Percentiles: []float64{6, 6, 6}},
+		{Start: t0.Add(10 * time.Second), End: t0.Add(12 * time.Second), Count: 1, Min: 10, Max: 10, Mean: 10, Percentiles: []float64{10, 10, 10}},
+	}
+	if diff := cmp.Diff(expected, windows); diff != "" {
+		t.Fatalf("tumbling windows are not matched:\n\n%s", diff)
+	}
+
+	windows, err = series.Aggregate(3*time.Second, WithWindowStep(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var starts []float64
+	var means []float64
+	for _, window := range windows {
+		starts = append(starts, window.Start.Sub(t0).Seconds())
+		means = append(means, window.Mean)
+	}
+
+	if diff := cmp.Diff([]float64{0, 1, 2, 3, 4, 5, 8, 9, 10}, starts); diff != "" {
+		t.Fatalf("sliding window starts are not matched:\n\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]float64{2, 3, 3.5, 5, 6, 6, 10, 10, 10}, means, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Fatalf("sliding window means are not matched:\n\n%s", diff)
+	}
+
+	if _, err = series.Aggregate(0); err != errInvalidWindow {
+		t.Fatalf("expected %v, but got %v", errInvalidWindow, err)
+	}
+
+	if _, err = series.Aggregate(time.Second, WithPercentiles(101)); err != errInvalidPercentile {
+		t.Fatalf("expected %v, but got %v", errInvalidPercentile, err)
+	}
+}
+
+func TestReadAggregates(t *testing.T) {
+	paths := []*FieldPath{MustCompileFieldPath("header.seq"), MustCompileFieldPath("points[0].y")}
+	aggregates, err := ReadAggregates(NewDecoder(bytes.NewReader(writePlanTestBag(t))), "/plan", paths, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aggregates) != 2 || aggregates[0].Path != "header.seq" || len(aggregates[0].Windows) != 1 {
+		t.Fatalf("expected a window for each path, but got %+v", aggregates)
+	}
+
+	if window := aggregates[0].Windows[0]; window.Count != 3 || window.Mean != 1 || !window.Start.Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected 3 samples from 1s, but got %+v", window)
+	}
+}
@@ -0,0 +1,60 @@
+package rosbag
+
+// AliasSemantics selects the builtin types that the deprecated byte and char aliases are decoded
+// as. The declared type of a field is kept in MessageFieldDefinition.DeclaredType.
+type AliasSemantics uint8
+
+const (
+	// AliasSemanticsROS1 decodes byte as int8, and char as uint8, as they're defined in ROS1,
+	// http://wiki.ros.org/msg#Fields
+	AliasSemanticsROS1 AliasSemantics = iota
+	// AliasSemanticsUnsigned decodes both byte and char as uint8, like ROS2 and most of the code
+	// that treats byte arrays as raw data expects
+	AliasSemanticsUnsigned
+)
+
+// apply changes the types of the byte fields of def and its nested definitions. Definitions are
+// parsed with the ROS1 semantics, so there's nothing to change for AliasSemanticsROS1.
+func (semantics AliasSemantics) apply(def *MessageDefinition) {
+	if semantics != AliasSemanticsUnsigned {
+		return
+	}
+
+	seen := make(map[*MessageDefinition]bool)
+	var walk func(def *MessageDefinition)
+	walk = func(def *MessageDefinition) {
+		if seen[def] {
+			return
+		}
+		seen[def] = true
+
+		for _, field := range def.Fields {
+			if field.Type == MessageFieldTypeComplex && field.MsgType != nil {
+				walk(field.MsgType)
+				continue
+			}
+
+			if field.DeclaredType == "byte" {
+				field.Type = MessageFieldTypeUint8
+				field.Default = toUnsignedByte(field.Default)
+			}
+		}
+	}
+	walk(def)
+}
+
+// toUnsignedByte converts the int8 default value of a byte field to uint8.
+func toUnsignedByte(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8:
+		return uint8(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = toUnsignedByte(v[i])
+		}
+		return values
+	default:
+		return v
+	}
+}
@@ -0,0 +1,95 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecoderAliasSemantics(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/aliases",
+		Type:                 "test_msgs/Aliases",
+		RawMessageDefinition: "byte b\nchar c\nbyte[] data",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte{0xff, 0xfe}, uint32Bytes(2)...)
+	data = append(data, 0x80, 1)
+	err = writer.WriteMessage(conn, time.Unix(1, 0), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name      string
+		Semantics AliasSemantics
+		Expected  map[string]interface{}
+	}{
+		{
+			Name:      "ROS1",
+			Semantics: AliasSemanticsROS1,
+			Expected: map[string]interface{}{
+				"b":    int8(-1),
+				"c":    uint8(0xfe),
+				"data": []int8{-128, 1},
+			},
+		},
+		{
+			Name:      "Unsigned",
+			Semantics: AliasSemanticsUnsigned,
+			Expected: map[string]interface{}{
+				"b":    uint8(0xff),
+				"c":    uint8(0xfe),
+				"data": []uint8{0x80, 1},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			decoder := NewDecoder(bytes.NewReader(buf.Bytes()), WithAliasSemantics(testCase.Semantics))
+			for {
+				record, err := decoder.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				msg, ok := record.(*RecordMessageData)
+				if !ok {
+					record.Close()
+					continue
+				}
+				defer msg.Close()
+
+				actual := make(map[string]interface{})
+				err = msg.ViewAs(actual)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(testCase.Expected, actual); diff != "" {
+					t.Fatal(diff)
+				}
+
+				field := msg.ConnectionHeader().MessageDefinition.Fields[0]
+				if field.DeclaredType != "byte" {
+					t.Fatalf("expected the declared type to be byte, but got %s", field.DeclaredType)
+				}
+				return
+			}
+		})
+	}
+}
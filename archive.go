@@ -0,0 +1,350 @@
+package rosbag
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ArchiveExt is the extension of the archived bags that reference the chunks in a ChunkStore,
+// e.g. example.bag.archive.json.
+const ArchiveExt = ".archive.json"
+
+var (
+	errArchiveDigest = errors.New("restored bag doesn't match the sha256 of the archive")
+	errNegativeSeek  = errors.New("seek to a negative offset")
+)
+
+// ChunkStore stores the chunk records of archived bags by the lowercase hex SHA-256 digest of
+// their bytes, so a chunk that is repeated across bags, e.g. a map, a latched topic, or a
+// calibration, is only stored once.
+type ChunkStore interface {
+	// Has reports whether the chunk with digest is stored.
+	Has(digest string) (bool, error)
+	// Put stores the chunk with digest. Put is only called for chunks that are not stored yet.
+	Put(digest string, chunk []byte) error
+	// Get returns the chunk with digest.
+	Get(digest string) ([]byte, error)
+}
+
+// DirChunkStore is a ChunkStore in a directory. Every chunk is a file at <dir>/<digest[:2]>/<digest>,
+// so that no directory gets too large.
+type DirChunkStore struct {
+	dir string
+}
+
+// NewDirChunkStore creates a store in dir. The directory is created by the first Put.
+func NewDirChunkStore(dir string) *DirChunkStore {
+	return &DirChunkStore{dir: dir}
+}
+
+func (store *DirChunkStore) path(digest string) string {
+	if len(digest) < 2 {
+		return filepath.Join(store.dir, digest)
+	}
+	return filepath.Join(store.dir, digest[:2], digest)
+}
+
+// Has reports whether the file of the chunk exists.
+func (store *DirChunkStore) Has(digest string) (bool, error) {
+	_, err := os.Stat(store.path(digest))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put writes the chunk to a temporary file, and renames it, so a partially written chunk is
+// never found by Has.
+func (store *DirChunkStore) Put(digest string, chunk []byte) error {
+	path := store.path(digest)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), digest+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(chunk)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get reads the file of the chunk.
+func (store *DirChunkStore) Get(digest string) ([]byte, error) {
+	return ioutil.ReadFile(store.path(digest))
+}
+
+// ArchivedBag is a bag whose chunks are stored in a ChunkStore. It only has the records between
+// the chunks, e.g. the bag header, the index data, and the chunk infos, and the digests of the
+// chunks, so it's a small fraction of the bag. It only has plain fields, so it can be stored with
+// encoding/json, see ArchiveExt.
+type ArchivedBag struct {
+	// Size and SHA256 are the size and the lowercase hex SHA-256 digest of the whole bag
+	Size   int64         `json:"size"`
+	SHA256 string        `json:"sha256"`
+	Parts  []ArchivePart `json:"parts"`
+}
+
+// ArchivePart is a part of an archived bag, which is either the bytes of the records between two
+// chunks, or a chunk record in the store.
+type ArchivePart struct {
+	Data []byte `json:"data,omitempty"`
+	// Chunk is the digest of the chunk record in the store, and Length is its size
+	Chunk  string `json:"chunk,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+func (part *ArchivePart) size() int64 {
+	if part.Chunk != "" {
+		return part.Length
+	}
+	return int64(len(part.Data))
+}
+
+// Archive reads the whole bag from r, stores the chunks that are not in store yet, and returns the
+// archived bag that references them. The bag can be restored byte for byte with
+// ArchivedBag.Restore, or read in place with ArchivedBag.NewReader.
+func Archive(r io.Reader, store ChunkStore) (*ArchivedBag, error) {
+	whole := sha256.New()
+	counter := &countingWriter{}
+	br := bufio.NewReader(io.TeeReader(r, io.MultiWriter(whole, counter)))
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	var version Version
+	_, err = fmt.Sscanf(string(line), versionFormat, &version.Major, &version.Minor)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != supportedVersion {
+		return nil, &UnsupportedVersionError{Version: version}
+	}
+
+	var bag ArchivedBag
+	data := line
+	for {
+		record, op, err := readRawRecord(br)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if op != OpChunk {
+			data = append(data, record.Raw...)
+			continue
+		}
+
+		if len(data) > 0 {
+			bag.Parts = append(bag.Parts, ArchivePart{Data: data})
+			data = nil
+		}
+
+		sum := sha256.Sum256(record.Raw)
+		digest := hex.EncodeToString(sum[:])
+		stored, err := store.Has(digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if !stored {
+			err = store.Put(digest, record.Raw)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bag.Parts = append(bag.Parts, ArchivePart{Chunk: digest, Length: int64(len(record.Raw))})
+	}
+
+	if len(data) > 0 {
+		bag.Parts = append(bag.Parts, ArchivePart{Data: data})
+	}
+
+	bag.Size = counter.n
+	bag.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	return &bag, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return len(b), nil
+}
+
+// Restore writes the bag to w with the chunks from store, and verifies the SHA-256 of the whole
+// bag.
+func (bag *ArchivedBag) Restore(w io.Writer, store ChunkStore) error {
+	whole := sha256.New()
+	w = io.MultiWriter(w, whole)
+	for i := range bag.Parts {
+		data, err := bag.partData(i, store)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hex.EncodeToString(whole.Sum(nil)) != bag.SHA256 {
+		return errArchiveDigest
+	}
+	return nil
+}
+
+// partData returns the bytes of the ith part, the chunks are verified against their digests.
+func (bag *ArchivedBag) partData(i int, store ChunkStore) ([]byte, error) {
+	part := &bag.Parts[i]
+	if part.Chunk == "" {
+		return part.Data, nil
+	}
+
+	chunk, err := store.Get(part.Chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != part.Chunk || int64(len(chunk)) != part.Length {
+		return nil, fmt.Errorf("chunk %s in the store is corrupted", part.Chunk)
+	}
+	return chunk, nil
+}
+
+// ArchiveReader reads an archived bag as if it was the original bag, so it can be passed to
+// NewDecoder, Slice, or Partition without restoring the bag first. The chunks are fetched from the
+// store when they're read, and verified against their digests.
+type ArchiveReader struct {
+	bag   *ArchivedBag
+	store ChunkStore
+	// offsets are the offsets of the parts in the bag
+	offsets []int64
+	offset  int64
+	// cached is the index of the part in cachedData, the last chunk that has been fetched. ReadAt
+	// can be called concurrently, so the cache is guarded by mu
+	mu         sync.Mutex
+	cached     int
+	cachedData []byte
+}
+
+// NewReader creates a reader of the bag with the chunks from store.
+func (bag *ArchivedBag) NewReader(store ChunkStore) *ArchiveReader {
+	offsets := make([]int64, len(bag.Parts))
+	var offset int64
+	for i := range bag.Parts {
+		offsets[i] = offset
+		offset += bag.Parts[i].size()
+	}
+
+	return &ArchiveReader{
+		bag:     bag,
+		store:   store,
+		offsets: offsets,
+		cached:  -1,
+	}
+}
+
+// Size returns the size of the bag.
+func (reader *ArchiveReader) Size() int64 {
+	return reader.bag.Size
+}
+
+// Read reads the bag from the current offset.
+func (reader *ArchiveReader) Read(b []byte) (int, error) {
+	n, err := reader.ReadAt(b, reader.offset)
+	reader.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads the bag at off. Only the chunks that overlap b are fetched.
+func (reader *ArchiveReader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeSeek
+	}
+
+	var n int
+	for n < len(b) {
+		pos := off + int64(n)
+		i := sort.Search(len(reader.offsets), func(i int) bool {
+			return reader.offsets[i] > pos
+		}) - 1
+		if i < 0 || pos >= reader.offsets[i]+reader.bag.Parts[i].size() {
+			return n, io.EOF
+		}
+
+		data, err := reader.part(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(b[n:], data[pos-reader.offsets[i]:])
+	}
+	return n, nil
+}
+
+func (reader *ArchiveReader) part(i int) ([]byte, error) {
+	if reader.bag.Parts[i].Chunk == "" {
+		return reader.bag.Parts[i].Data, nil
+	}
+
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	if reader.cached != i {
+		data, err := reader.bag.partData(i, reader.store)
+		if err != nil {
+			return nil, err
+		}
+		reader.cached, reader.cachedData = i, data
+	}
+	return reader.cachedData, nil
+}
+
+// Seek sets the offset of the next Read, see io.Seeker.
+func (reader *ArchiveReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.bag.Size
+	}
+
+	if offset < 0 {
+		return 0, errNegativeSeek
+	}
+	reader.offset = offset
+	return offset, nil
+}
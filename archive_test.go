@@ -0,0 +1,144 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingChunkStore counts the chunks that are put into the store.
+type countingChunkStore struct {
+	*DirChunkStore
+	puts int
+}
+
+func (store *countingChunkStore) Put(digest string, chunk []byte) error {
+	store.puts++
+	return store.DirChunkStore.Put(digest, chunk)
+}
+
+func TestArchive(t *testing.T) {
+	shared := []indexedTestMessage{{0, 1}, {1, 2}}
+	bags := [][]byte{
+		writeIndexedTestBag(t, shared, []indexedTestMessage{{0, 3}}),
+		writeIndexedTestBag(t, shared, []indexedTestMessage{{1, 4}, {0, 5}}),
+	}
+
+	dir := t.TempDir()
+	store := &countingChunkStore{DirChunkStore: NewDirChunkStore(dir)}
+	var archived []*ArchivedBag
+	for _, raw := range bags {
+		bag, err := Archive(bytes.NewReader(raw), store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bag.Size != int64(len(raw)) {
+			t.Fatalf("expected the size to be %d, but got %d", len(raw), bag.Size)
+		}
+		archived = append(archived, bag)
+	}
+
+	// the first chunk is shared by both bags
+	if store.puts != 3 {
+		t.Fatalf("expected 3 chunks to be stored, but got %d", store.puts)
+	}
+
+	for i, bag := range archived {
+		var restored bytes.Buffer
+		err := bag.Restore(&restored, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(restored.Bytes(), bags[i]) {
+			t.Fatalf("expected bag %d to be restored byte for byte", i)
+		}
+
+		reader := bag.NewReader(store)
+		read, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(read, bags[i]) {
+			t.Fatalf("expected bag %d to be read byte for byte", i)
+		}
+
+		for _, off := range []int64{0, 10, bag.Size - 7} {
+			b := make([]byte, 7)
+			_, err := reader.ReadAt(b, off)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, bags[i][off:off+7]) {
+				t.Fatalf("expected bag %d to be read at %d", i, off)
+			}
+		}
+	}
+
+	slice, err := Slice(archived[1].NewReader(store), time.Unix(2, 0), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []indexedTestMessage{{1, 2}, {1, 4}, {0, 5}}
+	if diff := cmp.Diff(expected, readIndexedTestMessages(t, slice.NewDecoder())); diff != "" {
+		t.Fatalf("messages of the archived bag are not matched:\n\n%s", diff)
+	}
+}
+
+func TestArchiveCorruptedStore(t *testing.T) {
+	raw := writeIndexedTestBag(t, []indexedTestMessage{{0, 1}})
+	store := NewDirChunkStore(t.TempDir())
+	bag, err := Archive(bytes.NewReader(raw), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var digest string
+	for _, part := range bag.Parts {
+		if part.Chunk != "" {
+			digest = part.Chunk
+		}
+	}
+
+	path := filepath.Join(store.dir, digest[:2], digest)
+	chunk, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk[len(chunk)-1] ^= 0xff
+	err = ioutil.WriteFile(path, chunk, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bag.Restore(ioutil.Discard, store)
+	if err == nil {
+		t.Fatal("expected a corrupted chunk to fail the restore")
+	}
+
+	_, err = ioutil.ReadAll(bag.NewReader(store))
+	if err == nil || err == io.EOF {
+		t.Fatalf("expected a corrupted chunk to fail the reader, but got %v", err)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bag.Restore(ioutil.Discard, store)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a missing chunk to fail the restore, but got %v", err)
+	}
+}
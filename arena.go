@@ -0,0 +1,93 @@
+package rosbag
+
+const (
+	defaultArenaChunkSize = 64 * 1024
+)
+
+// Arena holds the memory of the messages that are decoded by RecordMessageData.ViewAsArena. The
+// message data is copied to the arena once, and the strings and the arrays of the decoded message
+// are views of the copy, while the nested maps are reused from the previous messages. This gives
+// the decoded messages the lifetime of the arena instead of the lifetime of the record at the
+// cost of a single copy, which suits batch pipelines that decode a batch, process it, and then
+// release it in one call.
+//
+// An Arena is not safe for concurrent use.
+type Arena struct {
+	chunkSize int
+	chunks    [][]byte
+	// chunk is the index of the chunk that the next allocation is made from
+	chunk int
+	off   int
+	maps  []map[string]interface{}
+	// usedMaps is the number of maps from maps that have been handed out
+	usedMaps int
+}
+
+// NewArena creates an empty arena. The memory of the arena grows in chunks of chunkSize bytes, or
+// 64 KB when chunkSize is not positive. Larger messages get a chunk of their own.
+func NewArena(chunkSize int) *Arena {
+	if chunkSize <= 0 {
+		chunkSize = defaultArenaChunkSize
+	}
+	return &Arena{chunkSize: chunkSize}
+}
+
+// Release makes all of the memory of the arena available for reuse. Every message that has been
+// decoded with the arena MUST NOT be used after Release, since its strings, arrays, and maps are
+// overwritten by the following messages.
+func (arena *Arena) Release() {
+	arena.chunk = 0
+	arena.off = 0
+
+	for _, m := range arena.maps[:arena.usedMaps] {
+		for k := range m {
+			delete(m, k)
+		}
+	}
+	arena.usedMaps = 0
+}
+
+// Size returns the number of bytes that the arena holds, including the memory that is available
+// for reuse.
+func (arena *Arena) Size() int {
+	size := 0
+	for _, chunk := range arena.chunks {
+		size += len(chunk)
+	}
+	return size
+}
+
+// copy returns a copy of b that is allocated from the arena.
+func (arena *Arena) copy(b []byte) []byte {
+	n := len(b)
+	for ; arena.chunk < len(arena.chunks); arena.chunk++ {
+		if chunk := arena.chunks[arena.chunk]; len(chunk)-arena.off >= n {
+			dst := chunk[arena.off : arena.off+n : arena.off+n]
+			arena.off += n
+			copy(dst, b)
+			return dst
+		}
+		arena.off = 0
+	}
+
+	size := arena.chunkSize
+	if n > size {
+		size = n
+	}
+	arena.chunks = append(arena.chunks, make([]byte, size))
+	return arena.copy(b)
+}
+
+// newMap returns an empty map that is reused after Release.
+func (arena *Arena) newMap() map[string]interface{} {
+	if arena == nil {
+		return make(map[string]interface{})
+	}
+
+	if arena.usedMaps == len(arena.maps) {
+		arena.maps = append(arena.maps, make(map[string]interface{}))
+	}
+	m := arena.maps[arena.usedMaps]
+	arena.usedMaps++
+	return m
+}
@@ -0,0 +1,104 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestViewAsArena(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, WithChunkSize(1))
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/header",
+		Type:                 "test_msgs/Stamped",
+		RawMessageDefinition: testHeaderMessageDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frameIDs := []string{"base_link", "map", "odom"}
+	for i, frameID := range frameIDs {
+		data := uint32Bytes(uint32(i))
+		data = append(data, timeField(time.Unix(int64(i), 0))...)
+		data = append(data, encodeTestString(frameID)...)
+		data = append(data, uint8(i))
+		err = writer.WriteMessage(conn, time.Unix(int64(i+1), 0), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunks of the arena are smaller than the messages
+	arena := NewArena(8)
+	decode := func() []map[string]interface{} {
+		var messages []map[string]interface{}
+		decoder := NewDecoder(bytes.NewReader(buf.Bytes()))
+		for {
+			record, err := decoder.Read()
+			if err == io.EOF {
+				return messages
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg, ok := record.(*RecordMessageData); ok {
+				v := make(map[string]interface{})
+				err = msg.ViewAsArena(v, arena)
+				if err != nil {
+					t.Fatal(err)
+				}
+				messages = append(messages, v)
+			}
+
+			// the messages must outlive the records
+			record.Close()
+		}
+	}
+
+	messages := decode()
+	var expected []map[string]interface{}
+	for i, frameID := range frameIDs {
+		expected = append(expected, map[string]interface{}{
+			"header": map[string]interface{}{
+				"seq":      uint32(i),
+				"stamp":    time.Unix(int64(i), 0),
+				"frame_id": frameID,
+			},
+			"x": uint8(i),
+		})
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Fatal(diff)
+	}
+
+	size := arena.Size()
+	header := reflect.ValueOf(messages[0]["header"]).Pointer()
+	arena.Release()
+
+	messages = decode()
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if arena.Size() != size {
+		t.Fatalf("expected the arena to reuse its %d bytes, but it has %d bytes", size, arena.Size())
+	}
+
+	if reflect.ValueOf(messages[0]["header"]).Pointer() != header {
+		t.Fatal("expected the nested maps to be reused")
+	}
+}
@@ -0,0 +1,787 @@
+package rosbag
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bag is a bag that's read with random access. The index section is parsed once by Open, and the
+// chunks are only read when their messages are, so a message in the middle of a large bag can be
+// read without reading the bag up to it. A Bag is safe for concurrent use.
+type Bag struct {
+	r     io.ReaderAt
+	size  int64
+	conns map[uint32]*ConnectionHeader
+	// chunks are sorted by their positions
+	chunks []workChunk
+	// plans are the plan caches of the connections for Decode
+	plans map[uint32]*planCache
+	// entries are the entries of every chunk when the bag doesn't have an index section, and they
+	// have been found by scanning the chunks
+	entries [][]MessageEntry
+
+	mu sync.Mutex
+	// chunkCache has the decompressed data of the recently read chunks
+	chunkCache *chunkCache
+}
+
+// BagOption configures an optional behavior of a Bag.
+type BagOption func(*bagConfig)
+
+type bagConfig struct {
+	chunkCacheSize int64
+	err            error
+}
+
+// WithChunkCache sets the total size in bytes of the decompressed chunks that a Bag keeps, so
+// reading back and forth in time, e.g. scrubbing through a camera topic, doesn't decompress the
+// same chunks again. The least recently used chunks are evicted first, and the last read chunk is
+// always kept. The default is 64 MiB.
+func WithChunkCache(size int64) BagOption {
+	return func(config *bagConfig) {
+		if size < 0 {
+			config.err = fmt.Errorf("chunk cache size must not be negative, but got %d", size)
+			return
+		}
+		config.chunkCacheSize = size
+	}
+}
+
+// BagChunk describes a chunk of a Bag from its chunk info record.
+type BagChunk struct {
+	// Offset is the position of the chunk record in the bag, and Length is the length of the
+	// chunk record
+	Offset int64
+	Length int64
+	// StartTime and EndTime are the earliest and the latest record times of the messages
+	StartTime time.Time
+	EndTime   time.Time
+	// MessageCounts are the numbers of messages of every connection in the chunk
+	MessageCounts map[uint32]uint32
+}
+
+// MessageEntry locates a message of a Bag from the index data records.
+type MessageEntry struct {
+	Conn uint32
+	Time time.Time
+	// Chunk is the index of the chunk of the message in Bag.Chunks, and Offset is the offset of the
+	// message data record in the decompressed chunk data
+	Chunk  int
+	Offset uint32
+}
+
+// Open parses the index section of the bag in r, which has size bytes, and returns a Bag that reads
+// the chunks and the messages of the bag with random access. When the bag doesn't have an index
+// section, e.g. the bag of a recording that was interrupted, every chunk is read and indexed in
+// memory instead, and the bag ends at the last complete chunk.
+func Open(r io.ReaderAt, size int64, opts ...BagOption) (*Bag, error) {
+	config := bagConfig{chunkCacheSize: defaultChunkCacheSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.err != nil {
+		return nil, config.err
+	}
+
+	section := io.NewSectionReader(r, 0, size)
+	decoder := NewDecoder(section)
+	err := decoder.Preload()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoder.chunkInfos) == 0 {
+		return scanBag(r, size, &config)
+	}
+
+	chunks, err := locateWorkChunks(section, 0, decoder.chunkInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bag{
+		r:          r,
+		size:       size,
+		conns:      decoder.conns,
+		chunks:     chunks,
+		plans:      newPlanCaches(decoder.conns),
+		chunkCache: newChunkCache(config.chunkCacheSize),
+	}, nil
+}
+
+func newPlanCaches(conns map[uint32]*ConnectionHeader) map[uint32]*planCache {
+	plans := make(map[uint32]*planCache, len(conns))
+	for conn, hdr := range conns {
+		plans[conn] = newPlanCache(&hdr.MessageDefinition)
+	}
+	return plans
+}
+
+// Chunks returns the chunks of the bag in the order of their positions.
+func (bag *Bag) Chunks() []BagChunk {
+	chunks := make([]BagChunk, len(bag.chunks))
+	for i, chunk := range bag.chunks {
+		chunks[i] = BagChunk{
+			Offset:        chunk.pos,
+			Length:        chunk.length,
+			StartTime:     chunk.startTime,
+			EndTime:       chunk.endTime,
+			MessageCounts: chunk.counts,
+		}
+	}
+	return chunks
+}
+
+// Connections returns the connection headers of the bag by their connection IDs. The headers are
+// shared with the bag, so they must not be modified.
+func (bag *Bag) Connections() map[uint32]*ConnectionHeader {
+	conns := make(map[uint32]*ConnectionHeader, len(bag.conns))
+	for conn, hdr := range bag.conns {
+		conns[conn] = hdr
+	}
+	return conns
+}
+
+// MessageCounts returns the number of messages on every topic from the message counts of the chunk
+// infos, so the chunks aren't read. The topics of the connections without messages have a count
+// of 0.
+func (bag *Bag) MessageCounts() map[string]uint64 {
+	counts := make(map[string]uint64, len(bag.conns))
+	for _, hdr := range bag.conns {
+		counts[hdr.Topic] = 0
+	}
+
+	for _, chunk := range bag.chunks {
+		for conn, count := range chunk.counts {
+			if hdr, ok := bag.conns[conn]; ok {
+				counts[hdr.Topic] += uint64(count)
+			}
+		}
+	}
+	return counts
+}
+
+// scanBag reads every chunk of the bag in r, which doesn't have an index section, to index the
+// chunks in memory. The records after the last complete record are ignored.
+func scanBag(r io.ReaderAt, size int64, config *bagConfig) (*Bag, error) {
+	bag := Bag{
+		r:          r,
+		size:       size,
+		conns:      make(map[uint32]*ConnectionHeader),
+		chunkCache: newChunkCache(config.chunkCacheSize),
+	}
+
+	offset := int64(len(fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor)))
+	br := bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	for {
+		record, op, err := readRawRecord(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case OpChunk:
+			_, index, conns, err := indexChunk(&RecordChunk{RecordBase: record}, offset)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, conn := range conns {
+				err = bag.addConnection(conn)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			var entries []MessageEntry
+			for _, conn := range index.conns {
+				entries = appendEntries(entries, conn, index.entries[conn], len(bag.chunks))
+			}
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].Offset < entries[j].Offset
+			})
+
+			bag.entries = append(bag.entries, entries)
+			bag.chunks = append(bag.chunks, workChunk{
+				pos:       offset,
+				length:    int64(len(record.Raw)),
+				startTime: index.start,
+				endTime:   index.end,
+				counts:    index.counts(),
+			})
+		case OpConnection:
+			err = bag.addConnection(record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		offset += int64(len(record.Raw))
+	}
+	bag.plans = newPlanCaches(bag.conns)
+	return &bag, nil
+}
+
+func (bag *Bag) addConnection(record *RecordBase) error {
+	connRecord := RecordConnection{RecordBase: record}
+	conn, err := connRecord.Conn()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := bag.conns[conn]; ok {
+		return nil
+	}
+
+	hdr, err := connRecord.ConnectionHeader()
+	if err != nil {
+		return err
+	}
+
+	// topic is optional in the connection header, but it's required in the record header
+	if hdr.Topic == "" {
+		hdr.Topic, err = connRecord.Topic()
+		if err != nil {
+			return err
+		}
+	}
+	bag.conns[conn] = hdr
+	return nil
+}
+
+// appendEntries appends the entries of the index data of conn in the ith chunk to entries.
+func appendEntries(entries []MessageEntry, conn uint32, data []byte, i int) []MessageEntry {
+	for ; len(data) >= indexEntrySize; data = data[indexEntrySize:] {
+		entries = append(entries, MessageEntry{
+			Conn:   conn,
+			Time:   extractTime(data),
+			Chunk:  i,
+			Offset: endian.Uint32(data[8:]),
+		})
+	}
+	return entries
+}
+
+// StartTime returns the earliest record time of the messages in the bag from the chunk infos. It's
+// zero when the bag doesn't have messages.
+func (bag *Bag) StartTime() time.Time {
+	var start time.Time
+	for _, chunk := range bag.chunks {
+		if len(chunk.counts) > 0 && (start.IsZero() || chunk.startTime.Before(start)) {
+			start = chunk.startTime
+		}
+	}
+	return start
+}
+
+// EndTime returns the latest record time of the messages in the bag from the chunk infos. It's zero
+// when the bag doesn't have messages.
+func (bag *Bag) EndTime() time.Time {
+	var end time.Time
+	for _, chunk := range bag.chunks {
+		if len(chunk.counts) > 0 && chunk.endTime.After(end) {
+			end = chunk.endTime
+		}
+	}
+	return end
+}
+
+// Duration returns the time between the first and the last messages of the bag.
+func (bag *Bag) Duration() time.Duration {
+	return bag.EndTime().Sub(bag.StartTime())
+}
+
+// ChunkEntries returns the entries of the messages in the ith chunk from its index data records,
+// which follow the chunk. The chunk itself is not read. The entries are in the order of their
+// offsets in the chunk.
+func (bag *Bag) ChunkEntries(i int) ([]MessageEntry, error) {
+	if i < 0 || i >= len(bag.chunks) {
+		return nil, fmt.Errorf("chunk %d is out of range, the bag has %d chunks", i, len(bag.chunks))
+	}
+
+	if bag.entries != nil {
+		return append([]MessageEntry(nil), bag.entries[i]...), nil
+	}
+
+	chunk := bag.chunks[i]
+	indexPos := chunk.pos + chunk.length
+	// the records are small, and they're read without a buffer, so that the next chunk isn't read
+	r := io.NewSectionReader(bag.r, indexPos, bag.size-indexPos)
+	var entries []MessageEntry
+	// there is an index data record for every connection in the chunk
+	for range chunk.counts {
+		record, op, err := readRawRecord(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if op != OpIndexData {
+			return nil, fmt.Errorf("expected an index data record after the chunk at %d, but got op %d", chunk.pos, op)
+		}
+
+		conn, err := (&RecordIndexData{RecordBase: record}).Conn()
+		if err != nil {
+			return nil, err
+		}
+
+		data := record.Data()
+		if len(data)%indexEntrySize != 0 {
+			return nil, fmt.Errorf("index data of connection %d has an invalid length of %d", conn, len(data))
+		}
+
+		entries = appendEntries(entries, conn, data, i)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+	return entries, nil
+}
+
+// Entries returns the entries of every message in the bag sorted by their record times. The
+// messages with the same time are in the order of the bag. Only the index data records are read.
+func (bag *Bag) Entries() ([]MessageEntry, error) {
+	var entries []MessageEntry
+	for i := range bag.chunks {
+		chunkEntries, err := bag.ChunkEntries(i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, chunkEntries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// ReadMessage reads the message of entry. Only the chunk of the message is read and decompressed,
+// and it's kept in the chunk cache of the bag, which keeps the least recently used chunks up to
+// 64 MiB of decompressed data by default, so reading the messages of a chunk one by one, or going
+// back to a recently read chunk, reads the chunk once. The cache is sized with WithChunkCache, and
+// WithChunkCache(0) only keeps the last read chunk. The record doesn't need to be closed, and it
+// can be used after other messages are read.
+func (bag *Bag) ReadMessage(entry MessageEntry) (*RecordMessageData, error) {
+	data, err := bag.readChunk(entry.Chunk)
+	if err != nil {
+		return nil, err
+	}
+	return bag.messageAt(data, entry)
+}
+
+// messageAt returns the message of entry from data, which is the decompressed data of its chunk.
+func (bag *Bag) messageAt(data []byte, entry MessageEntry) (*RecordMessageData, error) {
+	if uint64(entry.Offset) >= uint64(len(data)) {
+		return nil, fmt.Errorf("index points to offset %d, which is outside of the chunk at %d", entry.Offset, bag.chunks[entry.Chunk].pos)
+	}
+
+	record, err := sliceRawRecord(data[entry.Offset:])
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := record.Op()
+	if err != nil {
+		return nil, err
+	}
+
+	if op != OpMessageData {
+		return nil, fmt.Errorf("index points to offset %d, which is not a message in the chunk at %d", entry.Offset, bag.chunks[entry.Chunk].pos)
+	}
+	return bag.messageData(record)
+}
+
+// ReadChunk reads the messages of the ith chunk in the order of the chunk.
+func (bag *Bag) ReadChunk(i int) ([]*RecordMessageData, error) {
+	data, err := bag.readChunk(i)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*RecordMessageData
+	for len(data) > 0 {
+		record, err := sliceRawRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("chunk at %d: %w", bag.chunks[i].pos, err)
+		}
+		data = data[len(record.Raw):]
+
+		op, err := record.Op()
+		if err != nil {
+			return nil, err
+		}
+
+		if op != OpMessageData {
+			continue
+		}
+
+		msg, err := bag.messageData(record)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// readChunk returns the decompressed data of the ith chunk. The data is shared, so it must not be
+// modified.
+func (bag *Bag) readChunk(i int) ([]byte, error) {
+	if i < 0 || i >= len(bag.chunks) {
+		return nil, fmt.Errorf("chunk %d is out of range, the bag has %d chunks", i, len(bag.chunks))
+	}
+
+	bag.mu.Lock()
+	defer bag.mu.Unlock()
+	if data, ok := bag.chunkCache.get(i); ok {
+		return data, nil
+	}
+
+	chunk := bag.chunks[i]
+	record, op, err := readRawRecord(io.NewSectionReader(bag.r, chunk.pos, chunk.length))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if op != OpChunk {
+		return nil, fmt.Errorf("expected a chunk record at %d, but got op %d", chunk.pos, op)
+	}
+
+	data, err := decompressChunk(&RecordChunk{RecordBase: record})
+	if err != nil {
+		return nil, err
+	}
+
+	bag.chunkCache.add(i, data)
+	return data, nil
+}
+
+// messageData specializes record with the connection header of its connection.
+func (bag *Bag) messageData(record *RecordBase) (*RecordMessageData, error) {
+	msg := RecordMessageData{RecordBase: record}
+	conn, err := msg.Conn()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, ok := bag.conns[conn]
+	if !ok {
+		return nil, fmt.Errorf("message on connection %d: %w", conn, errNotFoundConnectionHeader)
+	}
+	msg.connHdr = hdr
+	msg.plans = bag.plans[conn]
+	return &msg, nil
+}
+
+// ReaderOption configures an optional behavior of a BagReader.
+type ReaderOption func(*readerConfig)
+
+type readerConfig struct {
+	topics     []*Pattern
+	start, end time.Time
+	inOrder    bool
+	err        error
+}
+
+// WithTopics limits the messages of a BagReader to the topics that match any of topics, see
+// Pattern. The chunks without messages on the topics are skipped with the message counts of their
+// chunk infos, so they're never read or decompressed. Every topic is read by default.
+func WithTopics(topics ...string) ReaderOption {
+	return func(config *readerConfig) {
+		for _, topic := range topics {
+			pattern, err := CompilePattern(topic)
+			if err != nil && config.err == nil {
+				config.err = err
+			}
+			config.topics = append(config.topics, pattern)
+		}
+	}
+}
+
+// WithTimeRange limits the messages of a BagReader to the messages whose record times are in
+// [start, end). A zero start or end leaves that side of the range open. The chunks whose chunk
+// infos don't overlap the range are skipped without being read, and SeekTime can't move the
+// reader before start.
+func WithTimeRange(start, end time.Time) ReaderOption {
+	return func(config *readerConfig) {
+		config.start = start
+		config.end = end
+	}
+}
+
+// InOrder makes a BagReader read the messages strictly in the order of their record times across
+// the chunks, instead of the order of the bag. Recorders write the messages in the order they
+// arrive, so the record times of a bag go back and forth around the boundaries of the chunks, and
+// a chunk can even overlap the time range of many others. The messages with the same time are read
+// in the order of the bag.
+//
+// The entries of a chunk are read when the chunk info of the chunk shows that it can have the next
+// message, so only the entries of the chunks that overlap the current time are kept in memory, and
+// the decompressed data of a chunk is kept until its last message is read.
+func InOrder() ReaderOption {
+	return func(config *readerConfig) {
+		config.inOrder = true
+	}
+}
+
+// BagReader reads the messages of a Bag in the order of the bag, or in the order of their record
+// times with InOrder, see Bag.NewReader.
+type BagReader struct {
+	bag *Bag
+	// conns are the connections whose messages are read, every connection is read when it's nil
+	conns map[uint32]bool
+	// start is the time of SeekTime or the start of WithTimeRange, whichever is later, and the
+	// messages before it are skipped. from and end are the range of WithTimeRange.
+	start     time.Time
+	from, end time.Time
+	// chunk is the index of the current chunk, and entries are its entries that haven't been read
+	chunk   int
+	entries []MessageEntry
+	// err is reported by Read and SeekTime, e.g. an invalid topic pattern
+	err error
+
+	// order are the indexes of the chunks sorted by their start times when the reader is InOrder,
+	// and next is the position in order of the next chunk whose entries are read
+	order []int
+	next  int
+	// pending are the entries of the chunks before next that haven't been read, sorted by time.
+	// data are the decompressed data of their chunks that have been read, and remaining are the
+	// numbers of entries of every chunk in pending.
+	pending   []MessageEntry
+	data      map[int][]byte
+	remaining map[int]int
+}
+
+// NewReader creates a reader that reads the messages of the bag in the order of the bag from the
+// first chunk. The messages are located with the index data records, so the records of the chunks
+// that aren't messages are never read. Readers are independent of each other, but they share the
+// chunk cache of the bag, see WithChunkCache, so a chunk that has been decompressed by a reader
+// isn't decompressed again by the others while it's cached. With WithChunkCache(0), only the last
+// read chunk is kept, so concurrent readers of different chunks decompress their chunks more than
+// once. opts can be used to read only some of the messages, see ReaderOption.
+func (bag *Bag) NewReader(opts ...ReaderOption) *BagReader {
+	var config readerConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	reader := BagReader{
+		bag:   bag,
+		chunk: -1,
+		start: config.start,
+		from:  config.start,
+		end:   config.end,
+		err:   config.err,
+	}
+	if config.inOrder {
+		reader.order = make([]int, len(bag.chunks))
+		for i := range reader.order {
+			reader.order[i] = i
+		}
+
+		sort.SliceStable(reader.order, func(i, j int) bool {
+			return bag.chunks[reader.order[i]].startTime.Before(bag.chunks[reader.order[j]].startTime)
+		})
+		reader.resetOrdered()
+	}
+
+	if len(config.topics) > 0 && config.err == nil {
+		reader.conns = make(map[uint32]bool)
+		for conn, hdr := range bag.conns {
+			for _, pattern := range config.topics {
+				if pattern.Match(hdr.Topic) {
+					reader.conns[conn] = true
+					break
+				}
+			}
+		}
+	}
+	return &reader
+}
+
+// Read returns the next message. It returns io.EOF after the last message.
+func (reader *BagReader) Read() (*RecordMessageData, error) {
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	if reader.order != nil {
+		return reader.readOrdered()
+	}
+
+	for {
+		for len(reader.entries) > 0 {
+			entry := reader.entries[0]
+			reader.entries = reader.entries[1:]
+			if !reader.keepsEntry(entry) {
+				continue
+			}
+			return reader.bag.ReadMessage(entry)
+		}
+
+		err := reader.nextChunk()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// SeekTime moves the reader to the first message whose record time is at or after t, and Read
+// skips the messages before t from then on. The chunks that end before t are skipped with their
+// chunk infos without being read, and only the index data records of the first chunk after them
+// are read to find the message. Seeking to a zero time moves the reader back to the first message.
+// The messages after t are still read in the order of the bag, which isn't strictly the order of
+// their record times, unless the reader is InOrder.
+func (reader *BagReader) SeekTime(t time.Time) error {
+	if reader.err != nil {
+		return reader.err
+	}
+
+	reader.start = t
+	if t.Before(reader.from) {
+		reader.start = reader.from
+	}
+
+	if reader.order != nil {
+		reader.resetOrdered()
+		return nil
+	}
+
+	reader.chunk = -1
+	reader.entries = nil
+	err := reader.nextChunk()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// nextChunk moves the reader to the next chunk that can have messages for the reader, and reads
+// its entries. It returns io.EOF when there are no more chunks.
+func (reader *BagReader) nextChunk() error {
+	for {
+		if reader.chunk+1 >= len(reader.bag.chunks) {
+			return io.EOF
+		}
+		reader.chunk++
+
+		if reader.skipsChunk(reader.bag.chunks[reader.chunk]) {
+			continue
+		}
+
+		entries, err := reader.bag.ChunkEntries(reader.chunk)
+		if err != nil {
+			return err
+		}
+		reader.entries = entries
+		return nil
+	}
+}
+
+// readOrdered returns the message of the earliest entry of the chunks that can have messages before
+// it. It returns io.EOF after the last message.
+func (reader *BagReader) readOrdered() (*RecordMessageData, error) {
+	// a chunk that starts after the earliest pending entry can't have an earlier message
+	for reader.next < len(reader.order) {
+		i := reader.order[reader.next]
+		if len(reader.pending) > 0 && reader.bag.chunks[i].startTime.After(reader.pending[0].Time) {
+			break
+		}
+		reader.next++
+
+		if reader.skipsChunk(reader.bag.chunks[i]) {
+			continue
+		}
+
+		entries, err := reader.bag.ChunkEntries(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if reader.keepsEntry(entry) {
+				reader.pending = append(reader.pending, entry)
+				reader.remaining[i]++
+			}
+		}
+
+		sort.Slice(reader.pending, func(i, j int) bool {
+			a, b := reader.pending[i], reader.pending[j]
+			if !a.Time.Equal(b.Time) {
+				return a.Time.Before(b.Time)
+			}
+
+			if a.Chunk != b.Chunk {
+				return a.Chunk < b.Chunk
+			}
+			return a.Offset < b.Offset
+		})
+	}
+
+	if len(reader.pending) == 0 {
+		return nil, io.EOF
+	}
+
+	entry := reader.pending[0]
+	reader.pending = reader.pending[1:]
+	data, ok := reader.data[entry.Chunk]
+	if !ok {
+		var err error
+		data, err = reader.bag.readChunk(entry.Chunk)
+		if err != nil {
+			return nil, err
+		}
+		reader.data[entry.Chunk] = data
+	}
+
+	reader.remaining[entry.Chunk]--
+	if reader.remaining[entry.Chunk] == 0 {
+		delete(reader.remaining, entry.Chunk)
+		delete(reader.data, entry.Chunk)
+	}
+	return reader.bag.messageAt(data, entry)
+}
+
+// resetOrdered moves an InOrder reader back to the first chunk.
+func (reader *BagReader) resetOrdered() {
+	reader.next = 0
+	reader.pending = nil
+	reader.data = make(map[int][]byte)
+	reader.remaining = make(map[int]int)
+}
+
+// keepsEntry reports whether the message of entry is read by the reader.
+func (reader *BagReader) keepsEntry(entry MessageEntry) bool {
+	if entry.Time.Before(reader.start) || (!reader.end.IsZero() && !entry.Time.Before(reader.end)) {
+		return false
+	}
+	return reader.conns == nil || reader.conns[entry.Conn]
+}
+
+// skipsChunk reports whether the chunk info of chunk is enough to know that it doesn't have
+// messages for the reader.
+func (reader *BagReader) skipsChunk(chunk workChunk) bool {
+	if chunk.endTime.Before(reader.start) || (!reader.end.IsZero() && !chunk.startTime.Before(reader.end)) {
+		return true
+	}
+
+	if reader.conns == nil {
+		return false
+	}
+
+	for conn, count := range chunk.counts {
+		if count > 0 && reader.conns[conn] {
+			return false
+		}
+	}
+	return true
+}
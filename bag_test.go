@@ -0,0 +1,490 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBag(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 1, X: 1}, {Conn: 0, X: 3}},
+		[]indexedTestMessage{{Conn: 0, X: 2}, {Conn: 1, X: 5}},
+		[]indexedTestMessage{{Conn: 1, X: 4}},
+	)
+	bag, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := bag.Chunks()
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, but got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if i > 0 && chunk.Offset <= chunks[i-1].Offset {
+			t.Fatalf("expected the chunks to be sorted by their offsets, but got %d after %d", chunk.Offset, chunks[i-1].Offset)
+		}
+	}
+
+	if !chunks[1].StartTime.Equal(time.Unix(2, 0)) || !chunks[1].EndTime.Equal(time.Unix(5, 0)) {
+		t.Fatalf("expected the second chunk to be from 2s to 5s, but got %s - %s", chunks[1].StartTime, chunks[1].EndTime)
+	}
+
+	entries, err := bag.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, but got %d", len(entries))
+	}
+
+	// the entries are sorted by time across the chunks, and the messages are read in any order
+	expectedChunks := []int{0, 1, 0, 2, 1}
+	for _, i := range []int{4, 0, 3, 1, 2, 4} {
+		entry := entries[i]
+		if entry.Chunk != expectedChunks[i] || !entry.Time.Equal(time.Unix(int64(i+1), 0)) {
+			t.Fatalf("expected entry %d to be in chunk %d at %ds, but got %+v", i, expectedChunks[i], i+1, entry)
+		}
+
+		msg, err := bag.ReadMessage(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := []byte{uint8(i + 1)}; !bytes.Equal(msg.Data(), expected) {
+			t.Fatalf("expected message %d to be %v, but got %v", i, expected, msg.Data())
+		}
+
+		if conn, _ := msg.Conn(); msg.ConnectionHeader().Topic != []string{"/a", "/b"}[conn] {
+			t.Fatalf("expected message %d to have the connection header of %d, but got %s", i, conn, msg.ConnectionHeader().Topic)
+		}
+	}
+
+	messages, err := bag.ReadChunk(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || !bytes.Equal(messages[0].Data(), []byte{2}) || !bytes.Equal(messages[1].Data(), []byte{5}) {
+		t.Fatalf("expected the messages of the second chunk, but got %v", messages)
+	}
+
+	if diff := cmp.Diff(map[string]uint64{"/a": 2, "/b": 3}, bag.MessageCounts()); diff != "" {
+		t.Fatalf("message counts are not matched:\n\n%s", diff)
+	}
+
+	conns := bag.Connections()
+	if len(conns) != 2 || conns[0].Topic != "/a" || conns[1].Topic != "/b" {
+		t.Fatalf("expected the connections of /a and /b, but got %v", conns)
+	}
+
+	if !bag.StartTime().Equal(time.Unix(1, 0)) || !bag.EndTime().Equal(time.Unix(5, 0)) || bag.Duration() != 4*time.Second {
+		t.Fatalf("expected the bag to be from 1s to 5s, but got %s - %s (%s)", bag.StartTime(), bag.EndTime(), bag.Duration())
+	}
+
+	_, err = bag.ReadChunk(3)
+	if err == nil {
+		t.Fatal("expected an error for a chunk that's out of range")
+	}
+}
+
+func TestBagReaderSeekTime(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 1, X: 1}, {Conn: 0, X: 3}},
+		[]indexedTestMessage{{Conn: 0, X: 2}, {Conn: 1, X: 5}},
+		[]indexedTestMessage{{Conn: 1, X: 4}},
+	)
+	bag, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readValues := func(reader *BagReader) []uint8 {
+		var values []uint8
+		for {
+			msg, err := reader.Read()
+			if err == io.EOF {
+				return values
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, msg.Data()[0])
+		}
+	}
+
+	reader := bag.NewReader()
+	if diff := cmp.Diff([]uint8{1, 3, 2, 5, 4}, readValues(reader)); diff != "" {
+		t.Fatalf("messages are not in the order of the bag:\n\n%s", diff)
+	}
+
+	testCases := []struct {
+		Time     time.Time
+		Expected []uint8
+	}{
+		// the first chunk ends before 4s, and the message at 2s is skipped in the second chunk
+		{Time: time.Unix(4, 0), Expected: []uint8{5, 4}},
+		{Time: time.Unix(2, 0), Expected: []uint8{3, 2, 5, 4}},
+		{Time: time.Unix(5, 0), Expected: []uint8{5}},
+		{Time: time.Unix(6, 0), Expected: nil},
+		{Time: time.Time{}, Expected: []uint8{1, 3, 2, 5, 4}},
+	}
+
+	for _, testCase := range testCases {
+		err = reader.SeekTime(testCase.Time)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(testCase.Expected, readValues(reader)); diff != "" {
+			t.Fatalf("messages after %s are not matched:\n\n%s", testCase.Time, diff)
+		}
+	}
+}
+
+func TestBagReaderInOrder(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 1, X: 1}, {Conn: 0, X: 3}},
+		[]indexedTestMessage{{Conn: 0, X: 2}, {Conn: 1, X: 5}},
+		[]indexedTestMessage{{Conn: 1, X: 4}},
+	)
+	bag, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readValues := func(reader *BagReader) []uint8 {
+		var values []uint8
+		for {
+			msg, err := reader.Read()
+			if err == io.EOF {
+				return values
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, msg.Data()[0])
+		}
+	}
+
+	reader := bag.NewReader(InOrder())
+	if diff := cmp.Diff([]uint8{1, 2, 3, 4, 5}, readValues(reader)); diff != "" {
+		t.Fatalf("messages are not in the order of their times:\n\n%s", diff)
+	}
+
+	err = reader.SeekTime(time.Unix(3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]uint8{3, 4, 5}, readValues(reader)); diff != "" {
+		t.Fatalf("messages after 3s are not matched:\n\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]uint8{1, 4, 5}, readValues(bag.NewReader(InOrder(), WithTopics("/b")))); diff != "" {
+		t.Fatalf("messages on /b are not matched:\n\n%s", diff)
+	}
+}
+
+// recordingReaderAt records the ranges that are read from a bag.
+type recordingReaderAt struct {
+	r      io.ReaderAt
+	ranges [][2]int64
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.ranges = append(r.ranges, [2]int64{off, off + int64(n)})
+	return n, err
+}
+
+func TestBagChunkCache(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 0, X: 1}},
+		[]indexedTestMessage{{Conn: 0, X: 2}},
+		[]indexedTestMessage{{Conn: 0, X: 3}},
+	)
+
+	// readAlternately reads the messages of the first two chunks back and forth, and returns the
+	// number of times that every chunk is read
+	readAlternately := func(opts ...BagOption) []int {
+		r := recordingReaderAt{r: bytes.NewReader(raw)}
+		bag, err := Open(&r, int64(len(raw)), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ranges = nil
+
+		entries, err := bag.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 10; i++ {
+			msg, err := bag.ReadMessage(entries[i%2])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.Data()[0] != uint8(i%2+1) {
+				t.Fatalf("expected message %d to be %d, but got %d", i, i%2+1, msg.Data()[0])
+			}
+		}
+
+		reads := make([]int, len(bag.Chunks()))
+		for i, chunk := range bag.Chunks() {
+			for _, read := range r.ranges {
+				if read[0] == chunk.Offset {
+					reads[i]++
+				}
+			}
+		}
+		return reads
+	}
+
+	if diff := cmp.Diff([]int{1, 1, 0}, readAlternately()); diff != "" {
+		t.Fatalf("expected every chunk to be decompressed once:\n\n%s", diff)
+	}
+
+	// the cache only keeps the last read chunk
+	if diff := cmp.Diff([]int{5, 5, 0}, readAlternately(WithChunkCache(1))); diff != "" {
+		t.Fatalf("expected the chunks to be evicted:\n\n%s", diff)
+	}
+
+	_, err := Open(bytes.NewReader(raw), int64(len(raw)), WithChunkCache(-1))
+	if err == nil {
+		t.Fatal("expected a negative chunk cache size to fail")
+	}
+}
+
+func TestBagReaderTopics(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 1, X: 1}, {Conn: 0, X: 3}},
+		[]indexedTestMessage{{Conn: 0, X: 2}, {Conn: 1, X: 5}},
+		[]indexedTestMessage{{Conn: 1, X: 4}},
+	)
+	r := recordingReaderAt{r: bytes.NewReader(raw)}
+	bag, err := Open(&r, int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ranges = nil
+
+	reader := bag.NewReader(WithTopics("/a"))
+	var values []uint8
+	for {
+		msg, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, msg.Data()[0])
+	}
+
+	if diff := cmp.Diff([]uint8{3, 2}, values); diff != "" {
+		t.Fatalf("messages are not matched:\n\n%s", diff)
+	}
+
+	// the last chunk only has messages on /b
+	skipped := bag.Chunks()[2]
+	for _, read := range r.ranges {
+		if read[0] < skipped.Offset+skipped.Length && read[1] > skipped.Offset {
+			t.Fatalf("expected the chunk at %d to be skipped, but %d-%d was read", skipped.Offset, read[0], read[1])
+		}
+	}
+
+	_, err = bag.NewReader(WithTopics("/a/**/[")).Read()
+	if err == nil {
+		t.Fatal("expected an invalid pattern to fail the reader")
+	}
+}
+
+func TestBagReaderTimeRange(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{Conn: 1, X: 1}, {Conn: 0, X: 3}},
+		[]indexedTestMessage{{Conn: 0, X: 2}, {Conn: 1, X: 5}},
+		[]indexedTestMessage{{Conn: 1, X: 4}},
+	)
+	r := recordingReaderAt{r: bytes.NewReader(raw)}
+	bag, err := Open(&r, int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ranges = nil
+
+	readValues := func(reader *BagReader) []uint8 {
+		var values []uint8
+		for {
+			msg, err := reader.Read()
+			if err == io.EOF {
+				return values
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, msg.Data()[0])
+		}
+	}
+
+	reader := bag.NewReader(WithTimeRange(time.Unix(2, 0), time.Unix(4, 0)))
+	if diff := cmp.Diff([]uint8{3, 2}, readValues(reader)); diff != "" {
+		t.Fatalf("messages in [2s, 4s) are not matched:\n\n%s", diff)
+	}
+
+	// the last chunk starts at the end of the range
+	skipped := bag.Chunks()[2]
+	for _, read := range r.ranges {
+		if read[0] < skipped.Offset+skipped.Length && read[1] > skipped.Offset {
+			t.Fatalf("expected the chunk at %d to be skipped, but %d-%d was read", skipped.Offset, read[0], read[1])
+		}
+	}
+
+	// seeking before the range moves the reader to the start of the range
+	err = reader.SeekTime(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]uint8{3, 2}, readValues(reader)); diff != "" {
+		t.Fatalf("messages after seeking to a zero time are not matched:\n\n%s", diff)
+	}
+
+	reader = bag.NewReader(WithTimeRange(time.Time{}, time.Unix(3, 0)), InOrder())
+	if diff := cmp.Diff([]uint8{1, 2}, readValues(reader)); diff != "" {
+		t.Fatalf("messages before 3s are not matched:\n\n%s", diff)
+	}
+
+	reader = bag.NewReader(WithTimeRange(time.Unix(3, 0), time.Time{}), InOrder())
+	if diff := cmp.Diff([]uint8{3, 4, 5}, readValues(reader)); diff != "" {
+		t.Fatalf("messages from 3s are not matched:\n\n%s", diff)
+	}
+}
+
+func TestBagExample(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bag, err := Open(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := bag.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total uint64
+	for _, count := range bag.MessageCounts() {
+		total += count
+	}
+
+	if total != uint64(len(entries)) {
+		t.Fatalf("expected the message counts to add up to %d, but got %d", len(entries), total)
+	}
+
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			t.Fatalf("expected the entries to be sorted by time, but got %s after %s", entries[i].Time, entries[i-1].Time)
+		}
+	}
+
+	reader := bag.NewReader(InOrder())
+	for _, entry := range entries {
+		msg, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, _ := msg.Conn()
+		msgTime, _ := msg.Time()
+		if conn != entry.Conn || !msgTime.Equal(entry.Time) {
+			t.Fatalf("expected the message of %+v, but got connection %d at %s", entry, conn, msgTime)
+		}
+	}
+
+	_, err = reader.Read()
+	if err != io.EOF {
+		t.Fatalf("expected %v after the last message, but got %v", io.EOF, err)
+	}
+
+	// in the order of the bag, the messages are the same as the ones of the decoder
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Chunk != entries[j].Chunk {
+			return entries[i].Chunk < entries[j].Chunk
+		}
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	var actual [][]byte
+	for _, entry := range entries {
+		msg, err := bag.ReadMessage(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, append(append([]byte(nil), msg.Header()...), msg.Data()...))
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareRecords(t, readAllMessages(t, raw), actual)
+}
+
+func TestOpenNotIndexed(t *testing.T) {
+	raw := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	// the trailing partial record of an interrupted recording is ignored
+	raw = append(raw, 1, 2)
+	bag, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bag.StartTime().Equal(time.Unix(1, 0)) || !bag.EndTime().Equal(time.Unix(3, 0)) || bag.Duration() != 2*time.Second {
+		t.Fatalf("expected the bag to be from 1s to 3s, but got %s - %s (%s)", bag.StartTime(), bag.EndTime(), bag.Duration())
+	}
+
+	entries, err := bag.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual [][]byte
+	for _, entry := range entries {
+		msg, err := bag.ReadMessage(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, append(append([]byte(nil), msg.Header()...), msg.Data()...))
+	}
+	compareRecords(t, readAllMessages(t, raw[:len(raw)-2]), actual)
+
+	if diff := cmp.Diff(map[string]uint64{"/test": 3}, bag.MessageCounts()); diff != "" {
+		t.Fatalf("message counts are not matched:\n\n%s", diff)
+	}
+}
@@ -0,0 +1,106 @@
+package rosbag
+
+import (
+	"io"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// TopicBench is the share of a topic in a BenchResult.
+type TopicBench struct {
+	Topic    string `json:"topic"`
+	Messages uint64 `json:"messages"`
+	// Bytes is the size of the message data of the topic
+	Bytes int64 `json:"bytes"`
+	// View is the time that is spent viewing the messages of the topic as maps
+	View time.Duration `json:"view"`
+}
+
+// BenchResult is the decoding throughput of a bag that is measured by BenchDecode.
+type BenchResult struct {
+	Elapsed time.Duration `json:"elapsed"`
+	// Bytes is the size of the bag that has been read
+	Bytes    int64  `json:"bytes"`
+	Records  uint64 `json:"records"`
+	Messages uint64 `json:"messages"`
+	// Allocs and AllocBytes are the heap allocations of the whole process while decoding, so they
+	// include the allocations of other goroutines
+	Allocs     uint64       `json:"allocs"`
+	AllocBytes uint64       `json:"alloc_bytes"`
+	Topics     []TopicBench `json:"topics"`
+}
+
+// RecordsPerSecond returns the records that are decoded per second.
+func (result *BenchResult) RecordsPerSecond() float64 {
+	return float64(result.Records) / result.Elapsed.Seconds()
+}
+
+// MBPerSecond returns the megabytes of the bag that are decoded per second.
+func (result *BenchResult) MBPerSecond() float64 {
+	return float64(result.Bytes) / 1024 / 1024 / result.Elapsed.Seconds()
+}
+
+// BenchDecode reads the rest of the bag from decoder, and measures how fast it's decoded. When
+// view is true, every message is viewed as a map[string]interface{} like BenchmarkE2E does, which
+// is how most applications use the messages, otherwise only the records are read. The options of
+// the decoder, e.g. WithPrefetch or NewDecoderBytes, are measured as they're configured, so they
+// can be compared on the same bag.
+func BenchDecode(decoder *Decoder, view bool) (*BenchResult, error) {
+	var result BenchResult
+	topics := make(map[string]*TopicBench)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		result.Records++
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+		result.Messages++
+
+		topic := topics[msg.connHdr.Topic]
+		if topic == nil {
+			topic = &TopicBench{Topic: msg.connHdr.Topic}
+			topics[msg.connHdr.Topic] = topic
+		}
+		topic.Messages++
+		topic.Bytes += int64(msg.DataLen)
+
+		if view {
+			viewStart := time.Now()
+			err = msg.ViewAs(make(map[string]interface{}))
+			topic.View += time.Since(viewStart)
+			if err != nil {
+				msg.Close()
+				return nil, err
+			}
+		}
+		msg.Close()
+	}
+
+	result.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.Bytes = decoder.offset
+	result.Allocs = after.Mallocs - before.Mallocs
+	result.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	for _, topic := range topics {
+		result.Topics = append(result.Topics, *topic)
+	}
+
+	sort.Slice(result.Topics, func(i, j int) bool {
+		return result.Topics[i].Topic < result.Topics[j].Topic
+	})
+	return &result, nil
+}
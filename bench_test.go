@@ -0,0 +1,51 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestBenchDecode(t *testing.T) {
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(1, 2, 3, 4, 5)...)
+
+	decoders := map[string]*Decoder{
+		"Reader": NewDecoder(bytes.NewReader(raw)),
+		"Bytes":  NewDecoderBytes(raw),
+	}
+
+	for name, decoder := range decoders {
+		for _, view := range []bool{false, true} {
+			result, err := BenchDecode(decoder, view)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if result.Bytes != int64(len(raw)) || result.Records != 6 || result.Messages != 5 || result.Elapsed <= 0 {
+				t.Fatalf("%s: unexpected result %+v", name, result)
+			}
+
+			if diff := cmp.Diff([]TopicBench{{Topic: "/test", Messages: 5, Bytes: 5}}, result.Topics, cmpopts.IgnoreFields(TopicBench{}, "View")); diff != "" {
+				t.Fatalf("%s: topics are not matched:\n\n%s", name, diff)
+			}
+
+			if view != (result.Topics[0].View > 0) {
+				t.Fatalf("%s: expected the view time only when the messages are viewed, but got %v", name, result.Topics[0].View)
+			}
+
+			if result.RecordsPerSecond() <= 0 || result.MBPerSecond() <= 0 {
+				t.Fatalf("%s: expected positive rates, but got %v and %v", name, result.RecordsPerSecond(), result.MBPerSecond())
+			}
+
+			// the next run reads the same bag again
+			if name == "Reader" {
+				decoder = NewDecoder(bytes.NewReader(raw))
+			} else {
+				decoder = NewDecoderBytes(raw)
+			}
+		}
+	}
+}
@@ -0,0 +1,541 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+const (
+	// bz2MaxBlock is the maximum size of a block after the initial run-length encoding, it's a bit
+	// below the 900 KB blocks of level 9 like the reference encoder
+	bz2MaxBlock = 9*100*1000 - 19
+	// bz2MaxCodeLen is the maximum length of the Huffman codes of the reference encoder
+	bz2MaxCodeLen = 17
+	// bz2GroupSize is the number of symbols that are coded with the same Huffman table
+	bz2GroupSize = 50
+	bz2RunA      = 0
+	bz2RunB      = 1
+)
+
+var bz2CRCTable = func() (table [256]uint32) {
+	for i := range table {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return table
+}()
+
+// bz2Writer compresses the data that's written to it as a bzip2 stream, since the standard library
+// can only decompress bzip2. The data is buffered, and compressed when the writer is closed, which
+// is fine for chunks. The blocks are sorted with prefix doubling, so the worst case is
+// O(n log n) for very repetitive data.
+type bz2Writer struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func newBZ2Writer(w io.Writer) io.WriteCloser {
+	return &bz2Writer{w: w}
+}
+
+func (writer *bz2Writer) Write(p []byte) (int, error) {
+	return writer.buf.Write(p)
+}
+
+func (writer *bz2Writer) Close() error {
+	bw := bz2BitWriter{out: make([]byte, 0, writer.buf.Len()/2)}
+	bw.out = append(bw.out, "BZh9"...)
+
+	var combinedCRC uint32
+	data := writer.buf.Bytes()
+	for len(data) > 0 {
+		n, block := bz2RunLength(data, bz2MaxBlock)
+		crc := bz2CRC(data[:n])
+		combinedCRC = (combinedCRC<<1 | combinedCRC>>31) ^ crc
+		bw.block(block, crc)
+		data = data[n:]
+	}
+
+	// the end of stream magic is the square root of pi
+	bw.writeBits(24, 0x177245)
+	bw.writeBits(24, 0x385090)
+	bw.writeBits(32, uint64(combinedCRC))
+	bw.flush()
+
+	writer.buf.Reset()
+	_, err := writer.w.Write(bw.out)
+	return err
+}
+
+func bz2CRC(data []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range data {
+		crc = crc<<8 ^ bz2CRCTable[byte(crc>>24)^b]
+	}
+	return ^crc
+}
+
+// bz2RunLength encodes the runs of 4 to 255 equal bytes of data as the 4 bytes and the number of
+// the other bytes, until the output reaches max bytes. It returns the number of bytes of data that
+// have been encoded, and the output.
+func bz2RunLength(data []byte, max int) (int, []byte) {
+	var out []byte
+	i := 0
+	for i < len(data) {
+		b := data[i]
+		run := 1
+		for i+run < len(data) && run < 255 && data[i+run] == b {
+			run++
+		}
+
+		if run < 4 {
+			if len(out)+1 > max {
+				break
+			}
+			out = append(out, b)
+			i++
+			continue
+		}
+
+		if len(out)+5 > max {
+			break
+		}
+		out = append(out, b, b, b, b, byte(run-4))
+		i += run
+	}
+	return i, out
+}
+
+// bz2SortRotations returns the start positions of the rotations of block in sorted order, by
+// sorting the rotations by prefixes of doubling lengths with counting sorts.
+func bz2SortRotations(block []byte) []int32 {
+	n := len(block)
+	order := make([]int32, n)
+	classes := make([]int32, n)
+	counts := make([]int32, 256)
+	if n > len(counts) {
+		counts = make([]int32, n)
+	}
+
+	for _, b := range block {
+		counts[b]++
+	}
+	for i := 1; i < 256; i++ {
+		counts[i] += counts[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		counts[block[i]]--
+		order[counts[block[i]]] = int32(i)
+	}
+
+	numClasses := int32(1)
+	for i := 1; i < n; i++ {
+		if block[order[i]] != block[order[i-1]] {
+			numClasses++
+		}
+		classes[order[i]] = numClasses - 1
+	}
+
+	shifted := make([]int32, n)
+	nextClasses := make([]int32, n)
+	for h := 1; h < n && int(numClasses) < n; h <<= 1 {
+		// the rotations are sorted by their second halves, so they only need a stable sort by their
+		// first halves
+		for i, start := range order {
+			shifted[i] = start - int32(h)
+			if shifted[i] < 0 {
+				shifted[i] += int32(n)
+			}
+		}
+
+		for i := range counts[:numClasses] {
+			counts[i] = 0
+		}
+		for _, start := range shifted {
+			counts[classes[start]]++
+		}
+		for i := int32(1); i < numClasses; i++ {
+			counts[i] += counts[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			class := classes[shifted[i]]
+			counts[class]--
+			order[counts[class]] = shifted[i]
+		}
+
+		second := func(start int32) int32 {
+			return classes[(int(start)+h)%n]
+		}
+
+		nextClasses[order[0]] = 0
+		numClasses = 1
+		for i := 1; i < n; i++ {
+			cur, prev := order[i], order[i-1]
+			if classes[cur] != classes[prev] || second(cur) != second(prev) {
+				numClasses++
+			}
+			nextClasses[cur] = numClasses - 1
+		}
+		classes, nextClasses = nextClasses, classes
+	}
+	return order
+}
+
+// bz2BitWriter writes the bits of a bzip2 stream from the most significant bit.
+type bz2BitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+func (bw *bz2BitWriter) writeBits(n uint, v uint64) {
+	bw.acc = bw.acc<<n | v&(1<<n-1)
+	bw.n += n
+	for bw.n >= 8 {
+		bw.n -= 8
+		bw.out = append(bw.out, byte(bw.acc>>bw.n))
+	}
+}
+
+// flush pads the last byte with zeros.
+func (bw *bz2BitWriter) flush() {
+	if bw.n > 0 {
+		bw.writeBits(8-bw.n, 0)
+	}
+}
+
+// block writes a block of run-length encoded data with the CRC of its original data.
+func (bw *bz2BitWriter) block(block []byte, crc uint32) {
+	order := bz2SortRotations(block)
+	n := len(block)
+	var origPtr int
+	last := make([]byte, n)
+	for i, start := range order {
+		if start == 0 {
+			origPtr = i
+		}
+		last[i] = block[(int(start)+n-1)%n]
+	}
+
+	var inUse [256]bool
+	for _, b := range block {
+		inUse[b] = true
+	}
+
+	// the bytes are renumbered to the bytes in use, which are the symbols of move-to-front
+	var seq [256]byte
+	var mtf [256]byte
+	numInUse := 0
+	for b, used := range inUse {
+		if used {
+			seq[b] = byte(numInUse)
+			mtf[numInUse] = byte(numInUse)
+			numInUse++
+		}
+	}
+
+	alphaSize := numInUse + 2
+	syms := make([]uint16, 0, n+1)
+	freqs := make([]int, alphaSize)
+	emit := func(sym uint16) {
+		syms = append(syms, sym)
+		freqs[sym]++
+	}
+
+	// the runs of zeros are written in bijective base 2 with RUNA and RUNB
+	zeros := 0
+	flushZeros := func() {
+		if zeros == 0 {
+			return
+		}
+
+		zeros--
+		for {
+			if zeros&1 == 0 {
+				emit(bz2RunA)
+			} else {
+				emit(bz2RunB)
+			}
+			if zeros < 2 {
+				break
+			}
+			zeros = (zeros - 2) / 2
+		}
+		zeros = 0
+	}
+
+	for _, b := range last {
+		s := seq[b]
+		j := 0
+		for mtf[j] != s {
+			j++
+		}
+
+		if j == 0 {
+			zeros++
+			continue
+		}
+
+		flushZeros()
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = s
+		emit(uint16(j + 1))
+	}
+	flushZeros()
+	emit(uint16(numInUse + 1))
+
+	lengths, selectors := bz2Tables(syms, freqs)
+
+	bw.writeBits(24, 0x314159)
+	bw.writeBits(24, 0x265359)
+	bw.writeBits(32, uint64(crc))
+	// the block is not randomized
+	bw.writeBits(1, 0)
+	bw.writeBits(24, uint64(origPtr))
+
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for _, used := range inUse[i*16 : i*16+16] {
+			if used {
+				ranges |= 1 << (15 - i)
+				break
+			}
+		}
+	}
+	bw.writeBits(16, ranges)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<(15-i)) == 0 {
+			continue
+		}
+
+		var used uint64
+		for j, ok := range inUse[i*16 : i*16+16] {
+			if ok {
+				used |= 1 << (15 - j)
+			}
+		}
+		bw.writeBits(16, used)
+	}
+
+	bw.writeBits(3, uint64(len(lengths)))
+	bw.writeBits(15, uint64(len(selectors)))
+	var tables [6]uint8
+	for i := range tables {
+		tables[i] = uint8(i)
+	}
+	for _, selector := range selectors {
+		j := 0
+		for tables[j] != selector {
+			j++
+			bw.writeBits(1, 1)
+		}
+		bw.writeBits(1, 0)
+		copy(tables[1:j+1], tables[:j])
+		tables[0] = selector
+	}
+
+	// the code lengths are written as deltas from the previous length
+	codes := make([][]uint32, len(lengths))
+	for t, table := range lengths {
+		length := table[0]
+		bw.writeBits(5, uint64(length))
+		for _, l := range table {
+			for length < l {
+				bw.writeBits(2, 2)
+				length++
+			}
+			for length > l {
+				bw.writeBits(2, 3)
+				length--
+			}
+			bw.writeBits(1, 0)
+		}
+		codes[t] = bz2Codes(table)
+	}
+
+	for i, sym := range syms {
+		t := selectors[i/bz2GroupSize]
+		bw.writeBits(uint(lengths[t][sym]), uint64(codes[t][sym]))
+	}
+}
+
+// bz2Codes returns the canonical Huffman codes of the code lengths, which are assigned in the
+// order of the lengths, and of the symbols.
+func bz2Codes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	for length := uint8(1); length <= bz2MaxCodeLen; length++ {
+		for sym, l := range lengths {
+			if l == length {
+				codes[sym] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bz2Tables chooses the Huffman tables of the symbols of a block, and the table of every group of
+// symbols, like the reference encoder: the alphabet is split into ranges of about the same
+// frequency for the initial tables, and every group is assigned to the cheapest table, which are
+// rebuilt from the frequencies of their groups a few times.
+func bz2Tables(syms []uint16, freqs []int) ([][]uint8, []uint8) {
+	var numTables int
+	switch {
+	case len(syms) < 200:
+		numTables = 2
+	case len(syms) < 600:
+		numTables = 3
+	case len(syms) < 1200:
+		numTables = 4
+	case len(syms) < 2400:
+		numTables = 5
+	default:
+		numTables = 6
+	}
+
+	alphaSize := len(freqs)
+	lengths := make([][]uint8, numTables)
+	remaining := len(syms)
+	lo := 0
+	for t := 0; t < numTables; t++ {
+		// the tables take the ranges of the alphabet from the end, like the reference encoder
+		target := remaining / (numTables - t)
+		hi := lo - 1
+		sum := 0
+		for sum < target && hi < alphaSize-1 {
+			hi++
+			sum += freqs[hi]
+		}
+
+		lengths[t] = make([]uint8, alphaSize)
+		for sym := range lengths[t] {
+			if sym < lo || sym > hi {
+				lengths[t][sym] = 15
+			}
+		}
+		remaining -= sum
+		lo = hi + 1
+	}
+
+	selectors := make([]uint8, (len(syms)+bz2GroupSize-1)/bz2GroupSize)
+	tableFreqs := make([][]int, numTables)
+	for t := range tableFreqs {
+		tableFreqs[t] = make([]int, alphaSize)
+	}
+
+	for iter := 0; iter < 4; iter++ {
+		for t := range tableFreqs {
+			for sym := range tableFreqs[t] {
+				tableFreqs[t][sym] = 0
+			}
+		}
+
+		for g := range selectors {
+			group := syms[g*bz2GroupSize:]
+			if len(group) > bz2GroupSize {
+				group = group[:bz2GroupSize]
+			}
+
+			best, bestCost := 0, -1
+			for t, table := range lengths {
+				cost := 0
+				for _, sym := range group {
+					cost += int(table[sym])
+				}
+
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+
+			selectors[g] = uint8(best)
+			for _, sym := range group {
+				tableFreqs[best][sym]++
+			}
+		}
+
+		for t := range lengths {
+			lengths[t] = bz2CodeLengths(tableFreqs[t], bz2MaxCodeLen)
+		}
+	}
+	return lengths, selectors
+}
+
+// bz2CodeLengths returns the lengths of the Huffman codes of freqs that are at most maxLen long.
+// Every symbol has a code, even when it's not used, since bzip2 tables have every symbol. The
+// frequencies are flattened until the longest code fits, like the reference encoder.
+func bz2CodeLengths(freqs []int, maxLen uint8) []uint8 {
+	weights := make([]int, len(freqs))
+	for sym, freq := range freqs {
+		weights[sym] = freq + 1
+	}
+
+	for {
+		lengths := huffmanCodeLengths(weights)
+		fits := true
+		for _, l := range lengths {
+			if l > maxLen {
+				fits = false
+				break
+			}
+		}
+
+		if fits {
+			return lengths
+		}
+
+		for sym := range weights {
+			weights[sym] = 1 + weights[sym]/2
+		}
+	}
+}
+
+// huffmanCodeLengths returns the lengths of the Huffman codes of the weights, which must be
+// positive, with the two-queue construction of the tree.
+func huffmanCodeLengths(weights []int) []uint8 {
+	n := len(weights)
+	leaves := make([]int, n)
+	for i := range leaves {
+		leaves[i] = i
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return weights[leaves[i]] < weights[leaves[j]]
+	})
+
+	// the nodes are the leaves, followed by the internal nodes in the order they're created
+	nodeWeights := make([]int, 2*n-1)
+	parents := make([]int, 2*n-1)
+	copy(nodeWeights, weights)
+	nextLeaf, nextInternal := 0, n
+	pop := func(created int) int {
+		if nextLeaf < n && (nextInternal >= created || weights[leaves[nextLeaf]] <= nodeWeights[nextInternal]) {
+			nextLeaf++
+			return leaves[nextLeaf-1]
+		}
+		nextInternal++
+		return nextInternal - 1
+	}
+
+	for created := n; created < 2*n-1; created++ {
+		a := pop(created)
+		b := pop(created)
+		nodeWeights[created] = nodeWeights[a] + nodeWeights[b]
+		parents[a], parents[b] = created, created
+	}
+
+	depths := make([]uint8, 2*n-1)
+	for node := 2*n - 3; node >= 0; node-- {
+		depths[node] = depths[parents[node]] + 1
+	}
+	return depths[:n]
+}
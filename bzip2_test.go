@@ -0,0 +1,69 @@
+package rosbag
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestBZ2Writer(t *testing.T) {
+	random := make([]byte, 100*1000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// text-like data with a small alphabet, which is larger than a block
+	words := []string{"pose ", "imu ", "battery ", "/camera/image ", "0.25 ", "\n"}
+	rng := rand.New(rand.NewSource(2))
+	var text bytes.Buffer
+	for text.Len() < 2*bz2MaxBlock {
+		text.WriteString(words[rng.Intn(len(words))])
+	}
+
+	testCases := []struct {
+		Name string
+		Data []byte
+	}{
+		{Name: "Empty", Data: nil},
+		{Name: "Single Byte", Data: []byte{42}},
+		{Name: "Short Runs", Data: []byte("aaabbbbcccccdddddd")},
+		{Name: "Long Run", Data: bytes.Repeat([]byte{7}, 1000)},
+		{Name: "Runs of Every Length", Data: func() []byte {
+			var data []byte
+			for run := 1; run <= 260; run++ {
+				data = append(data, bytes.Repeat([]byte{byte(run)}, run)...)
+			}
+			return data
+		}()},
+		{Name: "Repeated Runs", Data: bytes.Repeat([]byte{1, 1, 1, 1, 1, 1, 2, 2, 2, 2}, 100)},
+		{Name: "Random", Data: random},
+		{Name: "Every Byte", Data: func() []byte {
+			data := make([]byte, 256*3)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			return data
+		}()},
+		{Name: "Multiple Blocks", Data: text.Bytes()},
+		{Name: "Zeros", Data: make([]byte, 100*1000)},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			compressed, err := compressData(CompressionBZ2, testCase.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(testCase.Data, actual) {
+				t.Fatalf("expected %d bytes to be decompressed, but got %d different bytes", len(testCase.Data), len(actual))
+			}
+		})
+	}
+}
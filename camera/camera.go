@@ -0,0 +1,292 @@
+// Package camera projects 3D points into the pixels of a camera, and back-projects pixels to
+// rays, with the calibration from sensor_msgs/CameraInfo messages. It follows the conventions of
+// the image_geometry package of ROS.
+package camera
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// MessageType is the ROS type of the messages that are consumed by NewModel.
+const MessageType = "sensor_msgs/CameraInfo"
+
+// The distortion models that are supported by Model.
+const (
+	DistortionPlumbBob           = "plumb_bob"
+	DistortionRationalPolynomial = "rational_polynomial"
+	DistortionEquidistant        = "equidistant"
+)
+
+// undistortIterations is the number of iterations that Ray takes to invert the distortion, like
+// cv::undistortPoints.
+const undistortIterations = 20
+
+var (
+	errUncalibrated = errors.New("camera is not calibrated, K is all zeros")
+)
+
+type cameraInfo struct {
+	Header struct {
+		Stamp   time.Time `rosbag:"stamp"`
+		FrameID string    `rosbag:"frame_id,copy"`
+	} `rosbag:"header"`
+	Height          uint32    `rosbag:"height"`
+	Width           uint32    `rosbag:"width"`
+	DistortionModel string    `rosbag:"distortion_model,copy"`
+	D               []float64 `rosbag:"D,copy"`
+	K               []float64 `rosbag:"K,copy"`
+	R               []float64 `rosbag:"R,copy"`
+	P               []float64 `rosbag:"P,copy"`
+}
+
+// Point is a 3D point in the optical frame of the camera, where z points forward, x right, and y
+// down.
+type Point struct {
+	X, Y, Z float64
+}
+
+// Pixel is a position in an image, where (0, 0) is the center of the top left pixel.
+type Pixel struct {
+	U, V float64
+}
+
+// Model is the calibration of a camera from a sensor_msgs/CameraInfo message. Project and Ray
+// work with the raw (distorted) image, ProjectRectified and RectifiedRay work with the rectified
+// image. Binning and the region of interest are not applied.
+type Model struct {
+	FrameID string
+	// Stamp is the header stamp of the CameraInfo message.
+	Stamp           time.Time
+	Width, Height   uint32
+	DistortionModel string
+	D               []float64
+	K               [9]float64
+	R               [9]float64
+	P               [12]float64
+}
+
+// NewModel decodes the sensor_msgs/CameraInfo message in record. The model can be used after the
+// record is closed.
+func NewModel(record *rosbag.RecordMessageData) (*Model, error) {
+	var info cameraInfo
+	err := record.ViewAs(&info)
+	if err != nil {
+		return nil, err
+	}
+
+	model := Model{
+		FrameID:         info.Header.FrameID,
+		Stamp:           info.Header.Stamp,
+		Width:           info.Width,
+		Height:          info.Height,
+		DistortionModel: info.DistortionModel,
+		D:               info.D,
+	}
+
+	if len(info.K) != len(model.K) || len(info.R) != len(model.R) || len(info.P) != len(model.P) {
+		return nil, fmt.Errorf("K, R, and P must have 9, 9, and 12 elements, but got %d, %d, and %d", len(info.K), len(info.R), len(info.P))
+	}
+	copy(model.K[:], info.K)
+	copy(model.R[:], info.R)
+	copy(model.P[:], info.P)
+
+	if model.K == [9]float64{} {
+		return nil, errUncalibrated
+	}
+	return &model, nil
+}
+
+// Project projects p to a pixel in the raw image with K and D. ok is false when p is behind the
+// camera. The pixel can be outside of the image.
+func (model *Model) Project(p Point) (pixel Pixel, ok bool) {
+	if p.Z <= 0 {
+		return Pixel{}, false
+	}
+
+	x, y := model.distort(p.X/p.Z, p.Y/p.Z)
+	return model.toPixel(x, y), true
+}
+
+// ProjectRectified projects p to a pixel in the rectified image with P. ok is false when p is
+// behind the camera.
+func (model *Model) ProjectRectified(p Point) (pixel Pixel, ok bool) {
+	P := &model.P
+	w := P[8]*p.X + P[9]*p.Y + P[10]*p.Z + P[11]
+	if w <= 0 {
+		return Pixel{}, false
+	}
+
+	return Pixel{
+		U: (P[0]*p.X + P[1]*p.Y + P[2]*p.Z + P[3]) / w,
+		V: (P[4]*p.X + P[5]*p.Y + P[6]*p.Z + P[7]) / w,
+	}, true
+}
+
+// Ray back-projects a pixel in the raw image to the unit vector of the ray that goes through it.
+// The distortion is inverted iteratively.
+func (model *Model) Ray(pixel Pixel) Point {
+	K := &model.K
+	y0 := (pixel.V - K[5]) / K[4]
+	x0 := (pixel.U - K[2] - K[1]*y0) / K[0]
+	x, y := model.undistort(x0, y0)
+	return unit(x, y, 1)
+}
+
+// RectifiedRay back-projects a pixel in the rectified image to the unit vector of the ray that
+// goes through it with P, like image_geometry's projectPixelTo3dRay.
+func (model *Model) RectifiedRay(pixel Pixel) Point {
+	P := &model.P
+	return unit((pixel.U-P[2]-P[3])/P[0], (pixel.V-P[6]-P[7])/P[5], 1)
+}
+
+// Contains reports whether pixel is inside of the image.
+func (model *Model) Contains(pixel Pixel) bool {
+	return pixel.U >= -0.5 && pixel.V >= -0.5 && pixel.U < float64(model.Width)-0.5 && pixel.V < float64(model.Height)-0.5
+}
+
+func (model *Model) toPixel(x, y float64) Pixel {
+	K := &model.K
+	return Pixel{
+		U: K[0]*x + K[1]*y + K[2],
+		V: K[4]*y + K[5],
+	}
+}
+
+// distortion returns the coefficient at i of D, or 0 when D doesn't have it. Cameras without
+// distortion can leave D empty.
+func (model *Model) distortion(i int) float64 {
+	if i >= len(model.D) {
+		return 0
+	}
+	return model.D[i]
+}
+
+// distort applies the distortion to the normalized coordinates x and y.
+func (model *Model) distort(x, y float64) (float64, float64) {
+	k := model.distortion
+	if model.DistortionModel == DistortionEquidistant {
+		r := math.Hypot(x, y)
+		if r == 0 {
+			return x, y
+		}
+
+		theta := math.Atan(r)
+		t2 := theta * theta
+		t4 := t2 * t2
+		thetaD := theta * (1 + k(0)*t2 + k(1)*t4 + k(2)*t4*t2 + k(3)*t4*t4)
+		return x * thetaD / r, y * thetaD / r
+	}
+
+	r2 := x*x + y*y
+	radial := model.radial(r2)
+	return x*radial + 2*k(2)*x*y + k(3)*(r2+2*x*x),
+		y*radial + k(2)*(r2+2*y*y) + 2*k(3)*x*y
+}
+
+// radial returns the radial distortion factor of plumb_bob and rational_polynomial at the
+// squared radius r2.
+func (model *Model) radial(r2 float64) float64 {
+	k := model.distortion
+	r4 := r2 * r2
+	r6 := r4 * r2
+	radial := 1 + k(0)*r2 + k(1)*r4 + k(4)*r6
+	if model.DistortionModel == DistortionRationalPolynomial {
+		radial /= 1 + k(5)*r2 + k(6)*r4 + k(7)*r6
+	}
+	return radial
+}
+
+// undistort inverts distort with fixed-point iterations.
+func (model *Model) undistort(x0, y0 float64) (float64, float64) {
+	k := model.distortion
+	if model.DistortionModel == DistortionEquidistant {
+		thetaD := math.Hypot(x0, y0)
+		if thetaD == 0 {
+			return x0, y0
+		}
+
+		// solve thetaD = theta * (1 + k0*theta^2 + ...) with Newton's method
+		theta := thetaD
+		for i := 0; i < undistortIterations; i++ {
+			t2 := theta * theta
+			t4 := t2 * t2
+			f := theta*(1+k(0)*t2+k(1)*t4+k(2)*t4*t2+k(3)*t4*t4) - thetaD
+			df := 1 + 3*k(0)*t2 + 5*k(1)*t4 + 7*k(2)*t4*t2 + 9*k(3)*t4*t4
+			theta -= f / df
+		}
+
+		scale := math.Tan(theta) / thetaD
+		return x0 * scale, y0 * scale
+	}
+
+	x, y := x0, y0
+	for i := 0; i < undistortIterations; i++ {
+		r2 := x*x + y*y
+		dx := 2*k(2)*x*y + k(3)*(r2+2*x*x)
+		dy := k(2)*(r2+2*y*y) + 2*k(3)*x*y
+		radial := model.radial(r2)
+		x = (x0 - dx) / radial
+		y = (y0 - dy) / radial
+	}
+	return x, y
+}
+
+func unit(x, y, z float64) Point {
+	norm := math.Sqrt(x*x + y*y + z*z)
+	return Point{X: x / norm, Y: y / norm, Z: z / norm}
+}
+
+// InfoTopic returns the CameraInfo topic that belongs to an image topic by the ROS convention,
+// i.e. camera_info in the same namespace, e.g. /camera/image_raw is paired with
+// /camera/camera_info.
+func InfoTopic(imageTopic string) string {
+	return path.Join(path.Dir(imageTopic), "camera_info")
+}
+
+// Models keeps the latest Model of every CameraInfo topic, so that the messages of the image and
+// point cloud topics can be paired with their calibration while a bag is read.
+type Models struct {
+	topics map[string]*Model
+	frames map[string]*Model
+}
+
+// NewModels creates an empty Models.
+func NewModels() *Models {
+	return &Models{
+		topics: make(map[string]*Model),
+		frames: make(map[string]*Model),
+	}
+}
+
+// Add decodes the CameraInfo message in record, and replaces the model of its topic. Add has the
+// signature of rosbag.MessageHandler, so it can be passed to Decoder.Subscribe directly.
+func (models *Models) Add(record *rosbag.RecordMessageData) error {
+	model, err := NewModel(record)
+	if err != nil {
+		return err
+	}
+
+	models.topics[record.ConnectionHeader().Topic] = model
+	if model.FrameID != "" {
+		models.frames[model.FrameID] = model
+	}
+	return nil
+}
+
+// ForImage returns the latest model of the CameraInfo topic that belongs to imageTopic, see
+// InfoTopic. It returns nil when no CameraInfo message has been added for it yet.
+func (models *Models) ForImage(imageTopic string) *Model {
+	return models.topics[InfoTopic(imageTopic)]
+}
+
+// ForFrame returns the latest model whose header has frameID, e.g. to pair a point cloud that is
+// already in the optical frame of a camera. It returns nil when there is none.
+func (models *Models) ForFrame(frameID string) *Model {
+	return models.frames[frameID]
+}
@@ -0,0 +1,273 @@
+package camera
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const cameraInfoDefinition = `Header header
+uint32 height
+uint32 width
+string distortion_model
+float64[] D
+float64[9] K
+float64[9] R
+float64[12] P
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+func testModel(distortionModel string, d ...float64) *Model {
+	return &Model{
+		Width:           640,
+		Height:          480,
+		DistortionModel: distortionModel,
+		D:               d,
+		K:               [9]float64{500, 0, 320, 0, 505, 240, 0, 0, 1},
+		R:               [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1},
+		P:               [12]float64{480, 0, 322, 0, 0, 485, 238, 0, 0, 0, 1, 0},
+	}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestProjectRay(t *testing.T) {
+	models := []*Model{
+		testModel(DistortionPlumbBob),
+		testModel(DistortionPlumbBob, -0.25, 0.08, 0.001, -0.002, 0.01),
+		testModel(DistortionRationalPolynomial, -0.25, 0.08, 0.001, -0.002, 0.01, 0.02, 0.01, 0.005),
+		testModel(DistortionEquidistant, -0.01, 0.02, -0.005, 0.001),
+	}
+
+	points := []Point{{0, 0, 1}, {0.3, -0.2, 2}, {-1, 0.5, 4}}
+	for _, model := range models {
+		for _, p := range points {
+			pixel, ok := model.Project(p)
+			if !ok {
+				t.Fatalf("%s: expected %v to be projected", model.DistortionModel, p)
+			}
+
+			ray := model.Ray(pixel)
+			expected := unit(p.X, p.Y, p.Z)
+			if !closeTo(ray.X, expected.X) || !closeTo(ray.Y, expected.Y) || !closeTo(ray.Z, expected.Z) {
+				t.Fatalf("%s %v: expected the ray of %v to be %v, but got %v", model.DistortionModel, model.D, pixel, expected, ray)
+			}
+		}
+	}
+
+	model := testModel(DistortionPlumbBob)
+	pixel, _ := model.Project(Point{0.2, 0.1, 1})
+	if !closeTo(pixel.U, 420) || !closeTo(pixel.V, 290.5) {
+		t.Fatalf("expected (420, 290.5), but got %v", pixel)
+	}
+
+	if _, ok := model.Project(Point{0, 0, -1}); ok {
+		t.Fatal("expected a point behind the camera not to be projected")
+	}
+}
+
+func TestProjectRectified(t *testing.T) {
+	model := testModel(DistortionPlumbBob, -0.25, 0.08)
+	p := Point{0.2, 0.1, 1}
+	pixel, ok := model.ProjectRectified(p)
+	if !ok || !closeTo(pixel.U, 418) || !closeTo(pixel.V, 286.5) {
+		t.Fatalf("expected (418, 286.5), but got %v", pixel)
+	}
+
+	ray := model.RectifiedRay(pixel)
+	expected := unit(p.X, p.Y, p.Z)
+	if !closeTo(ray.X, expected.X) || !closeTo(ray.Y, expected.Y) || !closeTo(ray.Z, expected.Z) {
+		t.Fatalf("expected %v, but got %v", expected, ray)
+	}
+
+	if !model.Contains(pixel) || model.Contains(Pixel{U: 640, V: 0}) {
+		t.Fatal("expected Contains to check the image bounds")
+	}
+}
+
+func TestInfoTopic(t *testing.T) {
+	topics := map[string]string{
+		"/camera/image_raw":            "/camera/camera_info",
+		"/stereo/left/image_rect":      "/stereo/left/camera_info",
+		"image_raw":                    "camera_info",
+		"/camera/image_raw/compressed": "/camera/image_raw/camera_info",
+	}
+
+	for image, expected := range topics {
+		if actual := InfoTopic(image); actual != expected {
+			t.Errorf("expected the info topic of %s to be %s, but got %s", image, expected, actual)
+		}
+	}
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+func appendFloat64s(b []byte, vs []float64) []byte {
+	for _, v := range vs {
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], math.Float64bits(v))
+	}
+	return b
+}
+
+func encodeCameraInfo(model *Model) []byte {
+	var b []byte
+	b = appendUint32(b, 0)
+	b = appendUint32(b, 1)
+	b = appendUint32(b, 0)
+	b = appendString(b, model.FrameID)
+	b = appendUint32(b, model.Height)
+	b = appendUint32(b, model.Width)
+	b = appendString(b, model.DistortionModel)
+	b = appendUint32(b, uint32(len(model.D)))
+	b = appendFloat64s(b, model.D)
+	b = appendFloat64s(b, model.K[:])
+	b = appendFloat64s(b, model.R[:])
+	return appendFloat64s(b, model.P[:])
+}
+
+func TestModels(t *testing.T) {
+	expected := testModel(DistortionPlumbBob, -0.25, 0.08, 0, 0, 0)
+	expected.FrameID = "camera_optical"
+	expected.Stamp = time.Unix(1, 0)
+
+	var buf bytes.Buffer
+	writer := rosbag.NewWriter(&buf)
+	conn, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+		Topic:                "/camera/camera_info",
+		Type:                 MessageType,
+		RawMessageDefinition: cameraInfoDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.WriteMessage(conn, time.Unix(1, 0), encodeCameraInfo(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := NewModels()
+	decoder := rosbag.NewDecoder(bytes.NewReader(buf.Bytes()))
+	err = decoder.Subscribe("/camera/camera_info", models.Add)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := models.ForImage("/camera/image_rect")
+	if model == nil {
+		t.Fatal("expected a model for /camera/image_rect")
+	}
+
+	if model.FrameID != expected.FrameID || model.K != expected.K || model.P != expected.P || len(model.D) != 5 || model.Width != 640 {
+		t.Fatalf("expected %+v, but got %+v", expected, model)
+	}
+
+	if models.ForFrame("camera_optical") != model || models.ForImage("/other/image_raw") != nil {
+		t.Fatal("expected the model to be found by its frame only")
+	}
+}
+
+func TestDecodePoints(t *testing.T) {
+	fields := []pointField{
+		{Name: "x", Offset: 0, Datatype: pointFieldFloat32},
+		{Name: "y", Offset: 4, Datatype: pointFieldFloat32},
+		{Name: "z", Offset: 8, Datatype: pointFieldFloat64},
+		{Name: "intensity", Offset: 16, Datatype: pointFieldUint8},
+	}
+
+	data := make([]byte, 2*20)
+	for i, p := range []Point{{1, 2, 3}, {-0.5, 0.25, 10}} {
+		b := data[i*20:]
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(p.X)))
+		binary.BigEndian.PutUint32(b[4:], math.Float32bits(float32(p.Y)))
+		binary.BigEndian.PutUint64(b[8:], math.Float64bits(p.Z))
+	}
+
+	points, err := decodePoints(fields, binary.BigEndian, 20, 2, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 2 || points[0] != (Point{1, 2, 3}) || points[1] != (Point{-0.5, 0.25, 10}) {
+		t.Fatalf("unexpected points %v", points)
+	}
+
+	if _, err = decodePoints(fields[:2], binary.BigEndian, 20, 2, data); err == nil {
+		t.Fatal("expected a cloud without z to fail")
+	}
+
+	if _, err = decodePoints(fields, binary.BigEndian, 20, 3, data); err == nil {
+		t.Fatal("expected a cloud with missing data to fail")
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	testCases := []struct {
+		encoding  string
+		bigEndian uint8
+		pixel     []byte
+		expected  color.Color
+	}{
+		{"mono8", 0, []byte{0x80}, color.Gray{Y: 0x80}},
+		{"mono16", 0, []byte{0x34, 0x12}, color.Gray16{Y: 0x1234}},
+		{"mono16", 1, []byte{0x12, 0x34}, color.Gray16{Y: 0x1234}},
+		{"bgr8", 0, []byte{1, 2, 3}, color.RGBA{R: 3, G: 2, B: 1, A: 0xff}},
+		{"rgba8", 0, []byte{1, 2, 3, 4}, color.RGBA{R: 1, G: 2, B: 3, A: 4}},
+	}
+
+	for _, testCase := range testCases {
+		img, ok := decodeImage(ImageType, map[string]interface{}{
+			"width":        uint32(1),
+			"height":       uint32(1),
+			"step":         uint32(len(testCase.pixel)),
+			"encoding":     testCase.encoding,
+			"is_bigendian": testCase.bigEndian,
+			"data":         testCase.pixel,
+		})
+		if !ok {
+			t.Fatalf("expected %s to be decoded", testCase.encoding)
+		}
+
+		if actual := img.At(0, 0); actual != testCase.expected {
+			t.Fatalf("expected %s to be %v, but got %v", testCase.encoding, testCase.expected, actual)
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"width": uint32(1), "height": uint32(1), "step": uint32(1), "encoding": "yuv422", "data": []uint8{0, 0}},
+		{"width": uint32(2), "height": uint32(1), "step": uint32(3), "encoding": "rgb8", "data": []uint8{0, 0, 0}},
+	} {
+		_, ok := decodeImage(ImageType, data)
+		if ok {
+			t.Fatalf("expected %v to not be decoded", data)
+		}
+	}
+}
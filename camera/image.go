@@ -0,0 +1,124 @@
+package camera
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+
+	"github.com/lherman-cs/go-rosbag"
+
+	// CompressedImage messages are either JPEG or PNG
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// The ROS types of the messages that are consumed by Image.
+const (
+	ImageType           = "sensor_msgs/Image"
+	CompressedImageType = "sensor_msgs/CompressedImage"
+)
+
+// ErrUnsupportedImage is returned by Image when the encoding or the format of the image is not
+// supported, or its data doesn't match its size.
+var ErrUnsupportedImage = errors.New("image is not supported")
+
+// IsImage reports whether the messages of msgType can be decoded by Image.
+func IsImage(msgType string) bool {
+	return msgType == ImageType || msgType == CompressedImageType
+}
+
+// Image decodes the sensor_msgs/Image or sensor_msgs/CompressedImage message in record. Images
+// with the mono8, mono16, rgb8, bgr8, rgba8, bgra8, and the matching OpenCV encodings are
+// supported, and so are JPEG and PNG compressed images. The image can be used after the record is
+// closed.
+func Image(record *rosbag.RecordMessageData) (image.Image, error) {
+	data := make(map[string]interface{})
+	err := record.ViewAs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img, ok := decodeImage(record.ConnectionHeader().Type, data)
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+	return img, nil
+}
+
+// decodeImage converts the fields of a sensor_msgs/Image or sensor_msgs/CompressedImage message to
+// an image. ok is false when the encoding or the format is not supported, or the data doesn't
+// match the size of the image.
+func decodeImage(msgType string, data map[string]interface{}) (img image.Image, ok bool) {
+	pixels, _ := data["data"].([]uint8)
+	if msgType == CompressedImageType {
+		img, _, err := image.Decode(bytes.NewReader(pixels))
+		return img, err == nil
+	}
+
+	width, _ := data["width"].(uint32)
+	height, _ := data["height"].(uint32)
+	step, _ := data["step"].(uint32)
+	encoding, _ := data["encoding"].(string)
+	bigEndian, _ := data["is_bigendian"].(uint8)
+
+	var channels int
+	switch encoding {
+	case "mono8", "8UC1":
+		channels = 1
+	case "mono16", "16UC1":
+		channels = 2
+	case "rgb8", "bgr8", "8UC3":
+		channels = 3
+	case "rgba8", "bgra8", "8UC4":
+		channels = 4
+	default:
+		return nil, false
+	}
+
+	if width == 0 || height == 0 || int(step) < int(width)*channels || len(pixels) < int(step)*int(height) {
+		return nil, false
+	}
+
+	rect := image.Rect(0, 0, int(width), int(height))
+	switch channels {
+	case 1:
+		gray := image.NewGray(rect)
+		for y := 0; y < rect.Dy(); y++ {
+			copy(gray.Pix[y*gray.Stride:], pixels[y*int(step):y*int(step)+rect.Dx()])
+		}
+		return gray, true
+	case 2:
+		gray := image.NewGray16(rect)
+		for y := 0; y < rect.Dy(); y++ {
+			row := pixels[y*int(step):]
+			for x := 0; x < rect.Dx(); x++ {
+				v := uint16(row[2*x])<<8 | uint16(row[2*x+1])
+				if bigEndian == 0 {
+					v = uint16(row[2*x+1])<<8 | uint16(row[2*x])
+				}
+				gray.SetGray16(x, y, color.Gray16{Y: v})
+			}
+		}
+		return gray, true
+	}
+
+	// OpenCV orders the channels of 8UC3 and 8UC4 as BGR
+	bgr := encoding == "bgr8" || encoding == "bgra8" || encoding == "8UC3" || encoding == "8UC4"
+	rgba := image.NewRGBA(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		row := pixels[y*int(step):]
+		for x := 0; x < rect.Dx(); x++ {
+			px := row[x*channels:]
+			c := color.RGBA{R: px[0], G: px[1], B: px[2], A: 0xff}
+			if bgr {
+				c.R, c.B = c.B, c.R
+			}
+			if channels == 4 {
+				c.A = px[3]
+			}
+			rgba.SetRGBA(x, y, c)
+		}
+	}
+	return rgba, true
+}
@@ -0,0 +1,180 @@
+package camera
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// PointCloudType is the ROS type of the messages that are consumed by PointCloud.
+const PointCloudType = "sensor_msgs/PointCloud2"
+
+// The datatypes of sensor_msgs/PointField.
+const (
+	pointFieldInt8    = 1
+	pointFieldUint8   = 2
+	pointFieldInt16   = 3
+	pointFieldUint16  = 4
+	pointFieldInt32   = 5
+	pointFieldUint32  = 6
+	pointFieldFloat32 = 7
+	pointFieldFloat64 = 8
+)
+
+var pointFieldSizes = map[uint8]uint32{
+	pointFieldInt8:    1,
+	pointFieldUint8:   1,
+	pointFieldInt16:   2,
+	pointFieldUint16:  2,
+	pointFieldInt32:   4,
+	pointFieldUint32:  4,
+	pointFieldFloat32: 4,
+	pointFieldFloat64: 8,
+}
+
+type pointField struct {
+	Name     string `rosbag:"name"`
+	Offset   uint32 `rosbag:"offset"`
+	Datatype uint8  `rosbag:"datatype"`
+}
+
+type pointCloud2 struct {
+	Header struct {
+		Stamp   time.Time `rosbag:"stamp"`
+		FrameID string    `rosbag:"frame_id,copy"`
+	} `rosbag:"header"`
+	Height      uint32       `rosbag:"height"`
+	Width       uint32       `rosbag:"width"`
+	Fields      []pointField `rosbag:"fields"`
+	IsBigEndian bool         `rosbag:"is_bigendian"`
+	PointStep   uint32       `rosbag:"point_step"`
+	Data        []uint8      `rosbag:"data"`
+}
+
+// PointCloud decodes the x, y, and z fields of the sensor_msgs/PointCloud2 message in record, and
+// returns the points with the frame of the cloud. Invalid points, which are NaN in non-dense
+// clouds, are kept, so the indices match the cloud. The points can be used after the record is
+// closed.
+func PointCloud(record *rosbag.RecordMessageData) (points []Point, frameID string, err error) {
+	var cloud pointCloud2
+	err = record.ViewAs(&cloud)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if cloud.IsBigEndian {
+		order = binary.BigEndian
+	}
+
+	points, err = decodePoints(cloud.Fields, order, cloud.PointStep, int(cloud.Width)*int(cloud.Height), cloud.Data)
+	if err != nil {
+		return nil, "", err
+	}
+	return points, cloud.Header.FrameID, nil
+}
+
+// PointCloudIntensity is like PointCloud, but also returns the intensity field of every point,
+// e.g. the reflectance of a lidar return. The intensities are 0 when the cloud doesn't have an
+// intensity field.
+func PointCloudIntensity(record *rosbag.RecordMessageData) (points []Point, intensities []float64, frameID string, err error) {
+	var cloud pointCloud2
+	err = record.ViewAs(&cloud)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if cloud.IsBigEndian {
+		order = binary.BigEndian
+	}
+
+	n := int(cloud.Width) * int(cloud.Height)
+	points, err = decodePoints(cloud.Fields, order, cloud.PointStep, n, cloud.Data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	intensities = make([]float64, n)
+	for _, field := range cloud.Fields {
+		size, ok := pointFieldSizes[field.Datatype]
+		if field.Name != "intensity" || !ok || field.Offset+size > cloud.PointStep {
+			continue
+		}
+
+		read := pointFieldReader(field.Offset, field.Datatype, order)
+		for i := range intensities {
+			intensities[i] = read(cloud.Data[i*int(cloud.PointStep):])
+		}
+	}
+	return points, intensities, cloud.Header.FrameID, nil
+}
+
+func decodePoints(fields []pointField, order binary.ByteOrder, step uint32, n int, data []byte) ([]Point, error) {
+	var readers [3]func(b []byte) float64
+	for _, field := range fields {
+		var i int
+		switch field.Name {
+		case "x":
+			i = 0
+		case "y":
+			i = 1
+		case "z":
+			i = 2
+		default:
+			continue
+		}
+
+		size, ok := pointFieldSizes[field.Datatype]
+		if !ok {
+			return nil, fmt.Errorf("field %s has an unknown datatype %d", field.Name, field.Datatype)
+		}
+
+		if field.Offset+size > step {
+			return nil, fmt.Errorf("field %s at %d doesn't fit in the point step %d", field.Name, field.Offset, step)
+		}
+
+		readers[i] = pointFieldReader(field.Offset, field.Datatype, order)
+	}
+
+	for i, name := range []string{"x", "y", "z"} {
+		if readers[i] == nil {
+			return nil, fmt.Errorf("point cloud doesn't have the %s field", name)
+		}
+	}
+
+	if uint64(len(data)) < uint64(n)*uint64(step) {
+		return nil, fmt.Errorf("point cloud has %d points of %d bytes, but only %d bytes of data", n, step, len(data))
+	}
+
+	points := make([]Point, n)
+	for i := range points {
+		b := data[i*int(step):]
+		points[i] = Point{X: readers[0](b), Y: readers[1](b), Z: readers[2](b)}
+	}
+	return points, nil
+}
+
+func pointFieldReader(off uint32, datatype uint8, order binary.ByteOrder) func(b []byte) float64 {
+	switch datatype {
+	case pointFieldInt8:
+		return func(b []byte) float64 { return float64(int8(b[off])) }
+	case pointFieldUint8:
+		return func(b []byte) float64 { return float64(b[off]) }
+	case pointFieldInt16:
+		return func(b []byte) float64 { return float64(int16(order.Uint16(b[off:]))) }
+	case pointFieldUint16:
+		return func(b []byte) float64 { return float64(order.Uint16(b[off:])) }
+	case pointFieldInt32:
+		return func(b []byte) float64 { return float64(int32(order.Uint32(b[off:]))) }
+	case pointFieldUint32:
+		return func(b []byte) float64 { return float64(order.Uint32(b[off:])) }
+	case pointFieldFloat32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b[off:]))) }
+	default:
+		return func(b []byte) float64 { return math.Float64frombits(order.Uint64(b[off:])) }
+	}
+}
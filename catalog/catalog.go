@@ -0,0 +1,384 @@
+// Package catalog maintains a local index of the bags under storage roots in a SQLite database, so
+// bags can be found by their time ranges, topics, types, and sizes without opening them, e.g. the
+// bags that have /front_lidar from last Tuesday. The index is updated incrementally, a bag is only
+// read again when its size or modification time changes.
+package catalog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+
+	// the driver of the catalog database
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS bags (
+	path TEXT PRIMARY KEY,
+	size INTEGER NOT NULL,
+	mod_time INTEGER NOT NULL,
+	start_time REAL NOT NULL,
+	end_time REAL NOT NULL,
+	messages INTEGER NOT NULL,
+	info TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bags_time ON bags (start_time, end_time);
+CREATE TABLE IF NOT EXISTS topics (
+	path TEXT NOT NULL,
+	topic TEXT NOT NULL,
+	type TEXT NOT NULL,
+	messages INTEGER NOT NULL,
+	PRIMARY KEY (path, topic)
+);
+CREATE INDEX IF NOT EXISTS topics_topic ON topics (topic);
+CREATE INDEX IF NOT EXISTS topics_type ON topics (type);
+`
+
+// Catalog is an index of bags in a SQLite database. The database has a bags table with the
+// path, size, modification time, start and end times in Unix seconds, message count, and the
+// rosbag.BagInfo as JSON of every bag, and a topics table with the path, topic, type, and message
+// count of every topic of a bag, so it can also be queried with SQL directly.
+type Catalog struct {
+	db *sql.DB
+}
+
+// Open opens the catalog database at path, and creates it when it doesn't exist.
+func Open(path string) (*Catalog, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only has one writer anyway, and in-memory databases are per connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the catalog database.
+func (catalog *Catalog) Close() error {
+	return catalog.db.Close()
+}
+
+// ScanError is a bag that can't be indexed, e.g. because it's still being recorded.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+func (err *ScanError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Path, err.Err)
+}
+
+func (err *ScanError) Unwrap() error {
+	return err.Err
+}
+
+// ScanStats counts the changes of a Scan.
+type ScanStats struct {
+	// Added and Updated bags have been read, and Unchanged bags have been skipped
+	Added     int
+	Updated   int
+	Unchanged int
+	// Removed bags don't exist under the roots anymore
+	Removed int
+	// Failed bags can't be read, they're not in the catalog, and they're tried again by the next
+	// scan
+	Failed []*ScanError
+}
+
+// Scan walks roots, and indexes every .bag file under them. The bags whose size and modification
+// time haven't changed since the previous scan are skipped, and the bags under roots that don't
+// exist anymore are removed. A bag that can't be read doesn't stop the scan, it's reported in
+// ScanStats.Failed instead.
+func (catalog *Catalog) Scan(roots ...string) (*ScanStats, error) {
+	var stats ScanStats
+	seen := make(map[string]bool)
+	absRoots := make([]string, len(roots))
+	for i, root := range roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		absRoots[i] = root
+
+		err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() || filepath.Ext(path) != ".bag" {
+				return nil
+			}
+
+			seen[path] = true
+			return catalog.scanBag(path, fi, &stats)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := catalog.db.Query("SELECT path FROM bags")
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if !seen[path] && underRoots(path, absRoots) {
+			removed = append(removed, path)
+		}
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range removed {
+		err = catalog.remove(path)
+		if err != nil {
+			return nil, err
+		}
+		stats.Removed++
+	}
+	return &stats, nil
+}
+
+func underRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanBag indexes the bag at path unless it hasn't changed.
+func (catalog *Catalog) scanBag(path string, fi os.FileInfo, stats *ScanStats) error {
+	var size, modTime int64
+	err := catalog.db.QueryRow("SELECT size, mod_time FROM bags WHERE path = ?", path).Scan(&size, &modTime)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if exists && size == fi.Size() && modTime == fi.ModTime().UnixNano() {
+		stats.Unchanged++
+		return nil
+	}
+
+	info, err := readInfo(path)
+	if err != nil {
+		stats.Failed = append(stats.Failed, &ScanError{Path: path, Err: err})
+		if exists {
+			return catalog.remove(path)
+		}
+		return nil
+	}
+
+	err = catalog.put(info, fi)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		stats.Updated++
+	} else {
+		stats.Added++
+	}
+	return nil
+}
+
+func readInfo(path string) (*rosbag.BagInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := rosbag.ReadInfo(rosbag.NewDecoder(f))
+	if err != nil {
+		return nil, err
+	}
+
+	info.Path = path
+	return info, nil
+}
+
+// put replaces the bag of info in the catalog.
+func (catalog *Catalog) put(info *rosbag.BagInfo, fi os.FileInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	tx, err := catalog.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM topics WHERE path = ?", info.Path)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO bags (path, size, mod_time, start_time, end_time, messages, info) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		info.Path, fi.Size(), fi.ModTime().UnixNano(), info.Start, info.End, info.Messages, string(b))
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range info.Topics {
+		_, err = tx.Exec("INSERT INTO topics (path, topic, type, messages) VALUES (?, ?, ?, ?)",
+			info.Path, topic.Topic, topic.Type, topic.Messages)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (catalog *Catalog) remove(path string) error {
+	tx, err := catalog.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM topics WHERE path = ?", path)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM bags WHERE path = ?", path)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Query selects bags in a catalog. The zero values of the fields don't restrict the bags.
+type Query struct {
+	// Topic and Type are patterns that a topic of a bag, and its type must match, see
+	// rosbag.Pattern, e.g. "/front_lidar" or "sensor_msgs/*"
+	Topic string
+	Type  string
+	// Start and End select the bags whose messages overlap [Start, End)
+	Start time.Time
+	End   time.Time
+	// MinSize and MaxSize are the bounds of the sizes of the bags in bytes
+	MinSize int64
+	MaxSize int64
+}
+
+// Find returns the bags that match query, sorted by their start times.
+func (catalog *Catalog) Find(query Query) ([]*rosbag.BagInfo, error) {
+	var topic, typ *rosbag.Pattern
+	var err error
+	if query.Topic != "" {
+		topic, err = rosbag.CompilePattern(query.Topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Type != "" {
+		typ, err = rosbag.CompilePattern(query.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var conditions []string
+	var args []interface{}
+	if !query.Start.IsZero() {
+		conditions = append(conditions, "end_time >= ?")
+		args = append(args, toSeconds(query.Start))
+	}
+
+	if !query.End.IsZero() {
+		conditions = append(conditions, "start_time < ?")
+		args = append(args, toSeconds(query.End))
+	}
+
+	if query.MinSize > 0 {
+		conditions = append(conditions, "size >= ?")
+		args = append(args, query.MinSize)
+	}
+
+	if query.MaxSize > 0 {
+		conditions = append(conditions, "size <= ?")
+		args = append(args, query.MaxSize)
+	}
+
+	stmt := "SELECT info FROM bags"
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := catalog.db.Query(stmt+" ORDER BY start_time, path", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bags []*rosbag.BagInfo
+	for rows.Next() {
+		var b string
+		err = rows.Scan(&b)
+		if err != nil {
+			return nil, err
+		}
+
+		var info rosbag.BagInfo
+		err = json.Unmarshal([]byte(b), &info)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasTopic(&info, topic, typ) {
+			bags = append(bags, &info)
+		}
+	}
+	return bags, rows.Err()
+}
+
+// hasTopic reports whether info has a topic that matches topic and typ, which match any topic
+// when they're nil.
+func hasTopic(info *rosbag.BagInfo, topic, typ *rosbag.Pattern) bool {
+	if topic == nil && typ == nil {
+		return true
+	}
+
+	for _, t := range info.Topics {
+		if (topic == nil || topic.Match(t.Topic)) && (typ == nil || typ.Match(t.Type)) {
+			return true
+		}
+	}
+	return false
+}
+
+func toSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+// writeTestBag writes a bag at path with a message on topic at every second of seconds.
+func writeTestBag(t *testing.T, path, topic, typ string, seconds ...int64) {
+	builder := rosbagtest.NewBuilder(t)
+	conn := builder.Connection(topic, typ, "uint8 x\n")
+	for _, sec := range seconds {
+		builder.Message(conn, time.Unix(sec, 0), []byte{uint8(sec)})
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, builder.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestCatalog(t *testing.T) *Catalog {
+	catalog, err := Open(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { catalog.Close() })
+	return catalog
+}
+
+func findPaths(t *testing.T, catalog *Catalog, query Query) []string {
+	bags, err := catalog.Find(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, bag := range bags {
+		paths = append(paths, filepath.Base(bag.Path))
+	}
+	return paths
+}
+
+func TestCatalog(t *testing.T) {
+	root := t.TempDir()
+	writeTestBag(t, filepath.Join(root, "lidar.bag"), "/front_lidar", "sensor_msgs/PointCloud2", 10, 11, 12)
+	writeTestBag(t, filepath.Join(root, "day2", "imu.bag"), "/imu", "sensor_msgs/Imu", 20, 21, 22, 23, 24, 25, 26, 27)
+	writeTestBag(t, filepath.Join(root, "day2", "odom.bag"), "/odom", "nav_msgs/Odometry", 30)
+	err := ioutil.WriteFile(filepath.Join(root, "notes.txt"), []byte("not a bag"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, "broken.bag"), []byte("#ROSBAG V2.0\n\x01"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := openTestCatalog(t)
+	stats, err := catalog.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Added != 3 || len(stats.Failed) != 1 || filepath.Base(stats.Failed[0].Path) != "broken.bag" {
+		t.Fatalf("unexpected stats of the first scan: %+v", stats)
+	}
+
+	imu, err := os.Stat(filepath.Join(root, "day2", "imu.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name     string
+		Query    Query
+		Expected []string
+	}{
+		{
+			Name:     "All",
+			Expected: []string{"lidar.bag", "imu.bag", "odom.bag"},
+		},
+		{
+			Name:     "Topic",
+			Query:    Query{Topic: "/front_lidar"},
+			Expected: []string{"lidar.bag"},
+		},
+		{
+			Name:     "Type",
+			Query:    Query{Type: "sensor_msgs/*"},
+			Expected: []string{"lidar.bag", "imu.bag"},
+		},
+		{
+			Name:     "Topic And Type",
+			Query:    Query{Topic: "/odom", Type: "sensor_msgs/*"},
+			Expected: nil,
+		},
+		{
+			Name:     "Time",
+			Query:    Query{Start: time.Unix(12, 0), End: time.Unix(30, 0)},
+			Expected: []string{"lidar.bag", "imu.bag"},
+		},
+		{
+			Name:     "Size",
+			Query:    Query{MinSize: imu.Size()},
+			Expected: []string{"imu.bag"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			if diff := cmp.Diff(testCase.Expected, findPaths(t, catalog, testCase.Query)); diff != "" {
+				t.Fatalf("bags are not matched:\n\n%s", diff)
+			}
+		})
+	}
+
+	bags, err := catalog.Find(Query{Topic: "/imu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bags) != 1 || bags[0].Messages != 8 || bags[0].Start != 20 || bags[0].End != 27 {
+		t.Fatalf("unexpected info of the bag: %+v", bags)
+	}
+}
+
+func TestCatalogRescan(t *testing.T) {
+	root := t.TempDir()
+	writeTestBag(t, filepath.Join(root, "a.bag"), "/a", "std_msgs/UInt8", 1)
+	writeTestBag(t, filepath.Join(root, "b.bag"), "/b", "std_msgs/UInt8", 2)
+	other := t.TempDir()
+	writeTestBag(t, filepath.Join(other, "c.bag"), "/c", "std_msgs/UInt8", 3)
+
+	catalog := openTestCatalog(t)
+	_, err := catalog.Scan(root, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := catalog.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&ScanStats{Unchanged: 2}, stats); diff != "" {
+		t.Fatalf("stats are not matched:\n\n%s", diff)
+	}
+
+	err = os.Remove(filepath.Join(root, "a.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestBag(t, filepath.Join(root, "b.bag"), "/b", "std_msgs/UInt8", 2, 4)
+	stats, err = catalog.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&ScanStats{Updated: 1, Removed: 1}, stats); diff != "" {
+		t.Fatalf("stats are not matched:\n\n%s", diff)
+	}
+
+	// the bags of the other root are kept
+	if diff := cmp.Diff([]string{"b.bag", "c.bag"}, findPaths(t, catalog, Query{})); diff != "" {
+		t.Fatalf("bags are not matched:\n\n%s", diff)
+	}
+
+	bags, err := catalog.Find(Query{Topic: "/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bags) != 1 || bags[0].Messages != 2 {
+		t.Fatalf("expected the updated bag to be read again, but got %+v", bags)
+	}
+}
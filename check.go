@@ -0,0 +1,504 @@
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// The checks that are run by Check. They're the values of Finding.Check.
+const (
+	// CheckVersion checks that the bag starts with the supported version line
+	CheckVersion = "version"
+	// CheckStructure checks that the records are complete, and have the fields that their op needs
+	CheckStructure = "structure"
+	// CheckChunk checks that the chunks can be decompressed to their size
+	CheckChunk = "chunk"
+	// CheckMD5 checks the md5sums of the connections against their message definitions, and that
+	// the connections of a type agree on its md5sum
+	CheckMD5 = "md5"
+	// CheckIndex checks the bag header, the index data records, and the index section against the
+	// chunks
+	CheckIndex = "index"
+	// CheckTime checks that the message times of every connection don't go back in time
+	CheckTime = "time"
+)
+
+// Finding is a problem that is found by Check.
+type Finding struct {
+	Check string `json:"check"`
+	// Offset is the offset in bytes of the top-level record that has the problem. It's -1 when the
+	// problem is not about a single record.
+	Offset  int64  `json:"offset"`
+	Message string `json:"message"`
+}
+
+func (finding Finding) String() string {
+	if finding.Offset < 0 {
+		return fmt.Sprintf("%s: %s", finding.Check, finding.Message)
+	}
+	return fmt.Sprintf("%s: offset %d: %s", finding.Check, finding.Offset, finding.Message)
+}
+
+type checkedMessage struct {
+	conn uint32
+	time time.Time
+}
+
+type checkedChunk struct {
+	start, end time.Time
+	counts     map[uint32]uint32
+	// messages are the message data records in the chunk by their offset in the chunk data
+	messages map[uint32]checkedMessage
+	// indexed are the connections that have an index data record after the chunk
+	indexed map[uint32]bool
+}
+
+type checker struct {
+	findings []Finding
+	// offset is the offset of the current top-level record
+	offset     int64
+	conns      map[uint32]*ConnectionHeader
+	md5Sums    map[string]string
+	lastTimes  map[uint32]time.Time
+	chunks     map[int64]*checkedChunk
+	lastChunk  *checkedChunk
+	chunkInfos map[int64]bool
+	// indexStart is the offset of the first record of the index section, it's -1 until the first
+	// connection or chunk info record after the chunks
+	indexStart int64
+	bagHeader  *RecordBagHeader
+}
+
+// Check reads the whole bag from r, and validates the version, the record structure, the chunk
+// sizes, the md5sums, the index data records, the index section, and the message times. Every
+// problem is reported as a Finding, so a bag is valid when there are no findings. The error is
+// only for the errors of r, a bag that ends in the middle of a record is a finding.
+func Check(r io.Reader) ([]Finding, error) {
+	c := checker{
+		conns:      make(map[uint32]*ConnectionHeader),
+		md5Sums:    make(map[string]string),
+		lastTimes:  make(map[uint32]time.Time),
+		chunks:     make(map[int64]*checkedChunk),
+		chunkInfos: make(map[int64]bool),
+		indexStart: -1,
+	}
+
+	err := c.check(&checkReader{reader: bufio.NewReader(r)})
+	if err != nil {
+		return nil, err
+	}
+	return c.findings, nil
+}
+
+func (c *checker) report(check string, format string, args ...interface{}) {
+	c.findings = append(c.findings, Finding{Check: check, Offset: c.offset, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) reportBag(check string, format string, args ...interface{}) {
+	c.findings = append(c.findings, Finding{Check: check, Offset: -1, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkReader keeps the errors of the underlying reader apart from the problems of the bag.
+type checkReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *checkReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (c *checker) check(r *checkReader) error {
+	var version Version
+	_, err := fmt.Fscanf(r, versionFormat, &version.Major, &version.Minor)
+	if err != nil {
+		c.reportBag(CheckVersion, "the bag doesn't start with a version line: %v", err)
+		return nil
+	}
+
+	if version != supportedVersion {
+		c.reportBag(CheckVersion, "%s", &UnsupportedVersionError{Version: version})
+		return nil
+	}
+	c.offset = int64(len(fmt.Sprintf(versionFormat, version.Major, version.Minor)))
+
+	for {
+		record, op, err := readRawRecord(r)
+		if err == io.EOF {
+			break
+		}
+
+		if r.err != nil {
+			return r.err
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			c.report(CheckStructure, "the bag is truncated in the middle of the record")
+			return nil
+		}
+
+		// the record can't be skipped when its header is invalid
+		if err != nil {
+			c.report(CheckStructure, "%v", err)
+			return nil
+		}
+
+		err = c.checkRecord(record, op)
+		if err != nil {
+			c.report(CheckStructure, "%v", err)
+		}
+		c.offset += int64(len(record.Raw))
+	}
+
+	c.checkBagHeader()
+	return nil
+}
+
+func (c *checker) checkRecord(record *RecordBase, op Op) error {
+	switch op {
+	case OpBagHeader:
+		if c.bagHeader != nil {
+			return fmt.Errorf("the bag has more than one bag header record")
+		}
+		c.bagHeader = &RecordBagHeader{RecordBase: record}
+	case OpChunk:
+		c.lastChunk = nil
+		if c.indexStart >= 0 {
+			c.report(CheckIndex, "the chunk is after the index section")
+		}
+		return c.checkChunk(&RecordChunk{RecordBase: record})
+	case OpIndexData:
+		return c.checkIndexData(&RecordIndexData{RecordBase: record})
+	case OpConnection:
+		c.lastChunk = nil
+		if c.indexStart < 0 {
+			c.indexStart = c.offset
+		}
+		return c.checkIndexConnection(&RecordConnection{RecordBase: record})
+	case OpChunkInfo:
+		c.lastChunk = nil
+		if c.indexStart < 0 {
+			c.indexStart = c.offset
+		}
+		return c.checkChunkInfo(&RecordChunkInfo{RecordBase: record})
+	case OpMessageData:
+		return fmt.Errorf("message data record is outside of a chunk")
+	default:
+		if _, ok := registeredOp(op); !ok {
+			return fmt.Errorf("unknown op %d", op)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkChunk(record *RecordChunk) error {
+	compression, err := record.Compression()
+	if err != nil {
+		return err
+	}
+
+	size, err := record.Size()
+	if err != nil {
+		return err
+	}
+
+	data := record.Data()
+	if compression != CompressionNone {
+		var r io.Reader
+		r, err = newChunkReader(compression, bytes.NewReader(data))
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+
+		if err != nil {
+			c.report(CheckChunk, "the chunk can't be decompressed with %s: %v", compression, err)
+			return nil
+		}
+	}
+
+	if uint32(len(data)) != size {
+		c.report(CheckChunk, "the chunk size is %d, but its data is %d bytes", size, len(data))
+	}
+
+	chunk := checkedChunk{
+		counts:   make(map[uint32]uint32),
+		messages: make(map[uint32]checkedMessage),
+		indexed:  make(map[uint32]bool),
+	}
+	c.chunks[c.offset] = &chunk
+	c.lastChunk = &chunk
+
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		off := uint32(len(data) - r.Len())
+		inner, op, err := readRawRecord(r)
+		if err != nil {
+			return fmt.Errorf("the record at %d of the chunk data is invalid: %w", off, unexpectedEOF(err))
+		}
+
+		switch op {
+		case OpConnection:
+			err = c.checkConnection(&RecordConnection{RecordBase: inner})
+		case OpMessageData:
+			err = c.checkMessageData(&chunk, off, &RecordMessageData{RecordBase: inner})
+		default:
+			if _, ok := registeredOp(op); !ok {
+				err = fmt.Errorf("unexpected op %d in the chunk data", op)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("the record at %d of the chunk data is invalid: %w", off, err)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkConnection(record *RecordConnection) error {
+	conn, err := record.Conn()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := record.ConnectionHeader()
+	if err != nil {
+		return err
+	}
+
+	if hdr.Topic == "" {
+		hdr.Topic, err = record.Topic()
+		if err != nil {
+			return err
+		}
+	}
+
+	if prev, ok := c.conns[conn]; ok {
+		if prev.Topic != hdr.Topic || prev.Type != hdr.Type || prev.MD5Sum != hdr.MD5Sum {
+			c.report(CheckIndex, "connection %d is redefined from %s on %s to %s on %s", conn, prev.Type, prev.Topic, hdr.Type, hdr.Topic)
+		}
+		return nil
+	}
+	c.conns[conn] = hdr
+
+	// * is used by tools that accept any type
+	if hdr.MD5Sum == "*" {
+		return nil
+	}
+
+	if sum, ok := c.md5Sums[hdr.Type]; ok && sum != hdr.MD5Sum {
+		c.report(CheckMD5, "connection %d has the md5sum %s for %s, but another connection has %s", conn, hdr.MD5Sum, hdr.Type, sum)
+	}
+	c.md5Sums[hdr.Type] = hdr.MD5Sum
+
+	if len(hdr.MessageDefinition.Fields) > 0 || len(hdr.MessageDefinition.Constants) > 0 {
+		if sum := hdr.MessageDefinition.MD5Sum(); sum != hdr.MD5Sum {
+			c.report(CheckMD5, "connection %d has the md5sum %s for %s, but its message definition has %s", conn, hdr.MD5Sum, hdr.Type, sum)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkMessageData(chunk *checkedChunk, off uint32, record *RecordMessageData) error {
+	conn, err := record.Conn()
+	if err != nil {
+		return err
+	}
+
+	t, err := record.Time()
+	if err != nil {
+		return err
+	}
+
+	hdr, ok := c.conns[conn]
+	if !ok {
+		return fmt.Errorf("message data record of connection %d is before its connection record", conn)
+	}
+
+	if last, ok := c.lastTimes[conn]; ok && t.Before(last) {
+		c.report(CheckTime, "a message on %s at %s is after a message at %s", hdr.Topic, t, last)
+	}
+	c.lastTimes[conn] = t
+
+	if len(chunk.messages) == 0 || t.Before(chunk.start) {
+		chunk.start = t
+	}
+	if t.After(chunk.end) {
+		chunk.end = t
+	}
+	chunk.counts[conn]++
+	chunk.messages[off] = checkedMessage{conn: conn, time: t}
+	return nil
+}
+
+func (c *checker) checkIndexData(record *RecordIndexData) error {
+	if c.lastChunk == nil {
+		c.report(CheckIndex, "the index data record doesn't follow a chunk")
+		return nil
+	}
+	chunk := c.lastChunk
+
+	conn, err := record.Conn()
+	if err != nil {
+		return err
+	}
+
+	count, err := record.Count()
+	if err != nil {
+		return err
+	}
+
+	data := record.Data()
+	if uint64(len(data)) != uint64(count)*indexEntrySize {
+		c.report(CheckIndex, "the index data record of connection %d has %d entries, but its data is %d bytes", conn, count, len(data))
+		return nil
+	}
+
+	if chunk.indexed[conn] {
+		c.report(CheckIndex, "connection %d has more than one index data record for the chunk", conn)
+	}
+	chunk.indexed[conn] = true
+
+	if count != chunk.counts[conn] {
+		c.report(CheckIndex, "the index data record of connection %d has %d entries, but the chunk has %d messages", conn, count, chunk.counts[conn])
+	}
+
+	for ; len(data) > 0; data = data[indexEntrySize:] {
+		t := extractTime(data)
+		off := endian.Uint32(data[8:])
+		msg, ok := chunk.messages[off]
+		if !ok || msg.conn != conn || !msg.time.Equal(t) {
+			c.report(CheckIndex, "the index data record of connection %d points to offset %d at %s, which is not a message of the connection at that time", conn, off, t)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkIndexConnection(record *RecordConnection) error {
+	conn, err := record.Conn()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.conns[conn]; !ok {
+		c.report(CheckIndex, "connection %d is in the index section, but not in any chunk", conn)
+	}
+	return c.checkConnection(record)
+}
+
+func (c *checker) checkChunkInfo(record *RecordChunkInfo) error {
+	pos, err := record.ChunkPos()
+	if err != nil {
+		return err
+	}
+
+	start, err := record.StartTime()
+	if err != nil {
+		return err
+	}
+
+	end, err := record.EndTime()
+	if err != nil {
+		return err
+	}
+
+	counts, err := record.MessageCounts()
+	if err != nil {
+		return err
+	}
+
+	chunk, ok := c.chunks[int64(pos)]
+	if !ok {
+		c.report(CheckIndex, "the chunk info points to %d, which is not a chunk", pos)
+		return nil
+	}
+	c.chunkInfos[int64(pos)] = true
+
+	if len(chunk.messages) > 0 && (!start.Equal(chunk.start) || !end.Equal(chunk.end)) {
+		c.report(CheckIndex, "the chunk info of the chunk at %d is from %s to %s, but the messages are from %s to %s", pos, start, end, chunk.start, chunk.end)
+	}
+
+	if len(counts) != len(chunk.counts) {
+		c.report(CheckIndex, "the chunk info of the chunk at %d has %d connections, but the chunk has %d", pos, len(counts), len(chunk.counts))
+		return nil
+	}
+
+	for conn, count := range counts {
+		if chunk.counts[conn] != count {
+			c.report(CheckIndex, "the chunk info of the chunk at %d has %d messages on connection %d, but the chunk has %d", pos, count, conn, chunk.counts[conn])
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkBagHeader() {
+	// the index section of a bag without connections and chunks is empty, it starts at the end
+	indexStart := c.indexStart
+	if indexStart < 0 {
+		indexStart = c.offset
+	}
+
+	c.offset = -1
+	if c.bagHeader == nil {
+		c.reportBag(CheckStructure, "the bag doesn't have a bag header record")
+		return
+	}
+
+	indexPos, err := c.bagHeader.IndexPos()
+	if err != nil {
+		c.reportBag(CheckStructure, "the bag header is invalid: %v", err)
+		return
+	}
+
+	// the bag is still being recorded, or it has been written without an index
+	if indexPos == 0 {
+		return
+	}
+
+	if int64(indexPos) != indexStart {
+		c.reportBag(CheckIndex, "the bag header points to the index section at %d, but it starts at %d", indexPos, indexStart)
+	}
+
+	connCount, err := c.bagHeader.ConnCount()
+	if err != nil {
+		c.reportBag(CheckStructure, "the bag header is invalid: %v", err)
+		return
+	}
+
+	chunkCount, err := c.bagHeader.ChunkCount()
+	if err != nil {
+		c.reportBag(CheckStructure, "the bag header is invalid: %v", err)
+		return
+	}
+
+	if int(connCount) != len(c.conns) {
+		c.reportBag(CheckIndex, "the bag header has %d connections, but the bag has %d", connCount, len(c.conns))
+	}
+
+	if int(chunkCount) != len(c.chunks) {
+		c.reportBag(CheckIndex, "the bag header has %d chunks, but the bag has %d", chunkCount, len(c.chunks))
+	}
+
+	positions := make([]int64, 0, len(c.chunks))
+	for pos := range c.chunks {
+		if !c.chunkInfos[pos] {
+			positions = append(positions, pos)
+		}
+	}
+
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i] < positions[j]
+	})
+	for _, pos := range positions {
+		c.reportBag(CheckIndex, "the chunk at %d doesn't have a chunk info", pos)
+	}
+}
@@ -0,0 +1,89 @@
+package rosbag
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkFindings(t *testing.T, raw []byte) []Finding {
+	findings, err := Check(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return findings
+}
+
+func expectFinding(t *testing.T, findings []Finding, check string, msg string) {
+	for _, finding := range findings {
+		if finding.Check == check && strings.Contains(finding.Message, msg) {
+			return
+		}
+	}
+	t.Fatalf("expected a %s finding with %q, but got %v", check, msg, findings)
+}
+
+func TestCheckExampleBag(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if findings := checkFindings(t, raw); len(findings) != 0 {
+		t.Fatalf("expected no findings, but got %v", findings)
+	}
+
+	findings := checkFindings(t, raw[:len(raw)/2])
+	expectFinding(t, findings, CheckStructure, "truncated")
+
+	// bump chunk_count in the bag header
+	corrupted := append([]byte(nil), raw...)
+	idx := bytes.Index(corrupted, []byte("chunk_count="))
+	if idx < 0 {
+		t.Fatal("expected the bag header to have chunk_count")
+	}
+	corrupted[idx+len("chunk_count=")]++
+	findings = checkFindings(t, corrupted)
+	expectFinding(t, findings, CheckIndex, "chunks, but the bag has 1")
+}
+
+func TestCheck(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		MD5Sum:               "0123456789abcdef0123456789abcdef",
+		RawMessageDefinition: "uint8 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sec := range []int64{2, 1} {
+		err = writer.WriteMessage(conn, time.Unix(sec, 0), []byte{uint8(sec)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := checkFindings(t, buf.Bytes())
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, but got %v", findings)
+	}
+	expectFinding(t, findings, CheckMD5, "its message definition has")
+	expectFinding(t, findings, CheckTime, "/test")
+
+	findings = checkFindings(t, []byte("#ROSBAG V1.2\n"))
+	expectFinding(t, findings, CheckVersion, "not supported")
+}
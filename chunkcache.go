@@ -0,0 +1,56 @@
+package rosbag
+
+import (
+	"container/list"
+)
+
+const (
+	defaultChunkCacheSize = 64 << 20
+)
+
+// chunkCache keeps the most recently used decompressed chunks of a Bag until their total size
+// exceeds capacity. The most recently used chunk is always kept, even when it's larger than
+// capacity, so reading the messages of a chunk in order decompresses it once.
+type chunkCache struct {
+	capacity int64
+	size     int64
+	// lru has the cached chunks from the most recently used to the least recently used
+	lru    *list.List
+	chunks map[int]*list.Element
+}
+
+type cachedChunk struct {
+	index int
+	data  []byte
+}
+
+func newChunkCache(capacity int64) *chunkCache {
+	return &chunkCache{
+		capacity: capacity,
+		lru:      list.New(),
+		chunks:   make(map[int]*list.Element),
+	}
+}
+
+// get returns the data of the chunk at index i, and marks it as the most recently used.
+func (cache *chunkCache) get(i int) ([]byte, bool) {
+	elem, ok := cache.chunks[i]
+	if !ok {
+		return nil, false
+	}
+
+	cache.lru.MoveToFront(elem)
+	return elem.Value.(*cachedChunk).data, true
+}
+
+// add caches data as the chunk at index i, and evicts the least recently used chunks until the
+// cache fits in its capacity.
+func (cache *chunkCache) add(i int, data []byte) {
+	cache.chunks[i] = cache.lru.PushFront(&cachedChunk{index: i, data: data})
+	cache.size += int64(len(data))
+	for cache.size > cache.capacity && cache.lru.Len() > 1 {
+		oldest := cache.lru.Remove(cache.lru.Back()).(*cachedChunk)
+		delete(cache.chunks, oldest.index)
+		cache.size -= int64(len(oldest.data))
+	}
+}
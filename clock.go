@@ -0,0 +1,59 @@
+package rosbag
+
+import (
+	"time"
+)
+
+// ClockCorrection corrects the times of a sensor whose clock isn't synchronized with the others.
+// A time t is corrected to t + Offset + Drift * (t - Reference), so Offset fixes a constant
+// offset, and Drift fixes a clock that runs at a different rate, e.g. a Drift of -1e-6 fixes a
+// clock that gains 1 µs every second since Reference.
+type ClockCorrection struct {
+	Offset    time.Duration
+	Drift     float64
+	Reference time.Time
+}
+
+// Apply returns the corrected t.
+func (correction *ClockCorrection) Apply(t time.Time) time.Time {
+	corrected := t.Add(correction.Offset)
+	if correction.Drift != 0 {
+		elapsed := t.Sub(correction.Reference).Seconds()
+		corrected = corrected.Add(time.Duration(correction.Drift * elapsed * float64(time.Second)))
+	}
+	return corrected
+}
+
+// WithClockCorrection corrects the record times, and the std_msgs/Header stamps, of the messages
+// of every topic that doesn't have its own correction from WithTopicClockCorrection. Zero stamps
+// are kept since they mean that the stamp is not set. The stamps are corrected before
+// WithHeaderStampTime takes them as the record times.
+func WithClockCorrection(correction ClockCorrection) WriterOption {
+	return func(config *writerConfig) {
+		config.clock = &correction
+	}
+}
+
+// WithTopicClockCorrection corrects the times of the messages of topic like WithClockCorrection,
+// e.g. for the topics of a sensor with its own clock.
+func WithTopicClockCorrection(topic string, correction ClockCorrection) WriterOption {
+	return func(config *writerConfig) {
+		if config.topicClocks == nil {
+			config.topicClocks = make(map[string]*ClockCorrection)
+		}
+		config.topicClocks[topic] = &correction
+	}
+}
+
+// correctHeaderStamp returns a copy of data with the stamp of its std_msgs/Header corrected. data
+// is returned as is when it's too short, or the stamp is zero.
+func (correction *ClockCorrection) correctHeaderStamp(data []byte) []byte {
+	if len(data) < 12 || (endian.Uint32(data[4:]) == 0 && endian.Uint32(data[8:]) == 0) {
+		return data
+	}
+
+	// data belongs to the caller of WriteMessage
+	corrected := append([]byte(nil), data...)
+	copy(corrected[4:], timeField(correction.Apply(extractTime(data[4:]))))
+	return corrected
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// newChunkCounter counts the chunks that are new to the store.
+type newChunkCounter struct {
+	rosbag.ChunkStore
+	stored int
+}
+
+func (counter *newChunkCounter) Put(digest string, chunk []byte) error {
+	counter.stored++
+	return counter.ChunkStore.Put(digest, chunk)
+}
+
+func runArchive(args []string, stdout io.Writer) error {
+	flags := newFlagSet("archive", "-store dir [-o out.json] <bag>")
+	storeDir := flags.String("store", "", "the directory of the content-addressed chunk store")
+	out := flags.String("o", "", "the path of the archived bag, the default is the bag path with "+rosbag.ArchiveExt)
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *storeDir == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	if *out == "" {
+		*out = flags.Arg(0) + rosbag.ArchiveExt
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	store := &newChunkCounter{ChunkStore: rosbag.NewDirChunkStore(*storeDir)}
+	bag, err := rosbag.Archive(f, store)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(bag)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(*out, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	var chunks int
+	for _, part := range bag.Parts {
+		if part.Chunk != "" {
+			chunks++
+		}
+	}
+
+	fmt.Fprintf(stdout, "stored %d new of %d chunks in %s, and wrote the archived bag to %s\n", store.stored, chunks, *storeDir, *out)
+	return nil
+}
+
+func runRestore(args []string, stdout io.Writer) error {
+	flags := newFlagSet("restore", "-store dir <archive.json> <out.bag>")
+	storeDir := flags.String("store", "", "the directory of the content-addressed chunk store")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	if *storeDir == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var bag rosbag.ArchivedBag
+	err = json.Unmarshal(b, &bag)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+
+	f, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	err = bag.Restore(f, rosbag.NewDirChunkStore(*storeDir))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err != nil {
+		// a partially restored bag is not usable
+		os.Remove(flags.Arg(1))
+		return err
+	}
+
+	fmt.Fprintf(stdout, "restored %d bytes to %s\n", bag.Size, flags.Arg(1))
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func runBench(args []string, stdout io.Writer) error {
+	flags := newFlagSet("bench", "[-n runs] [-raw] [-in-memory] [-prefetch] [-buffer-size bytes] [-json] <bag>")
+	runs := flags.Int("n", 3, "the number of times the bag is decoded")
+	raw := flags.Bool("raw", false, "only read the records without viewing the messages as maps")
+	inMemory := flags.Bool("in-memory", false, "load the bag to memory first, and decode it with NewDecoderBytes")
+	prefetch := flags.Bool("prefetch", false, "read the chunks ahead while the messages are decoded")
+	bufferSize := flags.Int("buffer-size", 0, "the size of the read buffer in bytes, the default of the decoder when it's 0")
+	asJSON := flags.Bool("json", false, "print the result of every run as a JSON array")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *runs < 1 {
+		fmt.Fprintln(flags.Output(), "-n must be at least 1")
+		flags.Usage()
+		return errUsage
+	}
+
+	var opts []rosbag.DecoderOption
+	if *prefetch {
+		opts = append(opts, rosbag.WithPrefetch())
+	}
+
+	if *bufferSize > 0 {
+		opts = append(opts, rosbag.WithBufferSize(*bufferSize))
+	}
+
+	var b []byte
+	if *inMemory {
+		b, err = ioutil.ReadFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	}
+
+	var results []*rosbag.BenchResult
+	for i := 0; i < *runs; i++ {
+		result, err := benchOnce(flags.Arg(0), b, !*raw, opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+	return printBench(stdout, results)
+}
+
+// benchOnce decodes the bag at path once, or b when the bag has been loaded to memory.
+func benchOnce(path string, b []byte, view bool, opts []rosbag.DecoderOption) (*rosbag.BenchResult, error) {
+	if b != nil {
+		return rosbag.BenchDecode(rosbag.NewDecoderBytes(b, opts...), view)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return rosbag.BenchDecode(rosbag.NewDecoder(f, opts...), view)
+}
+
+// printBench prints the averages of the runs, and the breakdown of the topics.
+func printBench(w io.Writer, results []*rosbag.BenchResult) error {
+	var total rosbag.BenchResult
+	topics := make(map[string]time.Duration)
+	for _, result := range results {
+		total.Elapsed += result.Elapsed
+		total.Bytes += result.Bytes
+		total.Records += result.Records
+		total.Allocs += result.Allocs
+		total.AllocBytes += result.AllocBytes
+		for _, topic := range result.Topics {
+			topics[topic.Topic] += topic.View
+		}
+	}
+
+	runs := len(results)
+	last := results[runs-1]
+	fmt.Fprintf(w, "runs:        %d\n", runs)
+	fmt.Fprintf(w, "size:        %.2f MB\n", float64(last.Bytes)/1024/1024)
+	fmt.Fprintf(w, "records:     %d (%d messages)\n", last.Records, last.Messages)
+	fmt.Fprintf(w, "time:        %v per run\n", (total.Elapsed / time.Duration(runs)).Round(time.Microsecond))
+	fmt.Fprintf(w, "throughput:  %.0f records/s, %.2f MB/s\n", total.RecordsPerSecond(), total.MBPerSecond())
+	fmt.Fprintf(w, "allocations: %d (%.2f MB) per run\n", total.Allocs/uint64(runs), float64(total.AllocBytes)/1024/1024/float64(runs))
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tMESSAGES\tSIZE\tVIEW TIME")
+	for _, topic := range last.Topics {
+		view := (topics[topic.Topic] / time.Duration(runs)).Round(time.Microsecond)
+		fmt.Fprintf(tw, "%s\t%d\t%.2f MB\t%v\n", topic.Topic, topic.Messages, float64(topic.Bytes)/1024/1024, view)
+	}
+	return tw.Flush()
+}
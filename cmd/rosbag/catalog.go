@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag/catalog"
+)
+
+func runCatalog(args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "scan":
+			return runCatalogScan(args[1:], stdout)
+		case "find":
+			return runCatalogFind(args[1:], stdout)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Usage: rosbag catalog scan [-db path] <root>...")
+	fmt.Fprintln(os.Stderr, "       rosbag catalog find [-db path] [-topic pattern] [-type pattern] [-start time] [-end time] [-min-size bytes] [-max-size bytes] [-json]")
+	return errUsage
+}
+
+func runCatalogScan(args []string, stdout io.Writer) error {
+	flags := newFlagSet("catalog scan", "[-db path] <root>...")
+	db := flags.String("db", "catalog.db", "the SQLite database of the catalog")
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	c, err := catalog.Open(*db)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	stats, err := c.Scan(flags.Args()...)
+	if err != nil {
+		return err
+	}
+
+	for _, failed := range stats.Failed {
+		fmt.Fprintf(os.Stderr, "rosbag: skipped %v\n", failed)
+	}
+
+	fmt.Fprintf(stdout, "added %d bags, updated %d, removed %d, and skipped %d unchanged and %d unreadable\n",
+		stats.Added, stats.Updated, stats.Removed, stats.Unchanged, len(stats.Failed))
+	return nil
+}
+
+// timeFlag is a time in Unix seconds or RFC 3339.
+type timeFlag struct {
+	time.Time
+}
+
+func (flag *timeFlag) String() string {
+	if flag.IsZero() {
+		return ""
+	}
+	return flag.Format(time.RFC3339Nano)
+}
+
+func (flag *timeFlag) Set(value string) error {
+	t, err := parseTime(value)
+	if err != nil {
+		return err
+	}
+
+	flag.Time = t
+	return nil
+}
+
+func runCatalogFind(args []string, stdout io.Writer) error {
+	flags := newFlagSet("catalog find", "[-db path] [-topic pattern] [-type pattern] [-start time] [-end time] [-min-size bytes] [-max-size bytes] [-json]")
+	db := flags.String("db", "catalog.db", "the SQLite database of the catalog")
+	var start, end timeFlag
+	var query catalog.Query
+	flags.StringVar(&query.Topic, "topic", "", "find the bags with a topic that matches the pattern, e.g. /camera/*")
+	flags.StringVar(&query.Type, "type", "", "find the bags with a message type that matches the pattern, e.g. sensor_msgs/*")
+	flags.Var(&start, "start", "find the bags with messages at or after the time in Unix seconds or RFC 3339")
+	flags.Var(&end, "end", "find the bags with messages before the time in Unix seconds or RFC 3339")
+	flags.Int64Var(&query.MinSize, "min-size", 0, "find the bags of at least the size in bytes")
+	flags.Int64Var(&query.MaxSize, "max-size", 0, "find the bags of at most the size in bytes")
+	asJSON := flags.Bool("json", false, "print the infos of the bags as a JSON array")
+	err := parseFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	// opening a catalog creates it, which is surprising for a typo in the path
+	_, err = os.Stat(*db)
+	if err != nil {
+		return err
+	}
+
+	c, err := catalog.Open(*db)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	query.Start = start.Time
+	query.End = end.Time
+	bags, err := c.Find(query)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(bags)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSTART\tDURATION\tSIZE\tMESSAGES\tTOPICS")
+	for _, bag := range bags {
+		start := time.Unix(0, int64(bag.Start*float64(time.Second))).UTC()
+		fmt.Fprintf(w, "%s\t%s\t%.2fs\t%d\t%d\t%d\n", bag.Path, start.Format(time.RFC3339), bag.Duration, bag.Size, bag.Messages, len(bag.Topics))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func runCheck(args []string, stdout io.Writer) error {
+	flags := newFlagSet("check", "[-json] <bag>")
+	asJSON := flags.Bool("json", false, "print the findings as a JSON array")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	f, err := rosbag.OpenBag(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	findings, err := rosbag.Check(f)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		// an empty array instead of null is easier for scripts
+		if findings == nil {
+			findings = []rosbag.Finding{}
+		}
+
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(findings)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range findings {
+			fmt.Fprintln(stdout, finding)
+		}
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("%s has %d problems", flags.Arg(0), len(findings))
+	}
+
+	if !*asJSON {
+		fmt.Fprintf(stdout, "%s is valid\n", flags.Arg(0))
+	}
+	return nil
+}
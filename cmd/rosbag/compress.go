@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func runCompress(args []string, stdout io.Writer) error {
+	flags := newFlagSet("compress", "[-codec lz4] [-output-dir dir] [-f] [-q] <bag>...")
+	codec := flags.String("codec", string(rosbag.CompressionLZ4), "the compression of the chunks, lz4, bz2, snappy, or delta for slowly changing messages, which only this library can read")
+	return transcodeBags(flags, args, stdout, func(w io.WriteSeeker, r io.Reader, opts ...rosbag.TranscodeOption) error {
+		return rosbag.Transcode(w, r, rosbag.Compression(*codec), opts...)
+	})
+}
+
+func runDecompress(args []string, stdout io.Writer) error {
+	flags := newFlagSet("decompress", "[-output-dir dir] [-f] [-q] <bag>...")
+	return transcodeBags(flags, args, stdout, func(w io.WriteSeeker, r io.Reader, opts ...rosbag.TranscodeOption) error {
+		return rosbag.Transcode(w, r, rosbag.CompressionNone, opts...)
+	})
+}
+
+func runReindex(args []string, stdout io.Writer) error {
+	flags := newFlagSet("reindex", "[-output-dir dir] [-f] [-q] <bag>...")
+	// the truncations are printed after the progress of every bag, they're in the order of the bags
+	var truncations []*rosbag.TruncatedError
+	err := transcodeBags(flags, args, stdout, func(w io.WriteSeeker, r io.Reader, opts ...rosbag.TranscodeOption) error {
+		stats, err := rosbag.Reindex(w, r, opts...)
+		if err != nil {
+			return err
+		}
+
+		truncations = append(truncations, stats.Truncated)
+		return nil
+	})
+
+	for i, truncation := range truncations {
+		if truncation != nil {
+			fmt.Fprintf(stdout, "%s: %v, the rest is dropped\n", flags.Arg(i), truncation)
+		}
+	}
+	return err
+}
+
+// transcodeFunc copies the bag from r to w, like rosbag.Transcode.
+type transcodeFunc func(w io.WriteSeeker, r io.Reader, opts ...rosbag.TranscodeOption) error
+
+// transcodeBags rewrites the bags in args with transcode like the ros_comm tools. transcode is
+// called after the flags are parsed.
+func transcodeBags(flags *flag.FlagSet, args []string, stdout io.Writer, transcode transcodeFunc) error {
+	outputDir := flags.String("output-dir", "", "write the bags to this directory instead of replacing them")
+	force := flags.Bool("f", false, "overwrite the existing backups and output bags")
+	quiet := flags.Bool("q", false, "don't print the progress")
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	for _, path := range flags.Args() {
+		var progress io.Writer
+		if !*quiet {
+			progress = stdout
+		}
+
+		err = transcodeBag(path, *outputDir, *force, transcode, progress)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// transcodeBag rewrites the bag at path with transcode. Without outputDir, the bag is replaced in place, and
+// the original is kept as <name>.orig.bag. The original is restored when the transcoding fails.
+func transcodeBag(path string, outputDir string, force bool, transcode transcodeFunc, progress io.Writer) error {
+	src := path
+	dst := filepath.Join(outputDir, filepath.Base(path))
+	existing := dst
+	if outputDir == "" {
+		src = strings.TrimSuffix(path, ".bag") + ".orig.bag"
+		dst = path
+		existing = src
+	}
+
+	if _, err := os.Stat(existing); err == nil && !force {
+		return fmt.Errorf("%s already exists, use -f to overwrite it", existing)
+	}
+
+	if outputDir == "" {
+		err := os.Rename(path, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := transcodeFile(dst, src, transcode, progress)
+	if err != nil && outputDir == "" {
+		os.Remove(dst)
+		if restoreErr := os.Rename(src, path); restoreErr != nil {
+			return fmt.Errorf("%v, and the original can't be restored from %s: %v", err, src, restoreErr)
+		}
+	}
+	return err
+}
+
+func transcodeFile(dst, src string, transcode transcodeFunc, progress io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var opts []rosbag.TranscodeOption
+	if progress != nil {
+		name := filepath.Base(dst)
+		lastPercent := -1
+		opts = append(opts, rosbag.WithTranscodeProgress(func(read int64) {
+			percent := 100
+			if info.Size() > 0 {
+				percent = int(read * 100 / info.Size())
+			}
+
+			if percent != lastPercent {
+				fmt.Fprintf(progress, "\r%s %3d%%", name, percent)
+				lastPercent = percent
+			}
+		}))
+	}
+
+	err = transcode(out, in, opts...)
+	if progress != nil {
+		fmt.Fprintln(progress)
+	}
+
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbag2"
+	"github.com/lherman-cs/go-rosbag/ulog"
+)
+
+// The formats that are detected by convert from the file extensions.
+const (
+	formatBag     = "bag"
+	formatMCAP    = "mcap"
+	formatDB3     = "db3"
+	formatULog    = "ulog"
+	formatROSBag2 = "rosbag2 directory"
+)
+
+// detectFormat detects the format of path from its extension. Paths without an extension are
+// rosbag2 directories.
+func detectFormat(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(strings.TrimRight(path, "/"))); ext {
+	case ".bag":
+		return formatBag, nil
+	case ".mcap":
+		return formatMCAP, nil
+	case ".db3":
+		return formatDB3, nil
+	case ".ulg":
+		return formatULog, nil
+	case "":
+		return formatROSBag2, nil
+	default:
+		return "", fmt.Errorf("unknown format of %s", path)
+	}
+}
+
+func runConvert(args []string, stdout io.Writer) error {
+	flags := newFlagSet("convert", "[-start-time unix_seconds] <in.bag|in.ulg> <out.mcap|out.db3|out.ulg|out_dir|out.bag>")
+	startTime := flags.Float64("start-time", 0, "the Unix time of the start of a .ulg input, the log keeps its own timestamps by default")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	in, out := flags.Arg(0), flags.Arg(1)
+	inFormat, err := detectFormat(in)
+	if err != nil {
+		return err
+	}
+
+	outFormat, err := detectFormat(out)
+	if err != nil {
+		return err
+	}
+
+	if inFormat == formatULog {
+		if outFormat != formatBag {
+			return errors.New("a .ulg can only be converted to a .bag")
+		}
+
+		var opts []ulog.Option
+		if *startTime != 0 {
+			opts = append(opts, ulog.WithStartTime(time.Unix(0, int64(*startTime*float64(time.Second)))))
+		}
+
+		err = convertFile(in, out, func(r io.Reader, w io.Writer) error {
+			_, err := ulog.ToBag(w, r, opts...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "converted %s to %s\n", in, out)
+		return nil
+	}
+
+	if inFormat != formatBag {
+		return fmt.Errorf("converting from a %s is not supported yet, the input must be a .bag or a .ulg", inFormat)
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := rosbag.NewDecoder(f)
+	switch outFormat {
+	case formatMCAP:
+		err = convertMCAP(out, decoder)
+	case formatROSBag2:
+		err = rosbag2.Convert(out, decoder)
+	case formatDB3:
+		err = rosbag2.ConvertDB3(out, decoder)
+	case formatULog:
+		err = convertULog(out, decoder, stdout)
+	default:
+		err = fmt.Errorf("converting to a %s is not supported", outFormat)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "converted %s to %s\n", in, out)
+	return nil
+}
+
+func convertMCAP(path string, decoder *rosbag.Decoder) error {
+	return createOutput(path, func(w io.Writer) error {
+		return rosbag2.ConvertMCAP(w, decoder)
+	})
+}
+
+func convertULog(path string, decoder *rosbag.Decoder, stdout io.Writer) error {
+	var stats *ulog.Stats
+	err := createOutput(path, func(w io.Writer) error {
+		var err error
+		stats, err = ulog.FromBag(w, decoder)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range stats.SkippedTopics {
+		fmt.Fprintf(stdout, "skipped %s, ULog doesn't have variable-length arrays\n", topic)
+	}
+	return nil
+}
+
+// convertFile opens in, and converts it to out with convert.
+func convertFile(in, out string, convert func(r io.Reader, w io.Writer) error) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return createOutput(out, func(w io.Writer) error {
+		return convert(f, w)
+	})
+}
+
+// createOutput creates the file path, and writes it with write. The file is removed when write
+// fails.
+func createOutput(path string, write func(w io.Writer) error) error {
+	// the output isn't overwritten, like rosbag2.Convert
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = write(f)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
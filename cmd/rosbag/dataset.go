@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/dataset"
+)
+
+// topicFlags collects the values of a repeated flag, e.g. -camera and -lidar.
+type topicFlags []string
+
+func (flags *topicFlags) String() string {
+	return ""
+}
+
+func (flags *topicFlags) Set(value string) error {
+	*flags = append(*flags, value)
+	return nil
+}
+
+func runDataset(args []string, stdout io.Writer) error {
+	flags := newFlagSet("dataset", "[-layout kitti|nuscenes] [-camera topic]... [-lidar topic]... [-pose topic] [-ego-frame frame] <bag> <out_dir>")
+	layout := flags.String("layout", string(dataset.LayoutKITTI), "the directory layout of the dataset, kitti or nuscenes")
+	var cameras, lidars topicFlags
+	flags.Var(&cameras, "camera", "an image topic, it can be repeated, every image topic is exported by default")
+	flags.Var(&lidars, "lidar", "a point cloud topic, it can be repeated, every point cloud topic is exported by default")
+	pose := flags.String("pose", "", "the topic of the ego poses, the first odometry or pose topic by default")
+	egoFrame := flags.String("ego-frame", "base_link", "the frame of the vehicle that the extrinsics are relative to")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	if *layout != string(dataset.LayoutKITTI) && *layout != string(dataset.LayoutNuScenes) {
+		flags.Usage()
+		return errUsage
+	}
+
+	opts := []dataset.Option{
+		dataset.WithLayout(dataset.Layout(*layout)),
+		dataset.WithPoseTopic(*pose),
+		dataset.WithEgoFrame(*egoFrame),
+	}
+
+	if len(cameras) > 0 {
+		opts = append(opts, dataset.WithCameras(cameras...))
+	}
+
+	if len(lidars) > 0 {
+		opts = append(opts, dataset.WithLidars(lidars...))
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out := flags.Arg(1)
+	stats, err := dataset.Export(out, rosbag.NewDecoder(in), opts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote %d images of %d cameras, %d sweeps of %d lidars, and %d poses to %s\n",
+		stats.Images, len(stats.Cameras), stats.Sweeps, len(stats.Lidars), stats.Poses, out)
+	return nil
+}
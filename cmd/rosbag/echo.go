@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// errEchoLimit stops the decoder when the number of messages to echo is reached
+var errEchoLimit = errors.New("echo limit is reached")
+
+func runEcho(args []string, stdout io.Writer) error {
+	flags := newFlagSet("echo", "[-e expr] [-n count] <bag> <topic>")
+	exprFlag := flags.String("e", ".", "the jq-style expression that extracts the values from every message, e.g. '.transforms[] | .child_frame_id'")
+	count := flags.Int("n", 0, "the number of messages to print, 0 prints all of them")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	expr, err := rosbag.CompileExpr(*exprFlag)
+	if err != nil {
+		return err
+	}
+
+	f, err := rosbag.OpenBag(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var messages int
+	encoder := json.NewEncoder(stdout)
+	// whole messages are written straight from the message data
+	messageEncoder := rosbag.NewJSONEncoder(stdout)
+	decoder := rosbag.NewDecoder(f)
+	err = decoder.Subscribe(flags.Arg(1), func(record *rosbag.RecordMessageData) error {
+		if *exprFlag == "." {
+			err := messageEncoder.Encode(record)
+			if err != nil {
+				return err
+			}
+		} else {
+			data := make(map[string]interface{})
+			err := record.ViewAs(data)
+			if err != nil {
+				return err
+			}
+
+			outputs, err := expr.Eval(data)
+			if err != nil {
+				return err
+			}
+
+			// the outputs share the memory of the record, so they're encoded before it's closed
+			for _, output := range outputs {
+				err = encoder.Encode(jsonSafeValue(output))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		messages++
+		if *count > 0 && messages >= *count {
+			return errEchoLimit
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Run()
+	if err == errEchoLimit {
+		return nil
+	}
+	return err
+}
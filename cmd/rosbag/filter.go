@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// dropFlags collects the repeated -drop-topic and -drop-range flags as copy options.
+type dropFlags struct {
+	opts []rosbag.CopyOption
+}
+
+// topicFlag is the -drop-topic flag of dropFlags.
+type topicFlag struct {
+	*dropFlags
+}
+
+func (flag topicFlag) String() string {
+	return ""
+}
+
+func (flag topicFlag) Set(value string) error {
+	_, err := rosbag.CompilePattern(value)
+	if err != nil {
+		return err
+	}
+
+	flag.opts = append(flag.opts, rosbag.WithDroppedTopics(value))
+	return nil
+}
+
+// rangeFlag is the -drop-range flag of dropFlags.
+type rangeFlag struct {
+	*dropFlags
+}
+
+func (flag rangeFlag) String() string {
+	return ""
+}
+
+func (flag rangeFlag) Set(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("expected start:end, but got %q", value)
+	}
+
+	var times [2]time.Time
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		var err error
+		times[i], err = parseTime(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	flag.opts = append(flag.opts, rosbag.WithDroppedTimeRange(times[0], times[1]))
+	return nil
+}
+
+func runFilter(args []string, stdout io.Writer) error {
+	flags := newFlagSet("filter", "[-drop-topic pattern]... [-drop-range start:end]... <bag> <out.bag>")
+	var drops dropFlags
+	flags.Var(topicFlag{&drops}, "drop-topic", "drop the messages on the topics that match the pattern, e.g. /camera/*, it can be repeated")
+	flags.Var(rangeFlag{&drops}, "drop-range", "drop the messages whose record times in Unix seconds are in [start, end), either side can be empty, it can be repeated")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	stats, err := rosbag.Copy(out, in, drops.opts...)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+
+	if err != nil {
+		// a partially written bag is not usable
+		os.Remove(flags.Arg(1))
+		return err
+	}
+
+	fmt.Fprintf(stdout, "copied %d chunks as is, rewrote %d, and dropped %d\n", stats.Copied, stats.Rewritten, stats.Dropped)
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/hdf5"
+)
+
+// fieldFlags collects the repeated -field flags.
+type fieldFlags []hdf5.Field
+
+func (flags *fieldFlags) String() string {
+	return ""
+}
+
+// Set parses a field of the form topic:path, e.g. /odom:pose.pose.position.x.
+func (flags *fieldFlags) Set(value string) error {
+	colon := strings.IndexByte(value, ':')
+	if colon <= 0 {
+		return fmt.Errorf("field must be topic:path, but got %s", value)
+	}
+
+	path, err := rosbag.CompileFieldPath(value[colon+1:])
+	if err != nil {
+		return err
+	}
+
+	*flags = append(*flags, hdf5.Field{Topic: value[:colon], Path: path})
+	return nil
+}
+
+func runHDF5(args []string, stdout io.Writer) error {
+	flags := newFlagSet("hdf5", "[-chunk-size n] [-level n] -field topic:path... <bag> <out.h5>")
+	chunkSize := flags.Int("chunk-size", 16384, "the number of elements in a chunk of a dataset")
+	level := flags.Int("level", 4, "the deflate level of the chunks from 1 to 9")
+	var fields fieldFlags
+	flags.Var(&fields, "field", "a numeric field of a topic as topic:path, e.g. /odom:pose.pose.position.x, it can be repeated")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = hdf5.Export(out, rosbag.NewDecoder(in), fields, hdf5.WithChunkSize(*chunkSize), hdf5.WithCompressionLevel(*level))
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote %d fields to %s\n", len(fields), flags.Arg(1))
+	return nil
+}
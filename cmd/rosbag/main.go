@@ -0,0 +1,139 @@
+// Command rosbag inspects and manipulates rosbag files. Run `rosbag help` for the list of
+// commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errUsage is returned by a command when its arguments are invalid, the usage has already been
+// printed by the flag set.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "topics", summary: "list the topics of a bag", run: runTopics},
+		{name: "check", summary: "validate a bag, and exit with 1 when it has problems", run: runCheck},
+		{name: "bench", summary: "measure how fast a bag is decoded with the decoder options", run: runBench},
+		{name: "manifest", summary: "write the SHA-256 digests of a bag, its chunks, and its connections", run: runManifest},
+		{name: "verify", summary: "verify a bag against its manifest, and exit with 1 when it doesn't match", run: runVerify},
+		{name: "archive", summary: "store the chunks of a bag in a content-addressed chunk store", run: runArchive},
+		{name: "restore", summary: "restore an archived bag from a content-addressed chunk store", run: runRestore},
+		{name: "compress", summary: "compress the chunks of bags in place", run: runCompress},
+		{name: "decompress", summary: "decompress the chunks of bags in place", run: runDecompress},
+		{name: "reindex", summary: "rebuild the index of bags in place, e.g. the bags of a crashed recorder", run: runReindex},
+		{name: "filter", summary: "copy a bag without some topics or time ranges, keeping the untouched chunks as is", run: runFilter},
+		{name: "catalog", summary: "index the bags under directories, and find bags by topic, type, time, and size", run: runCatalog},
+		{name: "convert", summary: "convert a bag to MCAP, a rosbag2 directory, or a PX4 ULog file, and a ULog file back to a bag", run: runConvert},
+		{name: "echo", summary: "print the messages of a topic as JSON lines", run: runEcho},
+		{name: "query", summary: "select fields of messages with a SQL-like statement", run: runQuery},
+		{name: "triggers", summary: "find the moments when fields cross thresholds, and extract the windows around them", run: runTriggers},
+		{name: "tfrecord", summary: "export messages as TFRecord examples", run: runTFRecord},
+		{name: "npy", summary: "export numeric fields of a topic as NumPy arrays", run: runNPY},
+		{name: "hdf5", summary: "export numeric fields of topics as an HDF5 file", run: runHDF5},
+		{name: "markers", summary: "export visualization markers as OBJ or glTF scenes", run: runMarkers},
+		{name: "dataset", summary: "export camera images, lidar sweeps, calibrations, and ego poses as a KITTI or nuScenes dataset", run: runDataset},
+		{name: "preview", summary: "write a compact preview of a bag for archive browsers", run: runPreview},
+		{name: "serve", summary: "stream the messages of a bag as server-sent events", run: runServe},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: rosbag <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "rosbag <command> -h" for the arguments of a command.`)
+}
+
+// newFlagSet creates the flag set of a command, the usage line is printed before the flags.
+func newFlagSet(name string, usageLine string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: rosbag %s %s\n", name, usageLine)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args, and checks that there are nArgs positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string, nArgs int) error {
+	// the flag set prints the errors and the usage
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() != nArgs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// parseTime parses s as Unix seconds, or as an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		whole, frac := math.Modf(sec)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected Unix seconds or an RFC 3339 time, but got %q", s)
+	}
+	return t, nil
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return errUsage
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		return nil
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdout)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "rosbag: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return errUsage
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == errUsage {
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rosbag: %v\n", err)
+		os.Exit(1)
+	}
+}
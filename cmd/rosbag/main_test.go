@@ -0,0 +1,888 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/preview"
+)
+
+const exampleBag = "../../examples/logging/example.bag"
+
+func TestTopics(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"topics", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("expected a header and 9 topics, but got:\n%s", buf.String())
+	}
+
+	if fields := strings.Fields(lines[2]); fields[0] != "/tf" || fields[3] != "2688" {
+		t.Fatalf("expected /tf to have 2688 messages, but got %q", lines[2])
+	}
+
+	buf.Reset()
+	err = run([]string{"topics", "-json", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var topics []rosbag.TopicStats
+	err = json.Unmarshal(buf.Bytes(), &topics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(topics) != 9 || topics[0].Topic != "/rosout" || topics[0].Messages != 10 {
+		t.Fatalf("expected 9 topics starting with /rosout, but got %+v", topics)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"check", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := exampleBag + " is valid\n"; buf.String() != expected {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	// the example bag is indexed, so a part of it has problems
+	raw, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := filepath.Join(t.TempDir(), "truncated.bag")
+	err = ioutil.WriteFile(truncated, raw[:len(raw)/2], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = run([]string{"check", "-json", truncated}, &buf)
+	if err == nil {
+		t.Fatal("expected the truncated bag to fail the check")
+	}
+
+	var findings []rosbag.Finding
+	err = json.Unmarshal(buf.Bytes(), &findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) == 0 || findings[0].Check != rosbag.CheckStructure {
+		t.Fatalf("expected a structure finding, but got %v", findings)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	raw, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.bag")
+	err = ioutil.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = run([]string{"compress", "-codec", "lz4", path}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "example.bag 100%") {
+		t.Fatalf("expected the progress to reach 100%%, but got %q", buf.String())
+	}
+
+	backup, err := ioutil.ReadFile(filepath.Join(dir, "example.orig.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(raw, backup) {
+		t.Fatal("expected the backup to be the original bag")
+	}
+
+	// the backup already exists
+	err = run([]string{"compress", "-q", path}, &buf)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected the existing backup to fail the command, but got %v", err)
+	}
+
+	outputDir := filepath.Join(dir, "out")
+	err = os.Mkdir(outputDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = run([]string{"decompress", "-q", "-output-dir", outputDir, path}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected no progress, but got %q", buf.String())
+	}
+
+	err = run([]string{"check", filepath.Join(outputDir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"compress", "-q", "-codec", "bz2", "-output-dir", dir, "-f", filepath.Join(outputDir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"check", filepath.Join(dir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"compress", "-q", "-codec", "snappy", "-output-dir", dir, "-f", filepath.Join(outputDir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"check", filepath.Join(dir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"compress", "-codec", "zstd", "-output-dir", dir, "-f", filepath.Join(outputDir, "example.bag")}, &buf)
+	if err == nil {
+		t.Fatal("expected zstd to fail since it can't be written")
+	}
+}
+
+func TestReindex(t *testing.T) {
+	raw, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorder crashed in the middle of the bag
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.bag")
+	err = ioutil.WriteFile(path, raw[:len(raw)/2], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = run([]string{"reindex", "-q", path}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), path+": bag is truncated") || !strings.Contains(buf.String(), "the rest is dropped") {
+		t.Fatalf("expected the truncation to be printed, but got %q", buf.String())
+	}
+
+	err = run([]string{"check", path}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(dir, "out")
+	err = os.Mkdir(outputDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = run([]string{"reindex", "-q", "-output-dir", outputDir, exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output for a complete bag, but got %q", buf.String())
+	}
+
+	err = run([]string{"check", filepath.Join(outputDir, "example.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		out      string
+		expected string
+	}{
+		{out: filepath.Join(dir, "example.mcap"), expected: filepath.Join(dir, "example.mcap")},
+		{out: filepath.Join(dir, "example.db3"), expected: filepath.Join(dir, "example.db3")},
+		{out: filepath.Join(dir, "example"), expected: filepath.Join(dir, "example", "metadata.yaml")},
+		{out: filepath.Join(dir, "example.ulg"), expected: filepath.Join(dir, "example.ulg")},
+	}
+
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		err := run([]string{"convert", exampleBag, testCase.out}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = os.Stat(testCase.expected); err != nil {
+			t.Fatal(err)
+		}
+
+		// the output already exists
+		err = run([]string{"convert", exampleBag, testCase.out}, &buf)
+		if err == nil {
+			t.Fatalf("expected %s not to be overwritten", testCase.out)
+		}
+	}
+
+	// the ULog is converted back with the time of the bag
+	var buf bytes.Buffer
+	err := run([]string{"convert", "-start-time", "1", filepath.Join(dir, "example.ulg"), filepath.Join(dir, "ulog.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"check", filepath.Join(dir, "ulog.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsupported := [][]string{
+		{"convert", filepath.Join(dir, "example.ulg"), filepath.Join(dir, "ulog.mcap")},
+		{"convert", filepath.Join(dir, "example.mcap"), filepath.Join(dir, "back.bag")},
+		{"convert", exampleBag, filepath.Join(dir, "example.txt")},
+	}
+	for _, args := range unsupported {
+		err := run(args, &bytes.Buffer{})
+		if err == nil {
+			t.Fatalf("expected %q to fail", args)
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"echo", "-n", "2", "-e", ".transforms[] | .child_frame_id", exampleBag, "/tf"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], `"`) {
+		t.Fatalf("expected the child frame ids of 2 messages, but got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"echo", "-n", "1", exampleBag, "/rosout"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg["msg"] == nil {
+		t.Fatalf("expected a /rosout message, but got %v", msg)
+	}
+
+	err = run([]string{"echo", "-e", "name", exampleBag, "/rosout"}, &buf)
+	if err == nil {
+		t.Fatal("expected an invalid expression to fail")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"query", exampleBag, "SELECT header.frame_id, level FROM /rosout WHERE level >= 2 LIMIT 3"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || strings.Join(strings.Fields(lines[0]), " ") != "TIME TOPIC header.frame_id level" {
+		t.Fatalf("expected a header and 3 rows, but got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"query", "-json", exampleBag, "SELECT level FROM /rosout LIMIT 1"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var row queryRow
+	err = json.Unmarshal(buf.Bytes(), &row)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if row.Topic != "/rosout" || row.Values["level"] == nil {
+		t.Fatalf("expected a /rosout row with a level, but got %+v", row)
+	}
+
+	err = run([]string{"query", exampleBag, "SELECT FROM /rosout"}, &buf)
+	if err == nil {
+		t.Fatal("expected an invalid statement to fail")
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	err := run([]string{"triggers", "-before", "1s", "-after", "1s", "-window", "/turtle1/*", "-o", dir,
+		"-when", "fast=/turtle1/pose:linear_velocity > 1.5", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 || strings.Join(strings.Fields(lines[1])[1:3], " ") != "fast /turtle1/pose" {
+		t.Fatalf("expected a header and events of fast, but got:\n%s", buf.String())
+	}
+
+	// every window is a valid bag of the window topics
+	buf.Reset()
+	err = run([]string{"topics", filepath.Join(dir, "event_1.bag")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "/turtle1/pose") || strings.Contains(buf.String(), "/turtle2") {
+		t.Fatalf("expected the /turtle1 topics, but got:\n%s", buf.String())
+	}
+
+	err = run([]string{"triggers", "-when", "/turtle1/pose:linear_velocity ~ 1", exampleBag}, &buf)
+	if err == nil {
+		t.Fatal("expected an invalid condition to fail")
+	}
+}
+
+func TestTFRecord(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "rosout.tfrecord")
+	var buf bytes.Buffer
+	err := run([]string{"tfrecord", "-feature", "msg=/rosout:.msg", "-feature", "level=/rosout:.level", exampleBag, out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "wrote 10 examples to " + out + "\n"; buf.String() != expected {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	for _, feature := range []string{"msg", "/rosout:.msg", "msg=/rosout:msg"} {
+		err = run([]string{"tfrecord", "-feature", feature, exampleBag, out}, &buf)
+		if err != errUsage {
+			t.Fatalf("expected %v for %s, but got %v", errUsage, feature, err)
+		}
+	}
+}
+
+func TestNPY(t *testing.T) {
+	dir := t.TempDir()
+	for _, out := range []string{filepath.Join(dir, "rosout.npz"), filepath.Join(dir, "rosout")} {
+		var buf bytes.Buffer
+		err := run([]string{"npy", "-path", "header.seq", "-path", "level", exampleBag, "/rosout", out}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "wrote 10 messages to " + out + "\n"; buf.String() != expected {
+			t.Fatalf("expected %q, but got %q", expected, buf.String())
+		}
+	}
+
+	for _, name := range []string{"time.npy", "header.seq.npy", "level.npy"} {
+		_, err := os.Stat(filepath.Join(dir, "rosout", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHDF5(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "rosout.h5")
+	var buf bytes.Buffer
+	err := run([]string{"hdf5", "-field", "/rosout:header.seq", "-field", "/rosout:level", exampleBag, out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "wrote 2 fields to " + out + "\n"; buf.String() != expected {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("\x89HDF\r\n\x1a\n")) {
+		t.Fatal("expected an HDF5 signature")
+	}
+}
+
+func TestManifestVerify(t *testing.T) {
+	raw, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bag := filepath.Join(t.TempDir(), "example.bag")
+	err = ioutil.WriteFile(bag, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = run([]string{"manifest", bag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(buf.String(), " to "+bag+rosbag.ManifestExt+"\n") {
+		t.Fatalf("expected the manifest to be next to the bag, but got %q", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"verify", bag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := bag + " matches its manifest\n"; buf.String() != expected {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	// a truncated transfer
+	err = ioutil.WriteFile(bag, raw[:len(raw)/2], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = run([]string{"verify", "-json", bag}, &buf)
+	if err == nil {
+		t.Fatal("expected the truncated bag to fail")
+	}
+
+	var findings []rosbag.Finding
+	err = json.Unmarshal(buf.Bytes(), &findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) < 2 || findings[0].Check != rosbag.CheckManifest {
+		t.Fatalf("expected the bag and its chunks to not match, but got %v", findings)
+	}
+}
+
+func TestArchiveRestore(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "chunks")
+	archived := filepath.Join(dir, "example.bag"+rosbag.ArchiveExt)
+	var buf bytes.Buffer
+	err := run([]string{"archive", "-store", store, "-o", archived, exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "stored ") || strings.HasPrefix(buf.String(), "stored 0 ") {
+		t.Fatalf("expected the chunks to be stored, but got %q", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"archive", "-store", store, "-o", archived, exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "stored 0 new ") {
+		t.Fatalf("expected the chunks to be deduplicated, but got %q", buf.String())
+	}
+
+	restored := filepath.Join(dir, "restored.bag")
+	err = run([]string{"restore", "-store", store, archived, restored}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadFile(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Fatal("expected the bag to be restored byte for byte")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "filtered.bag")
+	var buf bytes.Buffer
+	err := run([]string{"filter", "-drop-topic", "/tf", "-drop-range", ":1", exampleBag, out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "copied ") {
+		t.Fatalf("expected the chunks to be counted, but got %q", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"check", out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = run([]string{"topics", out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "/tf ") || !strings.Contains(buf.String(), "/rosout ") {
+		t.Fatalf("expected only /tf to be dropped, but got:\n%s", buf.String())
+	}
+}
+
+func TestTopicsZip(t *testing.T) {
+	b, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := filepath.Join(t.TempDir(), "upload.zip")
+	f, err := os.Create(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the stored bag is read in place, so its index section is used like the bag's
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "logs/example.bag", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected, actual bytes.Buffer
+	err = run([]string{"topics", exampleBag}, &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"topics", upload}, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.String() != expected.String() {
+		t.Fatalf("expected the topics of the bag in the archive to be\n%s\nbut got\n%s", expected.String(), actual.String())
+	}
+}
+
+func TestBench(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"bench", "-n", "2", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "runs:        2\n") || !strings.Contains(buf.String(), "/rosout ") {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"bench", "-n", "1", "-raw", "-in-memory", "-json", exampleBag}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results []rosbag.BenchResult
+	err = json.Unmarshal(buf.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Messages == 0 || results[0].Topics[0].View != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	root := t.TempDir()
+	b, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, "example.bag"), b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := filepath.Join(t.TempDir(), "catalog.db")
+	var buf bytes.Buffer
+	err = run([]string{"catalog", "scan", "-db", db, root}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "added 1 bags, updated 0, removed 0, and skipped 0 unchanged and 0 unreadable\n"; buf.String() != expected {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	err = run([]string{"catalog", "find", "-db", db, "-topic", "/rosout", "-start", "2000-01-01T00:00:00Z", "-json"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bags []rosbag.BagInfo
+	err = json.Unmarshal(buf.Bytes(), &bags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bags) != 1 || filepath.Base(bags[0].Path) != "example.bag" {
+		t.Fatalf("expected the example bag to be found, but got %+v", bags)
+	}
+
+	buf.Reset()
+	err = run([]string{"catalog", "find", "-db", db, "-topic", "/unknown"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 1 {
+		t.Fatalf("expected no bags, but got:\n%s", buf.String())
+	}
+
+	err = run([]string{"catalog", "find", "-db", filepath.Join(root, "missing.db")}, &buf)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a missing catalog to fail, but got %v", err)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "preview.json")
+	var buf bytes.Buffer
+	err := run([]string{"preview", "-resolution", "10s", exampleBag, out}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p preview.Preview
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := fmt.Sprintf("wrote the preview of %d topics to %s\n", len(p.Topics), out); buf.String() != expected || len(p.Topics) == 0 {
+		t.Fatalf("expected %q, but got %q", expected, buf.String())
+	}
+
+	if p.Resolution != 10*time.Second {
+		t.Fatalf("expected a resolution of 10s, but got %v", p.Resolution)
+	}
+}
+
+func TestDataset(t *testing.T) {
+	// the example bag doesn't have cameras or lidars, but its /tf_static is read
+	err := run([]string{"dataset", "-layout", "nuscenes", exampleBag, t.TempDir()}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "doesn't have camera or lidar messages") {
+		t.Fatalf("expected a bag without sensors to fail, but got %v", err)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	handler := &eventsHandler{path: exampleBag}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events?topic=/rosout&pace=fast", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("expected an event stream, but got %s", contentType)
+	}
+
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(events) != 11 {
+		t.Fatalf("expected 10 messages and an end event, but got %d events", len(events))
+	}
+
+	if events[10] != "event: end\ndata: {}" {
+		t.Fatalf("expected an end event, but got %q", events[10])
+	}
+
+	var envelope eventEnvelope
+	err := json.Unmarshal([]byte(strings.TrimPrefix(events[0], "event: message\ndata: ")), &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if envelope.Topic != "/rosout" || envelope.Type != "rosgraph_msgs/Log" || envelope.Data["msg"] == nil {
+		t.Fatalf("expected a /rosout message, but got %+v", envelope)
+	}
+
+	for _, query := range []string{"topic=re:(", "pace=slow", "speed=0", "clock=0", "pace=fast&clock=100"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/events?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d for %s, but got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestServeEventsClock(t *testing.T) {
+	handler := &eventsHandler{path: exampleBag}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events?topic=/rosout&speed=1000000&clock=1000", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var messages, clocks int
+	var lastClock float64
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	for _, event := range events[:len(events)-1] {
+		switch {
+		case strings.HasPrefix(event, "event: message\n"):
+			messages++
+		case strings.HasPrefix(event, "event: clock\n"):
+			var envelope clockEnvelope
+			err := json.Unmarshal([]byte(strings.TrimPrefix(event, "event: clock\ndata: ")), &envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if envelope.Time <= lastClock {
+				t.Fatalf("expected the clock to move forward from %f, but got %f", lastClock, envelope.Time)
+			}
+			lastClock = envelope.Time
+			clocks++
+		default:
+			t.Fatalf("unexpected event %q", event)
+		}
+	}
+
+	// the clock is published before the first message is sent
+	if messages != 10 || clocks == 0 || !strings.HasPrefix(events[0], "event: clock\n") {
+		t.Fatalf("expected 10 messages after a clock, but got %d messages and %d clocks", messages, clocks)
+	}
+
+	if events[len(events)-1] != "event: end\ndata: {}" {
+		t.Fatalf("expected an end event, but got %q", events[len(events)-1])
+	}
+}
+
+func TestJSONSafeMap(t *testing.T) {
+	data := jsonSafeMap(map[string]interface{}{
+		"range":  float32(math.Inf(1)),
+		"ranges": []float32{1, float32(math.NaN())},
+		"points": []map[string]interface{}{{"x": math.NaN()}},
+	})
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := `{"points":[{"x":null}],"range":null,"ranges":[1,null]}`; string(raw) != expected {
+		t.Fatalf("expected %s, but got %s", expected, raw)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	testCases := [][]string{
+		nil,
+		{"unknown"},
+		{"topics"},
+		{"topics", "-unknown", exampleBag},
+		{"compress"},
+		{"reindex"},
+		{"bench"},
+		{"bench", "-n", "0", exampleBag},
+		{"manifest"},
+		{"verify", exampleBag, "extra"},
+		{"archive", exampleBag},
+		{"restore", "-store", "chunks", exampleBag},
+		{"filter", exampleBag},
+		{"filter", "-drop-range", "1", exampleBag, "out.bag"},
+		{"catalog"},
+		{"catalog", "scan"},
+		{"catalog", "find", "-start", "yesterday"},
+		{"convert", exampleBag},
+		{"triggers", exampleBag},
+		{"triggers", "-when", "/turtle1/pose:x > 1"},
+		{"serve"},
+		{"query", exampleBag},
+		{"echo", exampleBag},
+		{"tfrecord", exampleBag, "out.tfrecord"},
+		{"npy", exampleBag, "/rosout", "out.npz"},
+		{"hdf5", exampleBag, "out.h5"},
+		{"preview", exampleBag},
+		{"hdf5", "-field", "level", exampleBag, "out.h5"},
+		{"markers", exampleBag, "/markers"},
+		{"markers", "-format", "stl", exampleBag, "/markers", "out"},
+		{"dataset", exampleBag},
+		{"dataset", "-layout", "waymo", exampleBag, "out"},
+	}
+
+	for _, args := range testCases {
+		err := run(args, &bytes.Buffer{})
+		if err != errUsage {
+			t.Fatalf("expected %v for %q, but got %v", errUsage, args, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func runManifest(args []string, stdout io.Writer) error {
+	flags := newFlagSet("manifest", "[-o out.json] <bag>")
+	out := flags.String("o", "", "the path of the manifest, the default is the bag path with "+rosbag.ManifestExt)
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = flags.Arg(0) + rosbag.ManifestExt
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := rosbag.NewManifest(f)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(*out, append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote the digests of %d chunks and %d connections to %s\n", len(manifest.Chunks), len(manifest.Connections), *out)
+	return nil
+}
+
+func runVerify(args []string, stdout io.Writer) error {
+	flags := newFlagSet("verify", "[-manifest path] [-json] <bag>")
+	manifestPath := flags.String("manifest", "", "the path of the manifest, the default is the bag path with "+rosbag.ManifestExt)
+	asJSON := flags.Bool("json", false, "print the findings as a JSON array")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *manifestPath == "" {
+		*manifestPath = flags.Arg(0) + rosbag.ManifestExt
+	}
+
+	b, err := ioutil.ReadFile(*manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var manifest rosbag.Manifest
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *manifestPath, err)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	findings, err := rosbag.VerifyManifest(f, &manifest)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		// an empty array instead of null is easier for scripts
+		if findings == nil {
+			findings = []rosbag.Finding{}
+		}
+
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(findings)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range findings {
+			fmt.Fprintln(stdout, finding)
+		}
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("%s doesn't match its manifest", flags.Arg(0))
+	}
+
+	if !*asJSON {
+		fmt.Fprintf(stdout, "%s matches its manifest\n", flags.Arg(0))
+	}
+	return nil
+}
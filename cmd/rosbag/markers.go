@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/marker"
+)
+
+func runMarkers(args []string, stdout io.Writer) error {
+	flags := newFlagSet("markers", "[-format obj|gltf] [-merge] <bag> <topic> <out_dir|out_file>")
+	format := flags.String("format", string(marker.FormatGLTF), "the format of the scenes, obj or gltf")
+	merge := flags.Bool("merge", false, "write every marker of the topic to a single scene at out_file, instead of a scene per message to out_dir")
+	err := parseFlags(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	if *format != string(marker.FormatOBJ) && *format != string(marker.FormatGLTF) {
+		flags.Usage()
+		return errUsage
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	decoder := rosbag.NewDecoder(in)
+	topic, out := flags.Arg(1), flags.Arg(2)
+	if !*merge {
+		scenes, err := marker.Export(out, decoder, topic, marker.Format(*format))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "wrote %d scenes to %s\n", scenes, out)
+		return nil
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = marker.ExportMerged(f, decoder, topic, marker.Format(*format))
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote the merged scene to %s\n", out)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/npy"
+)
+
+// pathFlags collects the repeated -path flags.
+type pathFlags []*rosbag.FieldPath
+
+func (flags *pathFlags) String() string {
+	return ""
+}
+
+func (flags *pathFlags) Set(value string) error {
+	path, err := rosbag.CompileFieldPath(value)
+	if err != nil {
+		return err
+	}
+
+	*flags = append(*flags, path)
+	return nil
+}
+
+func runNPY(args []string, stdout io.Writer) error {
+	flags := newFlagSet("npy", "-path field... <bag> <topic> <out.npz|out_dir>")
+	var paths pathFlags
+	flags.Var(&paths, "path", "a numeric field path of the messages, e.g. pose.position.x, it can be repeated")
+	err := parseFlags(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	columns, err := npy.ReadColumns(rosbag.NewDecoder(f), flags.Arg(1), paths)
+	if err != nil {
+		return err
+	}
+
+	out := flags.Arg(2)
+	if filepath.Ext(out) == ".npz" {
+		err = writeNPZ(out, columns)
+	} else {
+		err = columns.WriteDir(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote %d messages to %s\n", len(columns.Time), out)
+	return nil
+}
+
+func writeNPZ(path string, columns *npy.Columns) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = columns.WriteNPZ(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lherman-cs/go-rosbag/preview"
+)
+
+func runPreview(args []string, stdout io.Writer) error {
+	flags := newFlagSet("preview", "[-resolution 1s] [-thumbnails 4] [-thumbnail-size 160] <bag> <out.json>")
+	resolution := flags.Duration("resolution", 0, "the width of the buckets of the numeric summaries, the default is 1s")
+	thumbnails := flags.Int("thumbnails", 4, "the number of thumbnails of every camera topic")
+	thumbnailSize := flags.Int("thumbnail-size", 160, "the maximum width and height of the thumbnails in pixels")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	opts := []preview.Option{preview.WithThumbnails(*thumbnails), preview.WithThumbnailSize(*thumbnailSize)}
+	if *resolution != 0 {
+		opts = append(opts, preview.WithResolution(*resolution))
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	p, err := preview.Generate(in, opts...)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = json.NewEncoder(out).Encode(p)
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote the preview of %d topics to %s\n", len(p.Topics), flags.Arg(1))
+	return nil
+}
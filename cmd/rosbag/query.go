@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// queryRow is a JSON line of the query output.
+type queryRow struct {
+	Topic  string                 `json:"topic"`
+	Time   float64                `json:"time"`
+	Values map[string]interface{} `json:"values"`
+}
+
+func runQuery(args []string, stdout io.Writer) error {
+	flags := newFlagSet("query", "[-json] <bag> <statement>")
+	asJSON := flags.Bool("json", false, "print every row as a JSON line")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	query, err := rosbag.ParseQuery(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	f, err := rosbag.OpenBag(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := rosbag.NewDecoder(f)
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		return query.Run(decoder, func(row rosbag.QueryRow) error {
+			values := make(map[string]interface{}, len(row.Values))
+			for i, column := range query.Columns {
+				values[column.String()] = jsonSafeValue(row.Values[i])
+			}
+			return encoder.Encode(queryRow{
+				Topic:  row.Topic,
+				Time:   unixSeconds(row.Time),
+				Values: values,
+			})
+		})
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	header := []string{"TIME", "TOPIC"}
+	for _, column := range query.Columns {
+		header = append(header, column.String())
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	err = query.Run(decoder, func(row rosbag.QueryRow) error {
+		fields := []string{fmt.Sprintf("%.9f", unixSeconds(row.Time)), row.Topic}
+		for _, v := range row.Values {
+			if t, ok := v.(time.Time); ok {
+				fields = append(fields, fmt.Sprintf("%.9f", unixSeconds(t)))
+				continue
+			}
+			fields = append(fields, fmt.Sprint(v))
+		}
+		_, err := fmt.Fprintln(w, strings.Join(fields, "\t"))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const (
+	paceRealtime = "realtime"
+	paceFast     = "fast"
+)
+
+func runServe(args []string, stdout io.Writer) error {
+	flags := newFlagSet("serve", "[-addr :8080] <bag>")
+	addr := flags.String("addr", ":8080", "the address to listen on")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	// fail early instead of on the first request
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", &eventsHandler{path: flags.Arg(0)})
+	fmt.Fprintf(stdout, "serving %s on %s\n", flags.Arg(0), *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// eventEnvelope is the data of a message event.
+type eventEnvelope struct {
+	Topic string                 `json:"topic"`
+	Type  string                 `json:"type"`
+	Time  float64                `json:"time"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// clockEnvelope is the data of a clock event.
+type clockEnvelope struct {
+	Time float64 `json:"time"`
+}
+
+// eventsHandler streams the decoded messages of a bag as server-sent events. The query parameters
+// are:
+//
+//   - topic selects the topics with rosbag.Pattern syntaxes, it can be repeated. All topics are
+//     streamed when it's not set.
+//   - pace is "realtime" (the default) to send the messages at the times they were recorded, or
+//     "fast" to send them as fast as possible.
+//   - speed multiplies the realtime pace, e.g. 2 plays the bag twice as fast.
+//   - clock is the rate in Hz to send the playback clock as "clock" events with a clockEnvelope,
+//     like rosbag play --clock does for use_sim_time nodes. It requires the realtime pace.
+//
+// Every message is sent as a "message" event with an eventEnvelope, and the stream ends with an
+// "end" event, or an "error" event when the bag can't be decoded.
+type eventsHandler struct {
+	path string
+}
+
+func (handler *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var patterns []*rosbag.Pattern
+	for _, topic := range query["topic"] {
+		p, err := rosbag.CompilePattern(topic)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid topic %q: %v", topic, err), http.StatusBadRequest)
+			return
+		}
+		patterns = append(patterns, p)
+	}
+
+	pace := query.Get("pace")
+	if pace == "" {
+		pace = paceRealtime
+	}
+	if pace != paceRealtime && pace != paceFast {
+		http.Error(w, fmt.Sprintf("invalid pace %q, it must be %s or %s", pace, paceRealtime, paceFast), http.StatusBadRequest)
+		return
+	}
+
+	speed := 1.0
+	if s := query.Get("speed"); s != "" {
+		var err error
+		speed, err = strconv.ParseFloat(s, 64)
+		if err != nil || speed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid speed %q, it must be a positive number", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var clockHz float64
+	if s := query.Get("clock"); s != "" {
+		var err error
+		clockHz, err = strconv.ParseFloat(s, 64)
+		if err != nil || clockHz <= 0 || math.IsInf(clockHz, 0) {
+			http.Error(w, fmt.Sprintf("invalid clock %q, it must be a positive rate in Hz", s), http.StatusBadRequest)
+			return
+		}
+
+		if pace != paceRealtime {
+			http.Error(w, fmt.Sprintf("clock requires the %s pace", paceRealtime), http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(handler.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err = streamEvents(w, flusher, r, rosbag.NewDecoder(f), patterns, pace == paceRealtime, speed, clockHz)
+	if err != nil {
+		// the status has already been sent, so the error can only be reported as an event
+		writeEvent(w, "error", err.Error())
+		flusher.Flush()
+		return
+	}
+
+	writeEvent(w, "end", struct{}{})
+	flusher.Flush()
+}
+
+func streamEvents(w io.Writer, flusher http.Flusher, r *http.Request, decoder *rosbag.Decoder, patterns []*rosbag.Pattern, realtime bool, speed float64, clockHz float64) error {
+	ctx := r.Context()
+	send := func(msg *rosbag.RecordMessageData) error {
+		if !matchTopic(patterns, msg.ConnectionHeader().Topic) {
+			return nil
+		}
+
+		t, err := msg.Time()
+		if err != nil {
+			return err
+		}
+
+		data := make(map[string]interface{})
+		err = msg.ViewAs(data)
+		if err != nil {
+			return err
+		}
+
+		// data shares the memory of the record, so it must be encoded before the record is closed
+		hdr := msg.ConnectionHeader()
+		err = writeEvent(w, "message", eventEnvelope{
+			Topic: hdr.Topic,
+			Type:  hdr.Type,
+			Time:  unixSeconds(t),
+			Data:  jsonSafeMap(data),
+		})
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if !realtime {
+		for {
+			record, err := decoder.Read()
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if msg, ok := record.(*rosbag.RecordMessageData); ok {
+				err = send(msg)
+			}
+			record.Close()
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+		}
+	}
+
+	// the topics are matched by send, so that a message isn't sent once per matching pattern
+	err := decoder.Subscribe("re:.*", send)
+	if err != nil {
+		return err
+	}
+
+	var opts []rosbag.PlayerOption
+	if clockHz > 0 {
+		period := time.Duration(float64(time.Second) / clockHz)
+		opts = append(opts, rosbag.WithClockPublisher(period, func(t time.Time) error {
+			err := writeEvent(w, "clock", clockEnvelope{Time: unixSeconds(t)})
+			if err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}))
+	}
+
+	player := rosbag.NewPlayer(decoder, opts...)
+	err = player.Clock().SetRate(speed)
+	if err != nil {
+		return err
+	}
+
+	err = player.Run(ctx)
+	if err != nil && err == ctx.Err() {
+		// the client has gone away
+		return nil
+	}
+	return err
+}
+
+func matchTopic(patterns []*rosbag.Pattern, topic string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if p.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeEvent(w io.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// jsonSafeMap replaces the float values that can't be encoded in JSON, i.e. NaN and infinities,
+// with null. Sensor messages use NaN for invalid readings, e.g. in sensor_msgs/LaserScan.
+func jsonSafeMap(data map[string]interface{}) map[string]interface{} {
+	for k, v := range data {
+		data[k] = jsonSafeValue(v)
+	}
+	return data
+}
+
+func jsonSafeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float32:
+		return jsonSafeFloat(float64(v))
+	case float64:
+		return jsonSafeFloat(v)
+	case []float32:
+		for _, f := range v {
+			if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+				vs := make([]interface{}, len(v))
+				for i, f := range v {
+					vs[i] = jsonSafeFloat(float64(f))
+				}
+				return vs
+			}
+		}
+	case []float64:
+		for _, f := range v {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				vs := make([]interface{}, len(v))
+				for i, f := range v {
+					vs[i] = jsonSafeFloat(f)
+				}
+				return vs
+			}
+		}
+	case map[string]interface{}:
+		return jsonSafeMap(v)
+	case []map[string]interface{}:
+		for _, m := range v {
+			jsonSafeMap(m)
+		}
+	}
+	return v
+}
+
+func jsonSafeFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/tfrecord"
+)
+
+// featureFlags collects the repeated -feature flags.
+type featureFlags []tfrecord.Mapping
+
+func (flags *featureFlags) String() string {
+	return ""
+}
+
+// Set parses a feature mapping of the form name=topic:expr, e.g. image=/camera/image_raw:.data.
+func (flags *featureFlags) Set(value string) error {
+	eq := strings.IndexByte(value, '=')
+	colon := strings.IndexByte(value, ':')
+	if eq <= 0 || colon < eq {
+		return fmt.Errorf("feature must be name=topic:expr, but got %s", value)
+	}
+
+	expr, err := rosbag.CompileExpr(value[colon+1:])
+	if err != nil {
+		return err
+	}
+
+	*flags = append(*flags, tfrecord.Mapping{
+		Feature: value[:eq],
+		Topic:   value[eq+1 : colon],
+		Expr:    expr,
+	})
+	return nil
+}
+
+func runTFRecord(args []string, stdout io.Writer) error {
+	flags := newFlagSet("tfrecord", "[-trigger topic] -feature name=topic:expr... <bag> <out.tfrecord>")
+	trigger := flags.String("trigger", "", "the topic that an example is written for every message of, the default is the topic of the first feature")
+	var features featureFlags
+	flags.Var(&features, "feature", "a feature of the examples as name=topic:expr, e.g. image=/camera/image_raw:.data, it can be repeated")
+	err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	if len(features) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	if *trigger == "" {
+		*trigger = features[0].Topic
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	examples, err := tfrecord.Export(out, rosbag.NewDecoder(in), *trigger, features)
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "wrote %d examples to %s\n", examples, flags.Arg(1))
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func runTopics(args []string, stdout io.Writer) error {
+	flags := newFlagSet("topics", "[-json] <bag>")
+	asJSON := flags.Bool("json", false, "print the topics as a JSON array")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	f, err := rosbag.OpenBag(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	topics, err := rosbag.ReadTopicStats(rosbag.NewDecoder(f))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(topics)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tTYPE\tMD5SUM\tMESSAGES\tFREQUENCY")
+	for _, topic := range topics {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.2f Hz\n", topic.Topic, topic.Type, topic.MD5Sum, topic.Messages, topic.Frequency)
+	}
+	return w.Flush()
+}
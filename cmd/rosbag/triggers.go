@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// parseTrigger parses a -when flag of the form "[name=]topic:condition", e.g.
+// "low_battery=/battery:voltage < 10". The name is the condition by default.
+func parseTrigger(value string) (rosbag.Trigger, error) {
+	colon := strings.IndexByte(value, ':')
+	if colon < 0 {
+		return rosbag.Trigger{}, fmt.Errorf("trigger %q must be [name=]topic:condition", value)
+	}
+
+	trigger := rosbag.Trigger{Topic: value[:colon], Name: strings.TrimSpace(value[colon+1:])}
+	if eq := strings.IndexByte(trigger.Topic, '='); eq >= 0 {
+		trigger.Name, trigger.Topic = trigger.Topic[:eq], trigger.Topic[eq+1:]
+	}
+
+	var err error
+	trigger.Condition, err = rosbag.ParseTriggerCondition(value[colon+1:])
+	return trigger, err
+}
+
+func runTriggers(args []string, stdout io.Writer) error {
+	flags := newFlagSet("triggers", "[-before 5s] [-after 5s] [-window topic]... [-o out_dir] -when [name=]topic:condition... <bag>")
+	var whens, windows topicFlags
+	flags.Var(&whens, "when", "a trigger, e.g. 'low_battery=/battery:voltage < 10' or '/imu:|linear_acceleration| > 29.43', it can be repeated")
+	flags.Var(&windows, "window", "a topic of the messages in the windows, it can be repeated, every topic is in the windows by default")
+	before := flags.Duration("before", 5*time.Second, "the duration of the window before a matching message")
+	after := flags.Duration("after", 5*time.Second, "the duration of the window after a matching message")
+	outDir := flags.String("o", "", "the directory that the window of every event is written to as a bag")
+	err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if len(whens) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	var opts []rosbag.TriggerOption
+	if len(windows) > 0 {
+		opts = append(opts, rosbag.WithWindowTopics(windows...))
+	}
+
+	if *outDir != "" {
+		err = os.MkdirAll(*outDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTRIGGER\tTOPIC\tMESSAGES\tBAG")
+	var events int
+	handle := func(event *rosbag.TriggerEvent) error {
+		events++
+		path := "-"
+		if *outDir != "" {
+			path = filepath.Join(*outDir, fmt.Sprintf("event_%d.bag", events))
+			err := writeWindow(path, event.Window)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%.9f\t%s\t%s\t%d\t%s\n", unixSeconds(event.Message.Time), event.Trigger.Name,
+			event.Message.ConnectionHeader.Topic, len(event.Window), path)
+		return err
+	}
+
+	engine := rosbag.NewTriggerEngine(opts...)
+	for _, when := range whens {
+		trigger, err := parseTrigger(when)
+		if err != nil {
+			return err
+		}
+
+		trigger.Before, trigger.After = *before, *after
+		err = engine.Register(trigger, handle)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := rosbag.OpenBag(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = engine.Run(rosbag.NewDecoder(f))
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeWindow writes the messages of an event window as a bag.
+func writeWindow(path string, window []*rosbag.TriggerMessage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	writer := rosbag.NewWriter(f)
+	conns := make(map[*rosbag.ConnectionHeader]uint32)
+	for _, msg := range window {
+		conn, ok := conns[msg.ConnectionHeader]
+		if !ok {
+			conn, err = writer.WriteConnection(msg.ConnectionHeader)
+			if err != nil {
+				return err
+			}
+			conns[msg.ConnectionHeader] = conn
+		}
+
+		err = writer.WriteMessage(conn, msg.Time, msg.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// goBuiltinTypes are the Go types of the builtin types, the same types that ViewAs decodes them as.
+var goBuiltinTypes = map[rosbag.MessageFieldType]string{
+	rosbag.MessageFieldTypeBool:     "bool",
+	rosbag.MessageFieldTypeInt8:     "int8",
+	rosbag.MessageFieldTypeUint8:    "uint8",
+	rosbag.MessageFieldTypeInt16:    "int16",
+	rosbag.MessageFieldTypeUint16:   "uint16",
+	rosbag.MessageFieldTypeInt32:    "int32",
+	rosbag.MessageFieldTypeUint32:   "uint32",
+	rosbag.MessageFieldTypeInt64:    "int64",
+	rosbag.MessageFieldTypeUint64:   "uint64",
+	rosbag.MessageFieldTypeFloat32:  "float32",
+	rosbag.MessageFieldTypeFloat64:  "float64",
+	rosbag.MessageFieldTypeString:   "string",
+	rosbag.MessageFieldTypeWString:  "string",
+	rosbag.MessageFieldTypeTime:     "time.Time",
+	rosbag.MessageFieldTypeDuration: "time.Duration",
+}
+
+// initialisms are the words that are upper case in Go names, e.g. frame_id is FrameID.
+var initialisms = map[string]bool{
+	"api": true, "http": true, "id": true, "ip": true, "json": true, "url": true, "uuid": true, "xml": true,
+}
+
+// generator collects the message definitions, and generates a struct for every message type.
+type generator struct {
+	pkg      string
+	register bool
+	defs     map[string]*rosbag.MessageDefinition
+	// goNames are the names of the structs of the message types, see generate
+	goNames map[string]string
+}
+
+func newGenerator(pkg string, register bool) *generator {
+	return &generator{
+		pkg:      pkg,
+		register: register,
+		defs:     make(map[string]*rosbag.MessageDefinition),
+	}
+}
+
+// addDefinition adds def of msgType and its nested types. A message type can be added more than
+// once, e.g. from multiple bags, as long as its definitions are the same.
+func (gen *generator) addDefinition(msgType string, def *rosbag.MessageDefinition) error {
+	if prev, ok := gen.defs[msgType]; ok {
+		if prev.MD5Sum() != def.MD5Sum() {
+			return fmt.Errorf("%s has different message definitions", msgType)
+		}
+		return nil
+	}
+	gen.defs[msgType] = def
+
+	for _, field := range def.Fields {
+		if field.Type != rosbag.MessageFieldTypeComplex {
+			continue
+		}
+
+		err := gen.addDefinition(field.MsgType.Type, field.MsgType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBag adds the message types of the connections of the bag at path. The connections are read
+// from the index section when the bag has one, and from the whole bag otherwise.
+func (gen *generator) addBag(path string) error {
+	f, err := rosbag.OpenBag(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := rosbag.NewDecoder(f)
+	defer decoder.Close()
+	err = decoder.Preload()
+	if err != nil {
+		return err
+	}
+
+	if len(decoder.ChunkInfos()) == 0 {
+		for {
+			record, err := decoder.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+			record.Close()
+		}
+	}
+
+	for _, hdr := range decoder.Connections() {
+		err = gen.addDefinition(hdr.Type, &hdr.MessageDefinition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generate returns the formatted Go source of the structs. The structs are named after their
+// message types without the packages, unless multiple packages have the same name, e.g.
+// sensor_msgs/Image is Image, and the package is prepended to the colliding names.
+func (gen *generator) generate() ([]byte, error) {
+	msgTypes := make([]string, 0, len(gen.defs))
+	names := make(map[string]int)
+	for msgType := range gen.defs {
+		msgTypes = append(msgTypes, msgType)
+		names[typeName(msgType)]++
+	}
+	sort.Strings(msgTypes)
+
+	gen.goNames = make(map[string]string, len(msgTypes))
+	var usesTime bool
+	for _, msgType := range msgTypes {
+		name := typeName(msgType)
+		if names[name] > 1 {
+			name = goName(msgType[:strings.IndexByte(msgType, '/')]) + name
+		}
+		gen.goNames[msgType] = name
+
+		for _, field := range gen.defs[msgType].Fields {
+			usesTime = usesTime || field.Type == rosbag.MessageFieldTypeTime || field.Type == rosbag.MessageFieldTypeDuration
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by rosbaggen. DO NOT EDIT.\n\npackage %s\n\n", gen.pkg)
+	if usesTime || gen.register {
+		b.WriteString("import (\n")
+		if usesTime {
+			b.WriteString("\"time\"\n")
+		}
+
+		if gen.register {
+			b.WriteString("\n\"github.com/lherman-cs/go-rosbag\"\n")
+		}
+		b.WriteString(")\n\n")
+	}
+
+	for _, msgType := range msgTypes {
+		err := gen.writeStruct(&b, msgType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if gen.register {
+		b.WriteString("func init() {\n")
+		for _, msgType := range msgTypes {
+			fmt.Fprintf(&b, "rosbag.RegisterType(%q, %s{})\n", msgType, gen.goNames[msgType])
+		}
+		b.WriteString("}\n")
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code is invalid: %w", err)
+	}
+	return src, nil
+}
+
+// writeStruct writes the struct and the constants of msgType to b.
+func (gen *generator) writeStruct(b *bytes.Buffer, msgType string) error {
+	def := gen.defs[msgType]
+	name := gen.goNames[msgType]
+	fmt.Fprintf(b, "// %s is the ROS message type %s.\n", name, msgType)
+	writeComments(b, def.Comments, true)
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for _, field := range def.Fields {
+		writeComments(b, field.Comments, false)
+
+		typ := goBuiltinTypes[field.Type]
+		if field.Type == rosbag.MessageFieldTypeComplex {
+			typ = gen.goNames[field.MsgType.Type]
+		}
+
+		if field.IsArray {
+			typ = "[]" + typ
+		}
+
+		fmt.Fprintf(b, "%s %s `rosbag:%q`", goName(field.Name), typ, field.Name)
+		if field.Comment != "" {
+			fmt.Fprintf(b, " // %s", field.Comment)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n\n")
+
+	if len(def.Constants) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(b, "// The constants of %s.\nconst (\n", msgType)
+	for _, constant := range def.Constants {
+		var value string
+		switch v := constant.Value.(type) {
+		case nil:
+			return fmt.Errorf("%s: invalid value %q of constant %s", msgType, constant.Raw, constant.Name)
+		case string:
+			value = strconv.Quote(v)
+		default:
+			value = fmt.Sprint(v)
+		}
+
+		writeComments(b, constant.Comments, false)
+		fmt.Fprintf(b, "%s%s %s = %s", name, goName(constant.Name), goBuiltinTypes[constant.Type], value)
+		if constant.Comment != "" {
+			fmt.Fprintf(b, " // %s", constant.Comment)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")\n\n")
+	return nil
+}
+
+// writeComments writes the comment lines of a message definition as Go comments. The comments of
+// a message follow its doc comment, so they're separated by an empty comment line.
+func writeComments(b *bytes.Buffer, comments []string, separate bool) {
+	if len(comments) == 0 {
+		return
+	}
+
+	if separate {
+		b.WriteString("//\n")
+	}
+
+	for _, comment := range comments {
+		if comment == "" {
+			b.WriteString("//\n")
+			continue
+		}
+		fmt.Fprintf(b, "// %s\n", comment)
+	}
+}
+
+// typeName returns the name of msgType without the package, e.g. Header for std_msgs/Header.
+func typeName(msgType string) string {
+	return goName(msgType[strings.LastIndexByte(msgType, '/')+1:])
+}
+
+// goName converts a ROS name to an exported Go name, e.g. frame_id to FrameID, and MODE_AUTO to
+// ModeAuto.
+func goName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		if word == "" {
+			continue
+		}
+
+		if strings.ToUpper(word) == word {
+			word = strings.ToLower(word)
+		}
+
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "X" + b.String()
+	}
+	return b.String()
+}
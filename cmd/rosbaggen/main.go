@@ -0,0 +1,96 @@
+// Command rosbaggen generates Go structs with rosbag struct tags from ROS message definitions, so
+// messages can be viewed with compile-time types instead of maps. The definitions are read from
+// .msg files, or from the connection headers of bags:
+//
+//	rosbaggen -pkg msgs -o msgs/msgs.go my_msgs/msg/Status.msg recording.bag
+//
+// Every message type gets a struct, including the nested types, and the constants of a message
+// are generated as Go constants that are prefixed with the name of its struct. The nested types of
+// a .msg file are looked up in the ROS packages next to the package of the file, and in the -I
+// directories, the same way as catkin, e.g. std_msgs/Header is std_msgs/msg/Header.msg.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// errUsage is returned when the arguments are invalid, the usage has already been printed by the
+// flag set.
+var errUsage = errors.New("invalid usage")
+
+// includeFlags collects the repeated -I flags.
+type includeFlags []string
+
+func (flags *includeFlags) String() string {
+	return ""
+}
+
+func (flags *includeFlags) Set(value string) error {
+	*flags = append(*flags, value)
+	return nil
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("rosbaggen", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: rosbaggen [-pkg name] [-o file] [-I dir]... [-register] <file.msg|file.bag>...")
+		flags.PrintDefaults()
+	}
+
+	pkg := flags.String("pkg", "msgs", "the name of the Go package of the generated file")
+	out := flags.String("o", "", "write the generated file to the path instead of stdout")
+	register := flags.Bool("register", false, "register the structs with rosbag.RegisterType in an init function, so they're returned by record.Decode()")
+	var includes includeFlags
+	flags.Var(&includes, "I", "a directory of ROS packages to look up the nested types of .msg files in, it can be repeated")
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	gen := newGenerator(*pkg, *register)
+	for _, path := range flags.Args() {
+		if strings.HasSuffix(path, ".msg") {
+			err = gen.addMsgFile(path, includes)
+		} else {
+			err = gen.addBag(path)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	src, err := gen.generate()
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(*out, src, 0644)
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == errUsage {
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rosbaggen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const exampleBag = "../../examples/logging/example.bag"
+
+func writeMsgFile(t *testing.T, dir, msgType, raw string) {
+	parts := strings.SplitN(msgType, "/", 2)
+	path := filepath.Join(dir, parts[0], "msg", parts[1]+".msg")
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(raw), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateMsgFiles(t *testing.T) {
+	dir := t.TempDir()
+	includeDir := t.TempDir()
+	writeMsgFile(t, dir, "my_msgs/Status", `# The status of a robot.
+
+uint8 MODE_AUTO=1 # driving by itself
+string NAME=robot
+Header header
+Item[] items
+other_msgs/Item[2] others
+float64 battery_level # percent
+`)
+	writeMsgFile(t, dir, "my_msgs/Item", "int32 id\n")
+	writeMsgFile(t, dir, "other_msgs/Item", "duration age\n")
+	writeMsgFile(t, includeDir, "std_msgs/Header", "uint32 seq\ntime stamp\nstring frame_id\n")
+
+	var buf bytes.Buffer
+	err := run([]string{"-pkg", "robot", "-I", includeDir, filepath.Join(dir, "my_msgs", "msg", "Status.msg")}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "// Code generated by rosbaggen. DO NOT EDIT.\n" + `
+package robot
+
+import (
+	"time"
+)
+
+// MyMsgsItem is the ROS message type my_msgs/Item.
+type MyMsgsItem struct {
+	ID int32 ` + "`rosbag:\"id\"`" + `
+}
+
+// Status is the ROS message type my_msgs/Status.
+//
+// The status of a robot.
+type Status struct {
+	Header       Header          ` + "`rosbag:\"header\"`" + `
+	Items        []MyMsgsItem    ` + "`rosbag:\"items\"`" + `
+	Others       []OtherMsgsItem ` + "`rosbag:\"others\"`" + `
+	BatteryLevel float64         ` + "`rosbag:\"battery_level\"`" + ` // percent
+}
+
+// The constants of my_msgs/Status.
+const (
+	StatusModeAuto uint8  = 1 // driving by itself
+	StatusName     string = "robot"
+)
+
+// OtherMsgsItem is the ROS message type other_msgs/Item.
+type OtherMsgsItem struct {
+	Age time.Duration ` + "`rosbag:\"age\"`" + `
+}
+
+// Header is the ROS message type std_msgs/Header.
+type Header struct {
+	Seq     uint32    ` + "`rosbag:\"seq\"`" + `
+	Stamp   time.Time ` + "`rosbag:\"stamp\"`" + `
+	FrameID string    ` + "`rosbag:\"frame_id\"`" + `
+}
+`
+	if buf.String() != expected {
+		t.Fatalf("expected the generated code to be:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+
+	// the nested types are only looked up in the packages next to the file, and in the -I directories
+	err = run([]string{filepath.Join(dir, "my_msgs", "msg", "Status.msg")}, &buf)
+	if err == nil || !strings.Contains(err.Error(), "std_msgs/Header") {
+		t.Fatalf("expected an error for the missing std_msgs/Header, but got %v", err)
+	}
+}
+
+func TestGenerateBag(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "msgs.go")
+	err := run([]string{"-register", "-o", out, exampleBag}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parser.ParseFile(token.NewFileSet(), out, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"type Log struct {",
+		"LogDebug int8 = 1",
+		"FrameID string `rosbag:\"frame_id\"`",
+		"Transforms []TransformStamped `rosbag:\"transforms\"`",
+		`rosbag.RegisterType("rosgraph_msgs/Log", Log{})`,
+	} {
+		if !bytes.Contains(src, []byte(expected)) {
+			t.Fatalf("expected the generated code to have %q, but got:\n%s", expected, src)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	err := run(nil, ioutil.Discard)
+	if err != errUsage {
+		t.Fatalf("expected %v, but got %v", errUsage, err)
+	}
+}
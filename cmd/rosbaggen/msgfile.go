@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// builtinTypes are the builtin type names of message definitions, the other types are messages.
+var builtinTypes = map[string]bool{
+	"bool": true, "byte": true, "char": true,
+	"int8": true, "uint8": true, "int16": true, "uint16": true,
+	"int32": true, "uint32": true, "int64": true, "uint64": true,
+	"float32": true, "float64": true,
+	"string": true, "wstring": true, "time": true, "duration": true,
+}
+
+// addMsgFile adds the message type of the .msg file at path, and its nested types. The type is
+// named after the ROS package of the file, which is the directory above msg/. The nested types
+// are looked up in the packages next to it, and in includes.
+func (gen *generator) addMsgFile(path string, includes []string) error {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) == "msg" {
+		dir = filepath.Dir(dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	msgType := filepath.Base(abs) + "/" + strings.TrimSuffix(filepath.Base(path), ".msg")
+	raw, err := catMsgFile(msgType, path, append([]string{filepath.Dir(abs)}, includes...))
+	if err != nil {
+		return err
+	}
+
+	def, err := rosbag.ParseMessageDefinition(msgType, raw)
+	if err != nil {
+		return err
+	}
+	return gen.addDefinition(msgType, def)
+}
+
+// catMsgFile returns the message definition of the .msg file at path with the definitions of its
+// nested types in "MSG: <type>" sections, like the message definitions in connection headers.
+func catMsgFile(msgType string, path string, dirs []string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var raw strings.Builder
+	raw.Write(b)
+	seen := map[string]bool{msgType: true}
+	queue := nestedTypes(msgType, string(b))
+	for len(queue) > 0 {
+		nested := queue[0]
+		queue = queue[1:]
+		if seen[nested] {
+			continue
+		}
+		seen[nested] = true
+
+		b, err := readMsgFile(nested, dirs)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&raw, "\n%s\nMSG: %s\n", strings.Repeat("=", 80), nested)
+		raw.Write(b)
+		queue = append(queue, nestedTypes(nested, string(b))...)
+	}
+	return raw.String(), nil
+}
+
+// readMsgFile reads the .msg file of msgType, e.g. std_msgs/Header from std_msgs/msg/Header.msg,
+// in the first directory of dirs that has it.
+func readMsgFile(msgType string, dirs []string) ([]byte, error) {
+	parts := strings.SplitN(msgType, "/", 2)
+	for _, dir := range dirs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, parts[0], "msg", parts[1]+".msg"))
+		if err == nil {
+			return b, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("can't find the message definition of %s in %s", msgType, strings.Join(dirs, ", "))
+}
+
+// nestedTypes returns the message types of the fields of the message definition raw of msgType.
+// The types without a package are in the package of msgType, except for Header, which is
+// std_msgs/Header.
+func nestedTypes(msgType string, raw string) []string {
+	pkg := msgType[:strings.IndexByte(msgType, '/')+1]
+	var types []string
+	for _, line := range strings.Split(raw, "\n") {
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "=") {
+			continue
+		}
+
+		typ := fields[0]
+		if idx := strings.IndexAny(typ, "[<"); idx != -1 {
+			typ = typ[:idx]
+		}
+
+		switch {
+		case builtinTypes[typ]:
+			continue
+		case typ == "Header":
+			typ = "std_msgs/Header"
+		case !strings.Contains(typ, "/"):
+			typ = pkg + typ
+		default:
+			// ROS2 types have the msg namespace, e.g. std_msgs/msg/Header
+			typ = strings.Replace(typ, "/msg/", "/", 1)
+		}
+		types = append(types, typ)
+	}
+	return types
+}
@@ -0,0 +1,46 @@
+package rosbag
+
+import (
+	"io"
+	"sync"
+)
+
+var (
+	codecRegistryMu sync.RWMutex
+	codecRegistry   = make(map[Compression]ChunkCodec)
+)
+
+// ChunkCodec compresses and decompresses the data of chunks for a compression that is not built
+// in, see RegisterCompression.
+type ChunkCodec interface {
+	// NewReader returns a reader of the decompressed data of the compressed data in r.
+	NewReader(r io.Reader) (io.Reader, error)
+	// NewWriter returns a writer that compresses the data written to it to w. The compressed data
+	// is only complete after the writer is closed.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// RegisterCompression registers codec for the chunks whose compression header field is
+// compression, so they can be read by the decoders, and written by Transcode. The builtin
+// compressions can't be replaced. Registering the same compression again replaces the previous
+// codec.
+func RegisterCompression(compression Compression, codec ChunkCodec) {
+	codecRegistryMu.Lock()
+	codecRegistry[compression] = codec
+	codecRegistryMu.Unlock()
+}
+
+// registeredCodec returns the codec that is registered for compression.
+func registeredCodec(compression Compression) (ChunkCodec, bool) {
+	codecRegistryMu.RLock()
+	codec, ok := codecRegistry[compression]
+	codecRegistryMu.RUnlock()
+	return codec, ok
+}
+
+// unregisterCompression removes the codec that is registered for compression.
+func unregisterCompression(compression Compression) {
+	codecRegistryMu.Lock()
+	delete(codecRegistry, compression)
+	codecRegistryMu.Unlock()
+}
@@ -0,0 +1,213 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Column is the values of a field across the messages of a topic, sorted by their record times.
+// Values is a slice of the Go type of the field, see Data Type Mapping, e.g. []float64 for a
+// float64 field, or []string for a string field. Values is nil when there are no messages.
+type Column struct {
+	Time   []time.Time
+	Values interface{}
+}
+
+// Len is the number of values.
+func (column *Column) Len() int {
+	return len(column.Time)
+}
+
+// Float64s returns the values as float64s like FieldPath.Float64. An error is returned when the
+// field is not numeric.
+func (column *Column) Float64s() ([]float64, error) {
+	if values, ok := column.Values.([]float64); ok {
+		return values, nil
+	}
+
+	floats := make([]float64, column.Len())
+	if column.Len() == 0 {
+		return floats, nil
+	}
+
+	values := reflect.ValueOf(column.Values)
+	for i := range floats {
+		f, ok := numericValue(values.Index(i).Interface())
+		if !ok {
+			return nil, fmt.Errorf("column of %s is not numeric", values.Type().Elem())
+		}
+		floats[i] = f
+	}
+	return floats, nil
+}
+
+// ExtractColumn returns the values of path in the messages of topic, which can be a pattern, see
+// Pattern. Only the field of path is decoded from every message, see FieldPath. When bag has an
+// index section, the chunks without messages of topic are skipped without being read or
+// decompressed, otherwise the whole bag is streamed. bag is read from its current offset, which
+// must be the beginning of the bag, and it's seeked back to the offset at the end.
+func ExtractColumn(bag io.ReadSeeker, topic string, path *FieldPath) (*Column, error) {
+	pattern, err := CompilePattern(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := bag.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := NewDecoder(bag)
+	err = decoder.Preload()
+	if err != nil {
+		return nil, err
+	}
+
+	builder := columnBuilder{path: path}
+	if len(decoder.chunkInfos) > 0 {
+		err = builder.readIndexed(decoder, bag, start, pattern)
+	} else {
+		_, err = bag.Seek(start, io.SeekStart)
+		if err == nil {
+			err = streamSampleMessages(bag, pattern, builder.add)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = bag.Seek(start, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return builder.column(), nil
+}
+
+// columnBuilder appends the values of path to a slice of their type.
+type columnBuilder struct {
+	path   *FieldPath
+	times  []time.Time
+	values reflect.Value
+}
+
+func (builder *columnBuilder) add(record *RecordMessageData) error {
+	t, err := record.Time()
+	if err != nil {
+		return err
+	}
+
+	v, err := builder.path.Value(&record.ConnectionHeader().MessageDefinition, record.Data())
+	if err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(v)
+	if !builder.values.IsValid() {
+		builder.values = reflect.MakeSlice(reflect.SliceOf(value.Type()), 0, 64)
+	} else if elem := builder.values.Type().Elem(); value.Type() != elem {
+		// the connections of a topic can have different types
+		return fmt.Errorf("field %s is a %s on %s, but it's a %s on the previous messages", builder.path, value.Type(), record.ConnectionHeader().Topic, elem)
+	}
+
+	builder.times = append(builder.times, t)
+	builder.values = reflect.Append(builder.values, value)
+	return nil
+}
+
+// readIndexed reads the chunks that have messages on the topics of pattern. decoder must have
+// preloaded the index section.
+func (builder *columnBuilder) readIndexed(decoder *Decoder, bag io.ReadSeeker, start int64, pattern *Pattern) error {
+	for _, chunkInfo := range decoder.chunkInfos {
+		counts, err := chunkInfo.MessageCounts()
+		if err != nil {
+			return err
+		}
+
+		if !decoder.hasTopic(counts, pattern) {
+			continue
+		}
+
+		chunkPos, err := chunkInfo.ChunkPos()
+		if err != nil {
+			return err
+		}
+
+		_, err = bag.Seek(start+int64(chunkPos), io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		record, _, err := readRawRecord(bag)
+		if err != nil {
+			return err
+		}
+
+		data, err := decompressChunk(&RecordChunk{RecordBase: record})
+		if err != nil {
+			return err
+		}
+
+		for len(data) > 0 {
+			record, err := decoder.sliceRecord(&data)
+			if err != nil {
+				return err
+			}
+
+			if msg, ok := record.(*RecordMessageData); ok && pattern.Match(msg.ConnectionHeader().Topic) {
+				err = builder.add(msg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasTopic reports whether any connection of counts is on a topic of pattern.
+func (decoder *Decoder) hasTopic(counts map[uint32]uint32, pattern *Pattern) bool {
+	for conn, count := range counts {
+		hdr, ok := decoder.conns[conn]
+		if count > 0 && ok && pattern.Match(hdr.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// column returns the values sorted by time, messages of multiple connections are not ordered in
+// the bag.
+func (builder *columnBuilder) column() *Column {
+	column := Column{Time: builder.times}
+	if !builder.values.IsValid() {
+		return &column
+	}
+
+	less := func(i, j int) bool {
+		return builder.times[i].Before(builder.times[j])
+	}
+	if !sort.SliceIsSorted(builder.times, less) {
+		order := make([]int, len(builder.times))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return builder.times[order[i]].Before(builder.times[order[j]])
+		})
+
+		times := make([]time.Time, len(order))
+		values := reflect.MakeSlice(builder.values.Type(), len(order), len(order))
+		for i, j := range order {
+			times[i] = builder.times[j]
+			values.Index(i).Set(builder.values.Index(j))
+		}
+		column.Time = times
+		builder.values = values
+	}
+
+	column.Values = builder.values.Interface()
+	return &column
+}
@@ -0,0 +1,114 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtractColumn(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 2}, {0, 1}},
+		[]indexedTestMessage{{1, 3}, {0, 4}},
+		[]indexedTestMessage{{0, 5}},
+	)
+
+	x := MustCompileFieldPath("x")
+	column, err := ExtractColumn(bytes.NewReader(raw), "/a", x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the values are sorted by time
+	if diff := cmp.Diff([]uint8{1, 2, 4, 5}, column.Values); diff != "" {
+		t.Fatalf("values are not matched:\n\n%s", diff)
+	}
+
+	if column.Len() != 4 || !column.Time[0].Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected 4 values from 1s, but got %v", column.Time)
+	}
+
+	floats, err := column.Float64s()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]float64{1, 2, 4, 5}, floats); diff != "" {
+		t.Fatalf("floats are not matched:\n\n%s", diff)
+	}
+
+	// the chunks without /b are not read, so a corrupted chunk doesn't matter
+	units, err := Partition(bytes.NewReader(raw), WithWorkUnitSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unit := range []*WorkUnit{units[0], units[2]} {
+		// the length of the first header field is out of range
+		copy(raw[unit.Offset+lenInBytes:], bytes.Repeat([]byte{0xff}, lenInBytes))
+	}
+
+	column, err = ExtractColumn(bytes.NewReader(raw), "/b", x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]uint8{3}, column.Values); diff != "" {
+		t.Fatalf("values are not matched:\n\n%s", diff)
+	}
+
+	_, err = ExtractColumn(bytes.NewReader(raw), "/a", x)
+	if err == nil {
+		t.Fatal("expected the corrupted chunks of /a to fail")
+	}
+}
+
+func TestExtractColumnStreamed(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "string name",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"a", "b"} {
+		err = writer.WriteMessage(conn, time.Unix(int64(i), 0), append(uint32Bytes(1), name...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	column, err := ExtractColumn(bytes.NewReader(buf.Bytes()), "/test", MustCompileFieldPath("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b"}, column.Values); diff != "" {
+		t.Fatalf("values are not matched:\n\n%s", diff)
+	}
+
+	_, err = column.Float64s()
+	if err == nil {
+		t.Fatal("expected a string column to not be numeric")
+	}
+
+	column, err = ExtractColumn(bytes.NewReader(buf.Bytes()), "/other", MustCompileFieldPath("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if column.Len() != 0 || column.Values != nil {
+		t.Fatalf("expected an empty column, but got %+v", column)
+	}
+}
@@ -0,0 +1,469 @@
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// CopyOption configures the records that are dropped by Copy.
+type CopyOption func(*copyConfig)
+
+type copyConfig struct {
+	topics []*Pattern
+	ranges []copyRange
+	err    error
+}
+
+// copyRange is a dropped time range [start, end), a zero start or end leaves it open on that side.
+type copyRange struct {
+	start time.Time
+	end   time.Time
+}
+
+// WithDroppedTopics drops the messages on the topics that match any of topics, see Pattern. It
+// can be given multiple times.
+func WithDroppedTopics(topics ...string) CopyOption {
+	return func(config *copyConfig) {
+		for _, topic := range topics {
+			pattern, err := CompilePattern(topic)
+			if err != nil && config.err == nil {
+				config.err = err
+			}
+			config.topics = append(config.topics, pattern)
+		}
+	}
+}
+
+// WithDroppedTimeRange drops the messages whose record times are in [start, end). A zero start or
+// end leaves the range open on that side. It can be given multiple times.
+func WithDroppedTimeRange(start, end time.Time) CopyOption {
+	return func(config *copyConfig) {
+		config.ranges = append(config.ranges, copyRange{start: start, end: end})
+	}
+}
+
+// dropsTopic reports whether the messages on topic are dropped.
+func (config *copyConfig) dropsTopic(topic string) bool {
+	for _, pattern := range config.topics {
+		if pattern.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropsTime reports whether the messages at t are dropped.
+func (config *copyConfig) dropsTime(t time.Time) bool {
+	for _, r := range config.ranges {
+		if (r.start.IsZero() || !t.Before(r.start)) && (r.end.IsZero() || t.Before(r.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropsWindow reports whether every time in [start, end] is dropped.
+func (config *copyConfig) dropsWindow(start, end time.Time) bool {
+	for _, r := range config.ranges {
+		if (r.start.IsZero() || !start.Before(r.start)) && (r.end.IsZero() || end.Before(r.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsWindow reports whether any time in [start, end] is dropped.
+func (config *copyConfig) overlapsWindow(start, end time.Time) bool {
+	for _, r := range config.ranges {
+		if (r.start.IsZero() || !end.Before(r.start)) && (r.end.IsZero() || start.Before(r.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CopyStats counts what Copy has done with the chunks of the bag.
+type CopyStats struct {
+	// Copied chunks are written byte for byte with their index data records
+	Copied int
+	// Rewritten chunks have both dropped and kept messages, so they're decompressed, filtered, and
+	// compressed again with their compression
+	Rewritten int
+	// Dropped chunks only have dropped messages
+	Dropped int
+}
+
+// Copy copies the indexed bag in r to w without the messages that are dropped by opts, e.g.
+// WithDroppedTopics("/camera/*"). The chunks whose messages are all kept are copied byte for
+// byte without being decompressed, so filtering a large bag is mostly bound by I/O, and the kept
+// data is bit-identical. Only the chunks that have both dropped and kept messages are rewritten
+// with their compression. The chunks are located with the index section, and a new index section
+// is written. The bag header is rewritten at the end with the position of the index section,
+// which is why w must be seekable.
+//
+// Like rosbag and Writer do, the connection record of a connection is expected in the chunk of
+// its first message. When that chunk is dropped, the connection record is written to the next
+// chunk of the connection that is kept, which is rewritten then.
+func Copy(w io.WriteSeeker, r io.ReaderAt, opts ...CopyOption) (*CopyStats, error) {
+	var config copyConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.err != nil {
+		return nil, config.err
+	}
+
+	bag := io.NewSectionReader(r, 0, math.MaxInt64)
+	decoder := NewDecoder(bag)
+	err := decoder.Preload()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoder.chunkInfos) == 0 {
+		return nil, errNotIndexed
+	}
+
+	chunks, err := locateWorkChunks(bag, 0, decoder.chunkInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	copier := bagCopier{
+		config:     &config,
+		r:          r,
+		w:          w,
+		conns:      decoder.conns,
+		first:      make(map[uint32]int64),
+		written:    make(map[uint32]bool),
+		chunkInfos: make(map[int64]*RecordChunkInfo, len(decoder.chunkInfos)),
+	}
+	for _, chunkInfo := range decoder.chunkInfos {
+		// the positions have been validated by locateWorkChunks
+		pos, _ := chunkInfo.ChunkPos()
+		copier.chunkInfos[int64(pos)] = chunkInfo
+	}
+
+	for _, chunk := range chunks {
+		for conn, count := range chunk.counts {
+			if _, ok := copier.first[conn]; !ok && count > 0 {
+				copier.first[conn] = chunk.pos
+			}
+		}
+	}
+
+	versionLine := fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor)
+	bagHeader, _, err := readRawRecord(io.NewSectionReader(r, int64(len(versionLine)), math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	// the extra fields are kept, e.g. the fields of the encryptor of the chunks
+	fields, err := bagHeaderExtraFields(bagHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// the bag header is padded, so it can be rewritten with the index_pos at the end
+	header, err := encodeBagHeader(0, 0, 0, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copier.write([]byte(versionLine), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats CopyStats
+	for _, chunk := range chunks {
+		switch copier.classify(chunk) {
+		case copyChunkVerbatim:
+			stats.Copied++
+			err = copier.copyChunk(chunk)
+		case copyChunkRewrite:
+			var kept bool
+			kept, err = copier.rewriteChunk(chunk)
+			if kept {
+				stats.Rewritten++
+			} else {
+				stats.Dropped++
+			}
+		default:
+			stats.Dropped++
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	indexPos := copier.offset
+	ids := make([]uint32, 0, len(copier.written))
+	for conn := range copier.written {
+		ids = append(ids, conn)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, conn := range ids {
+		err = copier.write(encodeConnectionRecord(conn, copier.conns[conn]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = copier.write(copier.index...)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err = encodeBagHeader(uint64(indexPos), uint32(len(ids)), uint32(len(copier.index)), fields)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Seek(int64(len(versionLine)), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+type copyChunkAction uint8
+
+const (
+	copyChunkDrop copyChunkAction = iota
+	copyChunkVerbatim
+	copyChunkRewrite
+)
+
+// bagCopier writes the kept chunks of Copy.
+type bagCopier struct {
+	config *copyConfig
+	r      io.ReaderAt
+	w      io.Writer
+	offset int64
+	conns  map[uint32]*ConnectionHeader
+	// first is the position of the first chunk of every connection in the source bag, which has
+	// its connection record
+	first map[uint32]int64
+	// written marks the connections whose connection records have been written
+	written map[uint32]bool
+	// chunkInfos are the chunk infos of the source bag by their chunk positions, and index are the
+	// chunk infos of the copy
+	chunkInfos map[int64]*RecordChunkInfo
+	index      [][]byte
+}
+
+func (copier *bagCopier) Write(b []byte) (int, error) {
+	n, err := copier.w.Write(b)
+	copier.offset += int64(n)
+	return n, err
+}
+
+func (copier *bagCopier) write(records ...[]byte) error {
+	for _, record := range records {
+		_, err := copier.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keeps reports whether the messages on conn are kept.
+func (copier *bagCopier) keeps(conn uint32) bool {
+	hdr, ok := copier.conns[conn]
+	return ok && !copier.config.dropsTopic(hdr.Topic)
+}
+
+// classify decides what to do with chunk based on its chunk info.
+func (copier *bagCopier) classify(chunk workChunk) copyChunkAction {
+	if copier.config.dropsWindow(chunk.startTime, chunk.endTime) {
+		return copyChunkDrop
+	}
+
+	kept, rewrite := false, false
+	for conn, count := range chunk.counts {
+		if count == 0 {
+			continue
+		}
+
+		if !copier.keeps(conn) {
+			rewrite = true
+			continue
+		}
+		kept = true
+
+		// the connection record is in a chunk that hasn't been written
+		if !copier.written[conn] && copier.first[conn] != chunk.pos {
+			rewrite = true
+		}
+	}
+
+	switch {
+	case !kept:
+		return copyChunkDrop
+	case rewrite || copier.config.overlapsWindow(chunk.startTime, chunk.endTime):
+		return copyChunkRewrite
+	default:
+		return copyChunkVerbatim
+	}
+}
+
+// copyChunk writes chunk and its index data records as they are in the source bag.
+func (copier *bagCopier) copyChunk(chunk workChunk) error {
+	pos := copier.offset
+	r := bufio.NewReader(io.NewSectionReader(copier.r, chunk.pos, math.MaxInt64))
+	_, err := io.CopyN(copier, r, chunk.length)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, op, err := readRawRecord(r)
+		if err == io.EOF || (err == nil && op != OpIndexData) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = copier.write(record.Raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	for conn, count := range chunk.counts {
+		if count > 0 {
+			copier.written[conn] = true
+		}
+	}
+
+	posField := make([]byte, 8)
+	endian.PutUint64(posField, uint64(pos))
+	chunkInfo := copier.chunkInfos[chunk.pos]
+	header, err := replaceHeaderField(chunkInfo.Header(), "chunk_pos", posField)
+	if err != nil {
+		return err
+	}
+
+	copier.index = append(copier.index, appendRecord(nil, header, chunkInfo.Data()))
+	return nil
+}
+
+// rewriteChunk writes chunk without its dropped messages, and compresses it again with the same
+// compression. kept is false when every message is dropped, and nothing is written.
+func (copier *bagCopier) rewriteChunk(chunk workChunk) (kept bool, err error) {
+	record, _, err := readRawRecord(io.NewSectionReader(copier.r, chunk.pos, chunk.length))
+	if err != nil {
+		return false, err
+	}
+
+	chunkRecord := RecordChunk{RecordBase: record}
+	compression, err := chunkRecord.Compression()
+	if err != nil {
+		return false, err
+	}
+
+	src, err := decompressChunk(&chunkRecord)
+	if err != nil {
+		return false, err
+	}
+
+	var data []byte
+	index := newChunkIndex()
+	// the connection records are only written once their connections are known to be written
+	written := make(map[uint32]bool)
+	br := bytes.NewReader(src)
+	for {
+		record, op, err := readRawRecord(br)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("chunk at %d: %w", chunk.pos, err)
+		}
+
+		switch op {
+		case OpConnection:
+			conn, err := (&RecordConnection{RecordBase: record}).Conn()
+			if err != nil {
+				return false, err
+			}
+
+			if copier.keeps(conn) && !copier.written[conn] && !written[conn] {
+				data = append(data, record.Raw...)
+				written[conn] = true
+			}
+		case OpMessageData:
+			msg := RecordMessageData{RecordBase: record}
+			conn, err := msg.Conn()
+			if err != nil {
+				return false, err
+			}
+
+			t, err := msg.Time()
+			if err != nil {
+				return false, err
+			}
+
+			if !copier.keeps(conn) || copier.config.dropsTime(t) {
+				continue
+			}
+
+			if !copier.written[conn] && !written[conn] {
+				data = append(data, encodeConnectionRecord(conn, copier.conns[conn])...)
+				written[conn] = true
+			}
+
+			index.add(conn, t, len(data))
+			data = append(data, record.Raw...)
+		default:
+			data = append(data, record.Raw...)
+		}
+	}
+
+	if len(index.conns) == 0 {
+		return false, nil
+	}
+
+	raw, err := compressChunk(compression, data)
+	if err != nil {
+		return false, err
+	}
+
+	pos := copier.offset
+	err = copier.write(raw, index.appendIndexData(nil))
+	if err != nil {
+		return false, err
+	}
+
+	for _, conn := range index.conns {
+		copier.written[conn] = true
+	}
+
+	copier.index = append(copier.index, encodeChunkInfoRecord(uint64(pos), index.start, index.end, index.counts()))
+	return true, nil
+}
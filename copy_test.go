@@ -0,0 +1,191 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func copyBytes(t *testing.T, raw []byte, opts ...CopyOption) (*CopyStats, []byte) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stats, err := Copy(out, bytes.NewReader(raw), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = out.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats, copied
+}
+
+func TestCopy(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}},
+		[]indexedTestMessage{{0, 3}, {0, 4}},
+		[]indexedTestMessage{{1, 5}, {0, 6}},
+		[]indexedTestMessage{{1, 7}},
+	)
+
+	decoder := NewDecoder(bytes.NewReader(raw))
+	err := decoder.Preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := locateWorkChunks(bytes.NewReader(raw), 0, decoder.chunkInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name          string
+		Opts          []CopyOption
+		Expected      []indexedTestMessage
+		ExpectedStats CopyStats
+		// Verbatim are the indices of the chunks that are expected in the copy byte for byte
+		Verbatim []int
+	}{
+		{
+			Name:          "Topic",
+			Opts:          []CopyOption{WithDroppedTopics("/b")},
+			Expected:      []indexedTestMessage{{0, 1}, {0, 3}, {0, 4}, {0, 6}},
+			ExpectedStats: CopyStats{Copied: 1, Rewritten: 2, Dropped: 1},
+			Verbatim:      []int{1},
+		},
+		{
+			Name:          "Time Range",
+			Opts:          []CopyOption{WithDroppedTimeRange(time.Unix(3, 0), time.Unix(5, 0))},
+			Expected:      []indexedTestMessage{{0, 1}, {1, 2}, {1, 5}, {0, 6}, {1, 7}},
+			ExpectedStats: CopyStats{Copied: 3, Dropped: 1},
+			Verbatim:      []int{0, 2, 3},
+		},
+		{
+			// the connection record of /a is in the dropped part of the first chunk
+			Name:          "Connection Record",
+			Opts:          []CopyOption{WithDroppedTimeRange(time.Time{}, time.Unix(2, 0)), WithDroppedTopics("/c")},
+			Expected:      []indexedTestMessage{{1, 2}, {0, 3}, {0, 4}, {1, 5}, {0, 6}, {1, 7}},
+			ExpectedStats: CopyStats{Copied: 2, Rewritten: 2},
+			Verbatim:      []int{2, 3},
+		},
+		{
+			Name:          "Nothing",
+			Expected:      []indexedTestMessage{{0, 1}, {1, 2}, {0, 3}, {0, 4}, {1, 5}, {0, 6}, {1, 7}},
+			ExpectedStats: CopyStats{Copied: 4},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			stats, copied := copyBytes(t, raw, testCase.Opts...)
+			if diff := cmp.Diff(&testCase.ExpectedStats, stats); diff != "" {
+				t.Fatalf("stats are not matched:\n\n%s", diff)
+			}
+
+			findings, err := Check(bytes.NewReader(copied))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(findings) != 0 {
+				t.Fatalf("expected the copy to be valid, but got %v", findings)
+			}
+
+			if diff := cmp.Diff(testCase.Expected, readIndexedTestMessages(t, NewDecoder(bytes.NewReader(copied)))); diff != "" {
+				t.Fatalf("messages are not matched:\n\n%s", diff)
+			}
+
+			// the new index section locates the messages
+			slice, err := Slice(bytes.NewReader(copied), time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(testCase.Expected, readIndexedTestMessages(t, slice.NewDecoder())); diff != "" {
+				t.Fatalf("messages of the index are not matched:\n\n%s", diff)
+			}
+
+			for _, i := range testCase.Verbatim {
+				chunk := raw[chunks[i].pos : chunks[i].pos+chunks[i].length]
+				if !bytes.Contains(copied, chunk) {
+					t.Fatalf("expected chunk %d to be copied byte for byte", i)
+				}
+			}
+
+			if testCase.ExpectedStats == (CopyStats{Copied: len(chunks)}) && !bytes.Equal(copied, raw) {
+				t.Fatal("expected the bag to be copied byte for byte")
+			}
+		})
+	}
+}
+
+func TestCopyNotIndexed(t *testing.T) {
+	_, err := Copy(nil, bytes.NewReader(writeDeltaTestBag(t)))
+	if err != errNotIndexed {
+		t.Fatalf("expected an unindexed bag to fail, but got %v", err)
+	}
+}
+
+func TestCopyCompressed(t *testing.T) {
+	for _, compression := range []Compression{CompressionLZ4, CompressionSnappy} {
+		t.Run(string(compression), func(t *testing.T) {
+			raw := transcodeBytes(t, writeIndexedTestBag(t,
+				[]indexedTestMessage{{0, 1}, {1, 2}},
+				[]indexedTestMessage{{0, 3}},
+			), compression)
+
+			stats, copied := copyBytes(t, raw, WithDroppedTopics("/b"))
+			if diff := cmp.Diff(&CopyStats{Copied: 1, Rewritten: 1}, stats); diff != "" {
+				t.Fatalf("stats are not matched:\n\n%s", diff)
+			}
+
+			expected := []indexedTestMessage{{0, 1}, {0, 3}}
+			if diff := cmp.Diff(expected, readIndexedTestMessages(t, NewDecoder(bytes.NewReader(copied)))); diff != "" {
+				t.Fatalf("messages are not matched:\n\n%s", diff)
+			}
+
+			r := bytes.NewReader(copied[len("#ROSBAG V2.0\n"):])
+			for {
+				record, op, err := readRawRecord(r)
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if op != OpChunk {
+					continue
+				}
+
+				chunkCompression, err := (&RecordChunk{RecordBase: record}).Compression()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if chunkCompression != compression {
+					t.Fatalf("expected the chunks to keep their compression, but got %s", chunkCompression)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,530 @@
+// Package dataset exports the camera images, lidar sweeps, calibrations, and ego poses of a bag to
+// KITTI-like or nuScenes-like directory layouts, so that recorded drives can be fed to perception
+// training and evaluation pipelines without custom loaders.
+//
+// The intrinsics of the cameras are taken from the sensor_msgs/CameraInfo messages next to their
+// image topics, and the extrinsics of the sensors from the transforms of /tf_static, which are
+// chained from the frames of the sensors up to the ego frame. A frame that isn't connected to the
+// ego frame by /tf_static is assumed to be the ego frame.
+package dataset
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/camera"
+)
+
+// Layout is the directory layout of an exported dataset.
+type Layout string
+
+// The layouts of the datasets. KITTI datasets follow the raw data of KITTI, where every sensor
+// has a directory of numbered files and a timestamps.txt, and the calibrations are text files.
+// nuScenes datasets have the files of the sensors under sweeps, and the JSON tables of nuScenes
+// under v1.0-rosbag, so they can be loaded with the nuScenes devkit.
+const (
+	LayoutKITTI    Layout = "kitti"
+	LayoutNuScenes Layout = "nuscenes"
+)
+
+// The ROS types of the messages that can be the ego poses.
+const (
+	OdometryType                  = "nav_msgs/Odometry"
+	PoseStampedType               = "geometry_msgs/PoseStamped"
+	PoseWithCovarianceStampedType = "geometry_msgs/PoseWithCovarianceStamped"
+)
+
+const (
+	staticTransformTopic = "/tf_static"
+	defaultEgoFrame      = "base_link"
+	jpegQuality          = 95
+)
+
+// posePrefixes are the paths of the geometry_msgs/Pose in the messages of the ego poses.
+var posePrefixes = map[string]string{
+	OdometryType:                  "pose.pose.",
+	PoseStampedType:               "pose.",
+	PoseWithCovarianceStampedType: "pose.pose.",
+}
+
+var (
+	errNoSensors = errors.New("the bag doesn't have camera or lidar messages to export")
+)
+
+type config struct {
+	layout    Layout
+	cameras   map[string]bool
+	lidars    map[string]bool
+	poseTopic string
+	egoFrame  string
+}
+
+// Option configures what is exported, and how it's laid out.
+type Option func(*config)
+
+// WithLayout sets the directory layout of the dataset. The default is LayoutKITTI.
+func WithLayout(layout Layout) Option {
+	return func(cfg *config) {
+		cfg.layout = layout
+	}
+}
+
+// WithCameras sets the topics of the cameras. By default, every topic of sensor_msgs/Image or
+// sensor_msgs/CompressedImage messages is a camera.
+func WithCameras(topics ...string) Option {
+	return func(cfg *config) {
+		cfg.cameras = make(map[string]bool)
+		for _, topic := range topics {
+			cfg.cameras[topic] = true
+		}
+	}
+}
+
+// WithLidars sets the topics of the lidars. By default, every topic of sensor_msgs/PointCloud2
+// messages is a lidar.
+func WithLidars(topics ...string) Option {
+	return func(cfg *config) {
+		cfg.lidars = make(map[string]bool)
+		for _, topic := range topics {
+			cfg.lidars[topic] = true
+		}
+	}
+}
+
+// WithPoseTopic sets the topic of the ego poses, which has nav_msgs/Odometry,
+// geometry_msgs/PoseStamped, or geometry_msgs/PoseWithCovarianceStamped messages. By default, the
+// first topic of these types is used.
+func WithPoseTopic(topic string) Option {
+	return func(cfg *config) {
+		cfg.poseTopic = topic
+	}
+}
+
+// WithEgoFrame sets the frame of the vehicle, which the extrinsics of the sensors are relative to.
+// The default is base_link.
+func WithEgoFrame(frame string) Option {
+	return func(cfg *config) {
+		cfg.egoFrame = frame
+	}
+}
+
+// Stats describes an exported dataset.
+type Stats struct {
+	// Cameras and Lidars are the topics of the sensors in the order of their numbers
+	Cameras []string
+	Lidars  []string
+	Images  int
+	Sweeps  int
+	Poses   int
+}
+
+// Export runs decoder to the end, and writes the dataset to dir, which is created when it doesn't
+// exist. The sensors are numbered in the order of their first messages, and the samples are timed
+// by the record times of the messages. opts can be used to select the sensors and the layout, see
+// Option.
+func Export(dir string, decoder *rosbag.Decoder, opts ...Option) (*Stats, error) {
+	cfg := config{
+		layout:   LayoutKITTI,
+		egoFrame: defaultEgoFrame,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.layout != LayoutKITTI && cfg.layout != LayoutNuScenes {
+		return nil, fmt.Errorf("unknown dataset layout %q", string(cfg.layout))
+	}
+
+	exp := exporter{
+		dir:        dir,
+		cfg:        &cfg,
+		sensors:    make(map[string]*sensor),
+		models:     camera.NewModels(),
+		transforms: make(map[string]parentTransform),
+	}
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		err = exp.add(msg)
+		msg.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(exp.cameras) == 0 && len(exp.lidars) == 0 {
+		return nil, errNoSensors
+	}
+
+	sort.SliceStable(exp.poses, func(i, j int) bool {
+		return exp.poses[i].t.Before(exp.poses[j].t)
+	})
+
+	stats := Stats{Poses: len(exp.poses)}
+	for _, s := range exp.cameras {
+		stats.Cameras = append(stats.Cameras, s.topic)
+		stats.Images += len(s.samples)
+		s.model = exp.models.ForImage(s.topic)
+	}
+
+	for _, s := range exp.lidars {
+		stats.Lidars = append(stats.Lidars, s.topic)
+		stats.Sweeps += len(s.samples)
+	}
+
+	if cfg.layout == LayoutKITTI {
+		return &stats, exp.writeKITTI()
+	}
+	return &stats, exp.writeNuScenes()
+}
+
+// sample is a file of a sensor.
+type sample struct {
+	t time.Time
+	// file is relative to the directory of the dataset
+	file          string
+	width, height int
+}
+
+// sensor is a camera or a lidar.
+type sensor struct {
+	topic   string
+	name    string
+	index   int
+	frameID string
+	lidar   bool
+	// model is the calibration of a camera, it's nil when the camera doesn't have CameraInfo
+	// messages
+	model   *camera.Model
+	samples []sample
+}
+
+type timedPose struct {
+	t    time.Time
+	pose rigid
+}
+
+// parentTransform is a transform of /tf_static, which maps the points of a child frame to parent.
+type parentTransform struct {
+	parent    string
+	transform rigid
+}
+
+type exporter struct {
+	dir        string
+	cfg        *config
+	sensors    map[string]*sensor
+	cameras    []*sensor
+	lidars     []*sensor
+	models     *camera.Models
+	transforms map[string]parentTransform
+	poses      []timedPose
+}
+
+func (exp *exporter) add(msg *rosbag.RecordMessageData) error {
+	hdr := msg.ConnectionHeader()
+	switch {
+	case hdr.Topic == staticTransformTopic:
+		return exp.addTransforms(msg)
+	case hdr.Type == camera.MessageType:
+		return exp.models.Add(msg)
+	case exp.isPoseTopic(hdr.Topic, hdr.Type):
+		return exp.addPose(msg)
+	case exp.isCamera(hdr.Topic, hdr.Type):
+		return exp.addImage(msg)
+	case exp.isLidar(hdr.Topic, hdr.Type):
+		return exp.addSweep(msg)
+	}
+	return nil
+}
+
+func (exp *exporter) isCamera(topic, msgType string) bool {
+	if exp.cfg.cameras != nil {
+		return exp.cfg.cameras[topic]
+	}
+	return camera.IsImage(msgType)
+}
+
+func (exp *exporter) isLidar(topic, msgType string) bool {
+	if exp.cfg.lidars != nil {
+		return exp.cfg.lidars[topic]
+	}
+	return msgType == camera.PointCloudType
+}
+
+func (exp *exporter) isPoseTopic(topic, msgType string) bool {
+	if exp.cfg.poseTopic == "" && posePrefixes[msgType] != "" {
+		exp.cfg.poseTopic = topic
+	}
+	return topic == exp.cfg.poseTopic
+}
+
+// sensor returns the sensor of the topic, and numbers it when it's new.
+func (exp *exporter) sensor(topic string, lidar bool) *sensor {
+	s := exp.sensors[topic]
+	if s != nil {
+		return s
+	}
+
+	s = &sensor{topic: topic, lidar: lidar}
+	if lidar {
+		s.index = len(exp.lidars)
+		exp.lidars = append(exp.lidars, s)
+	} else {
+		s.index = len(exp.cameras)
+		exp.cameras = append(exp.cameras, s)
+	}
+
+	switch {
+	case exp.cfg.layout == LayoutNuScenes && lidar:
+		s.name = fmt.Sprintf("LIDAR_%02d", s.index)
+	case exp.cfg.layout == LayoutNuScenes:
+		s.name = fmt.Sprintf("CAM_%02d", s.index)
+	case lidar && s.index == 0:
+		s.name = "velodyne_points"
+	case lidar:
+		s.name = fmt.Sprintf("velodyne_points_%02d", s.index)
+	default:
+		s.name = fmt.Sprintf("image_%02d", s.index)
+	}
+
+	exp.sensors[topic] = s
+	return s
+}
+
+// sampleFile returns the path of the next sample of s relative to the directory of the dataset.
+func (exp *exporter) sampleFile(s *sensor, t time.Time) string {
+	if exp.cfg.layout == LayoutNuScenes {
+		ext := ".jpg"
+		if s.lidar {
+			ext = ".pcd.bin"
+		}
+		return filepath.Join("sweeps", s.name, fmt.Sprintf("%s__%d%s", s.name, t.UnixNano()/1000, ext))
+	}
+
+	ext := ".png"
+	if s.lidar {
+		ext = ".bin"
+	}
+	return filepath.Join(s.name, "data", fmt.Sprintf("%010d%s", len(s.samples), ext))
+}
+
+func (exp *exporter) addImage(msg *rosbag.RecordMessageData) error {
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	var stamped stampedMessage
+	err = msg.ViewAs(&stamped)
+	if err != nil {
+		return err
+	}
+
+	img, err := camera.Image(msg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg.ConnectionHeader().Topic, err)
+	}
+
+	s := exp.sensor(msg.ConnectionHeader().Topic, false)
+	s.frameID = stamped.Header.FrameID
+	file := exp.sampleFile(s, t)
+	err = writeFile(filepath.Join(exp.dir, file), func(w io.Writer) error {
+		if exp.cfg.layout == LayoutNuScenes {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+		}
+		return png.Encode(w, img)
+	})
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	s.samples = append(s.samples, sample{t: t, file: file, width: bounds.Dx(), height: bounds.Dy()})
+	return nil
+}
+
+func (exp *exporter) addSweep(msg *rosbag.RecordMessageData) error {
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	points, intensities, frameID, err := camera.PointCloudIntensity(msg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg.ConnectionHeader().Topic, err)
+	}
+
+	s := exp.sensor(msg.ConnectionHeader().Topic, true)
+	s.frameID = frameID
+	file := exp.sampleFile(s, t)
+
+	// KITTI sweeps are x, y, z, and intensity, nuScenes sweeps also have the ring index, which
+	// isn't known
+	stride := 4
+	if exp.cfg.layout == LayoutNuScenes {
+		stride = 5
+	}
+
+	b := make([]byte, len(points)*stride*4)
+	for i, p := range points {
+		values := [4]float64{p.X, p.Y, p.Z, intensities[i]}
+		for j, v := range values {
+			binary.LittleEndian.PutUint32(b[(i*stride+j)*4:], math.Float32bits(float32(v)))
+		}
+	}
+
+	err = writeFile(filepath.Join(exp.dir, file), func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.samples = append(s.samples, sample{t: t, file: file})
+	return nil
+}
+
+func (exp *exporter) addPose(msg *rosbag.RecordMessageData) error {
+	prefix, ok := posePrefixes[msg.ConnectionHeader().Type]
+	if !ok {
+		return fmt.Errorf("poses can't be read from %s messages", msg.ConnectionHeader().Type)
+	}
+
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	lazy := msg.Lazy()
+	paths := []string{"position.x", "position.y", "position.z", "orientation.w", "orientation.x", "orientation.y", "orientation.z"}
+	var values [7]float64
+	for i, path := range paths {
+		values[i], err = lazy.Float64(prefix + path)
+		if err != nil {
+			return err
+		}
+	}
+
+	pose := newRigid(vector3{values[0], values[1], values[2]}, quaternion{values[3], values[4], values[5], values[6]})
+	exp.poses = append(exp.poses, timedPose{t: t, pose: pose})
+	return nil
+}
+
+// stampedMessage is the header of a message.
+type stampedMessage struct {
+	Header struct {
+		FrameID string `rosbag:"frame_id,copy"`
+	} `rosbag:"header"`
+}
+
+type transformStamped struct {
+	Header struct {
+		FrameID string `rosbag:"frame_id,copy"`
+	} `rosbag:"header"`
+	ChildFrameID string `rosbag:"child_frame_id,copy"`
+	Transform    struct {
+		Translation vector3    `rosbag:"translation"`
+		Rotation    quaternion `rosbag:"rotation"`
+	} `rosbag:"transform"`
+}
+
+type tfMessage struct {
+	Transforms []transformStamped `rosbag:"transforms"`
+}
+
+func (exp *exporter) addTransforms(msg *rosbag.RecordMessageData) error {
+	var tf tfMessage
+	err := msg.ViewAs(&tf)
+	if err != nil {
+		return err
+	}
+
+	for _, transform := range tf.Transforms {
+		exp.transforms[transform.ChildFrameID] = parentTransform{
+			parent:    transform.Header.FrameID,
+			transform: newRigid(transform.Transform.Translation, transform.Transform.Rotation),
+		}
+	}
+	return nil
+}
+
+// extrinsic returns the transform from the frame of s to the ego frame.
+func (exp *exporter) extrinsic(s *sensor) rigid {
+	transform := identity
+	frame := s.frameID
+	for i := 0; frame != exp.cfg.egoFrame; i++ {
+		edge, ok := exp.transforms[frame]
+		// a chain that is longer than the number of transforms has a cycle
+		if !ok || i > len(exp.transforms) {
+			return identity
+		}
+
+		transform = edge.transform.mul(transform)
+		frame = edge.parent
+	}
+	return transform
+}
+
+// poseAt returns the ego pose that is the closest to t, it's the identity when the bag doesn't
+// have ego poses.
+func (exp *exporter) poseAt(t time.Time) rigid {
+	if len(exp.poses) == 0 {
+		return identity
+	}
+
+	i := sort.Search(len(exp.poses), func(i int) bool {
+		return !exp.poses[i].t.Before(t)
+	})
+
+	if i == len(exp.poses) || (i > 0 && t.Sub(exp.poses[i-1].t) < exp.poses[i].t.Sub(t)) {
+		i--
+	}
+	return exp.poses[i].pose
+}
+
+// writeFile creates the file at path and its directory, and writes it with write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = write(f)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,394 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+const headerDefinition = `
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+const poseDefinition = `
+================================================================================
+MSG: geometry_msgs/Pose
+geometry_msgs/Point position
+geometry_msgs/Quaternion orientation
+================================================================================
+MSG: geometry_msgs/Point
+float64 x
+float64 y
+float64 z
+================================================================================
+MSG: geometry_msgs/Quaternion
+float64 x
+float64 y
+float64 z
+float64 w
+`
+
+var testDefinitions = map[string]string{
+	"sensor_msgs/Image": `std_msgs/Header header
+uint32 height
+uint32 width
+string encoding
+uint8 is_bigendian
+uint32 step
+uint8[] data` + headerDefinition,
+	"sensor_msgs/CameraInfo": `std_msgs/Header header
+uint32 height
+uint32 width
+string distortion_model
+float64[] D
+float64[9] K
+float64[9] R
+float64[12] P` + headerDefinition,
+	"sensor_msgs/PointCloud2": `std_msgs/Header header
+uint32 height
+uint32 width
+sensor_msgs/PointField[] fields
+bool is_bigendian
+uint32 point_step
+uint32 row_step
+uint8[] data
+bool is_dense` + headerDefinition + `================================================================================
+MSG: sensor_msgs/PointField
+string name
+uint32 offset
+uint8 datatype
+uint32 count
+`,
+	"nav_msgs/Odometry": `std_msgs/Header header
+string child_frame_id
+geometry_msgs/PoseWithCovariance pose` + headerDefinition + `================================================================================
+MSG: geometry_msgs/PoseWithCovariance
+geometry_msgs/Pose pose
+float64[36] covariance` + poseDefinition,
+	"tf2_msgs/TFMessage": `geometry_msgs/TransformStamped[] transforms
+================================================================================
+MSG: geometry_msgs/TransformStamped
+std_msgs/Header header
+string child_frame_id
+geometry_msgs/Transform transform` + headerDefinition + `================================================================================
+MSG: geometry_msgs/Transform
+geometry_msgs/Vector3 translation
+geometry_msgs/Quaternion rotation
+================================================================================
+MSG: geometry_msgs/Vector3
+float64 x
+float64 y
+float64 z
+================================================================================
+MSG: geometry_msgs/Quaternion
+float64 x
+float64 y
+float64 z
+float64 w
+`,
+}
+
+type testEncoder struct {
+	b []byte
+}
+
+func (enc *testEncoder) uint32(v uint32) *testEncoder {
+	enc.b = append(enc.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	return enc
+}
+
+func (enc *testEncoder) string(s string) *testEncoder {
+	enc.uint32(uint32(len(s)))
+	enc.b = append(enc.b, s...)
+	return enc
+}
+
+func (enc *testEncoder) float64s(vs ...float64) *testEncoder {
+	for _, v := range vs {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		enc.b = append(enc.b, b[:]...)
+	}
+	return enc
+}
+
+func (enc *testEncoder) float32s(vs ...float32) *testEncoder {
+	for _, v := range vs {
+		enc.uint32(math.Float32bits(v))
+	}
+	return enc
+}
+
+// header encodes a std_msgs/Header without a stamp.
+func (enc *testEncoder) header(frameID string) *testEncoder {
+	return enc.uint32(0).uint32(0).uint32(0).string(frameID)
+}
+
+// sin45 is the sine and the cosine of 45 degrees, so (sin45, 0, 0, sin45) rotates 90 degrees
+// around z.
+var sin45 = math.Sqrt(0.5)
+
+var testK = [9]float64{100, 0, 1, 0, 100, 0.5, 0, 0, 1}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	conns := make(map[string]uint32)
+	write := func(topic, msgType string, t0 time.Duration, enc *testEncoder) {
+		conn, ok := conns[topic]
+		if !ok {
+			conn = builder.Connection(topic, msgType, testDefinitions[msgType])
+			conns[topic] = conn
+		}
+		builder.Message(conn, time.Unix(0, int64(t0)), enc.b)
+	}
+
+	tf := new(testEncoder).uint32(2)
+	tf.header("base_link").string("lidar").float64s(1, 0, 2, 0, 0, 0, 1)
+	tf.header("base_link").string("camera_optical").float64s(2, 0, 1, 0, 0, sin45, sin45)
+	write("/tf_static", "tf2_msgs/TFMessage", 0, tf)
+
+	info := new(testEncoder).header("camera_optical").uint32(1).uint32(2).string("plumb_bob")
+	info.uint32(5).float64s(0.1, 0, 0, 0, 0).float64s(testK[:]...)
+	info.float64s(1, 0, 0, 0, 1, 0, 0, 0, 1).float64s(100, 0, 1, 0, 0, 100, 0.5, 0, 0, 0, 1, 0)
+	write("/camera/camera_info", "sensor_msgs/CameraInfo", 0, info)
+
+	for i := 0; i < 2; i++ {
+		t0 := time.Duration(i+1) * time.Second
+		odom := new(testEncoder).header("odom").string("base_link")
+		odom.float64s(float64(i), 0, 0, 0, 0, 0, 1).float64s(make([]float64, 36)...)
+		write("/odom", "nav_msgs/Odometry", t0, odom)
+
+		img := new(testEncoder).header("camera_optical").uint32(1).uint32(2).string("rgb8")
+		img.b = append(img.b, 0)
+		img.uint32(6).uint32(6)
+		img.b = append(img.b, 0xff, 0, 0, 0, byte(i), 0xff)
+		write("/camera/image_raw", "sensor_msgs/Image", t0+10*time.Millisecond, img)
+
+		cloud := new(testEncoder).header("lidar").uint32(1).uint32(2).uint32(4)
+		for j, name := range []string{"x", "y", "z", "intensity"} {
+			cloud.string(name).uint32(uint32(j * 4))
+			cloud.b = append(cloud.b, 7)
+			cloud.uint32(1)
+		}
+		cloud.b = append(cloud.b, 0)
+		cloud.uint32(16).uint32(32).uint32(32)
+		cloud.float32s(1, 2, 3, 10, -1, 0.5, 0, float32(i))
+		cloud.b = append(cloud.b, 1)
+		write("/velodyne_points", "sensor_msgs/PointCloud2", t0+50*time.Millisecond, cloud)
+	}
+	return builder.Bytes()
+}
+
+func readFile(t *testing.T, path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// readKITTICalib reads the numeric values of a KITTI calibration file.
+func readKITTICalib(t *testing.T, path string) map[string][]float64 {
+	calib := make(map[string][]float64)
+	for _, line := range strings.Split(strings.TrimSpace(string(readFile(t, path))), "\n") {
+		parts := strings.SplitN(line, ": ", 2)
+		var values []float64
+		for _, field := range strings.Fields(parts[1]) {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				values = nil
+				break
+			}
+			values = append(values, v)
+		}
+
+		if values != nil {
+			calib[parts[0]] = values
+		}
+	}
+	return calib
+}
+
+func readFloat32s(t *testing.T, path string) []float64 {
+	b := readFile(t, path)
+	values := make([]float64, len(b)/4)
+	for i := range values {
+		values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:])))
+	}
+	return values
+}
+
+var approx = cmpopts.EquateApprox(0, 1e-6)
+
+func TestExportKITTI(t *testing.T) {
+	dir := t.TempDir()
+	stats, err := Export(dir, rosbag.NewDecoder(bytes.NewReader(writeTestBag(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStats := Stats{Cameras: []string{"/camera/image_raw"}, Lidars: []string{"/velodyne_points"}, Images: 2, Sweeps: 2, Poses: 2}
+	if diff := cmp.Diff(&expectedStats, stats); diff != "" {
+		t.Fatalf("stats are not matched:\n\n%s", diff)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "image_00", "data", "0000000001.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, g, b, _ := img.At(1, 0).RGBA(); img.Bounds().Dx() != 2 || r != 0 || g != 0x101 || b != 0xffff {
+		t.Fatalf("unexpected image %v", img.Bounds())
+	}
+
+	timestamps := "1970-01-01 00:00:01.010000000\n1970-01-01 00:00:02.010000000\n"
+	if actual := string(readFile(t, filepath.Join(dir, "image_00", "timestamps.txt"))); actual != timestamps {
+		t.Fatalf("expected the timestamps to be %q, but got %q", timestamps, actual)
+	}
+
+	sweep := readFloat32s(t, filepath.Join(dir, "velodyne_points", "data", "0000000001.bin"))
+	if diff := cmp.Diff([]float64{1, 2, 3, 10, -1, 0.5, 0, 1}, sweep); diff != "" {
+		t.Fatalf("sweep is not matched:\n\n%s", diff)
+	}
+
+	camToCam := readKITTICalib(t, filepath.Join(dir, "calib_cam_to_cam.txt"))
+	if diff := cmp.Diff(testK[:], camToCam["K_00"]); diff != "" {
+		t.Fatalf("K is not matched:\n\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]float64{2, 1}, camToCam["S_00"]); diff != "" {
+		t.Fatalf("S is not matched:\n\n%s", diff)
+	}
+
+	// the origin of the lidar is 1 meter to the left and 1 meter above the camera, whose x axis
+	// points to the right of the vehicle
+	veloToCam := readKITTICalib(t, filepath.Join(dir, "calib_velo_to_cam.txt"))
+	expectedVeloToCam := map[string][]float64{
+		"R": {0, 1, 0, -1, 0, 0, 0, 0, 1},
+		"T": {0, 1, 1},
+	}
+	if diff := cmp.Diff(expectedVeloToCam, veloToCam, approx); diff != "" {
+		t.Fatalf("velo_to_cam is not matched:\n\n%s", diff)
+	}
+
+	poses := strings.Split(strings.TrimSpace(string(readFile(t, filepath.Join(dir, "poses.txt")))), "\n")
+	if len(poses) != 2 || !strings.HasSuffix(strings.Fields(poses[1])[3], "1.000000e+00") {
+		t.Fatalf("unexpected poses %q", poses)
+	}
+}
+
+func TestExportNuScenes(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Export(dir, rosbag.NewDecoder(bytes.NewReader(writeTestBag(t))), WithLayout(LayoutNuScenes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readTable := func(name string, table interface{}) {
+		err := json.Unmarshal(readFile(t, filepath.Join(dir, nuScenesVersion, name+".json")), table)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var samples []nuScenesSample
+	readTable("sample", &samples)
+	if len(samples) != 2 || samples[0].Timestamp != 1050000 || samples[0].Next != samples[1].Token {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+
+	var calibrated []nuScenesCalibratedSensor
+	readTable("calibrated_sensor", &calibrated)
+	expectedCalibrated := []nuScenesCalibratedSensor{
+		{
+			Token:       nuScenesToken("calibrated_sensor", "CAM_00"),
+			SensorToken: nuScenesToken("sensor", "CAM_00"),
+			Translation: [3]float64{2, 0, 1},
+			Rotation:    [4]float64{sin45, 0, 0, sin45},
+			Intrinsic:   [][]float64{testK[0:3], testK[3:6], testK[6:9]},
+		},
+		{
+			Token:       nuScenesToken("calibrated_sensor", "LIDAR_00"),
+			SensorToken: nuScenesToken("sensor", "LIDAR_00"),
+			Translation: [3]float64{1, 0, 2},
+			Rotation:    [4]float64{1, 0, 0, 0},
+			Intrinsic:   [][]float64{},
+		},
+	}
+	if diff := cmp.Diff(expectedCalibrated, calibrated, approx); diff != "" {
+		t.Fatalf("calibrated sensors are not matched:\n\n%s", diff)
+	}
+
+	var sampleData []nuScenesSampleData
+	readTable("sample_data", &sampleData)
+	if len(sampleData) != 4 {
+		t.Fatalf("expected 4 sample data, but got %d", len(sampleData))
+	}
+
+	image, sweep := sampleData[1], sampleData[3]
+	if image.Filename != "sweeps/CAM_00/CAM_00__2010000.jpg" || image.IsKeyFrame || image.SampleToken != samples[1].Token || image.Width != 2 {
+		t.Fatalf("unexpected image %+v", image)
+	}
+
+	if sweep.Filename != "sweeps/LIDAR_00/LIDAR_00__2050000.pcd.bin" || !sweep.IsKeyFrame || sweep.Prev != sampleData[2].Token {
+		t.Fatalf("unexpected sweep %+v", sweep)
+	}
+
+	if values := readFloat32s(t, filepath.Join(dir, sweep.Filename)); len(values) != 10 || values[5] != -1 {
+		t.Fatalf("unexpected sweep values %v", values)
+	}
+
+	var egoPoses []nuScenesEgoPose
+	readTable("ego_pose", &egoPoses)
+	if len(egoPoses) != 4 || egoPoses[3].Token != sweep.EgoPoseToken || egoPoses[3].Translation != [3]float64{1, 0, 0} {
+		t.Fatalf("unexpected ego poses %+v", egoPoses)
+	}
+
+	var annotations []interface{}
+	readTable("sample_annotation", &annotations)
+	if len(annotations) != 0 {
+		t.Fatalf("expected no annotations, but got %v", annotations)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	raw := writeTestBag(t)
+	_, err := Export(t.TempDir(), rosbag.NewDecoder(bytes.NewReader(raw)), WithLayout("coco"))
+	if err == nil || !strings.Contains(err.Error(), "unknown dataset layout") {
+		t.Fatalf("expected an unknown layout to fail, but got %v", err)
+	}
+
+	_, err = Export(t.TempDir(), rosbag.NewDecoder(bytes.NewReader(raw)), WithCameras(), WithLidars())
+	if err != errNoSensors {
+		t.Fatalf("expected %v, but got %v", errNoSensors, err)
+	}
+
+	_, err = Export(t.TempDir(), rosbag.NewDecoder(bytes.NewReader(raw)), WithPoseTopic("/camera/image_raw"))
+	if err == nil || !strings.Contains(err.Error(), "poses can't be read") {
+		t.Fatalf("expected a pose topic of images to fail, but got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const kittiTimeLayout = "2006-01-02 15:04:05.000000000"
+
+// writeKITTI writes the timestamps of the sensors, the calibrations, and the ego poses. The
+// extrinsics in the calibrations are relative to the first camera like in KITTI.
+func (exp *exporter) writeKITTI() error {
+	for _, sensors := range [][]*sensor{exp.cameras, exp.lidars} {
+		for _, s := range sensors {
+			times := make([]time.Time, len(s.samples))
+			for i, sample := range s.samples {
+				times[i] = sample.t
+			}
+
+			err := writeKITTITimestamps(filepath.Join(exp.dir, s.name, "timestamps.txt"), times)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(exp.cameras) > 0 {
+		err := writeFile(filepath.Join(exp.dir, "calib_cam_to_cam.txt"), exp.writeCamToCam)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(exp.cameras) > 0 && len(exp.lidars) > 0 {
+		err := writeFile(filepath.Join(exp.dir, "calib_velo_to_cam.txt"), func(w io.Writer) error {
+			velo := exp.cameraExtrinsic(exp.lidars[0])
+			fmt.Fprintf(w, "calib_time: %s\n", exp.calibTime())
+			fmt.Fprintf(w, "R: %s\n", formatKITTIRotation(velo))
+			_, err := fmt.Fprintf(w, "T: %s\n", formatKITTIFloats(velo.translation.X, velo.translation.Y, velo.translation.Z))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(exp.poses) == 0 {
+		return nil
+	}
+
+	times := make([]time.Time, len(exp.poses))
+	for i, pose := range exp.poses {
+		times[i] = pose.t
+	}
+
+	err := writeKITTITimestamps(filepath.Join(exp.dir, "poses_timestamps.txt"), times)
+	if err != nil {
+		return err
+	}
+
+	// the poses are relative to the first pose like the poses of the KITTI odometry benchmark
+	return writeFile(filepath.Join(exp.dir, "poses.txt"), func(w io.Writer) error {
+		origin := exp.poses[0].pose.inverse()
+		for _, pose := range exp.poses {
+			m := origin.mul(pose.pose).matrix()
+			_, err := fmt.Fprintln(w, formatKITTIFloats(m[:]...))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeCamToCam writes the intrinsics of the cameras with CameraInfo messages, and their
+// extrinsics relative to the first camera.
+func (exp *exporter) writeCamToCam(w io.Writer) error {
+	fmt.Fprintf(w, "calib_time: %s\n", exp.calibTime())
+	for _, s := range exp.cameras {
+		model := s.model
+		if model == nil {
+			continue
+		}
+
+		extrinsic := exp.cameraExtrinsic(s).inverse()
+		fmt.Fprintf(w, "S_%02d: %s\n", s.index, formatKITTIFloats(float64(model.Width), float64(model.Height)))
+		fmt.Fprintf(w, "K_%02d: %s\n", s.index, formatKITTIFloats(model.K[:]...))
+		fmt.Fprintf(w, "D_%02d: %s\n", s.index, formatKITTIFloats(model.D...))
+		fmt.Fprintf(w, "R_%02d: %s\n", s.index, formatKITTIRotation(extrinsic))
+		fmt.Fprintf(w, "T_%02d: %s\n", s.index, formatKITTIFloats(extrinsic.translation.X, extrinsic.translation.Y, extrinsic.translation.Z))
+		fmt.Fprintf(w, "S_rect_%02d: %s\n", s.index, formatKITTIFloats(float64(model.Width), float64(model.Height)))
+		fmt.Fprintf(w, "R_rect_%02d: %s\n", s.index, formatKITTIFloats(model.R[:]...))
+		_, err := fmt.Fprintf(w, "P_rect_%02d: %s\n", s.index, formatKITTIFloats(model.P[:]...))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cameraExtrinsic returns the transform from the frame of s to the frame of the first camera.
+func (exp *exporter) cameraExtrinsic(s *sensor) rigid {
+	return exp.extrinsic(exp.cameras[0]).inverse().mul(exp.extrinsic(s))
+}
+
+// calibTime returns the time of the first sample in the format of the KITTI calibrations.
+func (exp *exporter) calibTime() string {
+	var first time.Time
+	for _, sensors := range [][]*sensor{exp.cameras, exp.lidars} {
+		for _, s := range sensors {
+			if len(s.samples) > 0 && (first.IsZero() || s.samples[0].t.Before(first)) {
+				first = s.samples[0].t
+			}
+		}
+	}
+	return first.UTC().Format("02-Jan-2006 15:04:05")
+}
+
+func writeKITTITimestamps(path string, times []time.Time) error {
+	return writeFile(path, func(w io.Writer) error {
+		for _, t := range times {
+			_, err := fmt.Fprintln(w, t.UTC().Format(kittiTimeLayout))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func formatKITTIRotation(transform rigid) string {
+	m := transform.matrix()
+	return formatKITTIFloats(m[0], m[1], m[2], m[4], m[5], m[6], m[8], m[9], m[10])
+}
+
+func formatKITTIFloats(values ...float64) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = fmt.Sprintf("%e", v)
+	}
+	return strings.Join(formatted, " ")
+}
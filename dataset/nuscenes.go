@@ -0,0 +1,252 @@
+package dataset
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const nuScenesVersion = "v1.0-rosbag"
+
+// nuScenesEmptyTables are the tables of the annotations and the maps, which are written empty
+// since the devkit loads every table.
+var nuScenesEmptyTables = []string{"attribute", "category", "instance", "map", "sample_annotation", "visibility"}
+
+type nuScenesSensor struct {
+	Token    string `json:"token"`
+	Channel  string `json:"channel"`
+	Modality string `json:"modality"`
+}
+
+type nuScenesCalibratedSensor struct {
+	Token       string      `json:"token"`
+	SensorToken string      `json:"sensor_token"`
+	Translation [3]float64  `json:"translation"`
+	Rotation    [4]float64  `json:"rotation"`
+	Intrinsic   [][]float64 `json:"camera_intrinsic"`
+}
+
+type nuScenesEgoPose struct {
+	Token       string     `json:"token"`
+	Timestamp   int64      `json:"timestamp"`
+	Rotation    [4]float64 `json:"rotation"`
+	Translation [3]float64 `json:"translation"`
+}
+
+type nuScenesSample struct {
+	Token      string `json:"token"`
+	Timestamp  int64  `json:"timestamp"`
+	Prev       string `json:"prev"`
+	Next       string `json:"next"`
+	SceneToken string `json:"scene_token"`
+}
+
+type nuScenesSampleData struct {
+	Token                 string `json:"token"`
+	SampleToken           string `json:"sample_token"`
+	EgoPoseToken          string `json:"ego_pose_token"`
+	CalibratedSensorToken string `json:"calibrated_sensor_token"`
+	Timestamp             int64  `json:"timestamp"`
+	FileFormat            string `json:"fileformat"`
+	IsKeyFrame            bool   `json:"is_key_frame"`
+	Height                int    `json:"height"`
+	Width                 int    `json:"width"`
+	Filename              string `json:"filename"`
+	Prev                  string `json:"prev"`
+	Next                  string `json:"next"`
+}
+
+type nuScenesScene struct {
+	Token            string `json:"token"`
+	LogToken         string `json:"log_token"`
+	Samples          int    `json:"nbr_samples"`
+	FirstSampleToken string `json:"first_sample_token"`
+	LastSampleToken  string `json:"last_sample_token"`
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+}
+
+type nuScenesLog struct {
+	Token        string `json:"token"`
+	Logfile      string `json:"logfile"`
+	Vehicle      string `json:"vehicle"`
+	DateCaptured string `json:"date_captured"`
+	Location     string `json:"location"`
+}
+
+// nuScenesToken returns a deterministic token of a row, so that exporting a bag again gives the
+// same tables.
+func nuScenesToken(table string, key string) string {
+	sum := md5.Sum([]byte(table + "/" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func nuScenesTimestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+// writeNuScenes writes the tables of a single scene. The samples, i.e. the key frames, are the
+// sweeps of the first lidar, or the images of the first camera when there is no lidar, and the
+// other files are paired with their closest samples. Every file has its own ego pose, which is the
+// closest ego pose in time.
+func (exp *exporter) writeNuScenes() error {
+	var key *sensor
+	if len(exp.lidars) > 0 {
+		key = exp.lidars[0]
+	} else {
+		key = exp.cameras[0]
+	}
+
+	scene := nuScenesScene{
+		Token:    nuScenesToken("scene", ""),
+		LogToken: nuScenesToken("log", ""),
+		Samples:  len(key.samples),
+		Name:     "scene-0001",
+	}
+
+	samples := make([]nuScenesSample, len(key.samples))
+	for i, sample := range key.samples {
+		samples[i] = nuScenesSample{
+			Token:      nuScenesToken("sample", fmt.Sprint(i)),
+			Timestamp:  nuScenesTimestamp(sample.t),
+			SceneToken: scene.Token,
+		}
+	}
+	linkNuScenesSamples(samples)
+	scene.FirstSampleToken = samples[0].Token
+	scene.LastSampleToken = samples[len(samples)-1].Token
+
+	sensors := []nuScenesSensor{}
+	calibratedSensors := []nuScenesCalibratedSensor{}
+	egoPoses := []nuScenesEgoPose{}
+	sampleData := []nuScenesSampleData{}
+	for _, s := range append(append([]*sensor(nil), exp.cameras...), exp.lidars...) {
+		sensor := nuScenesSensor{Token: nuScenesToken("sensor", s.name), Channel: s.name, Modality: "camera"}
+		if s.lidar {
+			sensor.Modality = "lidar"
+		}
+		sensors = append(sensors, sensor)
+
+		extrinsic := exp.extrinsic(s)
+		calibrated := nuScenesCalibratedSensor{
+			Token:       nuScenesToken("calibrated_sensor", s.name),
+			SensorToken: sensor.Token,
+			Translation: extrinsic.translation.array(),
+			Rotation:    extrinsic.rotation.array(),
+			Intrinsic:   [][]float64{},
+		}
+
+		if s.model != nil {
+			k := s.model.K
+			calibrated.Intrinsic = [][]float64{k[0:3], k[3:6], k[6:9]}
+		}
+		calibratedSensors = append(calibratedSensors, calibrated)
+
+		for i, sample := range s.samples {
+			ts := nuScenesTimestamp(sample.t)
+			pose := exp.poseAt(sample.t)
+			egoPose := nuScenesEgoPose{
+				Token:       nuScenesToken("ego_pose", fmt.Sprintf("%s/%d", s.name, i)),
+				Timestamp:   ts,
+				Rotation:    pose.rotation.array(),
+				Translation: pose.translation.array(),
+			}
+			egoPoses = append(egoPoses, egoPose)
+
+			data := nuScenesSampleData{
+				Token:                 nuScenesToken("sample_data", fmt.Sprintf("%s/%d", s.name, i)),
+				EgoPoseToken:          egoPose.Token,
+				CalibratedSensorToken: calibrated.Token,
+				Timestamp:             ts,
+				FileFormat:            "jpg",
+				IsKeyFrame:            s == key,
+				Height:                sample.height,
+				Width:                 sample.width,
+				Filename:              filepath.ToSlash(sample.file),
+			}
+
+			if s.lidar {
+				data.FileFormat = "pcd"
+			}
+
+			if s == key {
+				data.SampleToken = samples[i].Token
+			} else {
+				data.SampleToken = closestNuScenesSample(samples, ts)
+			}
+
+			if i > 0 {
+				data.Prev = sampleData[len(sampleData)-1].Token
+				sampleData[len(sampleData)-1].Next = data.Token
+			}
+			sampleData = append(sampleData, data)
+		}
+	}
+
+	log := nuScenesLog{
+		Token:        scene.LogToken,
+		DateCaptured: key.samples[0].t.UTC().Format("2006-01-02"),
+	}
+
+	tables := map[string]interface{}{
+		"log":               []nuScenesLog{log},
+		"scene":             []nuScenesScene{scene},
+		"sample":            samples,
+		"sensor":            sensors,
+		"calibrated_sensor": calibratedSensors,
+		"ego_pose":          egoPoses,
+		"sample_data":       sampleData,
+	}
+
+	for _, table := range nuScenesEmptyTables {
+		tables[table] = []struct{}{}
+	}
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(exp.dir, nuScenesVersion, name+".json")
+		err := writeFile(path, func(w io.Writer) error {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(tables[name])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func linkNuScenesSamples(samples []nuScenesSample) {
+	for i := range samples {
+		if i > 0 {
+			samples[i].Prev = samples[i-1].Token
+		}
+
+		if i < len(samples)-1 {
+			samples[i].Next = samples[i+1].Token
+		}
+	}
+}
+
+// closestNuScenesSample returns the token of the sample that is the closest to the timestamp ts.
+func closestNuScenesSample(samples []nuScenesSample, ts int64) string {
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Timestamp >= ts
+	})
+
+	if i == len(samples) || (i > 0 && ts-samples[i-1].Timestamp < samples[i].Timestamp-ts) {
+		i--
+	}
+	return samples[i].Token
+}
@@ -0,0 +1,104 @@
+package dataset
+
+import "math"
+
+type vector3 struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+}
+
+func (v vector3) add(u vector3) vector3 {
+	return vector3{v.X + u.X, v.Y + u.Y, v.Z + u.Z}
+}
+
+func (v vector3) scale(s float64) vector3 {
+	return vector3{v.X * s, v.Y * s, v.Z * s}
+}
+
+func (v vector3) cross(u vector3) vector3 {
+	return vector3{v.Y*u.Z - v.Z*u.Y, v.Z*u.X - v.X*u.Z, v.X*u.Y - v.Y*u.X}
+}
+
+func (v vector3) array() [3]float64 {
+	return [3]float64{v.X, v.Y, v.Z}
+}
+
+// quaternion is a rotation, like geometry_msgs/Quaternion.
+type quaternion struct {
+	W float64 `rosbag:"w"`
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+}
+
+// normalize returns q as a unit quaternion. A zero quaternion, which is what messages that don't
+// set their orientation have, is the identity.
+func (q quaternion) normalize() quaternion {
+	norm := math.Sqrt(q.W*q.W + q.X*q.X + q.Y*q.Y + q.Z*q.Z)
+	if norm == 0 {
+		return quaternion{W: 1}
+	}
+	return quaternion{W: q.W / norm, X: q.X / norm, Y: q.Y / norm, Z: q.Z / norm}
+}
+
+func (q quaternion) mul(r quaternion) quaternion {
+	return quaternion{
+		W: q.W*r.W - q.X*r.X - q.Y*r.Y - q.Z*r.Z,
+		X: q.W*r.X + q.X*r.W + q.Y*r.Z - q.Z*r.Y,
+		Y: q.W*r.Y - q.X*r.Z + q.Y*r.W + q.Z*r.X,
+		Z: q.W*r.Z + q.X*r.Y - q.Y*r.X + q.Z*r.W,
+	}
+}
+
+func (q quaternion) conjugate() quaternion {
+	return quaternion{W: q.W, X: -q.X, Y: -q.Y, Z: -q.Z}
+}
+
+// array returns q in the order of nuScenes, i.e. w, x, y, and z.
+func (q quaternion) array() [4]float64 {
+	return [4]float64{q.W, q.X, q.Y, q.Z}
+}
+
+// rotate rotates v by the unit quaternion q.
+func (q quaternion) rotate(v vector3) vector3 {
+	// v + 2w(u x v) + 2u x (u x v), where u is the vector part of q
+	u := vector3{q.X, q.Y, q.Z}
+	t := u.cross(v).scale(2)
+	return v.add(t.scale(q.W)).add(u.cross(t))
+}
+
+// rigid is a rotation followed by a translation, which maps the points of a frame to another.
+type rigid struct {
+	rotation    quaternion
+	translation vector3
+}
+
+var identity = rigid{rotation: quaternion{W: 1}}
+
+func newRigid(translation vector3, rotation quaternion) rigid {
+	return rigid{rotation: rotation.normalize(), translation: translation}
+}
+
+// mul returns the transform that applies b, then a.
+func (a rigid) mul(b rigid) rigid {
+	return rigid{
+		rotation:    a.rotation.mul(b.rotation).normalize(),
+		translation: a.rotation.rotate(b.translation).add(a.translation),
+	}
+}
+
+func (a rigid) inverse() rigid {
+	rotation := a.rotation.conjugate()
+	return rigid{rotation: rotation, translation: rotation.rotate(a.translation).scale(-1)}
+}
+
+// matrix returns the 3x4 matrix [R|t] of a in row-major order.
+func (a rigid) matrix() [12]float64 {
+	q := a.rotation
+	return [12]float64{
+		1 - 2*(q.Y*q.Y+q.Z*q.Z), 2 * (q.X*q.Y - q.W*q.Z), 2 * (q.X*q.Z + q.W*q.Y), a.translation.X,
+		2 * (q.X*q.Y + q.W*q.Z), 1 - 2*(q.X*q.X+q.Z*q.Z), 2 * (q.Y*q.Z - q.W*q.X), a.translation.Y,
+		2 * (q.X*q.Z - q.W*q.Y), 2 * (q.Y*q.Z + q.W*q.X), 1 - 2*(q.X*q.X+q.Y*q.Y), a.translation.Z,
+	}
+}
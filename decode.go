@@ -0,0 +1,31 @@
+package rosbag
+
+import (
+	"reflect"
+)
+
+// Decode decodes the message of record into a new value of the struct type T, e.g.
+//
+//	odom, err := rosbag.Decode[Odometry](record)
+//
+// The struct fields are matched like ViewAs, but with a Plan that is compiled on the first message
+// of the connection that is decoded as T, and cached for the next ones, so the struct fields are
+// only resolved once. Mismatches between the message definition and T are reported as a
+// *PlanError. Like ViewAs, strings and arrays share the memory of the record unless their struct
+// fields have the copy option, and the message is decoded by UnmarshalROS instead when *T
+// implements Unmarshaler.
+func Decode[T any](record *RecordMessageData) (T, error) {
+	var v T
+	if unmarshaler, ok := interface{}(&v).(Unmarshaler); ok {
+		err := unmarshaler.UnmarshalROS(&record.connHdr.MessageDefinition, record.Data())
+		return v, err
+	}
+
+	plan, err := record.plan(reflect.TypeOf(&v).Elem())
+	if err != nil {
+		return v, err
+	}
+
+	err = plan.Decode(record.Data(), &v)
+	return v, err
+}
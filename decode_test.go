@@ -0,0 +1,90 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/plan",
+		Type:                 "test_msgs/Plan",
+		RawMessageDefinition: planTestMessageDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		err = writer.WriteMessage(conn, time.Unix(int64(i), 0), encodePlanTestMessage(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoderBytes(buf.Bytes())
+	var actual []planTestMessage
+	var caches []*planCache
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			continue
+		}
+
+		v, err := Decode[planTestMessage](msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, v)
+		caches = append(caches, msg.plans)
+
+		// the width is the seq of the header, and the second field is points
+		unmarshaled, err := Decode[unmarshalerTestMessage](msg)
+		if err != nil || unmarshaled.Type != "geometry_msgs/Point[]" || unmarshaled.Width != 1 {
+			t.Fatalf("expected the message to be decoded by UnmarshalROS, but got %+v (%v)", unmarshaled, err)
+		}
+
+		_, err = Decode[struct {
+			Label int `rosbag:"label"`
+		}](msg)
+
+		var planErr *PlanError
+		if !errors.As(err, &planErr) {
+			t.Fatalf("expected a PlanError, but got %v", err)
+		}
+	}
+
+	if len(actual) != 2 || len(actual[1].Points) != 2 || actual[1].Points[1].Y != -1 || actual[0].Header.FrameID != "map" {
+		t.Fatalf("decoded messages are not matched: %+v", actual)
+	}
+
+	// both plans are compiled once for the connection, including the one that fails
+	if caches[0] != caches[1] || len(caches[0].plans) != 2 {
+		t.Fatalf("expected the plans to be cached for the connection, but got %v and %v", caches[0], caches[1])
+	}
+
+	if diff := cmp.Diff([]float64{1, 2, 3}, actual[0].Scale); diff != "" {
+		t.Fatalf("scale is not matched:\n\n%s", diff)
+	}
+}
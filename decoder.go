@@ -2,12 +2,16 @@ package rosbag
 
 import (
 	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"sync"
+	"time"
 
+	"github.com/golang/snappy"
 	"github.com/pierrec/lz4/v4"
 )
 
@@ -18,7 +22,7 @@ const (
 )
 
 var (
-	errUnsupportedCompression = errors.New("unsupported compression algorithm. Available algortihms: [none, bz2, lz4]")
+	errUnsupportedCompression = errors.New("unsupported compression algorithm. Available algortihms: [none, bz2, lz4, snappy] and the registered codecs")
 )
 
 var (
@@ -36,12 +40,83 @@ type Decoder struct {
 	chunkReader    io.Reader
 	checkedVersion bool
 	conns          map[uint32]*ConnectionHeader
+	telemetry      *telemetry
+	prefetch       bool
+	prefetcher     *prefetcher
+	// prefetchedChunk is the decompressed data of the current chunk when prefetching is enabled
+	prefetchedChunk []byte
+	// inMemory marks that the decoder slices records from bytes instead of reading from reader
+	inMemory bool
+	bytes    []byte
+	// chunkBytes is the remaining data of the current chunk when inMemory is true
+	chunkBytes    []byte
+	inChunk       bool
+	subscriptions []subscription
+	topicHandlers map[string][]MessageHandler
+	typeFilter    *typeFilter
+	windowFilter  *windowFilter
+	recordFilter  RecordFilter
+	// seeker is the reader passed to NewDecoder when it's seekable, it's used by Preload
+	seeker     io.ReadSeeker
+	preloaded  bool
+	version    *Version
+	chunkInfos []*RecordChunkInfo
+	// chunkLimit limits the reads of the current chunk from reader when streaming
+	chunkLimit *io.LimitedReader
+	// offset is the offset of the next top-level record, and goodOffset is the end of the last
+	// complete top-level record
+	offset             int64
+	goodOffset         int64
+	lastTime           time.Time
+	tolerateTruncation bool
+	truncation         *TruncatedError
+	aliasSemantics     AliasSemantics
+	unknownOps         UnknownOps
+	interner           *Interner
+	definitionCache    *DefinitionCache
+	validateMD5Sums    bool
+	memory             *memoryAccount
+	// plans are the plan caches of the connections for Decode
+	plans map[uint32]*planCache
+	// chunkMemory is the memory of the current compressed chunk when inMemory is true
+	chunkMemory int64
+	// err is reported by every Read once it's set, e.g. a configuration error
+	err error
 }
 
-func NewDecoder(r io.Reader) *Decoder {
+// NewDecoder creates a decoder that reads a rosbag from r. opts can be used to enable
+// optional behaviors, see DecoderOption.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	config := newDecoderConfig(opts)
+	seeker, _ := r.(io.ReadSeeker)
+	if config.readLimit > 0 {
+		r = newRateLimitedReader(r, config.readLimit)
+	}
+
+	memory := &memoryAccount{budget: config.memoryBudget}
+	if config.bufferSize > 0 {
+		r = bufio.NewReaderSize(r, config.bufferSize)
+		memory.readBuffer = int64(config.bufferSize)
+	}
+
 	return &Decoder{
-		reader: bufio.NewReader(r),
-		conns:  make(map[uint32]*ConnectionHeader),
+		reader:       r,
+		conns:        make(map[uint32]*ConnectionHeader),
+		telemetry:    newTelemetry(config),
+		prefetch:     config.prefetch,
+		typeFilter:   config.typeFilter,
+		windowFilter: config.windowFilter,
+		recordFilter: config.recordFilter,
+		seeker:       seeker,
+		err:          config.err,
+
+		tolerateTruncation: config.tolerateTruncation,
+		aliasSemantics:     config.aliasSemantics,
+		unknownOps:         config.unknownOps,
+		interner:           config.interner,
+		definitionCache:    config.definitionCache,
+		validateMD5Sums:    config.validateMD5Sums,
+		memory:             memory,
 	}
 }
 
@@ -49,46 +124,246 @@ func NewDecoder(r io.Reader) *Decoder {
 // at the beginning to mark that the rosbag format version is supported. When, it reaches EOF,
 // Next returns io.EOF error.
 func (decoder *Decoder) Read() (Record, error) {
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+
+	for {
+		record, err := decoder.read()
+		if err == errSkippedRecord {
+			continue
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			err = decoder.truncate()
+		}
+
+		if errors.Is(err, ErrMemoryBudget) {
+			// the record has been partially read, so the next records can't be found
+			decoder.err = err
+		}
+
+		if err != nil || (!decoder.typeFilter.skip(record) && !decoder.windowFilter.skip(record)) {
+			return record, err
+		}
+
+		record.Close()
+	}
+}
+
+func (decoder *Decoder) read() (Record, error) {
+	if !decoder.checkedVersion {
+		// the index is optional, errors are reported by an explicit Preload
+		_ = decoder.Preload()
+		if decoder.err != nil {
+			return nil, decoder.err
+		}
+	}
+
+	if decoder.inMemory {
+		return decoder.readBytes()
+	}
+
 	if !decoder.checkedVersion {
 		if err := decoder.checkVersion(); err != nil {
+			decoder.telemetry.end(err)
 			return nil, err
 		}
 
 		decoder.checkedVersion = true
+		if decoder.prefetch {
+			decoder.prefetcher = newPrefetcher(decoder.reader, decoder.memory)
+		}
 	}
 
 	record := recordPool.Get().(*RecordBase)
+	err := decoder.memory.reserveRecords(int64(len(record.Raw)))
+	if err != nil && len(record.Raw) > initialRecordSize {
+		// the buffer has grown for a large record, possibly of another decoder, so a small one is
+		// tried instead
+		record.Raw = make([]byte, initialRecordSize)
+		err = decoder.memory.reserveRecords(initialRecordSize)
+	}
+
+	if err != nil {
+		recordPool.Put(record)
+		decoder.telemetry.end(err)
+		return nil, err
+	}
+
 	record.closeFn = func() {
+		decoder.memory.releaseRecords(int64(len(record.Raw)))
 		recordPool.Put(record)
 	}
 	if decoder.chunkReader != nil {
 		specializedRecord, err := decoder.decodeRecord(decoder.chunkReader, record)
 		switch err {
 		case nil:
+			decoder.track(specializedRecord, false)
 			return specializedRecord, nil
 		case io.EOF:
 			/* explicit ignore */
+		case errSkippedRecord:
+			record.Close()
+			return nil, err
 		default:
 			// the record is not usable, so recyle it
 			record.Close()
+			decoder.telemetry.end(err)
 			return nil, err
 		}
 
 		// at this point, the error must be EOF, need to reset chunkReader and read from the source
 		// again
 		decoder.chunkReader = nil
+		if decoder.chunkLimit != nil {
+			// decompressors might leave trailing bytes, e.g. checksums. When the chunk data
+			// still isn't complete after that, the bag is truncated inside the chunk
+			_, err = io.Copy(ioutil.Discard, decoder.chunkLimit)
+			if err == nil && decoder.chunkLimit.N > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			decoder.chunkLimit = nil
+
+			if err != nil {
+				record.Close()
+				decoder.telemetry.end(err)
+				return nil, err
+			}
+		}
+		decoder.endChunk()
+		if decoder.prefetcher != nil {
+			decoder.prefetcher.release(decoder.prefetchedChunk)
+			decoder.prefetchedChunk = nil
+		}
+	}
+
+	specializedRecord, err := decoder.decodeTopLevelRecord(record)
+	if err == errSkippedRecord {
+		decoder.skipTopLevel(record)
+		record.Close()
+		return nil, err
 	}
 
-	specializedRecord, err := decoder.decodeRecord(decoder.reader, record)
 	if err != nil {
 		// the record is not usable, so recyle it
 		record.Close()
+		decoder.telemetry.end(err)
 		return nil, err
 	}
 
+	decoder.track(specializedRecord, true)
 	return specializedRecord, nil
 }
 
+// Version returns the format version of the bag. It's nil until the version line has been read
+// by the first Read. The version is set even when it's not supported.
+func (decoder *Decoder) Version() *Version {
+	return decoder.version
+}
+
+// Close releases the resources that are used by the decoder, e.g. the prefetching goroutine, and
+// the chunks that are reserved from the memory budget. Close doesn't close the underlying reader.
+// The decoder must not be used after Close.
+func (decoder *Decoder) Close() {
+	if decoder.prefetcher != nil {
+		decoder.prefetcher.close()
+	}
+	decoder.memory.close()
+}
+
+// SkipChunk discards the records of the current chunk that haven't been read, so the next Read
+// returns the record after the chunk. It can be called after Read returns a *RecordChunk, e.g. when
+// the chunk info of the chunk shows that it doesn't have the connections of interest, or in the
+// middle of the chunk. It's a no-op outside of a chunk.
+//
+// When the decoder streams the bag, the rest of the chunk is skipped without being decompressed
+// or parsed. With WithPrefetch or NewDecoderBytes, the chunk has been decompressed already, and
+// only its records aren't parsed. The connection records in the skipped part aren't read either,
+// so the messages of those connections in the next chunks are only known when the index section
+// has been preloaded, see Preload.
+func (decoder *Decoder) SkipChunk() error {
+	if decoder.err != nil {
+		return decoder.err
+	}
+
+	if decoder.inMemory {
+		if decoder.inChunk {
+			decoder.inChunk = false
+			decoder.chunkBytes = nil
+			decoder.memory.releaseChunks(decoder.chunkMemory)
+			decoder.chunkMemory = 0
+			decoder.endChunk()
+		}
+		return nil
+	}
+
+	if decoder.chunkReader == nil {
+		return nil
+	}
+
+	decoder.chunkReader = nil
+	if decoder.chunkLimit != nil {
+		_, err := io.Copy(ioutil.Discard, decoder.chunkLimit)
+		if err == nil && decoder.chunkLimit.N > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		decoder.chunkLimit = nil
+
+		if err == io.ErrUnexpectedEOF {
+			err = decoder.truncate()
+		}
+
+		if err != nil {
+			decoder.err = err
+			decoder.telemetry.end(err)
+			return err
+		}
+	}
+
+	decoder.endChunk()
+	if decoder.prefetcher != nil {
+		decoder.prefetcher.release(decoder.prefetchedChunk)
+		decoder.prefetchedChunk = nil
+	}
+	return nil
+}
+
+// decodeTopLevelRecord decodes the next record that is not inside a chunk.
+func (decoder *Decoder) decodeTopLevelRecord(record *RecordBase) (Record, error) {
+	if decoder.prefetcher == nil {
+		return decoder.decodeRecord(decoder.reader, record)
+	}
+
+	prefetched := decoder.prefetcher.next()
+	if prefetched.err != nil {
+		return nil, prefetched.err
+	}
+
+	decoder.prefetchedChunk = prefetched.chunk
+	return decoder.decodeRecord(bytes.NewReader(prefetched.raw), record)
+}
+
+// newChunkReader wraps r, which reads the raw chunk data, with a reader that decompresses
+// the data with the given compression algorithm.
+func newChunkReader(compression Compression, r io.Reader) (io.Reader, error) {
+	switch compression {
+	case CompressionNone:
+		return r, nil
+	case CompressionBZ2:
+		return bzip2.NewReader(r), nil
+	case CompressionLZ4:
+		return lz4.NewReader(r), nil
+	case CompressionSnappy:
+		return snappy.NewReader(r), nil
+	}
+
+	if codec, ok := registeredCodec(compression); ok {
+		return codec.NewReader(r)
+	}
+	return nil, errUnsupportedCompression
+}
+
 func (decoder *Decoder) handleChunk(record *RecordBase) (Record, error) {
 	chunkRecord := RecordChunk{
 		RecordBase: record,
@@ -99,18 +374,37 @@ func (decoder *Decoder) handleChunk(record *RecordBase) (Record, error) {
 		return nil, err
 	}
 
-	chunkReader := io.LimitReader(decoder.reader, int64(record.DataLen))
-	switch compression {
-	case CompressionNone:
-		decoder.chunkReader = chunkReader
-	case CompressionBZ2:
-		decoder.chunkReader = bzip2.NewReader(chunkReader)
-	case CompressionLZ4:
-		decoder.chunkReader = lz4.NewReader(chunkReader)
-	default:
-		return nil, errUnsupportedCompression
+	if decoder.inMemory {
+		if compression != CompressionNone {
+			size, err := chunkRecord.Size()
+			if err != nil {
+				return nil, err
+			}
+
+			err = decoder.memory.reserveChunks(int64(size))
+			if err != nil {
+				return nil, err
+			}
+			decoder.chunkMemory = int64(size)
+		}
+
+		decoder.chunkBytes, err = decompressChunk(&chunkRecord)
+		if err != nil {
+			return nil, err
+		}
+		decoder.inChunk = true
+	} else if decoder.prefetcher != nil {
+		// the chunk has been decompressed by the prefetcher
+		decoder.chunkReader = bytes.NewReader(decoder.prefetchedChunk)
+	} else {
+		decoder.chunkLimit = &io.LimitedReader{R: decoder.reader, N: int64(record.DataLen)}
+		decoder.chunkReader, err = newChunkReader(compression, decoder.chunkLimit)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	decoder.telemetry.startChunk(&chunkRecord)
 	return &chunkRecord, nil
 }
 
@@ -124,11 +418,27 @@ func (decoder *Decoder) handleConnection(record *RecordBase) (Record, error) {
 		return nil, err
 	}
 
-	hdr, err := connRecord.ConnectionHeader()
+	hdr, err := connRecord.connectionHeader(decoder.definitionCache)
 	if err != nil {
 		return nil, err
 	}
 
+	// topic is optional in the connection header, but it's required in the record header
+	if hdr.Topic == "" {
+		hdr.Topic, err = connRecord.Topic()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if decoder.validateMD5Sums {
+		err = hdr.ValidateMD5Sum()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	decoder.aliasSemantics.apply(&hdr.MessageDefinition)
 	decoder.conns[conn] = hdr
 	return &connRecord, nil
 }
@@ -148,7 +458,20 @@ func (decoder *Decoder) handleMessageData(record *RecordBase) (Record, error) {
 		return nil, errNotFoundConnectionHeader
 	}
 
+	// the plans are cached for the current connection header, which is replaced when the
+	// connection record is read again
+	cache := decoder.plans[conn]
+	if cache == nil || cache.def != &connHdr.MessageDefinition {
+		if decoder.plans == nil {
+			decoder.plans = make(map[uint32]*planCache)
+		}
+		cache = newPlanCache(&connHdr.MessageDefinition)
+		decoder.plans[conn] = cache
+	}
+
 	connRecord.connHdr = connHdr
+	connRecord.interner = decoder.interner
+	connRecord.plans = cache
 	return &connRecord, nil
 }
 
@@ -160,10 +483,14 @@ func (decoder *Decoder) checkVersion() error {
 		return err
 	}
 
+	decoder.version = &version
+	decoder.offset = int64(len(fmt.Sprintf(versionFormat, version.Major, version.Minor)))
+	decoder.goodOffset = decoder.offset
 	if version.Major != supportedVersion.Major || version.Minor != supportedVersion.Minor {
-		return fmt.Errorf("%s is not supported. %s is the current supported version", &version, &supportedVersion)
+		return &UnsupportedVersionError{Version: version}
 	}
 
+	decoder.telemetry.startBag(&version)
 	return nil
 }
 
@@ -171,7 +498,10 @@ func (decoder *Decoder) decodeRecord(r io.Reader, record *RecordBase) (Record, e
 	var off uint32
 	var err error
 
-	record.grow(off + lenInBytes)
+	err = decoder.growRecord(record, off+lenInBytes)
+	if err != nil {
+		return nil, err
+	}
 	_, err = io.ReadFull(r, record.Raw[off:off+lenInBytes])
 	if err != nil {
 		return nil, err
@@ -179,11 +509,14 @@ func (decoder *Decoder) decodeRecord(r io.Reader, record *RecordBase) (Record, e
 	record.HeaderLen = endian.Uint32(record.Raw[off : off+lenInBytes])
 	off += lenInBytes
 
-	record.grow(off + record.HeaderLen)
-	_, err = io.ReadFull(r, record.Raw[off:off+record.HeaderLen])
+	err = decoder.growRecord(record, off+record.HeaderLen)
 	if err != nil {
 		return nil, err
 	}
+	_, err = io.ReadFull(r, record.Raw[off:off+record.HeaderLen])
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
 	off += record.HeaderLen
 
 	op, err := record.Op()
@@ -191,26 +524,49 @@ func (decoder *Decoder) decodeRecord(r io.Reader, record *RecordBase) (Record, e
 		return nil, err
 	}
 
-	record.grow(off + lenInBytes)
-	_, err = io.ReadFull(r, record.Raw[off:off+lenInBytes])
+	err = decoder.growRecord(record, off+lenInBytes)
 	if err != nil {
 		return nil, err
 	}
+	_, err = io.ReadFull(r, record.Raw[off:off+lenInBytes])
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
 	record.DataLen = endian.Uint32(record.Raw[off : off+lenInBytes])
 	off += lenInBytes
 
+	if !decoder.keepRecord(op, record, decoder.chunkReader != nil) {
+		// the prefetcher has already read the data of the chunks
+		if op != OpChunk || decoder.prefetcher == nil {
+			_, err = io.CopyN(ioutil.Discard, r, int64(record.DataLen))
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		}
+		return nil, errSkippedRecord
+	}
+
 	// Since RecordChunk contains a lot of messages and connections, we don't parse
 	// the data part. We'll let the next iteration to parse this.
 	if op == OpChunk {
 		return decoder.handleChunk(record)
 	}
 
-	record.grow(off + record.DataLen)
-	_, err = io.ReadFull(r, record.Raw[off:off+record.DataLen])
+	err = decoder.growRecord(record, off+record.DataLen)
 	if err != nil {
 		return nil, err
 	}
+	_, err = io.ReadFull(r, record.Raw[off:off+record.DataLen])
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return decoder.specializeRecord(op, record)
+}
 
+// specializeRecord wraps record with the record type of op, and updates the decoder state
+// that depends on the record, e.g. the known connections.
+func (decoder *Decoder) specializeRecord(op Op, record *RecordBase) (Record, error) {
 	switch op {
 	case OpBagHeader:
 		return &RecordBagHeader{RecordBase: record}, nil
@@ -223,6 +579,6 @@ func (decoder *Decoder) decodeRecord(r io.Reader, record *RecordBase) (Record, e
 	case OpChunkInfo:
 		return &RecordChunkInfo{RecordBase: record}, nil
 	default:
-		return nil, errInvalidOp
+		return decoder.specializeCustomRecord(op, record)
 	}
 }
@@ -9,33 +9,9 @@ import (
 )
 
 func BenchmarkE2E(b *testing.B) {
-	const bagName = "benchbag.bag"
 	b.StopTimer()
 
-	var bag *os.File
-	if _, err := os.Stat(bagName); err != nil {
-		resp, err := http.Get("https://open-source-webviz-ui.s3.amazonaws.com/demo.bag")
-		if err != nil {
-			b.Fatal(err)
-		}
-		defer resp.Body.Close()
-
-		bag, err = os.Create(bagName)
-		if err != nil {
-			b.Fatal(err)
-		}
-
-		_, err = io.Copy(bag, resp.Body)
-		if err != nil {
-			bag.Close()
-			b.Fatal(err)
-		}
-	} else {
-		bag, err = os.Open(bagName)
-		if err != nil {
-			b.Fatal(err)
-		}
-	}
+	bag := openBenchBag(b)
 	defer bag.Close()
 
 	bagStat, err := bag.Stat()
@@ -92,3 +68,52 @@ func start(b *testing.B, bag string) {
 		record.Close()
 	}
 }
+
+func BenchmarkBenchDecode(b *testing.B) {
+	bag := openBenchBag(b)
+	defer bag.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := bag.Seek(0, io.SeekStart)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = BenchDecode(NewDecoder(bag), true)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// openBenchBag opens the demo bag, which is downloaded on the first run.
+func openBenchBag(b *testing.B) *os.File {
+	const bagName = "benchbag.bag"
+
+	var bag *os.File
+	if _, err := os.Stat(bagName); err != nil {
+		resp, err := http.Get("https://open-source-webviz-ui.s3.amazonaws.com/demo.bag")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		bag, err = os.Create(bagName)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = io.Copy(bag, resp.Body)
+		if err != nil {
+			bag.Close()
+			b.Fatal(err)
+		}
+	} else {
+		bag, err = os.Open(bagName)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return bag
+}
@@ -0,0 +1,175 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+)
+
+// NewDecoderBytes creates a decoder that reads a rosbag that is fully loaded in memory, e.g. a
+// small bag fetched from object storage, or a memory-mapped file. Unlike NewDecoder, records are
+// sliced directly from b instead of being copied to pooled buffers, and Record.Close is a no-op.
+// This means that b must not be modified while the records or the values viewed from them
+// are in use.
+//
+// Uncompressed chunks are sliced from b as well. Compressed chunks are decompressed to a new
+// buffer, and the records inside are sliced from that buffer.
+func NewDecoderBytes(b []byte, opts ...DecoderOption) *Decoder {
+	config := newDecoderConfig(opts)
+	reader := bytes.NewReader(b)
+	return &Decoder{
+		reader:       reader,
+		conns:        make(map[uint32]*ConnectionHeader),
+		telemetry:    newTelemetry(config),
+		inMemory:     true,
+		bytes:        b,
+		typeFilter:   config.typeFilter,
+		recordFilter: config.recordFilter,
+		seeker:       reader,
+		err:          config.err,
+
+		tolerateTruncation: config.tolerateTruncation,
+		aliasSemantics:     config.aliasSemantics,
+		unknownOps:         config.unknownOps,
+		interner:           config.interner,
+		definitionCache:    config.definitionCache,
+		validateMD5Sums:    config.validateMD5Sums,
+		memory:             &memoryAccount{budget: config.memoryBudget},
+	}
+}
+
+func (decoder *Decoder) readBytes() (Record, error) {
+	if !decoder.checkedVersion {
+		reader := decoder.reader.(*bytes.Reader)
+		if err := decoder.checkVersion(); err != nil {
+			decoder.telemetry.end(err)
+			return nil, err
+		}
+
+		decoder.checkedVersion = true
+		decoder.bytes = decoder.bytes[len(decoder.bytes)-reader.Len():]
+	}
+
+	if decoder.inChunk {
+		if len(decoder.chunkBytes) > 0 {
+			record, err := decoder.sliceRecord(&decoder.chunkBytes)
+			if err == errSkippedRecord {
+				return nil, err
+			}
+
+			if err != nil {
+				decoder.telemetry.end(err)
+				return nil, err
+			}
+
+			decoder.track(record, false)
+			return record, nil
+		}
+
+		decoder.inChunk = false
+		decoder.chunkBytes = nil
+		decoder.memory.releaseChunks(decoder.chunkMemory)
+		decoder.chunkMemory = 0
+		decoder.endChunk()
+	}
+
+	if len(decoder.bytes) == 0 {
+		decoder.telemetry.end(io.EOF)
+		return nil, io.EOF
+	}
+
+	size := len(decoder.bytes)
+	record, err := decoder.sliceRecord(&decoder.bytes)
+	if err == errSkippedRecord {
+		decoder.offset += int64(size - len(decoder.bytes))
+		decoder.goodOffset = decoder.offset
+		return nil, err
+	}
+
+	if err != nil {
+		decoder.telemetry.end(err)
+		return nil, err
+	}
+
+	decoder.track(record, true)
+	return record, nil
+}
+
+// sliceRecord slices the next record from the beginning of *b without copying, and advances
+// *b to the end of the record.
+func (decoder *Decoder) sliceRecord(b *[]byte) (Record, error) {
+	record, err := sliceRawRecord(*b)
+	if err != nil {
+		return nil, err
+	}
+	*b = (*b)[len(record.Raw):]
+
+	op, err := record.Op()
+	if err != nil {
+		return nil, err
+	}
+
+	if !decoder.keepRecord(op, record, decoder.inChunk) {
+		return nil, errSkippedRecord
+	}
+
+	if op == OpChunk {
+		return decoder.handleChunk(record)
+	}
+
+	return decoder.specializeRecord(op, record)
+}
+
+// sliceRawRecord returns the record at the beginning of b. The record shares the memory of b.
+func sliceRawRecord(b []byte) (*RecordBase, error) {
+	var record RecordBase
+	if len(b) < lenInBytes {
+		return nil, io.ErrUnexpectedEOF
+	}
+	record.HeaderLen = endian.Uint32(b)
+
+	off := uint64(lenInBytes) + uint64(record.HeaderLen)
+	if uint64(len(b)) < off+lenInBytes {
+		return nil, io.ErrUnexpectedEOF
+	}
+	record.DataLen = endian.Uint32(b[off:])
+
+	off += lenInBytes + uint64(record.DataLen)
+	if uint64(len(b)) < off {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// limit the capacity so that appending to the record never overwrites the next record
+	record.Raw = b[:off:off]
+	return &record, nil
+}
+
+// decompressChunk returns the decompressed data of record. The data is returned as is when the
+// chunk is not compressed.
+func decompressChunk(record *RecordChunk) ([]byte, error) {
+	compression, err := record.Compression()
+	if err != nil {
+		return nil, err
+	}
+
+	if compression == CompressionNone {
+		return record.Data(), nil
+	}
+
+	size, err := record.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newChunkReader(compression, bytes.NewReader(record.Data()))
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := make([]byte, size)
+	_, err = io.ReadFull(r, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
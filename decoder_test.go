@@ -1,36 +1,67 @@
 package rosbag
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+const (
+	exampleBag = "examples/logging/example.bag"
 )
 
+// openExampleBag opens the example bag. The example bag is encoded in little endian, so tests
+// that use it are skipped when big endian is simulated.
+func openExampleBag(t *testing.T) *os.File {
+	if endian != binary.LittleEndian {
+		t.Skip("the example bag can only be decoded in little endian")
+	}
+
+	f, err := os.Open(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
 func bytesToLimitedReader(b []byte) *io.LimitedReader {
 	return &io.LimitedReader{R: bytes.NewReader(b), N: int64(len(b))}
 }
 
 func TestDecoderCheckVersion(t *testing.T) {
 	testCases := []struct {
-		Name string
-		Raw  []byte
-		Fail bool
+		Name    string
+		Raw     []byte
+		Fail    bool
+		Version Version
 	}{
 		{
-			Name: "Missing Newline character",
-			Raw:  []byte("#ROSBAG V2.0"),
-			Fail: false,
+			Name:    "Missing Newline character",
+			Raw:     []byte("#ROSBAG V2.0"),
+			Fail:    false,
+			Version: Version{Major: 2, Minor: 0},
 		},
 		{
-			Name: "Unsupported Version",
-			Raw:  []byte("#ROSBAG V1.2\n"),
-			Fail: true,
+			Name:    "Unsupported Version",
+			Raw:     []byte("#ROSBAG V1.2\n"),
+			Fail:    true,
+			Version: Version{Major: 1, Minor: 2},
 		},
 		{
-			Name: "Expected Version Format",
-			Raw:  []byte("#ROSBAG V2.0\n"),
-			Fail: false,
+			Name:    "Expected Version Format",
+			Raw:     []byte("#ROSBAG V2.0\n"),
+			Fail:    false,
+			Version: Version{Major: 2, Minor: 0},
 		},
 	}
 
@@ -38,13 +69,23 @@ func TestDecoderCheckVersion(t *testing.T) {
 		testCase := testCase
 		t.Run(testCase.Name, func(t *testing.T) {
 			in := bytes.NewReader(testCase.Raw)
-			err := NewDecoder(in).checkVersion()
+			decoder := NewDecoder(in)
+			err := decoder.checkVersion()
 
 			if testCase.Fail && err == nil {
 				t.Fatal("expected to fail")
 			} else if !testCase.Fail && err != nil {
 				t.Fatal("expected to succeed")
 			}
+
+			var versionErr *UnsupportedVersionError
+			if testCase.Fail && !errors.As(err, &versionErr) {
+				t.Fatalf("expected an UnsupportedVersionError, but got %v", err)
+			}
+
+			if decoder.Version() == nil || *decoder.Version() != testCase.Version {
+				t.Fatalf("expected version to be %v, but got %v", testCase.Version, decoder.Version())
+			}
 		})
 	}
 }
@@ -264,3 +305,478 @@ func TestIterateHeaderFields(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoderTelemetry(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	var spanRecorder oteltest.StandardSpanRecorder
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(&spanRecorder))
+	meter, mp := oteltest.NewMeterProvider()
+
+	decoder := NewDecoder(f, WithTracerProvider(tp), WithMeterProvider(mp))
+	var expectedMessages int64
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := record.(*RecordMessageData); ok {
+			expectedMessages++
+		}
+		record.Close()
+	}
+
+	spans := spanRecorder.Completed()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 completed spans, but got %d", len(spans))
+	}
+
+	chunkSpan, bagSpan := spans[0], spans[1]
+	if chunkSpan.Name() != "rosbag.Chunk" || bagSpan.Name() != "rosbag.Bag" {
+		t.Fatalf("expected a chunk span followed by a bag span, but got %s and %s", chunkSpan.Name(), bagSpan.Name())
+	}
+
+	if chunkSpan.ParentSpanID() != bagSpan.SpanContext().SpanID {
+		t.Fatal("expected the chunk span to be a child of the bag span")
+	}
+
+	totals := make(map[string]int64)
+	for _, measured := range oteltest.AsStructs(meter.MeasurementBatches) {
+		totals[measured.Name] += measured.Number.AsInt64()
+	}
+
+	if totals["rosbag.messages"] != expectedMessages {
+		t.Fatalf("expected %d messages to be counted, but got %d", expectedMessages, totals["rosbag.messages"])
+	}
+
+	if totals["rosbag.chunks"] != 1 {
+		t.Fatalf("expected 1 chunk to be counted, but got %d", totals["rosbag.chunks"])
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything except the version line is read as records
+	expectedBytes := stat.Size() - int64(len("#ROSBAG V2.0\n"))
+	if totals["rosbag.bytes"] != expectedBytes {
+		t.Fatalf("expected %d bytes to be counted, but got %d", expectedBytes, totals["rosbag.bytes"])
+	}
+}
+
+// readAllRecords reads all records from decoder, and returns the header and data of each record
+// concatenated.
+func readAllRecords(t *testing.T, decoder *Decoder) [][]byte {
+	var records [][]byte
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw := append([]byte(nil), record.Header()...)
+		// chunk data is streamed, so it's not available from the record
+		if _, ok := record.(*RecordChunk); !ok {
+			raw = append(raw, record.Data()...)
+		}
+		records = append(records, raw)
+		record.Close()
+	}
+	return records
+}
+
+func compareRecords(t *testing.T, expected, actual [][]byte) {
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d records, but got %d", len(expected), len(actual))
+	}
+
+	for i := range expected {
+		if !bytes.Equal(expected[i], actual[i]) {
+			t.Fatalf("expected record %d to be\n\n%v\n\nbut got\n\n%v\n\n", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestDecoderPrefetch(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+	expected := readAllRecords(t, NewDecoder(f))
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(f, WithPrefetch())
+	defer decoder.Close()
+	compareRecords(t, expected, readAllRecords(t, decoder))
+}
+
+func TestDecoderBytes(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+	expected := readAllRecords(t, NewDecoder(f))
+
+	raw, err := ioutil.ReadFile(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareRecords(t, expected, readAllRecords(t, NewDecoderBytes(raw)))
+
+	// records must be sliced from the input without copying
+	decoder := NewDecoderBytes(raw)
+	record, err := decoder.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := record.Header()
+	if &header[0] != &raw[len("#ROSBAG V2.0\n")+lenInBytes] {
+		t.Fatal("expected the record header to share the underlying buffer")
+	}
+
+	decoder = NewDecoderBytes(raw[:len(raw)-1])
+	for err == nil {
+		_, err = decoder.Read()
+	}
+
+	if !errors.Is(err, ErrTruncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a truncated bag to fail with %v, but got %v", ErrTruncated, err)
+	}
+}
+
+func TestDecoderBufferSize(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Opts []DecoderOption
+		Size int
+	}{
+		{
+			Name: "Default",
+			Size: defaultBufferSize,
+		},
+		{
+			Name: "Custom Size",
+			Opts: []DecoderOption{WithBufferSize(1 << 20)},
+			Size: 1 << 20,
+		},
+		{
+			Name: "Unbuffered",
+			Opts: []DecoderOption{WithBufferSize(0)},
+			Size: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			f := openExampleBag(t)
+			defer f.Close()
+
+			decoder := NewDecoder(f, testCase.Opts...)
+			if br, ok := decoder.reader.(*bufio.Reader); ok {
+				if br.Size() != testCase.Size {
+					t.Fatalf("expected buffer size to be %d, but got %d", testCase.Size, br.Size())
+				}
+			} else if testCase.Size != 0 {
+				t.Fatal("expected the reader to be buffered")
+			}
+
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				record.Close()
+			}
+		})
+	}
+}
+
+type headerField struct {
+	Key   string
+	Value []byte
+}
+
+func encodeHeader(fields ...headerField) []byte {
+	var header []byte
+	for _, field := range fields {
+		fieldLen := make([]byte, lenInBytes)
+		endian.PutUint32(fieldLen, uint32(len(field.Key)+1+len(field.Value)))
+		header = append(header, fieldLen...)
+		header = append(header, field.Key...)
+		header = append(header, headerFieldDelimiter)
+		header = append(header, field.Value...)
+	}
+	return header
+}
+
+func encodeRecord(header, data []byte) []byte {
+	raw := make([]byte, lenInBytes, 2*lenInBytes+len(header)+len(data))
+	endian.PutUint32(raw, uint32(len(header)))
+	raw = append(raw, header...)
+	dataLen := make([]byte, lenInBytes)
+	endian.PutUint32(dataLen, uint32(len(data)))
+	raw = append(raw, dataLen...)
+	return append(raw, data...)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	endian.PutUint32(b, v)
+	return b
+}
+
+// encodeTestChunkRecords encodes a connection on /test with "uint8 x" as the message definition,
+// and a message for each of values.
+func encodeTestChunkRecords(values ...uint8) []byte {
+	raw := encodeRecord(
+		encodeHeader(
+			headerField{"op", []byte{byte(OpConnection)}},
+			headerField{"conn", uint32Bytes(0)},
+			headerField{"topic", []byte("/test")},
+		),
+		encodeHeader(
+			headerField{"topic", []byte("/test")},
+			headerField{"type", []byte("test_msgs/Test")},
+			headerField{"md5sum", []byte("*")},
+			headerField{"message_definition", []byte("uint8 x")},
+		),
+	)
+
+	for i, v := range values {
+		raw = append(raw, encodeRecord(
+			encodeHeader(
+				headerField{"op", []byte{byte(OpMessageData)}},
+				headerField{"conn", uint32Bytes(0)},
+				headerField{"time", append(uint32Bytes(uint32(i)), uint32Bytes(0)...)},
+			),
+			[]byte{v},
+		)...)
+	}
+	return raw
+}
+
+func TestDecoderSnappy(t *testing.T) {
+	values := []uint8{1, 2, 3}
+	chunk := encodeTestChunkRecords(values...)
+
+	var compressed bytes.Buffer
+	w := snappy.NewBufferedWriter(&compressed)
+	_, err := w.Write(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeRecord(
+		encodeHeader(
+			headerField{"op", []byte{byte(OpChunk)}},
+			headerField{"compression", []byte(CompressionSnappy)},
+			headerField{"size", uint32Bytes(uint32(len(chunk)))},
+		),
+		compressed.Bytes(),
+	)...)
+
+	testCases := []struct {
+		Name    string
+		Decoder func() *Decoder
+	}{
+		{
+			Name:    "Stream",
+			Decoder: func() *Decoder { return NewDecoder(bytes.NewReader(raw)) },
+		},
+		{
+			Name:    "Prefetch",
+			Decoder: func() *Decoder { return NewDecoder(bytes.NewReader(raw), WithPrefetch()) },
+		},
+		{
+			Name:    "Bytes",
+			Decoder: func() *Decoder { return NewDecoderBytes(raw) },
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			decoder := testCase.Decoder()
+			defer decoder.Close()
+
+			var actual []uint8
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if record, ok := record.(*RecordMessageData); ok {
+					v := struct {
+						X uint8 `rosbag:"x"`
+					}{}
+					err = record.ViewAs(&v)
+					if err != nil {
+						t.Fatal(err)
+					}
+					actual = append(actual, v.X)
+				}
+				record.Close()
+			}
+
+			if !reflect.DeepEqual(values, actual) {
+				t.Fatalf("expected messages to be %v, but got %v", values, actual)
+			}
+		})
+	}
+}
+
+func TestDecoderPreload(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	// hide Seek so that the index is not preloaded
+	streaming := NewDecoder(struct{ io.Reader }{f})
+	expected := readAllRecords(t, streaming)
+	expectedTopics := streaming.Topics()
+	if len(expectedTopics) == 0 {
+		t.Fatal("expected the example bag to have topics")
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(f)
+	err = decoder.Preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedTopics, decoder.Topics()) {
+		t.Fatalf("expected preloaded topics to be %v, but got %v", expectedTopics, decoder.Topics())
+	}
+
+	if !reflect.DeepEqual(streaming.Connections(), decoder.Connections()) {
+		t.Fatalf("expected preloaded connections to be %v, but got %v", streaming.Connections(), decoder.Connections())
+	}
+
+	if len(decoder.ChunkInfos()) != 1 {
+		t.Fatalf("expected 1 preloaded chunk info, but got %d", len(decoder.ChunkInfos()))
+	}
+
+	compareRecords(t, expected, readAllRecords(t, decoder))
+
+	err = decoder.Preload()
+	if err != nil {
+		t.Fatalf("expected Preload after Read to be a no-op, but got %v", err)
+	}
+}
+
+func TestDecoderSkipChunk(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, WithChunkSize(1), WithCompression(CompressionLZ4))
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = writer.WriteMessage(conn, time.Unix(int64(i+1), 0), []byte{uint8(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	testCases := map[string]func() *Decoder{
+		"Stream": func() *Decoder {
+			return NewDecoder(struct{ io.Reader }{bytes.NewReader(raw)})
+		},
+		"Prefetch": func() *Decoder {
+			return NewDecoder(struct{ io.Reader }{bytes.NewReader(raw)}, WithPrefetch())
+		},
+		"Bytes": func() *Decoder {
+			return NewDecoderBytes(raw)
+		},
+	}
+
+	for name, newDecoder := range testCases {
+		t.Run(name, func(t *testing.T) {
+			decoder := newDecoder()
+			defer decoder.Close()
+
+			// a skip before the first chunk is a no-op
+			err := decoder.SkipChunk()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var chunks int
+			var actual []uint8
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				switch record := record.(type) {
+				case *RecordChunk:
+					chunks++
+					// the connection is in the first chunk, so it's skipped in the middle
+					if chunks == 3 || chunks == 4 {
+						err = decoder.SkipChunk()
+					}
+				case *RecordConnection:
+					if chunks == 1 {
+						err = decoder.SkipChunk()
+					}
+				case *RecordMessageData:
+					actual = append(actual, record.Data()[0])
+				}
+				record.Close()
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual([]uint8{1, 4}, actual) {
+				t.Fatalf("expected the messages of the chunks that aren't skipped, but got %v", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package rosbag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	// the defaults of arrays are []interface{}, the other values are builtin types that gob knows
+	gob.Register([]interface{}{})
+}
+
+// DefinitionCache is an on-disk cache of parsed message definitions keyed by md5sum, so that huge
+// message definitions are only parsed once across runs, e.g. by the jobs of a batch that read
+// many bags of the same robot. A cache is enabled for a decoder with WithDefinitionCache, and it
+// can be shared by multiple decoders and processes.
+//
+// The entries are validated against the raw message definitions, so a wrong md5sum never loads
+// the definition of another message. Connections with a "*" md5sum are not cached. The cache is
+// best-effort, a definition is parsed as usual when its entry can't be read or written.
+//
+// Compiled plans are not cached since they depend on the Go types that messages are viewed as.
+type DefinitionCache struct {
+	dir string
+}
+
+// NewDefinitionCache creates a cache that stores its entries in dir. dir is created when it
+// doesn't exist.
+func NewDefinitionCache(dir string) (*DefinitionCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &DefinitionCache{dir: dir}, nil
+}
+
+// cachedDefinition is the gob encoding of a parsed message definition. The nested definitions are
+// flattened, and referenced by their indices, so that shared definitions stay shared.
+type cachedDefinition struct {
+	// Hash is the SHA-256 of the raw message definition
+	Hash     [sha256.Size]byte
+	Messages []cachedMessage
+}
+
+type cachedMessage struct {
+	Type      string
+	Fields    []cachedField
+	Constants []cachedConstant
+	Comments  []string
+}
+
+type cachedField struct {
+	MessageFieldDefinition
+	// MsgType is the index of the nested definition, or -1
+	MsgType int
+}
+
+type cachedConstant struct {
+	MessageConstant
+	DeclaredType string
+}
+
+// unmarshall parses raw into def like MessageDefinition.unmarshall, but loads the definition from
+// the cache when there's an entry for md5sum, and stores it otherwise. cache can be nil.
+func (cache *DefinitionCache) unmarshall(def *MessageDefinition, md5sum string, raw []byte) error {
+	if cache == nil || !validMD5Sum(md5sum) {
+		return def.unmarshall(raw)
+	}
+
+	hash := sha256.Sum256(raw)
+	path := filepath.Join(cache.dir, md5sum+".gob")
+	if cache.load(def, path, hash) {
+		return nil
+	}
+
+	err := def.unmarshall(raw)
+	if err != nil {
+		return err
+	}
+
+	// a failed store only makes the next run parse the definition again
+	_ = cache.store(def, path, hash)
+	return nil
+}
+
+func validMD5Sum(md5sum string) bool {
+	b, err := hex.DecodeString(md5sum)
+	return err == nil && len(b) == 16
+}
+
+func (cache *DefinitionCache) load(def *MessageDefinition, path string, hash [sha256.Size]byte) bool {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var cached cachedDefinition
+	err = gob.NewDecoder(bytes.NewReader(raw)).Decode(&cached)
+	if err != nil || cached.Hash != hash || len(cached.Messages) == 0 {
+		return false
+	}
+
+	defs := make([]*MessageDefinition, len(cached.Messages))
+	defs[0] = def
+	for i := 1; i < len(defs); i++ {
+		defs[i] = &MessageDefinition{}
+	}
+
+	for i, msg := range cached.Messages {
+		*defs[i] = MessageDefinition{Type: msg.Type, Comments: msg.Comments}
+		for _, field := range msg.Fields {
+			if field.MsgType >= len(defs) {
+				*def = MessageDefinition{}
+				return false
+			}
+
+			fieldDef := field.MessageFieldDefinition
+			if field.MsgType >= 0 {
+				fieldDef.MsgType = defs[field.MsgType]
+			}
+			defs[i].Fields = append(defs[i].Fields, &fieldDef)
+		}
+
+		for _, constant := range msg.Constants {
+			constantDef := constant.MessageConstant
+			constantDef.field = &MessageFieldDefinition{
+				Type:         constantDef.Type,
+				DeclaredType: constant.DeclaredType,
+				Name:         constantDef.Name,
+				ArraySize:    -1,
+				Value:        constantDef.Value,
+				Line:         constantDef.Line,
+				RawLine:      constantDef.RawLine,
+				Comment:      constantDef.Comment,
+				Comments:     constantDef.Comments,
+			}
+			defs[i].Constants = append(defs[i].Constants, &constantDef)
+		}
+	}
+	return true
+}
+
+func (cache *DefinitionCache) store(def *MessageDefinition, path string, hash [sha256.Size]byte) error {
+	cached := cachedDefinition{Hash: hash}
+	indices := make(map[*MessageDefinition]int)
+	var add func(def *MessageDefinition) int
+	add = func(def *MessageDefinition) int {
+		if i, ok := indices[def]; ok {
+			return i
+		}
+
+		i := len(cached.Messages)
+		indices[def] = i
+		cached.Messages = append(cached.Messages, cachedMessage{Type: def.Type, Comments: def.Comments})
+		for _, field := range def.Fields {
+			msgType := -1
+			if field.MsgType != nil {
+				msgType = add(field.MsgType)
+			}
+
+			fieldDef := *field
+			fieldDef.MsgType = nil
+			cached.Messages[i].Fields = append(cached.Messages[i].Fields, cachedField{
+				MessageFieldDefinition: fieldDef,
+				MsgType:                msgType,
+			})
+		}
+
+		for _, constant := range def.Constants {
+			c := cachedConstant{MessageConstant: *constant}
+			c.field = nil
+			if constant.field != nil {
+				c.DeclaredType = constant.field.DeclaredType
+			}
+			cached.Messages[i].Constants = append(cached.Messages[i].Constants, c)
+		}
+		return i
+	}
+	add(def)
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&cached)
+	if err != nil {
+		return err
+	}
+
+	// the entry is renamed into place, so that concurrent readers never see a partial entry
+	f, err := ioutil.TempFile(cache.dir, ".definition-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
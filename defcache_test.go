@@ -0,0 +1,228 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const cachedTestDefinition = `# a pose with a covariance
+geometry_msgs/Point position
+geometry_msgs/Point[] path
+byte MODE=1
+string NAME="a"
+float64[2] scale [1.0, 2.0]
+
+================================================================================
+MSG: geometry_msgs/Point
+float64 x # meters
+float64 y
+`
+
+const cachedTestMD5Sum = "0123456789abcdef0123456789abcdef"
+
+// diffCachedDefinition compares the exported fields of the definitions, and the constants as
+// fields, which aren't exported. Unexported fields can't be compared by cmp with the purego tag.
+func diffCachedDefinition(expected, actual *MessageDefinition) string {
+	if diff := cmp.Diff(expected, actual, cmpopts.IgnoreUnexported(MessageConstant{})); diff != "" {
+		return diff
+	}
+
+	for i, constant := range expected.Constants {
+		if actual.Constants[i].field == nil {
+			return fmt.Sprintf("constant %s doesn't have a field", constant.Name)
+		}
+
+		if diff := cmp.Diff(constant.asField(), actual.Constants[i].asField()); diff != "" {
+			return fmt.Sprintf("constant %s: %s", constant.Name, diff)
+		}
+	}
+
+	for i, field := range expected.Fields {
+		if field.MsgType == nil {
+			continue
+		}
+
+		if diff := diffCachedDefinition(field.MsgType, actual.Fields[i].MsgType); diff != "" {
+			return diff
+		}
+	}
+	return ""
+}
+
+func TestDefinitionCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "definitions")
+	cache, err := NewDefinitionCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected MessageDefinition
+	err = expected.unmarshall([]byte(cachedTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first unmarshall stores the entry, and the second one loads it
+	for i := 0; i < 2; i++ {
+		var actual MessageDefinition
+		err = cache.unmarshall(&actual, cachedTestMD5Sum, []byte(cachedTestDefinition))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := diffCachedDefinition(&expected, &actual); diff != "" {
+			t.Fatalf("definition %d is not matched:\n\n%s", i, diff)
+		}
+
+		if actual.Fields[0].MsgType != actual.Fields[1].MsgType {
+			t.Fatal("expected the fields of the same type to share the nested definition")
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != cachedTestMD5Sum+".gob" {
+		t.Fatalf("expected a single entry for the md5sum, but got %v", entries)
+	}
+
+	// another definition with the same md5sum is parsed instead of loaded
+	var other MessageDefinition
+	err = cache.unmarshall(&other, cachedTestMD5Sum, []byte("uint8 x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(other.Fields) != 1 || other.Fields[0].Name != "x" {
+		t.Fatalf("expected the definition to be parsed, but got %+v", other)
+	}
+
+	// corrupted entries are parsed, and replaced
+	err = ioutil.WriteFile(filepath.Join(dir, cachedTestMD5Sum+".gob"), []byte("corrupted"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual MessageDefinition
+	err = cache.unmarshall(&actual, cachedTestMD5Sum, []byte(cachedTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := diffCachedDefinition(&expected, &actual); diff != "" {
+		t.Fatalf("definition is not matched:\n\n%s", diff)
+	}
+
+	// "*" md5sums can't be used as keys
+	err = cache.unmarshall(&actual, "*", []byte(cachedTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected the * md5sum to be skipped, but got %v", entries)
+	}
+}
+
+func TestDecoderDefinitionCache(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the messages are printed since they share the memory of the records
+	readMessages := func(inMemory bool, opts ...DecoderOption) []string {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var messages []string
+		decoder := NewDecoder(f, opts...)
+		if inMemory {
+			decoder = NewDecoderBytes(b, opts...)
+		}
+
+		err = decoder.Subscribe("/rosout", func(record *RecordMessageData) error {
+			v := make(map[string]interface{})
+			err := record.ViewAs(v)
+			messages = append(messages, fmt.Sprint(v))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = decoder.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+
+	cache, err := NewDefinitionCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readMessages(false)
+	for i := 0; i < 2; i++ {
+		if actual := readMessages(false, WithDefinitionCache(cache)); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected run %d to decode the same messages with the cache", i)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) == 0 {
+		t.Fatal("expected the definitions to be cached")
+	}
+
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeType != 0 || filepath.Ext(entry.Name()) != ".gob" {
+			t.Fatalf("unexpected entry %s", entry.Name())
+		}
+	}
+
+	// the bytes decoder stores to its own cache, and loads from it on the second run
+	bytesCache, err := NewDefinitionCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if actual := readMessages(true, WithDefinitionCache(bytesCache)); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected run %d of the bytes decoder to decode the same messages with the cache", i)
+		}
+
+		bytesEntries, err := ioutil.ReadDir(bytesCache.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bytesEntries) != len(entries) {
+			t.Fatalf("expected the bytes decoder to cache %d definitions, but got %d", len(entries), len(bytesEntries))
+		}
+	}
+}
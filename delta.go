@@ -0,0 +1,135 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// CompressionDelta is an extension from the standard. The data of every message in the chunk is
+// XORed with the data of the previous message of its connection in the chunk when they have the
+// same length, and the chunk data is then compressed with lz4. Slowly changing messages, e.g.
+// odometry or battery states, become mostly zeros, which compress much better than the messages
+// themselves. The headers and the other records are kept as is.
+//
+// The codec is registered by default, so the decoders read these bags transparently, but other
+// tools can't read them. Transcode them with CompressionLZ4 or CompressionNone to export standard
+// bags, e.g. with `rosbag compress -codec lz4`.
+const CompressionDelta Compression = "delta"
+
+var errInvalidChunkData = errors.New("chunk data is not a sequence of records")
+
+func init() {
+	RegisterCompression(CompressionDelta, deltaCodec{})
+}
+
+type deltaCodec struct{}
+
+func (deltaCodec) NewReader(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(lz4.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	err = xorMessages(data, false)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (deltaCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &deltaWriter{w: w}, nil
+}
+
+// deltaWriter buffers the chunk data, since the messages can only be encoded once they're
+// complete.
+type deltaWriter struct {
+	w    io.Writer
+	data bytes.Buffer
+}
+
+func (writer *deltaWriter) Write(b []byte) (int, error) {
+	return writer.data.Write(b)
+}
+
+func (writer *deltaWriter) Close() error {
+	data := writer.data.Bytes()
+	err := xorMessages(data, true)
+	if err != nil {
+		return err
+	}
+
+	lw := lz4.NewWriter(writer.w)
+	_, err = lw.Write(data)
+	if err != nil {
+		return err
+	}
+	return lw.Close()
+}
+
+// xorMessages XORs the data of every message data record in the chunk data with the data of the
+// previous message of the same connection in place, when they have the same length. encode tells
+// whether data has the original messages, which are kept as the previous messages, or the XORed
+// ones, whose decoded messages are kept instead.
+func xorMessages(data []byte, encode bool) error {
+	previous := make(map[uint32][]byte)
+	for len(data) > 0 {
+		var record RecordBase
+		if len(data) < lenInBytes {
+			return errInvalidChunkData
+		}
+		record.HeaderLen = endian.Uint32(data)
+
+		off := uint64(lenInBytes) + uint64(record.HeaderLen)
+		if uint64(len(data)) < off+lenInBytes {
+			return errInvalidChunkData
+		}
+		record.DataLen = endian.Uint32(data[off:])
+
+		end := off + lenInBytes + uint64(record.DataLen)
+		if uint64(len(data)) < end {
+			return errInvalidChunkData
+		}
+		record.Raw = data[:end]
+		data = data[end:]
+
+		op, err := record.Op()
+		if err != nil {
+			return err
+		}
+
+		if op != OpMessageData {
+			continue
+		}
+
+		conn, err := (&RecordMessageData{RecordBase: &record}).Conn()
+		if err != nil {
+			return err
+		}
+
+		msg := record.Data()
+		prev, ok := previous[conn]
+		switch {
+		case !ok || len(prev) != len(msg):
+			if encode {
+				previous[conn] = append(prev[:0], msg...)
+			} else {
+				previous[conn] = msg
+			}
+		case encode:
+			for i := range msg {
+				msg[i], prev[i] = msg[i]^prev[i], msg[i]
+			}
+		default:
+			for i := range msg {
+				msg[i] ^= prev[i]
+			}
+			previous[conn] = msg
+		}
+	}
+	return nil
+}
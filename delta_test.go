@@ -0,0 +1,218 @@
+package rosbag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeDeltaTestBag(t *testing.T) []byte {
+	const odom, log = 0, 1
+	var records []testRecord
+	for i := 0; i < 5000; i++ {
+		msg := make([]byte, 20)
+		endian.PutUint32(msg, uint32(i))
+		endian.PutUint64(msg[4:], math.Float64bits(100+float64(i)/1000))
+		endian.PutUint64(msg[12:], math.Float64bits(-3))
+		records = append(records, testRecord{Conn: odom, Time: time.Unix(int64(i), 0), Data: msg})
+
+		if i%100 == 0 {
+			text := []byte("step " + time.Duration(i).String())
+			records = append(records, testRecord{Conn: log, Time: time.Unix(int64(i), 0), Data: append(uint32Bytes(uint32(len(text))), text...)})
+		}
+	}
+
+	return writeTestBag(t, withTestConns(
+		&ConnectionHeader{
+			Topic:                "/odom",
+			Type:                 "test_msgs/Odometry",
+			MD5Sum:               "*",
+			RawMessageDefinition: "uint32 seq\nfloat64 x\nfloat64 y",
+		},
+		&ConnectionHeader{
+			Topic:                "/log",
+			Type:                 "std_msgs/String",
+			MD5Sum:               "*",
+			RawMessageDefinition: "string data",
+		},
+	), withTestRecords(records...))
+}
+
+func transcodeBytes(t *testing.T, raw []byte, compression Compression) []byte {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = Transcode(out, bytes.NewReader(raw), compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = out.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcoded, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transcoded
+}
+
+func TestCompressionDelta(t *testing.T) {
+	raw := writeDeltaTestBag(t)
+	expected := readAllMessages(t, raw)
+
+	delta := transcodeBytes(t, raw, CompressionDelta)
+	findings, err := Check(bytes.NewReader(delta))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the delta bag to be valid, but got %v", findings)
+	}
+
+	compareRecords(t, expected, readAllMessages(t, delta))
+
+	decoder := NewDecoder(bytes.NewReader(delta), WithPrefetch())
+	defer decoder.Close()
+	compareRecords(t, expected, readAllMessagesFrom(t, decoder))
+
+	// standard bags are exported by transcoding the chunks again
+	compareRecords(t, expected, readAllMessages(t, transcodeBytes(t, delta, CompressionLZ4)))
+}
+
+func TestXORMessages(t *testing.T) {
+	r := bytes.NewReader(writeDeltaTestBag(t))
+	_, err := r.Seek(int64(len(fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor))), io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunk []byte
+	for chunk == nil {
+		record, op, err := readRawRecord(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if op == OpChunk {
+			chunk = record.Data()
+		}
+	}
+
+	zeros := func(b []byte) int {
+		return len(b) - len(bytes.ReplaceAll(b, []byte{0}, nil))
+	}
+
+	encoded := append([]byte(nil), chunk...)
+	err = xorMessages(encoded, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the slowly changing messages become mostly zeros, which lz4 compresses well
+	if zeros(encoded) <= zeros(chunk) {
+		t.Fatalf("expected the messages to have more zeros, but got %d zeros instead of %d", zeros(encoded), zeros(chunk))
+	}
+
+	err = xorMessages(encoded, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(encoded, chunk) {
+		t.Fatal("expected the messages to be decoded")
+	}
+
+	err = xorMessages(chunk[:len(chunk)-1], true)
+	if err != errInvalidChunkData {
+		t.Fatalf("expected a truncated chunk to fail, but got %v", err)
+	}
+}
+
+// readAllMessagesFrom is like readAllMessages, but it reads the messages from decoder.
+func readAllMessagesFrom(t *testing.T, decoder *Decoder) [][]byte {
+	var messages [][]byte
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return messages
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record, ok := record.(*RecordMessageData); ok {
+			messages = append(messages, append(append([]byte(nil), record.Header()...), record.Data()...))
+		}
+		record.Close()
+	}
+}
+
+// reversingCodec stores the chunk data reversed, so it can't be read without the codec.
+type reversingCodec struct{}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+func (reversingCodec) NewReader(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	reverseBytes(data)
+	return bytes.NewReader(data), err
+}
+
+func (reversingCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &reversingWriter{w: w}, nil
+}
+
+type reversingWriter struct {
+	w    io.Writer
+	data []byte
+}
+
+func (writer *reversingWriter) Write(b []byte) (int, error) {
+	writer.data = append(writer.data, b...)
+	return len(b), nil
+}
+
+func (writer *reversingWriter) Close() error {
+	reverseBytes(writer.data)
+	_, err := writer.w.Write(writer.data)
+	return err
+}
+
+func TestRegisterCompression(t *testing.T) {
+	const compression = Compression("test-reverse")
+	raw := writeDeltaTestBag(t)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = Transcode(out, bytes.NewReader(raw), compression)
+	if err != errCompressionNotWritable {
+		t.Fatalf("expected an unregistered compression to fail, but got %v", err)
+	}
+
+	RegisterCompression(compression, reversingCodec{})
+	t.Cleanup(func() {
+		unregisterCompression(compression)
+	})
+	compareRecords(t, readAllMessages(t, raw), readAllMessages(t, transcodeBytes(t, raw, compression)))
+}
@@ -0,0 +1,290 @@
+package rosbag
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// EncodeMessage serializes v as the message data of def, which is the inverse of ViewAs. v is a
+// map[string]interface{}, or a struct or a pointer to a struct with rosbag tags, and the nested
+// messages are maps or structs too, like the values that ViewAs decodes into. The fields that are
+// missing from v are encoded as zero values, and numbers are converted to the types of their fields
+// when they fit, e.g. an int in a map can be encoded as a uint8 field.
+func EncodeMessage(def *MessageDefinition, v interface{}) ([]byte, error) {
+	value := indirectValue(reflect.ValueOf(v))
+	if value.Kind() != reflect.Map && value.Kind() != reflect.Struct {
+		return nil, errInvalidDataType
+	}
+	return appendMessageData(nil, def, value, "")
+}
+
+// indirectValue follows the pointers and the interfaces of value. The zero Value is returned for
+// nil.
+func indirectValue(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+func appendMessageData(b []byte, def *MessageDefinition, value reflect.Value, path string) ([]byte, error) {
+	var fieldValue func(name string) reflect.Value
+	switch value.Kind() {
+	case reflect.Invalid:
+		fieldValue = func(string) reflect.Value {
+			return reflect.Value{}
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("message %s can't be encoded from a %s, the keys must be strings", messagePath(path), value.Type())
+		}
+
+		fieldValue = func(name string) reflect.Value {
+			return value.MapIndex(reflect.ValueOf(name).Convert(value.Type().Key()))
+		}
+	case reflect.Struct:
+		mapper := make(map[string]structField)
+		err := createFieldMapper(value, mapper)
+		if err != nil {
+			return nil, err
+		}
+
+		fieldValue = func(name string) reflect.Value {
+			return mapper[name].value
+		}
+	default:
+		return nil, fmt.Errorf("message %s can't be encoded from a %s", messagePath(path), value.Type())
+	}
+
+	var err error
+	for _, field := range def.Fields {
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+
+		b, err = appendFieldData(b, field, fieldValue(field.Name), fieldPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func messagePath(path string) string {
+	if path == "" {
+		return "data"
+	}
+	return path
+}
+
+// appendFieldData appends the serialized field to b. The fixed-size arrays must have exactly
+// ArraySize elements, unless they're missing.
+func appendFieldData(b []byte, field *MessageFieldDefinition, value reflect.Value, path string) ([]byte, error) {
+	value = indirectValue(value)
+	if !field.IsArray {
+		return appendFieldElem(b, field, value, path)
+	}
+
+	var length int
+	if value.IsValid() {
+		if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+			return nil, encodeFieldError(field, value, path)
+		}
+		length = value.Len()
+	}
+
+	if field.ArraySize < 0 {
+		b = append(b, uint32Field(uint32(length))...)
+	} else if value.IsValid() && length != field.ArraySize {
+		return nil, fmt.Errorf("message field %s has %d elements, but it's an array of %d", path, length, field.ArraySize)
+	} else {
+		length = field.ArraySize
+	}
+
+	isBytes := field.Type == MessageFieldTypeUint8 || field.Type == MessageFieldTypeInt8
+	if isBytes && value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 {
+		return append(b, value.Bytes()...), nil
+	}
+
+	var err error
+	for i := 0; i < length; i++ {
+		var elem reflect.Value
+		if value.IsValid() {
+			elem = value.Index(i)
+		}
+
+		b, err = appendFieldElem(b, field, indirectValue(elem), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendFieldElem appends a single value of the type of field to b. The zero value of the type is
+// appended when value is the zero Value.
+func appendFieldElem(b []byte, field *MessageFieldDefinition, value reflect.Value, path string) ([]byte, error) {
+	if value.IsValid() && !value.CanInterface() {
+		return nil, fmt.Errorf("message field %s can't be encoded from an unexported struct field", path)
+	}
+
+	switch field.Type {
+	case MessageFieldTypeComplex:
+		return appendMessageData(b, field.MsgType, value, path)
+	case MessageFieldTypeBool:
+		if !value.IsValid() {
+			return append(b, 0), nil
+		}
+
+		if value.Kind() != reflect.Bool {
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		if value.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case MessageFieldTypeInt8, MessageFieldTypeInt16, MessageFieldTypeInt32, MessageFieldTypeInt64:
+		size := fixedFieldSizes[field.Type]
+		bits := uint(size * 8)
+		var n int64
+		switch {
+		case !value.IsValid():
+		case isIntKind(value.Kind()):
+			n = value.Int()
+		case isUintKind(value.Kind()) && value.Uint() <= math.MaxInt64:
+			n = int64(value.Uint())
+		default:
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+			return nil, fmt.Errorf("message field %s (%s) can't hold %d", path, field.rosType(), n)
+		}
+		return appendUint(b, size, uint64(n)), nil
+	case MessageFieldTypeUint8, MessageFieldTypeUint16, MessageFieldTypeUint32, MessageFieldTypeUint64:
+		size := fixedFieldSizes[field.Type]
+		bits := uint(size * 8)
+		var n uint64
+		switch {
+		case !value.IsValid():
+		case isUintKind(value.Kind()):
+			n = value.Uint()
+		case isIntKind(value.Kind()) && value.Int() >= 0:
+			n = uint64(value.Int())
+		case isIntKind(value.Kind()):
+			return nil, fmt.Errorf("message field %s (%s) can't hold %d", path, field.rosType(), value.Int())
+		default:
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		if bits < 64 && n >= 1<<bits {
+			return nil, fmt.Errorf("message field %s (%s) can't hold %d", path, field.rosType(), n)
+		}
+		return appendUint(b, size, n), nil
+	case MessageFieldTypeFloat32, MessageFieldTypeFloat64:
+		var f float64
+		switch {
+		case !value.IsValid():
+		case value.Kind() == reflect.Float32 || value.Kind() == reflect.Float64:
+			f = value.Float()
+		case isIntKind(value.Kind()):
+			f = float64(value.Int())
+		case isUintKind(value.Kind()):
+			f = float64(value.Uint())
+		default:
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		if field.Type == MessageFieldTypeFloat32 {
+			return appendUint(b, 4, uint64(math.Float32bits(float32(f)))), nil
+		}
+		return appendUint(b, 8, math.Float64bits(f)), nil
+	case MessageFieldTypeString, MessageFieldTypeWString:
+		var s string
+		if value.IsValid() {
+			if value.Kind() != reflect.String {
+				return nil, encodeFieldError(field, value, path)
+			}
+			s = value.String()
+		}
+
+		if field.Type == MessageFieldTypeString {
+			b = append(b, uint32Field(uint32(len(s)))...)
+			return append(b, s...), nil
+		}
+
+		// wstring is the number of UTF-16 code units followed by the code units
+		units := utf16.Encode([]rune(s))
+		b = append(b, uint32Field(uint32(len(units)))...)
+		for _, unit := range units {
+			b = appendUint(b, 2, uint64(unit))
+		}
+		return b, nil
+	case MessageFieldTypeTime:
+		if !value.IsValid() {
+			return append(b, make([]byte, 8)...), nil
+		}
+
+		if value.Type() != timeType {
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		t := value.Interface().(time.Time)
+		if t.IsZero() {
+			return append(b, make([]byte, 8)...), nil
+		}
+		return append(b, timeField(t)...), nil
+	case MessageFieldTypeDuration:
+		if !value.IsValid() {
+			return append(b, make([]byte, 8)...), nil
+		}
+
+		if value.Type() != durationType {
+			return nil, encodeFieldError(field, value, path)
+		}
+
+		// the nanoseconds are positive like the durations of roscpp, e.g. -1.5s is -2s and 0.5s
+		d := time.Duration(value.Int())
+		sec, nsec := d/time.Second, d%time.Second
+		if nsec < 0 {
+			sec--
+			nsec += time.Second
+		}
+		b = appendUint(b, 4, uint64(uint32(int32(sec))))
+		return appendUint(b, 4, uint64(nsec)), nil
+	}
+	return nil, fmt.Errorf("message field %s has an unknown type %s", path, field.Type)
+}
+
+func encodeFieldError(field *MessageFieldDefinition, value reflect.Value, path string) error {
+	return fmt.Errorf("message field %s (%s) can't be encoded from a %s", path, field.rosType(), value.Type())
+}
+
+// appendUint appends the low size bytes of v to b.
+func appendUint(b []byte, size int, v uint64) []byte {
+	var raw [8]byte
+	switch size {
+	case 1:
+		raw[0] = uint8(v)
+	case 2:
+		endian.PutUint16(raw[:], uint16(v))
+	case 4:
+		endian.PutUint32(raw[:], uint32(v))
+	default:
+		endian.PutUint64(raw[:], v)
+	}
+	return append(b, raw[:size]...)
+}
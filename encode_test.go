@@ -0,0 +1,339 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const encodeTestDefinition = `uint8 MODE=1
+bool flag
+int8 i8
+uint16 u16
+int32 i32
+uint64 u64
+float32 f32
+float64 f64
+string name
+wstring label
+time stamp
+duration elapsed
+int16[] values
+uint8[4] raw
+Point origin
+Point[] points
+string[2] tags
+
+================================================================================
+MSG: test_msgs/Point
+float64 x
+float64 y
+`
+
+type encodeTestPoint struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+}
+
+type encodeTestMessage struct {
+	Mode    uint8             `rosbag:"MODE"`
+	Flag    bool              `rosbag:"flag"`
+	I8      int8              `rosbag:"i8"`
+	U16     uint16            `rosbag:"u16"`
+	I32     int32             `rosbag:"i32"`
+	U64     uint64            `rosbag:"u64"`
+	F32     float32           `rosbag:"f32"`
+	F64     float64           `rosbag:"f64"`
+	Name    string            `rosbag:"name,copy"`
+	Label   string            `rosbag:"label"`
+	Stamp   time.Time         `rosbag:"stamp"`
+	Elapsed time.Duration     `rosbag:"elapsed"`
+	Values  []int16           `rosbag:"values,copy"`
+	Raw     []uint8           `rosbag:"raw,copy"`
+	Origin  encodeTestPoint   `rosbag:"origin"`
+	Points  []encodeTestPoint `rosbag:"points"`
+	Tags    []string          `rosbag:"tags,copy"`
+}
+
+func parseEncodeTestDefinition(t *testing.T) *MessageDefinition {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(encodeTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &def
+}
+
+func TestEncodeMessage(t *testing.T) {
+	def := parseEncodeTestDefinition(t)
+	expected := encodeTestMessage{
+		Mode:    1,
+		Flag:    true,
+		I8:      -8,
+		U16:     16,
+		I32:     -32,
+		U64:     1 << 40,
+		F32:     0.5,
+		F64:     -2.25,
+		Name:    "robot",
+		Label:   "ロボット",
+		Stamp:   time.Unix(100, 5),
+		Elapsed: 1500 * time.Millisecond,
+		Values:  []int16{-1, 2, 3},
+		Raw:     []uint8{1, 2, 3, 4},
+		Origin:  encodeTestPoint{X: 1, Y: 2},
+		Points:  []encodeTestPoint{{X: 3}, {Y: 4}},
+		Tags:    []string{"a", "b"},
+	}
+
+	raw, err := EncodeMessage(def, &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual encodeTestMessage
+	rest, err := decodeMessageData(def, raw, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rest) != 0 {
+		t.Fatalf("expected the whole message to be decoded, but %d bytes are left", len(rest))
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("decoded message is not matched:\n\n%s", diff)
+	}
+
+	// the maps that are decoded by ViewAs encode to the same data
+	m := make(map[string]interface{})
+	_, err = decodeMessageData(def, raw, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromMap, err := EncodeMessage(def, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(raw, fromMap) {
+		t.Fatalf("expected the map to be encoded like the struct\n%v\n%v", raw, fromMap)
+	}
+
+	// numbers are converted, nested messages can be maps, and missing fields are zero values
+	fromMap, err = EncodeMessage(def, map[string]interface{}{
+		"i8":     -1,
+		"u64":    uint32(7),
+		"f32":    2,
+		"origin": map[string]interface{}{"x": 1.5},
+		"points": []interface{}{map[string]interface{}{"y": 2.5}, &encodeTestPoint{X: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual = encodeTestMessage{}
+	_, err = decodeMessageData(def, fromMap, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = encodeTestMessage{
+		Mode:   1,
+		I8:     -1,
+		U64:    7,
+		F32:    2,
+		Stamp:  time.Unix(0, 0),
+		Raw:    []uint8{0, 0, 0, 0},
+		Origin: encodeTestPoint{X: 1.5},
+		Points: []encodeTestPoint{{Y: 2.5}, {X: 3}},
+		Tags:   []string{"", ""},
+		Values: []int16{},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("decoded message is not matched:\n\n%s", diff)
+	}
+}
+
+func TestEncodeMessageErrors(t *testing.T) {
+	def := parseEncodeTestDefinition(t)
+	testCases := []struct {
+		Name  string
+		Data  interface{}
+		Error string
+	}{
+		{Name: "Not a Message", Data: 1, Error: errInvalidDataType.Error()},
+		{Name: "Wrong Type", Data: map[string]interface{}{"name": 1}, Error: "message field name (string) can't be encoded from a int"},
+		{Name: "Overflow", Data: map[string]interface{}{"i8": 128}, Error: "message field i8 (int8) can't hold 128"},
+		{Name: "Negative Unsigned", Data: map[string]interface{}{"u16": -1}, Error: "message field u16 (uint16) can't hold -1"},
+		{Name: "Fixed Array Length", Data: map[string]interface{}{"raw": []byte{1}}, Error: "message field raw has 1 elements, but it's an array of 4"},
+		{Name: "Nested", Data: map[string]interface{}{"points": []interface{}{nil, map[string]interface{}{"x": "1"}}}, Error: "message field points[1].x (float64) can't be encoded from a string"},
+		{Name: "Nested Not a Message", Data: map[string]interface{}{"origin": 1}, Error: "message origin can't be encoded from a int"},
+	}
+
+	for _, testCase := range testCases {
+		_, err := EncodeMessage(def, testCase.Data)
+		if err == nil || !strings.Contains(err.Error(), testCase.Error) {
+			t.Fatalf("%s: expected %q, but got %v", testCase.Name, testCase.Error, err)
+		}
+	}
+}
+
+func TestWriterWriteMessageFrom(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: encodeTestDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := encodeTestMessage{
+		Mode:   1,
+		Name:   "robot",
+		Stamp:  time.Unix(1, 0),
+		Values: []int16{},
+		Raw:    []uint8{1, 2, 3, 4},
+		Points: []encodeTestPoint{},
+		Tags:   []string{"a", "b"},
+	}
+	err = writer.WriteMessageFrom(conn, time.Unix(1, 0), &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.WriteMessageFrom(conn+1, time.Unix(1, 0), &expected)
+	if err != errUnknownConnection {
+		t.Fatalf("expected %v, but got %v", errUnknownConnection, err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(bytes.NewReader(buf.Bytes()))
+	var messages []encodeTestMessage
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			var actual encodeTestMessage
+			err = msg.ViewAs(&actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, actual)
+		}
+		record.Close()
+	}
+
+	if diff := cmp.Diff([]encodeTestMessage{expected}, messages); diff != "" {
+		t.Fatalf("messages are not matched:\n\n%s", diff)
+	}
+}
+
+func TestWriterRewriteMaps(t *testing.T) {
+	// decoding the example bag into maps and writing them again must produce the same message data
+	f := openExampleBag(t)
+	defer f.Close()
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conns := make(map[uint32]uint32)
+	var expected [][]byte
+	decoder := NewDecoder(f)
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		src, err := msg.Conn()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, ok := conns[src]
+		if !ok {
+			hdr := *msg.ConnectionHeader()
+			conn, err = writer.WriteConnection(&hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns[src] = conn
+		}
+
+		m := make(map[string]interface{})
+		err = msg.ViewAs(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgTime, err := msg.Time()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.WriteMessageFrom(conn, msgTime, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected = append(expected, append([]byte(nil), msg.Data()...))
+		record.Close()
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual [][]byte
+	decoder = NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			actual = append(actual, append([]byte(nil), msg.Data()...))
+		}
+		record.Close()
+	}
+
+	if len(expected) == 0 {
+		t.Fatal("expected the example bag to have messages")
+	}
+	compareRecords(t, expected, actual)
+}
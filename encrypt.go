@@ -0,0 +1,94 @@
+package rosbag
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// EncryptorAESCBC is the name of the stock encryptor of rosbag, which encrypts chunks with
+// AES-128 in CBC mode.
+const EncryptorAESCBC = "rosbag/AesCbcEncryptor"
+
+// aesKeyLen is the length of the AES-128 keys of rosbag/AesCbcEncryptor.
+const aesKeyLen = 16
+
+// Encryptor encrypts the records of a bag, like the encryptor plugins of rosbag. The name and the
+// fields of the encryptor are written to the bag header, so that readers can load the matching
+// plugin to decrypt the bag.
+type Encryptor interface {
+	// Name is the name of the plugin, e.g. rosbag/AesCbcEncryptor.
+	Name() string
+	// HeaderFields returns the fields that are added to the bag header, e.g. the encrypted key.
+	HeaderFields() map[string][]byte
+	// EncryptChunk encrypts the data of a chunk after it's compressed.
+	EncryptChunk(data []byte) ([]byte, error)
+	// EncryptHeader encrypts the header of a connection record that's written outside of chunks.
+	EncryptHeader(header []byte) ([]byte, error)
+}
+
+type aesCBCEncryptor struct {
+	block        cipher.Block
+	gpgKeyUser   string
+	encryptedKey []byte
+	rand         io.Reader
+}
+
+// NewAESCBCEncryptor creates the stock rosbag/AesCbcEncryptor. key is the 16-byte AES key that
+// encrypts the chunks, and encryptedKey is key encrypted with the GPG public key of gpgKeyUser,
+// e.g. by gpg --encrypt --recipient <gpgKeyUser>. rosbag decrypts encryptedKey with the private
+// key of gpgKeyUser, so the key itself is never written to the bag.
+func NewAESCBCEncryptor(key []byte, gpgKeyUser string, encryptedKey []byte) (Encryptor, error) {
+	if len(key) != aesKeyLen {
+		return nil, fmt.Errorf("expected an AES key of %d bytes, but got %d", aesKeyLen, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesCBCEncryptor{
+		block:        block,
+		gpgKeyUser:   gpgKeyUser,
+		encryptedKey: encryptedKey,
+		rand:         rand.Reader,
+	}, nil
+}
+
+func (encryptor *aesCBCEncryptor) Name() string {
+	return EncryptorAESCBC
+}
+
+func (encryptor *aesCBCEncryptor) HeaderFields() map[string][]byte {
+	return map[string][]byte{
+		"gpg_key_user":  []byte(encryptor.gpgKeyUser),
+		"encrypted_key": encryptor.encryptedKey,
+	}
+}
+
+// EncryptChunk pads data with PKCS#7, and encrypts it with a random IV. The IV is prepended to
+// the encrypted data.
+func (encryptor *aesCBCEncryptor) EncryptChunk(data []byte) ([]byte, error) {
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	encrypted := make([]byte, aes.BlockSize+len(data)+padding)
+	iv := encrypted[:aes.BlockSize]
+	_, err := io.ReadFull(encryptor.rand, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := encrypted[aes.BlockSize:]
+	copy(plain, data)
+	copy(plain[len(data):], bytes.Repeat([]byte{byte(padding)}, padding))
+	cipher.NewCBCEncrypter(encryptor.block, iv).CryptBlocks(plain, plain)
+	return encrypted, nil
+}
+
+// EncryptHeader encrypts header like a chunk, rosbag stores both the same way.
+func (encryptor *aesCBCEncryptor) EncryptHeader(header []byte) ([]byte, error) {
+	return encryptor.EncryptChunk(header)
+}
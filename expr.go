@@ -0,0 +1,266 @@
+package rosbag
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type exprStepKind uint8
+
+const (
+	exprStepField exprStepKind = iota
+	exprStepIndex
+	exprStepSlice
+	exprStepIterate
+)
+
+// exprStep is a single operation of an expression, e.g. ".name", "[3]", "[1:3]", or "[]".
+type exprStep struct {
+	kind  exprStepKind
+	name  string
+	index int
+	// lo and hi are the bounds of a slice, hasLo and hasHi are false when the bound is omitted
+	lo, hi       int
+	hasLo, hasHi bool
+}
+
+// Expr extracts values from decoded messages with a subset of the jq syntax:
+//
+//   - "." is the message itself.
+//   - ".a.b" selects nested fields.
+//   - ".a[3]" selects an array element, negative indices count from the end.
+//   - ".a[1:3]" selects a part of an array or a string, either bound can be omitted.
+//   - ".a[]" iterates over the elements of an array, or the values of a message, so the
+//     expression outputs multiple values.
+//   - "|" passes every output of the left expression to the right one, e.g.
+//     ".transforms[] | .child_frame_id".
+//
+// Missing fields and out of range indices output nil, like null in jq.
+type Expr struct {
+	raw    string
+	stages [][]exprStep
+}
+
+// CompileExpr parses expr, and returns an Expr that can be evaluated against messages.
+func CompileExpr(expr string) (*Expr, error) {
+	e := Expr{raw: expr}
+	for _, stage := range strings.Split(expr, "|") {
+		steps, err := parseExprStage(strings.TrimSpace(stage))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %s: %w", expr, err)
+		}
+		e.stages = append(e.stages, steps)
+	}
+	return &e, nil
+}
+
+// MustCompileExpr is like CompileExpr but panics if expr can't be parsed.
+func MustCompileExpr(expr string) *Expr {
+	e, err := CompileExpr(expr)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.raw
+}
+
+func parseExprStage(stage string) ([]exprStep, error) {
+	if !strings.HasPrefix(stage, ".") {
+		return nil, fmt.Errorf("%q must start with .", stage)
+	}
+
+	var steps []exprStep
+	// "." alone is the identity, and ".[0]" indexes the input
+	rest := stage
+
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "" {
+				// "." followed by "[" or the end
+				if (len(rest) > 0 && rest[0] == '.') || (len(rest) == 0 && len(steps) > 0) {
+					return nil, fmt.Errorf("%q has an empty field name", stage)
+				}
+				continue
+			}
+
+			if strings.ContainsAny(name, " ]") {
+				return nil, fmt.Errorf("%q has an invalid field name %q", stage, name)
+			}
+			steps = append(steps, exprStep{kind: exprStepField, name: name})
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unterminated [", stage)
+			}
+
+			step, err := parseExprBrackets(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", stage, err)
+			}
+			steps = append(steps, step)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%q has an unexpected %q", stage, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// parseExprBrackets parses the content of brackets, which is empty, an index, or a slice.
+func parseExprBrackets(content string) (exprStep, error) {
+	if content == "" {
+		return exprStep{kind: exprStepIterate}, nil
+	}
+
+	colon := strings.IndexByte(content, ':')
+	if colon < 0 {
+		index, err := strconv.Atoi(content)
+		if err != nil {
+			return exprStep{}, fmt.Errorf("invalid index %q", content)
+		}
+		return exprStep{kind: exprStepIndex, index: index}, nil
+	}
+
+	step := exprStep{kind: exprStepSlice}
+	var err error
+	if lo := strings.TrimSpace(content[:colon]); lo != "" {
+		step.hasLo = true
+		step.lo, err = strconv.Atoi(lo)
+		if err != nil {
+			return exprStep{}, fmt.Errorf("invalid slice %q", content)
+		}
+	}
+
+	if hi := strings.TrimSpace(content[colon+1:]); hi != "" {
+		step.hasHi = true
+		step.hi, err = strconv.Atoi(hi)
+		if err != nil {
+			return exprStep{}, fmt.Errorf("invalid slice %q", content)
+		}
+	}
+	return step, nil
+}
+
+// Eval evaluates the expression against v, which is usually a message that is viewed as a
+// map[string]interface{}, and returns the outputs. The outputs share the memory of v.
+func (e *Expr) Eval(v interface{}) ([]interface{}, error) {
+	outputs := []interface{}{v}
+	var err error
+	for _, stage := range e.stages {
+		for _, step := range stage {
+			outputs, err = step.eval(outputs)
+			if err != nil {
+				return nil, fmt.Errorf("expression %s: %w", e.raw, err)
+			}
+		}
+	}
+	return outputs, nil
+}
+
+func (step *exprStep) eval(inputs []interface{}) ([]interface{}, error) {
+	outputs := make([]interface{}, 0, len(inputs))
+	for _, input := range inputs {
+		if input == nil && step.kind != exprStepIterate {
+			outputs = append(outputs, nil)
+			continue
+		}
+
+		switch step.kind {
+		case exprStepField:
+			m, ok := input.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can't select field %s of a %T", step.name, input)
+			}
+			outputs = append(outputs, m[step.name])
+		case exprStepIndex:
+			value := reflect.ValueOf(input)
+			if value.Kind() != reflect.Slice {
+				return nil, fmt.Errorf("can't index a %T", input)
+			}
+
+			i := step.index
+			if i < 0 {
+				i += value.Len()
+			}
+
+			if i < 0 || i >= value.Len() {
+				outputs = append(outputs, nil)
+				continue
+			}
+			outputs = append(outputs, value.Index(i).Interface())
+		case exprStepSlice:
+			value := reflect.ValueOf(input)
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.String {
+				return nil, fmt.Errorf("can't slice a %T", input)
+			}
+
+			lo, hi := 0, value.Len()
+			if step.hasLo {
+				lo = clampExprBound(step.lo, value.Len())
+			}
+			if step.hasHi {
+				hi = clampExprBound(step.hi, value.Len())
+			}
+			if hi < lo {
+				hi = lo
+			}
+			outputs = append(outputs, value.Slice(lo, hi).Interface())
+		case exprStepIterate:
+			switch input := input.(type) {
+			case map[string]interface{}:
+				keys := make([]string, 0, len(input))
+				for key := range input {
+					keys = append(keys, key)
+				}
+
+				// maps are iterated in a random order
+				sort.Strings(keys)
+				for _, key := range keys {
+					outputs = append(outputs, input[key])
+				}
+			default:
+				value := reflect.ValueOf(input)
+				if value.Kind() != reflect.Slice {
+					return nil, fmt.Errorf("can't iterate over a %T", input)
+				}
+
+				for i := 0; i < value.Len(); i++ {
+					outputs = append(outputs, value.Index(i).Interface())
+				}
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// clampExprBound converts a slice bound to an index in [0, length], negative bounds count from
+// the end.
+func clampExprBound(bound, length int) int {
+	if bound < 0 {
+		bound += length
+	}
+
+	if bound < 0 {
+		return 0
+	}
+
+	if bound > length {
+		return length
+	}
+	return bound
+}
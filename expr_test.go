@@ -0,0 +1,67 @@
+package rosbag
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExprEval(t *testing.T) {
+	msg := map[string]interface{}{
+		"ranges": []float32{1, 2, 3, 4, 5},
+		"transforms": []map[string]interface{}{
+			{"child_frame_id": "base_link", "transform": map[string]interface{}{"x": 1.0}},
+			{"child_frame_id": "camera", "transform": map[string]interface{}{"x": 2.0}},
+		},
+		"name": "lidar",
+		"pose": map[string]interface{}{"b": 2, "a": 1},
+	}
+
+	testCases := []struct {
+		expr     string
+		expected []interface{}
+	}{
+		{expr: ".", expected: []interface{}{msg}},
+		{expr: ".name", expected: []interface{}{"lidar"}},
+		{expr: ".missing", expected: []interface{}{nil}},
+		{expr: ".missing.x", expected: []interface{}{nil}},
+		{expr: ".ranges[1]", expected: []interface{}{float32(2)}},
+		{expr: ".ranges[-1]", expected: []interface{}{float32(5)}},
+		{expr: ".ranges[10]", expected: []interface{}{nil}},
+		{expr: ".ranges[0:2]", expected: []interface{}{[]float32{1, 2}}},
+		{expr: ".ranges[3:]", expected: []interface{}{[]float32{4, 5}}},
+		{expr: ".ranges[:-3]", expected: []interface{}{[]float32{1, 2}}},
+		{expr: ".ranges[4:1]", expected: []interface{}{[]float32{}}},
+		{expr: ".name[1:3]", expected: []interface{}{"id"}},
+		{expr: ".ranges[3:] | .[]", expected: []interface{}{float32(4), float32(5)}},
+		{expr: ".transforms[] | .child_frame_id", expected: []interface{}{"base_link", "camera"}},
+		{expr: ".transforms[].transform.x", expected: []interface{}{1.0, 2.0}},
+		{expr: ".transforms[1].child_frame_id", expected: []interface{}{"camera"}},
+		{expr: ".pose[]", expected: []interface{}{1, 2}},
+	}
+
+	for _, testCase := range testCases {
+		outputs, err := MustCompileExpr(testCase.expr).Eval(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.expr, err)
+		}
+
+		if diff := cmp.Diff(testCase.expected, outputs); diff != "" {
+			t.Fatalf("%s: outputs are not matched:\n\n%s", testCase.expr, diff)
+		}
+	}
+
+	for _, expr := range []string{".name.x", ".name[0]", ".pose[0:1]", ".missing[]"} {
+		if _, err := MustCompileExpr(expr).Eval(msg); err == nil {
+			t.Fatalf("expected %s to fail", expr)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	for _, expr := range []string{"", "name", ".a..b", ".a.", ".a[", ".a[x]", ".a[1:x]", ".a | b", ".a]"} {
+		if _, err := CompileExpr(expr); err == nil {
+			t.Fatalf("expected %q to be invalid", expr)
+		}
+	}
+}
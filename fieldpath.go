@@ -0,0 +1,238 @@
+package rosbag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldPathElem is a field name in a FieldPath, with the index of the array element when the
+// field is an array. index is -1 when the element has no index.
+type fieldPathElem struct {
+	name  string
+	index int
+}
+
+// FieldPath selects a numeric field of a message, e.g. "pose.position.x" or "ranges[3]". Nested
+// messages are separated by dots, and array elements are selected by their index in brackets.
+// A FieldPath is resolved against the serialized message without decoding the other fields.
+type FieldPath struct {
+	raw   string
+	elems []fieldPathElem
+}
+
+// CompileFieldPath parses path, and returns a FieldPath that can be resolved against messages.
+func CompileFieldPath(path string) (*FieldPath, error) {
+	p := FieldPath{raw: path}
+	if path == "" {
+		return nil, fmt.Errorf("field path is empty")
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		elem := fieldPathElem{name: part, index: -1}
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("field path %s has an unterminated index in %s", path, part)
+			}
+
+			index, err := strconv.Atoi(part[i+1 : len(part)-1])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("field path %s has an invalid index in %s", path, part)
+			}
+			elem.name = part[:i]
+			elem.index = index
+		}
+
+		if elem.name == "" {
+			return nil, fmt.Errorf("field path %s has an empty field name", path)
+		}
+		p.elems = append(p.elems, elem)
+	}
+
+	return &p, nil
+}
+
+// MustCompileFieldPath is like CompileFieldPath but panics if path can't be parsed.
+func MustCompileFieldPath(path string) *FieldPath {
+	p, err := CompileFieldPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *FieldPath) String() string {
+	return p.raw
+}
+
+// Value resolves the path against the serialized message data of def, and returns the value of
+// the field with the Go type of Data Type Mapping. Strings are copied, so the value can be used
+// after the record is closed.
+func (p *FieldPath) Value(def *MessageDefinition, data []byte) (interface{}, error) {
+	field, raw, err := p.locate(def, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if field.Type == MessageFieldTypeComplex {
+		return nil, fmt.Errorf("field %s is a %s message, one of its fields must be selected", p.raw, field.rosType())
+	}
+
+	v, _, err := decodeFieldBasic(field, raw)
+	if err != nil {
+		return nil, err
+	}
+	return copyFieldValue(v), nil
+}
+
+// Float64 resolves the path like Value, and returns the value as a float64. Booleans are 0 or 1,
+// and times and durations are in seconds. An error is returned when the path is not in def, an
+// index is out of range, or the field is not numeric.
+func (p *FieldPath) Float64(def *MessageDefinition, data []byte) (float64, error) {
+	field, raw, err := p.locate(def, data)
+	if err != nil {
+		return 0, err
+	}
+
+	var v interface{}
+	if field.Type != MessageFieldTypeComplex {
+		v, _, err = decodeFieldBasic(field, raw)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	f, ok := numericValue(v)
+	if !ok {
+		return 0, fmt.Errorf("field %s is a %s, which is not numeric", p.raw, field.rosType())
+	}
+	return f, nil
+}
+
+// numericValue converts the decoded value of a numeric field to a float64. ok is false when v is
+// not numeric.
+func numericValue(v interface{}) (f float64, ok bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int8:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case time.Time:
+		return float64(v.UnixNano()) / 1e9, true
+	case time.Duration:
+		return v.Seconds(), true
+	default:
+		return 0, false
+	}
+}
+
+// locate returns the definition of the field that the path points to, and the message data that
+// starts at the field. Arrays are resolved to their element, so the returned field is never an
+// array.
+func (p *FieldPath) locate(def *MessageDefinition, raw []byte) (*MessageFieldDefinition, []byte, error) {
+	var err error
+	for i, elem := range p.elems {
+		var target *MessageFieldDefinition
+		for _, field := range def.Fields {
+			if field.Name == elem.name {
+				target = field
+				break
+			}
+
+			raw, err = skipFieldData(field, raw)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if target == nil {
+			return nil, nil, fmt.Errorf("field path %s: %s is not in %s", p.raw, elem.name, def.Type)
+		}
+
+		if target.IsArray != (elem.index >= 0) {
+			if target.IsArray {
+				return nil, nil, fmt.Errorf("field path %s: %s is an array, an element must be selected with an index", p.raw, elem.name)
+			}
+			return nil, nil, fmt.Errorf("field path %s: %s is not an array", p.raw, elem.name)
+		}
+
+		if target.IsArray {
+			target, raw, err = locateArrayElem(target, raw, elem.index)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field path %s: %s: %w", p.raw, elem.name, err)
+			}
+		}
+
+		if i == len(p.elems)-1 {
+			return target, raw, nil
+		}
+
+		if target.Type != MessageFieldTypeComplex {
+			return nil, nil, fmt.Errorf("field path %s: %s is a %s, which doesn't have fields", p.raw, elem.name, target.rosType())
+		}
+		def = target.MsgType
+	}
+
+	// CompileFieldPath never returns an empty path
+	return nil, nil, fmt.Errorf("field path is empty")
+}
+
+// locateArrayElem skips the array elements before index, and returns the definition of a single
+// element with the data that starts at it.
+func locateArrayElem(field *MessageFieldDefinition, raw []byte, index int) (*MessageFieldDefinition, []byte, error) {
+	size, fixed := fixedFieldSizes[field.Type]
+	var length, off int
+	var ok bool
+	if fixed {
+		length, off, ok = fieldDecodeSliceLength(raw, field.ArraySize, size)
+	} else {
+		length, off, ok = fieldDecodeLength(raw, field.ArraySize)
+	}
+
+	if !ok {
+		return nil, nil, errInvalidFormat
+	}
+	raw = raw[off:]
+
+	if index >= length {
+		return nil, nil, fmt.Errorf("index %d is out of range of %d elements", index, length)
+	}
+
+	elem := *field
+	elem.IsArray = false
+	elem.ArraySize = -1
+	if fixed {
+		return &elem, raw[index*size:], nil
+	}
+
+	var err error
+	for i := 0; i < index; i++ {
+		raw, err = skipFieldData(&elem, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return &elem, raw, nil
+}
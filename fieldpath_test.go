@@ -0,0 +1,65 @@
+package rosbag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFieldPathFloat64(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(planTestMessageDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := encodePlanTestMessage(3)
+	testCases := map[string]float64{
+		"header.seq":   1,
+		"header.stamp": 10.00000002,
+		"points[0].x":  0,
+		"points[2].y":  -2,
+		"points[1].x":  1,
+		"scale[2]":     3,
+	}
+
+	for path, expected := range testCases {
+		v, err := MustCompileFieldPath(path).Float64(&def, raw)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		if v != expected {
+			t.Fatalf("expected %s to be %v, but got %v", path, expected, v)
+		}
+	}
+
+	errCases := map[string]string{
+		"header.frame_id": "not numeric",
+		"points[3].x":     "out of range",
+		"points.x":        "an array",
+		"header[0].seq":   "not an array",
+		"unknown":         "not in",
+		"scale[0].x":      "doesn't have fields",
+	}
+
+	for path, expected := range errCases {
+		_, err := MustCompileFieldPath(path).Float64(&def, raw)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %s to fail with %q, but got %v", path, expected, err)
+		}
+	}
+
+	// the message is shorter than the array
+	_, err = MustCompileFieldPath("scale[2]").Float64(&def, raw[:len(raw)-20])
+	if err == nil {
+		t.Fatal("expected truncated data to fail")
+	}
+}
+
+func TestCompileFieldPath(t *testing.T) {
+	for _, path := range []string{"", "a..b", "a[", "a[x]", "a[-1]", "[0]"} {
+		if _, err := CompileFieldPath(path); err == nil {
+			t.Fatalf("expected %q to be invalid", path)
+		}
+	}
+}
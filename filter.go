@@ -0,0 +1,132 @@
+package rosbag
+
+import (
+	"errors"
+	"time"
+)
+
+// errSkippedRecord is returned internally when a record is skipped by the RecordFilter, it's never
+// returned by Read.
+var errSkippedRecord = errors.New("record is skipped by the record filter")
+
+// RecordInfo is the metadata of a record that is known before its data is read.
+type RecordInfo struct {
+	Op Op
+	// Conn and Time are only set for message data records
+	Conn uint32
+	Time time.Time
+	// HeaderLen and DataLen are the lengths of the header and the data of the record, the data of a
+	// chunk is compressed
+	HeaderLen uint32
+	DataLen   uint32
+	// InChunk is true for the records inside a chunk
+	InChunk bool
+}
+
+// RecordFilter decides whether a record is read, it returns false to skip the record. info must
+// not be kept after the filter returns.
+type RecordFilter func(info *RecordInfo) bool
+
+// keepRecord reports whether the record with the header in record should be read. The records
+// that the decoder needs, e.g. the connections, are always read, and so are the records whose
+// headers can't be parsed, so that their errors are reported. The records of unknown ops are
+// skipped with UnknownOpsSkip.
+func (decoder *Decoder) keepRecord(op Op, record *RecordBase, inChunk bool) bool {
+	if !decoder.knownOp(op) {
+		return false
+	}
+
+	if decoder.recordFilter == nil || (op != OpMessageData && op != OpChunk) {
+		return true
+	}
+
+	info := RecordInfo{
+		Op:        op,
+		HeaderLen: record.HeaderLen,
+		DataLen:   record.DataLen,
+		InChunk:   inChunk,
+	}
+
+	if op == OpMessageData {
+		var err error
+		info.Conn, err = record.findFieldUint32([]byte("conn"))
+		if err != nil {
+			return true
+		}
+
+		info.Time, err = record.findFieldTime([]byte("time"))
+		if err != nil {
+			return true
+		}
+	}
+	return decoder.recordFilter(&info)
+}
+
+// typeFilter decides which connections are read based on their types. A nil typeFilter allows
+// every connection.
+type typeFilter struct {
+	// include is ignored when it's empty
+	include []*Pattern
+	exclude []*Pattern
+	// allowed caches the decision per connection header
+	allowed map[*ConnectionHeader]bool
+}
+
+func (filter *typeFilter) allow(connHdr *ConnectionHeader) bool {
+	if filter == nil {
+		return true
+	}
+
+	allowed, ok := filter.allowed[connHdr]
+	if ok {
+		return allowed
+	}
+
+	allowed = filter.match(connHdr.Type)
+	if filter.allowed == nil {
+		filter.allowed = make(map[*ConnectionHeader]bool)
+	}
+	filter.allowed[connHdr] = allowed
+	return allowed
+}
+
+func (filter *typeFilter) match(msgType string) bool {
+	for _, pattern := range filter.exclude {
+		if pattern.Match(msgType) {
+			return false
+		}
+	}
+
+	if len(filter.include) == 0 {
+		return true
+	}
+
+	for _, pattern := range filter.include {
+		if pattern.Match(msgType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skip reports whether record should be skipped by the decoder.
+func (filter *typeFilter) skip(record Record) bool {
+	if filter == nil {
+		return false
+	}
+
+	switch record := record.(type) {
+	case *RecordMessageData:
+		return !filter.allow(record.ConnectionHeader())
+	case *RecordConnection:
+		connHdr, err := record.ConnectionHeader()
+		if err != nil {
+			// let the caller see the broken record
+			return false
+		}
+		return !filter.match(connHdr.Type)
+	default:
+		return false
+	}
+}
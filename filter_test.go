@@ -0,0 +1,218 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecoderTypeFilter(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Opts     []DecoderOption
+		Expected func(msgType string) bool
+	}{
+		{
+			Name:     "Include Exact",
+			Opts:     []DecoderOption{WithMessageTypes("geometry_msgs/Twist")},
+			Expected: func(msgType string) bool { return msgType == "geometry_msgs/Twist" },
+		},
+		{
+			Name: "Include Glob",
+			Opts: []DecoderOption{WithMessageTypes("turtlesim/*", "rosgraph_msgs/Log")},
+			Expected: func(msgType string) bool {
+				return msgType == "turtlesim/Pose" || msgType == "turtlesim/Color" || msgType == "rosgraph_msgs/Log"
+			},
+		},
+		{
+			Name:     "Exclude Regexp",
+			Opts:     []DecoderOption{WithoutMessageTypes("re:tf2?(_msgs)?/.*")},
+			Expected: func(msgType string) bool { return msgType != "tf/tfMessage" && msgType != "tf2_msgs/TFMessage" },
+		},
+		{
+			Name: "Exclude Takes Precedence",
+			Opts: []DecoderOption{
+				WithMessageTypes("turtlesim/*"),
+				WithoutMessageTypes("turtlesim/Color"),
+			},
+			Expected: func(msgType string) bool { return msgType == "turtlesim/Pose" },
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			f := openExampleBag(t)
+			defer f.Close()
+
+			expected := make(map[string]int)
+			decoder := NewDecoder(f)
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if record, ok := record.(*RecordMessageData); ok {
+					msgType := record.ConnectionHeader().Type
+					if testCase.Expected(msgType) {
+						expected[msgType]++
+					}
+				}
+				record.Close()
+			}
+
+			_, err := f.Seek(0, io.SeekStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := make(map[string]int)
+			decoder = NewDecoder(f, testCase.Opts...)
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				switch record := record.(type) {
+				case *RecordMessageData:
+					actual[record.ConnectionHeader().Type]++
+				case *RecordConnection:
+					connHdr, err := record.ConnectionHeader()
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if !testCase.Expected(connHdr.Type) {
+						t.Fatalf("expected connection with %s to be skipped", connHdr.Type)
+					}
+				}
+				record.Close()
+			}
+
+			if len(expected) == 0 {
+				t.Fatal("expected some messages to pass the filter")
+			}
+
+			for msgType, count := range expected {
+				if actual[msgType] != count {
+					t.Fatalf("expected %d messages of %s, but got %d", count, msgType, actual[msgType])
+				}
+			}
+
+			if len(actual) != len(expected) {
+				t.Fatalf("expected message types to be %v, but got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecoderTypeFilterInvalidPattern(t *testing.T) {
+	decoder := NewDecoderBytes([]byte("#ROSBAG V2.0\n"), WithMessageTypes("re:("))
+	if _, err := decoder.Read(); err == nil || err == io.EOF {
+		t.Fatalf("expected an invalid pattern error, but got %v", err)
+	}
+}
+
+func TestDecoderRecordFilter(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}, {0, 3}},
+		[]indexedTestMessage{{0, 4}, {0, 5}},
+		[]indexedTestMessage{{1, 6}, {0, 7}},
+	)
+
+	// the first record of every chunk is too long for the chunk
+	corrupted := append([]byte(nil), raw...)
+	units, err := Partition(bytes.NewReader(raw), WithWorkUnitSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unit := range units {
+		chunk, err := readRecordHeader(bytes.NewReader(raw[unit.Offset:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		endian.PutUint32(corrupted[unit.Offset+int64(len(chunk.Raw)):], 1<<24)
+	}
+
+	decoders := map[string]func(raw []byte, opts ...DecoderOption) *Decoder{
+		"Stream": func(raw []byte, opts ...DecoderOption) *Decoder {
+			return NewDecoder(bytes.NewReader(raw), opts...)
+		},
+		"Bytes": func(raw []byte, opts ...DecoderOption) *Decoder {
+			return NewDecoderBytes(raw, opts...)
+		},
+		"Prefetch": func(raw []byte, opts ...DecoderOption) *Decoder {
+			return NewDecoder(bytes.NewReader(raw), append(opts, WithPrefetch())...)
+		},
+	}
+
+	for name, newDecoder := range decoders {
+		newDecoder := newDecoder
+		t.Run(name, func(t *testing.T) {
+			// every other message of /a from 3s
+			var seen int
+			decoder := newDecoder(raw, WithRecordFilter(func(info *RecordInfo) bool {
+				if info.Op != OpMessageData {
+					return true
+				}
+
+				if !info.InChunk || info.DataLen != 1 {
+					t.Fatalf("expected a message with a byte in a chunk, but got %+v", info)
+				}
+
+				if info.Conn != 0 || info.Time.Before(time.Unix(3, 0)) {
+					return false
+				}
+				seen++
+				return seen%2 == 1
+			}))
+			defer decoder.Close()
+
+			expected := []indexedTestMessage{{0, 3}, {0, 5}}
+			if diff := cmp.Diff(expected, readIndexedTestMessages(t, decoder)); diff != "" {
+				t.Fatalf("messages are not matched:\n\n%s", diff)
+			}
+
+			// the skipped chunks are not decompressed, so their records don't matter
+			var chunks int
+			decoder = newDecoder(corrupted, WithRecordFilter(func(info *RecordInfo) bool {
+				if info.Op == OpChunk {
+					chunks++
+					return false
+				}
+				return true
+			}))
+			defer decoder.Close()
+
+			if messages := readIndexedTestMessages(t, decoder); len(messages) != 0 || chunks != 3 {
+				t.Fatalf("expected 3 chunks to be skipped, but got %d chunks and %v", chunks, messages)
+			}
+
+			decoder = newDecoder(corrupted)
+			defer decoder.Close()
+			for {
+				record, err := decoder.Read()
+				if err == io.EOF {
+					t.Fatal("expected the corrupted chunks to fail")
+				}
+
+				if err != nil {
+					break
+				}
+				record.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package hdf5
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// TimeDataset is the name of the dataset with the record times of the messages of a topic in
+// nanoseconds since the Unix epoch.
+const TimeDataset = "time"
+
+var (
+	errNoFields = errors.New("at least one field is required")
+)
+
+// Field is a numeric field of the messages of a topic.
+type Field struct {
+	// Topic is also the path of the group of the topic, so it should be an exact topic name
+	// instead of a pattern
+	Topic string
+	Path  *rosbag.FieldPath
+}
+
+type topicColumns struct {
+	time   []int64
+	paths  []*rosbag.FieldPath
+	values [][]float64
+}
+
+// Export runs decoder to the end, and writes the fields to w as an HDF5 file. Every topic is a
+// group with a float64 dataset per field, which is named by the field path, and an int64 time
+// dataset, e.g. /odom/pose.pose.position.x and /odom/time. The values of a message are at the same
+// index in every dataset of its topic. See rosbag.FieldPath.Float64 for the conversion of the
+// values.
+func Export(w io.Writer, decoder *rosbag.Decoder, fields []Field, opts ...Option) error {
+	if len(fields) == 0 {
+		return errNoFields
+	}
+
+	topics := make(map[string]*topicColumns)
+	for _, field := range fields {
+		columns, ok := topics[field.Topic]
+		if !ok {
+			columns = &topicColumns{}
+			topics[field.Topic] = columns
+
+			err := decoder.Subscribe(field.Topic, columns.add)
+			if err != nil {
+				return err
+			}
+		}
+		columns.paths = append(columns.paths, field.Path)
+		columns.values = append(columns.values, nil)
+	}
+
+	err := decoder.Run()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+
+	file := NewFile(opts...)
+	for _, topic := range names {
+		columns := topics[topic]
+		err = file.AddInt64s(topic+"/"+TimeDataset, columns.time)
+		if err != nil {
+			return err
+		}
+
+		for i, path := range columns.paths {
+			err = file.AddFloat64s(topic+"/"+path.String(), columns.values[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = file.WriteTo(w)
+	return err
+}
+
+func (columns *topicColumns) add(record *rosbag.RecordMessageData) error {
+	t, err := record.Time()
+	if err != nil {
+		return err
+	}
+
+	def := &record.ConnectionHeader().MessageDefinition
+	for i, path := range columns.paths {
+		v, err := path.Float64(def, record.Data())
+		if err != nil {
+			return err
+		}
+		columns.values[i] = append(columns.values[i], v)
+	}
+	columns.time = append(columns.time, t.UnixNano())
+	return nil
+}
@@ -0,0 +1,592 @@
+// Package hdf5 exports numeric fields of bags as HDF5 files, so that they can be loaded by tools
+// like h5py and MATLAB. Every topic is a group with a dataset per field path, and a time dataset
+// with the record times of the messages, e.g. /imu/data/angular_velocity.x and /imu/data/time.
+//
+// The files are written with the original HDF5 file format, superblock version 0, which is
+// readable by every version of the HDF5 library. The datasets are 1-D, chunked, and compressed
+// with deflate.
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	signature = "\x89HDF\r\n\x1a\n"
+	// superblockSize is the size of a version 0 superblock with 8-byte offsets and lengths
+	superblockSize = 96
+	// undefinedAddress marks addresses that don't point to anything, e.g. the sibling of the
+	// last B-tree node
+	undefinedAddress = math.MaxUint64
+	// The K values of the B-trees, a node has up to 2K children. The chunk K is not stored in a
+	// version 0 superblock, so it must be the default of the HDF5 library.
+	groupLeafK     = 4
+	groupInternalK = 16
+	chunkK         = 32
+	// heapFreeNull ends the free list of a local heap, since 0 is a valid heap offset
+	heapFreeNull    = 1
+	symbolEntrySize = 40
+	// elemSize is the size of the float64 and int64 elements
+	elemSize = 8
+)
+
+// The types of the object header messages.
+const (
+	msgDataspace      = 0x0001
+	msgDatatype       = 0x0003
+	msgFillValue      = 0x0005
+	msgLayout         = 0x0008
+	msgFilterPipeline = 0x000b
+	msgSymbolTable    = 0x0011
+)
+
+// The node types of version 1 B-trees.
+const (
+	btreeGroup = 0
+	btreeChunk = 1
+)
+
+const (
+	cacheNone        = 0
+	cacheSymbolTable = 1
+)
+
+const (
+	filterDeflate = 1
+	// filterOptional lets readers skip the filter when it fails, like the HDF5 library creates
+	// deflate filters
+	filterOptional = 1
+)
+
+var (
+	errInvalidChunkSize        = errors.New("chunk size must be positive")
+	errInvalidCompressionLevel = errors.New("compression level must be between 1 and 9")
+	errEmptyName               = errors.New("dataset path must have a name")
+)
+
+type config struct {
+	chunkSize        int
+	compressionLevel int
+}
+
+// Option configures the datasets of a file.
+type Option func(*config)
+
+// WithChunkSize sets the number of elements in a chunk of a dataset. Every chunk is compressed on
+// its own, so readers only decompress the chunks that they read. The default is 16384.
+func WithChunkSize(elements int) Option {
+	return func(cfg *config) {
+		cfg.chunkSize = elements
+	}
+}
+
+// WithCompressionLevel sets the deflate level of the chunks from 1, the fastest, to 9, the
+// smallest. The default is 4, like h5py.
+func WithCompressionLevel(level int) Option {
+	return func(cfg *config) {
+		cfg.compressionLevel = level
+	}
+}
+
+type datatype int
+
+const (
+	datatypeFloat64 datatype = iota
+	datatypeInt64
+)
+
+type dataset struct {
+	typ datatype
+	// data are the little-endian elements
+	data []byte
+}
+
+func (ds *dataset) len() int {
+	return len(ds.data) / elemSize
+}
+
+// group maps the names of its links to *group or *dataset.
+type group struct {
+	links map[string]interface{}
+}
+
+func newGroup() *group {
+	return &group{links: make(map[string]interface{})}
+}
+
+// File is an HDF5 file that is built in memory, and written with WriteTo.
+type File struct {
+	root   *group
+	config config
+}
+
+// NewFile creates an empty file.
+func NewFile(opts ...Option) *File {
+	cfg := config{
+		chunkSize:        16384,
+		compressionLevel: 4,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return &File{root: newGroup(), config: cfg}
+}
+
+// AddFloat64s adds a 1-D float64 dataset at path, e.g. /imu/data/angular_velocity.x. The groups
+// of the path are created when they don't exist.
+func (f *File) AddFloat64s(path string, values []float64) error {
+	data := make([]byte, elemSize*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[elemSize*i:], math.Float64bits(v))
+	}
+	return f.add(path, &dataset{typ: datatypeFloat64, data: data})
+}
+
+// AddInt64s adds a 1-D int64 dataset at path. The groups of the path are created when they don't
+// exist.
+func (f *File) AddInt64s(path string, values []int64) error {
+	data := make([]byte, elemSize*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[elemSize*i:], uint64(v))
+	}
+	return f.add(path, &dataset{typ: datatypeInt64, data: data})
+}
+
+func (f *File) add(path string, ds *dataset) error {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return errEmptyName
+	}
+
+	g := f.root
+	for i, name := range names[:len(names)-1] {
+		link, ok := g.links[name]
+		if !ok {
+			sub := newGroup()
+			g.links[name] = sub
+			g = sub
+			continue
+		}
+
+		sub, ok := link.(*group)
+		if !ok {
+			return fmt.Errorf("/%s is a dataset, but it's used as a group", strings.Join(names[:i+1], "/"))
+		}
+		g = sub
+	}
+
+	name := names[len(names)-1]
+	if _, ok := g.links[name]; ok {
+		return fmt.Errorf("/%s already exists", strings.Join(names, "/"))
+	}
+	g.links[name] = ds
+	return nil
+}
+
+// WriteTo writes the file to w.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if f.config.chunkSize <= 0 {
+		return 0, errInvalidChunkSize
+	}
+
+	if f.config.compressionLevel < 1 || f.config.compressionLevel > 9 {
+		return 0, errInvalidCompressionLevel
+	}
+
+	e := encoder{config: &f.config}
+	// the superblock is written last, since it points to the root group
+	e.buf = make([]byte, superblockSize)
+	root, err := e.writeGroup(f.root)
+	if err != nil {
+		return 0, err
+	}
+
+	superblock := []byte(signature)
+	// the versions of the superblock, the free space, the root symbol table entry, and the shared
+	// header messages, then the sizes of offsets and lengths
+	superblock = append(superblock, 0, 0, 0, 0, 0, 8, 8, 0)
+	superblock = appendUint16(superblock, groupLeafK)
+	superblock = appendUint16(superblock, groupInternalK)
+	// file consistency flags
+	superblock = appendUint32(superblock, 0)
+	// base address, free space address, end of file address, and driver information address
+	superblock = appendUint64(superblock, 0)
+	superblock = appendUint64(superblock, undefinedAddress)
+	superblock = appendUint64(superblock, uint64(len(e.buf)))
+	superblock = appendUint64(superblock, undefinedAddress)
+	superblock = appendSymbolEntry(superblock, 0, root)
+	copy(e.buf, superblock)
+
+	n, err := w.Write(e.buf)
+	return int64(n), err
+}
+
+// encoder appends the objects of a file to buf, and their addresses are their offsets in buf.
+// Objects are written before the objects that point to them, so every address is known when it's
+// written.
+type encoder struct {
+	config *config
+	buf    []byte
+}
+
+func (e *encoder) addr() uint64 {
+	return uint64(len(e.buf))
+}
+
+// groupAddrs are the addresses of a group, the B-tree and the heap are cached in the symbol table
+// entries of the group.
+type groupAddrs struct {
+	header uint64
+	btree  uint64
+	heap   uint64
+}
+
+// writeGroup writes the links of g, and g as an old-style group with a symbol table, which is a
+// B-tree of symbol table nodes, and a local heap with the names of the links.
+func (e *encoder) writeGroup(g *group) (*groupAddrs, error) {
+	names := make([]string, 0, len(g.links))
+	for name := range g.links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the heap starts with an empty string, which is the key before the first name
+	heap := make([]byte, 8)
+	offsets := make([]uint64, len(names))
+	for i, name := range names {
+		offsets[i] = uint64(len(heap))
+		heap = append(heap, name...)
+		heap = append(heap, make([]byte, align8(len(name)+1)-len(name))...)
+	}
+
+	links := make([]*groupAddrs, len(names))
+	for i, name := range names {
+		switch link := g.links[name].(type) {
+		case *group:
+			addrs, err := e.writeGroup(link)
+			if err != nil {
+				return nil, err
+			}
+			links[i] = addrs
+		case *dataset:
+			header, err := e.writeDataset(link)
+			if err != nil {
+				return nil, err
+			}
+			links[i] = &groupAddrs{header: header}
+		}
+	}
+
+	heapAddr := e.addr()
+	e.buf = append(e.buf, "HEAP"...)
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	e.buf = appendUint64(e.buf, uint64(len(heap)))
+	e.buf = appendUint64(e.buf, heapFreeNull)
+	e.buf = appendUint64(e.buf, heapAddr+32)
+	e.buf = append(e.buf, heap...)
+
+	var nodes []btreeEntry
+	var left uint64
+	for start := 0; start < len(names); start += 2 * groupLeafK {
+		end := start + 2*groupLeafK
+		if end > len(names) {
+			end = len(names)
+		}
+
+		addr := e.addr()
+		e.buf = append(e.buf, "SNOD"...)
+		e.buf = append(e.buf, 1, 0)
+		e.buf = appendUint16(e.buf, uint16(end-start))
+		for i := start; i < end; i++ {
+			e.buf = appendSymbolEntry(e.buf, offsets[i], links[i])
+		}
+		e.buf = append(e.buf, make([]byte, (2*groupLeafK-(end-start))*symbolEntrySize)...)
+
+		right := offsets[end-1]
+		nodes = append(nodes, btreeEntry{left: appendUint64(nil, left), right: appendUint64(nil, right), addr: addr})
+		left = right
+	}
+
+	btree := e.writeBTree(btreeGroup, groupInternalK, 8, nodes)
+	header := e.writeObjectHeader([]message{
+		{typ: msgSymbolTable, data: appendUint64(appendUint64(nil, btree), heapAddr)},
+	})
+	return &groupAddrs{header: header, btree: btree, heap: heapAddr}, nil
+}
+
+// appendSymbolEntry appends the symbol table entry of a link to b. The B-tree and the heap of
+// groups are cached in the scratch pad.
+func appendSymbolEntry(b []byte, nameOffset uint64, link *groupAddrs) []byte {
+	b = appendUint64(b, nameOffset)
+	b = appendUint64(b, link.header)
+	if link.btree == 0 {
+		b = appendUint32(b, cacheNone)
+		return append(b, make([]byte, 20)...)
+	}
+
+	b = appendUint32(b, cacheSymbolTable)
+	b = appendUint32(b, 0)
+	b = appendUint64(b, link.btree)
+	return appendUint64(b, link.heap)
+}
+
+// writeDataset writes the chunks of ds, the B-tree that indexes them, and the object header of ds.
+func (e *encoder) writeDataset(ds *dataset) (uint64, error) {
+	n := ds.len()
+	chunkLen := e.config.chunkSize
+	if n < chunkLen {
+		chunkLen = n
+	}
+	// chunks can't be empty even when the dataset is
+	if chunkLen == 0 {
+		chunkLen = 1
+	}
+
+	var chunks []btreeEntry
+	var compressed bytes.Buffer
+	for start := 0; start < n; start += chunkLen {
+		end := start + chunkLen
+		if end > n {
+			end = n
+		}
+
+		// the last chunk is padded to the chunk size, since chunks always have the same size
+		// before they're compressed
+		raw := ds.data[start*elemSize : end*elemSize]
+		if end-start < chunkLen {
+			raw = append(append([]byte{}, raw...), make([]byte, (chunkLen-(end-start))*elemSize)...)
+		}
+
+		compressed.Reset()
+		zw, err := zlib.NewWriterLevel(&compressed, e.config.compressionLevel)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = zw.Write(raw)
+		if err != nil {
+			return 0, err
+		}
+
+		err = zw.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		addr := e.addr()
+		e.buf = append(e.buf, compressed.Bytes()...)
+		chunks = append(chunks, btreeEntry{
+			left:  chunkKey(uint32(compressed.Len()), uint64(start)),
+			right: chunkKey(0, uint64(start+chunkLen)),
+			addr:  addr,
+		})
+	}
+
+	// datasets without chunks don't have a B-tree yet
+	btree := uint64(undefinedAddress)
+	if len(chunks) > 0 {
+		btree = e.writeBTree(btreeChunk, chunkK, len(chunkKey(0, 0)), chunks)
+	}
+
+	// the dataspace is resizable, so the chunks don't have to fit in it
+	dataspace := []byte{1, 1, 1, 0, 0, 0, 0, 0}
+	dataspace = appendUint64(dataspace, uint64(n))
+	dataspace = appendUint64(dataspace, undefinedAddress)
+
+	var datatype []byte
+	switch ds.typ {
+	case datatypeFloat64:
+		// version 1 floating point, little-endian IEEE 754 with an implied mantissa bit, and the
+		// sign at bit 63
+		datatype = []byte{0x11, 0x20, 63, 0}
+		datatype = appendUint32(datatype, elemSize)
+		datatype = appendUint16(datatype, 0)
+		datatype = appendUint16(datatype, 64)
+		datatype = append(datatype, 52, 11, 0, 52)
+		datatype = appendUint32(datatype, 1023)
+	case datatypeInt64:
+		// version 1 fixed point, little-endian and signed
+		datatype = []byte{0x10, 0x08, 0, 0}
+		datatype = appendUint32(datatype, elemSize)
+		datatype = appendUint16(datatype, 0)
+		datatype = appendUint16(datatype, 64)
+	}
+
+	// version 2 with incremental allocation, the fill value is written if it's set, and it's not
+	fillValue := []byte{2, 3, 2, 0}
+
+	// version 3 chunked layout, the last dimension of the chunks is the element size
+	layout := []byte{3, 2, 2}
+	layout = appendUint64(layout, btree)
+	layout = appendUint32(layout, uint32(chunkLen))
+	layout = appendUint32(layout, elemSize)
+
+	// version 1 with a single filter that has the compression level as its only parameter, which
+	// is padded to 8 bytes
+	filters := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	filters = appendUint16(filters, filterDeflate)
+	filters = appendUint16(filters, 0)
+	filters = appendUint16(filters, filterOptional)
+	filters = appendUint16(filters, 1)
+	filters = appendUint32(filters, uint32(e.config.compressionLevel))
+	filters = appendUint32(filters, 0)
+
+	return e.writeObjectHeader([]message{
+		{typ: msgDataspace, data: dataspace},
+		{typ: msgDatatype, data: datatype, constant: true},
+		{typ: msgFillValue, data: fillValue, constant: true},
+		{typ: msgLayout, data: layout},
+		{typ: msgFilterPipeline, data: filters},
+	}), nil
+}
+
+// chunkKey is the B-tree key of the chunk at offset, the offset of the element dimension is
+// always 0.
+func chunkKey(size uint32, offset uint64) []byte {
+	// the filter mask is 0, since every filter has been applied
+	key := appendUint32(appendUint32(nil, size), 0)
+	key = appendUint64(key, offset)
+	return appendUint64(key, 0)
+}
+
+type message struct {
+	typ  uint16
+	data []byte
+	// constant marks messages that never change, like the HDF5 library marks datatypes
+	constant bool
+}
+
+// writeObjectHeader writes a version 1 object header with messages.
+func (e *encoder) writeObjectHeader(messages []message) uint64 {
+	var size int
+	for _, msg := range messages {
+		size += 8 + align8(len(msg.data))
+	}
+
+	addr := e.addr()
+	e.buf = append(e.buf, 1, 0)
+	e.buf = appendUint16(e.buf, uint16(len(messages)))
+	// the reference count, the size of the messages, and the padding that aligns the messages
+	e.buf = appendUint32(e.buf, 1)
+	e.buf = appendUint32(e.buf, uint32(size))
+	e.buf = appendUint32(e.buf, 0)
+	for _, msg := range messages {
+		var flags byte
+		if msg.constant {
+			flags = 1
+		}
+
+		e.buf = appendUint16(e.buf, msg.typ)
+		e.buf = appendUint16(e.buf, uint16(align8(len(msg.data))))
+		e.buf = append(e.buf, flags, 0, 0, 0)
+		e.buf = append(e.buf, msg.data...)
+		e.buf = append(e.buf, make([]byte, align8(len(msg.data))-len(msg.data))...)
+	}
+	return addr
+}
+
+// btreeEntry is a child of a B-tree node with the keys around it.
+type btreeEntry struct {
+	left  []byte
+	right []byte
+	addr  uint64
+}
+
+// writeBTree writes a version 1 B-tree of entries, and returns the address of its root. The
+// leaves are written first, then every level of nodes above them until a single node is left.
+func (e *encoder) writeBTree(nodeType byte, k int, keySize int, entries []btreeEntry) uint64 {
+	nodeSize := 24 + 2*k*8 + (2*k+1)*keySize
+	if len(entries) == 0 {
+		addr := e.addr()
+		e.writeBTreeNode(nodeType, 0, nodeSize, keySize, nil, undefinedAddress, undefinedAddress)
+		return addr
+	}
+
+	for level := byte(0); ; level++ {
+		// the nodes of a level have the same size, so the siblings are known before they're
+		// written
+		base := e.addr()
+		nodes := (len(entries) + 2*k - 1) / (2 * k)
+		var parents []btreeEntry
+		for i := 0; i < nodes; i++ {
+			children := entries[i*2*k:]
+			if len(children) > 2*k {
+				children = children[:2*k]
+			}
+
+			left, right := uint64(undefinedAddress), uint64(undefinedAddress)
+			if i > 0 {
+				left = base + uint64((i-1)*nodeSize)
+			}
+			if i < nodes-1 {
+				right = base + uint64((i+1)*nodeSize)
+			}
+
+			addr := e.addr()
+			e.writeBTreeNode(nodeType, level, nodeSize, keySize, children, left, right)
+			parents = append(parents, btreeEntry{
+				left:  children[0].left,
+				right: children[len(children)-1].right,
+				addr:  addr,
+			})
+		}
+
+		if len(parents) == 1 {
+			return parents[0].addr
+		}
+		entries = parents
+	}
+}
+
+func (e *encoder) writeBTreeNode(nodeType byte, level byte, nodeSize int, keySize int, children []btreeEntry, left, right uint64) {
+	start := len(e.buf)
+	e.buf = append(e.buf, "TREE"...)
+	e.buf = append(e.buf, nodeType, level)
+	e.buf = appendUint16(e.buf, uint16(len(children)))
+	e.buf = appendUint64(e.buf, left)
+	e.buf = appendUint64(e.buf, right)
+	for _, child := range children {
+		e.buf = append(e.buf, child.left...)
+		e.buf = appendUint64(e.buf, child.addr)
+	}
+
+	if len(children) > 0 {
+		e.buf = append(e.buf, children[len(children)-1].right...)
+	} else {
+		e.buf = append(e.buf, make([]byte, keySize)...)
+	}
+
+	// nodes are always read with their full size
+	e.buf = append(e.buf, make([]byte, nodeSize-(len(e.buf)-start))...)
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
@@ -0,0 +1,362 @@
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// reader reads the subset of the HDF5 file format that File writes, and fails the test on anything
+// that doesn't match the specification.
+type reader struct {
+	t *testing.T
+	b []byte
+}
+
+func (r *reader) expect(addr uint64, sig string) []byte {
+	r.t.Helper()
+	if addr+uint64(len(sig)) > uint64(len(r.b)) || string(r.b[addr:addr+uint64(len(sig))]) != sig {
+		r.t.Fatalf("expected %q at %d", sig, addr)
+	}
+	return r.b[addr+uint64(len(sig)):]
+}
+
+// readLinks reads the names and the object header addresses of the links of a group, and checks
+// the cached symbol tables of its subgroups.
+func (r *reader) readLinks(header uint64) map[string]uint64 {
+	r.t.Helper()
+	msgs := r.readObjectHeader(header)
+	table, ok := msgs[msgSymbolTable]
+	if !ok {
+		r.t.Fatalf("expected a symbol table in the group at %d", header)
+	}
+	btree, heap := binary.LittleEndian.Uint64(table), binary.LittleEndian.Uint64(table[8:])
+
+	b := r.expect(heap, "HEAP")
+	if b[0] != 0 || binary.LittleEndian.Uint64(b[12:]) != heapFreeNull {
+		r.t.Fatalf("invalid local heap at %d", heap)
+	}
+	size, data := binary.LittleEndian.Uint64(b[4:]), binary.LittleEndian.Uint64(b[20:])
+	names := r.b[data : data+size]
+
+	links := make(map[string]uint64)
+	var prev string
+	r.walkBTree(btree, btreeGroup, 8, func(snod uint64) {
+		b := r.expect(snod, "SNOD")
+		n := int(binary.LittleEndian.Uint16(b[2:]))
+		if b[0] != 1 || n > 2*groupLeafK {
+			r.t.Fatalf("invalid symbol table node at %d", snod)
+		}
+
+		for i := 0; i < n; i++ {
+			entry := b[4+i*symbolEntrySize:]
+			offset := binary.LittleEndian.Uint64(entry)
+			name := string(names[offset : offset+uint64(bytes.IndexByte(names[offset:], 0))])
+			if name <= prev {
+				r.t.Fatalf("expected %s to be sorted after %s", name, prev)
+			}
+			prev = name
+
+			addr := binary.LittleEndian.Uint64(entry[8:])
+			if binary.LittleEndian.Uint32(entry[16:]) == cacheSymbolTable {
+				cached := r.readObjectHeader(addr)[msgSymbolTable]
+				if !bytes.Equal(cached, entry[24:40]) {
+					r.t.Fatalf("cached symbol table of %s doesn't match its object header", name)
+				}
+			}
+			links[name] = addr
+		}
+	})
+	return links
+}
+
+// walkBTree calls fn with the children of the leaves of a version 1 B-tree in order.
+func (r *reader) walkBTree(addr uint64, nodeType byte, keySize int, fn func(child uint64)) {
+	r.t.Helper()
+	b := r.expect(addr, "TREE")
+	if b[0] != nodeType {
+		r.t.Fatalf("expected node type %d at %d, but got %d", nodeType, addr, b[0])
+	}
+
+	level := b[1]
+	n := int(binary.LittleEndian.Uint16(b[2:]))
+	for i := 0; i < n; i++ {
+		child := binary.LittleEndian.Uint64(b[20+keySize+i*(keySize+8):])
+		if level == 0 {
+			fn(child)
+			continue
+		}
+		r.walkBTree(child, nodeType, keySize, fn)
+	}
+}
+
+func (r *reader) readObjectHeader(addr uint64) map[uint16][]byte {
+	r.t.Helper()
+	b := r.b[addr:]
+	if b[0] != 1 {
+		r.t.Fatalf("expected a version 1 object header at %d", addr)
+	}
+
+	n := int(binary.LittleEndian.Uint16(b[2:]))
+	size := int(binary.LittleEndian.Uint32(b[8:]))
+	b = b[16 : 16+size]
+	msgs := make(map[uint16][]byte)
+	for i := 0; i < n; i++ {
+		typ, msgSize := binary.LittleEndian.Uint16(b), int(binary.LittleEndian.Uint16(b[2:]))
+		if msgSize%8 != 0 {
+			r.t.Fatalf("message %d at %d is not aligned", typ, addr)
+		}
+		msgs[typ] = b[8 : 8+msgSize]
+		b = b[8+msgSize:]
+	}
+
+	if len(b) != 0 {
+		r.t.Fatalf("expected %d messages to fill the object header at %d", n, addr)
+	}
+	return msgs
+}
+
+// readDataset reads the elements of a 1-D dataset as little-endian bytes.
+func (r *reader) readDataset(header uint64) (byte, []byte) {
+	r.t.Helper()
+	msgs := r.readObjectHeader(header)
+	for _, typ := range []uint16{msgDataspace, msgDatatype, msgFillValue, msgLayout, msgFilterPipeline} {
+		if _, ok := msgs[typ]; !ok {
+			r.t.Fatalf("expected message %d in the dataset at %d", typ, header)
+		}
+	}
+
+	dataspace := msgs[msgDataspace]
+	if dataspace[0] != 1 || dataspace[1] != 1 {
+		r.t.Fatalf("expected a version 1 1-D dataspace at %d", header)
+	}
+	n := binary.LittleEndian.Uint64(dataspace[8:])
+
+	layout := msgs[msgLayout]
+	if layout[0] != 3 || layout[1] != 2 || layout[2] != 2 {
+		r.t.Fatalf("expected a version 3 chunked layout at %d", header)
+	}
+	btree := binary.LittleEndian.Uint64(layout[3:])
+	chunkLen := uint64(binary.LittleEndian.Uint32(layout[11:]))
+
+	var data []byte
+	if btree != undefinedAddress {
+		r.walkBTree(btree, btreeChunk, 24, func(chunk uint64) {
+			zr, err := zlib.NewReader(bytes.NewReader(r.b[chunk:]))
+			if err != nil {
+				r.t.Fatal(err)
+			}
+
+			raw, err := ioutil.ReadAll(zr)
+			if err != nil {
+				r.t.Fatal(err)
+			}
+
+			if uint64(len(raw)) != chunkLen*elemSize {
+				r.t.Fatalf("expected chunks of %d bytes, but got %d", chunkLen*elemSize, len(raw))
+			}
+			data = append(data, raw...)
+		})
+	}
+
+	if uint64(len(data)) < n*elemSize {
+		r.t.Fatalf("expected %d elements, but the chunks have %d bytes", n, len(data))
+	}
+	return msgs[msgDatatype][0] & 0xf, data[:n*elemSize]
+}
+
+func readFile(t *testing.T, b []byte) *reader {
+	r := &reader{t: t, b: b}
+	sb := r.expect(0, signature)
+	if sb[0] != 0 || sb[5] != 8 || sb[6] != 8 {
+		t.Fatal("expected a version 0 superblock with 8-byte offsets and lengths")
+	}
+
+	if eof := binary.LittleEndian.Uint64(sb[32:]); eof != uint64(len(b)) {
+		t.Fatalf("expected the end of file address to be %d, but got %d", len(b), eof)
+	}
+	return r
+}
+
+// rootHeader returns the object header address of the root group from the superblock.
+func (r *reader) rootHeader() uint64 {
+	return binary.LittleEndian.Uint64(r.b[superblockSize-symbolEntrySize+8:])
+}
+
+func float64s(data []byte) []float64 {
+	values := make([]float64, len(data)/elemSize)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[elemSize*i:]))
+	}
+	return values
+}
+
+func int64s(data []byte) []int64 {
+	values := make([]int64, len(data)/elemSize)
+	for i := range values {
+		values[i] = int64(binary.LittleEndian.Uint64(data[elemSize*i:]))
+	}
+	return values
+}
+
+func TestFile(t *testing.T) {
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = float64(i) / 2
+	}
+
+	// enough datasets and chunks for B-trees with more than a single level
+	f := NewFile(WithChunkSize(7))
+	names := make([]string, 300)
+	for i := range names {
+		names[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+		err := f.AddFloat64s("/many/"+names[i], values[:i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := f.AddFloat64s("/imu/data/angular_velocity.x", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.AddInt64s("imu/data/time", []int64{-1, 1 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/imu/data/time", "/imu/data/time/x", "/"} {
+		if f.AddInt64s(path, nil) == nil {
+			t.Fatalf("expected adding %s to fail", path)
+		}
+	}
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := readFile(t, buf.Bytes())
+	root := r.readLinks(r.rootHeader())
+	if diff := cmp.Diff([]string{"imu", "many"}, sortedKeys(root)); diff != "" {
+		t.Fatalf("root links are not matched:\n\n%s", diff)
+	}
+
+	data := r.readLinks(r.readLinks(root["imu"])["data"])
+	typ, raw := r.readDataset(data["angular_velocity.x"])
+	if typ != 1 {
+		t.Fatalf("expected a floating point dataset, but got class %d", typ)
+	}
+	if diff := cmp.Diff(values, float64s(raw)); diff != "" {
+		t.Fatalf("values are not matched:\n\n%s", diff)
+	}
+
+	typ, raw = r.readDataset(data["time"])
+	if typ != 0 {
+		t.Fatalf("expected a fixed point dataset, but got class %d", typ)
+	}
+	if diff := cmp.Diff([]int64{-1, 1 << 40}, int64s(raw)); diff != "" {
+		t.Fatalf("times are not matched:\n\n%s", diff)
+	}
+
+	many := r.readLinks(root["many"])
+	if len(many) != len(names) {
+		t.Fatalf("expected %d datasets, but got %d", len(names), len(many))
+	}
+	for i, name := range names {
+		_, raw := r.readDataset(many[name])
+		if diff := cmp.Diff(values[:i], float64s(raw), cmpEmpty); diff != "" {
+			t.Fatalf("values of %s are not matched:\n\n%s", name, diff)
+		}
+	}
+}
+
+var cmpEmpty = cmp.Comparer(func(a, b []float64) bool {
+	return len(a) == 0 && len(b) == 0 || cmp.Equal(a, b)
+})
+
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestFileOptions(t *testing.T) {
+	for _, opt := range []Option{WithChunkSize(0), WithCompressionLevel(0), WithCompressionLevel(10)} {
+		_, err := NewFile(opt).WriteTo(ioutil.Discard)
+		if err == nil {
+			t.Fatal("expected an invalid option to fail")
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	var buf bytes.Buffer
+	writer := rosbag.NewWriter(&buf)
+	conn, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+		Topic:                "/point",
+		Type:                 "test_msgs/Point",
+		RawMessageDefinition: "float64 x\nint32 y\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, x := range []float64{0.5, 1.5} {
+		raw := make([]byte, 12)
+		binary.LittleEndian.PutUint64(raw, math.Float64bits(x))
+		binary.LittleEndian.PutUint32(raw[8:], uint32(i+1))
+		err = writer.WriteMessage(conn, time.Unix(1, int64(i)), raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = Export(&out, rosbag.NewDecoder(bytes.NewReader(buf.Bytes())), []Field{
+		{Topic: "/point", Path: rosbag.MustCompileFieldPath("x")},
+		{Topic: "/point", Path: rosbag.MustCompileFieldPath("y")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := readFile(t, out.Bytes())
+	point := r.readLinks(r.readLinks(r.rootHeader())["point"])
+	if diff := cmp.Diff([]string{"time", "x", "y"}, sortedKeys(point)); diff != "" {
+		t.Fatalf("datasets are not matched:\n\n%s", diff)
+	}
+
+	_, raw := r.readDataset(point["time"])
+	if diff := cmp.Diff([]int64{1e9, 1e9 + 1}, int64s(raw)); diff != "" {
+		t.Fatalf("times are not matched:\n\n%s", diff)
+	}
+
+	_, raw = r.readDataset(point["y"])
+	if diff := cmp.Diff([]float64{1, 2}, float64s(raw)); diff != "" {
+		t.Fatalf("values are not matched:\n\n%s", diff)
+	}
+
+	err = Export(&out, rosbag.NewDecoder(bytes.NewReader(buf.Bytes())), nil)
+	if err == nil {
+		t.Fatal("expected an export without fields to fail")
+	}
+}
@@ -0,0 +1,292 @@
+// Package imu resamples sensor_msgs/Imu messages to a fixed rate, and integrates the angular
+// velocity to orientations, so that the data is aligned for bias and vibration analysis.
+package imu
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// MessageType is the ROS type of the messages that are consumed by TimeSeries.
+const MessageType = "sensor_msgs/Imu"
+
+var (
+	errInvalidRate   = errors.New("rate must be positive")
+	errNotEnoughData = errors.New("at least 2 samples are required to resample")
+)
+
+type vector3 struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+}
+
+type imuMessage struct {
+	Header struct {
+		Stamp time.Time `rosbag:"stamp"`
+	} `rosbag:"header"`
+	Orientation           Quaternion `rosbag:"orientation"`
+	OrientationCovariance []float64  `rosbag:"orientation_covariance"`
+	AngularVelocity       vector3    `rosbag:"angular_velocity"`
+	LinearAcceleration    vector3    `rosbag:"linear_acceleration"`
+}
+
+// Quaternion is a rotation, like geometry_msgs/Quaternion.
+type Quaternion struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+	W float64 `rosbag:"w"`
+}
+
+// Identity is the quaternion of no rotation.
+var Identity = Quaternion{W: 1}
+
+// Mul returns the rotation q followed by the rotation r in the frame of q, i.e. q * r.
+func (q Quaternion) Mul(r Quaternion) Quaternion {
+	return Quaternion{
+		W: q.W*r.W - q.X*r.X - q.Y*r.Y - q.Z*r.Z,
+		X: q.W*r.X + q.X*r.W + q.Y*r.Z - q.Z*r.Y,
+		Y: q.W*r.Y - q.X*r.Z + q.Y*r.W + q.Z*r.X,
+		Z: q.W*r.Z + q.X*r.Y - q.Y*r.X + q.Z*r.W,
+	}
+}
+
+// normalize keeps q a unit quaternion, which drifts with floating point errors over many
+// multiplications.
+func (q Quaternion) normalize() Quaternion {
+	norm := math.Sqrt(q.W*q.W + q.X*q.X + q.Y*q.Y + q.Z*q.Z)
+	return Quaternion{W: q.W / norm, X: q.X / norm, Y: q.Y / norm, Z: q.Z / norm}
+}
+
+// rotation returns the quaternion of rotating by the rotation vector (x, y, z), whose direction
+// is the axis, and whose length is the angle in radians.
+func rotation(x, y, z float64) Quaternion {
+	angle := math.Sqrt(x*x + y*y + z*z)
+	if angle == 0 {
+		return Identity
+	}
+
+	s := math.Sin(angle/2) / angle
+	return Quaternion{W: math.Cos(angle / 2), X: x * s, Y: y * s, Z: z * s}
+}
+
+// Axes contains the samples of a 3D vector as a slice per axis.
+type Axes struct {
+	X []float64
+	Y []float64
+	Z []float64
+}
+
+func (axes *Axes) append(x, y, z float64) {
+	axes.X = append(axes.X, x)
+	axes.Y = append(axes.Y, y)
+	axes.Z = append(axes.Z, z)
+}
+
+// TimeSeries collects Imu messages at the rate they were recorded.
+type TimeSeries struct {
+	// Time is the time of every sample. It's the header stamp of the message, or the record time
+	// when the stamp is not set.
+	Time []time.Time
+	// AngularVelocity is in rad/s.
+	AngularVelocity Axes
+	// LinearAcceleration is in m/s^2.
+	LinearAcceleration Axes
+	// Orientation is the orientation that is estimated by the IMU. It's nil when the messages
+	// don't have one, i.e. the first element of orientation_covariance is -1.
+	Orientation []Quaternion
+}
+
+// NewTimeSeries creates an empty TimeSeries.
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{}
+}
+
+// Read subscribes to topic, runs decoder to the end, and returns the time series of the topic.
+func Read(decoder *rosbag.Decoder, topic string) (*TimeSeries, error) {
+	ts := NewTimeSeries()
+	err := decoder.Subscribe(topic, ts.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// Add appends the Imu message in record as a new sample. Add has the signature of
+// rosbag.MessageHandler, so it can be passed to Decoder.Subscribe directly.
+func (ts *TimeSeries) Add(record *rosbag.RecordMessageData) error {
+	var msg imuMessage
+	err := record.ViewAs(&msg)
+	if err != nil {
+		return err
+	}
+
+	t := msg.Header.Stamp
+	if t.IsZero() || t.Equal(time.Unix(0, 0)) {
+		t, err = record.Time()
+		if err != nil {
+			return err
+		}
+	}
+
+	hasOrientation := len(msg.OrientationCovariance) == 0 || msg.OrientationCovariance[0] != -1
+	ts.add(t, msg.AngularVelocity, msg.LinearAcceleration, msg.Orientation, hasOrientation)
+	return nil
+}
+
+func (ts *TimeSeries) add(t time.Time, angularVelocity, linearAcceleration vector3, orientation Quaternion, hasOrientation bool) {
+	n := len(ts.Time)
+	ts.Time = append(ts.Time, t)
+	ts.AngularVelocity.append(angularVelocity.X, angularVelocity.Y, angularVelocity.Z)
+	ts.LinearAcceleration.append(linearAcceleration.X, linearAcceleration.Y, linearAcceleration.Z)
+
+	// the orientation is only kept while every message has one, so it stays aligned with Time
+	if hasOrientation && len(ts.Orientation) == n {
+		ts.Orientation = append(ts.Orientation, orientation)
+	} else {
+		ts.Orientation = nil
+	}
+}
+
+// Len returns the number of samples.
+func (ts *TimeSeries) Len() int {
+	return len(ts.Time)
+}
+
+// Window returns the samples in [start, end). The returned time series is sorted by time.
+func (ts *TimeSeries) Window(start, end time.Time) *TimeSeries {
+	window := NewTimeSeries()
+	for _, i := range ts.order() {
+		t := ts.Time[i]
+		if t.Before(start) || !t.Before(end) {
+			continue
+		}
+
+		window.Time = append(window.Time, t)
+		window.AngularVelocity.append(ts.AngularVelocity.X[i], ts.AngularVelocity.Y[i], ts.AngularVelocity.Z[i])
+		window.LinearAcceleration.append(ts.LinearAcceleration.X[i], ts.LinearAcceleration.Y[i], ts.LinearAcceleration.Z[i])
+		if ts.Orientation != nil {
+			window.Orientation = append(window.Orientation, ts.Orientation[i])
+		}
+	}
+	return window
+}
+
+// order returns the indices of the samples sorted by time. Messages are usually recorded in
+// order, but the header stamps of some drivers are not.
+func (ts *TimeSeries) order() []int {
+	indices := make([]int, len(ts.Time))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		return ts.Time[indices[i]].Before(ts.Time[indices[j]])
+	})
+	return indices
+}
+
+// Resampled contains samples at a fixed rate.
+type Resampled struct {
+	// Start is the time of the first sample.
+	Start time.Time
+	// Period is the time between the samples.
+	Period time.Duration
+	// Time is the time of every sample, i.e. Start + i*Period.
+	Time               []time.Time
+	AngularVelocity    Axes
+	LinearAcceleration Axes
+}
+
+// Resample linearly interpolates the angular velocity and the linear acceleration at rate Hz,
+// from the first sample to the last one.
+func (ts *TimeSeries) Resample(rate float64) (*Resampled, error) {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil, errInvalidRate
+	}
+
+	if ts.Len() < 2 {
+		return nil, errNotEnoughData
+	}
+
+	order := ts.order()
+	start := ts.Time[order[0]]
+	end := ts.Time[order[len(order)-1]]
+	period := time.Duration(float64(time.Second) / rate)
+	if period <= 0 {
+		return nil, errInvalidRate
+	}
+
+	resampled := Resampled{
+		Start:  start,
+		Period: period,
+	}
+
+	// j is the index in order of the sample that is at or after t
+	j := 0
+	for t := start; !t.After(end); t = t.Add(period) {
+		for ts.Time[order[j]].Before(t) {
+			j++
+		}
+
+		b := order[j]
+		a := b
+		var alpha float64
+		if j > 0 && ts.Time[b].After(t) {
+			a = order[j-1]
+			alpha = float64(t.Sub(ts.Time[a])) / float64(ts.Time[b].Sub(ts.Time[a]))
+		}
+
+		resampled.Time = append(resampled.Time, t)
+		resampled.AngularVelocity.append(
+			lerp(ts.AngularVelocity.X[a], ts.AngularVelocity.X[b], alpha),
+			lerp(ts.AngularVelocity.Y[a], ts.AngularVelocity.Y[b], alpha),
+			lerp(ts.AngularVelocity.Z[a], ts.AngularVelocity.Z[b], alpha),
+		)
+		resampled.LinearAcceleration.append(
+			lerp(ts.LinearAcceleration.X[a], ts.LinearAcceleration.X[b], alpha),
+			lerp(ts.LinearAcceleration.Y[a], ts.LinearAcceleration.Y[b], alpha),
+			lerp(ts.LinearAcceleration.Z[a], ts.LinearAcceleration.Z[b], alpha),
+		)
+	}
+
+	return &resampled, nil
+}
+
+func lerp(a, b, alpha float64) float64 {
+	return a + (b-a)*alpha
+}
+
+// Integrate integrates the angular velocity to the orientation at every sample, starting from
+// initial at the first sample. The angular velocity is in the body frame, and it's averaged
+// between consecutive samples. The drift of the result shows the gyro bias.
+func (resampled *Resampled) Integrate(initial Quaternion) []Quaternion {
+	if len(resampled.Time) == 0 {
+		return nil
+	}
+
+	w := &resampled.AngularVelocity
+	dt := resampled.Period.Seconds()
+	orientations := make([]Quaternion, len(resampled.Time))
+	orientations[0] = initial.normalize()
+	for i := 1; i < len(orientations); i++ {
+		q := rotation(
+			(w.X[i-1]+w.X[i])/2*dt,
+			(w.Y[i-1]+w.Y[i])/2*dt,
+			(w.Z[i-1]+w.Z[i])/2*dt,
+		)
+		orientations[i] = orientations[i-1].Mul(q).normalize()
+	}
+	return orientations
+}
@@ -0,0 +1,94 @@
+package imu
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestResample(t *testing.T) {
+	t0 := time.Unix(10, 0)
+	ts := NewTimeSeries()
+	// the samples are out of order, and at an irregular rate
+	ts.add(t0, vector3{X: 0}, vector3{Z: 9.8}, Identity, true)
+	ts.add(t0.Add(300*time.Millisecond), vector3{X: 3}, vector3{Z: 9.5}, Identity, true)
+	ts.add(t0.Add(100*time.Millisecond), vector3{X: 1}, vector3{Z: 9.9}, Identity, false)
+
+	resampled, err := ts.Resample(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resampled.Period != 50*time.Millisecond || !resampled.Start.Equal(t0) {
+		t.Fatalf("expected to start at %v every 50ms, but got %v every %v", t0, resampled.Start, resampled.Period)
+	}
+
+	expected := Axes{
+		X: []float64{0, 0.5, 1, 1.5, 2, 2.5, 3},
+		Y: make([]float64, 7),
+		Z: make([]float64, 7),
+	}
+	if diff := cmp.Diff(expected, resampled.AngularVelocity, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Fatalf("AngularVelocity is not matched:\n\n%s", diff)
+	}
+
+	expectedZ := []float64{9.8, 9.85, 9.9, 9.8, 9.7, 9.6, 9.5}
+	if diff := cmp.Diff(expectedZ, resampled.LinearAcceleration.Z, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Fatalf("LinearAcceleration is not matched:\n\n%s", diff)
+	}
+
+	if ts.Orientation != nil {
+		t.Fatal("expected the orientation to be dropped when a message doesn't have one")
+	}
+
+	if _, err = ts.Resample(0); err != errInvalidRate {
+		t.Fatalf("expected %v, but got %v", errInvalidRate, err)
+	}
+
+	if _, err = NewTimeSeries().Resample(10); err != errNotEnoughData {
+		t.Fatalf("expected %v, but got %v", errNotEnoughData, err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	t0 := time.Unix(10, 0)
+	ts := NewTimeSeries()
+	for i := 0; i < 5; i++ {
+		ts.add(t0.Add(time.Duration(i)*time.Second), vector3{X: float64(i)}, vector3{}, Identity, true)
+	}
+
+	window := ts.Window(t0.Add(time.Second), t0.Add(3*time.Second))
+	if diff := cmp.Diff([]float64{1, 2}, window.AngularVelocity.X); diff != "" {
+		t.Fatalf("AngularVelocity is not matched:\n\n%s", diff)
+	}
+
+	if len(window.Orientation) != 2 {
+		t.Fatalf("expected 2 orientations, but got %d", len(window.Orientation))
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	t0 := time.Unix(10, 0)
+	ts := NewTimeSeries()
+	// rotate around z at pi/2 rad/s for a second
+	ts.add(t0, vector3{Z: math.Pi / 2}, vector3{}, Identity, true)
+	ts.add(t0.Add(time.Second), vector3{Z: math.Pi / 2}, vector3{}, Identity, true)
+
+	resampled, err := ts.Resample(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orientations := resampled.Integrate(Identity)
+	if len(orientations) != 101 {
+		t.Fatalf("expected 101 orientations, but got %d", len(orientations))
+	}
+
+	expected := Quaternion{W: math.Cos(math.Pi / 4), Z: math.Sin(math.Pi / 4)}
+	if diff := cmp.Diff(expected, orientations[100], cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Fatalf("orientation is not matched:\n\n%s", diff)
+	}
+}
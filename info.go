@@ -0,0 +1,218 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// TypeInfo is a message type that is used in a bag.
+type TypeInfo struct {
+	Type string `json:"type"`
+	MD5  string `json:"md5"`
+}
+
+// TopicInfo summarizes the messages of a topic.
+type TopicInfo struct {
+	Topic    string `json:"topic"`
+	Type     string `json:"type"`
+	Messages uint64 `json:"messages"`
+	// Connections is the number of connections on the topic. It's omitted when there's only one
+	// connection, like `rosbag info` does.
+	Connections int `json:"connections,omitempty"`
+}
+
+// BagInfo is a summary of a bag, like the output of `rosbag info`. The JSON field names, and the
+// output of WriteYAML, match `rosbag info --yaml`, so scripts that parse it can use BagInfo as is.
+type BagInfo struct {
+	// Path is the path of the bag. It's not known by the decoder, so it has to be set by the caller.
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version"`
+	// Duration, Start, and End are in seconds
+	Duration float64 `json:"duration"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	// Size is the size of the bag in bytes
+	Size     int64  `json:"size"`
+	Messages uint64 `json:"messages"`
+	// Indexed is true when the bag header points to an index section
+	Indexed bool `json:"indexed"`
+	// Compression is the compression that's used by most of the chunks
+	Compression string `json:"compression"`
+	// Uncompressed and Compressed are the total sizes of the chunk data. They're only set when the
+	// chunks are compressed.
+	Uncompressed int64       `json:"uncompressed,omitempty"`
+	Compressed   int64       `json:"compressed,omitempty"`
+	Types        []TypeInfo  `json:"types"`
+	Topics       []TopicInfo `json:"topics"`
+}
+
+// ReadInfo reads the rest of the bag from decoder, and summarizes it. The decoder must not have
+// been read before.
+func ReadInfo(decoder *Decoder) (*BagInfo, error) {
+	var info BagInfo
+	var start, end time.Time
+	compressions := make(map[Compression]int)
+	types := make(map[string]string)
+	topics := make(map[string]*TopicInfo)
+	topicConns := make(map[string]map[*ConnectionHeader]bool)
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch record := record.(type) {
+		case *RecordBagHeader:
+			var indexPos uint64
+			indexPos, err = record.IndexPos()
+			info.Indexed = indexPos != 0
+		case *RecordChunk:
+			var compression Compression
+			var size uint32
+			compression, err = record.Compression()
+			if err == nil {
+				compressions[compression]++
+				size, err = record.Size()
+				info.Uncompressed += int64(size)
+				info.Compressed += int64(record.DataLen)
+			}
+		case *RecordMessageData:
+			var t time.Time
+			t, err = record.Time()
+			if start.IsZero() || t.Before(start) {
+				start = t
+			}
+			if t.After(end) {
+				end = t
+			}
+
+			hdr := record.ConnectionHeader()
+			types[hdr.Type] = hdr.MD5Sum
+
+			topic, ok := topics[hdr.Topic]
+			if !ok {
+				topic = &TopicInfo{Topic: hdr.Topic, Type: hdr.Type}
+				topics[hdr.Topic] = topic
+				topicConns[hdr.Topic] = make(map[*ConnectionHeader]bool)
+			}
+			topic.Messages++
+			topicConns[hdr.Topic][hdr] = true
+			info.Messages++
+		}
+		record.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if version := decoder.Version(); version != nil {
+		info.Version = version.String()
+	}
+	info.Size = decoder.offset
+
+	if !start.IsZero() {
+		info.Start = toSeconds(start)
+		info.End = toSeconds(end)
+		info.Duration = end.Sub(start).Seconds()
+	}
+
+	info.Compression = string(CompressionNone)
+	var most int
+	for compression, count := range compressions {
+		if count > most || (count == most && string(compression) < info.Compression) {
+			info.Compression = string(compression)
+			most = count
+		}
+	}
+
+	if info.Compression == string(CompressionNone) {
+		info.Uncompressed = 0
+		info.Compressed = 0
+	}
+
+	for typ, md5 := range types {
+		info.Types = append(info.Types, TypeInfo{Type: typ, MD5: md5})
+	}
+	sort.Slice(info.Types, func(i, j int) bool {
+		return info.Types[i].Type < info.Types[j].Type
+	})
+
+	for name, topic := range topics {
+		// the connection headers of the same connection are shared, except when a connection
+		// record is repeated in multiple chunks
+		if conns := len(topicConns[name]); conns > 1 {
+			topic.Connections = conns
+		}
+		info.Topics = append(info.Topics, *topic)
+	}
+	sort.Slice(info.Topics, func(i, j int) bool {
+		return info.Topics[i].Topic < info.Topics[j].Topic
+	})
+
+	return &info, nil
+}
+
+func toSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// WriteYAML writes info in the same format as `rosbag info --yaml`.
+func (info *BagInfo) WriteYAML(w io.Writer) error {
+	p := yamlPrinter{w: w}
+	p.printf("path: %s\n", info.Path)
+	p.printf("version: %s\n", info.Version)
+	p.printf("duration: %.6f\n", info.Duration)
+	p.printf("start: %.6f\n", info.Start)
+	p.printf("end: %.6f\n", info.End)
+	p.printf("size: %d\n", info.Size)
+	p.printf("messages: %d\n", info.Messages)
+	if info.Indexed {
+		p.printf("indexed: True\n")
+	} else {
+		p.printf("indexed: False\n")
+	}
+	p.printf("compression: %s\n", info.Compression)
+	if info.Compression != string(CompressionNone) {
+		p.printf("uncompressed: %d\n", info.Uncompressed)
+		p.printf("compressed: %d\n", info.Compressed)
+	}
+
+	p.printf("types:\n")
+	for _, typ := range info.Types {
+		p.printf("    - type: %s\n", typ.Type)
+		p.printf("      md5: %s\n", typ.MD5)
+	}
+
+	p.printf("topics:\n")
+	for _, topic := range info.Topics {
+		p.printf("    - topic: %s\n", topic.Topic)
+		p.printf("      type: %s\n", topic.Type)
+		p.printf("      messages: %d\n", topic.Messages)
+		if topic.Connections > 1 {
+			p.printf("      connections: %d\n", topic.Connections)
+		}
+	}
+
+	return p.err
+}
+
+// yamlPrinter keeps the first write error so that the caller only checks it once.
+type yamlPrinter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *yamlPrinter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
@@ -0,0 +1,118 @@
+package rosbag
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadInfo(t *testing.T) {
+	raw := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	info, err := ReadInfo(NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Path = "test.bag"
+
+	expected := BagInfo{
+		Path:        "test.bag",
+		Version:     "2.0",
+		Duration:    2,
+		Start:       1,
+		End:         3,
+		Size:        int64(len(raw)),
+		Messages:    3,
+		Compression: "none",
+		Types: []TypeInfo{
+			{Type: "test_msgs/Test", MD5: "b7b8b5ba5a046619082c001d6588d6d8"},
+		},
+		Topics: []TopicInfo{
+			{Topic: "/test", Type: "test_msgs/Test", Messages: 3},
+		},
+	}
+
+	if diff := cmp.Diff(&expected, info); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var yaml bytes.Buffer
+	err = info.WriteYAML(&yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedYAML := `path: test.bag
+version: 2.0
+duration: 2.000000
+start: 1.000000
+end: 3.000000
+size: ` + jsonString(t, info.Size) + `
+messages: 3
+indexed: False
+compression: none
+types:
+    - type: test_msgs/Test
+      md5: b7b8b5ba5a046619082c001d6588d6d8
+topics:
+    - topic: /test
+      type: test_msgs/Test
+      messages: 3
+`
+	if diff := cmp.Diff(expectedYAML, yaml.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal([]byte(jsonString(t, info)), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"path", "version", "duration", "start", "end", "size", "messages", "indexed", "compression", "types", "topics"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("expected %s in the JSON output", name)
+		}
+	}
+}
+
+func TestReadInfoExampleBag(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadInfo(NewDecoder(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size != stat.Size() {
+		t.Fatalf("expected the size to be %d, but got %d", stat.Size(), info.Size)
+	}
+
+	if !info.Indexed {
+		t.Fatal("expected the example bag to be indexed")
+	}
+
+	var messages uint64
+	for _, topic := range info.Topics {
+		messages += topic.Messages
+	}
+
+	if messages != info.Messages || messages == 0 {
+		t.Fatalf("expected the topics to have %d messages, but got %d", info.Messages, messages)
+	}
+}
+
+func jsonString(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
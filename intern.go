@@ -0,0 +1,82 @@
+package rosbag
+
+import (
+	"sync"
+)
+
+// Interner makes the decoded strings of some message fields share their memory across messages.
+// Message streams repeat the same values, e.g. frame_id or encoding, so every distinct value is
+// copied once, and the following messages get the same copy. Interned strings don't share the
+// memory of the record, so they can be used after the record is closed, like the copy option of
+// the rosbag struct tag.
+//
+// An Interner keeps every distinct value until it's garbage collected, so it should only be used
+// for fields with a small set of values. An Interner is safe for concurrent use, and can be shared
+// by multiple decoders.
+type Interner struct {
+	fields map[string]bool
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// NewInterner creates an interner for the string and string array fields named fields, e.g.
+// "frame_id". The names are matched at every depth, so "frame_id" matches header.frame_id of
+// every message. When fields is empty, every string field is interned.
+func NewInterner(fields ...string) *Interner {
+	interner := Interner{
+		values: make(map[string]string),
+	}
+
+	if len(fields) > 0 {
+		interner.fields = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			interner.fields[field] = true
+		}
+	}
+	return &interner
+}
+
+// Intern returns the interned copy of s. The first call with a value stores a copy of s.
+func (interner *Interner) Intern(s string) string {
+	interner.mu.Lock()
+	defer interner.mu.Unlock()
+
+	if v, ok := interner.values[s]; ok {
+		return v
+	}
+
+	// s can be a view of the record, so the stored value must be a copy
+	v := string(append([]byte(nil), s...))
+	interner.values[v] = v
+	return v
+}
+
+// Len returns the number of distinct values that have been interned.
+func (interner *Interner) Len() int {
+	interner.mu.Lock()
+	defer interner.mu.Unlock()
+	return len(interner.values)
+}
+
+// interns returns true when the values of field are interned.
+func (interner *Interner) interns(field *MessageFieldDefinition) bool {
+	if interner == nil || (field.Type != MessageFieldTypeString && field.Type != MessageFieldTypeWString) {
+		return false
+	}
+	return interner.fields == nil || interner.fields[field.Name]
+}
+
+// internValue interns the decoded value of a string or a string array field.
+func (interner *Interner) internValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return interner.Intern(v)
+	case []string:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = interner.Intern(v[i])
+		}
+		return values
+	}
+	return v
+}
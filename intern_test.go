@@ -0,0 +1,120 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func encodeTestString(s string) []byte {
+	return append(uint32Bytes(uint32(len(s))), s...)
+}
+
+func TestWithInterner(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Frame",
+		RawMessageDefinition: "string frame_id\nstring[] tags\nstring note",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		data := encodeTestString("base_link")
+		data = append(data, uint32Bytes(2)...)
+		data = append(data, encodeTestString("a")...)
+		data = append(data, encodeTestString("b")...)
+		data = append(data, encodeTestString(string(rune('x'+i)))...)
+		err = writer.WriteMessage(conn, time.Unix(int64(i+1), 0), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type frame struct {
+		FrameID string   `rosbag:"frame_id"`
+		Tags    []string `rosbag:"tags"`
+	}
+
+	interner := NewInterner("frame_id", "tags")
+	decoder := NewDecoder(bytes.NewReader(buf.Bytes()), WithInterner(interner))
+	var frames []frame
+	var notes []string
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			var f frame
+			err = msg.ViewAs(&f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, f)
+
+			v := make(map[string]interface{})
+			err = msg.ViewAs(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notes = append(notes, v["note"].(string))
+		}
+
+		// the interned strings must outlive the record
+		record.Close()
+	}
+
+	expected := []frame{
+		{FrameID: "base_link", Tags: []string{"a", "b"}},
+		{FrameID: "base_link", Tags: []string{"a", "b"}},
+		{FrameID: "base_link", Tags: []string{"a", "b"}},
+	}
+	if diff := cmp.Diff(expected, frames); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if len(notes) != 3 {
+		t.Fatalf("expected 3 notes, but got %d", len(notes))
+	}
+
+	// note isn't interned
+	if n := interner.Len(); n != 3 {
+		t.Fatalf("expected 3 interned values, but got %d", n)
+	}
+}
+
+func TestInternerAllFields(t *testing.T) {
+	interner := NewInterner()
+	field := &MessageFieldDefinition{Type: MessageFieldTypeString, Name: "encoding"}
+	if !interner.interns(field) {
+		t.Fatal("expected every string field to be interned")
+	}
+
+	if interner.interns(&MessageFieldDefinition{Type: MessageFieldTypeUint8, Name: "data"}) {
+		t.Fatal("expected uint8 fields not to be interned")
+	}
+
+	raw := []byte("rgb8")
+	v := interner.Intern(string(raw))
+	raw[0] = 'b'
+	if v != "rgb8" || interner.Intern("rgb8") != v || interner.Len() != 1 {
+		t.Fatalf("expected rgb8 to be interned once, but got %q and %d values", v, interner.Len())
+	}
+}
@@ -0,0 +1,139 @@
+// Package jointstate builds per-joint time series from sensor_msgs/JointState messages.
+package jointstate
+
+import (
+	"math"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// MessageType is the ROS type of the messages that are consumed by TimeSeries.
+const MessageType = "sensor_msgs/JointState"
+
+type jointState struct {
+	Header struct {
+		Stamp time.Time `rosbag:"stamp"`
+	} `rosbag:"header"`
+	Name     []string  `rosbag:"name"`
+	Position []float64 `rosbag:"position"`
+	Velocity []float64 `rosbag:"velocity"`
+	Effort   []float64 `rosbag:"effort"`
+}
+
+// Joint contains the samples of a single joint. The samples are aligned with TimeSeries.Time,
+// and a sample is NaN when the joint, or the value, is missing from the message at that time.
+type Joint struct {
+	Position []float64
+	Velocity []float64
+	Effort   []float64
+}
+
+// TimeSeries collects JointState messages into aligned per-joint time series. Joints are matched
+// by name in every message, so the order of the names can change between messages, and joints
+// can appear or disappear in the middle of the bag.
+type TimeSeries struct {
+	// Time is the time of every sample. It's the header stamp of the message, or the record time
+	// when the stamp is not set.
+	Time []time.Time
+	// Joints maps joint names to their samples.
+	Joints map[string]*Joint
+}
+
+// NewTimeSeries creates an empty TimeSeries.
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{
+		Joints: make(map[string]*Joint),
+	}
+}
+
+// Read subscribes to topic, runs decoder to the end, and returns the time series of the topic.
+func Read(decoder *rosbag.Decoder, topic string) (*TimeSeries, error) {
+	ts := NewTimeSeries()
+	err := decoder.Subscribe(topic, ts.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// Add appends the JointState message in record as a new sample. Add has the signature of
+// rosbag.MessageHandler, so it can be passed to Decoder.Subscribe directly.
+func (ts *TimeSeries) Add(record *rosbag.RecordMessageData) error {
+	var msg jointState
+	err := record.ViewAs(&msg)
+	if err != nil {
+		return err
+	}
+
+	t := msg.Header.Stamp
+	if t.IsZero() || t.Equal(time.Unix(0, 0)) {
+		t, err = record.Time()
+		if err != nil {
+			return err
+		}
+	}
+
+	ts.add(t, msg.Name, msg.Position, msg.Velocity, msg.Effort)
+	return nil
+}
+
+func (ts *TimeSeries) add(t time.Time, names []string, position, velocity, effort []float64) {
+	ts.Time = append(ts.Time, t)
+	n := len(ts.Time)
+
+	for i, name := range names {
+		joint, ok := ts.Joints[name]
+		if !ok {
+			// the name is viewed from the record, so it has to be copied to outlive the record
+			name = string([]byte(name))
+			joint = &Joint{
+				Position: nans(n - 1),
+				Velocity: nans(n - 1),
+				Effort:   nans(n - 1),
+			}
+			ts.Joints[name] = joint
+		}
+
+		// a joint can only be listed once per message, ignore the duplicates
+		if len(joint.Position) == n {
+			continue
+		}
+
+		joint.Position = append(joint.Position, valueAt(position, i))
+		joint.Velocity = append(joint.Velocity, valueAt(velocity, i))
+		joint.Effort = append(joint.Effort, valueAt(effort, i))
+	}
+
+	// keep the joints that are missing from this message aligned
+	for _, joint := range ts.Joints {
+		if len(joint.Position) < n {
+			joint.Position = append(joint.Position, math.NaN())
+			joint.Velocity = append(joint.Velocity, math.NaN())
+			joint.Effort = append(joint.Effort, math.NaN())
+		}
+	}
+}
+
+// valueAt returns values[i], or NaN when values doesn't have it. JointState allows position,
+// velocity, and effort to be empty.
+func valueAt(values []float64, i int) float64 {
+	if i >= len(values) {
+		return math.NaN()
+	}
+	return values[i]
+}
+
+func nans(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.NaN()
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package jointstate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestTimeSeries(t *testing.T) {
+	nan := math.NaN()
+	t0 := time.Unix(1, 0)
+	t1 := time.Unix(2, 0)
+	t2 := time.Unix(3, 0)
+
+	ts := NewTimeSeries()
+	ts.add(t0, []string{"a", "b"}, []float64{1, 2}, []float64{10, 20}, nil)
+	// the order of the names changes, and c appears
+	ts.add(t1, []string{"c", "b", "a"}, []float64{6, 5, 4}, nil, nil)
+	// b disappears
+	ts.add(t2, []string{"a", "c"}, []float64{7, 8}, nil, []float64{0.5, 0.25})
+
+	expectedTime := []time.Time{t0, t1, t2}
+	if diff := cmp.Diff(expectedTime, ts.Time); diff != "" {
+		t.Fatalf("Time is not matched:\n\n%s", diff)
+	}
+
+	expected := map[string]*Joint{
+		"a": {
+			Position: []float64{1, 4, 7},
+			Velocity: []float64{10, nan, nan},
+			Effort:   []float64{nan, nan, 0.5},
+		},
+		"b": {
+			Position: []float64{2, 5, nan},
+			Velocity: []float64{20, nan, nan},
+			Effort:   []float64{nan, nan, nan},
+		},
+		"c": {
+			Position: []float64{nan, 6, 8},
+			Velocity: []float64{nan, nan, nan},
+			Effort:   []float64{nan, nan, 0.25},
+		},
+	}
+	if diff := cmp.Diff(expected, ts.Joints, cmpopts.EquateNaNs()); diff != "" {
+		t.Fatalf("Joints are not matched:\n\n%s", diff)
+	}
+}
@@ -0,0 +1,314 @@
+package rosbag
+
+import (
+	"encoding/base64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONEncoder writes messages to an io.Writer as JSON Lines, i.e. one JSON object per line. The
+// JSON is generated straight from the message data with the message definition, so unlike
+// encoding/json with RecordMessageData.ViewAs, no map, slice, or string is allocated for the
+// fields, which matters for the large arrays of camera and lidar messages.
+//
+// The values are written like encoding/json writes the values of ViewAs, i.e. uint8 arrays are
+// base64 strings, times are RFC 3339 strings, and durations are nanoseconds. The fields are in the
+// order of the message definition after the constants, empty arrays are [] instead of null, and
+// NaN and infinite floats are null. Registered types are written from the definition as well.
+type JSONEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewJSONEncoder creates an encoder that writes to w.
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{w: w}
+}
+
+// Encode writes the message of record followed by a newline. The message is written with a single
+// Write, and nothing is written when the message data doesn't match the message definition.
+func (encoder *JSONEncoder) Encode(record *RecordMessageData) error {
+	b, err := record.ConnectionHeader().MessageDefinition.AppendJSON(encoder.buf[:0], record.Data())
+	if err != nil {
+		return err
+	}
+
+	encoder.buf = append(b, '\n')
+	_, err = encoder.w.Write(encoder.buf)
+	return err
+}
+
+// AppendJSON appends the message in data to b as a JSON object, see JSONEncoder, and returns the
+// extended buffer.
+func (def *MessageDefinition) AppendJSON(b, data []byte) ([]byte, error) {
+	b, _, err := appendJSONMessage(b, def, data)
+	return b, err
+}
+
+func appendJSONMessage(b []byte, def *MessageDefinition, raw []byte) ([]byte, []byte, error) {
+	var err error
+	b = append(b, '{')
+	first := true
+	appendKey := func(name string) {
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = appendJSONString(b, name)
+		b = append(b, ':')
+	}
+
+	for _, constant := range def.Constants {
+		if constant.Value == nil {
+			continue
+		}
+
+		appendKey(constant.Name)
+		b = appendJSONValue(b, constant.Value)
+	}
+
+	for _, field := range def.Fields {
+		appendKey(field.Name)
+		switch {
+		case field.IsArray:
+			b, raw, err = appendJSONArray(b, field, raw)
+		case field.Type == MessageFieldTypeComplex:
+			b, raw, err = appendJSONMessage(b, field.MsgType, raw)
+		default:
+			b, raw, err = appendJSONField(b, field.Type, raw)
+		}
+
+		if err != nil {
+			return b, raw, err
+		}
+	}
+
+	return append(b, '}'), raw, nil
+}
+
+func appendJSONArray(b []byte, field *MessageFieldDefinition, raw []byte) ([]byte, []byte, error) {
+	length, off, ok := fieldDecodeLength(raw, field.ArraySize)
+	if !ok {
+		return b, raw, errInvalidFormat
+	}
+	raw = raw[off:]
+
+	if size, ok := fixedFieldSizes[field.Type]; ok && len(raw) < length*size {
+		return b, raw, errInvalidFormat
+	}
+
+	if field.Type == MessageFieldTypeUint8 {
+		return appendJSONBase64(b, raw[:length]), raw[length:], nil
+	}
+
+	var err error
+	b = append(b, '[')
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+
+		if field.Type == MessageFieldTypeComplex {
+			b, raw, err = appendJSONMessage(b, field.MsgType, raw)
+		} else {
+			b, raw, err = appendJSONField(b, field.Type, raw)
+		}
+
+		if err != nil {
+			return b, raw, err
+		}
+	}
+	return append(b, ']'), raw, nil
+}
+
+// appendJSONField appends the builtin value at the beginning of raw, and returns raw after it.
+func appendJSONField(b []byte, fieldType MessageFieldType, raw []byte) ([]byte, []byte, error) {
+	if size, ok := fixedFieldSizes[fieldType]; ok && len(raw) < size {
+		return b, raw, errInvalidFormat
+	}
+
+	switch fieldType {
+	case MessageFieldTypeBool:
+		return strconv.AppendBool(b, raw[0] != 0), raw[1:], nil
+	case MessageFieldTypeInt8:
+		return strconv.AppendInt(b, int64(int8(raw[0])), 10), raw[1:], nil
+	case MessageFieldTypeUint8:
+		return strconv.AppendUint(b, uint64(raw[0]), 10), raw[1:], nil
+	case MessageFieldTypeInt16:
+		return strconv.AppendInt(b, int64(int16(endian.Uint16(raw))), 10), raw[2:], nil
+	case MessageFieldTypeUint16:
+		return strconv.AppendUint(b, uint64(endian.Uint16(raw)), 10), raw[2:], nil
+	case MessageFieldTypeInt32:
+		return strconv.AppendInt(b, int64(int32(endian.Uint32(raw))), 10), raw[4:], nil
+	case MessageFieldTypeUint32:
+		return strconv.AppendUint(b, uint64(endian.Uint32(raw)), 10), raw[4:], nil
+	case MessageFieldTypeInt64:
+		return strconv.AppendInt(b, int64(endian.Uint64(raw)), 10), raw[8:], nil
+	case MessageFieldTypeUint64:
+		return strconv.AppendUint(b, endian.Uint64(raw), 10), raw[8:], nil
+	case MessageFieldTypeFloat32:
+		return appendJSONFloat(b, float64(math.Float32frombits(endian.Uint32(raw))), 32), raw[4:], nil
+	case MessageFieldTypeFloat64:
+		return appendJSONFloat(b, math.Float64frombits(endian.Uint64(raw)), 64), raw[8:], nil
+	case MessageFieldTypeTime:
+		return appendJSONTime(b, extractTime(raw)), raw[8:], nil
+	case MessageFieldTypeDuration:
+		return strconv.AppendInt(b, int64(extractDuration(raw)), 10), raw[8:], nil
+	case MessageFieldTypeString:
+		v, off, ok := fieldDecodeString(raw, -1)
+		if !ok {
+			return b, raw, errInvalidFormat
+		}
+		return appendJSONString(b, v.(string)), raw[off:], nil
+	case MessageFieldTypeWString:
+		v, off, ok := fieldDecodeWString(raw, -1)
+		if !ok {
+			return b, raw, errInvalidFormat
+		}
+		return appendJSONString(b, v.(string)), raw[off:], nil
+	default:
+		return b, raw, errInvalidFormat
+	}
+}
+
+// appendJSONValue appends the decoded value of a constant.
+func appendJSONValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case bool:
+		return strconv.AppendBool(b, v)
+	case int8:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int16:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case uint8:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint16:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(b, v, 10)
+	case float32:
+		return appendJSONFloat(b, float64(v), 32)
+	case float64:
+		return appendJSONFloat(b, v, 64)
+	case string:
+		return appendJSONString(b, v)
+	case time.Time:
+		return appendJSONTime(b, v)
+	case time.Duration:
+		return strconv.AppendInt(b, int64(v), 10)
+	default:
+		return append(b, "null"...)
+	}
+}
+
+// appendJSONFloat formats f like encoding/json, and writes NaN and infinities as null since JSON
+// can't represent them.
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(b, "null"...)
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// e-09 is shortened to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+func appendJSONTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+func appendJSONBase64(b []byte, data []byte) []byte {
+	n := base64.StdEncoding.EncodedLen(len(data))
+	b = append(b, '"')
+	if cap(b)-len(b) < n+1 {
+		grown := make([]byte, len(b), 2*cap(b)+n+1)
+		copy(grown, b)
+		b = grown
+	}
+
+	start := len(b)
+	b = b[:start+n]
+	base64.StdEncoding.Encode(b[start:], data)
+	return append(b, '"')
+}
+
+const jsonHex = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string with the escaping of encoding/json, including the
+// HTML characters.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', jsonHex[c>>4], jsonHex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+
+		// U+2028 and U+2029 are line terminators in JavaScript
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', jsonHex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,230 @@
+package rosbag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const jsonTestDefinition = `uint8 MODE=2
+bool ok
+int8 offset
+uint8[] data
+int16[2] fixed
+float32 ratio
+float64[] values
+string name
+time stamp
+duration elapsed
+Point[] points
+Point center
+================================================================================
+MSG: test_msgs/Point
+float64 x
+float64 y
+`
+
+type jsonTestMessage struct {
+	values []float64
+	name   string
+	points [][2]float64
+}
+
+func encodeJSONTestMessage(msg jsonTestMessage) []byte {
+	var raw []byte
+	appendUint32 := func(v uint32) {
+		b := make([]byte, 4)
+		endian.PutUint32(b, v)
+		raw = append(raw, b...)
+	}
+	appendFloat64 := func(v float64) {
+		b := make([]byte, 8)
+		endian.PutUint64(b, math.Float64bits(v))
+		raw = append(raw, b...)
+	}
+
+	raw = append(raw, 1, 0xfe)
+	appendUint32(3)
+	raw = append(raw, 1, 2, 3)
+	fixed := make([]byte, 4)
+	endian.PutUint16(fixed, uint16(0xffff))
+	endian.PutUint16(fixed[2:], 7)
+	raw = append(raw, fixed...)
+	appendUint32(math.Float32bits(0.5))
+	appendUint32(uint32(len(msg.values)))
+	for _, v := range msg.values {
+		appendFloat64(v)
+	}
+	appendUint32(uint32(len(msg.name)))
+	raw = append(raw, msg.name...)
+	raw = append(raw, timeField(time.Unix(3, 500))...)
+	raw = append(raw, timeField(time.Unix(1, 5))...)
+	appendUint32(uint32(len(msg.points)))
+	for _, point := range msg.points {
+		appendFloat64(point[0])
+		appendFloat64(point[1])
+	}
+	appendFloat64(0.25)
+	appendFloat64(-1e21)
+	return raw
+}
+
+func TestMessageDefinitionAppendJSON(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(jsonTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := encodeJSONTestMessage(jsonTestMessage{
+		values: []float64{1.5, math.NaN(), 1e-7},
+		name:   "a<b\"\n\u2028",
+	})
+	actual, err := def.AppendJSON([]byte("prefix "), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`prefix {"MODE":2,"ok":true,"offset":-2,"data":"AQID","fixed":[-1,7],"ratio":0.5,"values":[1.5,null,1e-7],"name":"a\u003cb\"\n\u2028","stamp":"%s","elapsed":1000000005,"points":[],"center":{"x":0.25,"y":-1e+21}}`, time.Unix(3, 500).Format(time.RFC3339Nano))
+	if diff := cmp.Diff(expected, string(actual)); diff != "" {
+		t.Fatalf("JSON is not matched:\n\n%s", diff)
+	}
+
+	_, err = def.AppendJSON(nil, raw[:len(raw)-1])
+	if err != errInvalidFormat {
+		t.Fatalf("expected truncated data to be invalid, but got %v", err)
+	}
+}
+
+func TestMessageDefinitionAppendJSONMatchesViewAs(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(jsonTestDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := encodeJSONTestMessage(jsonTestMessage{
+		values: []float64{-3, 1e22, 123456.789},
+		name:   "frame & <id>",
+		points: [][2]float64{{1, 2}, {3.5, -4}},
+	})
+
+	data := make(map[string]interface{})
+	_, err = decodeMessageData(&def, raw, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewed, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamed, err := def.AppendJSON(nil, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected, actual interface{}
+	err = json.Unmarshal(viewed, &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(streamed, &actual)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, streamed)
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("JSON doesn't match the viewed message:\n\n%s", diff)
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	var buf bytes.Buffer
+	encoder := NewJSONEncoder(&buf)
+	decoder := NewDecoder(f)
+	var messages int
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record, ok := record.(*RecordMessageData); ok {
+			err = encoder.Encode(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages++
+		}
+		record.Close()
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != messages {
+		t.Fatalf("expected %d lines, but got %d", messages, len(lines))
+	}
+
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("expected every line to be valid JSON, but got %s", line)
+		}
+	}
+}
+
+func BenchmarkJSONEncoder(b *testing.B) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(jsonTestDefinition))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = float64(i) / 3
+	}
+	raw := encodeJSONTestMessage(jsonTestMessage{values: values, name: "lidar"})
+
+	b.Run("AppendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, err = def.AppendJSON(buf[:0], raw)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ViewAs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data := make(map[string]interface{})
+			_, err = decodeMessageData(&def, raw, data)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			_, err = json.Marshal(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package rosbag
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LazyMessage is a serialized message that resolves field paths on demand, e.g.
+// msg.Get("pose.position.x"), by walking the message definition and skipping the data of the
+// fields before the selected field without decoding them. The offsets of the fields that have been
+// walked are kept, so resolving another field of the same message only walks the rest. This makes
+// it cheap for filters and queries that look at a few fields of every message.
+//
+// Like ViewAs, the strings and the arrays that are returned share the memory of the message data,
+// so they must not be used after the record is closed. A LazyMessage is not safe for concurrent
+// use.
+type LazyMessage struct {
+	def  *MessageDefinition
+	data []byte
+	// offsets are the offsets in data of the fields of the messages that have been walked, keyed by
+	// the path prefix of the message, e.g. "" or "points[2].", in the order of the fields
+	offsets map[string][]int
+}
+
+// NewLazyMessage creates a LazyMessage of data that is serialized with def.
+func NewLazyMessage(def *MessageDefinition, data []byte) *LazyMessage {
+	return &LazyMessage{
+		def:     def,
+		data:    data,
+		offsets: make(map[string][]int),
+	}
+}
+
+// Lazy returns the message data as a LazyMessage with the message definition of its connection.
+func (record *RecordMessageData) Lazy() *LazyMessage {
+	return NewLazyMessage(&record.connHdr.MessageDefinition, record.Data())
+}
+
+// Get resolves path, a FieldPath syntax, e.g. "header.frame_id" or "points[3]", and returns the
+// value of the field with the Go type of Data Type Mapping. Unlike FieldPath, path can also select
+// a whole array, which is returned as a slice, a nested message, which is returned as a
+// map[string]interface{}, or a constant.
+func (msg *LazyMessage) Get(path string) (interface{}, error) {
+	p, err := CompileFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return msg.GetPath(p)
+}
+
+// GetPath is like Get with a compiled path, so that the path is parsed once for many messages.
+func (msg *LazyMessage) GetPath(path *FieldPath) (interface{}, error) {
+	field, raw, err := msg.locate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if field.Value != nil {
+		return field.Value, nil
+	}
+
+	if field.Type != MessageFieldTypeComplex {
+		v, _, err := decodeFieldBasic(field, raw)
+		return v, err
+	}
+
+	// the decoder of the nested messages takes care of the registered types and the arrays
+	m := make(map[string]interface{})
+	_, err = decodeMessageData(&MessageDefinition{Type: field.MsgType.Type, Fields: []*MessageFieldDefinition{field}}, raw, m)
+	if err != nil {
+		return nil, err
+	}
+	return m[field.Name], nil
+}
+
+// Float64 resolves path like Get, and returns the value as a float64 like FieldPath.Float64.
+func (msg *LazyMessage) Float64(path string) (float64, error) {
+	p, err := CompileFieldPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := msg.GetPath(p)
+	if err != nil {
+		return 0, err
+	}
+
+	f, ok := numericValue(v)
+	if !ok {
+		return 0, fmt.Errorf("field %s is a %T, which is not numeric", path, v)
+	}
+	return f, nil
+}
+
+// locate returns the definition of the field that path points to, and the message data that
+// starts at the field. Unlike FieldPath.locate, the last element of path can be a whole array.
+func (msg *LazyMessage) locate(path *FieldPath) (*MessageFieldDefinition, []byte, error) {
+	def := msg.def
+	base := 0
+	prefix := ""
+	for i, elem := range path.elems {
+		field, off, err := msg.locateField(def, prefix, base, elem.name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("field path %s: %w", path.raw, err)
+		}
+
+		if field.Value != nil {
+			if i != len(path.elems)-1 || elem.index >= 0 {
+				return nil, nil, fmt.Errorf("field path %s: %s is a constant", path.raw, elem.name)
+			}
+			return field, nil, nil
+		}
+
+		raw := msg.data[off:]
+		prefix += elem.name
+		if elem.index >= 0 {
+			if !field.IsArray {
+				return nil, nil, fmt.Errorf("field path %s: %s is not an array", path.raw, elem.name)
+			}
+
+			field, raw, err = locateArrayElem(field, raw, elem.index)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field path %s: %s: %w", path.raw, elem.name, err)
+			}
+			prefix += "[" + strconv.Itoa(elem.index) + "]"
+		}
+
+		if i == len(path.elems)-1 {
+			return field, raw, nil
+		}
+
+		if field.IsArray {
+			return nil, nil, fmt.Errorf("field path %s: %s is an array, an element must be selected with an index", path.raw, elem.name)
+		}
+
+		if field.Type != MessageFieldTypeComplex {
+			return nil, nil, fmt.Errorf("field path %s: %s is a %s, which doesn't have fields", path.raw, elem.name, field.rosType())
+		}
+
+		def = field.MsgType
+		base = len(msg.data) - len(raw)
+		prefix += "."
+	}
+
+	// CompileFieldPath never returns an empty path
+	return nil, nil, fmt.Errorf("field path is empty")
+}
+
+// locateField returns the field name of def, and the offset of its data in msg.data. The message
+// of def starts at base, and prefix is its path prefix. The offsets of the fields before the field
+// are kept, so they're skipped only once.
+func (msg *LazyMessage) locateField(def *MessageDefinition, prefix string, base int, name string) (*MessageFieldDefinition, int, error) {
+	offsets, ok := msg.offsets[prefix]
+	if !ok {
+		offsets = []int{base}
+	}
+	defer func() { msg.offsets[prefix] = offsets }()
+
+	var i int
+	var prev *MessageFieldDefinition
+	for _, field := range def.Fields {
+		if i == len(offsets) {
+			raw, err := skipFieldData(prev, msg.data[offsets[i-1]:])
+			if err != nil {
+				return nil, 0, err
+			}
+			offsets = append(offsets, len(msg.data)-len(raw))
+		}
+
+		if field.Name == name {
+			return field, offsets[i], nil
+		}
+		prev = field
+		i++
+	}
+
+	if constant, ok := def.Constant(name); ok {
+		return constant.asField(), 0, nil
+	}
+	return nil, 0, fmt.Errorf("%s is not in %s", name, def.Type)
+}
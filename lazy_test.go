@@ -0,0 +1,89 @@
+package rosbag
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLazyMessage(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(planTestMessageDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewLazyMessage(&def, encodePlanTestMessage(3))
+	testCases := map[string]interface{}{
+		"label":           "label",
+		"header.frame_id": "map",
+		"header.stamp":    time.Unix(10, 20),
+		"points[2].y":     float64(-2),
+		"scale":           []float64{1, 2, 3},
+		"scale[1]":        float64(2),
+		"MODE":            uint8(3),
+		"points[1]":       map[string]interface{}{"x": float64(1), "y": float64(-1)},
+		"header":          map[string]interface{}{"seq": uint32(1), "stamp": time.Unix(10, 20), "frame_id": "map"},
+		"points": []map[string]interface{}{
+			{"x": float64(0), "y": float64(0)},
+			{"x": float64(1), "y": float64(-1)},
+			{"x": float64(2), "y": float64(-2)},
+		},
+	}
+
+	for path, expected := range testCases {
+		v, err := msg.Get(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		if diff := cmp.Diff(expected, v); diff != "" {
+			t.Fatalf("%s is not matched:\n\n%s", path, diff)
+		}
+	}
+
+	// the fields of the message, the header, and the third point have been walked
+	if len(msg.offsets[""]) != 4 || len(msg.offsets["header."]) != 3 || len(msg.offsets["points[2]."]) != 2 {
+		t.Fatalf("unexpected offsets %v", msg.offsets)
+	}
+
+	f, err := msg.Float64("header.stamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f != 10.00000002 {
+		t.Fatalf("expected 10.00000002, but got %v", f)
+	}
+
+	errCases := map[string]string{
+		"points[3].x":    "out of range",
+		"points.x":       "an array",
+		"header[0].seq":  "not an array",
+		"unknown":        "not in",
+		"header.unknown": "not in std_msgs/Header",
+		"scale[0].x":     "doesn't have fields",
+		"MODE.x":         "a constant",
+		"":               "empty",
+	}
+
+	for path, expected := range errCases {
+		_, err := msg.Get(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %s to fail with %q, but got %v", path, expected, err)
+		}
+	}
+
+	_, err = msg.Float64("label")
+	if err == nil || !strings.Contains(err.Error(), "not numeric") {
+		t.Fatalf("expected a string to fail, but got %v", err)
+	}
+
+	raw := encodePlanTestMessage(3)
+	_, err = NewLazyMessage(&def, raw[:len(raw)-10]).Get("label")
+	if err == nil {
+		t.Fatal("expected truncated data to fail")
+	}
+}
@@ -0,0 +1,291 @@
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// ManifestExt is the extension of the manifest that is stored next to a bag, e.g.
+// example.bag.sha256.json.
+const ManifestExt = ".sha256.json"
+
+// CheckManifest is the check of the findings of VerifyManifest. It's not run by Check, since it
+// needs the manifest of the bag.
+const CheckManifest = "manifest"
+
+// Manifest has the SHA-256 digests of a bag, so that long-term archives can detect bit rot and
+// incomplete transfers, and locate the damage to chunks and connections. It only has plain
+// fields, so it can be stored with encoding/json next to the bag. The digests are lowercase hex.
+type Manifest struct {
+	Size        int64              `json:"size"`
+	SHA256      string             `json:"sha256"`
+	Chunks      []ChunkDigest      `json:"chunks"`
+	Connections []ConnectionDigest `json:"connections"`
+}
+
+// ChunkDigest is the digest of a whole chunk record, i.e. its header and its compressed data.
+type ChunkDigest struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	SHA256 string `json:"sha256"`
+}
+
+// ConnectionDigest is the digest of the message data records of a connection in the order of the
+// bag. The records are digested after the chunks are decompressed, so the digest doesn't change
+// when the bag is recompressed.
+type ConnectionDigest struct {
+	Conn     uint32 `json:"conn"`
+	Topic    string `json:"topic"`
+	Messages int    `json:"messages"`
+	SHA256   string `json:"sha256"`
+}
+
+// NewManifest reads the whole bag from r, and returns its manifest. An error is returned when the
+// bag can't be parsed, since the manifest of a damaged bag can't be used to verify it later.
+func NewManifest(r io.Reader) (*Manifest, error) {
+	digester := newBagDigester(r)
+	err := digester.run()
+	if err != nil {
+		return nil, err
+	}
+	return digester.manifest(), nil
+}
+
+// VerifyManifest reads the whole bag from r, and compares its digests to manifest. The findings
+// locate the chunks and the connections that don't match, or are missing, e.g. after an
+// incomplete transfer. The bag doesn't need to be parsable, the parts of the bag after the first
+// broken record are reported as missing. nil is returned when the bag matches the manifest.
+func VerifyManifest(r io.Reader, manifest *Manifest) ([]Finding, error) {
+	digester := newBagDigester(r)
+	parseErr := digester.run()
+	actual := digester.manifest()
+	if digester.err != nil {
+		return nil, digester.err
+	}
+
+	if actual.Size == manifest.Size && actual.SHA256 == manifest.SHA256 {
+		return nil, nil
+	}
+
+	var findings []Finding
+	report := func(offset int64, format string, args ...interface{}) {
+		findings = append(findings, Finding{Check: CheckManifest, Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+
+	report(-1, "the bag has %d bytes with sha256 %s, but the manifest has %d bytes with sha256 %s", actual.Size, actual.SHA256, manifest.Size, manifest.SHA256)
+	if parseErr != nil {
+		report(digester.offset, "the bag can't be parsed after this offset: %v", parseErr)
+	}
+
+	chunks := make(map[int64]ChunkDigest)
+	for _, chunk := range actual.Chunks {
+		chunks[chunk.Offset] = chunk
+	}
+
+	for _, expected := range manifest.Chunks {
+		chunk, ok := chunks[expected.Offset]
+		delete(chunks, expected.Offset)
+		switch {
+		case !ok:
+			report(expected.Offset, "the chunk is missing")
+		case chunk.Length != expected.Length || chunk.SHA256 != expected.SHA256:
+			report(expected.Offset, "the chunk has %d bytes with sha256 %s, but the manifest has %d bytes with sha256 %s", chunk.Length, chunk.SHA256, expected.Length, expected.SHA256)
+		}
+	}
+
+	for _, chunk := range actual.Chunks {
+		if _, ok := chunks[chunk.Offset]; ok {
+			report(chunk.Offset, "the chunk is not in the manifest")
+		}
+	}
+
+	conns := make(map[uint32]ConnectionDigest)
+	for _, conn := range actual.Connections {
+		conns[conn.Conn] = conn
+	}
+
+	for _, expected := range manifest.Connections {
+		conn, ok := conns[expected.Conn]
+		switch {
+		case !ok:
+			report(-1, "connection %d on %s is missing", expected.Conn, expected.Topic)
+		case conn.Messages != expected.Messages || conn.SHA256 != expected.SHA256:
+			report(-1, "connection %d on %s has %d messages with sha256 %s, but the manifest has %d messages with sha256 %s", expected.Conn, expected.Topic, conn.Messages, conn.SHA256, expected.Messages, expected.SHA256)
+		}
+	}
+	return findings, nil
+}
+
+// bagDigester computes the digests of a bag while it's parsed.
+type bagDigester struct {
+	r      *bufio.Reader
+	whole  hash.Hash
+	size   int64
+	chunks []ChunkDigest
+	conns  map[uint32]*connectionDigester
+	// offset is the offset of the next top-level record
+	offset int64
+	// err is an error from reading r, which fails the verification unlike the errors of parsing
+	err error
+}
+
+type connectionDigester struct {
+	topic    string
+	messages int
+	hash     hash.Hash
+}
+
+// countingReader counts the bytes that are read from r, and reports the read errors to the
+// digester.
+type countingReader struct {
+	r        io.Reader
+	digester *bagDigester
+}
+
+func (reader *countingReader) Read(b []byte) (int, error) {
+	n, err := reader.r.Read(b)
+	reader.digester.whole.Write(b[:n])
+	reader.digester.size += int64(n)
+	if err != nil && err != io.EOF {
+		reader.digester.err = err
+	}
+	return n, err
+}
+
+func newBagDigester(r io.Reader) *bagDigester {
+	digester := bagDigester{
+		whole: sha256.New(),
+		conns: make(map[uint32]*connectionDigester),
+	}
+	digester.r = bufio.NewReader(&countingReader{r: r, digester: &digester})
+	return &digester
+}
+
+// run parses the bag, and returns the first parsing error. The rest of the bag is still read
+// after an error, so that the digest and the size of the whole file are complete.
+func (digester *bagDigester) run() error {
+	err := digester.parse()
+	_, copyErr := io.Copy(ioutil.Discard, digester.r)
+	if err == nil {
+		err = copyErr
+	}
+	return err
+}
+
+func (digester *bagDigester) parse() error {
+	var version Version
+	_, err := fmt.Fscanf(digester.r, versionFormat, &version.Major, &version.Minor)
+	if err != nil {
+		return err
+	}
+	digester.offset = int64(len(fmt.Sprintf(versionFormat, version.Major, version.Minor)))
+
+	for {
+		record, op, err := readRawRecord(digester.r)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if op == OpChunk {
+			sum := sha256.Sum256(record.Raw)
+			digester.chunks = append(digester.chunks, ChunkDigest{
+				Offset: digester.offset,
+				Length: int64(len(record.Raw)),
+				SHA256: hex.EncodeToString(sum[:]),
+			})
+
+			data, err := decompressChunk(&RecordChunk{RecordBase: record})
+			if err != nil {
+				return err
+			}
+
+			chunk := bytes.NewReader(data)
+			for chunk.Len() > 0 {
+				inner, op, err := readRawRecord(chunk)
+				if err != nil {
+					return err
+				}
+
+				err = digester.add(op, inner)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			err = digester.add(op, record)
+			if err != nil {
+				return err
+			}
+		}
+		digester.offset += int64(len(record.Raw))
+	}
+}
+
+func (digester *bagDigester) add(op Op, record *RecordBase) error {
+	switch op {
+	case OpConnection:
+		connection := RecordConnection{RecordBase: record}
+		conn, err := connection.Conn()
+		if err != nil {
+			return err
+		}
+
+		topic, err := connection.Topic()
+		if err != nil {
+			return err
+		}
+		digester.connection(conn).topic = topic
+	case OpMessageData:
+		conn, err := (&RecordMessageData{RecordBase: record}).Conn()
+		if err != nil {
+			return err
+		}
+
+		connection := digester.connection(conn)
+		connection.messages++
+		connection.hash.Write(record.Raw)
+	}
+	return nil
+}
+
+func (digester *bagDigester) connection(conn uint32) *connectionDigester {
+	connection, ok := digester.conns[conn]
+	if !ok {
+		connection = &connectionDigester{hash: sha256.New()}
+		digester.conns[conn] = connection
+	}
+	return connection
+}
+
+func (digester *bagDigester) manifest() *Manifest {
+	manifest := Manifest{
+		Size:   digester.size,
+		SHA256: hex.EncodeToString(digester.whole.Sum(nil)),
+		Chunks: digester.chunks,
+	}
+
+	for conn, connection := range digester.conns {
+		manifest.Connections = append(manifest.Connections, ConnectionDigest{
+			Conn:     conn,
+			Topic:    connection.topic,
+			Messages: connection.messages,
+			SHA256:   hex.EncodeToString(connection.hash.Sum(nil)),
+		})
+	}
+
+	sort.Slice(manifest.Connections, func(i, j int) bool {
+		return manifest.Connections[i].Conn < manifest.Connections[j].Conn
+	})
+	return &manifest
+}
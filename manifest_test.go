@@ -0,0 +1,104 @@
+package rosbag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}},
+		[]indexedTestMessage{{0, 3}},
+		[]indexedTestMessage{{1, 5}, {0, 6}},
+	)
+
+	manifest, err := NewManifest(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(raw)
+	if manifest.Size != int64(len(raw)) || manifest.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the digest of %d bytes, but got %d bytes with %s", len(raw), manifest.Size, manifest.SHA256)
+	}
+
+	if len(manifest.Chunks) != 3 || len(manifest.Connections) != 2 {
+		t.Fatalf("expected 3 chunks and 2 connections, but got %+v", manifest)
+	}
+
+	conn := manifest.Connections[1]
+	if conn.Conn != 1 || conn.Topic != "/b" || conn.Messages != 2 {
+		t.Fatalf("expected 2 messages on /b, but got %+v", conn)
+	}
+
+	findings, err := VerifyManifest(bytes.NewReader(raw), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the bag to match, but got %v", findings)
+	}
+
+	// the last byte of the second chunk is the value of its message
+	corrupted := append([]byte(nil), raw...)
+	chunk := manifest.Chunks[1]
+	corrupted[chunk.Offset+chunk.Length-1]++
+	findings, err = VerifyManifest(bytes.NewReader(corrupted), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectFindings(t, findings, "the bag has", "the chunk has", "connection 0 on /a")
+	if findings[1].Offset != chunk.Offset {
+		t.Fatalf("expected the second chunk to be corrupted, but got %v", findings[1])
+	}
+
+	// an incomplete transfer
+	findings, err = VerifyManifest(bytes.NewReader(raw[:manifest.Chunks[2].Offset+10]), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectFindings(t, findings, "the bag has", "can't be parsed", "the chunk is missing", "connection 0 on /a", "connection 1 on /b")
+}
+
+func TestManifestCorruptedLength(t *testing.T) {
+	raw := writeIndexedTestBag(t, []indexedTestMessage{{0, 1}, {1, 2}})
+	manifest, err := NewManifest(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header length of the chunk overflows the record length
+	corrupted := append([]byte(nil), raw...)
+	copy(corrupted[manifest.Chunks[0].Offset:], bytes.Repeat([]byte{0xff}, lenInBytes))
+	_, err = NewManifest(bytes.NewReader(corrupted))
+	if err != errRecordTooLarge {
+		t.Fatalf("expected %v, but got %v", errRecordTooLarge, err)
+	}
+
+	findings, err := VerifyManifest(bytes.NewReader(corrupted), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectFindings(t, findings, "the bag has", "can't be parsed", "the chunk is missing", "connection 0 on /a", "connection 1 on /b")
+}
+
+// expectFindings checks that every finding has the message in the same position of messages.
+func expectFindings(t *testing.T, findings []Finding, messages ...string) {
+	t.Helper()
+	if len(findings) != len(messages) {
+		t.Fatalf("expected %d findings, but got %v", len(messages), findings)
+	}
+
+	for i, finding := range findings {
+		if finding.Check != CheckManifest || !strings.Contains(finding.String(), messages[i]) {
+			t.Fatalf("expected finding %d to be about %q, but got %v", i, messages[i], finding)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package marker
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// Format is the file format of the scenes, it's also the extension of the files.
+type Format string
+
+// The formats of the scenes. OBJ files have the colors of the vertices after their positions,
+// which is supported by most viewers, e.g. MeshLab and Blender. glTF files embed their buffers,
+// so every scene is a single file.
+const (
+	FormatOBJ  Format = "obj"
+	FormatGLTF Format = "gltf"
+)
+
+func (format Format) write(w io.Writer, markers []*Marker) error {
+	switch format {
+	case FormatOBJ:
+		return WriteOBJ(w, markers)
+	case FormatGLTF:
+		return WriteGLTF(w, markers)
+	default:
+		return fmt.Errorf("unknown scene format %q", string(format))
+	}
+}
+
+// Export runs decoder to the end, and writes the scene of markers that are shown after every
+// message of topic to dir. The files are named by the record times of the messages, e.g.
+// 1600000000.000000000.gltf, and dir is created when it doesn't exist. Export returns the number
+// of scenes that have been written.
+func Export(dir string, decoder *rosbag.Decoder, topic string, format Format) (int, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	scene := NewScene()
+	var scenes int
+	err = decoder.Subscribe(topic, func(record *rosbag.RecordMessageData) error {
+		t, err := record.Time()
+		if err != nil {
+			return err
+		}
+
+		markers, err := Decode(record)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range markers {
+			scene.Apply(t, m)
+		}
+
+		name := fmt.Sprintf("%d.%09d.%s", t.Unix(), t.Nanosecond(), format)
+		err = writeFile(filepath.Join(dir, name), scene.Markers(t), format)
+		if err != nil {
+			return err
+		}
+		scenes++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = decoder.Run()
+	return scenes, err
+}
+
+func writeFile(path string, markers []*Marker, format Format) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = format.write(f, markers)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ExportMerged runs decoder to the end, and writes every marker that's added on topic to w as a
+// single scene, e.g. to see the whole history of a planner at once. Deletes and lifetimes are
+// ignored.
+func ExportMerged(w io.Writer, decoder *rosbag.Decoder, topic string, format Format) error {
+	var merged []*Marker
+	err := decoder.Subscribe(topic, func(record *rosbag.RecordMessageData) error {
+		markers, err := Decode(record)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range markers {
+			if m.Action == ActionAdd || m.Action == ActionModify {
+				merged = append(merged, m)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		return err
+	}
+
+	// the versions of a marker stay in the order of the bag
+	sortMarkers(merged)
+	return format.write(w, merged)
+}
+
+// WriteOBJ writes markers to w as a Wavefront OBJ scene with an object per marker.
+func WriteOBJ(w io.Writer, markers []*Marker) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# visualization_msgs/Marker scene")
+
+	// the indices of the vertices are global and start from 1
+	base := uint32(1)
+	for _, m := range markers {
+		geometry := newMesh(m)
+		if geometry == nil || geometry.empty() {
+			continue
+		}
+
+		fmt.Fprintf(bw, "o %s\n", strings.Replace(m.Name(), " ", "_", -1))
+		for i, v := range geometry.positions {
+			c := geometry.colors[i]
+			fmt.Fprintf(bw, "v %g %g %g %g %g %g\n", v.X, v.Y, v.Z, c.R, c.G, c.B)
+		}
+
+		for i := 0; i < len(geometry.triangles); i += 3 {
+			t := geometry.triangles[i : i+3]
+			fmt.Fprintf(bw, "f %d %d %d\n", base+t[0], base+t[1], base+t[2])
+		}
+
+		for i := 0; i < len(geometry.lines); i += 2 {
+			fmt.Fprintf(bw, "l %d %d\n", base+geometry.lines[i], base+geometry.lines[i+1])
+		}
+
+		for _, i := range geometry.points {
+			fmt.Fprintf(bw, "p %d\n", base+i)
+		}
+		base += uint32(len(geometry.positions))
+	}
+	return bw.Flush()
+}
+
+// The constants of glTF 2.0.
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfPoints       = 0
+	gltfLines        = 1
+	gltfTriangles    = 4
+)
+
+// zUpToYUp rotates the z-up frames of ROS to the y-up frame of glTF, it's a rotation of -90
+// degrees around x.
+var zUpToYUp = []float64{-math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2}
+
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes,omitempty"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Accessors   []gltfAccessor   `json:"accessors,omitempty"`
+	BufferViews []gltfBufferView `json:"bufferViews,omitempty"`
+	Buffers     []gltfBuffer     `json:"buffers,omitempty"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name     string    `json:"name"`
+	Mesh     *int      `json:"mesh,omitempty"`
+	Rotation []float64 `json:"rotation,omitempty"`
+	Children []int     `json:"children,omitempty"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Mode       int            `json:"mode"`
+	Material   int            `json:"material"`
+}
+
+type gltfMaterial struct {
+	Name                 string                 `json:"name"`
+	PBRMetallicRoughness map[string]interface{} `json:"pbrMetallicRoughness"`
+	AlphaMode            string                 `json:"alphaMode"`
+	DoubleSided          bool                   `json:"doubleSided"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri"`
+}
+
+// gltfBuilder appends the data of the accessors to a single buffer.
+type gltfBuilder struct {
+	doc gltfDocument
+	buf []byte
+}
+
+// addAccessor appends data, which are float32 or uint32 components, to the buffer, and returns
+// the index of its accessor.
+func (builder *gltfBuilder) addAccessor(data []uint32, componentType int, typ string, count int, target int) int {
+	view := gltfBufferView{ByteOffset: len(builder.buf), ByteLength: 4 * len(data), Target: target}
+	for _, v := range data {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], v)
+		builder.buf = append(builder.buf, b[:]...)
+	}
+
+	builder.doc.BufferViews = append(builder.doc.BufferViews, view)
+	builder.doc.Accessors = append(builder.doc.Accessors, gltfAccessor{
+		BufferView:    len(builder.doc.BufferViews) - 1,
+		ComponentType: componentType,
+		Count:         count,
+		Type:          typ,
+	})
+	return len(builder.doc.Accessors) - 1
+}
+
+func (builder *gltfBuilder) addMesh(name string, geometry *mesh) int {
+	positions := make([]uint32, 0, 3*len(geometry.positions))
+	min := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	max := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for _, v := range geometry.positions {
+		for i, x := range []float32{float32(v.X), float32(v.Y), float32(v.Z)} {
+			positions = append(positions, math.Float32bits(x))
+			if x < min[i] {
+				min[i] = x
+			}
+			if x > max[i] {
+				max[i] = x
+			}
+		}
+	}
+	position := builder.addAccessor(positions, gltfFloat, "VEC3", len(geometry.positions), gltfArrayBuffer)
+	// positions must have their bounds
+	builder.doc.Accessors[position].Min = min
+	builder.doc.Accessors[position].Max = max
+
+	colors := make([]uint32, 0, 4*len(geometry.colors))
+	material := 0
+	for _, c := range geometry.colors {
+		for _, x := range []float32{c.R, c.G, c.B, c.A} {
+			colors = append(colors, math.Float32bits(x))
+		}
+		if c.A < 1 {
+			material = 1
+		}
+	}
+	color := builder.addAccessor(colors, gltfFloat, "VEC4", len(geometry.colors), gltfArrayBuffer)
+
+	gm := gltfMesh{Name: name}
+	for _, primitive := range []struct {
+		indices []uint32
+		mode    int
+	}{
+		{geometry.triangles, gltfTriangles},
+		{geometry.lines, gltfLines},
+		{geometry.points, gltfPoints},
+	} {
+		if len(primitive.indices) == 0 {
+			continue
+		}
+
+		gm.Primitives = append(gm.Primitives, gltfPrimitive{
+			Attributes: map[string]int{"POSITION": position, "COLOR_0": color},
+			Indices:    builder.addAccessor(primitive.indices, gltfUnsignedInt, "SCALAR", len(primitive.indices), gltfElementArray),
+			Mode:       primitive.mode,
+			Material:   material,
+		})
+	}
+
+	builder.doc.Meshes = append(builder.doc.Meshes, gm)
+	return len(builder.doc.Meshes) - 1
+}
+
+// WriteGLTF writes markers to w as a glTF 2.0 scene with a node per marker. The nodes are the
+// children of a root node that rotates the z-up frame of ROS to the y-up frame of glTF.
+func WriteGLTF(w io.Writer, markers []*Marker) error {
+	var builder gltfBuilder
+	builder.doc = gltfDocument{
+		Asset:  gltfAsset{Version: "2.0", Generator: "go-rosbag"},
+		Scenes: []gltfScene{{Nodes: []int{0}}},
+		Nodes:  []gltfNode{{Name: "ros", Rotation: zUpToYUp}},
+		// the vertex colors are multiplied by the base color, which is white by default
+		Materials: []gltfMaterial{
+			{Name: "opaque", PBRMetallicRoughness: map[string]interface{}{"metallicFactor": 0}, AlphaMode: "OPAQUE", DoubleSided: true},
+			{Name: "transparent", PBRMetallicRoughness: map[string]interface{}{"metallicFactor": 0}, AlphaMode: "BLEND", DoubleSided: true},
+		},
+	}
+
+	for _, m := range markers {
+		geometry := newMesh(m)
+		if geometry == nil || geometry.empty() {
+			continue
+		}
+
+		index := builder.addMesh(m.Name(), geometry)
+		builder.doc.Nodes = append(builder.doc.Nodes, gltfNode{Name: m.Name(), Mesh: &index})
+		builder.doc.Nodes[0].Children = append(builder.doc.Nodes[0].Children, len(builder.doc.Nodes)-1)
+	}
+
+	if len(builder.buf) > 0 {
+		builder.doc.Buffers = []gltfBuffer{{
+			ByteLength: len(builder.buf),
+			URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(builder.buf),
+		}}
+	}
+
+	return json.NewEncoder(w).Encode(&builder.doc)
+}
@@ -0,0 +1,242 @@
+// Package marker exports visualization_msgs/Marker and visualization_msgs/MarkerArray messages as
+// OBJ or glTF scenes, so that the debug visualizations of planners and perception can be viewed
+// in standard 3D viewers without RViz.
+//
+// The markers are placed by their poses in their frames, the frames are not transformed to a
+// common frame. Text markers, and meshes from mesh resources, are not exported.
+package marker
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// The ROS types of the messages that are consumed by Decode.
+const (
+	MessageType      = "visualization_msgs/Marker"
+	ArrayMessageType = "visualization_msgs/MarkerArray"
+)
+
+// The types of visualization_msgs/Marker, which are the shapes of the markers.
+const (
+	TypeArrow int32 = iota
+	TypeCube
+	TypeSphere
+	TypeCylinder
+	TypeLineStrip
+	TypeLineList
+	TypeCubeList
+	TypeSphereList
+	TypePoints
+	TypeTextViewFacing
+	TypeMeshResource
+	TypeTriangleList
+)
+
+// The actions of visualization_msgs/Marker, which are what a marker does to the marker with the
+// same namespace and ID. ActionModify is the same as ActionAdd.
+const (
+	ActionAdd       int32 = 0
+	ActionModify    int32 = 1
+	ActionDelete    int32 = 2
+	ActionDeleteAll int32 = 3
+)
+
+// Vector3 is a point or a scale, like geometry_msgs/Point and geometry_msgs/Vector3.
+type Vector3 struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+}
+
+func (v Vector3) add(u Vector3) Vector3 {
+	return Vector3{X: v.X + u.X, Y: v.Y + u.Y, Z: v.Z + u.Z}
+}
+
+func (v Vector3) sub(u Vector3) Vector3 {
+	return Vector3{X: v.X - u.X, Y: v.Y - u.Y, Z: v.Z - u.Z}
+}
+
+func (v Vector3) scale(s float64) Vector3 {
+	return Vector3{X: v.X * s, Y: v.Y * s, Z: v.Z * s}
+}
+
+// mul multiplies v by u element-wise.
+func (v Vector3) mul(u Vector3) Vector3 {
+	return Vector3{X: v.X * u.X, Y: v.Y * u.Y, Z: v.Z * u.Z}
+}
+
+func (v Vector3) cross(u Vector3) Vector3 {
+	return Vector3{
+		X: v.Y*u.Z - v.Z*u.Y,
+		Y: v.Z*u.X - v.X*u.Z,
+		Z: v.X*u.Y - v.Y*u.X,
+	}
+}
+
+func (v Vector3) norm() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// Quaternion is a rotation, like geometry_msgs/Quaternion.
+type Quaternion struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+	Z float64 `rosbag:"z"`
+	W float64 `rosbag:"w"`
+}
+
+// rotate rotates v by q. A zero quaternion, which is what markers that don't set their
+// orientation have, is treated as the identity like RViz does.
+func (q Quaternion) rotate(v Vector3) Vector3 {
+	norm := math.Sqrt(q.X*q.X + q.Y*q.Y + q.Z*q.Z + q.W*q.W)
+	if norm == 0 {
+		return v
+	}
+
+	// v + 2w(u x v) + 2u x (u x v), where u is the vector part of the unit quaternion
+	u := Vector3{X: q.X / norm, Y: q.Y / norm, Z: q.Z / norm}
+	t := u.cross(v).scale(2)
+	return v.add(t.scale(q.W / norm)).add(u.cross(t))
+}
+
+// Pose is the position and the orientation of a marker in its frame.
+type Pose struct {
+	Position    Vector3    `rosbag:"position"`
+	Orientation Quaternion `rosbag:"orientation"`
+}
+
+func (pose Pose) transform(v Vector3) Vector3 {
+	return pose.Orientation.rotate(v).add(pose.Position)
+}
+
+// Color is a color with components from 0 to 1, like std_msgs/ColorRGBA.
+type Color struct {
+	R float32 `rosbag:"r"`
+	G float32 `rosbag:"g"`
+	B float32 `rosbag:"b"`
+	A float32 `rosbag:"a"`
+}
+
+// Marker is a visualization_msgs/Marker message.
+type Marker struct {
+	Header struct {
+		Stamp   time.Time `rosbag:"stamp"`
+		FrameID string    `rosbag:"frame_id,copy"`
+	} `rosbag:"header"`
+	Namespace string        `rosbag:"ns,copy"`
+	ID        int32         `rosbag:"id"`
+	Type      int32         `rosbag:"type"`
+	Action    int32         `rosbag:"action"`
+	Pose      Pose          `rosbag:"pose"`
+	Scale     Vector3       `rosbag:"scale"`
+	Color     Color         `rosbag:"color"`
+	Lifetime  time.Duration `rosbag:"lifetime"`
+	Points    []Vector3     `rosbag:"points"`
+	Colors    []Color       `rosbag:"colors"`
+}
+
+// Name is the namespace and the ID of the marker, e.g. planner/3.
+func (m *Marker) Name() string {
+	return fmt.Sprintf("%s/%d", m.Namespace, m.ID)
+}
+
+type markerArray struct {
+	Markers []Marker `rosbag:"markers"`
+}
+
+// Decode decodes the Marker or the MarkerArray message in record to markers.
+func Decode(record *rosbag.RecordMessageData) ([]*Marker, error) {
+	switch typ := record.ConnectionHeader().Type; typ {
+	case MessageType:
+		var m Marker
+		err := record.ViewAs(&m)
+		if err != nil {
+			return nil, err
+		}
+		return []*Marker{&m}, nil
+	case ArrayMessageType:
+		var array markerArray
+		err := record.ViewAs(&array)
+		if err != nil {
+			return nil, err
+		}
+
+		markers := make([]*Marker, len(array.Markers))
+		for i := range array.Markers {
+			markers[i] = &array.Markers[i]
+		}
+		return markers, nil
+	default:
+		return nil, fmt.Errorf("expected %s or %s, but got %s", MessageType, ArrayMessageType, typ)
+	}
+}
+
+type markerKey struct {
+	namespace string
+	id        int32
+}
+
+type sceneMarker struct {
+	marker *Marker
+	added  time.Time
+}
+
+// Scene is the set of markers that are shown at a time, like the markers that RViz shows for a
+// topic. Markers replace the markers with the same namespace and ID, until they're deleted or
+// their lifetime ends.
+type Scene struct {
+	markers map[markerKey]sceneMarker
+}
+
+// NewScene creates an empty scene.
+func NewScene() *Scene {
+	return &Scene{markers: make(map[markerKey]sceneMarker)}
+}
+
+// Apply applies the action of m, which is received at t.
+func (scene *Scene) Apply(t time.Time, m *Marker) {
+	key := markerKey{namespace: m.Namespace, id: m.ID}
+	switch m.Action {
+	case ActionAdd, ActionModify:
+		scene.markers[key] = sceneMarker{marker: m, added: t}
+	case ActionDelete:
+		delete(scene.markers, key)
+	case ActionDeleteAll:
+		// since Noetic, a namespace limits the markers that are deleted
+		for k := range scene.markers {
+			if m.Namespace == "" || k.namespace == m.Namespace {
+				delete(scene.markers, k)
+			}
+		}
+	}
+}
+
+// Markers returns the markers that are shown at t sorted by their namespaces and IDs. Markers
+// whose lifetime has ended before t are removed.
+func (scene *Scene) Markers(t time.Time) []*Marker {
+	var markers []*Marker
+	for key, m := range scene.markers {
+		if m.marker.Lifetime > 0 && m.added.Add(m.marker.Lifetime).Before(t) {
+			delete(scene.markers, key)
+			continue
+		}
+		markers = append(markers, m.marker)
+	}
+
+	sortMarkers(markers)
+	return markers
+}
+
+func sortMarkers(markers []*Marker) {
+	sort.SliceStable(markers, func(i, j int) bool {
+		if markers[i].Namespace != markers[j].Namespace {
+			return markers[i].Namespace < markers[j].Namespace
+		}
+		return markers[i].ID < markers[j].ID
+	})
+}
@@ -0,0 +1,313 @@
+package marker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+const markerArrayDefinition = `
+visualization_msgs/Marker[] markers
+
+MSG: visualization_msgs/Marker
+std_msgs/Header header
+string ns
+int32 id
+int32 type
+int32 action
+geometry_msgs/Pose pose
+geometry_msgs/Vector3 scale
+std_msgs/ColorRGBA color
+duration lifetime
+geometry_msgs/Point[] points
+std_msgs/ColorRGBA[] colors
+string text
+
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+
+MSG: geometry_msgs/Pose
+geometry_msgs/Point position
+geometry_msgs/Quaternion orientation
+
+MSG: geometry_msgs/Point
+float64 x
+float64 y
+float64 z
+
+MSG: geometry_msgs/Quaternion
+float64 x
+float64 y
+float64 z
+float64 w
+
+MSG: geometry_msgs/Vector3
+float64 x
+float64 y
+float64 z
+
+MSG: std_msgs/ColorRGBA
+float32 r
+float32 g
+float32 b
+float32 a
+`
+
+func appendValues(b []byte, values ...interface{}) []byte {
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			b = appendValues(b, uint32(len(v)))
+			b = append(b, v...)
+		default:
+			var buf bytes.Buffer
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+			b = append(b, buf.Bytes()...)
+		}
+	}
+	return b
+}
+
+func appendMarker(b []byte, m *Marker) []byte {
+	b = appendValues(b, uint32(0), uint32(0), uint32(0), m.Header.FrameID, m.Namespace, m.ID, m.Type, m.Action)
+	b = appendValues(b, m.Pose, m.Scale, m.Color, int32(m.Lifetime/time.Second), int32(m.Lifetime%time.Second))
+	b = appendValues(b, uint32(len(m.Points)))
+	for _, p := range m.Points {
+		b = appendValues(b, p)
+	}
+	b = appendValues(b, uint32(len(m.Colors)))
+	for _, c := range m.Colors {
+		b = appendValues(b, c)
+	}
+	return appendValues(b, "")
+}
+
+var red = Color{R: 1, A: 1}
+
+func newMarker(ns string, id int32, typ int32, action int32) *Marker {
+	m := &Marker{Namespace: ns, ID: id, Type: typ, Action: action, Scale: Vector3{X: 1, Y: 1, Z: 1}, Color: red}
+	m.Header.FrameID = "map"
+	return m
+}
+
+func writeTestBag(t *testing.T, arrays ...[]*Marker) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	conn := builder.Connection("/markers", ArrayMessageType, markerArrayDefinition)
+	for i, markers := range arrays {
+		raw := appendValues(nil, uint32(len(markers)))
+		for _, m := range markers {
+			raw = appendMarker(raw, m)
+		}
+		builder.Message(conn, time.Unix(int64(i+1), 0), raw)
+	}
+	return builder.Bytes()
+}
+
+func TestRotate(t *testing.T) {
+	// 90 degrees around z
+	q := Quaternion{Z: math.Sqrt2 / 2, W: math.Sqrt2 / 2}
+	actual := q.rotate(Vector3{X: 1, Z: 2})
+	if diff := cmp.Diff(Vector3{Y: 1, Z: 2}, actual, cmpopts.EquateApprox(0, 1e-12)); diff != "" {
+		t.Fatalf("rotated vector is not matched:\n\n%s", diff)
+	}
+
+	// the zero quaternion of markers that don't set their orientation
+	actual = Quaternion{}.rotate(Vector3{X: 1})
+	if actual != (Vector3{X: 1}) {
+		t.Fatalf("expected the zero quaternion to be the identity, but got %v", actual)
+	}
+}
+
+func TestNewMesh(t *testing.T) {
+	cube := newMarker("", 0, TypeCube, ActionAdd)
+	cube.Pose.Position = Vector3{X: 10}
+	cube.Scale = Vector3{X: 2, Y: 4, Z: 6}
+	geometry := newMesh(cube)
+	if len(geometry.positions) != 8 || len(geometry.triangles) != 36 {
+		t.Fatalf("expected 8 vertices and 12 triangles, but got %d and %d", len(geometry.positions), len(geometry.triangles)/3)
+	}
+
+	for _, v := range geometry.positions {
+		if math.Abs(v.X-10) != 1 || math.Abs(v.Y) != 2 || math.Abs(v.Z) != 3 {
+			t.Fatalf("expected the corners of the cube to be at (10±1, ±2, ±3), but got %v", v)
+		}
+	}
+
+	strip := newMarker("", 0, TypeLineStrip, ActionAdd)
+	strip.Points = []Vector3{{}, {X: 1}, {X: 2}}
+	strip.Colors = []Color{red, red, {G: 1, A: 1}}
+	geometry = newMesh(strip)
+	if diff := cmp.Diff([]uint32{0, 1, 1, 2}, geometry.lines); diff != "" {
+		t.Fatalf("lines are not matched:\n\n%s", diff)
+	}
+	if geometry.colors[2] != strip.Colors[2] {
+		t.Fatalf("expected the colors of the points, but got %v", geometry.colors)
+	}
+
+	list := newMarker("", 0, TypeLineList, ActionAdd)
+	list.Points = strip.Points
+	if diff := cmp.Diff([]uint32{0, 1}, newMesh(list).lines); diff != "" {
+		t.Fatalf("lines are not matched:\n\n%s", diff)
+	}
+
+	arrow := newMarker("", 0, TypeArrow, ActionAdd)
+	arrow.Points = []Vector3{{}, {Z: 1}}
+	arrow.Scale = Vector3{X: 0.1, Y: 0.2, Z: 0.25}
+	for _, v := range newMesh(arrow).positions {
+		if v.Z < 0 || v.Z > 1 || math.Hypot(v.X, v.Y) > 0.1+1e-12 {
+			t.Fatalf("expected the arrow to be within its points and its head, but got %v", v)
+		}
+	}
+
+	if newMesh(newMarker("", 0, TypeTextViewFacing, ActionAdd)) != nil {
+		t.Fatal("expected text markers to be skipped")
+	}
+}
+
+func TestScene(t *testing.T) {
+	scene := NewScene()
+	at := func(sec int64) time.Time {
+		return time.Unix(sec, 0)
+	}
+
+	a := newMarker("a", 1, TypeCube, ActionAdd)
+	b := newMarker("b", 1, TypeCube, ActionAdd)
+	b.Lifetime = time.Second
+	scene.Apply(at(1), b)
+	scene.Apply(at(1), a)
+	if diff := cmp.Diff([]*Marker{a, b}, scene.Markers(at(2))); diff != "" {
+		t.Fatalf("markers are not matched:\n\n%s", diff)
+	}
+
+	// b's lifetime ends
+	if diff := cmp.Diff([]*Marker{a}, scene.Markers(at(3))); diff != "" {
+		t.Fatalf("markers are not matched:\n\n%s", diff)
+	}
+
+	modified := newMarker("a", 1, TypeSphere, ActionModify)
+	scene.Apply(at(3), modified)
+	scene.Apply(at(3), newMarker("a", 2, TypeCube, ActionAdd))
+	scene.Apply(at(3), newMarker("c", 1, TypeCube, ActionAdd))
+	scene.Apply(at(3), newMarker("a", 2, TypeCube, ActionDelete))
+	if diff := cmp.Diff([]*Marker{modified, newMarker("c", 1, TypeCube, ActionAdd)}, scene.Markers(at(3))); diff != "" {
+		t.Fatalf("markers are not matched:\n\n%s", diff)
+	}
+
+	scene.Apply(at(4), newMarker("c", 0, TypeCube, ActionDeleteAll))
+	if diff := cmp.Diff([]*Marker{modified}, scene.Markers(at(4))); diff != "" {
+		t.Fatalf("markers are not matched:\n\n%s", diff)
+	}
+
+	scene.Apply(at(4), newMarker("", 0, TypeCube, ActionDeleteAll))
+	if markers := scene.Markers(at(4)); len(markers) != 0 {
+		t.Fatalf("expected every marker to be deleted, but got %d", len(markers))
+	}
+}
+
+func TestExport(t *testing.T) {
+	points := newMarker("b", 0, TypePoints, ActionAdd)
+	points.Points = []Vector3{{X: 1}, {Y: 1}}
+	raw := writeTestBag(t,
+		[]*Marker{newMarker("a", 0, TypeCube, ActionAdd), points},
+		[]*Marker{newMarker("a", 0, TypeCube, ActionDelete)},
+	)
+
+	dir := filepath.Join(t.TempDir(), "scenes")
+	scenes, err := Export(dir, rosbag.NewDecoder(bytes.NewReader(raw)), "/markers", FormatOBJ)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scenes != 2 {
+		t.Fatalf("expected 2 scenes, but got %d", scenes)
+	}
+
+	obj, err := ioutil.ReadFile(filepath.Join(dir, "1.000000000.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(obj []byte, prefix string) int {
+		var n int
+		for _, line := range strings.Split(string(obj), "\n") {
+			if strings.HasPrefix(line, prefix) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if objects, faces, pts := count(obj, "o "), count(obj, "f "), count(obj, "p "); objects != 2 || faces != 12 || pts != 2 {
+		t.Fatalf("expected 2 objects with 12 faces and 2 points, but got %d, %d, and %d", objects, faces, pts)
+	}
+
+	if !strings.Contains(string(obj), "p 9\np 10\n") {
+		t.Fatalf("expected the points to be indexed after the vertices of the cube:\n%s", obj)
+	}
+
+	// the cube is deleted in the second scene
+	obj, err = ioutil.ReadFile(filepath.Join(dir, "2.000000000.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if objects := count(obj, "o "); objects != 1 {
+		t.Fatalf("expected 1 object, but got %d", objects)
+	}
+
+	var buf bytes.Buffer
+	err = ExportMerged(&buf, rosbag.NewDecoder(bytes.NewReader(raw)), "/markers", FormatGLTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc gltfDocument
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Meshes) != 2 || len(doc.Nodes) != 3 {
+		t.Fatalf("expected 2 meshes under the root node, but got %d meshes and %d nodes", len(doc.Meshes), len(doc.Nodes))
+	}
+
+	if doc.Meshes[0].Name != "a/0" || doc.Meshes[1].Primitives[0].Mode != gltfPoints {
+		t.Fatalf("expected a cube and points, but got %+v", doc.Meshes)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(doc.Buffers[0].URI, "data:application/octet-stream;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != doc.Buffers[0].ByteLength {
+		t.Fatalf("expected a buffer of %d bytes, but got %d", doc.Buffers[0].ByteLength, len(data))
+	}
+
+	components := map[string]int{"SCALAR": 1, "VEC3": 3, "VEC4": 4}
+	for _, accessor := range doc.Accessors {
+		view := doc.BufferViews[accessor.BufferView]
+		if view.ByteLength != 4*accessor.Count*components[accessor.Type] || view.ByteOffset+view.ByteLength > len(data) {
+			t.Fatalf("accessor %+v doesn't match its buffer view %+v", accessor, view)
+		}
+	}
+
+	err = ExportMerged(&buf, rosbag.NewDecoder(bytes.NewReader(raw)), "/markers", Format("stl"))
+	if err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
@@ -0,0 +1,234 @@
+package marker
+
+import (
+	"math"
+)
+
+// segments is the number of segments around the circles of spheres, cylinders, and arrows.
+const segments = 16
+
+// mesh is the geometry of a marker in its frame. Triangles, lines, and points are indices of
+// positions, every position has a color.
+type mesh struct {
+	positions []Vector3
+	colors    []Color
+	triangles []uint32
+	lines     []uint32
+	points    []uint32
+}
+
+func (m *mesh) empty() bool {
+	return len(m.triangles) == 0 && len(m.lines) == 0 && len(m.points) == 0
+}
+
+// addVertex adds a vertex, and returns its index.
+func (m *mesh) addVertex(v Vector3, color Color) uint32 {
+	m.positions = append(m.positions, v)
+	m.colors = append(m.colors, color)
+	return uint32(len(m.positions) - 1)
+}
+
+// addShape adds a copy of the triangles of shape that's scaled by scale, and moved to center.
+func (m *mesh) addShape(shape *mesh, center, scale Vector3, color Color) {
+	base := uint32(len(m.positions))
+	for _, v := range shape.positions {
+		m.addVertex(v.mul(scale).add(center), color)
+	}
+
+	for _, i := range shape.triangles {
+		m.triangles = append(m.triangles, base+i)
+	}
+}
+
+// unitCube is a cube with sides of 1 around the origin.
+var unitCube = func() *mesh {
+	var cube mesh
+	for i := 0; i < 8; i++ {
+		cube.positions = append(cube.positions, Vector3{
+			X: float64(i&1) - 0.5,
+			Y: float64(i>>1&1) - 0.5,
+			Z: float64(i>>2&1) - 0.5,
+		})
+	}
+
+	// two counter-clockwise triangles for every face, seen from outside
+	cube.triangles = []uint32{
+		0, 2, 1, 1, 2, 3, // -z
+		4, 5, 6, 5, 7, 6, // +z
+		0, 1, 4, 1, 5, 4, // -y
+		2, 6, 3, 3, 6, 7, // +y
+		0, 4, 2, 2, 4, 6, // -x
+		1, 3, 5, 3, 7, 5, // +x
+	}
+	return &cube
+}()
+
+// unitSphere is a sphere with a diameter of 1 around the origin.
+var unitSphere = func() *mesh {
+	const rings = segments / 2
+	var sphere mesh
+	for i := 0; i <= rings; i++ {
+		phi := math.Pi * float64(i) / rings
+		for j := 0; j < segments; j++ {
+			theta := 2 * math.Pi * float64(j) / segments
+			sphere.positions = append(sphere.positions, Vector3{
+				X: 0.5 * math.Sin(phi) * math.Cos(theta),
+				Y: 0.5 * math.Sin(phi) * math.Sin(theta),
+				Z: 0.5 * math.Cos(phi),
+			})
+		}
+	}
+
+	for i := 0; i < rings; i++ {
+		for j := 0; j < segments; j++ {
+			a := uint32(i*segments + j)
+			b := uint32(i*segments + (j+1)%segments)
+			c := a + segments
+			d := b + segments
+			sphere.triangles = append(sphere.triangles, a, c, b, b, c, d)
+		}
+	}
+	return &sphere
+}()
+
+// unitCylinder is a cylinder with a diameter and a height of 1 around the origin along z.
+var unitCylinder = func() *mesh {
+	var cylinder mesh
+	cylinder.addFrustum(Vector3{Z: -0.5}, Vector3{Z: 0.5}, 0.5, 0.5, Color{})
+	return &cylinder
+}()
+
+// addFrustum adds a closed truncated cone from start to end, with the radius r0 at start and r1
+// at end. A radius of 0 makes a cone.
+func (m *mesh) addFrustum(start, end Vector3, r0, r1 float64, color Color) {
+	axis := end.sub(start)
+	length := axis.norm()
+	if length == 0 {
+		return
+	}
+	axis = axis.scale(1 / length)
+
+	// any vector that's not parallel to the axis makes a basis of the circles
+	other := Vector3{X: 1}
+	if math.Abs(axis.X) > 0.9 {
+		other = Vector3{Y: 1}
+	}
+	u := axis.cross(other)
+	u = u.scale(1 / u.norm())
+	v := axis.cross(u)
+
+	startCenter := m.addVertex(start, color)
+	endCenter := m.addVertex(end, color)
+	base := uint32(len(m.positions))
+	for j := 0; j < segments; j++ {
+		theta := 2 * math.Pi * float64(j) / segments
+		dir := u.scale(math.Cos(theta)).add(v.scale(math.Sin(theta)))
+		m.addVertex(start.add(dir.scale(r0)), color)
+		m.addVertex(end.add(dir.scale(r1)), color)
+	}
+
+	for j := 0; j < segments; j++ {
+		a := base + uint32(2*j)
+		b := base + uint32(2*((j+1)%segments))
+		m.triangles = append(m.triangles,
+			a, b, a+1, b, b+1, a+1, // side
+			startCenter, b, a, // start cap
+			endCenter, a+1, b+1, // end cap
+		)
+	}
+}
+
+// markerColor returns the color of the i-th point of m, which is from Colors when every point
+// has a color.
+func markerColor(m *Marker, i int) Color {
+	if len(m.Colors) == len(m.Points) && i < len(m.Colors) {
+		return m.Colors[i]
+	}
+	return m.Color
+}
+
+// newMesh builds the geometry of m with the pose of m applied, like RViz draws it. It returns
+// nil for the types that are not supported.
+func newMesh(m *Marker) *mesh {
+	var local mesh
+	switch m.Type {
+	case TypeArrow:
+		addArrow(&local, m)
+	case TypeCube:
+		local.addShape(unitCube, Vector3{}, m.Scale, m.Color)
+	case TypeSphere:
+		local.addShape(unitSphere, Vector3{}, m.Scale, m.Color)
+	case TypeCylinder:
+		local.addShape(unitCylinder, Vector3{}, m.Scale, m.Color)
+	case TypeLineStrip, TypeLineList:
+		for i, p := range m.Points {
+			local.addVertex(p, markerColor(m, i))
+		}
+
+		step := 2
+		if m.Type == TypeLineStrip {
+			step = 1
+		}
+		for i := 0; i+1 < len(m.Points); i += step {
+			local.lines = append(local.lines, uint32(i), uint32(i+1))
+		}
+	case TypeCubeList, TypeSphereList:
+		shape := unitCube
+		if m.Type == TypeSphereList {
+			shape = unitSphere
+		}
+		for i, p := range m.Points {
+			local.addShape(shape, p, m.Scale, markerColor(m, i))
+		}
+	case TypePoints:
+		for i, p := range m.Points {
+			local.points = append(local.points, local.addVertex(p, markerColor(m, i)))
+		}
+	case TypeTriangleList:
+		for i := 0; i+2 < len(m.Points); i += 3 {
+			for j := i; j < i+3; j++ {
+				local.triangles = append(local.triangles, local.addVertex(m.Points[j].mul(m.Scale), markerColor(m, j)))
+			}
+		}
+	default:
+		return nil
+	}
+
+	for i, v := range local.positions {
+		local.positions[i] = m.Pose.transform(v)
+	}
+	return &local
+}
+
+// addArrow adds the shaft and the head of an arrow marker. An arrow either goes from its first
+// point to its second point, with the diameters of the shaft and the head, and the length of the
+// head in the scale, or along the x axis with the length, the width, and the height in the scale.
+func addArrow(m *mesh, marker *Marker) {
+	if len(marker.Points) >= 2 {
+		start, end := marker.Points[0], marker.Points[1]
+		length := end.sub(start).norm()
+		if length == 0 {
+			return
+		}
+
+		headLength := marker.Scale.Z
+		if headLength <= 0 || headLength > length {
+			headLength = 0.23 * length
+		}
+		headStart := start.add(end.sub(start).scale((length - headLength) / length))
+		m.addFrustum(start, headStart, marker.Scale.X/2, marker.Scale.X/2, marker.Color)
+		m.addFrustum(headStart, end, marker.Scale.Y/2, 0, marker.Color)
+		return
+	}
+
+	length := marker.Scale.X
+	headStart := Vector3{X: 0.77 * length}
+	base := uint32(len(m.positions))
+	m.addFrustum(Vector3{}, headStart, 0.5, 0.5, marker.Color)
+	m.addFrustum(headStart, Vector3{X: length}, 1, 0, marker.Color)
+	// the radii are scaled by the width and the height
+	for i := base; i < uint32(len(m.positions)); i++ {
+		m.positions[i].Y *= marker.Scale.Y / 2
+		m.positions[i].Z *= marker.Scale.Z / 2
+	}
+}
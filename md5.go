@@ -0,0 +1,74 @@
+package rosbag
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// MD5SumError is returned when the md5sum of a connection header doesn't match the md5sum of its
+// message definition, e.g. the definition of a message has changed without its md5sum.
+type MD5SumError struct {
+	Topic string
+	Type  string
+	// Declared is the md5sum of the connection header, and Computed is the md5sum of the message
+	// definition
+	Declared string
+	Computed string
+}
+
+func (err *MD5SumError) Error() string {
+	return fmt.Sprintf("connection on %s has the md5sum %s for %s, but its message definition has %s", err.Topic, err.Declared, err.Type, err.Computed)
+}
+
+// ValidateMD5Sum returns an MD5SumError when the md5sum of hdr doesn't match the md5sum of its
+// message definition. The "*" md5sum of the tools that accept any type, and the connections
+// without a message definition are always valid.
+func (hdr *ConnectionHeader) ValidateMD5Sum() error {
+	if hdr.MD5Sum == "*" || (len(hdr.MessageDefinition.Fields) == 0 && len(hdr.MessageDefinition.Constants) == 0) {
+		return nil
+	}
+
+	if sum := hdr.MessageDefinition.MD5Sum(); sum != hdr.MD5Sum {
+		return &MD5SumError{Topic: hdr.Topic, Type: hdr.Type, Declared: hdr.MD5Sum, Computed: sum}
+	}
+	return nil
+}
+
+// MD5Sum computes the md5sum of the message definition like genmsg does for ROS1 messages, so it
+// can be compared with the md5sum of a connection header. The md5sum is computed from the
+// constants and the fields without comments, and the nested message types are replaced by their
+// md5sums. The definition must be parsed from a raw message definition, since the declared types
+// of the builtin types are part of the md5sum, e.g. byte and int8 have different md5sums.
+func (def *MessageDefinition) MD5Sum() string {
+	return def.md5Sum(make(map[*MessageDefinition]string))
+}
+
+func (def *MessageDefinition) md5Sum(cache map[*MessageDefinition]string) string {
+	if sum, ok := cache[def]; ok {
+		return sum
+	}
+
+	var lines []string
+	for _, constant := range def.Constants {
+		typeName := constant.Type.String()
+		if constant.field != nil && constant.field.DeclaredType != "" {
+			typeName = constant.field.DeclaredType
+		}
+		lines = append(lines, fmt.Sprintf("%s %s=%s", typeName, constant.Name, strings.TrimSpace(constant.Raw)))
+	}
+
+	for _, field := range def.Fields {
+		if field.Type == MessageFieldTypeComplex && field.MsgType != nil {
+			// the array suffix of nested message types is not a part of the md5sum
+			lines = append(lines, fmt.Sprintf("%s %s", field.MsgType.md5Sum(cache), field.Name))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", field.rosType(), field.Name))
+	}
+
+	sum := md5.Sum([]byte(strings.Join(lines, "\n")))
+	cache[def] = hex.EncodeToString(sum[:])
+	return cache[def]
+}
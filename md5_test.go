@@ -0,0 +1,157 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMessageDefinitionMD5Sum(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	decoder := NewDecoder(f)
+	var checked int
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record, ok := record.(*RecordConnection); ok {
+			hdr, err := record.ConnectionHeader()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sum := hdr.MessageDefinition.MD5Sum(); sum != hdr.MD5Sum {
+				t.Fatalf("expected the md5sum of %s to be %s, but got %s", hdr.Type, hdr.MD5Sum, sum)
+			}
+			checked++
+		}
+		record.Close()
+	}
+
+	if checked == 0 {
+		t.Fatal("expected the example bag to have connections")
+	}
+}
+
+func TestMessageDefinitionMD5SumConstants(t *testing.T) {
+	// the constants of rosgraph_msgs/Log are declared as byte, and have trailing comments. This
+	// also runs when the example bag can't be decoded.
+	raw := `byte DEBUG=1 #debug level
+byte INFO=2  #general level
+byte WARN=4  #warning level
+byte ERROR=8 #error level
+byte FATAL=16 #fatal/critical level
+Header header
+byte level
+string name # name of the node
+string msg # message
+string file # file the message came from
+string function # function the message came from
+uint32 line # line the message came from
+string[] topics # topic names that the node publishes
+
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+	var def MessageDefinition
+	err := def.unmarshall([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum, expected := def.MD5Sum(), "acffd30cd6b6de30f120938c17c593fb"; sum != expected {
+		t.Fatalf("expected %s, but got %s", expected, sum)
+	}
+}
+
+func TestWriterMD5Sum(t *testing.T) {
+	raw := writeTestBag(t, withTestValues(1), withTestWriter(WithChunkSize(1)))
+	findings, err := Check(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the computed md5sum to be valid, but got %v", findings)
+	}
+}
+
+func TestDecoderMD5Validation(t *testing.T) {
+	writeBag := func(md5sum string) []byte {
+		var buf bytes.Buffer
+		writer := NewWriter(&buf)
+		conn, err := writer.WriteConnection(&ConnectionHeader{
+			Topic:                "/test",
+			Type:                 "test_msgs/Test",
+			MD5Sum:               md5sum,
+			RawMessageDefinition: "uint8 x",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.WriteMessage(conn, time.Unix(1, 0), []byte{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, md5sum := range []string{"", "*"} {
+		records := readAllRecords(t, NewDecoder(bytes.NewReader(writeBag(md5sum)), WithMD5Validation()))
+		if len(records) == 0 {
+			t.Fatalf("expected the records of the bag with %q to be read", md5sum)
+		}
+	}
+
+	raw := writeBag("0123456789abcdef0123456789abcdef")
+	for _, decoder := range []*Decoder{
+		NewDecoder(bytes.NewReader(raw), WithMD5Validation()),
+		NewDecoderBytes(raw, WithMD5Validation()),
+	} {
+		var err error
+		for err == nil {
+			var record Record
+			record, err = decoder.Read()
+			if record != nil {
+				record.Close()
+			}
+		}
+
+		var md5Err *MD5SumError
+		if !errors.As(err, &md5Err) {
+			t.Fatalf("expected an MD5SumError, but got %v", err)
+		}
+
+		expected := MD5SumError{
+			Topic:    "/test",
+			Type:     "test_msgs/Test",
+			Declared: "0123456789abcdef0123456789abcdef",
+			Computed: "b7b8b5ba5a046619082c001d6588d6d8",
+		}
+		if *md5Err != expected {
+			t.Fatalf("expected %v, but got %v", &expected, md5Err)
+		}
+	}
+
+	// without the option, the mismatch is only reported by Check
+	readAllRecords(t, NewDecoder(bytes.NewReader(raw)))
+}
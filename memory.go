@@ -0,0 +1,213 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrMemoryBudget is matched by the errors of decoders that would exceed their MemoryBudget.
+	ErrMemoryBudget = errors.New("memory budget is exceeded")
+)
+
+// MemoryUsage is the memory of the buffers that are held by a Decoder. It doesn't include the
+// values that are viewed from the records, or the internal buffers of the decompressors of a
+// streaming decoder.
+type MemoryUsage struct {
+	// Records is the size of the pooled buffers of the records that have been read, and have not
+	// been closed yet. The records of NewDecoderBytes are sliced from the bag, so they don't hold
+	// any buffer.
+	Records int64
+	// Chunks is the size of the decompressed chunks, i.e. the chunks that are prefetched, and the
+	// buffers that the prefetcher keeps for the next chunks, or the current compressed chunk of
+	// NewDecoderBytes.
+	Chunks int64
+	// ReadBuffer is the size of the buffer that wraps the reader, see WithBufferSize.
+	ReadBuffer int64
+}
+
+// Total returns the sum of the memory of every kind of buffer.
+func (usage MemoryUsage) Total() int64 {
+	return usage.Records + usage.Chunks + usage.ReadBuffer
+}
+
+// MemoryBudget limits the memory of the record and chunk buffers of the decoders that share it,
+// so a service that decodes many bags concurrently can reject work instead of running out of
+// memory. The read buffers are fixed when the decoders are created, so they are not reserved from
+// the budget. A MemoryBudget is safe for concurrent use.
+type MemoryBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+// NewMemoryBudget creates a budget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Limit returns the size of the budget in bytes.
+func (budget *MemoryBudget) Limit() int64 {
+	return budget.limit
+}
+
+// Used returns the memory in bytes that is reserved by the decoders.
+func (budget *MemoryBudget) Used() int64 {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.used
+}
+
+// Available returns the memory in bytes that can still be reserved, e.g. to decide whether a new
+// bag can be admitted.
+func (budget *MemoryBudget) Available() int64 {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.limit - budget.used
+}
+
+// reserve reserves n bytes, or fails with a MemoryBudgetError when they're not available. A nil
+// budget doesn't limit anything.
+func (budget *MemoryBudget) reserve(n int64) error {
+	if budget == nil {
+		return nil
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if budget.used+n > budget.limit {
+		return &MemoryBudgetError{Limit: budget.limit, Used: budget.used, Required: n}
+	}
+	budget.used += n
+	return nil
+}
+
+func (budget *MemoryBudget) release(n int64) {
+	if budget == nil {
+		return
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.used -= n
+}
+
+// MemoryBudgetError is returned by Decoder.Read when a buffer can't be reserved from the budget of
+// the decoder. The decoder can't continue after it, since the record has been partially read. It
+// matches ErrMemoryBudget with errors.Is.
+type MemoryBudgetError struct {
+	// Limit is the size of the budget.
+	Limit int64
+	// Used is the memory that was reserved from the budget when the buffer was requested.
+	Used int64
+	// Required is the size of the buffer.
+	Required int64
+}
+
+func (err *MemoryBudgetError) Error() string {
+	return fmt.Sprintf("%s, %d bytes are required, but only %d of %d bytes are available", ErrMemoryBudget, err.Required, err.Limit-err.Used, err.Limit)
+}
+
+// Is reports whether target is ErrMemoryBudget.
+func (err *MemoryBudgetError) Is(target error) bool {
+	return target == ErrMemoryBudget
+}
+
+// memoryAccount counts the memory of the buffers of a decoder, and reserves it from the budget of
+// the decoder. Records can be closed, and chunks can be prefetched, in other goroutines than the
+// one that reads the decoder.
+type memoryAccount struct {
+	mu         sync.Mutex
+	budget     *MemoryBudget
+	records    int64
+	chunks     int64
+	readBuffer int64
+	// closed marks that the chunks have been released by Decoder.Close
+	closed bool
+}
+
+func (account *memoryAccount) reserveRecords(n int64) error {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	err := account.budget.reserve(n)
+	if err != nil {
+		return err
+	}
+	account.records += n
+	return nil
+}
+
+func (account *memoryAccount) releaseRecords(n int64) {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	account.budget.release(n)
+	account.records -= n
+}
+
+func (account *memoryAccount) reserveChunks(n int64) error {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if account.closed {
+		// the prefetcher is stopping, its buffers are not used anymore
+		return nil
+	}
+
+	err := account.budget.reserve(n)
+	if err != nil {
+		return err
+	}
+	account.chunks += n
+	return nil
+}
+
+func (account *memoryAccount) releaseChunks(n int64) {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if account.closed {
+		return
+	}
+	account.budget.release(n)
+	account.chunks -= n
+}
+
+// close releases the chunks, the records are still released when they are closed.
+func (account *memoryAccount) close() {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if account.closed {
+		return
+	}
+	account.budget.release(account.chunks)
+	account.chunks = 0
+	account.closed = true
+}
+
+func (account *memoryAccount) usage() MemoryUsage {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	return MemoryUsage{
+		Records:    account.records,
+		Chunks:     account.chunks,
+		ReadBuffer: account.readBuffer,
+	}
+}
+
+// MemoryUsage returns the memory of the buffers that are held by the decoder, see MemoryUsage.
+func (decoder *Decoder) MemoryUsage() MemoryUsage {
+	return decoder.memory.usage()
+}
+
+// growRecord grows the buffer of record like RecordBase.grow, and reserves the new memory.
+func (decoder *Decoder) growRecord(record *RecordBase, requiredSize uint32) error {
+	if uint32(len(record.Raw)) >= requiredSize {
+		return nil
+	}
+
+	err := decoder.memory.reserveRecords(2*int64(requiredSize) - int64(len(record.Raw)))
+	if err != nil {
+		return err
+	}
+	record.grow(requiredSize)
+	return nil
+}
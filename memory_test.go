@@ -0,0 +1,141 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+const memoryTestMessageSize = 10000
+
+func writeMemoryTestBag(t *testing.T, messages int) []byte {
+	data := make([]byte, lenInBytes+memoryTestMessageSize)
+	endian.PutUint32(data, memoryTestMessageSize)
+	records := make([]testRecord, messages)
+	for i := range records {
+		records[i] = testRecord{Time: time.Unix(int64(i), 0), Data: data}
+	}
+
+	return writeTestBag(t, withTestConns(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8[] data",
+	}), withTestRecords(records...))
+}
+
+func TestDecoderMemoryUsage(t *testing.T) {
+	budget := NewMemoryBudget(1 << 20)
+	decoder := NewDecoder(bytes.NewReader(writeMemoryTestBag(t, 3)), WithPrefetch(), WithMemoryBudget(budget))
+
+	var held Record
+	for held == nil {
+		record, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := record.(*RecordMessageData); ok {
+			held = record
+		} else {
+			record.Close()
+		}
+	}
+
+	usage := decoder.MemoryUsage()
+	if usage.Records < memoryTestMessageSize {
+		t.Fatalf("expected the held record to be counted, but got %+v", usage)
+	}
+
+	if usage.Chunks < 3*memoryTestMessageSize {
+		t.Fatalf("expected the prefetched chunk to be counted, but got %+v", usage)
+	}
+
+	if usage.ReadBuffer != defaultBufferSize {
+		t.Fatalf("expected the read buffer to be %d bytes, but got %+v", defaultBufferSize, usage)
+	}
+
+	if budget.Used() != usage.Records+usage.Chunks {
+		t.Fatalf("expected %d bytes to be reserved, but got %d", usage.Records+usage.Chunks, budget.Used())
+	}
+
+	held.Close()
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Close()
+	}
+	decoder.Close()
+
+	usage = decoder.MemoryUsage()
+	if usage.Records != 0 || usage.Chunks != 0 {
+		t.Fatalf("expected every buffer to be released, but got %+v", usage)
+	}
+
+	if budget.Used() != 0 || budget.Available() != budget.Limit() {
+		t.Fatalf("expected the budget to be free, but %d bytes are used", budget.Used())
+	}
+}
+
+func TestDecoderMemoryBudget(t *testing.T) {
+	bag := writeMemoryTestBag(t, 3)
+	tests := []struct {
+		name    string
+		decoder func(budget *MemoryBudget) *Decoder
+	}{
+		{
+			name: "Stream",
+			decoder: func(budget *MemoryBudget) *Decoder {
+				return NewDecoder(bytes.NewReader(bag), WithMemoryBudget(budget))
+			},
+		},
+		{
+			name: "Prefetch",
+			decoder: func(budget *MemoryBudget) *Decoder {
+				return NewDecoder(bytes.NewReader(bag), WithPrefetch(), WithMemoryBudget(budget))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			budget := NewMemoryBudget(2 * initialRecordSize)
+			decoder := test.decoder(budget)
+			defer decoder.Close()
+
+			var err error
+			for err == nil {
+				var record Record
+				record, err = decoder.Read()
+				if err == nil {
+					record.Close()
+				}
+			}
+
+			var budgetErr *MemoryBudgetError
+			if !errors.Is(err, ErrMemoryBudget) || !errors.As(err, &budgetErr) {
+				t.Fatalf("expected the budget to be exceeded, but got %v", err)
+			}
+
+			if budgetErr.Limit != budget.Limit() || budgetErr.Required <= budgetErr.Limit-budgetErr.Used {
+				t.Fatalf("expected the required memory to exceed the budget, but got %+v", budgetErr)
+			}
+
+			_, err = decoder.Read()
+			if !errors.Is(err, ErrMemoryBudget) {
+				t.Fatalf("expected the decoder to keep failing, but got %v", err)
+			}
+
+			if budget.Used() != 0 {
+				t.Fatalf("expected the budget to be free after the records are closed, but %d bytes are used", budget.Used())
+			}
+		})
+	}
+}
@@ -38,6 +38,9 @@ const (
 	MessageFieldTypeTime
 	MessageFieldTypeDuration
 	MessageFieldTypeComplex
+	// MessageFieldTypeWString is the ROS2 wide string. It's added after MessageFieldTypeComplex
+	// to keep the values of the existing types
+	MessageFieldTypeWString
 )
 
 var (
@@ -56,22 +59,119 @@ var (
 		"float32":  MessageFieldTypeFloat32,
 		"float64":  MessageFieldTypeFloat64,
 		"string":   MessageFieldTypeString,
+		"wstring":  MessageFieldTypeWString,
 		"time":     MessageFieldTypeTime,
 		"duration": MessageFieldTypeDuration,
 	}
 )
 
+var (
+	messageFieldTypeNames = map[MessageFieldType]string{
+		MessageFieldTypeBool:     "bool",
+		MessageFieldTypeInt8:     "int8",
+		MessageFieldTypeUint8:    "uint8",
+		MessageFieldTypeInt16:    "int16",
+		MessageFieldTypeUint16:   "uint16",
+		MessageFieldTypeInt32:    "int32",
+		MessageFieldTypeUint32:   "uint32",
+		MessageFieldTypeInt64:    "int64",
+		MessageFieldTypeUint64:   "uint64",
+		MessageFieldTypeFloat32:  "float32",
+		MessageFieldTypeFloat64:  "float64",
+		MessageFieldTypeString:   "string",
+		MessageFieldTypeTime:     "time",
+		MessageFieldTypeDuration: "duration",
+		MessageFieldTypeComplex:  "complex",
+		MessageFieldTypeWString:  "wstring",
+	}
+)
+
+// String returns the ROS name of the builtin type, e.g. "float64". Complex types are named
+// "complex" since the actual name is stored in MessageFieldDefinition.MsgType.
+func (fieldType MessageFieldType) String() string {
+	name, ok := messageFieldTypeNames[fieldType]
+	if !ok {
+		return fmt.Sprintf("MessageFieldType(%d)", uint8(fieldType))
+	}
+	return name
+}
+
+// FieldError is returned by ViewAs when a message field can't be assigned to the corresponding
+// struct field. It can be extracted with errors.As.
+type FieldError struct {
+	// Path is the path of the field from the root message, e.g. "pose.position.x" or "poses[2].x"
+	Path string
+	// ROSType is the declared ROS type of the field, e.g. "float64" or "geometry_msgs/Point[]"
+	ROSType string
+	// GoType is the type of the struct field that the message field is decoded into
+	GoType reflect.Type
+	// Topic and MessageType describe the connection of the message. They're empty when the
+	// message is not decoded from a record.
+	Topic       string
+	MessageType string
+}
+
+func (err *FieldError) Error() string {
+	msg := fmt.Sprintf("message field %s (%s) can't be decoded into the struct field of type %s", err.Path, err.ROSType, err.GoType)
+	if err.MessageType != "" {
+		msg += fmt.Sprintf(", message type %s on topic %s", err.MessageType, err.Topic)
+	}
+	return msg
+}
+
+// LengthError is returned by ViewAs when an array or a string is longer than the maxlen option
+// of its struct field, e.g. `rosbag:"points,maxlen=10000"`.
+type LengthError struct {
+	// Path is the path of the field from the root message, e.g. "points" or "scans[1].ranges"
+	Path   string
+	Length int
+	MaxLen int
+}
+
+func (err *LengthError) Error() string {
+	return fmt.Sprintf("message field %s has a length of %d, which exceeds the maxlen of %d", err.Path, err.Length, err.MaxLen)
+}
+
+// prefixFieldError prepends prefix to the path of err when err is a FieldError or a LengthError.
+func prefixFieldError(err error, prefix string) error {
+	var path *string
+	switch err := err.(type) {
+	case *FieldError:
+		path = &err.Path
+	case *LengthError:
+		path = &err.Path
+	default:
+		return err
+	}
+
+	if strings.HasPrefix(*path, "[") {
+		*path = prefix + *path
+	} else {
+		*path = prefix + "." + *path
+	}
+	return err
+}
+
 type ConnectionHeader struct {
 	Topic             string
 	Type              string
 	MD5Sum            string
 	MessageDefinition MessageDefinition
+	// RawMessageDefinition is the message definition text that MessageDefinition is parsed from
+	RawMessageDefinition string
 }
 
 // MessageDefinition is defined here, http://wiki.ros.org/msg
 type MessageDefinition struct {
-	Type   string
+	Type string
+	// Fields are the fields that are serialized in the message data, in their wire order
 	Fields []*MessageFieldDefinition
+	// Constants are the constants of the message, e.g. "uint8 MODE=1". They're not serialized in
+	// the message data
+	Constants []*MessageConstant
+	// Comments are the comment lines of the message that are not right above a field, e.g. the
+	// description at the top of a .msg file that is followed by an empty line
+	Comments []string
 }
 
 // decodeConstValue decodes raw to concrete type. Raw is expected to be in ASCII.
@@ -111,42 +211,96 @@ func decodeConstValue(fieldType MessageFieldType, raw []byte) (interface{}, erro
 		return float32(v), err
 	case MessageFieldTypeFloat64:
 		return strconv.ParseFloat(rawStr, 64)
-	case MessageFieldTypeString:
+	case MessageFieldTypeString, MessageFieldTypeWString:
 		return rawStr, nil
 	default:
 		return nil, errInvalidConstType
 	}
 }
 
+// decodeDefaultValue decodes the ROS2 default value of a field. String defaults can be quoted,
+// and array defaults are written as a list, e.g. [1, 2, 3] or ["a", "b"].
+func decodeDefaultValue(fieldType MessageFieldType, isArray bool, raw []byte) (interface{}, error) {
+	if !isArray {
+		return decodeConstValue(fieldType, unquote(raw))
+	}
+
+	if len(raw) < 2 || raw[0] != '[' || raw[len(raw)-1] != ']' {
+		return nil, errInvalidFormat
+	}
+
+	raw = bytes.TrimSpace(raw[1 : len(raw)-1])
+	if len(raw) == 0 {
+		return []interface{}{}, nil
+	}
+
+	var values []interface{}
+	for _, rawValue := range bytes.Split(raw, []byte(",")) {
+		v, err := decodeConstValue(fieldType, unquote(bytes.TrimSpace(rawValue)))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// unquote removes the surrounding single or double quotes of raw if there are any.
+func unquote(raw []byte) []byte {
+	if len(raw) >= 2 && (raw[0] == '"' || raw[0] == '\'') && raw[len(raw)-1] == raw[0] {
+		return raw[1 : len(raw)-1]
+	}
+	return raw
+}
+
 func (def *MessageDefinition) unmarshall(b []byte) error {
 	var err error
 	lines := bytes.Split(b, []byte("\n"))
 	unresolvedFields := make(map[*MessageFieldDefinition][]byte)
 	complexMsgs := []*MessageDefinition{def}
 
-	for _, line := range lines {
+	// comments are the comment lines that haven't been attached to a field yet
+	var comments []string
+	flushComments := func() {
+		complexMsg := complexMsgs[len(complexMsgs)-1]
+		complexMsg.Comments = append(complexMsg.Comments, comments...)
+		comments = nil
+	}
+
+	for i, rawLine := range lines {
+		line := rawLine
+
 		// find comments
+		var comment []byte
 		idx := bytes.IndexByte(line, '#')
 		if idx != -1 {
+			comment = bytes.TrimSpace(line[idx+1:])
 			line = line[:idx]
 		}
 
 		// remove whitespaces
 		line = bytes.TrimSpace(line)
 
-		// these are usually comment lines, ignore
+		// comment lines right above a field are attached to the field, and the other comment
+		// lines are attached to the message
 		if len(line) == 0 {
+			if idx != -1 {
+				comments = append(comments, string(comment))
+			} else {
+				flushComments()
+			}
 			continue
 		}
 
 		// at this point, if there's a '=', it just means a separator, ignore
 		if line[0] == '=' {
+			flushComments()
 			continue
 		}
 
 		// detect if this is a complex message definition
-		idx = bytes.IndexByte(line, ':')
-		if idx != -1 {
+		if bytes.HasPrefix(line, []byte("MSG:")) {
+			flushComments()
 			idx = bytes.LastIndexByte(line, ' ')
 			msgType := line[idx+1:]
 			complexMsgs = append(complexMsgs, &MessageDefinition{Type: string(msgType)})
@@ -160,11 +314,17 @@ func (def *MessageDefinition) unmarshall(b []byte) error {
 		idx = bytes.IndexByte(fieldType, '[')
 		var isArray bool
 		var arraySize int = -1
+		var arrayBound int
 		if idx != -1 {
 			off := bytes.IndexByte(fieldType[idx:], ']')
 			if off > 1 {
 				arraySizeRaw := fieldType[idx+1 : idx+off]
-				arraySize, err = strconv.Atoi(string(arraySizeRaw))
+				// ROS2 bounded sequence, e.g. int32[<=5]
+				if bytes.HasPrefix(arraySizeRaw, []byte("<=")) {
+					arrayBound, err = strconv.Atoi(string(arraySizeRaw[2:]))
+				} else {
+					arraySize, err = strconv.Atoi(string(arraySizeRaw))
+				}
 				if err != nil {
 					return err
 				}
@@ -174,28 +334,78 @@ func (def *MessageDefinition) unmarshall(b []byte) error {
 			isArray = true
 		}
 
-		// detect constant
-		idx = bytes.IndexByte(fieldName, '=')
+		// ROS2 bounded string, e.g. string<=10
+		var stringBound int
+		idx = bytes.Index(fieldType, []byte("<="))
+		if idx != -1 {
+			stringBound, err = strconv.Atoi(string(fieldType[idx+2:]))
+			if err != nil {
+				return err
+			}
+			fieldType = fieldType[:idx]
+		}
+
 		msgFieldType, ok := messageFieldTypeMap[string(fieldType)]
 		if !ok {
 			msgFieldType = MessageFieldTypeComplex
 		}
 
-		var constantValue interface{}
+		// detect constant, e.g. "int32 X=1", or ROS2 default value, e.g. "int32 x 1"
+		var constantValue, defaultValue interface{}
+		var isConstant bool
+		var rawConstant []byte
+		idx = bytes.IndexAny(fieldName, " \t=")
 		if idx != -1 {
-			// TODO: parse this constantValue
-			constantValue, err = decodeConstValue(msgFieldType, bytes.TrimSpace(fieldName[idx+1:]))
-			fieldName = bytes.TrimSpace(fieldName[:idx])
+			rawValue := bytes.TrimSpace(fieldName[idx:])
+			fieldName = fieldName[:idx]
 
+			if rawValue[0] == '=' {
+				isConstant = true
+				rawConstant = bytes.TrimSpace(rawValue[1:])
+				// TODO: parse this constantValue
+				constantValue, err = decodeConstValue(msgFieldType, rawConstant)
+			} else {
+				defaultValue, err = decodeDefaultValue(msgFieldType, isArray, rawValue)
+				if err != nil {
+					return err
+				}
+			}
 		}
 
 		complexMsg := complexMsgs[len(complexMsgs)-1]
 		fieldDef := MessageFieldDefinition{
-			Type:      msgFieldType,
-			Name:      string(fieldName),
-			IsArray:   isArray,
-			ArraySize: arraySize,
-			Value:     constantValue,
+			Type:         msgFieldType,
+			DeclaredType: string(fieldType),
+			Name:         string(fieldName),
+			IsArray:      isArray,
+			ArraySize:    arraySize,
+			ArrayBound:   arrayBound,
+			StringBound:  stringBound,
+			Value:        constantValue,
+			Default:      defaultValue,
+			Line:         i + 1,
+			RawLine:      string(bytes.TrimRight(rawLine, "\r")),
+			Comments:     comments,
+		}
+		comments = nil
+
+		if comment != nil {
+			fieldDef.Comment = string(comment)
+		}
+
+		if isConstant {
+			complexMsg.Constants = append(complexMsg.Constants, &MessageConstant{
+				Type:     fieldDef.Type,
+				Name:     fieldDef.Name,
+				Value:    fieldDef.Value,
+				Raw:      string(rawConstant),
+				Line:     fieldDef.Line,
+				RawLine:  fieldDef.RawLine,
+				Comment:  fieldDef.Comment,
+				Comments: fieldDef.Comments,
+				field:    &fieldDef,
+			})
+			continue
 		}
 
 		if fieldDef.Type == MessageFieldTypeComplex {
@@ -204,6 +414,8 @@ func (def *MessageDefinition) unmarshall(b []byte) error {
 		complexMsg.Fields = append(complexMsg.Fields, &fieldDef)
 	}
 
+	flushComments()
+
 	for field, msgType := range unresolvedFields {
 		msgDef := findComplexMsg(complexMsgs, string(msgType))
 		if msgDef == nil {
@@ -220,13 +432,173 @@ type MessageFieldDefinition struct {
 	Type    MessageFieldType
 	Name    string
 	IsArray bool
+	// DeclaredType is the type name as it's written in the message definition without the array
+	// and the string bounds, e.g. "byte", "float64", or "Header"
+	DeclaredType string
 	// ArraySize is only used when the field is a fixed-size array. If it's a slice, ArraySize is -1
 	ArraySize int
-	// Value is an optional field. It's only being used for constants
+	// ArrayBound is the upper bound of a ROS2 bounded sequence, e.g. int32[<=5]. It's 0 when the
+	// array is not bounded
+	ArrayBound int
+	// StringBound is the maximum length of a ROS2 bounded string, e.g. string<=10. It's 0 when the
+	// string is not bounded
+	StringBound int
+	// Value is the value of a constant. Constants are in MessageDefinition.Constants, so Value is
+	// always nil for the fields in MessageDefinition.Fields. The fields with a Value of definitions
+	// that are built by hand are moved to Constants by Writer.WriteConnection and CompilePlan
 	Value interface{}
+	// Default is the ROS2 default value of the field, e.g. "int32 x 5". Defaults of arrays are
+	// stored as []interface{}. It's nil when the field has no default value
+	Default interface{}
 	// MsgType is only being used when type is complex. This defines the custom
 	// message type.
 	MsgType *MessageDefinition
+	// Line is the line number of the field in the raw message definition, starting from 1
+	Line int
+	// RawLine is the original line of the raw message definition that the field is parsed from
+	RawLine string
+	// Comment is the comment at the end of the field line, without the '#'
+	Comment string
+	// Comments are the comment lines right above the field, without the '#'
+	Comments []string
+}
+
+// MessageConstant is a constant of a message, e.g. "uint8 MODE=1".
+type MessageConstant struct {
+	Type MessageFieldType
+	Name string
+	// Value is the decoded value of the constant, e.g. uint8(1). It's nil when Raw can't be decoded
+	// to Type
+	Value interface{}
+	// Raw is the raw text of the value, e.g. "1"
+	Raw string
+	// Line is the line number of the constant in the raw message definition, starting from 1
+	Line int
+	// RawLine is the original line of the raw message definition that the constant is parsed from
+	RawLine string
+	// Comment is the comment at the end of the constant line, without the '#'
+	Comment string
+	// Comments are the comment lines right above the constant, without the '#'
+	Comments []string
+
+	// field is the constant as a field, so that it can be set like the other fields by the decoder
+	field *MessageFieldDefinition
+}
+
+// asField returns the constant as a field that has Value.
+func (constant *MessageConstant) asField() *MessageFieldDefinition {
+	if constant.field != nil {
+		return constant.field
+	}
+
+	// the constant is not parsed by unmarshall, the definition is not modified since it can be
+	// shared by multiple decoders
+	return &MessageFieldDefinition{
+		Type:      constant.Type,
+		Name:      constant.Name,
+		ArraySize: -1,
+		Value:     constant.Value,
+	}
+}
+
+// ParseMessageDefinition parses raw, the message definition of the message type msgType in the
+// format of the message_definition field of connection headers: the root message, followed by a
+// "MSG: <type>" section for every nested message type, e.g. the output of gendeps --cat.
+func ParseMessageDefinition(msgType string, raw string) (*MessageDefinition, error) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	// the type is set after the nested types are resolved, so it's not matched by their names
+	def.Type = msgType
+	return &def, nil
+}
+
+// Constant returns the constant of the message with the given name.
+func (def *MessageDefinition) Constant(name string) (*MessageConstant, bool) {
+	for _, constant := range def.Constants {
+		if constant.Name == name {
+			return constant, true
+		}
+	}
+	return nil, false
+}
+
+// normalizeConstants moves the fields that have a Value to Constants in def and its nested
+// messages. Parsed definitions never have constants in Fields, but the definitions that are built
+// by hand can, so they're normalized once when they're registered, and Fields only has wire fields
+// for everything that walks them.
+func (def *MessageDefinition) normalizeConstants() {
+	seen := make(map[*MessageDefinition]bool)
+	var normalize func(def *MessageDefinition)
+	normalize = func(def *MessageDefinition) {
+		if seen[def] {
+			return
+		}
+		seen[def] = true
+
+		// the normalized definitions are only read, so they can be shared by goroutines
+		var moved bool
+		for _, field := range def.Fields {
+			if field.Type == MessageFieldTypeComplex && field.MsgType != nil {
+				normalize(field.MsgType)
+			}
+			moved = moved || field.Value != nil
+		}
+
+		if !moved {
+			return
+		}
+
+		fields := make([]*MessageFieldDefinition, 0, len(def.Fields))
+		for _, field := range def.Fields {
+			if field.Value == nil {
+				fields = append(fields, field)
+				continue
+			}
+
+			def.Constants = append(def.Constants, &MessageConstant{
+				Type:     field.Type,
+				Name:     field.Name,
+				Value:    field.Value,
+				Raw:      fmt.Sprint(field.Value),
+				Line:     field.Line,
+				RawLine:  field.RawLine,
+				Comment:  field.Comment,
+				Comments: field.Comments,
+				field:    field,
+			})
+		}
+		def.Fields = fields
+	}
+	normalize(def)
+}
+
+// rosType returns the declared ROS type of the field, e.g. "uint8[3]" or "std_msgs/Header".
+func (field *MessageFieldDefinition) rosType() string {
+	name := field.Type.String()
+	if field.Type == MessageFieldTypeComplex && field.MsgType != nil {
+		name = field.MsgType.Type
+	} else if field.DeclaredType != "" {
+		name = field.DeclaredType
+	}
+
+	if field.StringBound > 0 {
+		name = fmt.Sprintf("%s<=%d", name, field.StringBound)
+	}
+
+	switch {
+	case !field.IsArray:
+		return name
+	case field.ArrayBound > 0:
+		return fmt.Sprintf("%s[<=%d]", name, field.ArrayBound)
+	case field.ArraySize < 0:
+		return name + "[]"
+	default:
+		return fmt.Sprintf("%s[%d]", name, field.ArraySize)
+	}
 }
 
 // findComplexMsg iterates complexMsgs, and find for msgType. msgType can have an optional
@@ -240,20 +612,91 @@ func findComplexMsg(complexMsgs []*MessageDefinition, msgType string) *MessageDe
 	return nil
 }
 
-func createFieldMapper(structValue reflect.Value, mapper map[string]reflect.Value) {
+// structFieldOptions are the options of a struct field that follow the name in the rosbag struct
+// tag, e.g. `rosbag:"data,omit"`.
+type structFieldOptions struct {
+	// omit skips the message field without decoding it, the struct field is left as is
+	omit bool
+	// copy copies strings and arrays out of the message data, so that they can be used after the
+	// record is closed
+	copy bool
+	// maxLen is the maximum length of an array or a string. It's 0 when there's no limit
+	maxLen int
+}
+
+type structField struct {
+	value   reflect.Value
+	options structFieldOptions
+}
+
+// parseStructTag parses the rosbag struct tag of field. ok is false when the field is ignored
+// with `rosbag:"-"`.
+func parseStructTag(field reflect.StructField) (name string, options structFieldOptions, ok bool, err error) {
+	tag, hasTag := field.Tag.Lookup(rosbagStructTag)
+	if !hasTag {
+		return field.Name, options, true, nil
+	}
+
+	if tag == "-" {
+		return "", options, false, nil
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+
+	for _, option := range parts[1:] {
+		switch {
+		case option == "omit":
+			options.omit = true
+		case option == "copy":
+			options.copy = true
+		case strings.HasPrefix(option, "maxlen="):
+			options.maxLen, err = strconv.Atoi(strings.TrimPrefix(option, "maxlen="))
+			if err != nil || options.maxLen <= 0 {
+				return "", options, false, fmt.Errorf("invalid maxlen in the rosbag tag of %s: %q", field.Name, tag)
+			}
+		default:
+			return "", options, false, fmt.Errorf("unknown option in the rosbag tag of %s: %q", field.Name, option)
+		}
+	}
+
+	return name, options, true, nil
+}
+
+func createFieldMapper(structValue reflect.Value, mapper map[string]structField) error {
 	structType := structValue.Type()
 	for i := 0; i < structType.NumField(); i++ {
 		field := structType.Field(i)
-		fieldName, ok := field.Tag.Lookup(rosbagStructTag)
+		fieldName, options, ok, err := parseStructTag(field)
+		if err != nil {
+			return err
+		}
+
 		if !ok {
-			fieldName = field.Name
+			continue
 		}
 
-		mapper[fieldName] = structValue.Field(i)
+		mapper[fieldName] = structField{value: structValue.Field(i), options: options}
 	}
+	return nil
+}
+
+// viewConfig holds the optional behaviors of ViewAs, which apply to the nested messages too.
+type viewConfig struct {
+	interner *Interner
+	arena    *Arena
+	// widened are the fields of a migrated definition that are converted to their struct fields
+	widened map[*MessageFieldDefinition]bool
 }
 
 func decodeMessageData(def *MessageDefinition, raw []byte, data interface{}) ([]byte, error) {
+	return decodeMessageDataConfig(def, raw, data, viewConfig{})
+}
+
+func decodeMessageDataConfig(def *MessageDefinition, raw []byte, data interface{}, config viewConfig) ([]byte, error) {
 	var err error
 
 	value := reflect.ValueOf(data)
@@ -262,96 +705,177 @@ func decodeMessageData(def *MessageDefinition, raw []byte, data interface{}) ([]
 		value = reflect.Indirect(value)
 	}
 
-	var getFn func(string) reflect.Value
-	var getFieldTypeFn func(string) reflect.Type
-	var setFn func(string, interface{}) error
+	var getFn func(*MessageFieldDefinition) reflect.Value
+	var getFieldTypeFn func(*MessageFieldDefinition) reflect.Type
+	var setFn func(*MessageFieldDefinition, interface{}) error
+	getOptionsFn := func(*MessageFieldDefinition) structFieldOptions {
+		return structFieldOptions{}
+	}
 	switch value.Kind() {
 	case reflect.Map:
 		m := data.(map[string]interface{})
-		setFn = func(k string, v interface{}) error {
-			m[k] = v
+		setFn = func(field *MessageFieldDefinition, v interface{}) error {
+			// registered types are decoded through a pointer, but they're stored as values
+			if field.Type == MessageFieldTypeComplex && !field.IsArray {
+				if reflectValue := reflect.ValueOf(v); reflectValue.Kind() == reflect.Ptr {
+					v = reflectValue.Elem().Interface()
+				}
+			}
+
+			m[field.Name] = v
 			return nil
 		}
-		getFn = func(k string) reflect.Value {
-			return reflect.ValueOf(make(map[string]interface{}))
+		getFn = func(field *MessageFieldDefinition) reflect.Value {
+			if t, ok := registeredType(field.MsgType.Type); ok {
+				return reflect.New(t)
+			}
+			return reflect.ValueOf(config.arena.newMap())
 		}
-		getFieldTypeFn = func(k string) reflect.Type {
+		getFieldTypeFn = func(field *MessageFieldDefinition) reflect.Type {
+			if t, ok := registeredType(field.MsgType.Type); ok {
+				return reflect.SliceOf(t)
+			}
+
 			var m map[string]interface{}
 			return reflect.SliceOf(reflect.TypeOf(m))
 		}
 	case reflect.Struct:
-		mapper := make(map[string]reflect.Value)
-		createFieldMapper(value, mapper)
-		setFn = func(k string, v interface{}) error {
-			fieldValue, ok := mapper[k]
+		mapper := make(map[string]structField)
+		err = createFieldMapper(value, mapper)
+		if err != nil {
+			return nil, err
+		}
+
+		setFn = func(field *MessageFieldDefinition, v interface{}) error {
+			mapped, ok := mapper[field.Name]
 			if !ok {
 				return nil
 			}
+			fieldValue := mapped.value
 
 			reflectValue := reflect.ValueOf(v)
+			if config.widened[field] && reflectValue.Type() != fieldValue.Type() {
+				var ok bool
+				reflectValue, ok = widenValue(reflectValue, fieldValue.Type())
+				if !ok {
+					return &FieldError{
+						Path:    field.Name,
+						ROSType: field.rosType(),
+						GoType:  fieldValue.Type(),
+					}
+				}
+			}
+
 			if reflectValue.Kind() != fieldValue.Kind() {
-				return fmt.Errorf("message field %s is %s, but the struct field is %s", k, reflectValue.Kind(), fieldValue.Kind())
+				return &FieldError{
+					Path:    field.Name,
+					ROSType: field.rosType(),
+					GoType:  fieldValue.Type(),
+				}
 			}
 
 			fieldValue.Set(reflectValue)
 			return nil
 		}
-		getFn = func(k string) reflect.Value {
-			fieldValue, ok := mapper[k]
+		getFn = func(field *MessageFieldDefinition) reflect.Value {
+			mapped, ok := mapper[field.Name]
 			if !ok {
 				// TODO: To keep the decoder keeps reading, we need to create this dummy map
 				return reflect.ValueOf(make(map[string]interface{}))
 			}
 
-			return fieldValue
+			return mapped.value
 		}
-		getFieldTypeFn = func(k string) reflect.Type {
-			fieldValue, ok := mapper[k]
+		getFieldTypeFn = func(field *MessageFieldDefinition) reflect.Type {
+			mapped, ok := mapper[field.Name]
 			if !ok {
 				var m map[string]interface{}
 				return reflect.SliceOf(reflect.TypeOf(m))
 			}
 
-			// TODO: Add slice type check
-			return fieldValue.Type()
+			return mapped.value.Type()
+		}
+		getOptionsFn = func(field *MessageFieldDefinition) structFieldOptions {
+			return mapper[field.Name].options
 		}
 	default:
 		return nil, errInvalidDataType
 	}
 
+	// Const value, no need to parse, simply fill in the data
+	for _, constant := range def.Constants {
+		if constant.Value == nil {
+			continue
+		}
+
+		err = setFn(constant.asField(), constant.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var v interface{}
 	for _, field := range def.Fields {
-		// Const value, no need to parse, simply fill in the data
-		if field.Value != nil {
-			v = field.Value
-		} else if field.Type != MessageFieldTypeComplex {
+		options := getOptionsFn(field)
+		if options.omit {
+			raw, err = skipFieldData(field, raw)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if options.maxLen > 0 {
+			err = checkFieldLength(field, raw, options.maxLen)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if field.Type != MessageFieldTypeComplex {
 			v, raw, err = decodeFieldBasic(field, raw)
 		} else if field.IsArray {
-			t := getFieldTypeFn(field.Name)
-			v, raw, err = decodeFieldComplexSlice(field, raw, t)
+			t := getFieldTypeFn(field)
+			if t.Kind() != reflect.Slice {
+				return nil, &FieldError{
+					Path:    field.Name,
+					ROSType: field.rosType(),
+					GoType:  t,
+				}
+			}
+			v, raw, err = decodeFieldComplexSlice(field, raw, t, config)
+			if err != nil {
+				return nil, prefixFieldError(err, field.Name)
+			}
 		} else {
-			reflectValue := getFn(field.Name)
+			reflectValue := getFn(field)
 			if reflectValue.CanAddr() {
 				// No need to set the field value since the change happens in place
 				reflectValue = reflectValue.Addr()
-				raw, err = decodeMessageData(field.MsgType, raw, reflectValue.Interface())
+				raw, err = decodeMessageDataConfig(field.MsgType, raw, reflectValue.Interface(), config)
 
 				// TODO: Probably should be flatenned this or refactor out
 				if err != nil {
-					return nil, err
+					return nil, prefixFieldError(err, field.Name)
 				}
 				continue
 			}
 
 			v = reflectValue.Interface()
-			raw, err = decodeMessageData(field.MsgType, raw, v)
+			raw, err = decodeMessageDataConfig(field.MsgType, raw, v, config)
 		}
 
 		if err != nil {
 			return nil, err
 		}
 
-		err = setFn(field.Name, v)
+		if config.interner.interns(field) {
+			v = config.interner.internValue(v)
+		} else if options.copy {
+			v = copyFieldValue(v)
+		}
+
+		err = setFn(field, v)
 		if err != nil {
 			return nil, err
 		}
@@ -376,7 +900,7 @@ func decodeFieldBasic(field *MessageFieldDefinition, raw []byte) (interface{}, [
 	return v, raw[off:], nil
 }
 
-func decodeFieldComplexSlice(field *MessageFieldDefinition, raw []byte, fieldType reflect.Type) (interface{}, []byte, error) {
+func decodeFieldComplexSlice(field *MessageFieldDefinition, raw []byte, fieldType reflect.Type, config viewConfig) (interface{}, []byte, error) {
 	var length int
 	var off int
 	var ok bool
@@ -391,7 +915,7 @@ func decodeFieldComplexSlice(field *MessageFieldDefinition, raw []byte, fieldTyp
 	for i := 0; i < length; i++ {
 		v := vs.Index(i)
 		if v.Kind() == reflect.Map {
-			v.Set(reflect.ValueOf(make(map[string]interface{})))
+			v.Set(reflect.ValueOf(config.arena.newMap()))
 		} else if v.CanAddr() { // struct value
 			v = v.Addr()
 		} else if v.IsNil() { // struct pointer
@@ -399,11 +923,127 @@ func decodeFieldComplexSlice(field *MessageFieldDefinition, raw []byte, fieldTyp
 		}
 
 		// No need to check types as it'll be checked by decodeMessageData
-		raw, err = decodeMessageData(field.MsgType, raw, v.Interface())
+		raw, err = decodeMessageDataConfig(field.MsgType, raw, v.Interface(), config)
 		if err != nil {
-			return nil, raw, err
+			return nil, raw, prefixFieldError(err, fmt.Sprintf("[%d]", i))
 		}
 	}
 
 	return vs.Interface(), raw, nil
 }
+
+// fixedFieldSizes are the serialized sizes of the builtin types that have a fixed size.
+var fixedFieldSizes = map[MessageFieldType]int{
+	MessageFieldTypeBool:     1,
+	MessageFieldTypeInt8:     1,
+	MessageFieldTypeUint8:    1,
+	MessageFieldTypeInt16:    2,
+	MessageFieldTypeUint16:   2,
+	MessageFieldTypeInt32:    4,
+	MessageFieldTypeUint32:   4,
+	MessageFieldTypeInt64:    8,
+	MessageFieldTypeUint64:   8,
+	MessageFieldTypeFloat32:  4,
+	MessageFieldTypeFloat64:  8,
+	MessageFieldTypeTime:     8,
+	MessageFieldTypeDuration: 8,
+}
+
+// skipFieldData returns raw after the serialized field without decoding it.
+func skipFieldData(field *MessageFieldDefinition, raw []byte) ([]byte, error) {
+	length := 1
+	if field.IsArray {
+		var off int
+		var ok bool
+		length, off, ok = fieldDecodeLength(raw, field.ArraySize)
+		if !ok {
+			return raw, errInvalidFormat
+		}
+		raw = raw[off:]
+	}
+
+	if size, ok := fixedFieldSizes[field.Type]; ok {
+		if len(raw) < length*size {
+			return raw, errInvalidFormat
+		}
+		return raw[length*size:], nil
+	}
+
+	var err error
+	for i := 0; i < length; i++ {
+		switch field.Type {
+		case MessageFieldTypeString, MessageFieldTypeWString:
+			if len(raw) < lenInBytes {
+				return raw, errInvalidFormat
+			}
+
+			size := int(endian.Uint32(raw))
+			// wstrings are counted in UTF-16 code units
+			if field.Type == MessageFieldTypeWString {
+				size *= 2
+			}
+
+			if len(raw) < lenInBytes+size {
+				return raw, errInvalidFormat
+			}
+			raw = raw[lenInBytes+size:]
+		case MessageFieldTypeComplex:
+			for _, subField := range field.MsgType.Fields {
+				raw, err = skipFieldData(subField, raw)
+				if err != nil {
+					return raw, err
+				}
+			}
+		default:
+			return raw, errInvalidFormat
+		}
+	}
+
+	return raw, nil
+}
+
+// checkFieldLength returns a LengthError when the array or the string at the beginning of raw is
+// longer than maxLen. Malformed data is left to the decoder to report.
+func checkFieldLength(field *MessageFieldDefinition, raw []byte, maxLen int) error {
+	length := field.ArraySize
+	if !field.IsArray || length < 0 {
+		isString := field.Type == MessageFieldTypeString || field.Type == MessageFieldTypeWString
+		if (!field.IsArray && !isString) || len(raw) < lenInBytes {
+			return nil
+		}
+		length = int(endian.Uint32(raw))
+	}
+
+	if length > maxLen {
+		return &LengthError{
+			Path:   field.Name,
+			Length: length,
+			MaxLen: maxLen,
+		}
+	}
+	return nil
+}
+
+// copyFieldValue copies the strings and the slices of builtin types in v, since they're views of
+// the message data.
+func copyFieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return string(append([]byte(nil), v...))
+	case []string:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = string(append([]byte(nil), v[i]...))
+		}
+		return values
+	}
+
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice {
+		return v
+	}
+
+	values := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+	reflect.Copy(values, value)
+	return values.Interface()
+}
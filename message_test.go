@@ -1,6 +1,9 @@
 package rosbag
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"math"
 	"reflect"
 	"testing"
@@ -792,3 +795,635 @@ func TestDecodeMessageData(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeMessageDataFieldError(t *testing.T) {
+	msgDef := `
+	geometry_msgs/Point position
+	geometry_msgs/Point[] points
+
+	MSG: geometry_msgs/Point
+	float64 x
+	`
+
+	type Point struct {
+		X float64 `rosbag:"x"`
+	}
+	type BadPoint struct {
+		X int32 `rosbag:"x"`
+	}
+
+	raw := addData(nil, float64(1))
+	raw = addData(raw, uint32(2))
+	raw = addData(raw, float64(2))
+	raw = addData(raw, float64(3))
+
+	testCases := []struct {
+		Name     string
+		Data     interface{}
+		Expected FieldError
+	}{
+		{
+			Name: "Nested",
+			Data: &struct {
+				Position BadPoint `rosbag:"position"`
+			}{},
+			Expected: FieldError{
+				Path:    "position.x",
+				ROSType: "float64",
+				GoType:  reflect.TypeOf(int32(0)),
+			},
+		},
+		{
+			Name: "Slice Element",
+			Data: &struct {
+				Position Point      `rosbag:"position"`
+				Points   []BadPoint `rosbag:"points"`
+			}{},
+			Expected: FieldError{
+				Path:    "points[0].x",
+				ROSType: "float64",
+				GoType:  reflect.TypeOf(int32(0)),
+			},
+		},
+		{
+			Name: "Slice",
+			Data: &struct {
+				Position Point  `rosbag:"position"`
+				Points   string `rosbag:"points"`
+			}{},
+			Expected: FieldError{
+				Path:    "points",
+				ROSType: "geometry_msgs/Point[]",
+				GoType:  reflect.TypeOf(""),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			var def MessageDefinition
+			err := def.unmarshall([]byte(msgDef))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = decodeMessageData(&def, raw, testCase.Data)
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("expected a FieldError, but got %v", err)
+			}
+
+			if !reflect.DeepEqual(&testCase.Expected, fieldErr) {
+				t.Fatalf("expected %+v, but got %+v", &testCase.Expected, fieldErr)
+			}
+		})
+	}
+}
+
+func TestViewAsFieldError(t *testing.T) {
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(1)...)
+
+	decoder := NewDecoder(bytes.NewReader(raw))
+	for {
+		record, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		var v struct {
+			X string `rosbag:"x"`
+		}
+		err = msg.ViewAs(&v)
+		msg.Close()
+
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) {
+			t.Fatalf("expected a FieldError, but got %v", err)
+		}
+
+		if fieldErr.Path != "x" || fieldErr.ROSType != "uint8" || fieldErr.Topic != "/test" || fieldErr.MessageType != "test_msgs/Test" {
+			t.Fatalf("unexpected field error: %+v", fieldErr)
+		}
+		return
+	}
+}
+
+func TestDecodeMessageDataRegisteredType(t *testing.T) {
+	type Point struct {
+		X float64 `rosbag:"x"`
+	}
+	RegisterType("test_msgs/RegisteredPoint", Point{})
+
+	msgDef := `
+	test_msgs/RegisteredPoint position
+	test_msgs/RegisteredPoint[] points
+
+	MSG: test_msgs/RegisteredPoint
+	float64 x
+	`
+
+	var def MessageDefinition
+	err := def.unmarshall([]byte(msgDef))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := addData(nil, float64(1))
+	raw = addData(raw, uint32(2))
+	raw = addData(raw, float64(2))
+	raw = addData(raw, float64(3))
+
+	actual := make(map[string]interface{})
+	_, err = decodeMessageData(&def, raw, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"position": Point{X: 1},
+		"points":   []Point{{X: 2}, {X: 3}},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Decoded value is not matched:\n\n%s", diff)
+	}
+}
+
+func TestRecordMessageDataDecode(t *testing.T) {
+	type Decoded struct {
+		X uint8 `rosbag:"x"`
+	}
+	RegisterType("test_msgs/Decoded", &Decoded{})
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for _, typ := range []string{"test_msgs/Decoded", "test_msgs/NotRegistered"} {
+		conn, err := writer.WriteConnection(&ConnectionHeader{
+			Topic:                "/" + typ,
+			Type:                 typ,
+			RawMessageDefinition: "uint8 x",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.WriteMessage(conn, time.Unix(1, 0), []byte{7})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoderBytes(buf.Bytes())
+	var decoded []interface{}
+	var errs []error
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			v, err := msg.Decode()
+			decoded = append(decoded, v)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(decoded) != 2 {
+		t.Fatalf("expected 2 messages, but got %d", len(decoded))
+	}
+
+	if diff := cmp.Diff(&Decoded{X: 7}, decoded[0]); diff != "" || errs[0] != nil {
+		t.Fatalf("decoded message is not matched (%v):\n\n%s", errs[0], diff)
+	}
+
+	if !errors.Is(errs[1], ErrUnregisteredType) {
+		t.Fatalf("expected %v, but got %v", ErrUnregisteredType, errs[1])
+	}
+}
+
+func TestParseMessageDefinition(t *testing.T) {
+	// the root type ends with the name of the nested type, so it must not be matched by it
+	def, err := ParseMessageDefinition("test_msgs/MyHeader", "Header header\nMSG: std_msgs/Header\nuint32 seq\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Type != "test_msgs/MyHeader" || def.Fields[0].MsgType.Type != "std_msgs/Header" {
+		t.Fatalf("expected test_msgs/MyHeader with a std_msgs/Header field, but got %s with %s", def.Type, def.Fields[0].MsgType.Type)
+	}
+
+	_, err = ParseMessageDefinition("test_msgs/Missing", "Missing missing\n")
+	if err == nil {
+		t.Fatal("expected an error for a nested type without a definition")
+	}
+}
+
+// unmarshalerTestMessage decodes the width, and sums the data by hand.
+type unmarshalerTestMessage struct {
+	Type  string
+	Width uint32
+	Sum   int
+}
+
+func (msg *unmarshalerTestMessage) UnmarshalROS(def *MessageDefinition, data []byte) error {
+	if len(data) < 8 {
+		return errors.New("message is too short")
+	}
+
+	msg.Type = def.Fields[1].rosType()
+	msg.Width = endian.Uint32(data)
+	for _, b := range data[8:] {
+		msg.Sum += int(b)
+	}
+	return nil
+}
+
+func TestViewAsUnmarshaler(t *testing.T) {
+	RegisterType("test_msgs/Unmarshaler", unmarshalerTestMessage{})
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/unmarshaler",
+		Type:                 "test_msgs/Unmarshaler",
+		RawMessageDefinition: "uint32 width\nuint8[] data",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := addData(nil, uint32(2))
+	data = addData(data, uint32(3))
+	data = append(data, 1, 2, 3)
+	err = writer.WriteMessage(conn, time.Unix(1, 0), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoderBytes(buf.Bytes())
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			continue
+		}
+
+		expected := &unmarshalerTestMessage{Type: "uint8[]", Width: 2, Sum: 6}
+		var viewed, arenaViewed unmarshalerTestMessage
+		err = msg.ViewAs(&viewed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		arena := NewArena(0)
+		err = msg.ViewAsArena(&arenaViewed, arena)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arena.Release()
+
+		decoded, err := msg.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, actual := range []interface{}{&viewed, &arenaViewed, decoded} {
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("message is not decoded by UnmarshalROS:\n\n%s", diff)
+			}
+		}
+	}
+}
+
+func TestRegisterTypeInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected RegisterType to panic with a non-struct type")
+		}
+	}()
+
+	RegisterType("test_msgs/Invalid", 1)
+}
+
+func TestMessageDefinitionROS2(t *testing.T) {
+	msgDef := `
+	wstring name
+	string<=10 label "none"
+	int32[<=5] values [1, 2, 3]
+	string<=4[<=2] tags ["a", "b"]
+	float64 gain 0.5
+	uint8 MODE=2
+	`
+
+	var def MessageDefinition
+	err := def.unmarshall([]byte(msgDef))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*MessageFieldDefinition{
+		{Type: MessageFieldTypeWString, Name: "name", ArraySize: -1},
+		{Type: MessageFieldTypeString, Name: "label", ArraySize: -1, StringBound: 10, Default: "none"},
+		{Type: MessageFieldTypeInt32, Name: "values", IsArray: true, ArraySize: -1, ArrayBound: 5, Default: []interface{}{int32(1), int32(2), int32(3)}},
+		{Type: MessageFieldTypeString, Name: "tags", IsArray: true, ArraySize: -1, ArrayBound: 2, StringBound: 4, Default: []interface{}{"a", "b"}},
+		{Type: MessageFieldTypeFloat64, Name: "gain", ArraySize: -1, Default: 0.5},
+	}
+	ignorePositions := cmpopts.IgnoreFields(MessageFieldDefinition{}, "Line", "RawLine", "DeclaredType")
+	if diff := cmp.Diff(expected, def.Fields, ignorePositions); diff != "" {
+		t.Fatalf("Parsed definition is not matched:\n\n%s", diff)
+	}
+
+	if constant, ok := def.Constant("MODE"); !ok || constant.Value != uint8(2) {
+		t.Fatalf("expected MODE to be 2, but got %+v", constant)
+	}
+
+	if rosType := def.Fields[3].rosType(); rosType != "string<=4[<=2]" {
+		t.Fatalf("expected the ROS type to be string<=4[<=2], but got %s", rosType)
+	}
+
+	raw := addData(nil, uint32(2))
+	raw = addData(raw, uint16('h'))
+	raw = addData(raw, uint16('i'))
+	raw = addData(raw, "abc")
+	raw = addData(raw, uint32(1))
+	raw = addData(raw, int32(7))
+	raw = addData(raw, uint32(0))
+	raw = addData(raw, float64(1.5))
+
+	actual := make(map[string]interface{})
+	rawAfter, err := decodeMessageData(&def, raw, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rawAfter) != 0 {
+		t.Fatalf("Expected no buffer left after decoding the whole message, but got %v", rawAfter)
+	}
+
+	if actual["name"] != "hi" || actual["label"] != "abc" || actual["gain"] != 1.5 || actual["MODE"] != uint8(2) {
+		t.Fatalf("unexpected decoded message: %v", actual)
+	}
+}
+
+func TestMessageDefinitionComments(t *testing.T) {
+	msgDef := "# A stamped point\n" +
+		"\n" +
+		"# the frame of the point\n" +
+		"Header header\n" +
+		"float64 x # in meters\r\n" +
+		"uint8 MODE=1 # constants can have comments too\n" +
+		"# a trailing comment\n" +
+		"================================================================================\n" +
+		"MSG: std_msgs/Header\n" +
+		"# sequence ID\n" +
+		"# consecutively increasing\n" +
+		"uint32 seq\n"
+
+	var def MessageDefinition
+	err := def.unmarshall([]byte(msgDef))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"A stamped point", "a trailing comment"}, def.Comments); diff != "" {
+		t.Fatalf("Message comments are not matched:\n\n%s", diff)
+	}
+
+	header := def.Fields[0].MsgType
+	expected := []*MessageFieldDefinition{
+		{Type: MessageFieldTypeComplex, DeclaredType: "Header", Name: "header", ArraySize: -1, MsgType: header, Line: 4, RawLine: "Header header", Comments: []string{"the frame of the point"}},
+		{Type: MessageFieldTypeFloat64, DeclaredType: "float64", Name: "x", ArraySize: -1, Line: 5, RawLine: "float64 x # in meters", Comment: "in meters"},
+	}
+	if diff := cmp.Diff(expected, def.Fields); diff != "" {
+		t.Fatalf("Parsed definition is not matched:\n\n%s", diff)
+	}
+
+	expectedConstants := []*MessageConstant{
+		{Type: MessageFieldTypeUint8, Name: "MODE", Value: uint8(1), Raw: "1", Line: 6, RawLine: "uint8 MODE=1 # constants can have comments too", Comment: "constants can have comments too"},
+	}
+	ignoreField := cmpopts.IgnoreUnexported(MessageConstant{})
+	if diff := cmp.Diff(expectedConstants, def.Constants, ignoreField); diff != "" {
+		t.Fatalf("Parsed constants are not matched:\n\n%s", diff)
+	}
+
+	expectedHeader := []*MessageFieldDefinition{
+		{Type: MessageFieldTypeUint32, DeclaredType: "uint32", Name: "seq", ArraySize: -1, Line: 12, RawLine: "uint32 seq", Comments: []string{"sequence ID", "consecutively increasing"}},
+	}
+	if diff := cmp.Diff(expectedHeader, header.Fields); diff != "" {
+		t.Fatalf("Parsed header definition is not matched:\n\n%s", diff)
+	}
+
+	if len(header.Comments) != 0 {
+		t.Fatalf("expected no header comments, but got %v", header.Comments)
+	}
+}
+
+func TestDecodeMessageDataStructTagOptions(t *testing.T) {
+	msgDef := `
+	uint8[] data
+	float64[] points
+	string Name
+	geometry_msgs/Point[] poses
+	uint32 count
+
+	MSG: geometry_msgs/Point
+	float64 x
+	string frame
+	`
+
+	type Point struct {
+		X float64 `rosbag:"x"`
+	}
+
+	type Message struct {
+		Data   []uint8   `rosbag:"data,copy"`
+		Points []float64 `rosbag:"points,maxlen=2"`
+		Name   string    `rosbag:"-"`
+		Poses  []Point   `rosbag:"poses,omit"`
+		Count  uint32    `rosbag:"count"`
+	}
+
+	encode := func(points ...float64) []byte {
+		raw := addDataMulti(nil, []uint8{1, 2}, true)
+		raw = addDataMulti(raw, points, true)
+		raw = addData(raw, "name")
+		raw = addData(raw, uint32(2))
+		raw = addData(raw, float64(1))
+		raw = addData(raw, "a")
+		raw = addData(raw, float64(2))
+		raw = addData(raw, "b")
+		return addData(raw, uint32(7))
+	}
+
+	var def MessageDefinition
+	err := def.unmarshall([]byte(msgDef))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := encode(1.5, 2.5)
+	actual := Message{Poses: []Point{{X: -1}}}
+	rawAfter, err := decodeMessageData(&def, raw, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rawAfter) != 0 {
+		t.Fatalf("Expected no buffer left after decoding the whole message, but got %v", rawAfter)
+	}
+
+	expected := Message{
+		Data:   []uint8{1, 2},
+		Points: []float64{1.5, 2.5},
+		Poses:  []Point{{X: -1}},
+		Count:  7,
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Decoded value is not matched:\n\n%s", diff)
+	}
+
+	// the copied data must not change with the message data
+	for i := range raw {
+		raw[i] = 0
+	}
+
+	if diff := cmp.Diff([]uint8{1, 2}, actual.Data); diff != "" {
+		t.Fatalf("Copied value is not matched:\n\n%s", diff)
+	}
+
+	_, err = decodeMessageData(&def, encode(1, 2, 3), &Message{})
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) {
+		t.Fatalf("expected a LengthError, but got %v", err)
+	}
+
+	if *lengthErr != (LengthError{Path: "points", Length: 3, MaxLen: 2}) {
+		t.Fatalf("unexpected length error: %+v", lengthErr)
+	}
+
+	invalid := []interface{}{
+		&struct {
+			Data []uint8 `rosbag:"data,unknown"`
+		}{},
+		&struct {
+			Data []uint8 `rosbag:"data,maxlen=-1"`
+		}{},
+	}
+	for _, v := range invalid {
+		_, err = decodeMessageData(&def, encode(), v)
+		if err == nil {
+			t.Fatalf("expected an error for an invalid tag in %T", v)
+		}
+	}
+}
+
+func TestNormalizeConstants(t *testing.T) {
+	newDefinition := func() MessageDefinition {
+		mode := &MessageDefinition{
+			Type: "test_msgs/Mode",
+			Fields: []*MessageFieldDefinition{
+				{Type: MessageFieldTypeUint8, Name: "AUTO", ArraySize: -1, Value: uint8(2)},
+				{Type: MessageFieldTypeUint8, Name: "value", ArraySize: -1},
+			},
+		}
+		return MessageDefinition{
+			Type: "test_msgs/Status",
+			Fields: []*MessageFieldDefinition{
+				{Type: MessageFieldTypeUint8, Name: "x", ArraySize: -1},
+				{Type: MessageFieldTypeString, Name: "NAME", ArraySize: -1, Value: "robot"},
+				{Type: MessageFieldTypeComplex, Name: "mode", ArraySize: -1, MsgType: mode},
+			},
+		}
+	}
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	hdr := ConnectionHeader{Topic: "/status", Type: "test_msgs/Status", MessageDefinition: newDefinition()}
+	_, err := writer.WriteConnection(&hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := &hdr.MessageDefinition
+	if len(def.Fields) != 2 || len(def.Fields[1].MsgType.Fields) != 1 {
+		t.Fatalf("expected the constants to be moved out of the fields, but got %+v", def.Fields)
+	}
+
+	if constant, ok := def.Fields[1].MsgType.Constant("AUTO"); !ok || constant.Value != uint8(2) || constant.Raw != "2" {
+		t.Fatalf("expected AUTO to be a constant, but got %+v", constant)
+	}
+
+	data, err := EncodeMessage(def, map[string]interface{}{"x": 1, "mode": map[string]interface{}{"value": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]byte{1, 3}, data); diff != "" {
+		t.Fatalf("encoded data is not matched:\n\n%s", diff)
+	}
+
+	b, err := def.AppendJSON(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := `{"NAME":"robot","x":1,"mode":{"AUTO":2,"value":3}}`; string(b) != expected {
+		t.Fatalf("expected %s, but got %s", expected, b)
+	}
+
+	// plans of definitions that aren't registered with a connection are normalized too
+	type status struct {
+		X    uint8  `rosbag:"x"`
+		Name string `rosbag:"NAME"`
+		Mode struct {
+			Auto  uint8 `rosbag:"AUTO"`
+			Value uint8 `rosbag:"value"`
+		} `rosbag:"mode"`
+	}
+
+	unregistered := newDefinition()
+	plan, err := CompilePlan(&unregistered, reflect.TypeOf(status{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual status
+	err = plan.Decode(data, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.X != 1 || actual.Name != "robot" || actual.Mode.Auto != 2 || actual.Mode.Value != 3 {
+		t.Fatalf("decoded message is not matched: %+v", actual)
+	}
+}
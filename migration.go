@@ -0,0 +1,219 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	migrationRegistryMu sync.RWMutex
+	migrationRegistry   = make(map[string]*migration)
+
+	errMigrationField = errors.New("field is not in the message definition")
+)
+
+// Migration maps the fields of an old message definition to the fields of the current one, so
+// messages that were recorded with the old definition can be viewed as the same Go struct as the
+// current messages. Fields are referred to by their paths in the old definition, e.g. "pose.pos"
+// for the pos field of the nested pose message.
+type Migration struct {
+	// Renames maps the paths of the old fields to their current names, e.g. {"pose.pos": "position"}
+	Renames map[string]string
+	// Widenings are the paths of the old fields that are converted to the wider types of their
+	// struct fields, e.g. int32 to int64, uint16 to int32, or float32 to float64. Arrays are
+	// converted element by element, so they're copied out of the message data.
+	Widenings []string
+}
+
+// migration is a registered Migration with its migrated definition. Every connection with the
+// md5sum of the migration has the same fields, so the definition is only migrated once.
+type migration struct {
+	Migration
+	md5Sum string
+
+	mu       sync.Mutex
+	migrated *migratedDefinition
+}
+
+type migratedDefinition struct {
+	def     *MessageDefinition
+	widened map[*MessageFieldDefinition]bool
+	err     error
+}
+
+// RegisterMigration registers migration for the messages whose connection header has md5sum,
+// so that ViewAs and ViewAsArena decode them with the renamed fields, and convert the widened
+// fields to the types of their struct fields. A migration is registered for every old md5sum, so
+// one struct can decode the messages of every version of a message type, e.g.
+//
+//	rosbag.RegisterMigration("3c5f3b5b0b2a7c5f1e6f4a8b6f0d1c2e", rosbag.Migration{
+//		Renames:   map[string]string{"vel": "velocity"},
+//		Widenings: []string{"seq"},
+//	})
+//
+// An invalid path is reported by ViewAs. Registering the same md5sum again replaces the previous
+// migration.
+func RegisterMigration(md5sum string, migration Migration) {
+	migrationRegistryMu.Lock()
+	migrationRegistry[md5sum] = newMigration(md5sum, migration)
+	migrationRegistryMu.Unlock()
+}
+
+func newMigration(md5sum string, m Migration) *migration {
+	return &migration{Migration: m, md5Sum: md5sum}
+}
+
+// registeredMigration returns the migration that is registered for md5sum.
+func registeredMigration(md5sum string) (*migration, bool) {
+	migrationRegistryMu.RLock()
+	m, ok := migrationRegistry[md5sum]
+	migrationRegistryMu.RUnlock()
+	return m, ok
+}
+
+// apply returns def with the renamed fields, and the widened fields of the returned definition.
+// def is not modified.
+func (m *migration) apply(def *MessageDefinition) (*MessageDefinition, map[*MessageFieldDefinition]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.migrated == nil {
+		m.migrated = m.migrate(def)
+	}
+	return m.migrated.def, m.migrated.widened, m.migrated.err
+}
+
+func (m *migration) migrate(def *MessageDefinition) *migratedDefinition {
+	migrated := &migratedDefinition{def: copyDefinition(def), widened: make(map[*MessageFieldDefinition]bool)}
+
+	// the paths are resolved before any field is renamed, since they use the old names
+	renamed := make(map[*MessageFieldDefinition]string, len(m.Renames))
+	for path, name := range m.Renames {
+		field, err := m.resolve(migrated.def, path)
+		if err != nil {
+			migrated.err = err
+			return migrated
+		}
+		renamed[field] = name
+	}
+
+	for _, path := range m.Widenings {
+		field, err := m.resolve(migrated.def, path)
+		if err != nil {
+			migrated.err = err
+			return migrated
+		}
+		migrated.widened[field] = true
+	}
+
+	for field, name := range renamed {
+		field.Name = name
+	}
+	return migrated
+}
+
+// resolve finds the field of path in def.
+func (m *migration) resolve(def *MessageDefinition, path string) (*MessageFieldDefinition, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		var found *MessageFieldDefinition
+		for _, field := range def.Fields {
+			if field.Name == name {
+				found = field
+				break
+			}
+		}
+
+		switch {
+		case found == nil:
+			return nil, fmt.Errorf("migration of %s: %s: %w", m.md5Sum, path, errMigrationField)
+		case i == len(names)-1:
+			return found, nil
+		case found.Type != MessageFieldTypeComplex || found.MsgType == nil:
+			return nil, fmt.Errorf("migration of %s: %s: %s is not a message", m.md5Sum, path, strings.Join(names[:i+1], "."))
+		}
+		def = found.MsgType
+	}
+	return nil, fmt.Errorf("migration of %s: %s: %w", m.md5Sum, path, errMigrationField)
+}
+
+// copyDefinition copies def and its nested definitions. Nested definitions are not shared in the
+// copy, so a field of one nested message can be renamed without renaming the same field of
+// another nested message of the same type.
+func copyDefinition(def *MessageDefinition) *MessageDefinition {
+	copied := *def
+	copied.Fields = make([]*MessageFieldDefinition, len(def.Fields))
+	for i, field := range def.Fields {
+		copiedField := *field
+		if field.MsgType != nil {
+			copiedField.MsgType = copyDefinition(field.MsgType)
+		}
+		copied.Fields[i] = &copiedField
+	}
+	return &copied
+}
+
+// widenValue converts v to t when every value of v's type can be represented by t. Slices are
+// converted element by element.
+func widenValue(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Kind() == reflect.Slice && t.Kind() == reflect.Slice {
+		if !widens(v.Type().Elem(), t.Elem()) {
+			return v, false
+		}
+
+		widened := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			widened.Index(i).Set(v.Index(i).Convert(t.Elem()))
+		}
+		return widened, true
+	}
+
+	if !widens(v.Type(), t) {
+		return v, false
+	}
+	return v.Convert(t), true
+}
+
+// widens tells whether every value of from can be converted to to without losing precision.
+func widens(from, to reflect.Type) bool {
+	if from == to {
+		return true
+	}
+
+	switch kind := to.Kind(); {
+	case isIntKind(kind):
+		if isIntKind(from.Kind()) {
+			return from.Bits() <= to.Bits()
+		}
+		return isUintKind(from.Kind()) && from.Bits() < to.Bits()
+	case isUintKind(kind):
+		return isUintKind(from.Kind()) && from.Bits() <= to.Bits()
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		// the integers must fit in the mantissa
+		mantissa := 24
+		if kind == reflect.Float64 {
+			mantissa = 53
+		}
+
+		switch {
+		case from.Kind() == reflect.Float32 || from.Kind() == reflect.Float64:
+			return from.Bits() <= to.Bits()
+		case isIntKind(from.Kind()):
+			return from.Bits() <= mantissa+1
+		case isUintKind(from.Kind()):
+			return from.Bits() <= mantissa
+		}
+	}
+	return false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uint64
+}
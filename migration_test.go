@@ -0,0 +1,196 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type migrationTestPosition struct {
+	X float64 `rosbag:"x"`
+}
+
+type migrationTestOdometry struct {
+	Seq      int64                 `rosbag:"seq"`
+	Velocity []float64             `rosbag:"velocity,copy"`
+	Position migrationTestPosition `rosbag:"position"`
+}
+
+const (
+	migrationTestDefinitionV1 = `
+int32 seq
+float32[] vel
+test_msgs/Position pos
+================================================================================
+MSG: test_msgs/Position
+float64 px
+`
+	migrationTestDefinitionV2 = `
+int64 seq
+float64[] velocity
+test_msgs/Position position
+================================================================================
+MSG: test_msgs/Position
+float64 x
+`
+)
+
+func migrationTestMD5Sum(t *testing.T, raw string) string {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return def.MD5Sum()
+}
+
+// writeMigrationTestBag writes a message of both definitions to /odom, and returns the bag with
+// the md5sum of the old definition.
+func writeMigrationTestBag(t *testing.T) ([]byte, string) {
+	v1 := migrationTestMD5Sum(t, migrationTestDefinitionV1)
+	old := addData(nil, int32(-1))
+	old = addDataMulti(old, []float32{0.5, 2}, true)
+	old = addData(old, float64(3))
+
+	current := addData(nil, int64(1<<40))
+	current = addDataMulti(current, []float64{4}, true)
+	current = addData(current, float64(5))
+
+	raw := writeTestBag(t, withTestConns(
+		&ConnectionHeader{
+			Topic:                "/odom",
+			Type:                 "test_msgs/Odometry",
+			MD5Sum:               v1,
+			RawMessageDefinition: migrationTestDefinitionV1,
+		},
+		&ConnectionHeader{
+			Topic:                "/odom",
+			Type:                 "test_msgs/Odometry",
+			MD5Sum:               migrationTestMD5Sum(t, migrationTestDefinitionV2),
+			RawMessageDefinition: migrationTestDefinitionV2,
+		},
+	), withTestRecords(
+		testRecord{Conn: 0, Time: time.Unix(1, 0), Data: old},
+		testRecord{Conn: 1, Time: time.Unix(2, 0), Data: current},
+	))
+	return raw, v1
+}
+
+// viewMigrationTestMessages views every message of raw as v, and returns the views, or the first
+// error.
+func viewMigrationTestMessages(t *testing.T, raw []byte, v func() interface{}) ([]interface{}, error) {
+	decoder := NewDecoder(bytes.NewReader(raw))
+	defer decoder.Close()
+
+	var views []interface{}
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return views, nil
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			view := v()
+			err = msg.ViewAs(view)
+			if err != nil {
+				record.Close()
+				return nil, err
+			}
+			views = append(views, view)
+		}
+		record.Close()
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	raw, v1 := writeMigrationTestBag(t)
+	defer RegisterMigration(v1, Migration{})
+
+	newOdometry := func() interface{} { return &migrationTestOdometry{} }
+	_, err := viewMigrationTestMessages(t, raw, newOdometry)
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Fatalf("expected the old message to fail without a migration, but got %v", err)
+	}
+
+	RegisterMigration(v1, Migration{
+		Renames:   map[string]string{"vel": "velocity", "pos": "position", "pos.px": "x"},
+		Widenings: []string{"seq", "vel"},
+	})
+	views, err := viewMigrationTestMessages(t, raw, newOdometry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{
+		&migrationTestOdometry{Seq: -1, Velocity: []float64{0.5, 2}, Position: migrationTestPosition{X: 3}},
+		&migrationTestOdometry{Seq: 1 << 40, Velocity: []float64{4}, Position: migrationTestPosition{X: 5}},
+	}
+	if diff := cmp.Diff(expected, views); diff != "" {
+		t.Fatalf("migrated messages are not matched:\n\n%s", diff)
+	}
+
+	// maps have the current names too
+	views, err = viewMigrationTestMessages(t, raw, func() interface{} { return make(map[string]interface{}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := views[0].(map[string]interface{})["velocity"]; !ok {
+		t.Fatalf("expected the renamed field in the map, but got %v", views[0])
+	}
+
+	// seq can't be narrowed
+	_, err = viewMigrationTestMessages(t, raw, func() interface{} {
+		return &struct {
+			Seq int16 `rosbag:"seq"`
+		}{}
+	})
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "seq" {
+		t.Fatalf("expected a field error of seq, but got %v", err)
+	}
+
+	RegisterMigration(v1, Migration{Renames: map[string]string{"pos.py": "y"}})
+	_, err = viewMigrationTestMessages(t, raw, newOdometry)
+	if !errors.Is(err, errMigrationField) {
+		t.Fatalf("expected an unknown field to fail the migration, but got %v", err)
+	}
+}
+
+func TestWidens(t *testing.T) {
+	testCases := []struct {
+		from, to interface{}
+		expected bool
+	}{
+		{int8(0), int16(0), true},
+		{int32(0), int64(0), true},
+		{int64(0), int32(0), false},
+		{uint16(0), int32(0), true},
+		{uint32(0), int32(0), false},
+		{int8(0), uint64(0), false},
+		{uint8(0), uint32(0), true},
+		{float32(0), float64(0), true},
+		{float64(0), float32(0), false},
+		{int16(0), float32(0), true},
+		{int32(0), float32(0), false},
+		{uint32(0), float64(0), true},
+		{int64(0), float64(0), false},
+		{"", int64(0), false},
+	}
+
+	for _, testCase := range testCases {
+		from, to := reflect.TypeOf(testCase.from), reflect.TypeOf(testCase.to)
+		if actual := widens(from, to); actual != testCase.expected {
+			t.Errorf("expected widens(%v, %v) to be %v", from, to, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package npy exports numeric fields of bags as NumPy .npy arrays, or .npz archives, so that they
+// can be loaded with numpy.load.
+package npy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const (
+	magic = "\x93NUMPY"
+	// headerAlignment is the alignment of the array data, the header is padded with spaces
+	headerAlignment = 64
+	// TimeArray is the name of the array with the record times of the messages in nanoseconds
+	TimeArray = "time"
+)
+
+// writeHeader writes the header of a version 1.0 .npy file of a 1-D array of n elements of
+// descr, e.g. "<f8".
+func writeHeader(w io.Writer, descr string, n int) error {
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%d,), }", descr, n)
+	// magic, version, header length, dict, and the trailing newline
+	size := len(magic) + 2 + 2 + len(dict) + 1
+	padding := (headerAlignment - size%headerAlignment) % headerAlignment
+
+	var header bytes.Buffer
+	header.WriteString(magic)
+	header.Write([]byte{1, 0})
+	headerLen := len(dict) + padding + 1
+	if headerLen > math.MaxUint16 {
+		return fmt.Errorf("npy header is too long")
+	}
+	header.Write([]byte{byte(headerLen), byte(headerLen >> 8)})
+	header.WriteString(dict)
+	header.Write(bytes.Repeat([]byte{' '}, padding))
+	header.WriteByte('\n')
+
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+// WriteFloat64s writes values as a .npy file of a 1-D float64 array.
+func WriteFloat64s(w io.Writer, values []float64) error {
+	err := writeHeader(w, "<f8", len(values))
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// WriteInt64s writes values as a .npy file of a 1-D int64 array.
+func WriteInt64s(w io.Writer, values []int64) error {
+	err := writeHeader(w, "<i8", len(values))
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], uint64(v))
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Columns are the values of field paths in the messages of a topic. Every column has a value
+// for every message, which is at the same index in Time.
+type Columns struct {
+	// Time are the record times of the messages in nanoseconds since the Unix epoch
+	Time []int64
+	// Paths are the field paths of the columns
+	Paths  []string
+	Values [][]float64
+}
+
+// ReadColumns subscribes to topic, runs decoder to the end, and returns the values of paths in the
+// messages of the topic in the order of the bag. See rosbag.FieldPath.Float64 for the conversion
+// of the values.
+func ReadColumns(decoder *rosbag.Decoder, topic string, paths []*rosbag.FieldPath) (*Columns, error) {
+	columns := Columns{
+		Values: make([][]float64, len(paths)),
+	}
+	for _, path := range paths {
+		columns.Paths = append(columns.Paths, path.String())
+	}
+
+	err := decoder.Subscribe(topic, func(record *rosbag.RecordMessageData) error {
+		t, err := record.Time()
+		if err != nil {
+			return err
+		}
+
+		def := &record.ConnectionHeader().MessageDefinition
+		for i, path := range paths {
+			v, err := path.Float64(def, record.Data())
+			if err != nil {
+				return err
+			}
+			columns.Values[i] = append(columns.Values[i], v)
+		}
+		columns.Time = append(columns.Time, t.UnixNano())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		return nil, err
+	}
+	return &columns, nil
+}
+
+// arrays calls fn with the name and the writer of every array, the time array comes first.
+func (columns *Columns) arrays(fn func(name string, write func(w io.Writer) error) error) error {
+	err := fn(TimeArray, func(w io.Writer) error {
+		return WriteInt64s(w, columns.Time)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, path := range columns.Paths {
+		values := columns.Values[i]
+		err = fn(path, func(w io.Writer) error {
+			return WriteFloat64s(w, values)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteNPZ writes the columns as an .npz archive with an array per path, and the time array.
+func (columns *Columns) WriteNPZ(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	err := columns.arrays(func(name string, write func(w io.Writer) error) error {
+		f, err := archive.Create(name + ".npy")
+		if err != nil {
+			return err
+		}
+		return write(f)
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// WriteDir writes the columns as an .npy file per path, and the time array, e.g.
+// dir/pose.position.x.npy and dir/time.npy. dir is created when it doesn't exist.
+func (columns *Columns) WriteDir(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return columns.arrays(func(name string, write func(w io.Writer) error) error {
+		f, err := os.Create(filepath.Join(dir, name+".npy"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = write(f)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	})
+}
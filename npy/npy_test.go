@@ -0,0 +1,179 @@
+package npy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+// readArray parses a 1-D .npy array, and returns its descr and data.
+func readArray(t *testing.T, b []byte) (string, []byte) {
+	if len(b) < 10 || string(b[:6]) != magic || b[6] != 1 || b[7] != 0 {
+		t.Fatalf("expected a version 1.0 .npy header, but got %q", b)
+	}
+
+	headerLen := int(binary.LittleEndian.Uint16(b[8:]))
+	if (10+headerLen)%headerAlignment != 0 {
+		t.Fatalf("expected the data to be aligned to %d bytes, but it starts at %d", headerAlignment, 10+headerLen)
+	}
+
+	header := string(bytes.TrimRight(b[10:10+headerLen], " \n"))
+	return header, b[10+headerLen:]
+}
+
+func TestWriteFloat64s(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteFloat64s(&buf, []float64{1.5, -2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, data := readArray(t, buf.Bytes())
+	if expected := "{'descr': '<f8', 'fortran_order': False, 'shape': (2,), }"; header != expected {
+		t.Fatalf("expected %s, but got %s", expected, header)
+	}
+
+	if len(data) != 16 {
+		t.Fatalf("expected 16 bytes of data, but got %d", len(data))
+	}
+
+	for i, expected := range []float64{1.5, -2} {
+		if v := math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])); v != expected {
+			t.Fatalf("expected %v at %d, but got %v", expected, i, v)
+		}
+	}
+}
+
+func TestWriteInt64s(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteInt64s(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, data := readArray(t, buf.Bytes())
+	if expected := "{'descr': '<i8', 'fortran_order': False, 'shape': (0,), }"; header != expected {
+		t.Fatalf("expected %s, but got %s", expected, header)
+	}
+
+	if len(data) != 0 {
+		t.Fatalf("expected no data, but got %d bytes", len(data))
+	}
+}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	conn := builder.Connection("/point", "test_msgs/Point", "float64 x\nint32 y\n")
+	for i, x := range []float64{0.5, 1.5} {
+		raw := make([]byte, 12)
+		binary.LittleEndian.PutUint64(raw, math.Float64bits(x))
+		binary.LittleEndian.PutUint32(raw[8:], uint32(i+1))
+		builder.Message(conn, time.Unix(1, int64(i)), raw)
+	}
+	return builder.Bytes()
+}
+
+func TestReadColumns(t *testing.T) {
+	raw := writeTestBag(t)
+	paths := []*rosbag.FieldPath{rosbag.MustCompileFieldPath("x"), rosbag.MustCompileFieldPath("y")}
+	columns, err := ReadColumns(rosbag.NewDecoder(bytes.NewReader(raw)), "/point", paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Columns{
+		Time:   []int64{1e9, 1e9 + 1},
+		Paths:  []string{"x", "y"},
+		Values: [][]float64{{0.5, 1.5}, {1, 2}},
+	}
+	if diff := cmp.Diff(expected, columns); diff != "" {
+		t.Fatalf("columns are not matched:\n\n%s", diff)
+	}
+
+	_, err = ReadColumns(rosbag.NewDecoder(bytes.NewReader(raw)), "/point", []*rosbag.FieldPath{rosbag.MustCompileFieldPath("z")})
+	if err == nil {
+		t.Fatal("expected a path that is not in the message definition to fail")
+	}
+}
+
+func TestWriteNPZ(t *testing.T) {
+	columns := &Columns{
+		Time:   []int64{1, 2},
+		Paths:  []string{"pose.x"},
+		Values: [][]float64{{3, 4}},
+	}
+
+	var buf bytes.Buffer
+	err := columns.WriteNPZ(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"time.npy", "pose.x.npy"}, names); diff != "" {
+		t.Fatalf("arrays are not matched:\n\n%s", diff)
+	}
+
+	f, err := archive.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	actual, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	err = WriteFloat64s(&expected, []float64{3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected.Bytes(), actual) {
+		t.Fatal("expected the archived array to be the same as WriteFloat64s")
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	columns := &Columns{
+		Time:   []int64{1},
+		Paths:  []string{"x"},
+		Values: [][]float64{{3}},
+	}
+
+	dir := filepath.Join(t.TempDir(), "point")
+	err := columns.WriteDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"time.npy", "x.npy"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, data := readArray(t, b)
+		if len(data) != 8 {
+			t.Fatalf("expected 8 bytes of data in %s, but got %d", name, len(data))
+		}
+	}
+}
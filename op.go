@@ -0,0 +1,142 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var errStandardOp = errors.New("op is defined by the format, only custom ops can be written as raw records")
+
+var (
+	opRegistryMu sync.RWMutex
+	opRegistry   = make(map[Op]OpDecodeFunc)
+)
+
+// OpDecodeFunc wraps a record of a custom op in a record type of the application, usually a struct
+// that embeds *RecordBase and parses its header fields and data. The record follows the same
+// rules as the other records, it must not be used after it's closed.
+type OpDecodeFunc func(record *RecordBase) (Record, error)
+
+// RegisterOp registers decode for the records of the custom op, e.g. the vendor extension records
+// of a recorder. Decoder.Read returns the records of op as they're wrapped by decode, both at the
+// top level and inside chunks, and Check accepts them. When decode is nil, the records are
+// returned as *RecordUnknown.
+//
+// RegisterOp panics if op is defined by the format. Registering the same op again replaces the
+// previous decode.
+func RegisterOp(op Op, decode OpDecodeFunc) {
+	if isStandardOp(op) {
+		panic(fmt.Sprintf("rosbag: RegisterOp of %d: the op is defined by the format", op))
+	}
+
+	if decode == nil {
+		decode = decodeUnknown
+	}
+
+	opRegistryMu.Lock()
+	opRegistry[op] = decode
+	opRegistryMu.Unlock()
+}
+
+// registeredOp returns the decode that is registered for op.
+func registeredOp(op Op) (OpDecodeFunc, bool) {
+	opRegistryMu.RLock()
+	decode, ok := opRegistry[op]
+	opRegistryMu.RUnlock()
+	return decode, ok
+}
+
+func isStandardOp(op Op) bool {
+	switch op {
+	case OpInvalid, OpBagHeader, OpChunk, OpConnection, OpMessageData, OpIndexData, OpChunkInfo:
+		return true
+	}
+	return false
+}
+
+// UnknownOps selects what a decoder does with the records whose ops are neither defined by the
+// format nor registered with RegisterOp.
+type UnknownOps uint8
+
+const (
+	// UnknownOpsFail makes Read fail, which is the default since the bag is likely corrupted
+	UnknownOpsFail UnknownOps = iota
+	// UnknownOpsSkip discards the records without reading their data
+	UnknownOpsSkip
+	// UnknownOpsReturn returns the records as *RecordUnknown
+	UnknownOpsReturn
+)
+
+// RecordUnknown is a record with a custom op that has no decode of its own. Op returns the op, and
+// Fields returns the header fields.
+type RecordUnknown struct {
+	*RecordBase
+}
+
+func decodeUnknown(record *RecordBase) (Record, error) {
+	return &RecordUnknown{RecordBase: record}, nil
+}
+
+// Fields returns the header fields of the record without the op. The values are sliced from the
+// record.
+func (record *RecordUnknown) Fields() (map[string][]byte, error) {
+	fields := make(map[string][]byte)
+	err := iterateHeaderFields(record.Header(), func(key, value []byte) bool {
+		if string(key) != "op" {
+			fields[string(key)] = value
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// knownOp reports whether the decoder reads the records of op rather than skipping them.
+func (decoder *Decoder) knownOp(op Op) bool {
+	if decoder.unknownOps != UnknownOpsSkip || isStandardOp(op) {
+		return true
+	}
+
+	_, ok := registeredOp(op)
+	return ok
+}
+
+// specializeCustomRecord wraps a record whose op isn't defined by the format.
+func (decoder *Decoder) specializeCustomRecord(op Op, record *RecordBase) (Record, error) {
+	decode, ok := registeredOp(op)
+	if ok {
+		return decode(record)
+	}
+
+	if decoder.unknownOps == UnknownOpsReturn {
+		return decodeUnknown(record)
+	}
+	return nil, fmt.Errorf("%w %d", errInvalidOp, op)
+}
+
+// encodeCustomRecord encodes a record of the custom op with fields sorted by their keys after the
+// op field.
+func encodeCustomRecord(op Op, fields map[string][]byte, data []byte) ([]byte, error) {
+	if isStandardOp(op) {
+		return nil, errStandardOp
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key == "op" {
+			return nil, fmt.Errorf("the op field is written from op")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	header := appendHeaderField(nil, "op", []byte{byte(op)})
+	for _, key := range keys {
+		header = appendHeaderField(header, key, fields[key])
+	}
+	return appendRecord(nil, header, data), nil
+}
@@ -0,0 +1,198 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testRegisteredOp Op = 0x80
+	testUnknownOp    Op = 0x81
+)
+
+// recordVendor is the record type of testRegisteredOp.
+type recordVendor struct {
+	*RecordBase
+	Vendor string
+}
+
+func init() {
+	RegisterOp(testRegisteredOp, func(record *RecordBase) (Record, error) {
+		vendor, err := record.findField([]byte("vendor"))
+		if err != nil {
+			return nil, err
+		}
+		return &recordVendor{RecordBase: record, Vendor: string(vendor)}, nil
+	})
+}
+
+// writeCustomOpTestBag writes a message, a record of testRegisteredOp, a record of testUnknownOp,
+// and another message to a chunk, and a record of testUnknownOp after the chunk.
+func writeCustomOpTestBag(t *testing.T) []byte {
+	raw := writeTestBag(t, withTestConns(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8 x\n",
+	}), withTestRecords(
+		testRecord{Time: time.Unix(1, 0), Data: []byte{1}},
+		testRecord{Op: testRegisteredOp, Fields: map[string][]byte{"vendor": []byte("acme")}, Data: []byte("calibration")},
+		testRecord{Op: testUnknownOp, Fields: map[string][]byte{"b": []byte("2"), "a": []byte("1")}, Data: []byte("opaque")},
+		testRecord{Time: time.Unix(2, 0), Data: []byte{2}},
+	))
+
+	record, err := encodeCustomRecord(testUnknownOp, nil, []byte("trailer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(raw, record...)
+}
+
+// readCustomOpRecords describes the records that aren't part of the standard format.
+func readCustomOpRecords(decoder *Decoder) ([]string, error) {
+	var records []string
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+
+		if err != nil {
+			return records, err
+		}
+
+		switch record := record.(type) {
+		case *RecordMessageData:
+			records = append(records, "message "+string('0'+record.Data()[0]))
+		case *recordVendor:
+			records = append(records, "vendor "+record.Vendor+" "+string(record.Data()))
+		case *RecordUnknown:
+			fields, err := record.Fields()
+			if err != nil {
+				return records, err
+			}
+			records = append(records, "unknown "+string(fields["a"])+string(fields["b"])+" "+string(record.Data()))
+		}
+		record.Close()
+	}
+}
+
+func TestRegisterOp(t *testing.T) {
+	raw := writeCustomOpTestBag(t)
+	testCases := []struct {
+		Name       string
+		UnknownOps UnknownOps
+		Expected   []string
+		Err        bool
+	}{
+		{
+			Name:       "Fail",
+			UnknownOps: UnknownOpsFail,
+			Expected:   []string{"message 1", "vendor acme calibration"},
+			Err:        true,
+		},
+		{
+			Name:       "Skip",
+			UnknownOps: UnknownOpsSkip,
+			Expected:   []string{"message 1", "vendor acme calibration", "message 2"},
+		},
+		{
+			Name:       "Return",
+			UnknownOps: UnknownOpsReturn,
+			Expected:   []string{"message 1", "vendor acme calibration", "unknown 12 opaque", "message 2", "unknown  trailer"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		decoders := map[string]func() *Decoder{
+			"Reader": func() *Decoder {
+				return NewDecoder(bytes.NewReader(raw), WithUnknownOps(testCase.UnknownOps))
+			},
+			"Bytes": func() *Decoder {
+				return NewDecoderBytes(raw, WithUnknownOps(testCase.UnknownOps))
+			},
+		}
+
+		for name, newDecoder := range decoders {
+			newDecoder := newDecoder
+			t.Run(testCase.Name+" "+name, func(t *testing.T) {
+				records, err := readCustomOpRecords(newDecoder())
+				if testCase.Err != (err != nil) || (err != nil && !errors.Is(err, errInvalidOp)) {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				if diff := cmp.Diff(testCase.Expected, records); diff != "" {
+					t.Fatalf("records are not matched:\n\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestRegisterOpStandard(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering a standard op to panic")
+		}
+	}()
+	RegisterOp(OpChunk, nil)
+}
+
+func TestWriteRecordStandard(t *testing.T) {
+	writer := NewWriter(ioutil.Discard)
+	err := writer.WriteRecord(OpMessageData, nil, nil)
+	if err != errStandardOp {
+		t.Fatalf("expected %v, but got %v", errStandardOp, err)
+	}
+}
+
+func TestCheckRegisteredOp(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	err := writer.WriteRecord(testRegisteredOp, map[string][]byte{"vendor": []byte("acme")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := buf.Bytes()
+	findings, err := Check(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, finding := range findings {
+		if finding.Check == CheckStructure {
+			t.Fatalf("expected the registered op to be accepted, but got %v", finding)
+		}
+	}
+
+	record, err := encodeCustomRecord(testUnknownOp, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err = Check(bytes.NewReader(append(raw, record...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unknown bool
+	for _, finding := range findings {
+		unknown = unknown || finding.Check == CheckStructure
+	}
+
+	if !unknown {
+		t.Fatalf("expected the unknown op to be reported, but got %v", findings)
+	}
+}
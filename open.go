@@ -0,0 +1,246 @@
+package rosbag
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var errNotArchive = errors.New("file is neither a bag, nor a zip or tar archive")
+
+// archiveFormat is the format of a file that is detected from its first bytes.
+type archiveFormat uint8
+
+const (
+	archiveNone archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGzip
+)
+
+// detectArchive detects the format of the file that starts with head. A gzip stream is assumed
+// to be a tar.gz, since bags are never gzipped on their own.
+func detectArchive(head []byte) (archiveFormat, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("#ROSBAG")):
+		return archiveNone, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return archiveZip, nil
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return archiveTarGzip, nil
+	case len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")):
+		return archiveTar, nil
+	}
+	return archiveNone, errNotArchive
+}
+
+// OpenBag opens the bag at path for reading. path can be a bag, or a zip, tar, or tar.gz archive
+// with a single bag, e.g. a telemetry upload, which is detected from the first bytes of the file
+// rather than its extension. A bag in an archive is read by streaming through its entry, see
+// OpenArchiveEntry.
+func OpenBag(path string) (io.ReadCloser, error) {
+	return openBag(path, "")
+}
+
+// OpenArchiveEntry opens the bag entry name in the zip, tar, or tar.gz archive at path. name can
+// be a pattern of path.Match, and a name without a directory also matches the entries in
+// directories. When name is empty, the archive must have a single bag, which is the only entry that
+// ends with .bag. A zip archive must have a single matching entry, while the first matching entry
+// of a tar archive is opened, since tar archives can only be read in order.
+//
+// The returned reader streams through the entry, so the bag can be decoded without extracting
+// it. When the entry is stored uncompressed in a zip archive, the reader is also an io.ReadSeeker
+// and an io.ReaderAt of the bag, so the index section can be preloaded, and the bag can be passed
+// to Slice or Copy.
+func OpenArchiveEntry(path, name string) (io.ReadCloser, error) {
+	if name == "" {
+		name = "*.bag"
+	}
+	return openBag(path, name)
+}
+
+// openBag opens the bag at path, or the entry of the archive at path that matches the name pattern.
+// A plain bag is only accepted when pattern is empty.
+func openBag(filePath, pattern string) (io.ReadCloser, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+
+	format, err := detectArchive(head[:n])
+	if err == nil && format == archiveNone && pattern != "" {
+		err = fmt.Errorf("%s is a bag, not an archive", filePath)
+	}
+
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if pattern == "" {
+		pattern = "*.bag"
+	}
+
+	var r io.ReadCloser
+	switch format {
+	case archiveNone:
+		return f, nil
+	case archiveZip:
+		r, err = openZipEntry(f, pattern)
+	default:
+		r, err = openTarEntry(f, format == archiveTarGzip, pattern)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return r, nil
+}
+
+// matchEntry reports whether the entry name matches pattern. A pattern that is a bag name also
+// matches the entries of the bag in directories, e.g. *.bag matches uploads/run1.bag.
+func matchEntry(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+
+	ok, _ := path.Match(pattern, path.Base(name))
+	return ok && !strings.Contains(pattern, "/")
+}
+
+// entryError returns the error of a pattern that doesn't select a single entry of names.
+func entryError(pattern string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no entry matches %s", pattern)
+	}
+	return fmt.Errorf("%d entries match %s: %s", len(names), pattern, strings.Join(names, ", "))
+}
+
+// zipEntry streams a compressed zip entry.
+type zipEntry struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (entry *zipEntry) Close() error {
+	entry.ReadCloser.Close()
+	return entry.f.Close()
+}
+
+// storedZipEntry reads an uncompressed zip entry in place, so it supports random access.
+type storedZipEntry struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (entry *storedZipEntry) Close() error {
+	return entry.f.Close()
+}
+
+func openZipEntry(f *os.File, pattern string) (io.ReadCloser, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*zip.File
+	var names []string
+	for _, file := range zr.File {
+		if file.FileInfo().Mode().IsRegular() && matchEntry(pattern, file.Name) {
+			matched = append(matched, file)
+			names = append(names, file.Name)
+		}
+	}
+
+	if len(matched) != 1 {
+		return nil, entryError(pattern, names)
+	}
+
+	file := matched[0]
+	if file.Method == zip.Store {
+		off, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return &storedZipEntry{SectionReader: io.NewSectionReader(f, off, int64(file.UncompressedSize64)), f: f}, nil
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &zipEntry{ReadCloser: r, f: f}, nil
+}
+
+// tarEntry streams an entry of a tar archive, which is optionally gzipped.
+type tarEntry struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (entry *tarEntry) Close() error {
+	var err error
+	for i := len(entry.closers) - 1; i >= 0; i-- {
+		if closeErr := entry.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openTarEntry streams through the archive to the first entry that matches pattern. Tar archives
+// can only be read in order, so the entries after it are not checked.
+func openTarEntry(f *os.File, gzipped bool, pattern string) (io.ReadCloser, error) {
+	entry := tarEntry{closers: []io.Closer{f}}
+	var r io.Reader = bufio.NewReader(f)
+	if gzipped {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		entry.closers = append(entry.closers, gr)
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, entryError(pattern, nil)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.FileInfo().Mode().IsRegular() && matchEntry(pattern, hdr.Name) {
+			entry.Reader = tr
+			return &entry, nil
+		}
+	}
+}
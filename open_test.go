@@ -0,0 +1,193 @@
+package rosbag
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testArchiveEntry struct {
+	Name string
+	Data []byte
+}
+
+func writeTestZip(t *testing.T, method uint16, entries ...testArchiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Write(entry.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestTar(t *testing.T, gzipped bool, entries ...testArchiveEntry) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if gzipped {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		err := tw.WriteHeader(&tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Data)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tw.Write(entry.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gw != nil {
+		err = gw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenBag(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}},
+		[]indexedTestMessage{{0, 3}},
+	)
+	notes := testArchiveEntry{Name: "notes.txt", Data: []byte("uploaded by the recorder")}
+	bag := testArchiveEntry{Name: "uploads/run1.bag", Data: raw}
+
+	testCases := []struct {
+		Name string
+		Data []byte
+		// RandomAccess is true when the bag is expected to be an io.ReaderAt
+		RandomAccess bool
+	}{
+		{Name: "Bag", Data: raw, RandomAccess: true},
+		{Name: "Zip Stored", Data: writeTestZip(t, zip.Store, notes, bag), RandomAccess: true},
+		{Name: "Zip Deflated", Data: writeTestZip(t, zip.Deflate, notes, bag)},
+		{Name: "Tar", Data: writeTestTar(t, false, notes, bag)},
+		{Name: "Tar Gzip", Data: writeTestTar(t, true, notes, bag)},
+	}
+
+	expected := readIndexedTestMessages(t, NewDecoder(bytes.NewReader(raw)))
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			r, err := OpenBag(writeTestFile(t, "upload", testCase.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			ra, ok := r.(io.ReaderAt)
+			if ok != testCase.RandomAccess {
+				t.Fatalf("expected random access to be %v", testCase.RandomAccess)
+			}
+
+			if ok {
+				slice, err := Slice(ra, time.Unix(3, 0), time.Time{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff([]indexedTestMessage{{0, 3}}, readIndexedTestMessages(t, slice.NewDecoder())); diff != "" {
+					t.Fatalf("messages of the slice are not matched:\n\n%s", diff)
+				}
+			}
+
+			if diff := cmp.Diff(expected, readIndexedTestMessages(t, NewDecoder(r))); diff != "" {
+				t.Fatalf("messages are not matched:\n\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOpenArchiveEntry(t *testing.T) {
+	first := testArchiveEntry{Name: "run1.bag", Data: writeIndexedTestBag(t, []indexedTestMessage{{0, 1}})}
+	second := testArchiveEntry{Name: "logs/run2.bag", Data: writeIndexedTestBag(t, []indexedTestMessage{{1, 2}})}
+	zipPath := writeTestFile(t, "upload.zip", writeTestZip(t, zip.Deflate, first, second))
+	tarPath := writeTestFile(t, "upload.tar", writeTestTar(t, false, first, second))
+
+	testCases := []struct {
+		Path     string
+		Name     string
+		Expected []indexedTestMessage
+	}{
+		{Path: zipPath, Name: "run2.bag", Expected: []indexedTestMessage{{1, 2}}},
+		{Path: zipPath, Name: "run1.*", Expected: []indexedTestMessage{{0, 1}}},
+		{Path: tarPath, Name: "logs/run2.bag", Expected: []indexedTestMessage{{1, 2}}},
+		// tar archives are only read up to the first match
+		{Path: tarPath, Expected: []indexedTestMessage{{0, 1}}},
+	}
+
+	for _, testCase := range testCases {
+		r, err := OpenArchiveEntry(testCase.Path, testCase.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := readIndexedTestMessages(t, NewDecoder(r))
+		r.Close()
+		if diff := cmp.Diff(testCase.Expected, actual); diff != "" {
+			t.Fatalf("messages of %s in %s are not matched:\n\n%s", testCase.Name, filepath.Base(testCase.Path), diff)
+		}
+	}
+
+	errCases := []struct {
+		Path     string
+		Name     string
+		Expected string
+	}{
+		{Path: zipPath, Expected: "2 entries match *.bag: run1.bag, logs/run2.bag"},
+		{Path: zipPath, Name: "run3.bag", Expected: "no entry matches run3.bag"},
+		{Path: tarPath, Name: "run3.bag", Expected: "no entry matches run3.bag"},
+		{Path: writeTestFile(t, "run.bag", first.Data), Expected: "is a bag, not an archive"},
+		{Path: writeTestFile(t, "notes.txt", []byte("notes")), Expected: errNotArchive.Error()},
+	}
+
+	for _, errCase := range errCases {
+		_, err := OpenArchiveEntry(errCase.Path, errCase.Name)
+		if err == nil || !strings.Contains(err.Error(), errCase.Expected) {
+			t.Fatalf("expected %q, but got %v", errCase.Expected, err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package rosbag
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultBufferSize = 4096
+)
+
+// DecoderOption configures an optional behavior of a Decoder. Options are applied in order
+// by NewDecoder.
+type DecoderOption func(*decoderConfig)
+
+type decoderConfig struct {
+	ctx            context.Context
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	readLimit      int
+	prefetch       bool
+	bufferSize     int
+	typeFilter     *typeFilter
+	windowFilter   *windowFilter
+	recordFilter   RecordFilter
+	// tolerateTruncation makes Read return io.EOF instead of a TruncatedError
+	tolerateTruncation bool
+	aliasSemantics     AliasSemantics
+	unknownOps         UnknownOps
+	interner           *Interner
+	definitionCache    *DefinitionCache
+	memoryBudget       *MemoryBudget
+	validateMD5Sums    bool
+	// err is the first error from the options. It's reported by Decoder.Read since NewDecoder
+	// doesn't return an error.
+	err error
+}
+
+func newDecoderConfig(opts []DecoderOption) *decoderConfig {
+	config := decoderConfig{
+		ctx:        context.Background(),
+		bufferSize: defaultBufferSize,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return &config
+}
+
+// WithContext sets the parent context of the decoder. When tracing is enabled, the bag span
+// is created as a child of the span carried by ctx.
+func WithContext(ctx context.Context) DecoderOption {
+	return func(config *decoderConfig) {
+		config.ctx = ctx
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing. The decoder starts a span for the bag
+// when the version is checked and a child span for every chunk. The chunk span ends when the
+// last record inside the chunk has been read, and the bag span ends when Read returns an error,
+// including io.EOF.
+func WithTracerProvider(tp trace.TracerProvider) DecoderOption {
+	return func(config *decoderConfig) {
+		config.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider enables OpenTelemetry metrics. The decoder counts chunks, message data
+// records, and the raw bytes of the records read from the underlying reader.
+func WithMeterProvider(mp metric.MeterProvider) DecoderOption {
+	return func(config *decoderConfig) {
+		config.meterProvider = mp
+	}
+}
+
+// WithReadLimit limits the rate of reads from the underlying reader to bytesPerSec. The limit
+// applies to the raw bytes of the bag, not to the decompressed chunk data. A non-positive
+// bytesPerSec disables the limit.
+func WithReadLimit(bytesPerSec int) DecoderOption {
+	return func(config *decoderConfig) {
+		config.readLimit = bytesPerSec
+	}
+}
+
+// WithPrefetch enables chunk prefetching. A background goroutine reads and decompresses the next
+// chunk while the current one is being consumed, which hides I/O and decompression latency when
+// the consumer does nontrivial work per message. Each prefetched chunk is held in memory
+// decompressed. When prefetching is enabled, Decoder.Close must be called to stop the background
+// goroutine.
+func WithPrefetch() DecoderOption {
+	return func(config *decoderConfig) {
+		config.prefetch = true
+	}
+}
+
+// WithBufferSize sets the size of the buffer that wraps the reader passed to NewDecoder. The
+// default is 4 KB, which is small for bags with multi-megabyte chunks on high-latency storage.
+// A non-positive size disables buffering, and the reader is used as is. This is useful when
+// the reader is already buffered by the caller.
+func WithBufferSize(size int) DecoderOption {
+	return func(config *decoderConfig) {
+		config.bufferSize = size
+	}
+}
+
+// WithMessageTypes makes the decoder skip message data and connection records whose connection
+// type doesn't match any of types. types are patterns, e.g. "sensor_msgs/Image" or
+// "sensor_msgs/*", see Pattern. An invalid pattern is reported by the first Read.
+func WithMessageTypes(types ...string) DecoderOption {
+	return func(config *decoderConfig) {
+		patterns := config.compilePatterns(types)
+		config.filter().include = append(config.filter().include, patterns...)
+	}
+}
+
+// WithoutMessageTypes makes the decoder skip message data and connection records whose connection
+// type matches any of types. Exclusions take precedence over WithMessageTypes. types are
+// patterns, e.g. "visualization_msgs/*", see Pattern. An invalid pattern is reported by the first
+// Read.
+func WithoutMessageTypes(types ...string) DecoderOption {
+	return func(config *decoderConfig) {
+		patterns := config.compilePatterns(types)
+		config.filter().exclude = append(config.filter().exclude, patterns...)
+	}
+}
+
+// WithTruncationTolerance makes the decoder treat a truncated bag as if it ended after the last
+// complete record. Read delivers every complete record, including the complete records of a
+// truncated chunk, and then returns io.EOF instead of a TruncatedError. The truncation is still
+// available from Decoder.Truncated.
+func WithTruncationTolerance() DecoderOption {
+	return func(config *decoderConfig) {
+		config.tolerateTruncation = true
+	}
+}
+
+// WithAliasSemantics sets the builtin types that the byte and char aliases are decoded as. The
+// default is AliasSemanticsROS1. The semantics apply to the connection headers of the message data
+// records, and the types of the struct fields that the messages are viewed as must match them.
+func WithAliasSemantics(semantics AliasSemantics) DecoderOption {
+	return func(config *decoderConfig) {
+		config.aliasSemantics = semantics
+	}
+}
+
+// WithUnknownOps sets what the decoder does with the records whose ops are neither defined by the
+// format nor registered with RegisterOp. The default is UnknownOpsFail.
+func WithUnknownOps(unknownOps UnknownOps) DecoderOption {
+	return func(config *decoderConfig) {
+		config.unknownOps = unknownOps
+	}
+}
+
+// WithInterner makes RecordMessageData.ViewAs intern the strings of the fields of interner, so
+// repeated values like frame_id share their memory across messages instead of being copied for
+// every message, see Interner.
+func WithInterner(interner *Interner) DecoderOption {
+	return func(config *decoderConfig) {
+		config.interner = interner
+	}
+}
+
+// WithDefinitionCache makes the decoder load the parsed message definitions of the connections
+// from cache, and store the definitions that it parses, see DefinitionCache.
+func WithDefinitionCache(cache *DefinitionCache) DecoderOption {
+	return func(config *decoderConfig) {
+		config.definitionCache = cache
+	}
+}
+
+// WithRecordFilter makes the decoder call filter with the metadata of every message data and chunk
+// record right after its header is read, and skip the record when filter returns false. The data
+// of a skipped record is discarded without being copied, a skipped chunk is not decompressed, and
+// a skipped message doesn't need its connection. This makes cheap filters, e.g. every 5th message
+// of a connection after a time, skip the records before they cost anything. The other records are
+// always read since the decoder needs them.
+func WithRecordFilter(filter RecordFilter) DecoderOption {
+	return func(config *decoderConfig) {
+		config.recordFilter = filter
+	}
+}
+
+// WithMemoryBudget makes the decoder reserve the buffers of its records and chunks from budget,
+// and release them when the records are closed, and the chunks are done. Read fails with a
+// MemoryBudgetError when a buffer doesn't fit in the budget. A budget can be shared by many
+// decoders, see MemoryBudget. Decoder.Close must be called to release the prefetched chunks.
+func WithMemoryBudget(budget *MemoryBudget) DecoderOption {
+	return func(config *decoderConfig) {
+		config.memoryBudget = budget
+	}
+}
+
+// WithMD5Validation makes Read fail with an MD5SumError when the md5sum of a connection record
+// doesn't match the md5sum of its message definition, see ConnectionHeader.ValidateMD5Sum, so the
+// messages are never viewed with a definition that doesn't belong to them.
+func WithMD5Validation() DecoderOption {
+	return func(config *decoderConfig) {
+		config.validateMD5Sums = true
+	}
+}
+
+func (config *decoderConfig) filter() *typeFilter {
+	if config.typeFilter == nil {
+		config.typeFilter = &typeFilter{}
+	}
+	return config.typeFilter
+}
+
+func (config *decoderConfig) compilePatterns(raw []string) []*Pattern {
+	patterns := make([]*Pattern, 0, len(raw))
+	for _, r := range raw {
+		pattern, err := CompilePattern(r)
+		if err != nil {
+			if config.err == nil {
+				config.err = err
+			}
+			continue
+		}
+
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
@@ -0,0 +1,266 @@
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const defaultWorkUnitSize = 64 * 1024 * 1024
+
+var (
+	errNotIndexed = errors.New("bag doesn't have an index section, it must be reindexed first")
+)
+
+// PartitionOption configures an optional behavior of Partition.
+type PartitionOption func(*partitionConfig)
+
+type partitionConfig struct {
+	unitSize int64
+	units    int
+}
+
+// WithWorkUnitSize sets the size in bytes of the chunks after which a work unit is closed, and a
+// new unit is started. A unit has at least one chunk, so a unit can be larger when the chunks are
+// larger. The default is 64 MB.
+func WithWorkUnitSize(size int64) PartitionOption {
+	return func(config *partitionConfig) {
+		config.unitSize = size
+	}
+}
+
+// WithWorkUnits splits the chunks into n work units of about the same size, e.g. one for every
+// worker. It takes precedence over WithWorkUnitSize. There are fewer units when the bag has
+// fewer chunks than n.
+func WithWorkUnits(n int) PartitionOption {
+	return func(config *partitionConfig) {
+		config.units = n
+	}
+}
+
+// WorkConnection is a connection that's needed to decode the messages of a work unit.
+type WorkConnection struct {
+	Conn              uint32
+	Topic             string
+	Type              string
+	MD5Sum            string
+	MessageDefinition string
+}
+
+// WorkUnit is a range of consecutive chunks of an indexed bag with the connections of their
+// messages. A unit is self-contained, the chunks of a unit can be decoded without the rest of the
+// bag, so that units can be processed by separate processes or machines. Units only have plain
+// fields, so they can be shipped with encoding/json or encoding/gob.
+type WorkUnit struct {
+	// Index is the position of the unit in the bag
+	Index int
+	// Offset is the position of the first chunk in the bag, and Length is the length of the
+	// chunks including the index data records between them.
+	Offset int64
+	Length int64
+	// Chunks and Messages are the numbers of chunks and messages in the unit
+	Chunks   int
+	Messages uint64
+	// StartTime and EndTime are the earliest and the latest record times of the messages
+	StartTime time.Time
+	EndTime   time.Time
+	// Connections are sorted by their IDs
+	Connections []WorkConnection
+}
+
+// Partition splits the chunks of an indexed bag into work units, see WorkUnit. r is read from
+// its current offset, which must be the beginning of the bag, and it's seeked back to the
+// offset at the end. opts can be used to configure the size of the units, see PartitionOption.
+func Partition(r io.ReadSeeker, opts ...PartitionOption) ([]*WorkUnit, error) {
+	config := partitionConfig{
+		unitSize: defaultWorkUnitSize,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := NewDecoder(r)
+	err = decoder.Preload()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoder.chunkInfos) == 0 {
+		return nil, errNotIndexed
+	}
+
+	chunks, err := locateWorkChunks(r, start, decoder.chunkInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	unitSize := config.unitSize
+	if config.units > 0 {
+		var total int64
+		for _, chunk := range chunks {
+			total += chunk.length
+		}
+		unitSize = (total + int64(config.units) - 1) / int64(config.units)
+	}
+
+	var units []*WorkUnit
+	var unit *WorkUnit
+	conns := make(map[uint32]bool)
+	for _, chunk := range chunks {
+		if unit == nil {
+			unit = &WorkUnit{Index: len(units), Offset: chunk.pos, StartTime: chunk.startTime}
+			units = append(units, unit)
+		}
+
+		unit.Length = chunk.pos + chunk.length - unit.Offset
+		unit.Chunks++
+		if chunk.startTime.Before(unit.StartTime) {
+			unit.StartTime = chunk.startTime
+		}
+		if chunk.endTime.After(unit.EndTime) {
+			unit.EndTime = chunk.endTime
+		}
+
+		for conn, count := range chunk.counts {
+			unit.Messages += uint64(count)
+			conns[conn] = true
+		}
+
+		if unit.Length >= unitSize {
+			err = unit.setConnections(decoder.conns, conns)
+			if err != nil {
+				return nil, err
+			}
+			unit = nil
+			conns = make(map[uint32]bool)
+		}
+	}
+
+	if unit != nil {
+		err = unit.setConnections(decoder.conns, conns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = r.Seek(start, io.SeekStart)
+	return units, err
+}
+
+type workChunk struct {
+	// pos is relative to the beginning of the bag, and length is the length of the chunk record
+	pos       int64
+	length    int64
+	startTime time.Time
+	endTime   time.Time
+	counts    map[uint32]uint32
+}
+
+// locateWorkChunks reads the headers of the chunks of chunkInfos to get their lengths. The chunks
+// are sorted by their positions.
+func locateWorkChunks(r io.ReadSeeker, start int64, chunkInfos []*RecordChunkInfo) ([]workChunk, error) {
+	chunks := make([]workChunk, len(chunkInfos))
+	br := bufio.NewReader(r)
+	for i, chunkInfo := range chunkInfos {
+		chunkPos, err := chunkInfo.ChunkPos()
+		if err != nil {
+			return nil, err
+		}
+
+		chunks[i].pos = int64(chunkPos)
+		chunks[i].startTime, err = chunkInfo.StartTime()
+		if err != nil {
+			return nil, err
+		}
+
+		chunks[i].endTime, err = chunkInfo.EndTime()
+		if err != nil {
+			return nil, err
+		}
+
+		chunks[i].counts, err = chunkInfo.MessageCounts()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = r.Seek(start+chunks[i].pos, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		br.Reset(r)
+
+		chunk, err := readRecordHeader(br)
+		if err != nil {
+			return nil, err
+		}
+
+		op, err := chunk.Op()
+		if err != nil {
+			return nil, err
+		}
+
+		if op != OpChunk {
+			return nil, fmt.Errorf("expected a chunk record at %d, but got op %d", chunkPos, op)
+		}
+		chunks[i].length = int64(len(chunk.Raw)) + int64(chunk.DataLen)
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].pos < chunks[j].pos
+	})
+	return chunks, nil
+}
+
+func (unit *WorkUnit) setConnections(known map[uint32]*ConnectionHeader, conns map[uint32]bool) error {
+	for conn := range conns {
+		hdr, ok := known[conn]
+		if !ok {
+			return fmt.Errorf("connection %d of the chunk info is not in the index section: %w", conn, errNotFoundConnectionHeader)
+		}
+
+		unit.Connections = append(unit.Connections, WorkConnection{
+			Conn:              conn,
+			Topic:             hdr.Topic,
+			Type:              hdr.Type,
+			MD5Sum:            hdr.MD5Sum,
+			MessageDefinition: hdr.RawMessageDefinition,
+		})
+	}
+
+	sort.Slice(unit.Connections, func(i, j int) bool {
+		return unit.Connections[i].Conn < unit.Connections[j].Conn
+	})
+	return nil
+}
+
+// NewDecoder creates a decoder that decodes the chunks of the unit from r, which is the bag that
+// the unit is partitioned from, e.g. a file on a shared file system. The decoder reads a bag
+// that only has the chunks of the unit, and the connection records of the unit before them. opts
+// are passed to NewDecoder.
+func (unit *WorkUnit) NewDecoder(r io.ReaderAt, opts ...DecoderOption) *Decoder {
+	var prefix bytes.Buffer
+	fmt.Fprintf(&prefix, versionFormat, supportedVersion.Major, supportedVersion.Minor)
+	// the unit doesn't have an index section
+	bagHeader, _ := encodeBagHeader(0, uint32(len(unit.Connections)), uint32(unit.Chunks), nil)
+	prefix.Write(bagHeader)
+	for _, conn := range unit.Connections {
+		prefix.Write(encodeConnectionRecord(conn.Conn, &ConnectionHeader{
+			Topic:                conn.Topic,
+			Type:                 conn.Type,
+			MD5Sum:               conn.MD5Sum,
+			RawMessageDefinition: conn.MessageDefinition,
+		}))
+	}
+
+	return NewDecoder(io.MultiReader(&prefix, io.NewSectionReader(r, unit.Offset, unit.Length)), opts...)
+}
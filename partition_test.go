@@ -0,0 +1,147 @@
+package rosbag
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type indexedTestMessage struct {
+	Conn uint32
+	X    uint8
+}
+
+// writeIndexedTestBag writes a bag with an index section like rosbag record does, the connection
+// records are only in the first chunk that has their messages. The record time of a message is
+// its value in seconds.
+func writeIndexedTestBag(t *testing.T, chunks ...[]indexedTestMessage) []byte {
+	opts := []testBagOption{
+		withTestConns(
+			&ConnectionHeader{Topic: "/a", Type: "test_msgs/Test", MD5Sum: "*", RawMessageDefinition: "uint8 x"},
+			&ConnectionHeader{Topic: "/b", Type: "test_msgs/Test", MD5Sum: "*", RawMessageDefinition: "uint8 x"},
+		),
+		withTestIndexed(),
+	}
+
+	for _, messages := range chunks {
+		records := make([]testRecord, len(messages))
+		for i, msg := range messages {
+			records[i] = testRecord{Conn: msg.Conn, Time: time.Unix(int64(msg.X), 0), Data: []byte{msg.X}}
+		}
+		opts = append(opts, withTestRecords(records...))
+	}
+	return writeTestBag(t, opts...)
+}
+
+// readWorkUnit decodes the messages of unit from raw.
+func readWorkUnit(t *testing.T, unit *WorkUnit, raw []byte) []indexedTestMessage {
+	return readIndexedTestMessages(t, unit.NewDecoder(bytes.NewReader(raw)))
+}
+
+func readIndexedTestMessages(t *testing.T, decoder *Decoder) []indexedTestMessage {
+	var messages []indexedTestMessage
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return messages
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			conn, err := msg.Conn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := make(map[string]interface{})
+			err = msg.ViewAs(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, indexedTestMessage{Conn: conn, X: v["x"].(uint8)})
+		}
+		record.Close()
+	}
+}
+
+func TestPartition(t *testing.T) {
+	chunks := [][]indexedTestMessage{
+		{{0, 1}, {0, 2}},
+		{{1, 3}, {0, 4}},
+		{{0, 5}},
+	}
+	raw := writeIndexedTestBag(t, chunks...)
+
+	units, err := Partition(bytes.NewReader(raw), WithWorkUnitSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(units) != len(chunks) {
+		t.Fatalf("expected a unit for every chunk, but got %d units", len(units))
+	}
+
+	// the units are shipped to the workers
+	encoded, err := json.Marshal(units)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shipped []*WorkUnit
+	err = json.Unmarshal(encoded, &shipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, unit := range shipped {
+		if unit.Index != i || unit.Chunks != 1 || unit.Messages != uint64(len(chunks[i])) {
+			t.Fatalf("expected unit %d to have 1 chunk with %d messages, but got %+v", i, len(chunks[i]), unit)
+		}
+
+		if !unit.StartTime.Equal(time.Unix(int64(chunks[i][0].X), 0)) {
+			t.Fatalf("expected unit %d to start at %ds, but got %v", i, chunks[i][0].X, unit.StartTime)
+		}
+
+		// the connections of the last unit are only written in the previous chunks
+		if diff := cmp.Diff(chunks[i], readWorkUnit(t, unit, raw)); diff != "" {
+			t.Fatalf("messages of unit %d are not matched:\n\n%s", i, diff)
+		}
+	}
+
+	if len(shipped[2].Connections) != 1 || shipped[2].Connections[0].Topic != "/a" {
+		t.Fatalf("expected the last unit to only need /a, but got %+v", shipped[2].Connections)
+	}
+
+	units, err = Partition(bytes.NewReader(raw), WithWorkUnits(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(units) != 2 || units[0].Chunks != 2 || units[1].Chunks != 1 {
+		t.Fatalf("expected 2 units of 2 and 1 chunks, but got %d units", len(units))
+	}
+
+	expected := append(append([]indexedTestMessage(nil), chunks[0]...), chunks[1]...)
+	if diff := cmp.Diff(expected, readWorkUnit(t, units[0], raw)); diff != "" {
+		t.Fatalf("messages of the first unit are not matched:\n\n%s", diff)
+	}
+
+	if !units[0].EndTime.Equal(time.Unix(4, 0)) || len(units[0].Connections) != 2 {
+		t.Fatalf("expected the first unit to end at 4s with 2 connections, but got %+v", units[0])
+	}
+}
+
+func TestPartitionNotIndexed(t *testing.T) {
+	raw := writePlanTestBag(t)
+	_, err := Partition(bytes.NewReader(raw))
+	if err != errNotIndexed {
+		t.Fatalf("expected %v, but got %v", errNotIndexed, err)
+	}
+}
@@ -0,0 +1,75 @@
+package rosbag
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	regexpPatternPrefix = "re:"
+)
+
+// Pattern matches names, e.g. topics or message types, against one of the following syntaxes:
+//
+//   - "re:<expr>" matches names that fully match the regular expression expr, e.g. "re:/tf.*".
+//   - A pattern that contains any of "*?[" is a glob pattern with the syntax of path.Match, e.g.
+//     "/camera/*/image_raw". Note that "*" doesn't match across "/".
+//   - Any other pattern matches the name exactly.
+type Pattern struct {
+	raw    string
+	glob   bool
+	regexp *regexp.Regexp
+}
+
+// CompilePattern parses pattern, and returns a Pattern that can be used to match names.
+func CompilePattern(pattern string) (*Pattern, error) {
+	p := Pattern{raw: pattern}
+
+	if strings.HasPrefix(pattern, regexpPatternPrefix) {
+		expr := strings.TrimPrefix(pattern, regexpPatternPrefix)
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		p.regexp = re
+	} else if strings.ContainsAny(pattern, "*?[") {
+		// validate the pattern upfront so that Match doesn't need to report errors
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+
+		p.glob = true
+	}
+
+	return &p, nil
+}
+
+// MustCompilePattern is like CompilePattern but panics if pattern can't be parsed.
+func MustCompilePattern(pattern string) *Pattern {
+	p, err := CompilePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match reports whether name matches the pattern.
+func (p *Pattern) Match(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+
+	if p.glob {
+		matched, _ := path.Match(p.raw, name)
+		return matched
+	}
+
+	return p.raw == name
+}
+
+// String returns the source text of the pattern.
+func (p *Pattern) String() string {
+	return p.raw
+}
@@ -0,0 +1,45 @@
+package rosbag
+
+import (
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	testCases := []struct {
+		Pattern  string
+		Name     string
+		Expected bool
+	}{
+		{Pattern: "/tf", Name: "/tf", Expected: true},
+		{Pattern: "/tf", Name: "/tf_static", Expected: false},
+		{Pattern: "/camera/*/image_raw", Name: "/camera/front/image_raw", Expected: true},
+		{Pattern: "/camera/*/image_raw", Name: "/camera/front/left/image_raw", Expected: false},
+		{Pattern: "/robot?/odom", Name: "/robot1/odom", Expected: true},
+		{Pattern: "visualization_msgs/*", Name: "visualization_msgs/MarkerArray", Expected: true},
+		{Pattern: "re:/tf.*", Name: "/tf_static", Expected: true},
+		{Pattern: "re:/tf.*", Name: "/robot/tf", Expected: false},
+		{Pattern: "re:/unit[0-9]+/.*", Name: "/unit12/lidar/points", Expected: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Pattern+" "+testCase.Name, func(t *testing.T) {
+			p, err := CompilePattern(testCase.Pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := p.Match(testCase.Name); actual != testCase.Expected {
+				t.Fatalf("expected match to be %v, but got %v", testCase.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestCompilePatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"re:(", "/camera/[a"} {
+		if _, err := CompilePattern(pattern); err == nil {
+			t.Fatalf("expected %s to fail", pattern)
+		}
+	}
+}
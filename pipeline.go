@@ -0,0 +1,232 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Sink consumes the messages of a Pipeline, e.g. to export them in a format.
+type Sink interface {
+	// WriteMessage is called with every message of the bag in order. msg is shared by the sinks,
+	// so it must not be modified, and it must not be used after WriteMessage returns.
+	WriteMessage(msg *PipelineMessage) error
+	// Close is called once after the last message, or after the pipeline fails.
+	Close() error
+}
+
+// SinkFunc adapts a function to a Sink that has nothing to close.
+type SinkFunc func(msg *PipelineMessage) error
+
+// WriteMessage calls fn with msg.
+func (fn SinkFunc) WriteMessage(msg *PipelineMessage) error {
+	return fn(msg)
+}
+
+// Close does nothing.
+func (fn SinkFunc) Close() error {
+	return nil
+}
+
+// jsonSink writes the messages as JSON Lines.
+type jsonSink struct {
+	encoder *JSONEncoder
+	w       io.Writer
+}
+
+// NewJSONSink creates a sink that writes the messages to w with a JSONEncoder. w is closed with
+// the sink when it's an io.Closer.
+func NewJSONSink(w io.Writer) Sink {
+	return &jsonSink{encoder: NewJSONEncoder(w), w: w}
+}
+
+func (sink *jsonSink) WriteMessage(msg *PipelineMessage) error {
+	return sink.encoder.Encode(msg.RecordMessageData)
+}
+
+func (sink *jsonSink) Close() error {
+	if closer, ok := sink.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// PipelineMessage is a message data record that is shared by the sinks of a Pipeline. The record
+// is closed by the pipeline after every sink is done with it.
+type PipelineMessage struct {
+	*RecordMessageData
+	// refs is the number of sinks that still use the record
+	refs int32
+
+	once sync.Once
+	m    map[string]interface{}
+	err  error
+}
+
+// Map returns the message viewed as a map. The message is only decoded by the first sink that
+// calls Map, and the map is shared by the sinks, so it must not be modified.
+func (msg *PipelineMessage) Map() (map[string]interface{}, error) {
+	msg.once.Do(func() {
+		msg.m = make(map[string]interface{})
+		msg.err = msg.ViewAs(msg.m)
+	})
+	return msg.m, msg.err
+}
+
+// release closes the record when the last sink releases it.
+func (msg *PipelineMessage) release() {
+	if atomic.AddInt32(&msg.refs, -1) == 0 {
+		msg.RecordMessageData.Close()
+	}
+}
+
+// Pipeline decodes a bag once, and feeds every message to multiple sinks concurrently, so a bag
+// can be exported to several formats without reading it once per format. Every sink runs in its
+// own goroutine with its own buffer. With BackpressureBlock, the default, decoding waits for the
+// slowest sink, so the memory of the pipeline is bounded by the buffers of the sinks.
+type Pipeline struct {
+	decoder *Decoder
+	sinks   []*pipelineSink
+}
+
+type pipelineSink struct {
+	// dropped is accessed atomically, so it's kept first to be 64-bit aligned
+	dropped uint64
+
+	sink   Sink
+	config streamConfig
+	c      chan *PipelineMessage
+}
+
+// NewPipeline creates a pipeline that reads the rest of the bag from decoder. The decoder must not
+// be used by the caller while the pipeline is running.
+func NewPipeline(decoder *Decoder) *Pipeline {
+	return &Pipeline{decoder: decoder}
+}
+
+// AddSink adds sink to the pipeline. The buffer and the backpressure policy of the sink are
+// configured like a Stream, e.g. WithBackpressure(BackpressureDropOldest) for a sink that only
+// needs the recent messages. Sinks must be added before Run.
+func (pipeline *Pipeline) AddSink(sink Sink, opts ...StreamOption) {
+	config := streamConfig{
+		bufferLen: defaultStreamBufferLen,
+		policy:    BackpressureBlock,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	pipeline.sinks = append(pipeline.sinks, &pipelineSink{sink: sink, config: config})
+}
+
+// Dropped returns the number of messages that have been dropped by the backpressure policy of
+// every sink, in the order that the sinks are added.
+func (pipeline *Pipeline) Dropped() []uint64 {
+	dropped := make([]uint64, len(pipeline.sinks))
+	for i, sink := range pipeline.sinks {
+		dropped[i] = atomic.LoadUint64(&sink.dropped)
+	}
+	return dropped
+}
+
+// Run feeds the messages to the sinks until the end of the bag, and closes the sinks. When a sink
+// fails, the pipeline stops, and the first error is returned with the index of the sink.
+func (pipeline *Pipeline) Run() error {
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	done := make(chan struct{})
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			close(done)
+		}
+	}
+
+	for i, sink := range pipeline.sinks {
+		sink.c = make(chan *PipelineMessage, sink.config.bufferLen)
+		wg.Add(1)
+		go func(i int, sink *pipelineSink) {
+			defer wg.Done()
+
+			var err error
+			for msg := range sink.c {
+				// the pipeline is stopped on the first failure, and the rest of the messages are
+				// only released
+				if err == nil {
+					err = sink.sink.WriteMessage(msg)
+					if err != nil {
+						fail(fmt.Errorf("sink %d: %w", i, err))
+					}
+				}
+				msg.release()
+			}
+
+			closeErr := sink.sink.Close()
+			if err == nil && closeErr != nil {
+				fail(fmt.Errorf("sink %d: %w", i, closeErr))
+			}
+		}(i, sink)
+	}
+
+	err := pipeline.feed(done)
+	for _, sink := range pipeline.sinks {
+		close(sink.c)
+	}
+	wg.Wait()
+
+	if err != nil {
+		fail(err)
+	}
+	return firstErr
+}
+
+// feed reads the messages from the decoder, and sends them to the sinks until EOF, or done is
+// closed.
+func (pipeline *Pipeline) feed(done <-chan struct{}) error {
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		record, err := pipeline.decoder.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		data, ok := record.(*RecordMessageData)
+		if !ok || len(pipeline.sinks) == 0 {
+			record.Close()
+			continue
+		}
+
+		msg := &PipelineMessage{RecordMessageData: data, refs: int32(len(pipeline.sinks))}
+		for i, sink := range pipeline.sinks {
+			if !sink.send(msg, done) {
+				for range pipeline.sinks[i:] {
+					msg.release()
+				}
+				return nil
+			}
+		}
+	}
+}
+
+// send sends msg based on the backpressure policy of the sink. It returns false when done is
+// closed before msg is sent, msg is not released then.
+func (sink *pipelineSink) send(msg *PipelineMessage, done <-chan struct{}) bool {
+	return sendWithBackpressure(sink.c, msg, sink.config.policy, done, &sink.dropped, (*PipelineMessage).release)
+}
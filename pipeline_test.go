@@ -0,0 +1,169 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// closeRecorder records whether the sink has been closed.
+type closeRecorder struct {
+	SinkFunc
+	closed bool
+}
+
+func (sink *closeRecorder) Close() error {
+	sink.closed = true
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	values := []uint8{1, 2, 3, 4, 5}
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	var jsonl bytes.Buffer
+	pipeline := NewPipeline(NewDecoder(bytes.NewReader(raw)))
+	pipeline.AddSink(NewJSONSink(&jsonl))
+
+	var actual []uint8
+	maps := make([][]uintptr, 2)
+	for i := range maps {
+		i := i
+		pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+			m, err := msg.Map()
+			if err != nil {
+				return err
+			}
+
+			maps[i] = append(maps[i], reflect.ValueOf(m).Pointer())
+			if i == 0 {
+				actual = append(actual, m["x"].(uint8))
+			}
+			return nil
+		}), WithStreamBuffer(1))
+	}
+
+	err := pipeline.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(values, actual) {
+		t.Fatalf("expected messages to be %v, but got %v", values, actual)
+	}
+
+	// every message is decoded once for both sinks
+	if !reflect.DeepEqual(maps[0], maps[1]) {
+		t.Fatal("expected the sinks to share the decoded messages")
+	}
+
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	if len(lines) != len(values) || lines[0] != `{"x":1}` {
+		t.Fatalf("unexpected JSON lines: %q", lines)
+	}
+}
+
+func TestPipelineSinkError(t *testing.T) {
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(1, 2, 3, 4, 5)...)
+
+	errSink := errors.New("sink failed")
+	pipeline := NewPipeline(NewDecoder(bytes.NewReader(raw)))
+	other := &closeRecorder{SinkFunc: func(msg *PipelineMessage) error { return nil }}
+	pipeline.AddSink(other)
+
+	var written int
+	pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+		written++
+		if written == 2 {
+			return errSink
+		}
+		return nil
+	}))
+
+	err := pipeline.Run()
+	if !errors.Is(err, errSink) || !strings.HasPrefix(err.Error(), "sink 1: ") {
+		t.Fatalf("expected the error of the second sink, but got %v", err)
+	}
+
+	if written != 2 {
+		t.Fatalf("expected the failed sink to get no more messages, but got %d", written)
+	}
+
+	if !other.closed {
+		t.Fatal("expected the other sink to be closed")
+	}
+}
+
+func TestPipelineSinkErrorStopsDecoding(t *testing.T) {
+	values := make([]uint8, 200)
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	errSink := errors.New("sink failed")
+	pipeline := NewPipeline(NewDecoder(bytes.NewReader(raw)))
+	pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+		return errSink
+	}), WithStreamBuffer(1))
+
+	var received int
+	pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+		received++
+		return nil
+	}), WithStreamBuffer(1))
+
+	err := pipeline.Run()
+	if !errors.Is(err, errSink) {
+		t.Fatalf("expected the error of the first sink, but got %v", err)
+	}
+
+	// the first sink fails on the first message, so only the messages that are in flight by then
+	// reach the other sink
+	if received >= len(values)/2 {
+		t.Fatalf("expected decoding to stop after the failure, but the other sink got %d of %d messages", received, len(values))
+	}
+}
+
+func TestPipelineBackpressure(t *testing.T) {
+	values := []uint8{1, 2, 3, 4, 5}
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	pipeline := NewPipeline(NewDecoder(bytes.NewReader(raw)))
+	gate := make(chan struct{})
+	var slow []uint8
+	pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+		// the slow sink is stuck on the first message until the whole bag has been sent
+		<-gate
+		m, err := msg.Map()
+		if err != nil {
+			return err
+		}
+
+		slow = append(slow, m["x"].(uint8))
+		return nil
+	}), WithStreamBuffer(1), WithBackpressure(BackpressureDropNewest))
+
+	var fast int
+	pipeline.AddSink(SinkFunc(func(msg *PipelineMessage) error {
+		fast++
+		if fast == len(values) {
+			close(gate)
+		}
+		return nil
+	}))
+
+	err := pipeline.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the slow sink gets at most the message that it's stuck on, and the buffered one
+	dropped := pipeline.Dropped()
+	if len(slow) == 0 || len(slow) > 2 || slow[0] != 1 || uint64(len(slow))+dropped[0] != uint64(len(values)) || dropped[1] != 0 {
+		t.Fatalf("unexpected messages of the slow sink %v with %v dropped messages", slow, dropped)
+	}
+}
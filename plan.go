@@ -0,0 +1,344 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidPlanType = errors.New("plan type must be a struct")
+)
+
+// builtinGoTypes are the Go types that the builtin types are decoded as.
+var builtinGoTypes = map[MessageFieldType]reflect.Type{
+	MessageFieldTypeBool:     reflect.TypeOf(false),
+	MessageFieldTypeInt8:     reflect.TypeOf(int8(0)),
+	MessageFieldTypeUint8:    reflect.TypeOf(uint8(0)),
+	MessageFieldTypeInt16:    reflect.TypeOf(int16(0)),
+	MessageFieldTypeUint16:   reflect.TypeOf(uint16(0)),
+	MessageFieldTypeInt32:    reflect.TypeOf(int32(0)),
+	MessageFieldTypeUint32:   reflect.TypeOf(uint32(0)),
+	MessageFieldTypeInt64:    reflect.TypeOf(int64(0)),
+	MessageFieldTypeUint64:   reflect.TypeOf(uint64(0)),
+	MessageFieldTypeFloat32:  reflect.TypeOf(float32(0)),
+	MessageFieldTypeFloat64:  reflect.TypeOf(float64(0)),
+	MessageFieldTypeString:   reflect.TypeOf(""),
+	MessageFieldTypeWString:  reflect.TypeOf(""),
+	MessageFieldTypeTime:     reflect.TypeOf(time.Time{}),
+	MessageFieldTypeDuration: reflect.TypeOf(time.Duration(0)),
+}
+
+// PlanError is returned by CompilePlan with every mismatch between the message definition and the
+// struct. The errors are *FieldError for the fields whose types don't match, and plain errors for
+// invalid struct tags, or tagged struct fields that are not in the message definition.
+type PlanError struct {
+	Errors []error
+}
+
+func (err *PlanError) Error() string {
+	msgs := make([]string, len(err.Errors))
+	for i, fieldErr := range err.Errors {
+		msgs[i] = fieldErr.Error()
+	}
+	return fmt.Sprintf("%d mismatches between the message definition and the struct: %s", len(err.Errors), strings.Join(msgs, "; "))
+}
+
+type planField struct {
+	def *MessageFieldDefinition
+	// index is the index of the struct field, it's -1 when the message field is not mapped
+	index   int
+	options structFieldOptions
+	// ptr marks that the struct field, or the slice element for arrays, is a pointer to a struct
+	ptr bool
+	sub *Plan
+}
+
+type planConstant struct {
+	index int
+	value reflect.Value
+}
+
+// Plan decodes messages of a message definition into a struct type that has been validated ahead
+// of time by CompilePlan. Unlike ViewAs, the struct fields are resolved once, so a plan is meant to
+// be reused for every message of a connection. A plan is safe for concurrent use.
+type Plan struct {
+	typ       reflect.Type
+	fields    []planField
+	constants []planConstant
+}
+
+// CompilePlan validates the struct type t against def, and returns a plan that decodes the
+// messages of def into values of t. All mismatches are reported at once in a *PlanError, so
+// schema errors are caught at startup instead of in the middle of a bag. The struct fields are
+// matched the same way as ViewAs, including the rosbag struct tag options.
+func CompilePlan(def *MessageDefinition, t reflect.Type) (*Plan, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, errInvalidPlanType
+	}
+
+	def.normalizeConstants()
+	var errs []error
+	plan := compilePlan(def, t, &errs)
+	if len(errs) > 0 {
+		return nil, &PlanError{Errors: errs}
+	}
+	return plan, nil
+}
+
+func compilePlan(def *MessageDefinition, t reflect.Type, errs *[]error) *Plan {
+	plan := Plan{typ: t}
+
+	type mappedField struct {
+		index   int
+		options structFieldOptions
+		tagged  bool
+		used    bool
+	}
+	mapped := make(map[string]*mappedField)
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, ok, err := parseStructTag(field)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		// unexported fields can't be set
+		_, tagged := field.Tag.Lookup(rosbagStructTag)
+		if field.PkgPath != "" {
+			if tagged {
+				*errs = append(*errs, fmt.Errorf("struct field %s is tagged, but it's not exported", field.Name))
+			}
+			continue
+		}
+		mapped[name] = &mappedField{index: i, options: options, tagged: tagged}
+		names = append(names, name)
+	}
+
+	addConstant := func(name string, rosType string, value interface{}) {
+		m, ok := mapped[name]
+		if !ok {
+			return
+		}
+		m.used = true
+
+		goType := t.Field(m.index).Type
+		v := reflect.ValueOf(value)
+		if !v.Type().AssignableTo(goType) {
+			*errs = append(*errs, &FieldError{Path: name, ROSType: rosType, GoType: goType})
+			return
+		}
+		plan.constants = append(plan.constants, planConstant{index: m.index, value: v})
+	}
+
+	for _, constant := range def.Constants {
+		if constant.Value != nil {
+			addConstant(constant.Name, constant.Type.String(), constant.Value)
+		}
+	}
+
+	for _, field := range def.Fields {
+		pf := planField{def: field, index: -1}
+		m, ok := mapped[field.Name]
+		if !ok || m.options.omit {
+			if ok {
+				m.used = true
+			}
+			plan.fields = append(plan.fields, pf)
+			continue
+		}
+		m.used = true
+		pf.index = m.index
+		pf.options = m.options
+
+		goType := t.Field(m.index).Type
+		mismatch := &FieldError{Path: field.Name, ROSType: field.rosType(), GoType: goType}
+		if field.Type != MessageFieldTypeComplex {
+			expected, ok := builtinGoTypes[field.Type]
+			if ok && field.IsArray {
+				expected = reflect.SliceOf(expected)
+			}
+
+			if !ok || !expected.AssignableTo(goType) {
+				*errs = append(*errs, mismatch)
+				continue
+			}
+			plan.fields = append(plan.fields, pf)
+			continue
+		}
+
+		structType := goType
+		prefix := field.Name
+		if field.IsArray {
+			if structType.Kind() != reflect.Slice {
+				*errs = append(*errs, mismatch)
+				continue
+			}
+			structType = structType.Elem()
+			prefix += "[]"
+		}
+
+		if structType.Kind() == reflect.Ptr {
+			pf.ptr = true
+			structType = structType.Elem()
+		}
+
+		if structType.Kind() != reflect.Struct || structType == builtinGoTypes[MessageFieldTypeTime] {
+			*errs = append(*errs, mismatch)
+			continue
+		}
+
+		var subErrs []error
+		pf.sub = compilePlan(field.MsgType, structType, &subErrs)
+		for _, err := range subErrs {
+			*errs = append(*errs, prefixFieldError(err, prefix))
+		}
+		plan.fields = append(plan.fields, pf)
+	}
+
+	for _, name := range names {
+		if m := mapped[name]; m.tagged && !m.used {
+			*errs = append(*errs, fmt.Errorf("struct field %s is tagged with %s, which is not in the message definition", t.Field(m.index).Name, name))
+		}
+	}
+
+	return &plan
+}
+
+// Decode decodes the serialized message data into v, which must be a pointer to the struct type
+// of the plan. Like ViewAs, strings and arrays share the memory of data unless their struct field
+// has the copy option.
+func (plan *Plan) Decode(data []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Type() != plan.typ {
+		return fmt.Errorf("plan decodes into *%s, but got %T", plan.typ, v)
+	}
+
+	_, err := plan.decode(data, value.Elem())
+	return err
+}
+
+func (plan *Plan) decode(raw []byte, value reflect.Value) ([]byte, error) {
+	for _, constant := range plan.constants {
+		value.Field(constant.index).Set(constant.value)
+	}
+
+	var err error
+	for i := range plan.fields {
+		field := &plan.fields[i]
+		if field.index < 0 {
+			raw, err = skipFieldData(field.def, raw)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if field.options.maxLen > 0 {
+			err = checkFieldLength(field.def, raw, field.options.maxLen)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fieldValue := value.Field(field.index)
+		switch {
+		case field.sub == nil:
+			var v interface{}
+			v, raw, err = decodeFieldBasic(field.def, raw)
+			if err != nil {
+				return nil, err
+			}
+
+			if field.options.copy {
+				v = copyFieldValue(v)
+			}
+			fieldValue.Set(reflect.ValueOf(v))
+		case !field.def.IsArray:
+			raw, err = field.decodeElem(raw, fieldValue)
+			if err != nil {
+				return nil, prefixFieldError(err, field.def.Name)
+			}
+		default:
+			length, off, ok := fieldDecodeLength(raw, field.def.ArraySize)
+			if !ok {
+				return nil, errInvalidFormat
+			}
+			raw = raw[off:]
+
+			slice := reflect.MakeSlice(fieldValue.Type(), length, length)
+			for j := 0; j < length; j++ {
+				raw, err = field.decodeElem(raw, slice.Index(j))
+				if err != nil {
+					return nil, prefixFieldError(prefixFieldError(err, fmt.Sprintf("[%d]", j)), field.def.Name)
+				}
+			}
+			fieldValue.Set(slice)
+		}
+	}
+
+	return raw, nil
+}
+
+// decodeElem decodes a complex message into value, which is a struct or a pointer to a struct.
+func (field *planField) decodeElem(raw []byte, value reflect.Value) ([]byte, error) {
+	if field.ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	return field.sub.decode(raw, value)
+}
+
+// planCache caches the plans of the struct types that the messages of a connection are decoded
+// into by Decode, so the struct fields are only resolved once per connection and type.
+type planCache struct {
+	def *MessageDefinition
+
+	mu    sync.Mutex
+	plans map[reflect.Type]cachedPlan
+}
+
+type cachedPlan struct {
+	plan *Plan
+	err  error
+}
+
+func newPlanCache(def *MessageDefinition) *planCache {
+	return &planCache{def: def, plans: make(map[reflect.Type]cachedPlan)}
+}
+
+// get returns the plan of t, which is compiled on the first call. An error of CompilePlan is cached
+// as well, so it's returned for every message without compiling the plan again.
+func (cache *planCache) get(t reflect.Type) (*Plan, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cached, ok := cache.plans[t]
+	if !ok {
+		cached.plan, cached.err = CompilePlan(cache.def, t)
+		cache.plans[t] = cached
+	}
+	return cached.plan, cached.err
+}
+
+// plan returns the plan that decodes the message into values of t.
+func (record *RecordMessageData) plan(t reflect.Type) (*Plan, error) {
+	if record.plans == nil {
+		return CompilePlan(&record.connHdr.MessageDefinition, t)
+	}
+	return record.plans.get(t)
+}
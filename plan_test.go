@@ -0,0 +1,157 @@
+package rosbag
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const planTestMessageDefinition = `
+uint8 MODE=3
+Header header
+geometry_msgs/Point[] points
+float64[3] scale
+string label
+
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+
+MSG: geometry_msgs/Point
+float64 x
+float64 y
+`
+
+type planTestHeader struct {
+	Stamp   time.Time `rosbag:"stamp"`
+	FrameID string    `rosbag:"frame_id,copy"`
+}
+
+type planTestPoint struct {
+	X float64 `rosbag:"x"`
+	Y float64 `rosbag:"y"`
+}
+
+type planTestMessage struct {
+	Mode   uint8            `rosbag:"MODE"`
+	Header planTestHeader   `rosbag:"header"`
+	Points []*planTestPoint `rosbag:"points,maxlen=4"`
+	Scale  []float64        `rosbag:"scale"`
+	Label  string           `rosbag:"label,omit"`
+}
+
+func encodePlanTestMessage(points int) []byte {
+	raw := addData(nil, uint32(1))
+	raw = addData(raw, time.Unix(10, 20))
+	raw = addData(raw, "map")
+	raw = addData(raw, uint32(points))
+	for i := 0; i < points; i++ {
+		raw = addData(raw, float64(i))
+		raw = addData(raw, float64(-i))
+	}
+	raw = addDataMulti(raw, []float64{1, 2, 3}, false)
+	return addData(raw, "label")
+}
+
+func TestCompilePlan(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(planTestMessageDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := CompilePlan(&def, reflect.TypeOf(planTestMessage{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual planTestMessage
+	err = plan.Decode(encodePlanTestMessage(2), &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := planTestMessage{
+		Mode: 3,
+		Header: planTestHeader{
+			Stamp:   time.Unix(10, 20),
+			FrameID: "map",
+		},
+		Points: []*planTestPoint{{X: 0, Y: 0}, {X: 1, Y: -1}},
+		Scale:  []float64{1, 2, 3},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("Decoded value is not matched:\n\n%s", diff)
+	}
+
+	err = plan.Decode(encodePlanTestMessage(5), &actual)
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) || lengthErr.Path != "points" {
+		t.Fatalf("expected a LengthError for points, but got %v", err)
+	}
+
+	err = plan.Decode(encodePlanTestMessage(2), actual)
+	if err == nil {
+		t.Fatal("expected an error when decoding into a non-pointer")
+	}
+}
+
+func TestCompilePlanMismatches(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(planTestMessageDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type badPoint struct {
+		X int32 `rosbag:"x"`
+	}
+
+	type badMessage struct {
+		Mode   string `rosbag:"MODE"`
+		Header struct {
+			Stamp time.Duration `rosbag:"stamp"`
+		} `rosbag:"header"`
+		Points  []badPoint `rosbag:"points"`
+		Scale   []float32  `rosbag:"scale"`
+		Label   int        `rosbag:"label"`
+		Missing string     `rosbag:"missing"`
+		Invalid string     `rosbag:"invalid,unknown"`
+	}
+
+	_, err = CompilePlan(&def, reflect.TypeOf(&badMessage{}))
+	var planErr *PlanError
+	if !errors.As(err, &planErr) {
+		t.Fatalf("expected a PlanError, but got %v", err)
+	}
+
+	var paths []string
+	var others int
+	for _, err := range planErr.Errors {
+		var fieldErr *FieldError
+		if errors.As(err, &fieldErr) {
+			paths = append(paths, fieldErr.Path)
+		} else {
+			others++
+		}
+	}
+
+	expected := []string{"MODE", "header.stamp", "points[].x", "scale", "label"}
+	if diff := cmp.Diff(expected, paths); diff != "" {
+		t.Fatalf("Mismatched fields are not matched:\n\n%s", diff)
+	}
+
+	// the invalid tag, and the tagged field that's missing from the definition
+	if others != 2 {
+		t.Fatalf("expected 2 other errors, but got %d: %v", others, planErr)
+	}
+
+	_, err = CompilePlan(&def, reflect.TypeOf(1))
+	if err != errInvalidPlanType {
+		t.Fatalf("expected %v, but got %v", errInvalidPlanType, err)
+	}
+}
@@ -0,0 +1,323 @@
+package rosbag
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// The topic, the type, and the definition of the clock messages of use_sim_time workflows.
+const (
+	ClockTopic             = "/clock"
+	ClockMessageType       = "rosgraph_msgs/Clock"
+	ClockMessageDefinition = "time clock\n"
+)
+
+var (
+	errInvalidPlaybackRate = errors.New("playback rate must be positive")
+)
+
+// EncodeClock serializes a rosgraph_msgs/Clock message at t, e.g. to publish the clock of a
+// Player.
+func EncodeClock(t time.Time) []byte {
+	return timeField(t)
+}
+
+// PlaybackClock is the simulated time of a Player. The time starts at the record time of the
+// first message, and advances with the wall time multiplied by the rate. It can be paused,
+// stepped, and jumped at runtime from any goroutine, so that recorded data can drive
+// simulation-style consumers deterministically.
+type PlaybackClock struct {
+	mu sync.Mutex
+	// base is the simulated time at the wall time wall
+	base    time.Time
+	wall    time.Time
+	rate    float64
+	paused  bool
+	started bool
+	// skipBefore is the latest time that the clock has jumped to
+	skipBefore time.Time
+	// changed is closed when the clock is changed, so that waiting players wake up
+	changed chan struct{}
+	now     func() time.Time
+}
+
+// NewPlaybackClock creates a running clock with a rate of 1.
+func NewPlaybackClock() *PlaybackClock {
+	return &PlaybackClock{
+		rate:    1,
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// Now returns the simulated time. It's zero until the first message is played, or the clock is
+// jumped.
+func (clock *PlaybackClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.current()
+}
+
+func (clock *PlaybackClock) current() time.Time {
+	if clock.paused || !clock.started {
+		return clock.base
+	}
+	return clock.base.Add(time.Duration(float64(clock.now().Sub(clock.wall)) * clock.rate))
+}
+
+// update applies fn to the clock from the current simulated time, and wakes up the waiting
+// players.
+func (clock *PlaybackClock) update(fn func()) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.base = clock.current()
+	clock.wall = clock.now()
+	fn()
+	close(clock.changed)
+	clock.changed = make(chan struct{})
+}
+
+// Rate returns the number of simulated seconds per wall second.
+func (clock *PlaybackClock) Rate() float64 {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.rate
+}
+
+// SetRate sets the number of simulated seconds per wall second, e.g. 2 plays twice as fast. An
+// error is returned when rate is not positive.
+func (clock *PlaybackClock) SetRate(rate float64) error {
+	if rate <= 0 {
+		return errInvalidPlaybackRate
+	}
+
+	clock.update(func() {
+		clock.rate = rate
+	})
+	return nil
+}
+
+// Pause stops the simulated time until Resume.
+func (clock *PlaybackClock) Pause() {
+	clock.update(func() {
+		clock.paused = true
+	})
+}
+
+// Resume continues the simulated time after Pause.
+func (clock *PlaybackClock) Resume() {
+	clock.update(func() {
+		clock.paused = false
+	})
+}
+
+// Paused reports whether the clock is paused.
+func (clock *PlaybackClock) Paused() bool {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.paused
+}
+
+// Step advances the simulated time by d. It's usually called while the clock is paused to play
+// the messages in lockstep with a simulation.
+func (clock *PlaybackClock) Step(d time.Duration) {
+	clock.update(func() {
+		clock.base = clock.base.Add(d)
+	})
+}
+
+// Jump sets the simulated time to t. The messages that are recorded before t are skipped, like
+// seeking forward. Since the bag is streamed, jumping backward doesn't replay the messages, it
+// only delays the next ones.
+func (clock *PlaybackClock) Jump(t time.Time) {
+	clock.update(func() {
+		clock.base = t
+		clock.started = true
+		clock.skipBefore = t
+	})
+}
+
+// start starts the clock at t unless it's already started.
+func (clock *PlaybackClock) start(t time.Time) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if !clock.started {
+		clock.base = t
+		clock.wall = clock.now()
+		clock.started = true
+	}
+}
+
+// until returns the simulated time, the wall time until t when the clock keeps running, and a
+// channel that's closed when the clock changes. The wall time is negative when the clock is
+// paused.
+func (clock *PlaybackClock) until(t time.Time) (time.Time, time.Duration, <-chan struct{}) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	now := clock.current()
+	delay := time.Duration(-1)
+	if !clock.paused {
+		delay = time.Duration(float64(t.Sub(now)) / clock.rate)
+	}
+	return now, delay, clock.changed
+}
+
+func (clock *PlaybackClock) skipped(t time.Time) bool {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return t.Before(clock.skipBefore)
+}
+
+// PlayerOption configures an optional behavior of a Player.
+type PlayerOption func(*Player)
+
+// WithPlaybackClock makes the player use clock, e.g. a clock that is paused before playing to
+// step through the messages. By default, the player has a running clock with a rate of 1.
+func WithPlaybackClock(clock *PlaybackClock) PlayerOption {
+	return func(player *Player) {
+		player.clock = clock
+	}
+}
+
+// WithClockPublisher calls publish with the simulated time every period of wall time while the
+// clock is running, and when the clock is changed, like rosbag play --clock. The messages can be
+// serialized with EncodeClock, and published on ClockTopic for use_sim_time consumers. The
+// same time is never published twice in a row.
+func WithClockPublisher(period time.Duration, publish func(t time.Time) error) PlayerOption {
+	return func(player *Player) {
+		player.clockPeriod = period
+		player.publishClock = publish
+	}
+}
+
+// Player plays a bag, it dispatches the messages to the handlers that are subscribed to the
+// decoder at their record times on a PlaybackClock.
+type Player struct {
+	decoder      *Decoder
+	clock        *PlaybackClock
+	clockPeriod  time.Duration
+	publishClock func(t time.Time) error
+	published    time.Time
+}
+
+// NewPlayer creates a player that plays the messages of decoder. The handlers are subscribed
+// with Decoder.Subscribe. opts can be used to enable optional behaviors, see PlayerOption.
+func NewPlayer(decoder *Decoder, opts ...PlayerOption) *Player {
+	player := Player{decoder: decoder}
+	for _, opt := range opts {
+		opt(&player)
+	}
+
+	if player.clock == nil {
+		player.clock = NewPlaybackClock()
+	}
+	return &player
+}
+
+// Clock returns the clock of the player, which can be controlled while the player is running.
+func (player *Player) Clock() *PlaybackClock {
+	return player.clock
+}
+
+// Run reads the rest of the bag, and dispatches every message to the subscribed handlers when
+// the clock reaches its record time. Messages without a subscriber are skipped without waiting.
+// Run returns nil when it reaches EOF, and ctx.Err() when ctx is done. Otherwise, it stops at the
+// first error from the decoder, a handler, or the clock publisher, and returns that error.
+func (player *Player) Run(ctx context.Context) error {
+	for {
+		record, err := player.decoder.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = player.play(ctx, record)
+		record.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (player *Player) play(ctx context.Context, record Record) error {
+	msg, ok := record.(*RecordMessageData)
+	if !ok || len(player.decoder.handlers(msg.ConnectionHeader().Topic)) == 0 {
+		return nil
+	}
+
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	player.clock.start(t)
+	if player.clock.skipped(t) {
+		return nil
+	}
+
+	err = player.wait(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	// the clock can jump while waiting
+	if player.clock.skipped(t) {
+		return nil
+	}
+	return player.decoder.dispatch(msg)
+}
+
+// wait blocks until the clock reaches t, and publishes the clock while waiting.
+func (player *Player) wait(ctx context.Context, t time.Time) error {
+	for {
+		now, delay, changed := player.clock.until(t)
+		err := player.publish(now)
+		if err != nil {
+			return err
+		}
+
+		if !now.Before(t) {
+			return nil
+		}
+
+		var timer *time.Timer
+		var fired <-chan time.Time
+		if delay >= 0 {
+			if player.publishClock != nil && player.clockPeriod > 0 && player.clockPeriod < delay {
+				delay = player.clockPeriod
+			}
+			timer = time.NewTimer(delay)
+			fired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-changed:
+		case <-fired:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (player *Player) publish(t time.Time) error {
+	if player.publishClock == nil || t.Equal(player.published) {
+		return nil
+	}
+
+	player.published = t
+	return player.publishClock(t)
+}
@@ -0,0 +1,135 @@
+package rosbag
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writePlaybackTestBag(t *testing.T, seconds ...int64) []byte {
+	records := make([]testRecord, len(seconds))
+	for i, sec := range seconds {
+		records[i] = testRecord{Time: time.Unix(sec, 0), Data: []byte{uint8(sec)}}
+	}
+	return writeTestBag(t, withTestRecords(records...))
+}
+
+func TestPlayer(t *testing.T) {
+	decoder := NewDecoder(bytes.NewReader(writePlaybackTestBag(t, 1, 2, 3, 4, 6)))
+	played := make(chan time.Time, 8)
+	err := decoder.Subscribe("/test", func(record *RecordMessageData) error {
+		t, err := record.Time()
+		played <- t
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []time.Time
+	clock := NewPlaybackClock()
+	clock.Pause()
+	player := NewPlayer(decoder, WithPlaybackClock(clock), WithClockPublisher(time.Millisecond, func(t time.Time) error {
+		published = append(published, t)
+		return nil
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- player.Run(context.Background())
+	}()
+
+	expectPlayed := func(sec int64) {
+		select {
+		case actual := <-played:
+			if !actual.Equal(time.Unix(sec, 0)) {
+				t.Fatalf("expected the message at %ds, but got %v", sec, actual)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the message at %ds to be played", sec)
+		}
+	}
+
+	// the clock starts at the first message even though it's paused
+	expectPlayed(1)
+	clock.Step(time.Second)
+	expectPlayed(2)
+
+	// the message at 3s is skipped
+	clock.Jump(time.Unix(4, 0))
+	expectPlayed(4)
+
+	err = clock.SetRate(0)
+	if err == nil {
+		t.Fatal("expected a zero rate to fail")
+	}
+
+	err = clock.SetRate(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Resume()
+	expectPlayed(6)
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(4, 0)}
+	if diff := cmp.Diff(expected, published[:3]); diff != "" {
+		t.Fatalf("published clock is not matched:\n\n%s", diff)
+	}
+
+	for i := 3; i < len(published); i++ {
+		if !published[i].After(published[i-1]) {
+			t.Fatalf("expected the running clock to move forward, but got %v", published[i-1:i+1])
+		}
+	}
+
+	if !clock.Now().After(time.Unix(6, 0)) {
+		t.Fatalf("expected the clock to be after the last message, but got %v", clock.Now())
+	}
+}
+
+func TestPlayerCancel(t *testing.T) {
+	decoder := NewDecoder(bytes.NewReader(writePlaybackTestBag(t, 1, 2)))
+	err := decoder.Subscribe("/test", func(record *RecordMessageData) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewPlaybackClock()
+	clock.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = NewPlayer(decoder, WithPlaybackClock(clock)).Run(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected the player to be canceled, but got %v", err)
+	}
+}
+
+func TestEncodeClock(t *testing.T) {
+	var def MessageDefinition
+	err := def.unmarshall([]byte(ClockMessageDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Unix(3, 500)
+	msg := make(map[string]interface{})
+	_, err = decodeMessageData(&def, EncodeClock(expected), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual, ok := msg["clock"].(time.Time); !ok || !actual.Equal(expected) {
+		t.Fatalf("expected the clock to be %v, but got %v", expected, msg["clock"])
+	}
+}
@@ -0,0 +1,183 @@
+package rosbag
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// prefetchedRecord is a top-level record that has been read ahead by a prefetcher.
+type prefetchedRecord struct {
+	// raw contains <header_len><header><data_len><data>. For chunk records, raw stops at
+	// <data_len>, and the decompressed data is stored in chunk instead.
+	raw   []byte
+	chunk []byte
+	err   error
+}
+
+// prefetcher reads top-level records and decompresses chunks in a background goroutine so that
+// the next chunk is ready by the time the consumer is done with the current one.
+type prefetcher struct {
+	reader  io.Reader
+	records chan prefetchedRecord
+	free    chan []byte
+	done    chan struct{}
+	err     error
+	// memory accounts the chunk buffers that the prefetcher allocates
+	memory *memoryAccount
+}
+
+func newPrefetcher(r io.Reader, memory *memoryAccount) *prefetcher {
+	p := &prefetcher{
+		reader: r,
+		memory: memory,
+		// While the consumer is reading the current chunk, one chunk is ready in records and
+		// another one is being decompressed.
+		records: make(chan prefetchedRecord, 1),
+		free:    make(chan []byte, 2),
+		done:    make(chan struct{}),
+	}
+
+	go p.run()
+	return p
+}
+
+func (p *prefetcher) run() {
+	defer close(p.records)
+
+	for {
+		record := p.readRecord()
+		select {
+		case p.records <- record:
+		case <-p.done:
+			return
+		}
+
+		if record.err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next prefetched record. After an error is returned, next keeps returning the
+// same error.
+func (p *prefetcher) next() prefetchedRecord {
+	if p.err != nil {
+		return prefetchedRecord{err: p.err}
+	}
+
+	record := <-p.records
+	if record.err != nil {
+		p.err = record.err
+	}
+	return record
+}
+
+// release gives a chunk buffer that is no longer used back to the prefetcher to be reused.
+func (p *prefetcher) release(chunk []byte) {
+	select {
+	case p.free <- chunk:
+	default:
+		p.memory.releaseChunks(int64(cap(chunk)))
+	}
+}
+
+// close stops the background goroutine. It's safe to call close more than once.
+func (p *prefetcher) close() {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+func (p *prefetcher) readRecord() prefetchedRecord {
+	var record RecordBase
+	var err error
+
+	record.Raw = make([]byte, 2*lenInBytes)
+	_, err = io.ReadFull(p.reader, record.Raw[:lenInBytes])
+	if err != nil {
+		return prefetchedRecord{err: err}
+	}
+	record.HeaderLen = endian.Uint32(record.Raw)
+
+	off := lenInBytes + record.HeaderLen
+	record.grow(off + lenInBytes)
+	_, err = io.ReadFull(p.reader, record.Raw[lenInBytes:off+lenInBytes])
+	if err != nil {
+		return prefetchedRecord{err: unexpectedEOF(err)}
+	}
+	record.DataLen = endian.Uint32(record.Raw[off:])
+	off += lenInBytes
+
+	op, err := record.Op()
+	if err != nil {
+		return prefetchedRecord{err: err}
+	}
+
+	if op == OpChunk {
+		chunk, err := p.readChunk(&RecordChunk{RecordBase: &record})
+		return prefetchedRecord{raw: record.Raw[:off], chunk: chunk, err: err}
+	}
+
+	record.grow(off + record.DataLen)
+	_, err = io.ReadFull(p.reader, record.Raw[off:off+record.DataLen])
+	if err != nil {
+		return prefetchedRecord{err: unexpectedEOF(err)}
+	}
+
+	return prefetchedRecord{raw: record.Raw[:off+record.DataLen]}
+}
+
+func (p *prefetcher) readChunk(record *RecordChunk) ([]byte, error) {
+	compression, err := record.Compression()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := record.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	var chunk []byte
+	select {
+	case chunk = <-p.free:
+	default:
+	}
+
+	if uint32(cap(chunk)) < size {
+		// the smaller buffer is dropped
+		p.memory.releaseChunks(int64(cap(chunk)))
+		err = p.memory.reserveChunks(int64(size))
+		if err != nil {
+			return nil, err
+		}
+		chunk = make([]byte, size)
+	}
+	chunk = chunk[:size]
+
+	compressed := &io.LimitedReader{R: p.reader, N: int64(record.DataLen)}
+	r, err := newChunkReader(compression, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.ReadFull(r, chunk)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	// Some compressors leave trailing bytes, e.g. checksums, consume them so that the next
+	// record starts at the right offset
+	_, err = io.Copy(ioutil.Discard, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	if compressed.N > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return chunk, nil
+}
@@ -0,0 +1,191 @@
+package rosbag
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// maxRawRecordLen is the maximum length of a record that is read by readRawRecord, the buffer of a
+// record grows to twice the length, so it must fit in a uint32.
+const maxRawRecordLen = math.MaxUint32 / 2
+
+var (
+	errPreloadAfterRead = errors.New("preload must happen before the first read")
+	errRecordTooLarge   = errors.New("record is larger than 2 GB, its length is likely corrupted")
+)
+
+// Preload reads the connection records and the chunk infos from the index section of the bag up
+// front, so that the connections are known before any chunk is read, e.g. for Topics. Preload
+// needs the reader that is passed to NewDecoder to be an io.ReadSeeker. It reads the bag header,
+// seeks to index_pos, and seeks back so that the chunks are streamed as usual. Preload is a no-op
+// when the reader is not seekable, or the bag doesn't have an index section.
+//
+// Read calls Preload on the first read, and ignores its errors since the bag can still be
+// streamed without the index. Preload can be called explicitly before the first Read to get the
+// error.
+func (decoder *Decoder) Preload() error {
+	if decoder.preloaded || decoder.seeker == nil {
+		return nil
+	}
+
+	if decoder.checkedVersion {
+		return errPreloadAfterRead
+	}
+	decoder.preloaded = true
+
+	start, err := decoder.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// the decoder can't continue from a wrong offset, so this error is reported by Read
+		_, err := decoder.seeker.Seek(start, io.SeekStart)
+		if err != nil && decoder.err == nil {
+			decoder.err = err
+		}
+	}()
+
+	return decoder.preloadIndex(start)
+}
+
+func (decoder *Decoder) preloadIndex(start int64) error {
+	r := bufio.NewReader(decoder.seeker)
+
+	var version Version
+	_, err := fmt.Fscanf(r, versionFormat, &version.Major, &version.Minor)
+	if err != nil {
+		return err
+	}
+
+	record, op, err := readRawRecord(r)
+	if err != nil {
+		return err
+	}
+
+	if op != OpBagHeader {
+		return fmt.Errorf("expected the bag header record, but got op %d", op)
+	}
+
+	indexPos, err := (&RecordBagHeader{RecordBase: record}).IndexPos()
+	if err != nil {
+		return err
+	}
+
+	// the bag is still being recorded, or it has been written without an index
+	if indexPos == 0 {
+		return nil
+	}
+
+	_, err = decoder.seeker.Seek(start+int64(indexPos), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r.Reset(decoder.seeker)
+	for {
+		record, op, err := readRawRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case OpConnection:
+			_, err = decoder.handleConnection(record)
+			if err != nil {
+				return err
+			}
+		case OpChunkInfo:
+			decoder.chunkInfos = append(decoder.chunkInfos, &RecordChunkInfo{RecordBase: record})
+		case OpChunk:
+			return fmt.Errorf("unexpected chunk record in the index section at %d", indexPos)
+		}
+	}
+}
+
+// readRawRecord reads a whole record from r into a new RecordBase that is not pooled, so it can
+// be kept by the decoder.
+func readRawRecord(r io.Reader) (*RecordBase, Op, error) {
+	record := RecordBase{
+		Raw: make([]byte, lenInBytes),
+	}
+
+	_, err := io.ReadFull(r, record.Raw)
+	if err != nil {
+		return nil, OpInvalid, err
+	}
+	record.HeaderLen = endian.Uint32(record.Raw)
+	if record.HeaderLen > maxRawRecordLen-2*lenInBytes {
+		return nil, OpInvalid, errRecordTooLarge
+	}
+
+	off := lenInBytes + record.HeaderLen
+	record.grow(off + lenInBytes)
+	_, err = io.ReadFull(r, record.Raw[lenInBytes:off+lenInBytes])
+	if err != nil {
+		return nil, OpInvalid, unexpectedEOF(err)
+	}
+	record.DataLen = endian.Uint32(record.Raw[off:])
+	off += lenInBytes
+	if record.DataLen > maxRawRecordLen-off {
+		return nil, OpInvalid, errRecordTooLarge
+	}
+
+	record.grow(off + record.DataLen)
+	_, err = io.ReadFull(r, record.Raw[off:off+record.DataLen])
+	if err != nil {
+		return nil, OpInvalid, unexpectedEOF(err)
+	}
+	record.Raw = record.Raw[:off+record.DataLen]
+
+	op, err := record.Op()
+	if err != nil {
+		return nil, OpInvalid, err
+	}
+
+	return &record, op, nil
+}
+
+// Topics returns the sorted topics of the connections that the decoder knows so far. When the
+// index section has been preloaded, all of the topics in the bag are known before the first
+// chunk.
+func (decoder *Decoder) Topics() []string {
+	seen := make(map[string]bool)
+	var topics []string
+	for _, hdr := range decoder.conns {
+		if !seen[hdr.Topic] {
+			seen[hdr.Topic] = true
+			topics = append(topics, hdr.Topic)
+		}
+	}
+
+	sort.Strings(topics)
+	return topics
+}
+
+// Connections returns the connection headers that the decoder knows so far by their connection
+// IDs, with the topics, the types, the md5sums, and the message definitions of the connections, so
+// the topics of a bag can be listed without viewing its messages. When the index section has been
+// preloaded, all of the connections in the bag are known before the first chunk. The headers are
+// shared with the decoder, so they must not be modified.
+func (decoder *Decoder) Connections() map[uint32]*ConnectionHeader {
+	conns := make(map[uint32]*ConnectionHeader, len(decoder.conns))
+	for conn, hdr := range decoder.conns {
+		conns[conn] = hdr
+	}
+	return conns
+}
+
+// ChunkInfos returns the chunk info records that have been preloaded from the index section. It's
+// empty when the index section hasn't been preloaded.
+func (decoder *Decoder) ChunkInfos() []*RecordChunkInfo {
+	return decoder.chunkInfos
+}
@@ -0,0 +1,52 @@
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	thumbnailQuality = 75
+)
+
+// newThumbnail scales img down so that its larger side is at most size with nearest-neighbor
+// sampling, and encodes it as a JPEG.
+func newThumbnail(img image.Image, size int) (*Thumbnail, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, maxInt(1, height*size/bounds.Dx())
+		} else {
+			width, height = maxInt(1, width*size/bounds.Dy()), size
+		}
+
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				scaled.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+			}
+		}
+		img = scaled
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Thumbnail{
+		Width:  width,
+		Height: height,
+		JPEG:   buf.Bytes(),
+	}, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
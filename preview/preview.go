@@ -0,0 +1,436 @@
+// Package preview generates compact previews of bags for archive browsing UIs, so that a bag
+// doesn't have to be decoded whenever it's browsed. A preview has the activity timeline of every
+// topic, low-rate summaries of the numeric fields, and a few thumbnails of every camera topic.
+package preview
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/camera"
+)
+
+const (
+	defaultResolution      = time.Second
+	defaultThumbnails      = 4
+	defaultThumbnailSize   = 160
+	defaultTimelineBuckets = 100
+	defaultMaxFields       = 16
+)
+
+var (
+	errInvalidResolution = errors.New("resolution must be positive")
+)
+
+type config struct {
+	resolution      time.Duration
+	thumbnails      int
+	thumbnailSize   int
+	timelineBuckets int
+	maxFields       int
+}
+
+// Option configures the content of a preview.
+type Option func(*config)
+
+// WithResolution sets the width of the buckets of the numeric summaries. The default is 1 second.
+func WithResolution(resolution time.Duration) Option {
+	return func(cfg *config) {
+		cfg.resolution = resolution
+	}
+}
+
+// WithThumbnails sets the number of thumbnails of every camera topic, they are spread evenly over
+// the bag. The default is 4, and 0 disables the thumbnails.
+func WithThumbnails(n int) Option {
+	return func(cfg *config) {
+		cfg.thumbnails = n
+	}
+}
+
+// WithThumbnailSize sets the maximum width and height of the thumbnails in pixels. The default is
+// 160.
+func WithThumbnailSize(size int) Option {
+	return func(cfg *config) {
+		cfg.thumbnailSize = size
+	}
+}
+
+// WithTimelineBuckets sets the number of buckets of the activity timelines. The default is 100, and
+// 0 disables the timelines.
+func WithTimelineBuckets(n int) Option {
+	return func(cfg *config) {
+		cfg.timelineBuckets = n
+	}
+}
+
+// WithMaxFields sets the maximum number of numeric fields that are summarized for every topic. The
+// fields are taken in the order of the message definition. The default is 16, and 0 disables the
+// summaries.
+func WithMaxFields(n int) Option {
+	return func(cfg *config) {
+		cfg.maxFields = n
+	}
+}
+
+// Preview is the preview of a bag. It only has plain fields, so it can be stored with
+// encoding/json next to the bag.
+type Preview struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Resolution is the width of the buckets of the numeric summaries in nanoseconds
+	Resolution time.Duration `json:"resolution"`
+	// Topics are sorted by their names
+	Topics []*Topic `json:"topics"`
+}
+
+// Topic is the preview of the messages of a topic.
+type Topic struct {
+	Topic    string `json:"topic"`
+	Type     string `json:"type"`
+	Messages int    `json:"messages"`
+	// Timeline is the number of messages in every bucket of the timeline, which splits
+	// [Start, End] of the preview evenly
+	Timeline []int `json:"timeline"`
+	// Fields are the numeric fields outside of arrays. They're not summarized for camera topics
+	Fields     []*Field     `json:"fields,omitempty"`
+	Thumbnails []*Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// Field summarizes the values of a numeric field in buckets of the resolution from the start of
+// the preview, the statistics of a bucket are at the same index in every slice. The statistics of
+// empty buckets are 0, since NaN can't be encoded in JSON, so Count must be checked first.
+type Field struct {
+	Path  string    `json:"path"`
+	Count []int     `json:"count"`
+	Min   []float64 `json:"min"`
+	Max   []float64 `json:"max"`
+	Mean  []float64 `json:"mean"`
+}
+
+// Thumbnail is a scaled down image of a camera topic.
+type Thumbnail struct {
+	Time   time.Time `json:"time"`
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+	JPEG   []byte    `json:"jpeg"`
+}
+
+// Generate reads the bag in r, and returns its preview. The time range of the bag is taken from
+// the index section, so an indexed bag is decoded once, and a bag without an index is read twice.
+// r is read from its current offset, which must be the beginning of the bag. opts can be used to
+// configure the content of the preview, see Option.
+//
+// The thumbnails are generated from sensor_msgs/Image messages with the mono8, mono16, rgb8, bgr8,
+// rgba8, and bgra8 encodings, and sensor_msgs/CompressedImage messages in JPEG or PNG. The
+// messages that can't be decoded don't have thumbnails.
+func Generate(r io.ReadSeeker, opts ...Option) (*Preview, error) {
+	cfg := config{
+		resolution:      defaultResolution,
+		thumbnails:      defaultThumbnails,
+		thumbnailSize:   defaultThumbnailSize,
+		timelineBuckets: defaultTimelineBuckets,
+		maxFields:       defaultMaxFields,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.resolution <= 0 {
+		return nil, errInvalidResolution
+	}
+	cfg.timelineBuckets = maxInt(cfg.timelineBuckets, 0)
+	cfg.thumbnailSize = maxInt(cfg.thumbnailSize, 1)
+
+	start, end, err := timeRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := previewBuilder{
+		cfg:     &cfg,
+		preview: Preview{Start: start, End: end, Resolution: cfg.resolution},
+		topics:  make(map[string]*topicBuilder),
+	}
+
+	decoder := rosbag.NewDecoder(r)
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg, ok := record.(*rosbag.RecordMessageData); ok {
+			err = builder.add(msg)
+		}
+		record.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return builder.build(), nil
+}
+
+// timeRange returns the earliest and the latest record times of the messages in r, and seeks r
+// back to its current offset.
+func timeRange(r io.ReadSeeker) (start time.Time, end time.Time, err error) {
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return start, end, err
+	}
+
+	update := func(first, last time.Time) {
+		if start.IsZero() || first.Before(start) {
+			start = first
+		}
+		if last.After(end) {
+			end = last
+		}
+	}
+
+	decoder := rosbag.NewDecoder(r)
+	err = decoder.Preload()
+	if err != nil {
+		return start, end, err
+	}
+
+	if chunkInfos := decoder.ChunkInfos(); len(chunkInfos) > 0 {
+		for _, chunkInfo := range chunkInfos {
+			first, err := chunkInfo.StartTime()
+			if err != nil {
+				return start, end, err
+			}
+
+			last, err := chunkInfo.EndTime()
+			if err != nil {
+				return start, end, err
+			}
+			update(first, last)
+		}
+	} else {
+		for {
+			record, err := decoder.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return start, end, err
+			}
+
+			if msg, ok := record.(*rosbag.RecordMessageData); ok {
+				var t time.Time
+				t, err = msg.Time()
+				update(t, t)
+			}
+			record.Close()
+			if err != nil {
+				return start, end, err
+			}
+		}
+	}
+
+	_, err = r.Seek(offset, io.SeekStart)
+	return start, end, err
+}
+
+type previewBuilder struct {
+	cfg     *config
+	preview Preview
+	topics  map[string]*topicBuilder
+}
+
+type topicBuilder struct {
+	topic    Topic
+	timeline []int
+	// paths are discovered from the definition of the first connection of the topic
+	paths  []*rosbag.FieldPath
+	fields []*fieldBuilder
+	// nextThumbnail is the index of the next thumbnail time
+	nextThumbnail int
+}
+
+// fieldBuilder accumulates the statistics of the buckets of a field. The buckets are kept in a
+// map since only the buckets with values are stored.
+type fieldBuilder struct {
+	buckets map[int]*bucketStats
+}
+
+type bucketStats struct {
+	count    int
+	min, max float64
+	sum      float64
+}
+
+func (builder *previewBuilder) add(msg *rosbag.RecordMessageData) error {
+	hdr := msg.ConnectionHeader()
+	topic, ok := builder.topics[hdr.Topic]
+	if !ok {
+		topic = &topicBuilder{
+			topic:    Topic{Topic: hdr.Topic, Type: hdr.Type},
+			timeline: make([]int, builder.cfg.timelineBuckets),
+		}
+
+		if !camera.IsImage(hdr.Type) && builder.cfg.maxFields > 0 {
+			for _, path := range numericPaths(&hdr.MessageDefinition, nil, builder.cfg.maxFields) {
+				topic.paths = append(topic.paths, rosbag.MustCompileFieldPath(path))
+				topic.fields = append(topic.fields, &fieldBuilder{buckets: make(map[int]*bucketStats)})
+			}
+		}
+		builder.topics[hdr.Topic] = topic
+	}
+
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	topic.topic.Messages++
+	if len(topic.timeline) > 0 {
+		topic.timeline[builder.timelineBucket(t)]++
+	}
+
+	bucket := int(t.Sub(builder.preview.Start) / builder.cfg.resolution)
+	for i, path := range topic.paths {
+		v, err := path.Float64(&hdr.MessageDefinition, msg.Data())
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			// the other connections of the topic can have different definitions
+			continue
+		}
+		topic.fields[i].add(bucket, v)
+	}
+
+	if camera.IsImage(hdr.Type) && topic.nextThumbnail < builder.cfg.thumbnails && !t.Before(builder.thumbnailTime(topic.nextThumbnail)) {
+		for topic.nextThumbnail < builder.cfg.thumbnails && !t.Before(builder.thumbnailTime(topic.nextThumbnail)) {
+			topic.nextThumbnail++
+		}
+
+		img, err := camera.Image(msg)
+		if err == camera.ErrUnsupportedImage {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		thumbnail, err := newThumbnail(img, builder.cfg.thumbnailSize)
+		if err != nil {
+			return err
+		}
+		thumbnail.Time = t
+		topic.topic.Thumbnails = append(topic.topic.Thumbnails, thumbnail)
+	}
+	return nil
+}
+
+func (builder *previewBuilder) timelineBucket(t time.Time) int {
+	duration := builder.preview.End.Sub(builder.preview.Start)
+	if duration <= 0 {
+		return 0
+	}
+
+	// the end of the bag is in the last bucket
+	n := builder.cfg.timelineBuckets
+	i := int(float64(t.Sub(builder.preview.Start)) / float64(duration) * float64(n))
+	if i >= n {
+		return n - 1
+	}
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// thumbnailTime returns the time of the i-th thumbnail, which is in the middle of the i-th of
+// equal parts of the bag.
+func (builder *previewBuilder) thumbnailTime(i int) time.Time {
+	duration := builder.preview.End.Sub(builder.preview.Start)
+	step := float64(duration) / float64(builder.cfg.thumbnails)
+	return builder.preview.Start.Add(time.Duration(step * (float64(i) + 0.5)))
+}
+
+func (builder *fieldBuilder) add(bucket int, v float64) {
+	stats, ok := builder.buckets[bucket]
+	if !ok {
+		builder.buckets[bucket] = &bucketStats{count: 1, min: v, max: v, sum: v}
+		return
+	}
+
+	stats.count++
+	stats.min = math.Min(stats.min, v)
+	stats.max = math.Max(stats.max, v)
+	stats.sum += v
+}
+
+func (builder *previewBuilder) build() *Preview {
+	buckets := int(builder.preview.End.Sub(builder.preview.Start)/builder.cfg.resolution) + 1
+	for _, topic := range builder.topics {
+		topic.topic.Timeline = topic.timeline
+		for i, path := range topic.paths {
+			field := Field{
+				Path:  path.String(),
+				Count: make([]int, buckets),
+				Min:   make([]float64, buckets),
+				Max:   make([]float64, buckets),
+				Mean:  make([]float64, buckets),
+			}
+
+			for bucket, stats := range topic.fields[i].buckets {
+				if bucket < 0 || bucket >= buckets {
+					continue
+				}
+				field.Count[bucket] = stats.count
+				field.Min[bucket] = stats.min
+				field.Max[bucket] = stats.max
+				field.Mean[bucket] = stats.sum / float64(stats.count)
+			}
+			topic.topic.Fields = append(topic.topic.Fields, &field)
+		}
+
+		builder.preview.Topics = append(builder.preview.Topics, &topic.topic)
+	}
+
+	sort.Slice(builder.preview.Topics, func(i, j int) bool {
+		return builder.preview.Topics[i].Topic < builder.preview.Topics[j].Topic
+	})
+	return &builder.preview
+}
+
+// numericPaths returns up to max paths of the numeric fields of def that are not in arrays, in the
+// order of the definition.
+func numericPaths(def *rosbag.MessageDefinition, prefix []string, max int) []string {
+	var paths []string
+	for _, field := range def.Fields {
+		if len(paths) >= max {
+			break
+		}
+
+		if field.IsArray {
+			continue
+		}
+
+		path := append(prefix[:len(prefix):len(prefix)], field.Name)
+		switch field.Type {
+		case rosbag.MessageFieldTypeString, rosbag.MessageFieldTypeWString, rosbag.MessageFieldTypeTime, rosbag.MessageFieldTypeDuration, rosbag.MessageFieldTypeBool:
+		case rosbag.MessageFieldTypeComplex:
+			if field.MsgType != nil {
+				paths = append(paths, numericPaths(field.MsgType, path, max-len(paths))...)
+			}
+		default:
+			paths = append(paths, strings.Join(path, "."))
+		}
+	}
+	return paths
+}
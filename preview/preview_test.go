@@ -0,0 +1,157 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+const testImageDefinition = `uint32 height
+uint32 width
+string encoding
+uint8 is_bigendian
+uint32 step
+uint8[] data
+`
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// encodeTestImage encodes a sensor_msgs/Image without the header of a width x 1 rgb8 image.
+func encodeTestImage(width uint32) []byte {
+	raw := appendUint32(nil, 1)
+	raw = appendUint32(raw, width)
+	raw = appendString(raw, "rgb8")
+	raw = append(raw, 0)
+	raw = appendUint32(raw, width*3)
+	raw = appendUint32(raw, width*3)
+	for i := uint32(0); i < width; i++ {
+		raw = append(raw, 0xff, 0, 0)
+	}
+	return raw
+}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	odom := builder.Connection("/odom", "test_msgs/Odometry", "string frame\nfloat64 x\nfloat64[] covariance\ntest_msgs/Twist twist\n===\nMSG: test_msgs/Twist\nint32 y\n")
+	camera := builder.Connection("/camera", "sensor_msgs/Image", testImageDefinition)
+	compressed := builder.Connection("/compressed", "sensor_msgs/CompressedImage", "string format\nuint8[] data\n")
+
+	writeOdom := func(t0 time.Duration, x float64, y int32) {
+		raw := appendString(nil, "odom")
+		raw = append(raw, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(raw[len(raw)-8:], math.Float64bits(x))
+		raw = appendUint32(raw, 0)
+		raw = appendUint32(raw, uint32(y))
+		builder.Message(odom, time.Unix(0, int64(t0)), raw)
+	}
+
+	writeOdom(0, 1, 10)
+	writeOdom(500*time.Millisecond, 3, 20)
+	writeOdom(2500*time.Millisecond, math.NaN(), 30)
+
+	// the thumbnails are taken at 0.625s and 1.875s of the 2.5s bag
+	for i, t0 := range []time.Duration{0, 700 * time.Millisecond, 1500 * time.Millisecond, 1900 * time.Millisecond} {
+		builder.Message(camera, time.Unix(0, int64(t0+time.Millisecond)), encodeTestImage(uint32(i+1)*200))
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	img.SetGray(0, 0, color.Gray{Y: 0xff})
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := appendString(nil, "png")
+	raw = appendUint32(raw, uint32(encoded.Len()))
+	builder.Message(compressed, time.Unix(2, 0), append(raw, encoded.Bytes()...))
+	return builder.Bytes()
+}
+
+func TestGenerate(t *testing.T) {
+	preview, err := Generate(bytes.NewReader(writeTestBag(t)), WithThumbnails(2), WithTimelineBuckets(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !preview.Start.Equal(time.Unix(0, 0)) || !preview.End.Equal(time.Unix(0, int64(2500*time.Millisecond))) {
+		t.Fatalf("expected the preview to be [0s, 2.5s], but got [%v, %v]", preview.Start, preview.End)
+	}
+
+	if len(preview.Topics) != 3 || preview.Topics[0].Topic != "/camera" || preview.Topics[1].Topic != "/compressed" || preview.Topics[2].Topic != "/odom" {
+		t.Fatalf("expected the topics to be sorted, but got %+v", preview.Topics)
+	}
+
+	odom := preview.Topics[2]
+	if diff := cmp.Diff([]int{1, 1, 0, 0, 1}, odom.Timeline); diff != "" {
+		t.Fatalf("timeline is not matched:\n\n%s", diff)
+	}
+
+	// NaN values are skipped
+	expected := []*Field{
+		{Path: "x", Count: []int{2, 0, 0}, Min: []float64{1, 0, 0}, Max: []float64{3, 0, 0}, Mean: []float64{2, 0, 0}},
+		{Path: "twist.y", Count: []int{2, 0, 1}, Min: []float64{10, 0, 30}, Max: []float64{20, 0, 30}, Mean: []float64{15, 0, 30}},
+	}
+	if diff := cmp.Diff(expected, odom.Fields); diff != "" {
+		t.Fatalf("fields are not matched:\n\n%s", diff)
+	}
+
+	camera := preview.Topics[0]
+	if camera.Messages != 4 || len(camera.Fields) != 0 || len(camera.Thumbnails) != 2 {
+		t.Fatalf("expected 2 thumbnails of 4 messages, but got %d thumbnails of %d messages", len(camera.Thumbnails), camera.Messages)
+	}
+
+	for i, t0 := range []time.Duration{700 * time.Millisecond, 1900 * time.Millisecond} {
+		thumbnail := camera.Thumbnails[i]
+		if !thumbnail.Time.Equal(time.Unix(0, int64(t0+time.Millisecond))) {
+			t.Fatalf("expected thumbnail %d to be at %v, but got %v", i, t0, thumbnail.Time)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(thumbnail.JPEG))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the images are 400x1 and 800x1
+		if img.Bounds().Dx() != 160 || img.Bounds().Dy() != 1 || thumbnail.Width != 160 {
+			t.Fatalf("expected thumbnail %d to be 160x1, but got %v", i, img.Bounds())
+		}
+
+		r, g, _, _ := img.At(0, 0).RGBA()
+		if r < 0xc000 || g > 0x4000 {
+			t.Fatalf("expected thumbnail %d to be red, but got %v", i, img.At(0, 0))
+		}
+	}
+
+	compressed := preview.Topics[1]
+	if len(compressed.Thumbnails) != 1 || compressed.Thumbnails[0].Width != 4 || compressed.Thumbnails[0].Height != 2 {
+		t.Fatalf("expected a 4x2 thumbnail, but got %+v", compressed.Thumbnails)
+	}
+
+	// the preview can be stored as JSON
+	_, err = json.Marshal(preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Generate(bytes.NewReader(writeTestBag(t)), WithResolution(0))
+	if err != errInvalidResolution {
+		t.Fatalf("expected %v, but got %v", errInvalidResolution, err)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build purego || safe
+// +build purego safe
+
+package rosbag
+
+import (
+	"encoding/binary"
+)
+
+// Without unsafe, slices can't point to the raw buffer, so every slice is decoded with a copy.
+var fieldDecodeSliceFastHelper map[MessageFieldType]fieldDecodeFunc
+
+// detectHostEndian can't read the memory layout without unsafe, so the byte order is unknown.
+func detectHostEndian() binary.ByteOrder {
+	return nil
+}
+
+// viewString copies raw to a new string.
+func viewString(raw []byte) string {
+	return string(raw)
+}
+
+func fieldDecodeBytes(raw []byte, length int) (b []byte, off int, ok bool) {
+	length, off, ok = fieldDecodeLength(raw, length)
+	if !ok || length == 0 {
+		return
+	}
+
+	raw = raw[off:]
+	if len(raw) < length {
+		ok = false
+		return
+	}
+
+	b = raw[:length]
+	off += length
+	return
+}
+
+func fieldDecodeBoolSlice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []byte
+	b, off, ok = fieldDecodeBytes(raw, length)
+
+	var arr []bool
+	if len(b) > 0 {
+		arr = make([]bool, len(b))
+		for i := range arr {
+			arr[i] = b[i] != 0
+		}
+	}
+	v = arr
+	return
+}
+
+func fieldDecodeInt8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []byte
+	b, off, ok = fieldDecodeBytes(raw, length)
+
+	var arr []int8
+	if len(b) > 0 {
+		arr = make([]int8, len(b))
+		for i := range arr {
+			arr[i] = int8(b[i])
+		}
+	}
+	v = arr
+	return
+}
+
+func fieldDecodeUint8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []byte
+	b, off, ok = fieldDecodeBytes(raw, length)
+
+	var arr []uint8
+	if len(b) > 0 {
+		arr = make([]uint8, len(b))
+		copy(arr, b)
+	}
+	v = arr
+	return
+}
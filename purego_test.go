@@ -0,0 +1,47 @@
+//go:build purego || safe
+// +build purego safe
+
+package rosbag
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPureGoDecodeCopies(t *testing.T) {
+	def := &MessageDefinition{
+		Fields: []*MessageFieldDefinition{
+			{Type: MessageFieldTypeString, Name: "name", ArraySize: -1},
+			{Type: MessageFieldTypeUint8, Name: "data", IsArray: true, ArraySize: -1},
+			{Type: MessageFieldTypeInt32, Name: "values", IsArray: true, ArraySize: 2},
+		},
+	}
+
+	var raw []byte
+	raw = append(raw, uint32Bytes(2)...)
+	raw = append(raw, "hi"...)
+	raw = append(raw, uint32Bytes(3)...)
+	raw = append(raw, 1, 2, 3)
+	raw = append(raw, uint32Bytes(4)...)
+	raw = append(raw, uint32Bytes(5)...)
+
+	data := make(map[string]interface{})
+	_, err := decodeMessageData(def, raw, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range raw {
+		raw[i] = 0xff
+	}
+
+	expected := map[string]interface{}{
+		"name":   "hi",
+		"data":   []uint8{1, 2, 3},
+		"values": []int32{4, 5},
+	}
+	if diff := cmp.Diff(expected, data); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,473 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// errQueryLimit stops Decoder.Run when the limit of a query is reached
+	errQueryLimit = errors.New("query limit is reached")
+)
+
+// queryOp is a comparison operator of a WHERE condition.
+type queryOp string
+
+const (
+	queryOpEqual        queryOp = "="
+	queryOpNotEqual     queryOp = "!="
+	queryOpLess         queryOp = "<"
+	queryOpLessEqual    queryOp = "<="
+	queryOpGreater      queryOp = ">"
+	queryOpGreaterEqual queryOp = ">="
+)
+
+// queryCondition is a "<path> <op> <literal>" condition. The literal is a float64, a string, or a
+// bool.
+type queryCondition struct {
+	path    *FieldPath
+	op      queryOp
+	literal interface{}
+}
+
+// Query is a parsed query statement, see ParseQuery.
+type Query struct {
+	// Columns are the field paths that are selected, in the order of the statement
+	Columns []*FieldPath
+	// Topic is the topic pattern of the FROM clause, see Pattern
+	Topic string
+	// Start and End are the record time range of BETWEEN, inclusive. They're zero when the
+	// statement doesn't have BETWEEN
+	Start time.Time
+	End   time.Time
+	// Limit is the maximum number of rows, 0 means no limit
+	Limit int
+
+	conditions []queryCondition
+}
+
+// QueryRow is a message that matches a query. Values are the values of the columns, with the Go
+// types of Data Type Mapping.
+type QueryRow struct {
+	Topic  string
+	Time   time.Time
+	Values []interface{}
+}
+
+// ParseQuery parses a statement with the following syntax, where the keywords are case
+// insensitive, and the clauses after FROM are optional:
+//
+//	SELECT <path>[, <path>...] FROM '<topic>'
+//	  [WHERE <path> <op> <literal> [AND <path> <op> <literal>...]]
+//	  [BETWEEN <t1> AND <t2>]
+//	  [LIMIT <n>]
+//
+// Paths are FieldPath syntaxes, e.g. pose.position.x. The topic is a Pattern, so it can select
+// multiple topics. op is one of =, !=, <, <=, >, or >=, and the literal is a number, a quoted
+// string, true, or false. The times of BETWEEN are either Unix times in seconds, or quoted
+// RFC 3339 times, and they're compared with the record times.
+func ParseQuery(statement string) (*Query, error) {
+	tokens, err := tokenizeQuery(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := queryParser{tokens: tokens}
+	query, err := parser.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	return query, nil
+}
+
+// Run runs the query against the rest of the bag, and calls fn with every matching message in
+// the order of the bag. Run stops at the first error from either the decoder or fn, and returns
+// it.
+func (query *Query) Run(decoder *Decoder, fn func(row QueryRow) error) error {
+	var rows int
+	err := decoder.Subscribe(query.Topic, func(record *RecordMessageData) error {
+		t, err := record.Time()
+		if err != nil {
+			return err
+		}
+
+		if !query.Start.IsZero() && (t.Before(query.Start) || t.After(query.End)) {
+			return nil
+		}
+
+		def := &record.ConnectionHeader().MessageDefinition
+		data := record.Data()
+		for _, condition := range query.conditions {
+			ok, err := condition.match(def, data)
+			if err != nil || !ok {
+				return err
+			}
+		}
+
+		row := QueryRow{
+			Topic:  record.ConnectionHeader().Topic,
+			Time:   t,
+			Values: make([]interface{}, len(query.Columns)),
+		}
+		for i, column := range query.Columns {
+			row.Values[i], err = column.Value(def, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = fn(row)
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if query.Limit > 0 && rows >= query.Limit {
+			return errQueryLimit
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Run()
+	if err == errQueryLimit {
+		return nil
+	}
+	return err
+}
+
+func (condition *queryCondition) match(def *MessageDefinition, data []byte) (bool, error) {
+	v, err := condition.path.Value(def, data)
+	if err != nil {
+		return false, err
+	}
+
+	var cmp int
+	switch literal := condition.literal.(type) {
+	case float64:
+		f, ok := numericValue(v)
+		if !ok {
+			return false, fmt.Errorf("field %s is compared with a number, but it's a %T", condition.path, v)
+		}
+
+		if math.IsNaN(f) {
+			// NaN only matches !=, like in Go
+			return condition.op == queryOpNotEqual, nil
+		}
+
+		switch {
+		case f < literal:
+			cmp = -1
+		case f > literal:
+			cmp = 1
+		}
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false, fmt.Errorf("field %s is compared with a string, but it's a %T", condition.path, v)
+		}
+		cmp = strings.Compare(s, literal)
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("field %s is compared with a bool, but it's a %T", condition.path, v)
+		}
+
+		if condition.op != queryOpEqual && condition.op != queryOpNotEqual {
+			return false, fmt.Errorf("field %s is a bool, which can only be compared with = or !=", condition.path)
+		}
+
+		if b != literal {
+			cmp = 1
+		}
+	}
+
+	return condition.op.holds(cmp), nil
+}
+
+func (op queryOp) valid() bool {
+	switch op {
+	case queryOpEqual, queryOpNotEqual, queryOpLess, queryOpLessEqual, queryOpGreater, queryOpGreaterEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// holds reports whether the operator holds for the result of a comparison, which is negative,
+// zero, or positive when the value is less than, equal to, or greater than the literal.
+func (op queryOp) holds(cmp int) bool {
+	switch op {
+	case queryOpEqual:
+		return cmp == 0
+	case queryOpNotEqual:
+		return cmp != 0
+	case queryOpLess:
+		return cmp < 0
+	case queryOpLessEqual:
+		return cmp <= 0
+	case queryOpGreater:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type queryTokenKind uint8
+
+const (
+	queryTokenWord queryTokenKind = iota
+	queryTokenString
+	queryTokenSymbol
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	value string
+}
+
+// tokenizeQuery splits a statement into words, quoted strings, and symbols. Words include paths,
+// numbers, and unquoted topics, e.g. pose.position.x, ranges[0], -1.5, and /camera/*.
+func tokenizeQuery(statement string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(statement[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid query: unterminated string at %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenString, value: statement[i+1 : i+1+end]})
+			i += end + 2
+		case c == ',':
+			tokens = append(tokens, queryToken{kind: queryTokenSymbol, value: string(c)})
+			i++
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(statement) && statement[i+1] == '=' {
+				op += "="
+			}
+
+			if op == "!" {
+				return nil, fmt.Errorf("invalid query: unexpected ! at %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenSymbol, value: op})
+			i += len(op)
+		default:
+			start := i
+			for i < len(statement) && isQueryWordChar(rune(statement[i])) {
+				i++
+			}
+
+			if i == start {
+				return nil, fmt.Errorf("invalid query: unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenWord, value: statement[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+func isQueryWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.[]-+:/*?", c)
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (parser *queryParser) next() (queryToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return queryToken{}, false
+	}
+	token := parser.tokens[parser.pos]
+	parser.pos++
+	return token, true
+}
+
+// keyword consumes the next token when it's the keyword, which is case insensitive.
+func (parser *queryParser) keyword(keyword string) bool {
+	if parser.pos < len(parser.tokens) {
+		token := parser.tokens[parser.pos]
+		if token.kind == queryTokenWord && strings.EqualFold(token.value, keyword) {
+			parser.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (parser *queryParser) expectKeyword(keyword string) error {
+	if !parser.keyword(keyword) {
+		return fmt.Errorf("expected %s", keyword)
+	}
+	return nil
+}
+
+func (parser *queryParser) path() (*FieldPath, error) {
+	token, ok := parser.next()
+	if !ok || token.kind != queryTokenWord {
+		return nil, fmt.Errorf("expected a field path")
+	}
+
+	if token.value == "*" {
+		return nil, fmt.Errorf("SELECT * is not supported, the field paths must be selected")
+	}
+	return CompileFieldPath(token.value)
+}
+
+func (parser *queryParser) parse() (*Query, error) {
+	var query Query
+	err := parser.expectKeyword("SELECT")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		path, err := parser.path()
+		if err != nil {
+			return nil, err
+		}
+		query.Columns = append(query.Columns, path)
+
+		if parser.pos < len(parser.tokens) && parser.tokens[parser.pos].value == "," {
+			parser.pos++
+			continue
+		}
+		break
+	}
+
+	err = parser.expectKeyword("FROM")
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := parser.next()
+	if !ok || token.kind == queryTokenSymbol {
+		return nil, fmt.Errorf("expected a topic after FROM")
+	}
+
+	// validate the pattern before running the query
+	_, err = CompilePattern(token.value)
+	if err != nil {
+		return nil, err
+	}
+	query.Topic = token.value
+
+	if parser.keyword("WHERE") {
+		for {
+			condition, err := parser.condition()
+			if err != nil {
+				return nil, err
+			}
+			query.conditions = append(query.conditions, condition)
+
+			if !parser.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if parser.keyword("BETWEEN") {
+		query.Start, err = parser.time()
+		if err != nil {
+			return nil, err
+		}
+
+		err = parser.expectKeyword("AND")
+		if err != nil {
+			return nil, err
+		}
+
+		query.End, err = parser.time()
+		if err != nil {
+			return nil, err
+		}
+
+		if query.End.Before(query.Start) {
+			return nil, fmt.Errorf("the end of BETWEEN is before its start")
+		}
+	}
+
+	if parser.keyword("LIMIT") {
+		token, ok := parser.next()
+		limit, err := strconv.Atoi(token.value)
+		if !ok || token.kind != queryTokenWord || err != nil || limit <= 0 {
+			return nil, fmt.Errorf("expected a positive number after LIMIT")
+		}
+		query.Limit = limit
+	}
+
+	if token, ok := parser.next(); ok {
+		return nil, fmt.Errorf("unexpected %q", token.value)
+	}
+	return &query, nil
+}
+
+func (parser *queryParser) condition() (queryCondition, error) {
+	path, err := parser.path()
+	if err != nil {
+		return queryCondition{}, err
+	}
+
+	token, ok := parser.next()
+	if !ok || token.kind != queryTokenSymbol {
+		return queryCondition{}, fmt.Errorf("expected a comparison operator after %s", path)
+	}
+	condition := queryCondition{path: path, op: queryOp(token.value)}
+	if !condition.op.valid() {
+		return queryCondition{}, fmt.Errorf("unknown operator %s", condition.op)
+	}
+
+	token, ok = parser.next()
+	if !ok || token.kind == queryTokenSymbol {
+		return queryCondition{}, fmt.Errorf("expected a value after %s %s", path, condition.op)
+	}
+
+	switch {
+	case token.kind == queryTokenString:
+		condition.literal = token.value
+	case strings.EqualFold(token.value, "true"):
+		condition.literal = true
+	case strings.EqualFold(token.value, "false"):
+		condition.literal = false
+	default:
+		f, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return queryCondition{}, fmt.Errorf("expected a number, a string, or a bool, but got %s", token.value)
+		}
+		condition.literal = f
+	}
+	return condition, nil
+}
+
+// time parses a Unix time in seconds, or a quoted RFC 3339 time.
+func (parser *queryParser) time() (time.Time, error) {
+	token, ok := parser.next()
+	if !ok || token.kind == queryTokenSymbol {
+		return time.Time{}, fmt.Errorf("expected a time")
+	}
+
+	if token.kind == queryTokenString {
+		return time.Parse(time.RFC3339Nano, token.value)
+	}
+
+	seconds, err := strconv.ParseFloat(token.value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a Unix time in seconds, but got %s", token.value)
+	}
+
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))), nil
+}
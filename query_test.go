@@ -0,0 +1,105 @@
+package rosbag
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func runTestQuery(t *testing.T, raw []byte, statement string) ([]QueryRow, error) {
+	query, err := ParseQuery(statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []QueryRow
+	err = query.Run(NewDecoder(bytes.NewReader(raw)), func(row QueryRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+func TestQuery(t *testing.T) {
+	raw := writePlanTestBag(t)
+	testCases := []struct {
+		statement string
+		expected  []QueryRow
+	}{
+		{
+			statement: "SELECT header.frame_id, points[1].x FROM '/plan' WHERE points[0].x >= 0 and header.frame_id = 'map' BETWEEN 2 AND 3.5",
+			expected: []QueryRow{
+				{Topic: "/plan", Time: time.Unix(3, 0), Values: []interface{}{"map", float64(1)}},
+				{Topic: "/plan", Time: time.Unix(2, 0), Values: []interface{}{"map", float64(1)}},
+			},
+		},
+		{
+			statement: `select header.seq from /pl* where header.stamp < 11 limit 1`,
+			expected: []QueryRow{
+				{Topic: "/plan", Time: time.Unix(3, 0), Values: []interface{}{uint32(1)}},
+			},
+		},
+		{
+			statement: `SELECT scale[0] FROM '/plan' BETWEEN '1970-01-01T00:00:01Z' AND '1970-01-01T00:00:01Z'`,
+			expected: []QueryRow{
+				{Topic: "/plan", Time: time.Unix(1, 0), Values: []interface{}{float64(1)}},
+			},
+		},
+		{
+			statement: `SELECT scale[0] FROM '/plan' WHERE header.frame_id != "map"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		rows, err := runTestQuery(t, raw, testCase.statement)
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.statement, err)
+		}
+
+		if diff := cmp.Diff(testCase.expected, rows); diff != "" {
+			t.Fatalf("%s: rows are not matched:\n\n%s", testCase.statement, diff)
+		}
+	}
+
+	// the message with a single point doesn't have points[1]
+	_, err := runTestQuery(t, raw, "SELECT points[1].x FROM /plan")
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("expected an out of range error, but got %v", err)
+	}
+
+	_, err = runTestQuery(t, raw, "SELECT header FROM /plan")
+	if err == nil || !strings.Contains(err.Error(), "must be selected") {
+		t.Fatalf("expected an error for selecting a message, but got %v", err)
+	}
+
+	_, err = runTestQuery(t, raw, "SELECT header.seq FROM /plan WHERE header.frame_id > 1")
+	if err == nil || !strings.Contains(err.Error(), "compared with a number") {
+		t.Fatalf("expected a type mismatch, but got %v", err)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	statements := []string{
+		"",
+		"SELECT FROM /plan",
+		"SELECT * FROM /plan",
+		"SELECT x FROM",
+		"SELECT x FROM 're:('",
+		"SELECT x FROM /plan WHERE x == 1",
+		"SELECT x FROM /plan WHERE x > ",
+		"SELECT x FROM /plan WHERE x > abc",
+		"SELECT x FROM /plan BETWEEN 2 AND 1",
+		"SELECT x FROM /plan LIMIT 0",
+		"SELECT x FROM /plan ORDER BY x",
+		"SELECT x FROM '/plan",
+	}
+
+	for _, statement := range statements {
+		if _, err := ParseQuery(statement); err == nil {
+			t.Fatalf("expected %q to be invalid", statement)
+		}
+	}
+}
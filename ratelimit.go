@@ -0,0 +1,57 @@
+package rosbag
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimitedReader throttles the reads from the underlying reader with a token bucket. The
+// bucket refills at bytesPerSec and holds at most one second worth of tokens. A single Read
+// never returns more than bytesPerSec bytes.
+type rateLimitedReader struct {
+	reader      io.Reader
+	bytesPerSec int
+	tokens      float64
+	last        time.Time
+	now         func() time.Time
+	sleep       func(time.Duration)
+}
+
+func newRateLimitedReader(r io.Reader, bytesPerSec int) *rateLimitedReader {
+	return &rateLimitedReader{
+		reader:      r,
+		bytesPerSec: bytesPerSec,
+		tokens:      float64(bytesPerSec),
+		last:        time.Now(),
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+func (reader *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > reader.bytesPerSec {
+		p = p[:reader.bytesPerSec]
+	}
+
+	n, err := reader.reader.Read(p)
+
+	// Pay for the bytes after reading them so that a read that doesn't return anything,
+	// e.g. io.EOF, is never delayed
+	reader.refill()
+	reader.tokens -= float64(n)
+	if reader.tokens < 0 {
+		reader.sleep(time.Duration(-reader.tokens / float64(reader.bytesPerSec) * float64(time.Second)))
+		reader.refill()
+	}
+
+	return n, err
+}
+
+func (reader *rateLimitedReader) refill() {
+	now := reader.now()
+	reader.tokens += now.Sub(reader.last).Seconds() * float64(reader.bytesPerSec)
+	if max := float64(reader.bytesPerSec); reader.tokens > max {
+		reader.tokens = max
+	}
+	reader.last = now
+}
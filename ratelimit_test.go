@@ -0,0 +1,55 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReader(t *testing.T) {
+	const bytesPerSec = 1024
+
+	testCases := []struct {
+		Name     string
+		Size     int
+		Expected time.Duration
+	}{
+		{
+			Name:     "Within Burst",
+			Size:     bytesPerSec,
+			Expected: 0,
+		},
+		{
+			Name:     "Multiple Seconds",
+			Size:     10 * bytesPerSec,
+			Expected: 9 * time.Second,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			reader := newRateLimitedReader(bytes.NewReader(make([]byte, testCase.Size)), bytesPerSec)
+			reader.last = now
+			reader.now = func() time.Time { return now }
+			reader.sleep = func(d time.Duration) { now = now.Add(d) }
+
+			n, err := io.Copy(ioutil.Discard, reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != int64(testCase.Size) {
+				t.Fatalf("expected to read %d bytes, but got %d", testCase.Size, n)
+			}
+
+			elapsed := now.Sub(time.Unix(0, 0))
+			if diff := elapsed - testCase.Expected; diff > time.Millisecond || diff < -time.Millisecond {
+				t.Fatalf("expected reading to take %s, but took %s", testCase.Expected, elapsed)
+			}
+		})
+	}
+}
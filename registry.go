@@ -0,0 +1,49 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	typeRegistryMu sync.RWMutex
+	typeRegistry   = make(map[string]reflect.Type)
+
+	// ErrUnregisteredType is returned by RecordMessageData.Decode when the type of the message
+	// hasn't been registered with RegisterType.
+	ErrUnregisteredType = errors.New("message type is not registered")
+)
+
+// RegisterType registers the Go struct type of v for the ROS message type rosType, e.g.
+// RegisterType("std_msgs/Header", Header{}). v can be a struct or a pointer to a struct. When
+// a message is decoded into a map[string]interface{}, nested fields of registered types are
+// decoded into values of the registered struct type instead of nested maps, and arrays of them
+// into slices of the struct type. RecordMessageData.Decode decodes the messages of rosType into
+// new values of the struct type.
+//
+// RegisterType panics if v is not a struct or a pointer to a struct. Registering the same
+// rosType again replaces the previous type.
+func RegisterType(rosType string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("rosbag: RegisterType of %s: %T is not a struct", rosType, v))
+	}
+
+	typeRegistryMu.Lock()
+	typeRegistry[rosType] = t
+	typeRegistryMu.Unlock()
+}
+
+// registeredType returns the struct type that is registered for rosType.
+func registeredType(rosType string) (reflect.Type, bool) {
+	typeRegistryMu.RLock()
+	t, ok := typeRegistry[rosType]
+	typeRegistryMu.RUnlock()
+	return t, ok
+}
@@ -0,0 +1,35 @@
+package rosbag
+
+import "io"
+
+// ReindexStats describes the bag that has been written by Reindex.
+type ReindexStats struct {
+	Chunks   int
+	Messages int
+	// Truncated is where the source bag stops being readable, or nil when it has been read to the
+	// end. The records after it are dropped.
+	Truncated *TruncatedError
+}
+
+// Reindex copies the bag from r to w with a new index section, like `rosbag reindex`. It repairs
+// the bags whose index section is missing or corrupt, e.g. the .bag.active files of a recorder
+// that crashed. The index data records, the connection records of the index section, and the chunk
+// infos of the source bag are dropped, and regenerated from the chunks, which are copied byte for
+// byte. The bag ends at the first record that can't be read, e.g. a chunk that was being written
+// when the recording stopped, and the complete records before it are kept, see
+// ReindexStats.Truncated. Like Transcode, the bag header is rewritten at the end, which is why w
+// must be seekable.
+func Reindex(w io.WriteSeeker, r io.Reader, opts ...TranscodeOption) (*ReindexStats, error) {
+	transcoder := newBagTranscoder(w, opts)
+	transcoder.salvage = true
+	err := transcoder.run(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReindexStats{
+		Chunks:    len(transcoder.chunkInfos),
+		Messages:  transcoder.messages,
+		Truncated: transcoder.truncation,
+	}, nil
+}
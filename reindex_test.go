@@ -0,0 +1,130 @@
+package rosbag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stripTestIndex returns raw without its index data, connection, and chunk info records, like the
+// bag of a recording that was interrupted.
+func stripTestIndex(t *testing.T, raw []byte) []byte {
+	versionLen := len(fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor))
+	stripped := append([]byte(nil), raw[:versionLen]...)
+	r := bytes.NewReader(raw[versionLen:])
+	for r.Len() > 0 {
+		record, op, err := readRawRecord(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch op {
+		case OpIndexData, OpConnection, OpChunkInfo:
+		default:
+			stripped = append(stripped, record.Raw...)
+		}
+	}
+	return stripped
+}
+
+func reindexTestBag(t *testing.T, raw []byte) ([]byte, *ReindexStats) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stats, err := Reindex(out, bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = out.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reindexed, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := Check(bytes.NewReader(reindexed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the reindexed bag to be valid, but got %v", findings)
+	}
+
+	decoder := NewDecoder(bytes.NewReader(reindexed))
+	err = decoder.Preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoder.ChunkInfos()) != stats.Chunks {
+		t.Fatalf("expected %d chunk infos, but got %d", stats.Chunks, len(decoder.ChunkInfos()))
+	}
+	return reindexed, stats
+}
+
+func TestReindex(t *testing.T) {
+	indexed := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	raw := stripTestIndex(t, indexed)
+	reindexed, stats := reindexTestBag(t, raw)
+	if expected := (ReindexStats{Chunks: 3, Messages: 3}); *stats != expected {
+		t.Fatalf("expected %+v, but got %+v", expected, *stats)
+	}
+
+	compareRecords(t, readAllMessages(t, indexed), readAllMessages(t, reindexed))
+
+	// the chunks are copied byte for byte
+	for n := 1; n <= 3; n++ {
+		offset, length := findTestChunk(t, raw, n)
+		reindexedOffset, reindexedLength := findTestChunk(t, reindexed, n)
+		if !bytes.Equal(raw[offset:offset+length], reindexed[reindexedOffset:reindexedOffset+reindexedLength]) {
+			t.Fatalf("expected chunk %d to be copied", n)
+		}
+	}
+}
+
+func TestReindexTruncated(t *testing.T) {
+	indexed := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	raw := stripTestIndex(t, indexed)
+	// the recording stopped while the third chunk was being written
+	offset, length := findTestChunk(t, raw, 3)
+	raw = raw[:offset+length/2]
+
+	reindexed, stats := reindexTestBag(t, raw)
+	expected := ReindexStats{
+		Chunks:    2,
+		Messages:  2,
+		Truncated: &TruncatedError{Offset: int64(offset), Time: time.Unix(2, 0)},
+	}
+	if stats.Chunks != expected.Chunks || stats.Messages != expected.Messages || stats.Truncated == nil || *stats.Truncated != *expected.Truncated {
+		t.Fatalf("expected %+v, but got %+v", expected, stats)
+	}
+
+	compareRecords(t, readAllMessages(t, indexed)[:2], readAllMessages(t, reindexed))
+}
+
+func TestReindexWithoutBagHeader(t *testing.T) {
+	versionLine := fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	_, err = Reindex(out, bytes.NewReader([]byte(versionLine)))
+	if err != errMissingBagHeader {
+		t.Fatalf("expected %v, but got %v", errMissingBagHeader, err)
+	}
+}
@@ -0,0 +1,119 @@
+package rosbag
+
+import (
+	"sort"
+)
+
+// ConnRemapOption configures an optional behavior of a ConnRemapper.
+type ConnRemapOption func(*ConnRemapper)
+
+// WithMergeIdenticalConnections makes the remapper map connections from different inputs to the
+// same output connection when they have the same topic, type, and md5sum.
+func WithMergeIdenticalConnections() ConnRemapOption {
+	return func(remapper *ConnRemapper) {
+		remapper.mergeIdentical = true
+	}
+}
+
+// ConnMapping is an entry of a ConnRemapper report.
+type ConnMapping struct {
+	// Input is the index of the input that the connection comes from
+	Input int
+	// From is the connection ID in the input
+	From uint32
+	// To is the connection ID in the output
+	To    uint32
+	Topic string
+	Type  string
+}
+
+type inputConn struct {
+	input int
+	conn  uint32
+}
+
+type connIdentity struct {
+	topic  string
+	typ    string
+	md5sum string
+}
+
+// ConnRemapper assigns output connection IDs to the connections of one or more input bags, so
+// that tools that merge, append, or filter bags don't attach messages to the wrong connection
+// when the inputs use the same IDs. Output IDs are assigned sequentially from 0 in the order the
+// connections are mapped, which is the same order Writer.WriteConnection assigns them. So, when
+// every new mapping is written with WriteConnection, the IDs of the remapper and the writer match.
+type ConnRemapper struct {
+	mergeIdentical bool
+	mapping        map[inputConn]uint32
+	identities     map[connIdentity]uint32
+	report         []ConnMapping
+	next           uint32
+}
+
+// NewConnRemapper creates an empty remapper.
+func NewConnRemapper(opts ...ConnRemapOption) *ConnRemapper {
+	remapper := ConnRemapper{
+		mapping:    make(map[inputConn]uint32),
+		identities: make(map[connIdentity]uint32),
+	}
+
+	for _, opt := range opts {
+		opt(&remapper)
+	}
+
+	return &remapper
+}
+
+// Map returns the output ID of conn from input. hdr is the connection header of conn. isNew is
+// true when the output connection has just been assigned, and needs to be written to the output.
+func (remapper *ConnRemapper) Map(input int, conn uint32, hdr *ConnectionHeader) (out uint32, isNew bool) {
+	key := inputConn{input: input, conn: conn}
+	if out, ok := remapper.mapping[key]; ok {
+		return out, false
+	}
+
+	identity := connIdentity{topic: hdr.Topic, typ: hdr.Type, md5sum: hdr.MD5Sum}
+	out, ok := remapper.identities[identity]
+	if !ok {
+		out = remapper.next
+		remapper.next++
+		isNew = true
+		if remapper.mergeIdentical {
+			remapper.identities[identity] = out
+		}
+	}
+
+	remapper.mapping[key] = out
+	remapper.report = append(remapper.report, ConnMapping{
+		Input: input,
+		From:  conn,
+		To:    out,
+		Topic: hdr.Topic,
+		Type:  hdr.Type,
+	})
+	return out, isNew
+}
+
+// Lookup returns the output ID of conn from input that has been mapped before.
+func (remapper *ConnRemapper) Lookup(input int, conn uint32) (uint32, bool) {
+	out, ok := remapper.mapping[inputConn{input: input, conn: conn}]
+	return out, ok
+}
+
+// Report returns all of the mappings sorted by the output ID, then by the input and the input ID.
+// The report is stable, the same inputs mapped in the same order always give the same report.
+func (remapper *ConnRemapper) Report() []ConnMapping {
+	report := append([]ConnMapping(nil), remapper.report...)
+	sort.SliceStable(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		if a.Input != b.Input {
+			return a.Input < b.Input
+		}
+		return a.From < b.From
+	})
+	return report
+}
@@ -0,0 +1,74 @@
+package rosbag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnRemapper(t *testing.T) {
+	a := &ConnectionHeader{Topic: "/a", Type: "test_msgs/Test", MD5Sum: "1"}
+	b := &ConnectionHeader{Topic: "/b", Type: "test_msgs/Test", MD5Sum: "1"}
+
+	// both inputs use conn 0, and /a is in both of them
+	maps := []struct {
+		Input int
+		Conn  uint32
+		Hdr   *ConnectionHeader
+	}{
+		{0, 0, a},
+		{1, 0, b},
+		{1, 1, a},
+		{0, 0, a},
+	}
+
+	testCases := []struct {
+		Name     string
+		Opts     []ConnRemapOption
+		Expected []uint32
+		Report   []ConnMapping
+	}{
+		{
+			Name:     "Separate",
+			Expected: []uint32{0, 1, 2, 0},
+			Report: []ConnMapping{
+				{Input: 0, From: 0, To: 0, Topic: "/a", Type: "test_msgs/Test"},
+				{Input: 1, From: 0, To: 1, Topic: "/b", Type: "test_msgs/Test"},
+				{Input: 1, From: 1, To: 2, Topic: "/a", Type: "test_msgs/Test"},
+			},
+		},
+		{
+			Name:     "Merge Identical",
+			Opts:     []ConnRemapOption{WithMergeIdenticalConnections()},
+			Expected: []uint32{0, 1, 0, 0},
+			Report: []ConnMapping{
+				{Input: 0, From: 0, To: 0, Topic: "/a", Type: "test_msgs/Test"},
+				{Input: 1, From: 1, To: 0, Topic: "/a", Type: "test_msgs/Test"},
+				{Input: 1, From: 0, To: 1, Topic: "/b", Type: "test_msgs/Test"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			remapper := NewConnRemapper(testCase.Opts...)
+			var actual []uint32
+			for _, m := range maps {
+				out, _ := remapper.Map(m.Input, m.Conn, m.Hdr)
+				actual = append(actual, out)
+
+				if lookup, ok := remapper.Lookup(m.Input, m.Conn); !ok || lookup != out {
+					t.Fatalf("expected Lookup to return %d, but got %d", out, lookup)
+				}
+			}
+
+			if !reflect.DeepEqual(testCase.Expected, actual) {
+				t.Fatalf("expected output connections to be %v, but got %v", testCase.Expected, actual)
+			}
+
+			if !reflect.DeepEqual(testCase.Report, remapper.Report()) {
+				t.Fatalf("expected report to be %v, but got %v", testCase.Report, remapper.Report())
+			}
+		})
+	}
+}
@@ -1,11 +1,12 @@
 // rosbag implements Rosbag Format Version 2.0, http://wiki.ros.org/Bags/Format/2.0.
-// Currently, this package only implements the decoder.
+// Bags are read with Decoder, and written with Writer.
 package rosbag
 
 import (
 	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
 	"time"
 )
 
@@ -44,6 +45,9 @@ const (
 	CompressionNone Compression = "none"
 	CompressionBZ2  Compression = "bz2"
 	CompressionLZ4  Compression = "lz4"
+	// CompressionSnappy is an extension from the standard. The chunk data is compressed with
+	// the snappy framing format, https://github.com/google/snappy/blob/master/framing_format.txt.
+	CompressionSnappy Compression = "snappy"
 )
 
 type Version struct {
@@ -55,6 +59,16 @@ func (version *Version) String() string {
 	return fmt.Sprintf("%d.%d", version.Major, version.Minor)
 }
 
+// UnsupportedVersionError is returned by Decoder.Read when the format version of the bag is not
+// supported.
+type UnsupportedVersionError struct {
+	Version Version
+}
+
+func (err *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%s is not supported. %s is the current supported version", &err.Version, &supportedVersion)
+}
+
 type Rosbag struct {
 	Version Version
 	Record  []Record
@@ -217,7 +231,7 @@ type RecordChunk struct {
 }
 
 // Compression parses Header to get the compression algorithm that's used for the underlying chunk data.
-// The supported compression values are "none", "lz4", and "bz2".
+// The supported compression values are "none", "lz4", "bz2", and "snappy".
 func (record *RecordChunk) Compression() (Compression, error) {
 	value, err := record.findField([]byte("compression"))
 	if err != nil {
@@ -253,9 +267,15 @@ func (record *RecordConnection) Topic() (string, error) {
 
 // ConnectionHeader reads the underlying data and decode it to ConnectionHeader
 func (record *RecordConnection) ConnectionHeader() (*ConnectionHeader, error) {
-	var err error
+	return record.connectionHeader(nil)
+}
+
+// connectionHeader is like ConnectionHeader, but the message definition is loaded from cache when
+// it has been parsed before. cache can be nil.
+func (record *RecordConnection) connectionHeader(cache *DefinitionCache) (*ConnectionHeader, error) {
 	var connectionHeader ConnectionHeader
-	err = iterateHeaderFields(record.Data(), func(key, value []byte) bool {
+	var rawDefinition []byte
+	err := iterateHeaderFields(record.Data(), func(key, value []byte) bool {
 		if bytes.Equal(key, []byte("topic")) {
 			connectionHeader.Topic = string(value)
 		} else if bytes.Equal(key, []byte("type")) {
@@ -263,17 +283,27 @@ func (record *RecordConnection) ConnectionHeader() (*ConnectionHeader, error) {
 		} else if bytes.Equal(key, []byte("md5sum")) {
 			connectionHeader.MD5Sum = string(value)
 		} else if bytes.Equal(key, []byte("message_definition")) {
-			err = connectionHeader.MessageDefinition.unmarshall(value)
+			connectionHeader.RawMessageDefinition = string(value)
+			rawDefinition = value
 		}
 		return true
 	})
+
+	// a message definition that can't be parsed doesn't fail the connection, its messages just
+	// can't be viewed
+	if rawDefinition != nil {
+		_ = cache.unmarshall(&connectionHeader.MessageDefinition, connectionHeader.MD5Sum, rawDefinition)
+	}
 	return &connectionHeader, err
 }
 
 // RecordMessageData contains the serialized message data in the ROS serialization format.
 type RecordMessageData struct {
 	*RecordBase
-	connHdr *ConnectionHeader
+	connHdr  *ConnectionHeader
+	interner *Interner
+	// plans are the cached plans of the connection, see Decode
+	plans *planCache
 }
 
 // Conn parses Header to get the unique connection ID within a bag
@@ -293,6 +323,16 @@ func (record *RecordMessageData) ConnectionHeader() *ConnectionHeader {
 	return record.connHdr
 }
 
+// Unmarshaler is implemented by the types that decode the serialized data of their messages
+// themselves, e.g. a hand-written decoder of sensor_msgs/PointCloud2 that reads the points in
+// place. ViewAs, ViewAsArena, and Decode call UnmarshalROS instead of decoding the message with
+// reflection when v implements Unmarshaler. def is the message definition of the connection, and
+// data is the message data, which is only valid until the record is closed for ViewAs, and until
+// the arena is released for ViewAsArena.
+type Unmarshaler interface {
+	UnmarshalROS(def *MessageDefinition, data []byte) error
+}
+
 // ViewAs views the underlying raw data in the given v format. When possible, View
 // will convert raw data without making a copy. With no copy, decoding large arrays become really
 // fast! But, this also means that any data types that are reference based can't be used after this
@@ -300,9 +340,64 @@ func (record *RecordMessageData) ConnectionHeader() *ConnectionHeader {
 //
 // So, if the data is absolutely needed after reading this record, you MUST NOT CLOSE this record
 // so that the underlying raw data is not overwritten by other records.
+//
+// When a message field doesn't match the kind of its struct field, ViewAs returns a *FieldError.
+// Struct fields can be skipped, copied, or limited with options in the rosbag struct tag, e.g.
+// `rosbag:"data,omit"`, `rosbag:"data,copy"`, `rosbag:"points,maxlen=10000"`, or `rosbag:"-"`.
+// The strings of the fields that are configured by WithInterner are interned instead. Messages of
+// an old message definition are migrated by the Migration that is registered for their md5sum.
+// When v implements Unmarshaler, the message is decoded by v instead.
 func (record *RecordMessageData) ViewAs(v interface{}) error {
-	_, err := decodeMessageData(&record.connHdr.MessageDefinition, record.Data(), v)
+	return record.view(record.Data(), v, viewConfig{interner: record.interner})
+}
+
+// ViewAsArena views the underlying raw data in the given v format like ViewAs, but from a copy of
+// the raw data in arena. The strings and the arrays of v share the memory of the copy, and the
+// nested maps come from arena, so v can be used after this Record is closed until arena is
+// released.
+func (record *RecordMessageData) ViewAsArena(v interface{}, arena *Arena) error {
+	return record.view(arena.copy(record.Data()), v, viewConfig{interner: record.interner, arena: arena})
+}
+
+// Decode views the message as a new value of the struct type that is registered for the type of
+// its connection with RegisterType, and returns a pointer to the value, e.g. a *StdString for
+// std_msgs/String, so the messages of a bag can be handled with a type switch instead of calling
+// ViewAs with a struct for every topic. The value shares the memory of the record like ViewAs.
+// Decode returns an error that wraps ErrUnregisteredType when the type isn't registered.
+func (record *RecordMessageData) Decode() (interface{}, error) {
+	t, ok := registeredType(record.connHdr.Type)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", record.connHdr.Type, ErrUnregisteredType)
+	}
+
+	v := reflect.New(t).Interface()
+	err := record.ViewAs(v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (record *RecordMessageData) view(data []byte, v interface{}, config viewConfig) error {
+	def := &record.connHdr.MessageDefinition
+	if unmarshaler, ok := v.(Unmarshaler); ok {
+		return unmarshaler.UnmarshalROS(def, data)
+	}
+
+	if migration, ok := registeredMigration(record.connHdr.MD5Sum); ok {
+		var err error
+		def, config.widened, err = migration.apply(def)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := decodeMessageDataConfig(def, data, v, config)
 	if err != nil {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErr.Topic = record.connHdr.Topic
+			fieldErr.MessageType = record.connHdr.Type
+		}
 		return err
 	}
 
@@ -357,3 +452,17 @@ func (record *RecordChunkInfo) EndTime() (time.Time, error) {
 func (record *RecordChunkInfo) Count() (uint32, error) {
 	return record.findFieldUint32([]byte("count"))
 }
+
+// MessageCounts parses Data to get the number of messages of each connection in the chunk
+func (record *RecordChunkInfo) MessageCounts() (map[uint32]uint32, error) {
+	data := record.Data()
+	if len(data)%(2*lenInBytes) != 0 {
+		return nil, fmt.Errorf("chunk info data has an invalid length of %d", len(data))
+	}
+
+	counts := make(map[uint32]uint32, len(data)/(2*lenInBytes))
+	for ; len(data) > 0; data = data[2*lenInBytes:] {
+		counts[endian.Uint32(data)] += endian.Uint32(data[lenInBytes:])
+	}
+	return counts, nil
+}
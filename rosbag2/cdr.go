@@ -0,0 +1,203 @@
+package rosbag2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+var (
+	errShortMessage = errors.New("message data is shorter than its definition")
+	// cdrHeader is the encapsulation header of little endian plain CDR
+	cdrHeader = []byte{0x00, 0x01, 0x00, 0x00}
+)
+
+var primitiveSizes = map[rosbag.MessageFieldType]int{
+	rosbag.MessageFieldTypeBool:    1,
+	rosbag.MessageFieldTypeInt8:    1,
+	rosbag.MessageFieldTypeUint8:   1,
+	rosbag.MessageFieldTypeInt16:   2,
+	rosbag.MessageFieldTypeUint16:  2,
+	rosbag.MessageFieldTypeInt32:   4,
+	rosbag.MessageFieldTypeUint32:  4,
+	rosbag.MessageFieldTypeInt64:   8,
+	rosbag.MessageFieldTypeUint64:  8,
+	rosbag.MessageFieldTypeFloat32: 4,
+	rosbag.MessageFieldTypeFloat64: 8,
+}
+
+// transcoder re-encodes ROS1 serialized messages to CDR. ROS1 messages are packed little endian
+// values, while CDR aligns every primitive to its size from the end of the encapsulation header,
+// and terminates strings with a NUL.
+type transcoder struct {
+	src []byte
+	dst []byte
+}
+
+// ToCDR re-encodes data, a ROS1 serialized message of type msgType with the definition def, to
+// CDR with an encapsulation header. The result follows the definition returned by Definition.
+func ToCDR(msgType string, def *rosbag.MessageDefinition, data []byte) ([]byte, error) {
+	t := transcoder{
+		src: data,
+		dst: make([]byte, 0, len(data)+len(data)/4+len(cdrHeader)),
+	}
+	t.dst = append(t.dst, cdrHeader...)
+
+	err := t.message(msgType, def)
+	if err != nil {
+		return nil, err
+	}
+	return t.dst, nil
+}
+
+func (t *transcoder) next(n int) ([]byte, error) {
+	if n < 0 || len(t.src) < n {
+		return nil, errShortMessage
+	}
+
+	b := t.src[:n]
+	t.src = t.src[n:]
+	return b, nil
+}
+
+func (t *transcoder) nextUint32() (uint32, error) {
+	b, err := t.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (t *transcoder) align(n int) {
+	for (len(t.dst)-len(cdrHeader))%n != 0 {
+		t.dst = append(t.dst, 0)
+	}
+}
+
+func (t *transcoder) putUint32(v uint32) {
+	t.align(4)
+	t.dst = append(t.dst, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(t.dst[len(t.dst)-4:], v)
+}
+
+func (t *transcoder) message(msgType string, def *rosbag.MessageDefinition) error {
+	for _, field := range def.Fields {
+		// the field is still read to move past it
+		skip := skipField(msgType, field)
+
+		if !field.IsArray {
+			err := t.value(field, skip)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		n := field.ArraySize
+		if n < 0 {
+			count, err := t.nextUint32()
+			if err != nil {
+				return err
+			}
+			n = int(count)
+			t.putUint32(count)
+		}
+
+		// byte arrays don't need alignment, so they're copied at once
+		if primitiveSizes[field.Type] == 1 {
+			b, err := t.next(n)
+			if err != nil {
+				return err
+			}
+			t.dst = append(t.dst, b...)
+			continue
+		}
+
+		for i := 0; i < n; i++ {
+			err := t.value(field, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *transcoder) value(field *rosbag.MessageFieldDefinition, skip bool) error {
+	if size, ok := primitiveSizes[field.Type]; ok {
+		b, err := t.next(size)
+		if err != nil {
+			return err
+		}
+
+		if !skip {
+			t.align(size)
+			t.dst = append(t.dst, b...)
+		}
+		return nil
+	}
+
+	switch field.Type {
+	case rosbag.MessageFieldTypeString:
+		n, err := t.nextUint32()
+		if err != nil {
+			return err
+		}
+
+		b, err := t.next(int(n))
+		if err != nil {
+			return err
+		}
+
+		// the length includes the NUL terminator
+		t.putUint32(n + 1)
+		t.dst = append(t.dst, b...)
+		t.dst = append(t.dst, 0)
+	case rosbag.MessageFieldTypeTime:
+		sec, err := t.nextUint32()
+		if err != nil {
+			return err
+		}
+
+		nsec, err := t.nextUint32()
+		if err != nil {
+			return err
+		}
+
+		t.putUint32(sec)
+		t.putUint32(nsec)
+	case rosbag.MessageFieldTypeDuration:
+		sec, err := t.nextUint32()
+		if err != nil {
+			return err
+		}
+
+		nsec, err := t.nextUint32()
+		if err != nil {
+			return err
+		}
+
+		// ROS1 durations can have a negative nsec, but builtin_interfaces/Duration has an unsigned
+		// nanosec, so the duration is normalized
+		d := time.Duration(int32(sec))*time.Second + time.Duration(int32(nsec))
+		normalizedSec := d / time.Second
+		normalizedNsec := d % time.Second
+		if normalizedNsec < 0 {
+			normalizedSec--
+			normalizedNsec += time.Second
+		}
+
+		t.putUint32(uint32(int32(normalizedSec)))
+		t.putUint32(uint32(normalizedNsec))
+	case rosbag.MessageFieldTypeComplex:
+		return t.message(field.MsgType.Type, field.MsgType)
+	default:
+		return fmt.Errorf("field %s of type %s can't be converted to CDR", field.Name, field.Type)
+	}
+
+	return nil
+}
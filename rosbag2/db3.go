@@ -0,0 +1,129 @@
+package rosbag2
+
+import (
+	"database/sql"
+	"os"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+
+	// the driver of the sqlite3 storage
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// db3Schema is the version 2 schema of the rosbag2 sqlite3 storage, which has the QoS profiles of
+// the topics. It doesn't have the schema table of the later versions, so the readers since Foxy
+// detect it from the columns of the topics table.
+const db3Schema = `
+CREATE TABLE topics (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	serialization_format TEXT NOT NULL,
+	offered_qos_profiles TEXT NOT NULL
+);
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY,
+	topic_id INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	data BLOB NOT NULL
+);
+CREATE INDEX timestamp_idx ON messages (timestamp ASC);
+`
+
+// ConvertDB3 reads the ROS1 bag from decoder, and writes it as a new rosbag2 sqlite3 storage file
+// at path, the default storage before MCAP. path must not exist, and it's removed when the
+// conversion fails. The messages are converted like Convert, and the file can be played by the
+// rosbag2 sqlite3 storage plugin without metadata.yaml. The storage uses cgo through
+// github.com/mattn/go-sqlite3.
+func ConvertDB3(path string, decoder *rosbag.Decoder) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	storage, err := newDB3Storage(path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	_, err = convert(storage, decoder)
+	if err != nil {
+		storage.abort()
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// db3Storage writes the topics and the messages to a sqlite3 storage file in a single
+// transaction, which is committed by Close.
+type db3Storage struct {
+	db       *sql.DB
+	tx       *sql.Tx
+	messages *sql.Stmt
+}
+
+func newDB3Storage(path string) (*db3Storage, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(db3Schema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	messages, err := tx.Prepare("INSERT INTO messages (topic_id, timestamp, data) VALUES (?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	return &db3Storage{db: db, tx: tx, messages: messages}, nil
+}
+
+func (storage *db3Storage) writeTopic(id uint16, hdr *rosbag.ConnectionHeader, metadata TopicMetadata) error {
+	// the topic IDs start from 1 like the rows that are inserted by rosbag2
+	_, err := storage.tx.Exec(
+		"INSERT INTO topics (id, name, type, serialization_format, offered_qos_profiles) VALUES (?, ?, ?, ?, ?)",
+		int64(id)+1, metadata.Name, metadata.Type, metadata.SerializationFormat, metadata.OfferedQoSProfiles,
+	)
+	return err
+}
+
+func (storage *db3Storage) writeMessage(id uint16, sequence uint32, t time.Time, data []byte) error {
+	_, err := storage.messages.Exec(int64(id)+1, t.UnixNano(), data)
+	return err
+}
+
+// Close commits the messages, and closes the database.
+func (storage *db3Storage) Close() error {
+	err := storage.tx.Commit()
+	if err != nil {
+		storage.db.Close()
+		return err
+	}
+	return storage.db.Close()
+}
+
+// abort rolls back the messages, and closes the database.
+func (storage *db3Storage) abort() {
+	storage.tx.Rollback()
+	storage.db.Close()
+}
@@ -0,0 +1,111 @@
+package rosbag2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const (
+	headerType   = "std_msgs/Header"
+	timeType     = "builtin_interfaces/msg/Time"
+	durationType = "builtin_interfaces/msg/Duration"
+)
+
+// TypeName translates a ROS1 type name to the ROS2 type name, e.g. std_msgs/String becomes
+// std_msgs/msg/String. Names that already have the msg namespace are returned as is.
+func TypeName(ros1Type string) string {
+	idx := strings.IndexByte(ros1Type, '/')
+	if idx == -1 || strings.Contains(ros1Type[idx+1:], "/") {
+		return ros1Type
+	}
+	return ros1Type[:idx] + "/msg/" + ros1Type[idx+1:]
+}
+
+// skipField returns true when the field of a message of type msgType doesn't exist in ROS2.
+// ROS2 dropped the seq field of std_msgs/Header.
+func skipField(msgType string, field *rosbag.MessageFieldDefinition) bool {
+	return msgType == headerType && field.Name == "seq"
+}
+
+// Definition translates the message definition of a ROS1 message of type msgType to the ROS2
+// message definition that's stored in the schemas of a rosbag2 storage file. The dependencies are
+// appended after the root message, each in a section that starts with "MSG: <type>", like in ROS1.
+// time and duration are translated to builtin_interfaces/msg/Time and Duration.
+func Definition(msgType string, def *rosbag.MessageDefinition) string {
+	var b strings.Builder
+	var deps []*rosbag.MessageDefinition
+	seen := make(map[string]bool)
+	var usesTime, usesDuration bool
+
+	writeFields := func(msgType string, def *rosbag.MessageDefinition) {
+		for _, constant := range def.Constants {
+			// ROS2 string constants are quoted
+			value := constant.Raw
+			if constant.Type == rosbag.MessageFieldTypeString {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&b, "%s %s=%s\n", constant.Type, constant.Name, value)
+		}
+
+		for _, field := range def.Fields {
+			if skipField(msgType, field) {
+				continue
+			}
+
+			var typeName string
+			switch field.Type {
+			case rosbag.MessageFieldTypeComplex:
+				typeName = TypeName(field.MsgType.Type)
+				if !seen[field.MsgType.Type] {
+					seen[field.MsgType.Type] = true
+					deps = append(deps, field.MsgType)
+				}
+			case rosbag.MessageFieldTypeTime:
+				typeName = timeType
+				usesTime = true
+			case rosbag.MessageFieldTypeDuration:
+				typeName = durationType
+				usesDuration = true
+			default:
+				typeName = field.Type.String()
+			}
+
+			switch {
+			case !field.IsArray:
+			case field.ArraySize < 0:
+				typeName += "[]"
+			default:
+				typeName += fmt.Sprintf("[%d]", field.ArraySize)
+			}
+
+			fmt.Fprintf(&b, "%s %s\n", typeName, field.Name)
+		}
+	}
+
+	writeSection := func(typeName string) {
+		b.WriteString(strings.Repeat("=", 80) + "\n")
+		fmt.Fprintf(&b, "MSG: %s\n", typeName)
+	}
+
+	writeFields(msgType, def)
+	// deps grows while the dependencies are written
+	for i := 0; i < len(deps); i++ {
+		writeSection(TypeName(deps[i].Type))
+		writeFields(deps[i].Type, deps[i])
+	}
+
+	if usesTime {
+		writeSection(timeType)
+		b.WriteString("int32 sec\nuint32 nanosec\n")
+	}
+
+	if usesDuration {
+		writeSection(durationType)
+		b.WriteString("int32 sec\nuint32 nanosec\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package rosbag2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// mcapMagic starts and ends every MCAP file, https://mcap.dev/spec
+var mcapMagic = []byte{0x89, 'M', 'C', 'A', 'P', '0', '\r', '\n'}
+
+const (
+	mcapOpHeader  = 0x01
+	mcapOpFooter  = 0x02
+	mcapOpSchema  = 0x03
+	mcapOpChannel = 0x04
+	mcapOpMessage = 0x05
+	mcapOpDataEnd = 0x0f
+)
+
+// mcapWriter writes an unchunked MCAP file without a summary section. Readers, including the
+// rosbag2 MCAP storage plugin, fall back to scanning the data section when there's no summary.
+type mcapWriter struct {
+	w      *bufio.Writer
+	record []byte
+	err    error
+}
+
+func newMCAPWriter(w io.Writer, profile string) *mcapWriter {
+	writer := mcapWriter{w: bufio.NewWriter(w)}
+	writer.write(mcapMagic)
+
+	writer.start(mcapOpHeader)
+	writer.putString(profile)
+	writer.putString("go-rosbag")
+	writer.end()
+	return &writer
+}
+
+func (writer *mcapWriter) writeSchema(id uint16, name, encoding string, data []byte) {
+	writer.start(mcapOpSchema)
+	writer.putUint16(id)
+	writer.putString(name)
+	writer.putString(encoding)
+	writer.putBytes(data)
+	writer.end()
+}
+
+func (writer *mcapWriter) writeChannel(id, schemaID uint16, topic, encoding string, metadata map[string]string) {
+	writer.start(mcapOpChannel)
+	writer.putUint16(id)
+	writer.putUint16(schemaID)
+	writer.putString(topic)
+	writer.putString(encoding)
+
+	var entries []byte
+	for key, value := range metadata {
+		entries = appendString(entries, key)
+		entries = appendString(entries, value)
+	}
+	writer.putBytes(entries)
+	writer.end()
+}
+
+func (writer *mcapWriter) writeMessage(channelID uint16, sequence uint32, logTime, publishTime uint64, data []byte) {
+	writer.start(mcapOpMessage)
+	writer.putUint16(channelID)
+	writer.putUint32(sequence)
+	writer.putUint64(logTime)
+	writer.putUint64(publishTime)
+	writer.record = append(writer.record, data...)
+	writer.end()
+}
+
+// Close writes the end of the file, and flushes it. It doesn't close the underlying writer.
+func (writer *mcapWriter) Close() error {
+	// the CRCs are optional, 0 means that they're not computed
+	writer.start(mcapOpDataEnd)
+	writer.putUint32(0)
+	writer.end()
+
+	writer.start(mcapOpFooter)
+	writer.putUint64(0)
+	writer.putUint64(0)
+	writer.putUint32(0)
+	writer.end()
+
+	writer.write(mcapMagic)
+	if writer.err != nil {
+		return writer.err
+	}
+	return writer.w.Flush()
+}
+
+func (writer *mcapWriter) write(b []byte) {
+	if writer.err != nil {
+		return
+	}
+	_, writer.err = writer.w.Write(b)
+}
+
+// start starts a record with op. The record is written by end once its length is known.
+func (writer *mcapWriter) start(op byte) {
+	writer.record = append(writer.record[:0], op, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func (writer *mcapWriter) end() {
+	binary.LittleEndian.PutUint64(writer.record[1:], uint64(len(writer.record)-9))
+	writer.write(writer.record)
+}
+
+func (writer *mcapWriter) putUint16(v uint16) {
+	writer.record = append(writer.record, 0, 0)
+	binary.LittleEndian.PutUint16(writer.record[len(writer.record)-2:], v)
+}
+
+func (writer *mcapWriter) putUint32(v uint32) {
+	writer.record = appendUint32(writer.record, v)
+}
+
+func (writer *mcapWriter) putUint64(v uint64) {
+	writer.record = append(writer.record, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(writer.record[len(writer.record)-8:], v)
+}
+
+func (writer *mcapWriter) putString(s string) {
+	writer.record = appendString(writer.record, s)
+}
+
+func (writer *mcapWriter) putBytes(b []byte) {
+	writer.record = appendUint32(writer.record, uint32(len(b)))
+	writer.record = append(writer.record, b...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	b = append(b, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], v)
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,277 @@
+package rosbag2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MetadataFile is the name of the metadata file in a rosbag2 directory
+	MetadataFile = "metadata.yaml"
+	// metadataVersion is the version of the metadata format that's written
+	metadataVersion = 5
+)
+
+// TopicMetadata describes a topic of a rosbag2.
+type TopicMetadata struct {
+	Name string
+	// Type is the ROS2 type name, e.g. std_msgs/msg/String
+	Type                string
+	SerializationFormat string
+	// OfferedQoSProfiles is the YAML encoded list of the QoS profiles of the publishers
+	OfferedQoSProfiles string
+}
+
+// TopicInfo is a topic with its number of messages.
+type TopicInfo struct {
+	Metadata     TopicMetadata
+	MessageCount uint64
+}
+
+// FileInfo describes a storage file of a rosbag2.
+type FileInfo struct {
+	// Path is relative to the rosbag2 directory
+	Path         string
+	StartingTime time.Time
+	Duration     time.Duration
+	MessageCount uint64
+}
+
+// Metadata is the content of the metadata.yaml file of a rosbag2 directory.
+type Metadata struct {
+	Version           int
+	StorageIdentifier string
+	Duration          time.Duration
+	StartingTime      time.Time
+	MessageCount      uint64
+	Topics            []TopicInfo
+	CompressionFormat string
+	CompressionMode   string
+	// RelativeFilePaths are the paths of the storage files relative to the rosbag2 directory
+	RelativeFilePaths []string
+	Files             []FileInfo
+}
+
+var (
+	errNotMetadata = errors.New("rosbag2_bagfile_information is missing from the metadata")
+)
+
+type yamlDuration struct {
+	Nanoseconds int64 `yaml:"nanoseconds"`
+}
+
+type yamlTime struct {
+	NanosecondsSinceEpoch int64 `yaml:"nanoseconds_since_epoch"`
+}
+
+func (t yamlTime) time() time.Time {
+	if t.NanosecondsSinceEpoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t.NanosecondsSinceEpoch)
+}
+
+type yamlMetadata struct {
+	Info *struct {
+		Version           int          `yaml:"version"`
+		StorageIdentifier string       `yaml:"storage_identifier"`
+		Duration          yamlDuration `yaml:"duration"`
+		StartingTime      yamlTime     `yaml:"starting_time"`
+		MessageCount      uint64       `yaml:"message_count"`
+		Topics            []struct {
+			Metadata struct {
+				Name                string `yaml:"name"`
+				Type                string `yaml:"type"`
+				SerializationFormat string `yaml:"serialization_format"`
+				// it's a string before Jazzy, and a list of profiles since Jazzy
+				OfferedQoSProfiles yaml.Node `yaml:"offered_qos_profiles"`
+			} `yaml:"topic_metadata"`
+			MessageCount uint64 `yaml:"message_count"`
+		} `yaml:"topics_with_message_count"`
+		CompressionFormat string   `yaml:"compression_format"`
+		CompressionMode   string   `yaml:"compression_mode"`
+		RelativeFilePaths []string `yaml:"relative_file_paths"`
+		Files             []struct {
+			Path         string       `yaml:"path"`
+			StartingTime yamlTime     `yaml:"starting_time"`
+			Duration     yamlDuration `yaml:"duration"`
+			MessageCount uint64       `yaml:"message_count"`
+		} `yaml:"files"`
+	} `yaml:"rosbag2_bagfile_information"`
+}
+
+// ReadMetadata parses a metadata.yaml file from r. Files is only set by the metadata version 5
+// and later, older versions only list RelativeFilePaths. The storage files are not read, so the
+// metadata can be used to discover rosbag2 directories without decoding their messages.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	var raw yamlMetadata
+	err := yaml.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	info := raw.Info
+	if info == nil {
+		return nil, errNotMetadata
+	}
+
+	metadata := Metadata{
+		Version:           info.Version,
+		StorageIdentifier: info.StorageIdentifier,
+		Duration:          time.Duration(info.Duration.Nanoseconds),
+		StartingTime:      info.StartingTime.time(),
+		MessageCount:      info.MessageCount,
+		CompressionFormat: info.CompressionFormat,
+		CompressionMode:   info.CompressionMode,
+		RelativeFilePaths: info.RelativeFilePaths,
+	}
+
+	for _, topic := range info.Topics {
+		node := &topic.Metadata.OfferedQoSProfiles
+		qos := node.Value
+		if node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode {
+			b, err := yaml.Marshal(node)
+			if err != nil {
+				return nil, err
+			}
+			qos = string(b)
+		}
+
+		metadata.Topics = append(metadata.Topics, TopicInfo{
+			Metadata: TopicMetadata{
+				Name:                topic.Metadata.Name,
+				Type:                topic.Metadata.Type,
+				SerializationFormat: topic.Metadata.SerializationFormat,
+				OfferedQoSProfiles:  qos,
+			},
+			MessageCount: topic.MessageCount,
+		})
+	}
+
+	for _, file := range info.Files {
+		metadata.Files = append(metadata.Files, FileInfo{
+			Path:         file.Path,
+			StartingTime: file.StartingTime.time(),
+			Duration:     time.Duration(file.Duration.Nanoseconds),
+			MessageCount: file.MessageCount,
+		})
+	}
+
+	return &metadata, nil
+}
+
+// ReadMetadataDir parses the metadata.yaml file of the rosbag2 directory dir.
+func ReadMetadataDir(dir string) (*Metadata, error) {
+	f, err := os.Open(filepath.Join(dir, MetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadMetadata(f)
+}
+
+// EndingTime returns the time of the last message.
+func (metadata *Metadata) EndingTime() time.Time {
+	return metadata.StartingTime.Add(metadata.Duration)
+}
+
+// Topic returns the topic with the given name, or nil when the topic is not in the rosbag2.
+func (metadata *Metadata) Topic(name string) *TopicInfo {
+	for i := range metadata.Topics {
+		if metadata.Topics[i].Metadata.Name == name {
+			return &metadata.Topics[i]
+		}
+	}
+	return nil
+}
+
+// WriteYAML writes metadata in the format of metadata.yaml.
+func (metadata *Metadata) WriteYAML(w io.Writer) error {
+	p := yamlPrinter{w: w}
+	p.printf("rosbag2_bagfile_information:\n")
+	p.printf("  version: %d\n", metadata.Version)
+	p.printf("  storage_identifier: %s\n", yamlString(metadata.StorageIdentifier))
+	p.printf("  duration:\n")
+	p.printf("    nanoseconds: %d\n", metadata.Duration.Nanoseconds())
+	p.printf("  starting_time:\n")
+	p.printf("    nanoseconds_since_epoch: %d\n", unixNano(metadata.StartingTime))
+	p.printf("  message_count: %d\n", metadata.MessageCount)
+
+	p.printf("  topics_with_message_count:\n")
+	for _, topic := range metadata.Topics {
+		p.printf("    - topic_metadata:\n")
+		p.printf("        name: %s\n", yamlString(topic.Metadata.Name))
+		p.printf("        type: %s\n", yamlString(topic.Metadata.Type))
+		p.printf("        serialization_format: %s\n", yamlString(topic.Metadata.SerializationFormat))
+		p.printf("        offered_qos_profiles: %s\n", yamlString(topic.Metadata.OfferedQoSProfiles))
+		p.printf("      message_count: %d\n", topic.MessageCount)
+	}
+
+	p.printf("  compression_format: %s\n", yamlString(metadata.CompressionFormat))
+	p.printf("  compression_mode: %s\n", yamlString(metadata.CompressionMode))
+
+	p.printf("  relative_file_paths:\n")
+	for _, path := range metadata.RelativeFilePaths {
+		p.printf("    - %s\n", yamlString(path))
+	}
+
+	p.printf("  files:\n")
+	for _, file := range metadata.Files {
+		p.printf("    - path: %s\n", yamlString(file.Path))
+		p.printf("      starting_time:\n")
+		p.printf("        nanoseconds_since_epoch: %d\n", unixNano(file.StartingTime))
+		p.printf("      duration:\n")
+		p.printf("        nanoseconds: %d\n", file.Duration.Nanoseconds())
+		p.printf("      message_count: %d\n", file.MessageCount)
+	}
+
+	return p.err
+}
+
+// unixNano returns 0 for the zero time instead of an overflowed value.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// yamlString quotes s when it would be read back as something else than the same string.
+func yamlString(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	for _, c := range s {
+		switch c {
+		case ':', '#', '\'', '"', '\n', '[', ']', '{', '}', ',', '&', '*', '!', '|', '>', '%', '@', '`':
+			return strconv.Quote(s)
+		}
+	}
+
+	if s[0] == ' ' || s[0] == '-' || s[0] == '?' || s[len(s)-1] == ' ' {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// yamlPrinter keeps the first write error so that the caller only checks it once.
+type yamlPrinter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *yamlPrinter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
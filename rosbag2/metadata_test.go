@@ -0,0 +1,133 @@
+package rosbag2
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+)
+
+func TestReadMetadataDir(t *testing.T) {
+	raw := writeTestBag(t)
+	dir := filepath.Join(t.TempDir(), "converted")
+	err := Convert(dir, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := ReadMetadataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Metadata{
+		Version:           5,
+		StorageIdentifier: "mcap",
+		Duration:          time.Second,
+		StartingTime:      time.Unix(1, 0),
+		MessageCount:      2,
+		Topics: []TopicInfo{
+			{
+				Metadata: TopicMetadata{
+					Name:                "/test",
+					Type:                "test_msgs/msg/Test",
+					SerializationFormat: "cdr",
+				},
+				MessageCount: 2,
+			},
+		},
+		RelativeFilePaths: []string{"converted_0.mcap"},
+		Files: []FileInfo{
+			{
+				Path:         "converted_0.mcap",
+				StartingTime: time.Unix(1, 0),
+				Duration:     time.Second,
+				MessageCount: 2,
+			},
+		},
+	}
+
+	if diff := cmp.Diff(&expected, metadata); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if !metadata.EndingTime().Equal(time.Unix(2, 0)) {
+		t.Fatalf("expected the ending time to be 2s, but got %v", metadata.EndingTime())
+	}
+
+	if topic := metadata.Topic("/test"); topic == nil || topic.MessageCount != 2 {
+		t.Fatalf("expected /test to have 2 messages, but got %v", topic)
+	}
+
+	if topic := metadata.Topic("/missing"); topic != nil {
+		t.Fatalf("expected no topic, but got %v", topic)
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	// version 4 doesn't have files, and Jazzy writes the QoS profiles as a list
+	raw := `rosbag2_bagfile_information:
+  version: 4
+  storage_identifier: sqlite3
+  relative_file_paths:
+    - bag_0.db3
+    - bag_1.db3
+  duration:
+    nanoseconds: 5000000000
+  starting_time:
+    nanoseconds_since_epoch: 1600000000000000000
+  message_count: 10
+  topics_with_message_count:
+    - topic_metadata:
+        name: /chatter
+        type: std_msgs/msg/String
+        serialization_format: cdr
+        offered_qos_profiles:
+          - history: 3
+            depth: 0
+      message_count: 10
+  compression_format: zstd
+  compression_mode: FILE
+`
+	metadata, err := ReadMetadata(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Metadata{
+		Version:           4,
+		StorageIdentifier: "sqlite3",
+		Duration:          5 * time.Second,
+		StartingTime:      time.Unix(1600000000, 0),
+		MessageCount:      10,
+		Topics: []TopicInfo{
+			{
+				Metadata: TopicMetadata{
+					Name:                "/chatter",
+					Type:                "std_msgs/msg/String",
+					SerializationFormat: "cdr",
+					OfferedQoSProfiles:  "- history: 3\n  depth: 0\n",
+				},
+				MessageCount: 10,
+			},
+		},
+		CompressionFormat: "zstd",
+		CompressionMode:   "FILE",
+		RelativeFilePaths: []string{"bag_0.db3", "bag_1.db3"},
+	}
+
+	if diff := cmp.Diff(&expected, metadata); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReadMetadataInvalid(t *testing.T) {
+	_, err := ReadMetadata(strings.NewReader("version: 5\n"))
+	if err != errNotMetadata {
+		t.Fatalf("expected %v, but got %v", errNotMetadata, err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package rosbag2 converts ROS1 bags to rosbag2 directories, so that the data can be used by
+// ROS2 tools. A rosbag2 directory contains a metadata.yaml file, and the storage files with the
+// messages. The storage files are written in the MCAP format with the ros2 profile, and the
+// messages are re-encoded to CDR. A bag can also be converted to a single MCAP file, or to a
+// single sqlite3 storage file (.db3) of the older rosbag2 releases.
+package rosbag2
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const (
+	storageIdentifier   = "mcap"
+	serializationFormat = "cdr"
+)
+
+type channelKey struct {
+	topic string
+	typ   string
+}
+
+type channel struct {
+	id       uint16
+	info     TopicInfo
+	sequence uint32
+}
+
+// Convert reads the ROS1 bag from decoder, and writes it as a new rosbag2 directory at dir. dir
+// must not exist. The type names are translated with TypeName, the definitions with Definition,
+// and the messages with ToCDR. Connections with the same topic and type are merged into a single
+// topic, like ROS2 records them.
+func Convert(dir string, decoder *rosbag.Decoder) error {
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	storagePath := filepath.Base(dir) + "_0." + storageIdentifier
+	f, err := os.Create(filepath.Join(dir, storagePath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	metadata, err := convert(newMCAPStorage(f), decoder)
+	if err != nil {
+		return err
+	}
+	metadata.StorageIdentifier = storageIdentifier
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	metadata.RelativeFilePaths = []string{storagePath}
+	metadata.Files = []FileInfo{
+		{
+			Path:         storagePath,
+			StartingTime: metadata.StartingTime,
+			Duration:     metadata.Duration,
+			MessageCount: metadata.MessageCount,
+		},
+	}
+
+	metadataFile, err := os.Create(filepath.Join(dir, MetadataFile))
+	if err != nil {
+		return err
+	}
+	defer metadataFile.Close()
+
+	err = metadata.WriteYAML(metadataFile)
+	if err != nil {
+		return err
+	}
+	return metadataFile.Close()
+}
+
+// ConvertMCAP reads the ROS1 bag from decoder, and writes it to w as a single MCAP file with the
+// ros2 profile. The file is the same as the storage file that's written by Convert, so it can be
+// played by the rosbag2 MCAP storage plugin without metadata.yaml.
+func ConvertMCAP(w io.Writer, decoder *rosbag.Decoder) error {
+	_, err := convert(newMCAPStorage(w), decoder)
+	return err
+}
+
+// storage writes the topics and the messages of a storage file.
+type storage interface {
+	// writeTopic writes the topic id, which is the first one of its connections
+	writeTopic(id uint16, hdr *rosbag.ConnectionHeader, metadata TopicMetadata) error
+	writeMessage(id uint16, sequence uint32, t time.Time, data []byte) error
+	Close() error
+}
+
+// mcapStorage writes the topics as MCAP channels, and their types as schemas.
+type mcapStorage struct {
+	writer  *mcapWriter
+	schemas map[string]uint16
+}
+
+func newMCAPStorage(w io.Writer) *mcapStorage {
+	return &mcapStorage{
+		writer:  newMCAPWriter(w, "ros2"),
+		schemas: make(map[string]uint16),
+	}
+}
+
+func (storage *mcapStorage) writeTopic(id uint16, hdr *rosbag.ConnectionHeader, metadata TopicMetadata) error {
+	schemaID, ok := storage.schemas[hdr.Type]
+	if !ok {
+		// schema IDs start from 1, 0 means no schema
+		schemaID = uint16(len(storage.schemas) + 1)
+		storage.schemas[hdr.Type] = schemaID
+		definition := Definition(hdr.Type, &hdr.MessageDefinition)
+		storage.writer.writeSchema(schemaID, metadata.Type, "ros2msg", []byte(definition))
+	}
+
+	storage.writer.writeChannel(id, schemaID, metadata.Name, metadata.SerializationFormat, map[string]string{
+		"offered_qos_profiles": metadata.OfferedQoSProfiles,
+	})
+	return nil
+}
+
+func (storage *mcapStorage) writeMessage(id uint16, sequence uint32, t time.Time, data []byte) error {
+	storage.writer.writeMessage(id, sequence, uint64(t.UnixNano()), uint64(t.UnixNano()), data)
+	return nil
+}
+
+func (storage *mcapStorage) Close() error {
+	return storage.writer.Close()
+}
+
+// convert writes the messages from decoder to storage, closes it, and returns the metadata of
+// the messages. The storage identifier of the metadata is not set.
+func convert(storage storage, decoder *rosbag.Decoder) (*Metadata, error) {
+	channels := make(map[channelKey]*channel)
+	var start, end time.Time
+	var count uint64
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		hdr := msg.ConnectionHeader()
+		key := channelKey{topic: hdr.Topic, typ: hdr.Type}
+		c, ok := channels[key]
+		if !ok {
+			c = &channel{
+				id: uint16(len(channels)),
+				info: TopicInfo{
+					Metadata: TopicMetadata{
+						Name:                hdr.Topic,
+						Type:                TypeName(hdr.Type),
+						SerializationFormat: serializationFormat,
+					},
+				},
+			}
+			channels[key] = c
+			err = storage.writeTopic(c.id, hdr, c.info.Metadata)
+			if err != nil {
+				msg.Close()
+				return nil, err
+			}
+		}
+
+		t, err := msg.Time()
+		if err != nil {
+			msg.Close()
+			return nil, err
+		}
+
+		data, err := ToCDR(hdr.Type, &hdr.MessageDefinition, msg.Data())
+		msg.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.writeMessage(c.id, c.sequence, t, data)
+		if err != nil {
+			return nil, err
+		}
+		c.sequence++
+		c.info.MessageCount++
+		count++
+
+		if start.IsZero() || t.Before(start) {
+			start = t
+		}
+		if t.After(end) {
+			end = t
+		}
+	}
+
+	err := storage.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := Metadata{
+		Version:      metadataVersion,
+		StartingTime: start,
+		Duration:     end.Sub(start),
+		MessageCount: count,
+	}
+
+	for _, c := range channels {
+		metadata.Topics = append(metadata.Topics, c.info)
+	}
+	sort.Slice(metadata.Topics, func(i, j int) bool {
+		a, b := metadata.Topics[i].Metadata, metadata.Topics[j].Metadata
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	return &metadata, nil
+}
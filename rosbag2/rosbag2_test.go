@@ -0,0 +1,309 @@
+package rosbag2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+	"github.com/lherman-cs/go-rosbag/rosbagtest"
+)
+
+const testDefinition = `Header header
+string name
+float64[] values
+duration elapsed
+uint8 MODE=1
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+// encodeTestMessage serializes a test message in the ROS1 format.
+func encodeTestMessage() []byte {
+	var b []byte
+	// header
+	b = appendUint32(b, 7)
+	b = appendUint32(b, 10)
+	b = appendUint32(b, 20)
+	b = appendString(b, "map")
+	// name
+	b = appendString(b, "ab")
+	// values
+	b = appendUint32(b, 1)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], math.Float64bits(1.5))
+	// elapsed is -0.5s, stored as 0s and -500000000ns
+	b = appendUint32(b, 0)
+	nsec := int32(-500000000)
+	return appendUint32(b, uint32(nsec))
+}
+
+func expectedCDR() []byte {
+	b := []byte{0x00, 0x01, 0x00, 0x00}
+	// header.stamp, seq is dropped
+	b = appendUint32(b, 10)
+	b = appendUint32(b, 20)
+	// header.frame_id
+	b = appendUint32(b, 4)
+	b = append(b, "map\x00"...)
+	// name
+	b = appendUint32(b, 3)
+	b = append(b, "ab\x00"...)
+	// values, the count is aligned to 4, and the float64 is aligned to 8
+	b = append(b, 0)
+	b = appendUint32(b, 1)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], math.Float64bits(1.5))
+	// elapsed
+	sec := int32(-1)
+	b = appendUint32(b, uint32(sec))
+	return appendUint32(b, 500000000)
+}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := rosbagtest.NewBuilder(t)
+	conn := builder.Connection("/test", "test_msgs/Test", testDefinition)
+	for i := 0; i < 2; i++ {
+		builder.Message(conn, time.Unix(int64(i+1), 0), encodeTestMessage())
+	}
+	return builder.Bytes()
+}
+
+func TestTypeName(t *testing.T) {
+	names := map[string]string{
+		"std_msgs/String":     "std_msgs/msg/String",
+		"std_msgs/msg/String": "std_msgs/msg/String",
+		"String":              "String",
+	}
+
+	for ros1, expected := range names {
+		if actual := TypeName(ros1); actual != expected {
+			t.Errorf("expected %s to be %s, but got %s", ros1, expected, actual)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	raw := writeTestBag(t)
+	dir := filepath.Join(t.TempDir(), "converted")
+	err := Convert(dir, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := ioutil.ReadFile(filepath.Join(dir, MetadataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMetadata := `rosbag2_bagfile_information:
+  version: 5
+  storage_identifier: mcap
+  duration:
+    nanoseconds: 1000000000
+  starting_time:
+    nanoseconds_since_epoch: 1000000000
+  message_count: 2
+  topics_with_message_count:
+    - topic_metadata:
+        name: /test
+        type: test_msgs/msg/Test
+        serialization_format: cdr
+        offered_qos_profiles: ""
+      message_count: 2
+  compression_format: ""
+  compression_mode: ""
+  relative_file_paths:
+    - converted_0.mcap
+  files:
+    - path: converted_0.mcap
+      starting_time:
+        nanoseconds_since_epoch: 1000000000
+      duration:
+        nanoseconds: 1000000000
+      message_count: 2
+`
+	if diff := cmp.Diff(expectedMetadata, string(metadata)); diff != "" {
+		t.Fatal(diff)
+	}
+
+	storage, err := ioutil.ReadFile(filepath.Join(dir, "converted_0.mcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(storage, mcapMagic) || !bytes.HasSuffix(storage, mcapMagic) {
+		t.Fatal("expected the storage file to start and end with the MCAP magic")
+	}
+
+	if n := bytes.Count(storage, expectedCDR()); n != 2 {
+		t.Fatalf("expected 2 CDR messages in the storage file, but got %d", n)
+	}
+
+	expectedDefinition := `uint8 MODE=1
+std_msgs/msg/Header header
+string name
+float64[] values
+builtin_interfaces/msg/Duration elapsed
+` + strings.Repeat("=", 80) + `
+MSG: std_msgs/msg/Header
+builtin_interfaces/msg/Time stamp
+string frame_id
+` + strings.Repeat("=", 80) + `
+MSG: builtin_interfaces/msg/Time
+int32 sec
+uint32 nanosec
+` + strings.Repeat("=", 80) + `
+MSG: builtin_interfaces/msg/Duration
+int32 sec
+uint32 nanosec
+`
+	if !bytes.Contains(storage, []byte(expectedDefinition)) {
+		t.Fatal("expected the ROS2 message definition in the storage file")
+	}
+}
+
+func TestConvertMCAP(t *testing.T) {
+	raw := writeTestBag(t)
+	dir := filepath.Join(t.TempDir(), "converted")
+	err := Convert(dir, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := ioutil.ReadFile(filepath.Join(dir, "converted_0.mcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = ConvertMCAP(&buf, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(storage, buf.Bytes()) {
+		t.Fatal("expected the MCAP file to be the same as the storage file of the rosbag2 directory")
+	}
+}
+
+func TestConvertDB3(t *testing.T) {
+	raw := writeTestBag(t)
+	path := filepath.Join(t.TempDir(), "converted.db3")
+	err := ConvertDB3(path, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var topic [5]string
+	err = db.QueryRow("SELECT id, name, type, serialization_format, offered_qos_profiles FROM topics").
+		Scan(&topic[0], &topic[1], &topic[2], &topic[3], &topic[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([5]string{"1", "/test", "test_msgs/msg/Test", "cdr", ""}, topic); diff != "" {
+		t.Fatalf("topic is not matched:\n\n%s", diff)
+	}
+
+	rows, err := db.Query("SELECT topic_id, timestamp, data FROM messages ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var timestamps []int64
+	for rows.Next() {
+		var topicID, timestamp int64
+		var data []byte
+		err = rows.Scan(&topicID, &timestamp, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if topicID != 1 || !bytes.Equal(data, expectedCDR()) {
+			t.Fatalf("expected a CDR message on topic 1, but got %x on topic %d", data, topicID)
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]int64{1000000000, 2000000000}, timestamps); diff != "" {
+		t.Fatalf("timestamps are not matched:\n\n%s", diff)
+	}
+
+	// the existing file is kept
+	err = ConvertDB3(path, rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err == nil {
+		t.Fatal("expected an error when the file exists")
+	}
+
+	if _, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed conversion doesn't leave a file behind
+	truncatedPath := filepath.Join(t.TempDir(), "truncated.db3")
+	err = ConvertDB3(truncatedPath, rosbag.NewDecoder(bytes.NewReader(raw[:len(raw)/2])))
+	if err == nil {
+		t.Fatal("expected a truncated bag to fail")
+	}
+
+	if _, err = os.Stat(truncatedPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the storage file to be removed, but got %v", err)
+	}
+}
+
+func TestConvertExistingDir(t *testing.T) {
+	raw := writeTestBag(t)
+	err := Convert(t.TempDir(), rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err == nil {
+		t.Fatal("expected an error when the directory exists")
+	}
+}
+
+func TestToCDRShortMessage(t *testing.T) {
+	raw := writeTestBag(t)
+	decoder := rosbag.NewDecoder(bytes.NewReader(raw))
+	for {
+		record, err := decoder.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		hdr := msg.ConnectionHeader()
+		_, err = ToCDR(hdr.Type, &hdr.MessageDefinition, msg.Data()[:10])
+		if err != errShortMessage {
+			t.Fatalf("expected %v, but got %v", errShortMessage, err)
+		}
+		msg.Close()
+		return
+	}
+}
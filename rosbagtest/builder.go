@@ -0,0 +1,59 @@
+package rosbagtest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// Builder writes a bag in memory with rosbag.Writer, so the tests that need a small bag don't
+// repeat the error handling of the writer. Every error fails the test.
+type Builder struct {
+	t      testing.TB
+	buf    bytes.Buffer
+	writer *rosbag.Writer
+}
+
+// NewBuilder creates a builder of a bag that's written with opts, see rosbag.WriterOption.
+func NewBuilder(t testing.TB, opts ...rosbag.WriterOption) *Builder {
+	b := &Builder{t: t}
+	b.writer = rosbag.NewWriter(&b.buf, opts...)
+	return b
+}
+
+// Connection registers a connection on topic with msgType and the message definition, and returns
+// its ID that's used by Message.
+func (b *Builder) Connection(topic, msgType, definition string) uint32 {
+	b.t.Helper()
+	conn, err := b.writer.WriteConnection(&rosbag.ConnectionHeader{
+		Topic:                topic,
+		Type:                 msgType,
+		RawMessageDefinition: definition,
+	})
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return conn
+}
+
+// Message writes a message with the serialized message data on conn at t.
+func (b *Builder) Message(conn uint32, t time.Time, data []byte) {
+	b.t.Helper()
+	err := b.writer.WriteMessage(conn, t, data)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+// Bytes closes the writer, and returns the bag. The bag is written to a buffer, so its bag header
+// doesn't point to its index section. The builder must not be used after Bytes.
+func (b *Builder) Bytes() []byte {
+	b.t.Helper()
+	err := b.writer.Close()
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return b.buf.Bytes()
+}
@@ -0,0 +1,282 @@
+// Package rosbagtest renders bags into canonical snapshots, and compares them with golden files,
+// so that the output of bag-processing code can be covered by regression tests.
+//
+// A snapshot has a line for every message with its topic, record time, type, and fields as JSON.
+// The keys are sorted, and the floats are rounded to a number of significant digits, so the same
+// messages always render the same snapshot.
+//
+// Builder writes the small bags of the tests in memory.
+package rosbagtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// UpdateEnv is the environment variable that makes AssertGolden rewrite the golden files with the
+// current snapshots instead of comparing them, e.g. ROSBAG_UPDATE_GOLDEN=1 go test ./...
+const UpdateEnv = "ROSBAG_UPDATE_GOLDEN"
+
+const defaultFloatPrecision = 9
+
+// Option configures an optional behavior of Snapshot.
+type Option func(*config)
+
+type config struct {
+	topics    []*rosbag.Pattern
+	precision int
+	err       error
+}
+
+// WithTopics limits the snapshot to the topics that match one of patterns, see rosbag.Pattern.
+// By default, every topic is in the snapshot.
+func WithTopics(patterns ...string) Option {
+	return func(c *config) {
+		for _, pattern := range patterns {
+			p, err := rosbag.CompilePattern(pattern)
+			if err != nil && c.err == nil {
+				c.err = err
+			}
+			c.topics = append(c.topics, p)
+		}
+	}
+}
+
+// WithFloatPrecision sets the number of significant digits of the floats. Fewer digits hide the
+// rounding differences between platforms and compilers. The default is 9.
+func WithFloatPrecision(digits int) Option {
+	return func(c *config) {
+		c.precision = digits
+	}
+}
+
+func (c *config) match(topic string) bool {
+	if len(c.topics) == 0 {
+		return true
+	}
+
+	for _, p := range c.topics {
+		if p != nil && p.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+type snapshotLine struct {
+	Topic   string      `json:"topic"`
+	Time    string      `json:"time"`
+	Type    string      `json:"type"`
+	Message interface{} `json:"message"`
+}
+
+// Snapshot runs decoder to the end, and renders its messages into a snapshot.
+func Snapshot(decoder *rosbag.Decoder, opts ...Option) ([]byte, error) {
+	c := config{precision: defaultFloatPrecision}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	var buf bytes.Buffer
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg, ok := record.(*rosbag.RecordMessageData); ok && c.match(msg.ConnectionHeader().Topic) {
+			err = c.writeLine(&buf, msg)
+		}
+		record.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *config) writeLine(buf *bytes.Buffer, msg *rosbag.RecordMessageData) error {
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	err = msg.ViewAs(fields)
+	if err != nil {
+		return err
+	}
+
+	hdr := msg.ConnectionHeader()
+	line, err := json.Marshal(snapshotLine{
+		Topic:   hdr.Topic,
+		Time:    formatTime(t),
+		Type:    hdr.Type,
+		Message: c.normalize(reflect.ValueOf(fields)),
+	})
+	if err != nil {
+		return err
+	}
+
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// normalize converts v to the values that are marshaled canonically. Times are independent of the
+// local time zone, and floats are json.Number with the configured precision.
+func (c *config) normalize(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Type() {
+	case timeType:
+		return formatTime(v.Interface().(time.Time))
+	case durationType:
+		return v.Interface().(time.Duration).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Float32:
+		return c.formatFloat(v.Float(), 32)
+	case reflect.Float64:
+		return c.formatFloat(v.Float(), 64)
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = c.normalize(iter.Value())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		// bytes are marshaled as base64
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = c.normalize(v.Index(i))
+		}
+		return values
+	default:
+		return v.Interface()
+	}
+}
+
+func (c *config) formatFloat(f float64, bitSize int) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case f == 0:
+		// -0 is the same as 0
+		return json.Number("0")
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', c.precision, bitSize))
+}
+
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// AssertGolden compares the snapshot of decoder with the golden file at path, and fails t when
+// they're different. When UpdateEnv is set, the golden file is written with the snapshot instead,
+// and its directory is created when it doesn't exist.
+func AssertGolden(t testing.TB, path string, decoder *rosbag.Decoder, opts ...Option) {
+	t.Helper()
+
+	actual, err := Snapshot(decoder, opts...)
+	if err != nil {
+		t.Fatalf("failed to render the snapshot: %v", err)
+	}
+
+	if os.Getenv(UpdateEnv) != "" {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, actual, 0644)
+		}
+
+		if err != nil {
+			t.Fatalf("failed to update the golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the golden file, set %s=1 to create it: %v", UpdateEnv, err)
+	}
+
+	if diff := Diff(expected, actual); diff != "" {
+		t.Errorf("snapshot doesn't match %s, set %s=1 to update it:\n%s", path, UpdateEnv, diff)
+	}
+}
+
+// Diff describes the first line where the snapshots expected and actual are different, and the
+// numbers of their lines. It returns an empty string when they're the same.
+func Diff(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+
+	expectedLines, actualLines := splitLines(expected), splitLines(actual)
+	var b strings.Builder
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+
+		if e != a {
+			fmt.Fprintf(&b, "line %d:\n- %s\n+ %s\n", i+1, e, a)
+			break
+		}
+	}
+
+	fmt.Fprintf(&b, "expected %d lines, but got %d", len(expectedLines), len(actualLines))
+	return b.String()
+}
+
+func splitLines(snapshot []byte) []string {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(snapshot), "\n"), "\n")
+}
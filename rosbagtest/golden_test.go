@@ -0,0 +1,93 @@
+package rosbagtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const exampleBag = "../examples/logging/example.bag"
+
+func appendFloat64(b []byte, f float64) []byte {
+	var raw [8]byte
+	binary.LittleEndian.PutUint64(raw[:], math.Float64bits(f))
+	return append(b, raw[:]...)
+}
+
+func writeTestBag(t *testing.T) []byte {
+	builder := NewBuilder(t)
+	values := builder.Connection("/values", "test_msgs/Values", "float64[] values\ntime stamp\n")
+	other := builder.Connection("/other", "test_msgs/Other", "uint8 x")
+
+	raw := []byte{6, 0, 0, 0}
+	for _, f := range []float64{0.1 + 0.2, 2.71828, math.Copysign(0, -1), math.NaN(), math.Inf(-1), 1e20} {
+		raw = appendFloat64(raw, f)
+	}
+	raw = append(raw, 2, 0, 0, 0, 3, 0, 0, 0)
+
+	builder.Message(values, time.Unix(1, 5), raw)
+	builder.Message(other, time.Unix(2, 0), []byte{7})
+	return builder.Bytes()
+}
+
+func TestSnapshot(t *testing.T) {
+	raw := writeTestBag(t)
+	actual, err := Snapshot(rosbag.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"topic":"/values","time":"1.000000005","type":"test_msgs/Values","message":{"stamp":"2.000000003","values":[0.3,2.71828,0,"NaN","-Inf",1e+20]}}
+{"topic":"/other","time":"2.000000000","type":"test_msgs/Other","message":{"x":7}}
+`
+	if diff := Diff([]byte(expected), actual); diff != "" {
+		t.Fatalf("snapshot is not matched:\n%s", diff)
+	}
+
+	actual, err = Snapshot(rosbag.NewDecoder(bytes.NewReader(raw)), WithTopics("/val*"), WithFloatPrecision(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := splitLines(actual); len(lines) != 1 || !strings.Contains(lines[0], `[0.3,2.72,0,`) {
+		t.Fatalf("expected a /values line with 3 digits, but got %s", actual)
+	}
+
+	_, err = Snapshot(rosbag.NewDecoder(bytes.NewReader(raw)), WithTopics("re:["))
+	if err == nil {
+		t.Fatal("expected an invalid pattern to fail")
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	f, err := os.Open(exampleBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	AssertGolden(t, filepath.Join("testdata", "rosout.golden"), rosbag.NewDecoder(f), WithTopics("/rosout"))
+}
+
+func TestDiff(t *testing.T) {
+	if diff := Diff([]byte("a\nb\n"), []byte("a\nb\n")); diff != "" {
+		t.Fatalf("expected no diff, but got %s", diff)
+	}
+
+	expected := "line 2:\n- b\n+ c\nexpected 2 lines, but got 3"
+	if diff := Diff([]byte("a\nb\n"), []byte("a\nc\nd\n")); diff != expected {
+		t.Fatalf("expected %q, but got %q", expected, diff)
+	}
+
+	expected = "line 1:\n- \n+ a\nexpected 0 lines, but got 1"
+	if diff := Diff(nil, []byte("a\n")); diff != expected {
+		t.Fatalf("expected %q, but got %q", expected, diff)
+	}
+}
@@ -0,0 +1,443 @@
+package rosbag
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// indexEntrySize is the size of an entry in the data of an index data record, which is the
+	// time and the offset of a message in its chunk
+	indexEntrySize = 12
+)
+
+var (
+	errInvalidSampleSize = errors.New("sample size must be positive")
+	errInvalidBuckets    = errors.New("number of buckets must be positive")
+)
+
+// SampleOption configures an optional behavior of SampleMessages.
+type SampleOption func(*sampleConfig)
+
+type sampleConfig struct {
+	seed    int64
+	buckets int
+}
+
+// WithSampleSeed sets the seed of the random selection, so the same bags and seed always select
+// the same messages. The default is 1.
+func WithSampleSeed(seed int64) SampleOption {
+	return func(config *sampleConfig) {
+		config.seed = seed
+	}
+}
+
+// WithSampleBuckets stratifies the selection by time. The time range of the messages is split into
+// n buckets of the same duration, and every bucket gets an equal share of the sample, so that
+// the sample covers the whole recording even when the message rate changes. Buckets that have
+// fewer messages than their share contribute all of them, so the sample can be smaller than
+// requested.
+func WithSampleBuckets(n int) SampleOption {
+	return func(config *sampleConfig) {
+		config.buckets = n
+	}
+}
+
+// sampleCandidate is the location of a message that can be selected. Messages of indexed bags
+// are located by their chunk and offset, and messages of the other bags by their ordinal among
+// the matching messages of the bag.
+type sampleCandidate struct {
+	bag      int
+	time     time.Time
+	chunkPos uint64
+	offset   uint32
+	ordinal  uint64
+}
+
+// SampleMessages selects n messages uniformly at random from the messages whose topic matches
+// topic in all of bags, and calls fn with each of them, see Pattern for the topic syntax. When n
+// is larger than the number of messages, all of them are selected. bag is the index of the bag
+// in bags. The selected messages are delivered in the order of the bags, and the order of the
+// messages in each bag. Like Subscribe, the record is closed after fn returns.
+//
+// The messages are located with the index section of the bags, so only the chunks that contain
+// selected messages are read. Bags without an index section are read twice instead. The bags
+// must start at the current offsets of their readers.
+func SampleMessages(bags []io.ReadSeeker, topic string, n int, fn func(bag int, record *RecordMessageData) error, opts ...SampleOption) error {
+	config := sampleConfig{
+		seed:    1,
+		buckets: 1,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if n <= 0 {
+		return errInvalidSampleSize
+	}
+
+	if config.buckets <= 0 {
+		return errInvalidBuckets
+	}
+
+	pattern, err := CompilePattern(topic)
+	if err != nil {
+		return err
+	}
+
+	starts := make([]int64, len(bags))
+	indexed := make([]bool, len(bags))
+	var candidates []sampleCandidate
+	for i, bag := range bags {
+		starts[i], err = bag.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		indexed[i], err = locateIndexedMessages(bag, i, starts[i], pattern, &candidates)
+		if err != nil {
+			return err
+		}
+
+		if !indexed[i] {
+			err = locateStreamedMessages(bag, i, starts[i], pattern, &candidates)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	selected := selectSample(candidates, n, &config)
+	for i, bag := range bags {
+		var messages []sampleCandidate
+		for len(selected) > 0 && selected[0].bag == i {
+			messages = append(messages, selected[0])
+			selected = selected[1:]
+		}
+
+		if len(messages) == 0 {
+			continue
+		}
+
+		_, err = bag.Seek(starts[i], io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		if indexed[i] {
+			err = readIndexedMessages(bag, i, starts[i], messages, fn)
+		} else {
+			err = readStreamedMessages(bag, i, pattern, messages, fn)
+		}
+
+		if err != nil {
+			return fmt.Errorf("bag %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// selectSample selects n candidates, and returns them sorted by their location.
+func selectSample(candidates []sampleCandidate, n int, config *sampleConfig) []sampleCandidate {
+	rng := rand.New(rand.NewSource(config.seed))
+	var selected []sampleCandidate
+	if config.buckets == 1 || len(candidates) == 0 {
+		selected = selectRandom(rng, candidates, n)
+	} else {
+		start, end := candidates[0].time, candidates[0].time
+		for _, candidate := range candidates {
+			if candidate.time.Before(start) {
+				start = candidate.time
+			}
+			if candidate.time.After(end) {
+				end = candidate.time
+			}
+		}
+
+		buckets := make([][]sampleCandidate, config.buckets)
+		duration := end.Sub(start)
+		for _, candidate := range candidates {
+			var i int
+			if duration > 0 {
+				i = int(float64(candidate.time.Sub(start)) / float64(duration) * float64(config.buckets))
+			}
+			// the last message is at the end of the last bucket
+			if i >= config.buckets {
+				i = config.buckets - 1
+			}
+			buckets[i] = append(buckets[i], candidate)
+		}
+
+		for i, bucket := range buckets {
+			share := n / config.buckets
+			if i < n%config.buckets {
+				share++
+			}
+			selected = append(selected, selectRandom(rng, bucket, share)...)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		a, b := &selected[i], &selected[j]
+		if a.bag != b.bag {
+			return a.bag < b.bag
+		}
+		if a.chunkPos != b.chunkPos {
+			return a.chunkPos < b.chunkPos
+		}
+		if a.offset != b.offset {
+			return a.offset < b.offset
+		}
+		return a.ordinal < b.ordinal
+	})
+	return selected
+}
+
+// selectRandom selects n candidates with a partial Fisher-Yates shuffle. candidates is reordered.
+func selectRandom(rng *rand.Rand, candidates []sampleCandidate, n int) []sampleCandidate {
+	if n >= len(candidates) {
+		return candidates
+	}
+
+	for i := 0; i < n; i++ {
+		j := i + rng.Intn(len(candidates)-i)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	return candidates[:n]
+}
+
+// locateIndexedMessages appends the messages of the topic to candidates from the index data
+// records that follow every chunk. It returns false when the bag doesn't have an index section.
+func locateIndexedMessages(bag io.ReadSeeker, i int, start int64, pattern *Pattern, candidates *[]sampleCandidate) (bool, error) {
+	decoder := NewDecoder(bag)
+	err := decoder.Preload()
+	if err != nil {
+		return false, err
+	}
+
+	if len(decoder.chunkInfos) == 0 {
+		return false, nil
+	}
+
+	r := bufio.NewReader(bag)
+	for _, chunkInfo := range decoder.chunkInfos {
+		chunkPos, err := chunkInfo.ChunkPos()
+		if err != nil {
+			return false, err
+		}
+
+		counts, err := chunkInfo.MessageCounts()
+		if err != nil {
+			return false, err
+		}
+
+		// skip the chunk data, which is only read when the chunk has selected messages
+		_, err = bag.Seek(start+int64(chunkPos), io.SeekStart)
+		if err != nil {
+			return false, err
+		}
+		r.Reset(bag)
+
+		chunk, err := readRecordHeader(r)
+		if err != nil {
+			return false, err
+		}
+
+		indexPos := start + int64(chunkPos) + int64(len(chunk.Raw)) + int64(chunk.DataLen)
+		_, err = bag.Seek(indexPos, io.SeekStart)
+		if err != nil {
+			return false, err
+		}
+		r.Reset(bag)
+
+		// there is an index data record for every connection in the chunk
+		for range counts {
+			record, op, err := readRawRecord(r)
+			if err != nil {
+				return false, err
+			}
+
+			if op != OpIndexData {
+				return false, fmt.Errorf("expected an index data record after the chunk at %d, but got op %d", chunkPos, op)
+			}
+
+			conn, err := (&RecordIndexData{RecordBase: record}).Conn()
+			if err != nil {
+				return false, err
+			}
+
+			hdr, ok := decoder.conns[conn]
+			if !ok {
+				return false, errNotFoundConnectionHeader
+			}
+
+			if !pattern.Match(hdr.Topic) {
+				continue
+			}
+
+			data := record.Data()
+			if len(data)%indexEntrySize != 0 {
+				return false, fmt.Errorf("index data of connection %d has an invalid length of %d", conn, len(data))
+			}
+
+			for ; len(data) > 0; data = data[indexEntrySize:] {
+				*candidates = append(*candidates, sampleCandidate{
+					bag:      i,
+					time:     extractTime(data),
+					chunkPos: chunkPos,
+					offset:   endian.Uint32(data[8:]),
+				})
+			}
+		}
+	}
+
+	_, err = bag.Seek(start, io.SeekStart)
+	return true, err
+}
+
+// readRecordHeader reads the header of a record, and the length of its data, without the data.
+func readRecordHeader(r io.Reader) (*RecordBase, error) {
+	record := RecordBase{
+		Raw: make([]byte, lenInBytes),
+	}
+
+	_, err := io.ReadFull(r, record.Raw)
+	if err != nil {
+		return nil, err
+	}
+	record.HeaderLen = endian.Uint32(record.Raw)
+
+	record.grow(lenInBytes + record.HeaderLen + lenInBytes)
+	record.Raw = record.Raw[:lenInBytes+record.HeaderLen+lenInBytes]
+	_, err = io.ReadFull(r, record.Raw[lenInBytes:])
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	record.DataLen = endian.Uint32(record.Raw[lenInBytes+record.HeaderLen:])
+	return &record, nil
+}
+
+// locateStreamedMessages appends the messages of the topic to candidates by reading the whole bag.
+// The messages are not decoded.
+func locateStreamedMessages(bag io.ReadSeeker, i int, start int64, pattern *Pattern, candidates *[]sampleCandidate) error {
+	var ordinal uint64
+	err := streamSampleMessages(bag, pattern, func(record *RecordMessageData) error {
+		t, err := record.Time()
+		if err != nil {
+			return err
+		}
+
+		*candidates = append(*candidates, sampleCandidate{bag: i, time: t, ordinal: ordinal})
+		ordinal++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = bag.Seek(start, io.SeekStart)
+	return err
+}
+
+func streamSampleMessages(bag io.ReadSeeker, pattern *Pattern, fn MessageHandler) error {
+	decoder := NewDecoder(bag)
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok && pattern.Match(msg.ConnectionHeader().Topic) {
+			err = fn(msg)
+		}
+		record.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readIndexedMessages reads the chunks of messages, and calls fn with the messages. messages are
+// sorted by their location.
+func readIndexedMessages(bag io.ReadSeeker, i int, start int64, messages []sampleCandidate, fn func(bag int, record *RecordMessageData) error) error {
+	// the connections are needed to specialize the message data records
+	decoder := NewDecoder(bag)
+	err := decoder.Preload()
+	if err != nil {
+		return err
+	}
+
+	var chunkPos uint64
+	var chunk []byte
+	for j, message := range messages {
+		if j == 0 || message.chunkPos != chunkPos {
+			chunkPos = message.chunkPos
+			_, err = bag.Seek(start+int64(chunkPos), io.SeekStart)
+			if err != nil {
+				return err
+			}
+
+			record, op, err := readRawRecord(bag)
+			if err != nil {
+				return err
+			}
+
+			if op != OpChunk {
+				return fmt.Errorf("expected a chunk record at %d, but got op %d", chunkPos, op)
+			}
+
+			chunk, err = decompressChunk(&RecordChunk{RecordBase: record})
+			if err != nil {
+				return err
+			}
+		}
+
+		if uint64(message.offset) >= uint64(len(chunk)) {
+			return fmt.Errorf("index points to offset %d, which is outside of the chunk at %d", message.offset, chunkPos)
+		}
+
+		b := chunk[message.offset:]
+		record, err := decoder.sliceRecord(&b)
+		if err != nil {
+			return err
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			return fmt.Errorf("index points to offset %d, which is not a message in the chunk at %d", message.offset, chunkPos)
+		}
+
+		err = fn(i, msg)
+		msg.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStreamedMessages reads the bag again, and calls fn with the messages whose ordinals are
+// selected. messages are sorted by their ordinals.
+func readStreamedMessages(bag io.ReadSeeker, i int, pattern *Pattern, messages []sampleCandidate, fn func(bag int, record *RecordMessageData) error) error {
+	var ordinal uint64
+	return streamSampleMessages(bag, pattern, func(record *RecordMessageData) error {
+		defer func() { ordinal++ }()
+		if len(messages) == 0 || messages[0].ordinal != ordinal {
+			return nil
+		}
+
+		messages = messages[1:]
+		return fn(i, record)
+	})
+}
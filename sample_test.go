@@ -0,0 +1,125 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func sampleTimes(t *testing.T, bags []io.ReadSeeker, topic string, n int, opts ...SampleOption) []time.Time {
+	var times []time.Time
+	err := SampleMessages(bags, topic, n, func(bag int, record *RecordMessageData) error {
+		if record.ConnectionHeader().Topic != "/tf" && record.ConnectionHeader().Topic != "/plan" {
+			t.Fatalf("unexpected topic %s", record.ConnectionHeader().Topic)
+		}
+
+		// the records can be decoded
+		data := make(map[string]interface{})
+		err := record.ViewAs(data)
+		if err != nil {
+			return err
+		}
+
+		ts, err := record.Time()
+		times = append(times, ts)
+		return err
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bag := range bags {
+		_, err = bag.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return times
+}
+
+func TestSampleMessagesIndexed(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	bags := []io.ReadSeeker{f}
+	times := sampleTimes(t, bags, "/tf", 50)
+	if len(times) != 50 {
+		t.Fatalf("expected 50 messages, but got %d", len(times))
+	}
+
+	if diff := cmp.Diff(times, sampleTimes(t, bags, "/tf", 50)); diff != "" {
+		t.Fatalf("expected the same seed to select the same messages:\n\n%s", diff)
+	}
+
+	if cmp.Equal(times, sampleTimes(t, bags, "/tf", 50, WithSampleSeed(2))) {
+		t.Fatal("expected another seed to select other messages")
+	}
+
+	var all []time.Time
+	decoder := NewDecoder(f)
+	err := decoder.Subscribe("/tf", func(record *RecordMessageData) error {
+		ts, err := record.Time()
+		all = append(all, ts)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, end := all[0], all[len(all)-1]
+	bucket := end.Sub(start) / 4
+	times = sampleTimes(t, bags, "/tf", 8, WithSampleBuckets(4))
+	counts := make([]int, 4)
+	for _, ts := range times {
+		i := int(ts.Sub(start) / bucket)
+		if i == 4 {
+			i = 3
+		}
+		counts[i]++
+	}
+
+	if diff := cmp.Diff([]int{2, 2, 2, 2}, counts); diff != "" {
+		t.Fatalf("expected 2 messages in every bucket:\n\n%s", diff)
+	}
+
+	// all of the messages are selected
+	if n := len(sampleTimes(t, bags, "/tf", 10000)); n != len(all) {
+		t.Fatalf("expected %d messages, but got %d", len(all), n)
+	}
+}
+
+func TestSampleMessagesStreamed(t *testing.T) {
+	raw := writePlanTestBag(t)
+	bags := []io.ReadSeeker{bytes.NewReader(raw), bytes.NewReader(raw)}
+	times := sampleTimes(t, bags, "/plan", 4)
+	if len(times) != 4 {
+		t.Fatalf("expected 4 messages, but got %d", len(times))
+	}
+
+	// the messages are delivered in the order of the bags
+	if diff := cmp.Diff(times, sampleTimes(t, bags, "/plan", 4)); diff != "" {
+		t.Fatalf("expected the same seed to select the same messages:\n\n%s", diff)
+	}
+
+	if n := len(sampleTimes(t, bags, "/other", 4)); n != 0 {
+		t.Fatalf("expected no messages of /other, but got %d", n)
+	}
+
+	err := SampleMessages(bags, "/plan", 0, nil)
+	if err != errInvalidSampleSize {
+		t.Fatalf("expected %v, but got %v", errInvalidSampleSize, err)
+	}
+}
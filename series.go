@@ -0,0 +1,154 @@
+package rosbag
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	errInvalidGridRate = errors.New("grid rate must be positive")
+)
+
+// Interpolation decides how Series.Resample computes the values between the samples.
+type Interpolation uint8
+
+const (
+	// InterpolationLinear interpolates linearly between the samples before and after the time.
+	// Times outside of the samples are NaN.
+	InterpolationLinear Interpolation = iota
+	// InterpolationZeroOrderHold holds the value of the latest sample at or before the time.
+	// Times before the first sample are NaN.
+	InterpolationZeroOrderHold
+)
+
+// Series is a numeric signal, i.e. the values of a field over time. Time is sorted, and every
+// value is at the time with the same index.
+type Series struct {
+	Time   []time.Time
+	Values []float64
+}
+
+// ReadSeries subscribes to topic, runs decoder to the end, and returns the values of path in the
+// messages of the topic at their record times.
+func ReadSeries(decoder *Decoder, topic string, path *FieldPath) (*Series, error) {
+	series, err := readSeries(decoder, topic, []*FieldPath{path})
+	if err != nil {
+		return nil, err
+	}
+	return series[0], nil
+}
+
+// readSeries reads the series of every path in a single pass of the bag.
+func readSeries(decoder *Decoder, topic string, paths []*FieldPath) ([]*Series, error) {
+	series := make([]*Series, len(paths))
+	for i := range series {
+		series[i] = &Series{}
+	}
+
+	err := decoder.Subscribe(topic, func(record *RecordMessageData) error {
+		t, err := record.Time()
+		if err != nil {
+			return err
+		}
+
+		def := &record.ConnectionHeader().MessageDefinition
+		for i, path := range paths {
+			v, err := path.Float64(def, record.Data())
+			if err != nil {
+				return err
+			}
+
+			series[i].Time = append(series[i].Time, t)
+			series[i].Values = append(series[i].Values, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	// messages of multiple connections are not ordered in the bag
+	for _, s := range series {
+		sort.Stable(s)
+	}
+	return series, nil
+}
+
+// Len is the number of samples. Len, Less, and Swap implement sort.Interface to sort the samples
+// by time.
+func (series *Series) Len() int {
+	return len(series.Time)
+}
+
+func (series *Series) Less(i, j int) bool {
+	return series.Time[i].Before(series.Time[j])
+}
+
+func (series *Series) Swap(i, j int) {
+	series.Time[i], series.Time[j] = series.Time[j], series.Time[i]
+	series.Values[i], series.Values[j] = series.Values[j], series.Values[i]
+}
+
+// Resample returns the values of series at the times of grid, so that signals that are recorded
+// at different rates can be compared sample by sample. grid doesn't need to be sorted.
+func (series *Series) Resample(grid []time.Time, interpolation Interpolation) *Series {
+	resampled := Series{
+		Time:   grid,
+		Values: make([]float64, len(grid)),
+	}
+
+	n := series.Len()
+	for i, t := range grid {
+		switch interpolation {
+		case InterpolationZeroOrderHold:
+			// j is the first sample after t
+			j := sort.Search(n, func(j int) bool { return series.Time[j].After(t) })
+			if j == 0 {
+				resampled.Values[i] = math.NaN()
+			} else {
+				resampled.Values[i] = series.Values[j-1]
+			}
+		default:
+			// j is the first sample at or after t
+			j := sort.Search(n, func(j int) bool { return !series.Time[j].Before(t) })
+			switch {
+			case j < n && series.Time[j].Equal(t):
+				resampled.Values[i] = series.Values[j]
+			case j == 0 || j == n:
+				resampled.Values[i] = math.NaN()
+			default:
+				t0, t1 := series.Time[j-1], series.Time[j]
+				alpha := float64(t.Sub(t0)) / float64(t1.Sub(t0))
+				resampled.Values[i] = series.Values[j-1] + (series.Values[j]-series.Values[j-1])*alpha
+			}
+		}
+	}
+
+	return &resampled
+}
+
+// FixedRateGrid returns the times from start to end, inclusive, at rate Hz. It can be passed to
+// Series.Resample.
+func FixedRateGrid(start, end time.Time, rate float64) ([]time.Time, error) {
+	period := time.Duration(float64(time.Second) / rate)
+	if rate <= 0 || math.IsNaN(rate) || period <= 0 {
+		return nil, errInvalidGridRate
+	}
+
+	var grid []time.Time
+	for i := 0; ; i++ {
+		// multiplying instead of adding keeps the rounding errors from accumulating
+		t := start.Add(time.Duration(i) * period)
+		if t.After(end) {
+			return grid, nil
+		}
+		grid = append(grid, t)
+	}
+}
@@ -0,0 +1,91 @@
+package rosbag
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// writePlanTestBag writes a bag with a message of 3, 1, and 2 points on /plan, at the time of
+// their number of points.
+func writePlanTestBag(t *testing.T) []byte {
+	var records []testRecord
+	// the messages are written out of order
+	for _, points := range []int{3, 1, 2} {
+		records = append(records, testRecord{Time: time.Unix(int64(points), 0), Data: encodePlanTestMessage(points)})
+	}
+
+	return writeTestBag(t, withTestConns(&ConnectionHeader{
+		Topic:                "/plan",
+		Type:                 "test_msgs/Plan",
+		RawMessageDefinition: planTestMessageDefinition,
+	}), withTestRecords(records...))
+}
+
+func TestReadSeries(t *testing.T) {
+	raw := writePlanTestBag(t)
+	series, err := ReadSeries(NewDecoder(bytes.NewReader(raw)), "/plan", MustCompileFieldPath("points[0].y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Series{
+		Time:   []time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(3, 0)},
+		Values: []float64{0, 0, 0},
+	}
+	if diff := cmp.Diff(expected, *series); diff != "" {
+		t.Fatalf("series is not matched:\n\n%s", diff)
+	}
+
+	_, err = ReadSeries(NewDecoder(bytes.NewReader(raw)), "/plan", MustCompileFieldPath("points[1].x"))
+	if err == nil {
+		t.Fatal("expected the message with a single point to fail")
+	}
+}
+
+func TestSeriesResample(t *testing.T) {
+	t0 := time.Unix(10, 0)
+	series := Series{
+		Time:   []time.Time{t0, t0.Add(time.Second), t0.Add(3 * time.Second)},
+		Values: []float64{0, 10, 30},
+	}
+
+	grid, err := FixedRateGrid(t0.Add(-time.Second), t0.Add(4*time.Second), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(grid) != 11 || !grid[10].Equal(t0.Add(4*time.Second)) {
+		t.Fatalf("expected 11 times until %v, but got %v", t0.Add(4*time.Second), grid)
+	}
+
+	nan := math.NaN()
+	testCases := []struct {
+		interpolation Interpolation
+		expected      []float64
+	}{
+		{
+			interpolation: InterpolationLinear,
+			expected:      []float64{nan, nan, 0, 5, 10, 15, 20, 25, 30, nan, nan},
+		},
+		{
+			interpolation: InterpolationZeroOrderHold,
+			expected:      []float64{nan, nan, 0, 0, 10, 10, 10, 10, 30, 30, 30},
+		},
+	}
+
+	for _, testCase := range testCases {
+		resampled := series.Resample(grid, testCase.interpolation)
+		if diff := cmp.Diff(testCase.expected, resampled.Values, cmpopts.EquateNaNs()); diff != "" {
+			t.Fatalf("interpolation %d is not matched:\n\n%s", testCase.interpolation, diff)
+		}
+	}
+
+	if _, err = FixedRateGrid(t0, t0, 0); err != errInvalidGridRate {
+		t.Fatalf("expected %v, but got %v", errInvalidGridRate, err)
+	}
+}
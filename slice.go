@@ -0,0 +1,261 @@
+package rosbag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// BagSlice is a view of the messages of an indexed bag within a time window, and optionally on
+// some topics. The view doesn't copy the bag, it only keeps the positions of the chunks that have
+// messages in the view, which are located from the index section. Every decoder of the view reads
+// the chunks from the bag, so code that reads "a bag" with a Decoder can be handed arbitrary
+// slices without writing temporary files.
+type BagSlice struct {
+	r      io.ReaderAt
+	filter *windowFilter
+	conns  map[uint32]*ConnectionHeader
+	// all is every chunk of the bag, and chunks are the ones that have messages in the view
+	all    []workChunk
+	chunks []workChunk
+}
+
+// Slice returns a view of the messages of the bag in r whose record times are in [start, end),
+// and whose topics match any of topics, see Pattern. A zero start or end leaves the window open on
+// that side, and every topic is in the view when topics are empty. r must be a whole bag with an
+// index section.
+func Slice(r io.ReaderAt, start, end time.Time, topics ...string) (*BagSlice, error) {
+	bag := io.NewSectionReader(r, 0, math.MaxInt64)
+	decoder := NewDecoder(bag)
+	err := decoder.Preload()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoder.chunkInfos) == 0 {
+		return nil, errNotIndexed
+	}
+
+	chunks, err := locateWorkChunks(bag, 0, decoder.chunkInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	slice := BagSlice{
+		r:      r,
+		filter: &windowFilter{},
+		conns:  decoder.conns,
+		all:    chunks,
+	}
+	return slice.Slice(start, end, topics...)
+}
+
+// Slice returns a narrower view of the messages of the view whose record times are in
+// [start, end), and whose topics match any of topics, like Slice. The view is not changed.
+func (slice *BagSlice) Slice(start, end time.Time, topics ...string) (*BagSlice, error) {
+	filter := *slice.filter
+	if !start.IsZero() && (filter.start.IsZero() || start.After(filter.start)) {
+		filter.start = start
+	}
+
+	if !end.IsZero() && (filter.end.IsZero() || end.Before(filter.end)) {
+		filter.end = end
+	}
+
+	if len(topics) > 0 {
+		patterns := make([]*Pattern, 0, len(topics))
+		for _, topic := range topics {
+			pattern, err := CompilePattern(topic)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, pattern)
+		}
+		filter.topics = append(filter.topics[:len(filter.topics):len(filter.topics)], patterns)
+	}
+	filter.allowed = nil
+
+	narrowed := BagSlice{
+		r:      slice.r,
+		filter: &filter,
+		conns:  slice.conns,
+		all:    slice.all,
+	}
+
+	for _, chunk := range slice.all {
+		if narrowed.hasMessages(chunk) {
+			narrowed.chunks = append(narrowed.chunks, chunk)
+		}
+	}
+	return &narrowed, nil
+}
+
+// hasMessages reports whether chunk can have messages in the view based on its chunk info.
+func (slice *BagSlice) hasMessages(chunk workChunk) bool {
+	if !slice.filter.start.IsZero() && chunk.endTime.Before(slice.filter.start) {
+		return false
+	}
+
+	if !slice.filter.end.IsZero() && !chunk.startTime.Before(slice.filter.end) {
+		return false
+	}
+
+	for conn, count := range chunk.counts {
+		hdr, ok := slice.conns[conn]
+		if count > 0 && ok && slice.filter.matchTopic(hdr.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start returns the inclusive start of the time window of the view, it's zero when the window
+// is open at the start.
+func (slice *BagSlice) Start() time.Time {
+	return slice.filter.start
+}
+
+// End returns the exclusive end of the time window of the view, it's zero when the window is open
+// at the end.
+func (slice *BagSlice) End() time.Time {
+	return slice.filter.end
+}
+
+// Chunks returns the number of chunks that are read by the decoders of the view.
+func (slice *BagSlice) Chunks() int {
+	return len(slice.chunks)
+}
+
+// Connections returns the connections on the topics of the view that have messages in its
+// chunks, sorted by their IDs.
+func (slice *BagSlice) Connections() []WorkConnection {
+	var unit WorkUnit
+	conns := make(map[uint32]bool)
+	for _, chunk := range slice.chunks {
+		for conn, count := range chunk.counts {
+			hdr, ok := slice.conns[conn]
+			if count > 0 && ok && slice.filter.matchTopic(hdr.Topic) {
+				conns[conn] = true
+			}
+		}
+	}
+
+	// the connections are known, so this can't fail
+	_ = unit.setConnections(slice.conns, conns)
+	return unit.Connections
+}
+
+// NewDecoder creates a decoder that reads the messages of the view. The decoder reads a bag that
+// only has the chunks of the view, and the connection records of their messages before them, and
+// it skips the records that are not in the view. opts are passed to NewDecoder.
+func (slice *BagSlice) NewDecoder(opts ...DecoderOption) *Decoder {
+	conns := make(map[uint32]bool)
+	for _, chunk := range slice.chunks {
+		for conn := range chunk.counts {
+			if _, ok := slice.conns[conn]; ok {
+				conns[conn] = true
+			}
+		}
+	}
+
+	ids := make([]uint32, 0, len(conns))
+	for conn := range conns {
+		ids = append(ids, conn)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var prefix bytes.Buffer
+	fmt.Fprintf(&prefix, versionFormat, supportedVersion.Major, supportedVersion.Minor)
+	// the view doesn't have an index section
+	bagHeader, _ := encodeBagHeader(0, uint32(len(ids)), uint32(len(slice.chunks)), nil)
+	prefix.Write(bagHeader)
+	for _, conn := range ids {
+		prefix.Write(encodeConnectionRecord(conn, slice.conns[conn]))
+	}
+
+	readers := []io.Reader{&prefix}
+	for _, chunk := range slice.chunks {
+		readers = append(readers, io.NewSectionReader(slice.r, chunk.pos, chunk.length))
+	}
+
+	// the filter caches its decisions per connection header, so every decoder has its own
+	filter := *slice.filter
+	filter.allowed = nil
+	opts = append(opts[:len(opts):len(opts)], func(config *decoderConfig) {
+		config.windowFilter = &filter
+	})
+	return NewDecoder(io.MultiReader(readers...), opts...)
+}
+
+// windowFilter skips the records that are not in a BagSlice. A nil windowFilter allows every
+// record.
+type windowFilter struct {
+	start time.Time
+	end   time.Time
+	// topics are the topic patterns of the slices that a view is narrowed from, a topic must
+	// match a pattern of every group
+	topics [][]*Pattern
+	// allowed caches the topic decision per connection header
+	allowed map[*ConnectionHeader]bool
+}
+
+func (filter *windowFilter) matchTopic(topic string) bool {
+	for _, patterns := range filter.topics {
+		matched := false
+		for _, pattern := range patterns {
+			if pattern.Match(topic) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// skip reports whether record should be skipped by the decoder.
+func (filter *windowFilter) skip(record Record) bool {
+	if filter == nil {
+		return false
+	}
+
+	switch record := record.(type) {
+	case *RecordMessageData:
+		connHdr := record.ConnectionHeader()
+		allowed, ok := filter.allowed[connHdr]
+		if !ok {
+			allowed = filter.matchTopic(connHdr.Topic)
+			if filter.allowed == nil {
+				filter.allowed = make(map[*ConnectionHeader]bool)
+			}
+			filter.allowed[connHdr] = allowed
+		}
+
+		if !allowed {
+			return true
+		}
+
+		t, err := record.Time()
+		if err != nil {
+			// let the caller see the broken record
+			return false
+		}
+		return (!filter.start.IsZero() && t.Before(filter.start)) || (!filter.end.IsZero() && !t.Before(filter.end))
+	case *RecordConnection:
+		connHdr, err := record.ConnectionHeader()
+		if err != nil {
+			return false
+		}
+		return !filter.matchTopic(connHdr.Topic)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSlice(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}},
+		[]indexedTestMessage{{0, 3}},
+		[]indexedTestMessage{{1, 5}, {0, 6}},
+	)
+
+	testCases := []struct {
+		name     string
+		start    int64
+		end      int64
+		topics   []string
+		chunks   int
+		expected []indexedTestMessage
+	}{
+		{"Whole", 0, 0, nil, 3, []indexedTestMessage{{0, 1}, {1, 2}, {0, 3}, {1, 5}, {0, 6}}},
+		{"Window", 3, 6, nil, 2, []indexedTestMessage{{0, 3}, {1, 5}}},
+		{"Topics", 0, 0, []string{"/b"}, 2, []indexedTestMessage{{1, 2}, {1, 5}}},
+		{"Empty", 7, 0, nil, 0, nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var start, end time.Time
+			if testCase.start > 0 {
+				start = time.Unix(testCase.start, 0)
+			}
+			if testCase.end > 0 {
+				end = time.Unix(testCase.end, 0)
+			}
+
+			slice, err := Slice(bytes.NewReader(raw), start, end, testCase.topics...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if slice.Chunks() != testCase.chunks {
+				t.Fatalf("expected %d chunks, but got %d", testCase.chunks, slice.Chunks())
+			}
+
+			// the view can be read more than once
+			for i := 0; i < 2; i++ {
+				actual := readIndexedTestMessages(t, slice.NewDecoder())
+				if diff := cmp.Diff(testCase.expected, actual); diff != "" {
+					t.Fatalf("messages of read %d are not matched:\n\n%s", i, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSliceNarrow(t *testing.T) {
+	raw := writeIndexedTestBag(t,
+		[]indexedTestMessage{{0, 1}, {1, 2}},
+		[]indexedTestMessage{{0, 3}},
+		[]indexedTestMessage{{1, 5}, {0, 6}},
+	)
+
+	slice, err := Slice(bytes.NewReader(raw), time.Time{}, time.Unix(6, 0), "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	narrowed, err := slice.Slice(time.Unix(3, 0), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !narrowed.Start().Equal(time.Unix(3, 0)) || !narrowed.End().Equal(time.Unix(6, 0)) {
+		t.Fatalf("expected the window to be [3s, 6s), but got [%v, %v)", narrowed.Start(), narrowed.End())
+	}
+
+	if diff := cmp.Diff([]indexedTestMessage{{1, 5}}, readIndexedTestMessages(t, narrowed.NewDecoder())); diff != "" {
+		t.Fatalf("messages are not matched:\n\n%s", diff)
+	}
+
+	expected := []WorkConnection{{Conn: 1, Topic: "/b", Type: "test_msgs/Test", MD5Sum: "*", MessageDefinition: "uint8 x"}}
+	if diff := cmp.Diff(expected, narrowed.Connections()); diff != "" {
+		t.Fatalf("connections are not matched:\n\n%s", diff)
+	}
+
+	// the topics of the views are intersected
+	disjoint, err := narrowed.Slice(time.Time{}, time.Time{}, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if disjoint.Chunks() != 0 || len(disjoint.Connections()) != 0 {
+		t.Fatalf("expected an empty view, but got %d chunks", disjoint.Chunks())
+	}
+
+	// the original view is not changed
+	if diff := cmp.Diff([]indexedTestMessage{{1, 2}, {1, 5}}, readIndexedTestMessages(t, slice.NewDecoder())); diff != "" {
+		t.Fatalf("messages are not matched:\n\n%s", diff)
+	}
+
+	_, err = slice.Slice(time.Time{}, time.Time{}, "re:(")
+	if err == nil {
+		t.Fatal("expected an invalid topic to fail")
+	}
+}
+
+func TestSliceNotIndexed(t *testing.T) {
+	_, err := Slice(bytes.NewReader(writePlanTestBag(t)), time.Time{}, time.Time{})
+	if err != errNotIndexed {
+		t.Fatalf("expected %v, but got %v", errNotIndexed, err)
+	}
+}
@@ -0,0 +1,395 @@
+package rosbag
+
+import (
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	defaultSortMemoryLimit = 256 * 1024 * 1024
+	// sortedMessageOverhead is the estimated memory used by a buffered message besides its data
+	sortedMessageOverhead = 64
+)
+
+// SortOption configures an optional behavior of SortByTime.
+type SortOption func(*sortConfig)
+
+type sortConfig struct {
+	memoryLimit int
+	tempDir     string
+	writerOpts  []WriterOption
+}
+
+// WithSortMemoryLimit sets the approximate number of bytes of messages that SortByTime buffers in
+// memory. When the bag is larger than the limit, the messages are sorted in runs that are spilled
+// to temporary files, and the runs are merged at the end. The default is 256 MB.
+func WithSortMemoryLimit(bytes int) SortOption {
+	return func(config *sortConfig) {
+		config.memoryLimit = bytes
+	}
+}
+
+// WithSortTempDir sets the directory of the temporary run files. The default is os.TempDir().
+func WithSortTempDir(dir string) SortOption {
+	return func(config *sortConfig) {
+		config.tempDir = dir
+	}
+}
+
+// WithSortWriterOptions sets the options of the writer of the sorted bag, e.g. WithChunkSize.
+func WithSortWriterOptions(opts ...WriterOption) SortOption {
+	return func(config *sortConfig) {
+		config.writerOpts = append(config.writerOpts, opts...)
+	}
+}
+
+type sortedMessage struct {
+	conn uint32
+	time time.Time
+	data []byte
+}
+
+// sortRun is a sorted run of messages that has been spilled to a temporary file. The connection
+// IDs in the file are local to the run, conns maps them back to the source connection IDs.
+type sortRun struct {
+	file    *os.File
+	decoder *Decoder
+	conns   []uint32
+	// index is the position of the run, it breaks ties so that the sort is stable
+	index   int
+	current sortedMessage
+	record  Record
+}
+
+// SortByTime rewrites the bag from src to dst with all messages globally sorted by their record
+// time. Messages with the same time keep their original order. The connections are preserved, and
+// the messages are re-chunked by the writer of dst. Bags that don't fit in the memory limit are
+// sorted with an external merge sort, see WithSortMemoryLimit.
+func SortByTime(dst io.Writer, src io.Reader, opts ...SortOption) error {
+	config := sortConfig{
+		memoryLimit: defaultSortMemoryLimit,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	var runs []*sortRun
+	defer func() {
+		for _, run := range runs {
+			run.close()
+		}
+	}()
+
+	conns := make(map[uint32]*ConnectionHeader)
+	var messages []sortedMessage
+	var size int
+
+	decoder := NewDecoder(src)
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch record := record.(type) {
+		case *RecordConnection:
+			err = rememberConnection(conns, record)
+		case *RecordMessageData:
+			var msg sortedMessage
+			msg, err = copySortedMessage(record)
+			messages = append(messages, msg)
+			size += len(msg.data) + sortedMessageOverhead
+		}
+		record.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if size >= config.memoryLimit {
+			run, err := spillSortRun(&config, conns, messages, len(runs))
+			if err != nil {
+				return err
+			}
+
+			runs = append(runs, run)
+			messages = messages[:0]
+			size = 0
+		}
+	}
+
+	sortMessages(messages)
+	writer := newSortedWriter(dst, conns, config.writerOpts)
+	if len(runs) == 0 {
+		for _, msg := range messages {
+			err := writer.write(msg)
+			if err != nil {
+				return err
+			}
+		}
+		return writer.Close()
+	}
+
+	if len(messages) > 0 {
+		run, err := spillSortRun(&config, conns, messages, len(runs))
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+
+	err := mergeSortRuns(writer, runs)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func rememberConnection(conns map[uint32]*ConnectionHeader, record *RecordConnection) error {
+	conn, err := record.Conn()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := record.ConnectionHeader()
+	if err != nil {
+		return err
+	}
+
+	if hdr.Topic == "" {
+		hdr.Topic, err = record.Topic()
+		if err != nil {
+			return err
+		}
+	}
+
+	conns[conn] = hdr
+	return nil
+}
+
+// copySortedMessage copies the message out of record since the record is reused after Close.
+func copySortedMessage(record *RecordMessageData) (sortedMessage, error) {
+	conn, err := record.Conn()
+	if err != nil {
+		return sortedMessage{}, err
+	}
+
+	t, err := record.Time()
+	if err != nil {
+		return sortedMessage{}, err
+	}
+
+	data := make([]byte, len(record.Data()))
+	copy(data, record.Data())
+	return sortedMessage{conn: conn, time: t, data: data}, nil
+}
+
+func sortMessages(messages []sortedMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].time.Before(messages[j].time)
+	})
+}
+
+func spillSortRun(config *sortConfig, conns map[uint32]*ConnectionHeader, messages []sortedMessage, index int) (*sortRun, error) {
+	sortMessages(messages)
+
+	file, err := ioutil.TempFile(config.tempDir, "rosbag-sort-*.bag")
+	if err != nil {
+		return nil, err
+	}
+
+	run := sortRun{file: file, index: index}
+	err = run.write(conns, messages)
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+
+	run.decoder = NewDecoder(file)
+	return &run, nil
+}
+
+func (run *sortRun) write(conns map[uint32]*ConnectionHeader, messages []sortedMessage) error {
+	writer := NewWriter(run.file)
+	runConns := make(map[uint32]uint32)
+	for _, msg := range messages {
+		runConn, ok := runConns[msg.conn]
+		if !ok {
+			hdr, ok := conns[msg.conn]
+			if !ok {
+				return errNotFoundConnectionHeader
+			}
+
+			var err error
+			runConn, err = writer.WriteConnection(hdr)
+			if err != nil {
+				return err
+			}
+
+			runConns[msg.conn] = runConn
+			run.conns = append(run.conns, msg.conn)
+		}
+
+		err := writer.WriteMessage(runConn, msg.time, msg.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// next reads the next message of the run. It returns io.EOF when the run is exhausted.
+func (run *sortRun) next() error {
+	if run.record != nil {
+		run.record.Close()
+		run.record = nil
+	}
+
+	for {
+		record, err := run.decoder.Read()
+		if err != nil {
+			return err
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		runConn, err := msg.Conn()
+		if err != nil {
+			msg.Close()
+			return err
+		}
+
+		t, err := msg.Time()
+		if err != nil {
+			msg.Close()
+			return err
+		}
+
+		// the data is only used until the next call, so it doesn't need to be copied
+		run.record = msg
+		run.current = sortedMessage{conn: run.conns[runConn], time: t, data: msg.Data()}
+		return nil
+	}
+}
+
+func (run *sortRun) close() {
+	if run.record != nil {
+		run.record.Close()
+		run.record = nil
+	}
+
+	run.file.Close()
+	os.Remove(run.file.Name())
+}
+
+// sortRunHeap is a min heap of runs ordered by their current message.
+type sortRunHeap []*sortRun
+
+func (h sortRunHeap) Len() int { return len(h) }
+func (h sortRunHeap) Less(i, j int) bool {
+	if h[i].current.time.Equal(h[j].current.time) {
+		return h[i].index < h[j].index
+	}
+	return h[i].current.time.Before(h[j].current.time)
+}
+func (h sortRunHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sortRunHeap) Push(x interface{}) { *h = append(*h, x.(*sortRun)) }
+func (h *sortRunHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+func mergeSortRuns(writer *sortedWriter, runs []*sortRun) error {
+	h := make(sortRunHeap, 0, len(runs))
+	for _, run := range runs {
+		err := run.next()
+		if err == io.EOF {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+		h = append(h, run)
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		run := h[0]
+		err := writer.write(run.current)
+		if err != nil {
+			return err
+		}
+
+		err = run.next()
+		if err == io.EOF {
+			heap.Pop(&h)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+		heap.Fix(&h, 0)
+	}
+
+	return nil
+}
+
+// sortedWriter writes sorted messages, and registers the source connections on first use.
+type sortedWriter struct {
+	*Writer
+	conns    map[uint32]*ConnectionHeader
+	remapper *ConnRemapper
+}
+
+func newSortedWriter(w io.Writer, conns map[uint32]*ConnectionHeader, opts []WriterOption) *sortedWriter {
+	return &sortedWriter{
+		Writer:   NewWriter(w, opts...),
+		conns:    conns,
+		remapper: NewConnRemapper(),
+	}
+}
+
+func (writer *sortedWriter) write(msg sortedMessage) error {
+	outConn, ok := writer.remapper.Lookup(0, msg.conn)
+	if !ok {
+		hdr, ok := writer.conns[msg.conn]
+		if !ok {
+			return errNotFoundConnectionHeader
+		}
+
+		var isNew bool
+		outConn, isNew = writer.remapper.Map(0, msg.conn, hdr)
+		if isNew {
+			_, err := writer.WriteConnection(hdr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.WriteMessage(outConn, msg.time, msg.data)
+}
@@ -0,0 +1,94 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSortByTime(t *testing.T) {
+	// x is the expected position of the message after sorting
+	writes := []struct {
+		Topic string
+		Time  int64
+		X     uint8
+	}{
+		{"/a", 3, 3},
+		{"/b", 1, 0},
+		{"/a", 2, 2},
+		{"/b", 5, 5},
+		{"/a", 1, 1},
+		{"/b", 4, 4},
+	}
+
+	var src bytes.Buffer
+	writer := NewWriter(&src, WithChunkSize(1))
+	conns := make(map[string]uint32)
+	for _, topic := range []string{"/a", "/b"} {
+		conn, err := writer.WriteConnection(&ConnectionHeader{
+			Topic:                topic,
+			Type:                 "test_msgs/Test",
+			RawMessageDefinition: "uint8 x",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[topic] = conn
+	}
+
+	for _, write := range writes {
+		err := writer.WriteMessage(conns[write.Topic], time.Unix(write.Time, 0), []byte{write.X})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTopics := make([]string, len(writes))
+	for _, write := range writes {
+		expectedTopics[write.X] = write.Topic
+	}
+
+	testCases := []struct {
+		Name string
+		Opts []SortOption
+	}{
+		{
+			Name: "In Memory",
+		},
+		{
+			Name: "External",
+			Opts: []SortOption{WithSortMemoryLimit(1), WithSortTempDir(t.TempDir())},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			var dst bytes.Buffer
+			err := SortByTime(&dst, bytes.NewReader(src.Bytes()), testCase.Opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := readWrittenMessages(t, dst.Bytes())
+			if len(actual) != len(writes) {
+				t.Fatalf("expected %d messages, but got %d", len(writes), len(actual))
+			}
+
+			for i, msg := range actual {
+				if msg.X != uint8(i) {
+					t.Fatalf("expected message %d to be %d, but got %d", i, i, msg.X)
+				}
+
+				if msg.Topic != expectedTopics[i] {
+					t.Fatalf("expected message %d to be on %s, but got %s", i, expectedTopics[i], msg.Topic)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package rosbag
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultStreamBufferLen = 64
+)
+
+// BackpressurePolicy decides what a Stream does when its consumer falls behind and the buffer of
+// the stream is full.
+type BackpressurePolicy uint8
+
+const (
+	// BackpressureBlock stops decoding until the consumer catches up. No message is dropped.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest drops the oldest buffered message to make room for the new one, so
+	// the consumer always sees the most recent messages.
+	BackpressureDropOldest
+	// BackpressureDropNewest drops the new message, and keeps the buffered ones.
+	BackpressureDropNewest
+)
+
+// StreamOption configures an optional behavior of a Stream.
+type StreamOption func(*streamConfig)
+
+type streamConfig struct {
+	bufferLen int
+	policy    BackpressurePolicy
+}
+
+// WithStreamBuffer sets the number of messages that can be buffered in the stream channel
+// before the backpressure policy kicks in. The default is 64.
+func WithStreamBuffer(n int) StreamOption {
+	return func(config *streamConfig) {
+		config.bufferLen = n
+	}
+}
+
+// WithBackpressure sets the behavior of the stream when its buffer is full. The default is
+// BackpressureBlock.
+func WithBackpressure(policy BackpressurePolicy) StreamOption {
+	return func(config *streamConfig) {
+		config.policy = policy
+	}
+}
+
+// Stream decodes message data records in a background goroutine, and delivers them through
+// a channel.
+type Stream struct {
+	// dropped is accessed atomically, so it's kept first to be 64-bit aligned
+	dropped uint64
+
+	// C delivers the decoded messages. The consumer must close every received record. C is
+	// closed when the decoder reaches EOF, fails, or the stream is closed.
+	C <-chan *RecordMessageData
+
+	c       chan *RecordMessageData
+	decoder *Decoder
+	policy  BackpressurePolicy
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Stream starts reading the rest of the bag in a background goroutine, and sends every message
+// data record to the returned Stream. Other records are closed right away. The decoder must not
+// be used by the caller while the stream is running.
+func (decoder *Decoder) Stream(opts ...StreamOption) *Stream {
+	config := streamConfig{
+		bufferLen: defaultStreamBufferLen,
+		policy:    BackpressureBlock,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	c := make(chan *RecordMessageData, config.bufferLen)
+	stream := Stream{
+		C:       c,
+		c:       c,
+		decoder: decoder,
+		policy:  config.policy,
+		done:    make(chan struct{}),
+	}
+
+	go stream.run()
+	return &stream
+}
+
+// Err returns the error that stopped the stream. It must only be called after C is closed.
+// Err returns nil when the stream stops at EOF or by Close.
+func (stream *Stream) Err() error {
+	return stream.err
+}
+
+// Dropped returns the number of messages that have been dropped by the backpressure policy.
+func (stream *Stream) Dropped() uint64 {
+	return atomic.LoadUint64(&stream.dropped)
+}
+
+// Close stops the stream, and closes the records that are buffered but not received yet.
+func (stream *Stream) Close() {
+	stream.once.Do(func() {
+		close(stream.done)
+	})
+
+	for record := range stream.c {
+		record.Close()
+	}
+}
+
+func (stream *Stream) run() {
+	defer close(stream.c)
+
+	for {
+		record, err := stream.decoder.Read()
+		if err != nil {
+			if err != io.EOF {
+				stream.err = err
+			}
+			return
+		}
+
+		msg, ok := record.(*RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		if !stream.send(msg) {
+			return
+		}
+	}
+}
+
+// send sends msg based on the backpressure policy. It returns false when the stream is closed.
+func (stream *Stream) send(msg *RecordMessageData) bool {
+	if !sendWithBackpressure(stream.c, msg, stream.policy, stream.done, &stream.dropped, (*RecordMessageData).Close) {
+		msg.Close()
+		return false
+	}
+	return true
+}
+
+// sendWithBackpressure sends msg to c based on policy, and calls release with every message that
+// is dropped, counting it in dropped. It returns false when done is closed before msg is sent,
+// msg is not released then.
+func sendWithBackpressure[T any](c chan T, msg T, policy BackpressurePolicy, done <-chan struct{}, dropped *uint64, release func(T)) bool {
+	if policy == BackpressureBlock {
+		select {
+		case c <- msg:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			return false
+		case c <- msg:
+			return true
+		default:
+		}
+
+		if policy == BackpressureDropNewest {
+			release(msg)
+			atomic.AddUint64(dropped, 1)
+			return true
+		}
+
+		// BackpressureDropOldest, the consumer might have taken the oldest message in the
+		// meantime, so try sending again either way
+		select {
+		case oldest := <-c:
+			release(oldest)
+			atomic.AddUint64(dropped, 1)
+		default:
+		}
+	}
+}
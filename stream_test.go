@@ -0,0 +1,104 @@
+package rosbag
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestDecoderStream(t *testing.T) {
+	values := []uint8{1, 2, 3, 4, 5}
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	testCases := []struct {
+		Name            string
+		Policy          BackpressurePolicy
+		Expected        []uint8
+		ExpectedDropped uint64
+	}{
+		{
+			Name:     "Block",
+			Policy:   BackpressureBlock,
+			Expected: values,
+		},
+		{
+			Name:            "Drop Oldest",
+			Policy:          BackpressureDropOldest,
+			Expected:        values[3:],
+			ExpectedDropped: 3,
+		},
+		{
+			Name:            "Drop Newest",
+			Policy:          BackpressureDropNewest,
+			Expected:        values[:2],
+			ExpectedDropped: 3,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			decoder := NewDecoder(bytes.NewReader(raw))
+			stream := decoder.Stream(WithStreamBuffer(2), WithBackpressure(testCase.Policy))
+			defer stream.Close()
+
+			if testCase.Policy != BackpressureBlock {
+				// let the consumer fall behind until the whole bag has been decoded
+				for stream.Dropped() < testCase.ExpectedDropped {
+					runtime.Gosched()
+				}
+			}
+
+			var actual []uint8
+			for record := range stream.C {
+				v := make(map[string]interface{})
+				err := record.ViewAs(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				actual = append(actual, v["x"].(uint8))
+				record.Close()
+			}
+
+			if err := stream.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(testCase.Expected, actual) {
+				t.Fatalf("expected messages to be %v, but got %v", testCase.Expected, actual)
+			}
+
+			if stream.Dropped() != testCase.ExpectedDropped {
+				t.Fatalf("expected %d dropped messages, but got %d", testCase.ExpectedDropped, stream.Dropped())
+			}
+		})
+	}
+}
+
+func TestDecoderStreamClose(t *testing.T) {
+	values := []uint8{1, 2, 3, 4, 5}
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	decoder := NewDecoder(bytes.NewReader(raw))
+	stream := decoder.Stream(WithStreamBuffer(1))
+
+	record, ok := <-stream.C
+	if !ok {
+		t.Fatal("expected a message before the stream is closed")
+	}
+	record.Close()
+
+	// Close must unblock the producer and drain the channel
+	stream.Close()
+	if _, ok := <-stream.C; ok {
+		t.Fatal("expected the stream channel to be closed")
+	}
+
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
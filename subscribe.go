@@ -0,0 +1,92 @@
+package rosbag
+
+import (
+	"io"
+)
+
+// MessageHandler handles a message data record that is dispatched by Decoder.Run. The record is
+// closed after the handler returns, so the handler must not keep a reference to the record, or to
+// the values viewed from it.
+type MessageHandler func(record *RecordMessageData) error
+
+type subscription struct {
+	pattern *Pattern
+	handler MessageHandler
+}
+
+// Subscribe registers fn to be called by Run for every message whose topic matches topic. topic
+// can be an exact topic name, a glob, or a regular expression, see Pattern. When a message
+// matches multiple subscriptions, the handlers are called in the order they're registered.
+// An error is returned when topic is not a valid pattern.
+func (decoder *Decoder) Subscribe(topic string, fn MessageHandler) error {
+	pattern, err := CompilePattern(topic)
+	if err != nil {
+		return err
+	}
+
+	decoder.subscriptions = append(decoder.subscriptions, subscription{
+		pattern: pattern,
+		handler: fn,
+	})
+	// the subscriptions have changed, so the handlers need to be matched again
+	decoder.topicHandlers = nil
+	return nil
+}
+
+// Run reads the rest of the bag and dispatches every message to the handlers that are subscribed
+// to its topic. Records without a subscriber are closed right away. Run returns nil when it
+// reaches EOF. Otherwise, it stops at the first error from either the decoder or a handler, and
+// returns that error.
+func (decoder *Decoder) Run() error {
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = decoder.dispatch(record)
+		record.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (decoder *Decoder) dispatch(record Record) error {
+	msg, ok := record.(*RecordMessageData)
+	if !ok {
+		return nil
+	}
+
+	for _, handler := range decoder.handlers(msg.ConnectionHeader().Topic) {
+		if err := handler(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handlers returns the handlers that are subscribed to topic. The result is cached per topic so
+// that the patterns are matched once for every topic.
+func (decoder *Decoder) handlers(topic string) []MessageHandler {
+	handlers, ok := decoder.topicHandlers[topic]
+	if ok {
+		return handlers
+	}
+
+	for _, sub := range decoder.subscriptions {
+		if sub.pattern.Match(topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+
+	if decoder.topicHandlers == nil {
+		decoder.topicHandlers = make(map[string][]MessageHandler)
+	}
+	decoder.topicHandlers[topic] = handlers
+	return handlers
+}
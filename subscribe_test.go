@@ -0,0 +1,79 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecoderSubscribe(t *testing.T) {
+	values := []uint8{1, 2, 3}
+	raw := []byte("#ROSBAG V2.0\n")
+	raw = append(raw, encodeTestChunkRecords(values...)...)
+
+	errHandler := errors.New("handler failed")
+
+	testCases := []struct {
+		Name     string
+		Topic    string
+		Fail     error
+		Expected []uint8
+	}{
+		{
+			Name:     "Subscribed Topic",
+			Topic:    "/test",
+			Expected: values,
+		},
+		{
+			Name:  "Other Topic",
+			Topic: "/other",
+		},
+		{
+			Name:     "Glob",
+			Topic:    "/te*",
+			Expected: values,
+		},
+		{
+			Name:     "Regexp",
+			Topic:    "re:/(test|other)",
+			Expected: values,
+		},
+		{
+			Name:     "Handler Error",
+			Topic:    "/test",
+			Fail:     errHandler,
+			Expected: values[:1],
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			var actual []uint8
+			decoder := NewDecoder(bytes.NewReader(raw))
+			err := decoder.Subscribe(testCase.Topic, func(record *RecordMessageData) error {
+				v := make(map[string]interface{})
+				err := record.ViewAs(v)
+				if err != nil {
+					return err
+				}
+
+				actual = append(actual, v["x"].(uint8))
+				return testCase.Fail
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = decoder.Run()
+			if err != testCase.Fail {
+				t.Fatalf("expected Run to return %v, but got %v", testCase.Fail, err)
+			}
+
+			if !reflect.DeepEqual(testCase.Expected, actual) {
+				t.Fatalf("expected messages to be %v, but got %v", testCase.Expected, actual)
+			}
+		})
+	}
+}
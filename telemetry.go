@@ -0,0 +1,140 @@
+package rosbag
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+)
+
+const (
+	instrumentationName = "github.com/lherman-cs/go-rosbag"
+)
+
+// telemetry holds the OpenTelemetry state of a Decoder. A nil telemetry is valid, and all of
+// its methods are no-op so that the decoder doesn't pay anything when instrumentation is disabled.
+type telemetry struct {
+	ctx       context.Context
+	tracer    trace.Tracer
+	bagCtx    context.Context
+	bagSpan   trace.Span
+	chunkSpan trace.Span
+	chunks    metric.Int64Counter
+	messages  metric.Int64Counter
+	bytes     metric.Int64Counter
+}
+
+func newTelemetry(config *decoderConfig) *telemetry {
+	if config.tracerProvider == nil && config.meterProvider == nil {
+		return nil
+	}
+
+	tp := config.tracerProvider
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+
+	mp := config.meterProvider
+	if mp == nil {
+		mp = metric.NoopMeterProvider{}
+	}
+
+	meter := metric.Must(mp.Meter(instrumentationName))
+	return &telemetry{
+		ctx:    config.ctx,
+		tracer: tp.Tracer(instrumentationName),
+		chunks: meter.NewInt64Counter("rosbag.chunks",
+			metric.WithDescription("Number of chunk records read")),
+		messages: meter.NewInt64Counter("rosbag.messages",
+			metric.WithDescription("Number of message data records read")),
+		bytes: meter.NewInt64Counter("rosbag.bytes",
+			metric.WithDescription("Number of raw record bytes read from the underlying reader"),
+			metric.WithUnit(unit.Bytes)),
+	}
+}
+
+func (t *telemetry) startBag(version *Version) {
+	if t == nil {
+		return
+	}
+
+	t.bagCtx, t.bagSpan = t.tracer.Start(t.ctx, "rosbag.Bag",
+		trace.WithAttributes(label.String("rosbag.version", version.String())))
+}
+
+func (t *telemetry) startChunk(record *RecordChunk) {
+	if t == nil {
+		return
+	}
+
+	attrs := []label.KeyValue{
+		label.Int64("rosbag.chunk.compressed_size", int64(record.DataLen)),
+	}
+
+	if compression, err := record.Compression(); err == nil {
+		attrs = append(attrs, label.String("rosbag.chunk.compression", string(compression)))
+	}
+
+	if size, err := record.Size(); err == nil {
+		attrs = append(attrs, label.Int64("rosbag.chunk.size", int64(size)))
+	}
+
+	_, t.chunkSpan = t.tracer.Start(t.bagCtx, "rosbag.Chunk", trace.WithAttributes(attrs...))
+	t.chunks.Add(t.bagCtx, 1)
+}
+
+// read accounts a record that has been successfully decoded. topLevel marks that the record is
+// read directly from the underlying reader instead of from a chunk.
+func (t *telemetry) read(record Record, topLevel bool) {
+	if t == nil {
+		return
+	}
+
+	if topLevel {
+		size := 2*lenInBytes + len(record.Header())
+		if chunk, ok := record.(*RecordChunk); ok {
+			size += int(chunk.DataLen)
+		} else {
+			size += len(record.Data())
+		}
+		t.bytes.Add(t.bagCtx, int64(size))
+	}
+
+	if msg, ok := record.(*RecordMessageData); ok {
+		t.messages.Add(t.bagCtx, 1, label.String("rosbag.topic", msg.ConnectionHeader().Topic))
+	}
+}
+
+func (t *telemetry) endChunk(err error) {
+	if t == nil || t.chunkSpan == nil {
+		return
+	}
+
+	endSpan(t.chunkSpan, err)
+	t.chunkSpan = nil
+}
+
+// end ends all of the open spans. err is recorded to the spans unless it's io.EOF.
+func (t *telemetry) end(err error) {
+	if t == nil {
+		return
+	}
+
+	t.endChunk(err)
+	if t.bagSpan != nil {
+		endSpan(t.bagSpan, err)
+		t.bagSpan = nil
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
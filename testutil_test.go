@@ -0,0 +1,145 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRecord is a record of a bag that's written by writeTestBag. It's a message on the Connth
+// connection of the bag, or a record of Op with Fields and Data when Op is set, see
+// Writer.WriteRecord.
+type testRecord struct {
+	Conn   uint32
+	Time   time.Time
+	Data   []byte
+	Op     Op
+	Fields map[string][]byte
+}
+
+// testBagOption configures a bag that's written by writeTestBag.
+type testBagOption func(*testBagConfig)
+
+type testBagConfig struct {
+	conns      []*ConnectionHeader
+	chunks     [][]testRecord
+	writerOpts []WriterOption
+	indexed    bool
+}
+
+// withTestConns replaces the connections of the bag, which is a uint8 x on /test by default. The
+// connections are registered in order, so their IDs are their indexes.
+func withTestConns(conns ...*ConnectionHeader) testBagOption {
+	return func(config *testBagConfig) {
+		config.conns = conns
+	}
+}
+
+// withTestRecords writes records in order, and then ends the chunk, so the records of the next
+// withTestRecords start a new chunk.
+func withTestRecords(records ...testRecord) testBagOption {
+	return func(config *testBagConfig) {
+		config.chunks = append(config.chunks, records)
+	}
+}
+
+// withTestValues writes a message of every value on the first connection, the ith value at i+1
+// seconds.
+func withTestValues(values ...uint8) testBagOption {
+	records := make([]testRecord, len(values))
+	for i, v := range values {
+		records[i] = testRecord{Time: time.Unix(int64(i+1), 0), Data: []byte{v}}
+	}
+	return withTestRecords(records...)
+}
+
+// withTestWriter writes the bag with opts, e.g. WithChunkSize(1) writes every message to its own
+// chunk.
+func withTestWriter(opts ...WriterOption) testBagOption {
+	return func(config *testBagConfig) {
+		config.writerOpts = append(config.writerOpts, opts...)
+	}
+}
+
+// withTestIndexed writes the bag to a file, so its bag header is rewritten to point to its index
+// section like rosbag record does. Otherwise, the bag is written to a buffer, and it's read as a
+// bag without an index section.
+func withTestIndexed() testBagOption {
+	return func(config *testBagConfig) {
+		config.indexed = true
+	}
+}
+
+// writeTestBag writes a bag with Writer, and returns it.
+func writeTestBag(t *testing.T, opts ...testBagOption) []byte {
+	config := testBagConfig{
+		conns: []*ConnectionHeader{{
+			Topic:                "/test",
+			Type:                 "test_msgs/Test",
+			RawMessageDefinition: "uint8 x",
+		}},
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	path := filepath.Join(t.TempDir(), "test.bag")
+	if config.indexed {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var err error
+	writer := NewWriter(w, config.writerOpts...)
+	for _, hdr := range config.conns {
+		_, err = writer.WriteConnection(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, records := range config.chunks {
+		for _, record := range records {
+			if record.Op != OpInvalid {
+				err = writer.WriteRecord(record.Op, record.Fields, record.Data)
+			} else {
+				err = writer.WriteMessage(record.Conn, record.Time, record.Data)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = writer.flushChunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.indexed {
+		return buf.Bytes()
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,217 @@
+package tfrecord
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+var (
+	errNoMappings = errors.New("at least one feature mapping is required")
+)
+
+// Mapping maps the values of the messages of a topic to a feature.
+type Mapping struct {
+	// Feature is the name of the feature in the examples
+	Feature string
+	Topic   string
+	// Expr extracts the values from the messages, e.g. ".data" or ".pose.position.x". Every output
+	// of the expression is appended to the feature
+	Expr *rosbag.Expr
+}
+
+// Export writes an example to w for every message of the trigger topic, e.g. the topic of the
+// images. The features of the trigger topic are extracted from the message itself, and the
+// features of the other topics from their latest message before it, e.g. the labels. Messages of
+// the trigger topic that arrive before every other topic has a message are skipped. Export
+// returns the number of examples that have been written.
+//
+// The values are converted to the feature lists by their types. uint8 arrays and strings are
+// appended to the bytes list, floats to the float list, and integers, bools, times, and durations
+// to the int64 list, where times and durations are in nanoseconds.
+func Export(w io.Writer, decoder *rosbag.Decoder, trigger string, mappings []Mapping) (int, error) {
+	if len(mappings) == 0 {
+		return 0, errNoMappings
+	}
+
+	topics := map[string]bool{trigger: true}
+	for _, mapping := range mappings {
+		topics[mapping.Topic] = true
+	}
+
+	writer := NewWriter(w)
+	latest := make([]*Feature, len(mappings))
+	var examples int
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return examples, nil
+		}
+
+		if err != nil {
+			return examples, err
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok || !topics[msg.ConnectionHeader().Topic] {
+			record.Close()
+			continue
+		}
+
+		err = updateFeatures(msg, mappings, latest)
+		topic := msg.ConnectionHeader().Topic
+		msg.Close()
+		if err != nil {
+			return examples, err
+		}
+
+		if topic != trigger {
+			continue
+		}
+
+		features := make(map[string]Feature, len(mappings))
+		for i, mapping := range mappings {
+			if latest[i] == nil {
+				features = nil
+				break
+			}
+			features[mapping.Feature] = *latest[i]
+		}
+
+		if features == nil {
+			continue
+		}
+
+		err = writer.Write(MarshalExample(features))
+		if err != nil {
+			return examples, err
+		}
+		examples++
+	}
+}
+
+// updateFeatures extracts the features of the mappings of the topic of msg into latest.
+func updateFeatures(msg *rosbag.RecordMessageData, mappings []Mapping, latest []*Feature) error {
+	var data map[string]interface{}
+	for i, mapping := range mappings {
+		if mapping.Topic != msg.ConnectionHeader().Topic {
+			continue
+		}
+
+		if data == nil {
+			data = make(map[string]interface{})
+			err := msg.ViewAs(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		outputs, err := mapping.Expr.Eval(data)
+		if err != nil {
+			return err
+		}
+
+		feature, err := newFeature(outputs)
+		if err != nil {
+			return fmt.Errorf("feature %s: %w", mapping.Feature, err)
+		}
+		latest[i] = feature
+	}
+	return nil
+}
+
+// newFeature converts the outputs of an expression to a feature. The values are copied, since
+// they share the memory of the record.
+func newFeature(outputs []interface{}) (*Feature, error) {
+	var feature Feature
+	for _, output := range outputs {
+		switch v := output.(type) {
+		case []uint8:
+			feature.Bytes = append(feature.Bytes, append([]byte{}, v...))
+			continue
+		case string:
+			feature.Bytes = append(feature.Bytes, []byte(v))
+			continue
+		}
+
+		value := reflect.ValueOf(output)
+		if value.Kind() != reflect.Slice {
+			err := feature.append(output)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for i := 0; i < value.Len(); i++ {
+			err := feature.append(value.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// a list must be set even when there are no values, so the feature keeps its type
+	kinds := 0
+	for _, set := range []bool{feature.Bytes != nil, feature.Floats != nil, feature.Int64s != nil} {
+		if set {
+			kinds++
+		}
+	}
+
+	if kinds > 1 {
+		return nil, fmt.Errorf("the values have mixed types, but a feature can only have a single type")
+	}
+
+	if kinds == 0 {
+		feature.Int64s = []int64{}
+	}
+	return &feature, nil
+}
+
+// append appends a scalar value to the list of its type.
+func (feature *Feature) append(v interface{}) error {
+	switch v := v.(type) {
+	case float32:
+		feature.Floats = append(feature.Floats, v)
+	case float64:
+		feature.Floats = append(feature.Floats, float32(v))
+	case bool:
+		var i int64
+		if v {
+			i = 1
+		}
+		feature.Int64s = append(feature.Int64s, i)
+	case int8:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case uint8:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case int16:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case uint16:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case int32:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case uint32:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case int64:
+		feature.Int64s = append(feature.Int64s, v)
+	case uint64:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case int:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case time.Time:
+		feature.Int64s = append(feature.Int64s, v.UnixNano())
+	case time.Duration:
+		feature.Int64s = append(feature.Int64s, int64(v))
+	case string:
+		feature.Bytes = append(feature.Bytes, []byte(v))
+	default:
+		return fmt.Errorf("a %T can't be converted to a feature, select its fields with the expression", v)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package tfrecord exports messages of bags as TFRecord files of tf.train.Example protos, so that
+// bags can be read by TensorFlow input pipelines, e.g. tf.data.TFRecordDataset.
+package tfrecord
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+// The field numbers of the tf.train.Example protos.
+const (
+	exampleFeatures  = 1
+	featuresFeature  = 1
+	mapEntryKey      = 1
+	mapEntryValue    = 2
+	featureBytesList = 1
+	featureFloatList = 2
+	featureInt64List = 3
+	listValue        = 1
+)
+
+// wireLen is the wire type of length-delimited protobuf fields.
+const wireLen = 2
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC is the checksum of TFRecord, which is masked since CRCs of data that contains CRCs
+// are weak.
+func maskedCRC(b []byte) uint32 {
+	crc := crc32.Checksum(b, crc32c)
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// Writer writes records in the TFRecord format.
+type Writer struct {
+	writer io.Writer
+	buf    []byte
+}
+
+// NewWriter creates a writer that writes records to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w}
+}
+
+// Write writes data as a single record.
+func (writer *Writer) Write(data []byte) error {
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:], uint64(len(data)))
+	binary.LittleEndian.PutUint32(header[8:], maskedCRC(header[:8]))
+
+	writer.buf = append(writer.buf[:0], header[:]...)
+	writer.buf = append(writer.buf, data...)
+	writer.buf = appendUint32(writer.buf, maskedCRC(data))
+	_, err := writer.writer.Write(writer.buf)
+	return err
+}
+
+// Feature is a feature of a tf.train.Example. Only one of the lists is set.
+type Feature struct {
+	Bytes  [][]byte
+	Floats []float32
+	Int64s []int64
+}
+
+// MarshalExample serializes a tf.train.Example with features. The features are sorted by name, so
+// the same features are always serialized to the same bytes.
+func MarshalExample(features map[string]Feature) []byte {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var featuresMsg []byte
+	for _, name := range names {
+		var entry []byte
+		entry = appendLen(entry, mapEntryKey, []byte(name))
+		entry = appendLen(entry, mapEntryValue, marshalFeature(features[name]))
+		featuresMsg = appendLen(featuresMsg, featuresFeature, entry)
+	}
+
+	return appendLen(nil, exampleFeatures, featuresMsg)
+}
+
+func marshalFeature(feature Feature) []byte {
+	var list []byte
+	switch {
+	case feature.Bytes != nil:
+		for _, b := range feature.Bytes {
+			list = appendLen(list, listValue, b)
+		}
+		return appendLen(nil, featureBytesList, list)
+	case feature.Floats != nil:
+		// the values are packed
+		packed := make([]byte, 0, 4*len(feature.Floats))
+		for _, f := range feature.Floats {
+			packed = appendUint32(packed, math.Float32bits(f))
+		}
+		list = appendLen(list, listValue, packed)
+		return appendLen(nil, featureFloatList, list)
+	default:
+		var packed []byte
+		for _, v := range feature.Int64s {
+			packed = appendUvarint(packed, uint64(v))
+		}
+		list = appendLen(list, listValue, packed)
+		return appendLen(nil, featureInt64List, list)
+	}
+}
+
+// appendLen appends a length-delimited field.
+func appendLen(b []byte, field int, value []byte) []byte {
+	b = appendUvarint(b, uint64(field<<3|wireLen))
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
@@ -0,0 +1,167 @@
+package tfrecord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+)
+
+// readRecords reads the records of a TFRecord file, and checks their CRCs.
+func readRecords(t *testing.T, b []byte) [][]byte {
+	var records [][]byte
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("expected a record header, but got %d bytes", len(b))
+		}
+
+		n := binary.LittleEndian.Uint64(b)
+		if crc := binary.LittleEndian.Uint32(b[8:]); crc != maskedCRC(b[:8]) {
+			t.Fatalf("length CRC is %x, but expected %x", crc, maskedCRC(b[:8]))
+		}
+		b = b[12:]
+
+		data := b[:n]
+		if crc := binary.LittleEndian.Uint32(b[n:]); crc != maskedCRC(data) {
+			t.Fatalf("data CRC is %x, but expected %x", crc, maskedCRC(data))
+		}
+		records = append(records, data)
+		b = b[n+4:]
+	}
+	return records
+}
+
+func TestMarshalExample(t *testing.T) {
+	actual := MarshalExample(map[string]Feature{"a": {Int64s: []int64{1}}})
+	expected := []byte{
+		0x0a, 0x0c, // features
+		0x0a, 0x0a, // feature map entry
+		0x0a, 0x01, 'a', // key
+		0x12, 0x05, // value
+		0x1a, 0x03, // int64 list
+		0x0a, 0x01, 0x01, // packed values
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("example is not matched:\n\n%s", diff)
+	}
+
+	actual = MarshalExample(map[string]Feature{
+		"b": {Floats: []float32{1}},
+		"a": {Bytes: [][]byte{[]byte("x"), {}}},
+	})
+	expected = []byte{
+		0x0a, 0x1d,
+		0x0a, 0x0c, 0x0a, 0x01, 'a', 0x12, 0x07, 0x0a, 0x05, 0x0a, 0x01, 'x', 0x0a, 0x00,
+		0x0a, 0x0d, 0x0a, 0x01, 'b', 0x12, 0x08, 0x12, 0x06, 0x0a, 0x04, 0x00, 0x00, 0x80, 0x3f,
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("example is not matched:\n\n%s", diff)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for _, data := range []string{"hello", ""} {
+		err := writer.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := readRecords(t, buf.Bytes())
+	if diff := cmp.Diff([][]byte{[]byte("hello"), {}}, records); diff != "" {
+		t.Fatalf("records are not matched:\n\n%s", diff)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var buf bytes.Buffer
+	writer := rosbag.NewWriter(&buf)
+	image, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+		Topic:                "/image",
+		Type:                 "test_msgs/Image",
+		RawMessageDefinition: "uint32 width\nuint8[] data\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	label, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+		Topic:                "/label",
+		Type:                 "std_msgs/Float64",
+		RawMessageDefinition: "float64 data\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeImage := func(width uint32, data ...byte) {
+		raw := []byte{byte(width), 0, 0, 0, byte(len(data)), 0, 0, 0}
+		err := writer.WriteMessage(image, time.Unix(1, 0), append(raw, data...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeLabel := func(v float64) {
+		raw := make([]byte, 8)
+		binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+		err := writer.WriteMessage(label, time.Unix(1, 0), raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first image doesn't have a label yet
+	writeImage(1, 9)
+	writeLabel(0.5)
+	writeImage(2, 1, 2)
+	writeLabel(1.5)
+	writeImage(3, 3, 4, 5)
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	examples, err := Export(&out, rosbag.NewDecoder(bytes.NewReader(buf.Bytes())), "/image", []Mapping{
+		{Feature: "image", Topic: "/image", Expr: rosbag.MustCompileExpr(".data")},
+		{Feature: "width", Topic: "/image", Expr: rosbag.MustCompileExpr(".width")},
+		{Feature: "label", Topic: "/label", Expr: rosbag.MustCompileExpr(".data")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if examples != 2 {
+		t.Fatalf("expected 2 examples, but got %d", examples)
+	}
+
+	expected := [][]byte{
+		MarshalExample(map[string]Feature{
+			"image": {Bytes: [][]byte{{1, 2}}},
+			"width": {Int64s: []int64{2}},
+			"label": {Floats: []float32{0.5}},
+		}),
+		MarshalExample(map[string]Feature{
+			"image": {Bytes: [][]byte{{3, 4, 5}}},
+			"width": {Int64s: []int64{3}},
+			"label": {Floats: []float32{1.5}},
+		}),
+	}
+	if diff := cmp.Diff(expected, readRecords(t, out.Bytes())); diff != "" {
+		t.Fatalf("examples are not matched:\n\n%s", diff)
+	}
+
+	_, err = Export(&out, rosbag.NewDecoder(bytes.NewReader(buf.Bytes())), "/image", []Mapping{
+		{Feature: "image", Topic: "/image", Expr: rosbag.MustCompileExpr(".")},
+	})
+	if err == nil {
+		t.Fatal("expected a message that is not selected down to its fields to fail")
+	}
+}
@@ -18,3 +18,11 @@ func extractDuration(raw []byte) time.Duration {
 	nsec := endian.Uint32(raw[4:])
 	return time.Duration(sec)*time.Second + time.Duration(nsec)*time.Nanosecond
 }
+
+// timeField is the inverse of extractTime. It encodes t to 8 bytes of sec and nsec.
+func timeField(t time.Time) []byte {
+	raw := make([]byte, 8)
+	endian.PutUint32(raw, uint32(t.Unix()))
+	endian.PutUint32(raw[4:], uint32(t.Nanosecond()))
+	return raw
+}
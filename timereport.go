@@ -0,0 +1,179 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// TimeRegressionKind tells which timestamp of a message went backwards.
+type TimeRegressionKind uint8
+
+const (
+	// TimeRegressionRecordTime is a regression of the record time of message data records.
+	TimeRegressionRecordTime TimeRegressionKind = iota
+	// TimeRegressionHeaderStamp is a regression of the std_msgs/Header stamp of messages.
+	TimeRegressionHeaderStamp
+)
+
+func (kind TimeRegressionKind) String() string {
+	switch kind {
+	case TimeRegressionRecordTime:
+		return "record time"
+	case TimeRegressionHeaderStamp:
+		return "header stamp"
+	default:
+		return fmt.Sprintf("TimeRegressionKind(%d)", uint8(kind))
+	}
+}
+
+// TimeRegression is a message whose timestamp is earlier than the latest timestamp before it.
+// Previous is that latest timestamp, so a single jump forward followed by in-order messages shows
+// up as a regression for every message until the timestamps catch up.
+type TimeRegression struct {
+	Kind  TimeRegressionKind
+	Topic string
+	// Index is the position of the message among all messages in the bag, starting from 0
+	Index    uint64
+	Previous time.Time
+	Time     time.Time
+}
+
+// Magnitude returns how far the timestamp went backwards.
+func (regression *TimeRegression) Magnitude() time.Duration {
+	return regression.Previous.Sub(regression.Time)
+}
+
+func (regression *TimeRegression) String() string {
+	return fmt.Sprintf("message %d on %s: %s went back by %s", regression.Index, regression.Topic,
+		regression.Kind, regression.Magnitude())
+}
+
+// TopicTimeReport contains the time regressions of a single topic.
+type TopicTimeReport struct {
+	Messages    uint64
+	RecordTime  []TimeRegression
+	HeaderStamp []TimeRegression
+	// lastTime and lastStamp are the timestamps of the previous message on the topic
+	lastTime  time.Time
+	lastStamp time.Time
+}
+
+// TimeReport describes where the timestamps of a bag go backwards.
+type TimeReport struct {
+	Messages uint64
+	// Global contains the record time regressions across all topics
+	Global []TimeRegression
+	Topics map[string]*TopicTimeReport
+	last   time.Time
+}
+
+// MaxRegression returns the regression with the largest magnitude in regressions, or nil when
+// regressions is empty.
+func MaxRegression(regressions []TimeRegression) *TimeRegression {
+	var max *TimeRegression
+	for i := range regressions {
+		if max == nil || regressions[i].Magnitude() > max.Magnitude() {
+			max = &regressions[i]
+		}
+	}
+	return max
+}
+
+// AnalyzeTime reads the rest of the bag from decoder, and reports where the record times and the
+// header stamps go backwards, both per topic and globally. Header stamps are only checked for
+// messages whose first field is a std_msgs/Header, and zero stamps are ignored.
+func AnalyzeTime(decoder *Decoder) (*TimeReport, error) {
+	report := TimeReport{
+		Topics: make(map[string]*TopicTimeReport),
+	}
+	hasHeader := make(map[*ConnectionHeader]bool)
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return &report, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			err = report.add(msg, hasHeader)
+		}
+		record.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (report *TimeReport) add(msg *RecordMessageData, hasHeader map[*ConnectionHeader]bool) error {
+	t, err := msg.Time()
+	if err != nil {
+		return err
+	}
+
+	hdr := msg.ConnectionHeader()
+	topicReport, ok := report.Topics[hdr.Topic]
+	if !ok {
+		topicReport = &TopicTimeReport{}
+		report.Topics[hdr.Topic] = topicReport
+	}
+
+	index := report.Messages
+	report.Messages++
+	topicReport.Messages++
+
+	regression := TimeRegression{
+		Kind:  TimeRegressionRecordTime,
+		Topic: hdr.Topic,
+		Index: index,
+		Time:  t,
+	}
+
+	if t.Before(report.last) {
+		regression.Previous = report.last
+		report.Global = append(report.Global, regression)
+	} else {
+		report.last = t
+	}
+
+	if t.Before(topicReport.lastTime) {
+		regression.Previous = topicReport.lastTime
+		topicReport.RecordTime = append(topicReport.RecordTime, regression)
+	} else {
+		topicReport.lastTime = t
+	}
+
+	headerFirst, ok := hasHeader[hdr]
+	if !ok {
+		headerFirst = startsWithHeader(&hdr.MessageDefinition)
+		hasHeader[hdr] = headerFirst
+	}
+
+	if !headerFirst {
+		return nil
+	}
+
+	stamp := headerStamp(msg.Data(), time.Time{})
+	if stamp.IsZero() {
+		return nil
+	}
+
+	if stamp.Before(topicReport.lastStamp) {
+		topicReport.HeaderStamp = append(topicReport.HeaderStamp, TimeRegression{
+			Kind:     TimeRegressionHeaderStamp,
+			Topic:    hdr.Topic,
+			Index:    index,
+			Previous: topicReport.lastStamp,
+			Time:     stamp,
+		})
+	} else {
+		topicReport.lastStamp = stamp
+	}
+
+	return nil
+}
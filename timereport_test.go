@@ -0,0 +1,89 @@
+package rosbag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeTime(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	stamped, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/stamped",
+		Type:                 "test_msgs/Stamped",
+		RawMessageDefinition: testHeaderMessageDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/plain",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []struct {
+		Conn  uint32
+		Time  int64
+		Stamp int64
+	}{
+		{stamped, 10, 5},
+		{plain, 12, 0},
+		// globally backwards by 1s, but in order on /stamped. The stamp goes back by 2s.
+		{stamped, 11, 3},
+		{plain, 13, 0},
+		{plain, 12, 0},
+	}
+	for _, write := range writes {
+		data := []byte{0}
+		if write.Conn == stamped {
+			data = encodeTestHeaderMessage(time.Unix(write.Stamp, 0), 0)
+		}
+
+		err = writer.WriteMessage(write.Conn, time.Unix(write.Time, 0), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := AnalyzeTime(NewDecoder(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Messages != uint64(len(writes)) {
+		t.Fatalf("expected %d messages, but got %d", len(writes), report.Messages)
+	}
+
+	if len(report.Global) != 2 || report.Global[0].Index != 2 || report.Global[1].Index != 4 {
+		t.Fatalf("unexpected global regressions: %v", report.Global)
+	}
+
+	if max := MaxRegression(report.Global); max.Magnitude() != time.Second || max.Topic != "/stamped" {
+		t.Fatalf("unexpected max global regression: %v", max)
+	}
+
+	stampedReport := report.Topics["/stamped"]
+	if len(stampedReport.RecordTime) != 0 {
+		t.Fatalf("expected no record time regressions on /stamped, but got %v", stampedReport.RecordTime)
+	}
+
+	if len(stampedReport.HeaderStamp) != 1 || stampedReport.HeaderStamp[0].Magnitude() != 2*time.Second {
+		t.Fatalf("unexpected header stamp regressions on /stamped: %v", stampedReport.HeaderStamp)
+	}
+
+	plainReport := report.Topics["/plain"]
+	if len(plainReport.RecordTime) != 1 || plainReport.RecordTime[0].Index != 4 {
+		t.Fatalf("unexpected record time regressions on /plain: %v", plainReport.RecordTime)
+	}
+}
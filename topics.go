@@ -0,0 +1,131 @@
+package rosbag
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// TopicStats summarizes the messages of a topic for scripting, e.g. `rosbag topics`.
+type TopicStats struct {
+	Topic    string `json:"topic"`
+	Type     string `json:"type"`
+	MD5Sum   string `json:"md5sum"`
+	Messages uint64 `json:"messages"`
+	// Frequency is the average number of messages per second. It's 0 when the topic has less than
+	// 2 messages.
+	Frequency float64 `json:"frequency"`
+}
+
+type topicStatsBuilder struct {
+	stats      map[string]*TopicStats
+	start, end map[string]time.Time
+}
+
+func (builder *topicStatsBuilder) add(hdr *ConnectionHeader, count uint64, start, end time.Time) {
+	stats, ok := builder.stats[hdr.Topic]
+	if !ok {
+		stats = &TopicStats{Topic: hdr.Topic, Type: hdr.Type, MD5Sum: hdr.MD5Sum}
+		builder.stats[hdr.Topic] = stats
+		builder.start[hdr.Topic] = start
+		builder.end[hdr.Topic] = end
+	}
+	stats.Messages += count
+
+	if start.Before(builder.start[hdr.Topic]) {
+		builder.start[hdr.Topic] = start
+	}
+	if end.After(builder.end[hdr.Topic]) {
+		builder.end[hdr.Topic] = end
+	}
+}
+
+func (builder *topicStatsBuilder) build() []TopicStats {
+	topics := make([]TopicStats, 0, len(builder.stats))
+	for name, stats := range builder.stats {
+		duration := builder.end[name].Sub(builder.start[name]).Seconds()
+		if stats.Messages > 1 && duration > 0 {
+			stats.Frequency = float64(stats.Messages-1) / duration
+		}
+		topics = append(topics, *stats)
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Topic < topics[j].Topic
+	})
+	return topics
+}
+
+// ReadTopicStats summarizes the topics of the bag sorted by name. The decoder must not have been
+// read before. When the reader of the decoder is seekable, and the bag has an index section, only
+// the index section is read. In that case, the message times are only known per chunk, so the
+// frequency is estimated from the times of the chunks that contain the messages of the topic.
+// Otherwise, the whole bag is read.
+func ReadTopicStats(decoder *Decoder) ([]TopicStats, error) {
+	builder := topicStatsBuilder{
+		stats: make(map[string]*TopicStats),
+		start: make(map[string]time.Time),
+		end:   make(map[string]time.Time),
+	}
+
+	err := decoder.Preload()
+	if err == nil && len(decoder.chunkInfos) > 0 {
+		err = builder.addChunkInfos(decoder)
+		if err != nil {
+			return nil, err
+		}
+		return builder.build(), nil
+	}
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return builder.build(), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if record, ok := record.(*RecordMessageData); ok {
+			var t time.Time
+			t, err = record.Time()
+			if err == nil {
+				builder.add(record.ConnectionHeader(), 1, t, t)
+			}
+		}
+		record.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (builder *topicStatsBuilder) addChunkInfos(decoder *Decoder) error {
+	for _, chunkInfo := range decoder.chunkInfos {
+		start, err := chunkInfo.StartTime()
+		if err != nil {
+			return err
+		}
+
+		end, err := chunkInfo.EndTime()
+		if err != nil {
+			return err
+		}
+
+		counts, err := chunkInfo.MessageCounts()
+		if err != nil {
+			return err
+		}
+
+		for conn, count := range counts {
+			hdr, ok := decoder.conns[conn]
+			if !ok {
+				return errNotFoundConnectionHeader
+			}
+			builder.add(hdr, uint64(count), start, end)
+		}
+	}
+	return nil
+}
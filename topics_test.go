@@ -0,0 +1,45 @@
+package rosbag
+
+import (
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReadTopicStats(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	// hide Seek so that the whole bag is read
+	scanned, err := ReadTopicStats(NewDecoder(struct{ io.Reader }{f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scanned) == 0 {
+		t.Fatal("expected the example bag to have topics")
+	}
+
+	for _, stats := range scanned {
+		if stats.Messages > 1 && stats.Frequency <= 0 {
+			t.Fatalf("expected %s to have a frequency, but got %v", stats.Topic, stats.Frequency)
+		}
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexed, err := ReadTopicStats(NewDecoder(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the frequency from the index is an estimate
+	if diff := cmp.Diff(scanned, indexed, cmpopts.IgnoreFields(TopicStats{}, "Frequency")); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,398 @@
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4/v4"
+)
+
+var (
+	errCompressionNotWritable = errors.New("chunks can only be compressed with none, lz4, bz2, snappy, or a registered codec")
+	errMissingBagHeader       = errors.New("bag doesn't have a bag header")
+)
+
+// TranscodeOption configures an optional behavior of Transcode.
+type TranscodeOption func(*transcodeConfig)
+
+type transcodeConfig struct {
+	progress func(read int64)
+}
+
+// WithTranscodeProgress calls progress with the number of bytes that have been read from the
+// source bag after every record. It's meant for progress reporting, so it must be fast.
+func WithTranscodeProgress(progress func(read int64)) TranscodeOption {
+	return func(config *transcodeConfig) {
+		config.progress = progress
+	}
+}
+
+// newChunkWriter returns a writer that compresses the chunk data that's written to it with
+// compression. The data is only complete after the writer is closed.
+func newChunkWriter(compression Compression, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionLZ4:
+		return lz4.NewWriter(w), nil
+	case CompressionBZ2:
+		return newBZ2Writer(w), nil
+	case CompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	}
+
+	if codec, ok := registeredCodec(compression); ok {
+		return codec.NewWriter(w)
+	}
+	return nil, errCompressionNotWritable
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressData returns data compressed with compression.
+func compressData(compression Compression, data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	cw, err := newChunkWriter(compression, &compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// compressChunk returns the record of a chunk with data compressed with compression.
+func compressChunk(compression Compression, data []byte) ([]byte, error) {
+	compressed, err := compressData(compression, data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := appendHeaderField(nil, "op", []byte{byte(OpChunk)})
+	header = appendHeaderField(header, "compression", []byte(compression))
+	header = appendHeaderField(header, "size", uint32Field(uint32(len(data))))
+	return appendRecord(nil, header, compressed), nil
+}
+
+// replaceHeaderField returns a copy of header with the value of key replaced by value.
+func replaceHeaderField(header []byte, key string, value []byte) ([]byte, error) {
+	var replaced []byte
+	err := iterateHeaderFields(header, func(k, v []byte) bool {
+		if string(k) == key {
+			v = value
+		}
+		replaced = appendHeaderField(replaced, string(k), v)
+		return true
+	})
+	return replaced, err
+}
+
+// Transcode copies the bag from r to w, and recompresses every chunk with compression, like
+// `rosbag compress` and `rosbag decompress`. The records are streamed, so only one chunk is held
+// in memory at a time, and the message data is copied without being decoded. The index is
+// regenerated from the chunks, so bags without an index section, e.g. the bags of an interrupted
+// recording, are indexed too. The bag header is rewritten at the end with the position of the new
+// index section, which is why w must be seekable, and its extra fields, e.g. the fields of the
+// encryptor, are kept. Chunks can be compressed with CompressionNone, CompressionLZ4,
+// CompressionBZ2, CompressionSnappy, or a compression that is registered with
+// RegisterCompression, e.g. CompressionDelta.
+func Transcode(w io.WriteSeeker, r io.Reader, compression Compression, opts ...TranscodeOption) error {
+	if _, err := newChunkWriter(compression, nil); err != nil {
+		return err
+	}
+
+	transcoder := newBagTranscoder(w, opts)
+	transcoder.compression = compression
+	return transcoder.run(r)
+}
+
+// bagTranscoder writes the records of Transcode and Reindex, and keeps the index section of the
+// output bag.
+type bagTranscoder struct {
+	config transcodeConfig
+	w      io.WriteSeeker
+	// compression is the compression of the written chunks, the chunks are copied as they are
+	// when it's empty
+	compression Compression
+	// salvage stops at the first record that can't be read, instead of failing, and keeps the
+	// records before it
+	salvage    bool
+	truncation *TruncatedError
+	offset     int64
+	// headerFields are the extra fields of the source bag header, e.g. the fields of the encryptor
+	headerFields map[string][]byte
+	// conns are the first connection records of every connection
+	conns      map[uint32][]byte
+	chunkInfos [][]byte
+	messages   int
+}
+
+func newBagTranscoder(w io.WriteSeeker, opts []TranscodeOption) *bagTranscoder {
+	transcoder := bagTranscoder{
+		w:     w,
+		conns: make(map[uint32][]byte),
+	}
+	for _, opt := range opts {
+		opt(&transcoder.config)
+	}
+	return &transcoder
+}
+
+func (transcoder *bagTranscoder) run(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var version Version
+	_, err := fmt.Fscanf(br, versionFormat, &version.Major, &version.Minor)
+	if err != nil {
+		return err
+	}
+
+	if version != supportedVersion {
+		return &UnsupportedVersionError{Version: version}
+	}
+
+	versionLine := fmt.Sprintf(versionFormat, version.Major, version.Minor)
+	err = transcoder.write([]byte(versionLine))
+	if err != nil {
+		return err
+	}
+
+	srcOffset := transcoder.offset
+	var bagHeaderOffset int64 = -1
+	var lastTime time.Time
+	for {
+		record, op, err := readRawRecord(br)
+		if err == io.EOF {
+			break
+		}
+
+		var chunkData []byte
+		var index *chunkIndex
+		var chunkConns []*RecordBase
+		if err == nil && op == OpChunk {
+			chunkData, index, chunkConns, err = indexChunk(&RecordChunk{RecordBase: record}, srcOffset)
+		}
+
+		if err != nil && transcoder.salvage && bagHeaderOffset >= 0 {
+			transcoder.truncation = &TruncatedError{Offset: srcOffset, Time: lastTime}
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case OpBagHeader:
+			bagHeaderOffset = transcoder.offset
+			transcoder.headerFields, err = bagHeaderExtraFields(record)
+			if err != nil {
+				break
+			}
+
+			// the bag header is padded, so it can be rewritten with the index_pos at the end
+			var header []byte
+			header, err = encodeBagHeader(0, 0, 0, transcoder.headerFields)
+			if err == nil {
+				err = transcoder.write(header)
+			}
+		case OpChunk:
+			for _, conn := range chunkConns {
+				transcoder.addConnection(conn)
+			}
+
+			if index.end.After(lastTime) {
+				lastTime = index.end
+			}
+			err = transcoder.writeChunk(record, chunkData, index)
+		case OpConnection:
+			// the connection records of the index section are written again at the end
+			var conn uint32
+			conn, err = (&RecordConnection{RecordBase: record}).Conn()
+			if err == nil {
+				transcoder.conns[conn] = transcoder.connRecord(conn, record)
+			}
+		case OpIndexData, OpChunkInfo:
+			// the index is regenerated
+		default:
+			err = transcoder.write(record.Raw)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		srcOffset += int64(len(record.Raw))
+		if transcoder.config.progress != nil {
+			transcoder.config.progress(srcOffset)
+		}
+	}
+
+	if bagHeaderOffset < 0 {
+		return errMissingBagHeader
+	}
+	return transcoder.writeIndex(bagHeaderOffset)
+}
+
+func (transcoder *bagTranscoder) write(records ...[]byte) error {
+	for _, record := range records {
+		n, err := transcoder.w.Write(record)
+		transcoder.offset += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connRecord returns the connection record of conn that's kept for the index section, which is
+// the first one of every connection.
+func (transcoder *bagTranscoder) connRecord(conn uint32, record *RecordBase) []byte {
+	if raw, ok := transcoder.conns[conn]; ok {
+		return raw
+	}
+	return append([]byte(nil), record.Raw...)
+}
+
+// addConnection keeps the connection record of a chunk, its connection has been validated by
+// indexChunk.
+func (transcoder *bagTranscoder) addConnection(record *RecordBase) {
+	conn, _ := (&RecordConnection{RecordBase: record}).Conn()
+	transcoder.conns[conn] = transcoder.connRecord(conn, record)
+}
+
+// indexChunk returns the decompressed data of chunk, the index of its messages, and its
+// connection records. srcPos is the position of chunk in the source bag.
+func indexChunk(chunk *RecordChunk, srcPos int64) ([]byte, *chunkIndex, []*RecordBase, error) {
+	data, err := decompressChunk(chunk)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("chunk at %d: %w", srcPos, err)
+	}
+
+	index := newChunkIndex()
+	var conns []*RecordBase
+	br := bytes.NewReader(data)
+	for {
+		offset := len(data) - br.Len()
+		record, op, err := readRawRecord(br)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("chunk at %d: %w", srcPos, err)
+		}
+
+		switch op {
+		case OpConnection:
+			_, err = (&RecordConnection{RecordBase: record}).Conn()
+			conns = append(conns, record)
+		case OpMessageData:
+			msg := RecordMessageData{RecordBase: record}
+			var conn uint32
+			var t time.Time
+			conn, err = msg.Conn()
+			if err == nil {
+				t, err = msg.Time()
+				index.add(conn, t, offset)
+			}
+		}
+
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("chunk at %d: %w", srcPos, err)
+		}
+	}
+	return data, index, conns, nil
+}
+
+// writeChunk writes the chunk record with data compressed with the compression of the transcoder,
+// or record as it is, followed by the index data records of its messages.
+func (transcoder *bagTranscoder) writeChunk(record *RecordBase, data []byte, index *chunkIndex) error {
+	raw := record.Raw
+	if transcoder.compression != "" {
+		var err error
+		raw, err = compressChunk(transcoder.compression, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	pos := transcoder.offset
+	err := transcoder.write(raw, index.appendIndexData(nil))
+	if err != nil {
+		return err
+	}
+
+	start, end := index.start, index.end
+	if len(index.conns) == 0 {
+		start, end = time.Unix(0, 0), time.Unix(0, 0)
+	}
+
+	for _, count := range index.counts() {
+		transcoder.messages += int(count)
+	}
+	transcoder.chunkInfos = append(transcoder.chunkInfos, encodeChunkInfoRecord(uint64(pos), start, end, index.counts()))
+	return nil
+}
+
+// writeIndex writes the connection records and the chunk infos, and rewrites the bag header at
+// bagHeaderOffset with the position of the index section.
+func (transcoder *bagTranscoder) writeIndex(bagHeaderOffset int64) error {
+	indexPos := transcoder.offset
+	ids := make([]uint32, 0, len(transcoder.conns))
+	for conn := range transcoder.conns {
+		ids = append(ids, conn)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, conn := range ids {
+		err := transcoder.write(transcoder.conns[conn])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := transcoder.write(transcoder.chunkInfos...)
+	if err != nil {
+		return err
+	}
+
+	header, err := encodeBagHeader(uint64(indexPos), uint32(len(ids)), uint32(len(transcoder.chunkInfos)), transcoder.headerFields)
+	if err != nil {
+		return err
+	}
+
+	_, err = transcoder.w.Seek(bagHeaderOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = transcoder.w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = transcoder.w.Seek(0, io.SeekEnd)
+	return err
+}
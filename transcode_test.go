@@ -0,0 +1,216 @@
+package rosbag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	f := openExampleBag(t)
+	defer f.Close()
+
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readAllMessages(t, raw)
+	src := raw
+	// round trip through lz4, bz2, and snappy and back, the output of every step must be a valid bag
+	for _, compression := range []Compression{CompressionLZ4, CompressionBZ2, CompressionSnappy, CompressionNone} {
+		out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		var read int64
+		err = Transcode(out, bytes.NewReader(src), compression, WithTranscodeProgress(func(n int64) {
+			read = n
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read != int64(len(src)) {
+			t.Fatalf("expected the progress to reach %d, but got %d", len(src), read)
+		}
+
+		_, err = out.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src, err = ioutil.ReadAll(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		findings, err := Check(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(findings) != 0 {
+			t.Fatalf("expected the %s bag to be valid, but got %v", compression, findings)
+		}
+
+		decoder := NewDecoder(bytes.NewReader(src))
+		err = decoder.Preload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(decoder.ChunkInfos()) != 1 {
+			t.Fatalf("expected the index to be preloaded, but got %d chunk infos", len(decoder.ChunkInfos()))
+		}
+	}
+
+	compareRecords(t, expected, readAllMessages(t, src))
+}
+
+// readAllMessages returns the header and the data of the message data records in raw.
+func readAllMessages(t *testing.T, raw []byte) [][]byte {
+	var messages [][]byte
+	for _, record := range readAllRecords(t, NewDecoderBytes(raw)) {
+		var op Op
+		err := iterateHeaderFields(record, func(key, value []byte) bool {
+			if string(key) == "op" {
+				op = Op(value[0])
+			}
+			return op == OpInvalid
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if op == OpMessageData {
+			messages = append(messages, record)
+		}
+	}
+	return messages
+}
+
+func TestTranscodeUnindexed(t *testing.T) {
+	indexed := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	raw := stripTestIndex(t, indexed)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = Transcode(out, bytes.NewReader(raw), CompressionLZ4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = out.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcoded, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := Check(bytes.NewReader(transcoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the transcoded bag to be valid, but got %v", findings)
+	}
+
+	decoder := NewDecoder(bytes.NewReader(transcoded))
+	err = decoder.Preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoder.ChunkInfos()) != 3 {
+		t.Fatalf("expected the index to be regenerated, but got %d chunk infos", len(decoder.ChunkInfos()))
+	}
+
+	compareRecords(t, readAllMessages(t, indexed), readAllMessages(t, transcoded))
+}
+
+// nopTestEncryptor is an encryptor that doesn't change the data, so its bags can be read without
+// decrypting them.
+type nopTestEncryptor struct{}
+
+func (nopTestEncryptor) Name() string {
+	return "test/NopEncryptor"
+}
+
+func (nopTestEncryptor) HeaderFields() map[string][]byte {
+	return map[string][]byte{"key_id": []byte("test key")}
+}
+
+func (nopTestEncryptor) EncryptChunk(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (nopTestEncryptor) EncryptHeader(header []byte) ([]byte, error) {
+	return header, nil
+}
+
+func TestTranscodeEncrypted(t *testing.T) {
+	raw := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithEncryptor(nopTestEncryptor{})))
+	transcoded := transcodeBytes(t, raw, CompressionLZ4)
+	compareRecords(t, readAllMessages(t, raw), readAllMessages(t, transcoded))
+
+	record, err := NewDecoderBytes(transcoded).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bagHeader, ok := record.(*RecordBagHeader)
+	if !ok {
+		t.Fatalf("expected a bag header, but got %T", record)
+	}
+
+	// the readers need the fields of the encryptor to decrypt the bag
+	fields := map[string]string{
+		"encryptor": "test/NopEncryptor",
+		"key_id":    "test key",
+	}
+	for key, expected := range fields {
+		value, err := bagHeader.findField([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != expected {
+			t.Fatalf("expected %s to be %q, but got %q", key, expected, value)
+		}
+	}
+
+	indexPos, err := bagHeader.IndexPos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexPos == 0 {
+		t.Fatal("expected the bag header to point to the index section")
+	}
+}
+
+func TestTranscodeUnsupportedCompression(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = Transcode(out, bytes.NewReader(nil), Compression("unknown"))
+	if err != errCompressionNotWritable {
+		t.Fatalf("expected %v, but got %v", errCompressionNotWritable, err)
+	}
+}
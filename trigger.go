@@ -0,0 +1,404 @@
+package rosbag
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TriggerCondition reports whether a message is an interesting moment, e.g. a battery voltage
+// below a threshold. The message is only valid until the condition returns.
+type TriggerCondition func(msg *LazyMessage) (bool, error)
+
+// Threshold returns a condition that compares the numeric field path with value, e.g.
+// Threshold("voltage", "<", 10). op is one of =, !=, <, <=, >, or >=, and the field is converted
+// like FieldPath.Float64.
+func Threshold(path string, op string, value float64) (TriggerCondition, error) {
+	return thresholdCondition(path, op, value, numericValue)
+}
+
+// MagnitudeThreshold returns a condition like Threshold that compares the magnitude of path with
+// value. The magnitude of a nested message or an array is the Euclidean norm of its numeric fields
+// or elements, e.g. MagnitudeThreshold("linear_acceleration", ">", 3*9.81) for an acceleration
+// above 3g, and the magnitude of a number is its absolute value.
+func MagnitudeThreshold(path string, op string, value float64) (TriggerCondition, error) {
+	return thresholdCondition(path, op, value, magnitude)
+}
+
+func thresholdCondition(path string, op string, value float64, convert func(v interface{}) (float64, bool)) (TriggerCondition, error) {
+	p, err := CompileFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !queryOp(op).valid() {
+		return nil, fmt.Errorf("unknown operator %s", op)
+	}
+
+	return func(msg *LazyMessage) (bool, error) {
+		v, err := msg.GetPath(p)
+		if err != nil {
+			return false, err
+		}
+
+		f, ok := convert(v)
+		if !ok {
+			return false, fmt.Errorf("field %s is a %T, which is not numeric", path, v)
+		}
+
+		if math.IsNaN(f) {
+			// NaN only matches !=, like in queries
+			return queryOp(op) == queryOpNotEqual, nil
+		}
+
+		var cmp int
+		switch {
+		case f < value:
+			cmp = -1
+		case f > value:
+			cmp = 1
+		}
+		return queryOp(op).holds(cmp), nil
+	}, nil
+}
+
+// magnitude returns the absolute value of a number, or the Euclidean norm of the numeric values of
+// a nested message or an array. ok is false when a value is not numeric.
+func magnitude(v interface{}) (float64, bool) {
+	if f, ok := numericValue(v); ok {
+		return math.Abs(f), true
+	}
+
+	var sum float64
+	add := func(elem interface{}) bool {
+		f, ok := magnitude(elem)
+		sum += f * f
+		return ok
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, elem := range v {
+			if !add(elem) {
+				return 0, false
+			}
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return 0, false
+		}
+
+		for i := 0; i < rv.Len(); i++ {
+			if !add(rv.Index(i).Interface()) {
+				return 0, false
+			}
+		}
+	}
+	return math.Sqrt(sum), true
+}
+
+// ParseTriggerCondition parses a condition of the form "<path> <op> <number>", or
+// "|<path>| <op> <number>" for the magnitude of the field, e.g. "voltage < 10" or
+// "|linear_acceleration| > 29.43". See Threshold and MagnitudeThreshold.
+func ParseTriggerCondition(condition string) (TriggerCondition, error) {
+	i := strings.IndexAny(condition, "=!<>")
+	if i < 0 {
+		return nil, fmt.Errorf("condition %q doesn't have a comparison operator", condition)
+	}
+
+	j := i + 1
+	if j < len(condition) && condition[j] == '=' {
+		j++
+	}
+
+	path, op := strings.TrimSpace(condition[:i]), condition[i:j]
+	value, err := strconv.ParseFloat(strings.TrimSpace(condition[j:]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("condition %q must compare with a number", condition)
+	}
+
+	if len(path) >= 2 && strings.HasPrefix(path, "|") && strings.HasSuffix(path, "|") {
+		return MagnitudeThreshold(strings.TrimSpace(path[1:len(path)-1]), op, value)
+	}
+	return Threshold(path, op, value)
+}
+
+// Trigger extracts the moments of a bag when a message of a topic matches a condition.
+type Trigger struct {
+	// Name identifies the trigger in its events
+	Name string
+	// Topic is the topic pattern of the messages that are checked, see Pattern
+	Topic     string
+	Condition TriggerCondition
+	// Before and After are the durations of the window around the matching message
+	Before time.Duration
+	After  time.Duration
+}
+
+// TriggerMessage is a message that has been copied out of its record, so it's valid after the
+// record is closed.
+type TriggerMessage struct {
+	Time             time.Time
+	ConnectionHeader *ConnectionHeader
+	Data             []byte
+}
+
+// Lazy returns the message as a LazyMessage.
+func (msg *TriggerMessage) Lazy() *LazyMessage {
+	return NewLazyMessage(&msg.ConnectionHeader.MessageDefinition, msg.Data)
+}
+
+// TriggerEvent is a match of a trigger.
+type TriggerEvent struct {
+	Trigger *Trigger
+	Message *TriggerMessage
+	// Window are the messages from Before the matching message to After it in the order of the
+	// bag, including the matching message when its topic is in the window
+	Window []*TriggerMessage
+}
+
+// TriggerHandler handles the events of a trigger.
+type TriggerHandler func(event *TriggerEvent) error
+
+// TriggerOption configures an optional behavior of a TriggerEngine.
+type TriggerOption func(*triggerConfig)
+
+type triggerConfig struct {
+	windowTopics []*Pattern
+	// err is the first invalid pattern, which is returned by Run
+	err error
+}
+
+// WithWindowTopics sets the topic patterns of the messages in the windows of the events, see
+// Pattern. Every topic is in the windows by default.
+func WithWindowTopics(topics ...string) TriggerOption {
+	return func(config *triggerConfig) {
+		for _, topic := range topics {
+			pattern, err := CompilePattern(topic)
+			if err != nil && config.err == nil {
+				config.err = err
+			}
+			config.windowTopics = append(config.windowTopics, pattern)
+		}
+	}
+}
+
+type registeredTrigger struct {
+	trigger Trigger
+	pattern *Pattern
+	handler TriggerHandler
+	// active are the topics whose last message matched the condition
+	active map[string]bool
+}
+
+type pendingEvent struct {
+	event   TriggerEvent
+	end     time.Time
+	handler TriggerHandler
+}
+
+// TriggerEngine checks the conditions of the registered triggers against the messages of a bag,
+// and calls their handlers with the windows around the matching messages, so the interesting
+// moments of long recordings can be extracted automatically.
+//
+// A trigger fires when its condition becomes true for a topic, and it fires again only after the
+// condition has been false, so a battery that stays below a threshold is a single event. The
+// windows assume that the messages are read in about the order of their record times, like they
+// are recorded.
+type TriggerEngine struct {
+	config   triggerConfig
+	triggers []*registeredTrigger
+	// maxBefore is the longest Before of the triggers, which is how long messages are buffered
+	maxBefore time.Duration
+	buffer    []*TriggerMessage
+	pending   []*pendingEvent
+}
+
+// NewTriggerEngine creates a TriggerEngine without triggers.
+func NewTriggerEngine(opts ...TriggerOption) *TriggerEngine {
+	var engine TriggerEngine
+	for _, opt := range opts {
+		opt(&engine.config)
+	}
+	return &engine
+}
+
+// Register adds trigger, and fn is called by Run with every event of trigger. An error is returned
+// when the topic is not a valid pattern.
+func (engine *TriggerEngine) Register(trigger Trigger, fn TriggerHandler) error {
+	pattern, err := CompilePattern(trigger.Topic)
+	if err != nil {
+		return err
+	}
+
+	if trigger.Condition == nil {
+		return fmt.Errorf("trigger %s doesn't have a condition", trigger.Name)
+	}
+
+	if trigger.Before < 0 || trigger.After < 0 {
+		return fmt.Errorf("trigger %s has a negative window", trigger.Name)
+	}
+
+	if trigger.Before > engine.maxBefore {
+		engine.maxBefore = trigger.Before
+	}
+
+	engine.triggers = append(engine.triggers, &registeredTrigger{
+		trigger: trigger,
+		pattern: pattern,
+		handler: fn,
+		active:  make(map[string]bool),
+	})
+	return nil
+}
+
+// Run reads the rest of the bag, and calls the handlers with the events once their windows have
+// ended. The events whose windows end after the bag are handled at the end with the messages up to
+// the end. Run stops at the first error from the decoder, a condition, or a handler, and returns
+// it.
+func (engine *TriggerEngine) Run(decoder *Decoder) error {
+	if engine.config.err != nil {
+		return engine.config.err
+	}
+
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			err = engine.process(msg)
+		}
+		record.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pending := range engine.pending {
+		err := pending.handler(&pending.event)
+		if err != nil {
+			return err
+		}
+	}
+	engine.pending = nil
+	return nil
+}
+
+func (engine *TriggerEngine) process(record *RecordMessageData) error {
+	t, err := record.Time()
+	if err != nil {
+		return err
+	}
+
+	err = engine.flush(t)
+	if err != nil {
+		return err
+	}
+
+	hdr := record.ConnectionHeader()
+	var msg *TriggerMessage
+	copyMessage := func() *TriggerMessage {
+		if msg == nil {
+			msg = &TriggerMessage{Time: t, ConnectionHeader: hdr, Data: append([]byte(nil), record.Data()...)}
+		}
+		return msg
+	}
+
+	inWindow := engine.inWindow(hdr.Topic)
+	if inWindow {
+		copyMessage()
+		for _, pending := range engine.pending {
+			pending.event.Window = append(pending.event.Window, msg)
+		}
+		engine.buffer = append(engine.buffer, msg)
+	}
+
+	var lazy *LazyMessage
+	for _, registered := range engine.triggers {
+		if !registered.pattern.Match(hdr.Topic) {
+			continue
+		}
+
+		if lazy == nil {
+			lazy = record.Lazy()
+		}
+
+		ok, err := registered.trigger.Condition(lazy)
+		if err != nil {
+			return fmt.Errorf("trigger %s on %s: %w", registered.trigger.Name, hdr.Topic, err)
+		}
+
+		fire := ok && !registered.active[hdr.Topic]
+		registered.active[hdr.Topic] = ok
+		if !fire {
+			continue
+		}
+
+		event := TriggerEvent{Trigger: &registered.trigger, Message: copyMessage()}
+		start := t.Add(-registered.trigger.Before)
+		for _, buffered := range engine.buffer {
+			if !buffered.Time.Before(start) {
+				event.Window = append(event.Window, buffered)
+			}
+		}
+
+		engine.pending = append(engine.pending, &pendingEvent{
+			event:   event,
+			end:     t.Add(registered.trigger.After),
+			handler: registered.handler,
+		})
+	}
+
+	// the messages that are older than every window are dropped
+	start := t.Add(-engine.maxBefore)
+	var i int
+	for i < len(engine.buffer) && engine.buffer[i].Time.Before(start) {
+		engine.buffer[i] = nil
+		i++
+	}
+	engine.buffer = engine.buffer[i:]
+	return nil
+}
+
+// flush handles the events whose windows end before t.
+func (engine *TriggerEngine) flush(t time.Time) error {
+	var rest []*pendingEvent
+	for _, pending := range engine.pending {
+		if !pending.end.Before(t) {
+			rest = append(rest, pending)
+			continue
+		}
+
+		err := pending.handler(&pending.event)
+		if err != nil {
+			return err
+		}
+	}
+	engine.pending = rest
+	return nil
+}
+
+func (engine *TriggerEngine) inWindow(topic string) bool {
+	if len(engine.config.windowTopics) == 0 {
+		return true
+	}
+
+	for _, pattern := range engine.config.windowTopics {
+		if pattern.Match(topic) {
+			return true
+		}
+	}
+	return false
+}
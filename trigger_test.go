@@ -0,0 +1,177 @@
+package rosbag
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const triggerTestImuDefinition = `geometry_msgs/Vector3 linear_acceleration
+================================================================================
+MSG: geometry_msgs/Vector3
+float64 x
+float64 y
+float64 z
+`
+
+// writeTriggerTestBag writes a bag with a battery voltage on /battery every second from 1s, and an
+// acceleration of 30 m/s^2 on /imu at 4s.
+func writeTriggerTestBag(t *testing.T, voltages ...float32) []byte {
+	const battery, imu = 0, 1
+	var records []testRecord
+	for i, voltage := range voltages {
+		data := make([]byte, 4)
+		endian.PutUint32(data, math.Float32bits(voltage))
+		records = append(records, testRecord{Conn: battery, Time: time.Unix(int64(i+1), 0), Data: data})
+
+		if i+1 == 4 {
+			data = make([]byte, 24)
+			endian.PutUint64(data[8:], math.Float64bits(30))
+			records = append(records, testRecord{Conn: imu, Time: time.Unix(4, 0), Data: data})
+		}
+	}
+
+	return writeTestBag(t, withTestConns(
+		&ConnectionHeader{
+			Topic:                "/battery",
+			Type:                 "test_msgs/Battery",
+			RawMessageDefinition: "float32 voltage",
+		},
+		&ConnectionHeader{
+			Topic:                "/imu",
+			Type:                 "test_msgs/Imu",
+			RawMessageDefinition: triggerTestImuDefinition,
+		},
+	), withTestRecords(records...))
+}
+
+type triggerTestEvent struct {
+	Name   string
+	Time   time.Time
+	Window []string
+}
+
+// runTestTriggers runs the triggers against raw, and returns the events with the topics and the
+// seconds of the messages in their windows.
+func runTestTriggers(t *testing.T, raw []byte, triggers []Trigger, opts ...TriggerOption) ([]triggerTestEvent, error) {
+	var events []triggerTestEvent
+	engine := NewTriggerEngine(opts...)
+	for _, trigger := range triggers {
+		err := engine.Register(trigger, func(event *TriggerEvent) error {
+			e := triggerTestEvent{Name: event.Trigger.Name, Time: event.Message.Time}
+			for _, msg := range event.Window {
+				e.Window = append(e.Window, msg.ConnectionHeader.Topic+"@"+msg.Time.Format("05"))
+			}
+			events = append(events, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := engine.Run(NewDecoder(bytes.NewReader(raw)))
+	return events, err
+}
+
+func TestTriggerEngine(t *testing.T) {
+	raw := writeTriggerTestBag(t, 12, 11, 9.5, 9, 12, 8, 8, 12)
+	lowBattery, err := ParseTriggerCondition("voltage < 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	highAcceleration, err := ParseTriggerCondition("|linear_acceleration| > 29.43")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := runTestTriggers(t, raw, []Trigger{
+		{Name: "low_battery", Topic: "/battery", Condition: lowBattery, Before: time.Second, After: time.Second},
+		{Name: "crash", Topic: "/imu", Condition: highAcceleration, After: 10 * time.Second},
+	}, WithWindowTopics("/bat*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the battery stays low from 3s to 4s, and from 6s to 7s, and the crash window ends after
+	// the bag
+	expected := []triggerTestEvent{
+		{Name: "low_battery", Time: time.Unix(3, 0), Window: []string{"/battery@02", "/battery@03", "/battery@04"}},
+		{Name: "low_battery", Time: time.Unix(6, 0), Window: []string{"/battery@05", "/battery@06", "/battery@07"}},
+		{Name: "crash", Time: time.Unix(4, 0), Window: []string{"/battery@04", "/battery@05", "/battery@06", "/battery@07", "/battery@08"}},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Fatalf("events are not matched:\n\n%s", diff)
+	}
+
+	// every topic is in the windows by default
+	events, err = runTestTriggers(t, raw, []Trigger{
+		{Name: "crash", Topic: "/imu", Condition: highAcceleration, Before: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []triggerTestEvent{
+		{Name: "crash", Time: time.Unix(4, 0), Window: []string{"/battery@03", "/battery@04", "/imu@04"}},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Fatalf("events are not matched:\n\n%s", diff)
+	}
+}
+
+func TestTriggerConditions(t *testing.T) {
+	raw := writeTriggerTestBag(t, 12, 12, 12, 12)
+	testCases := []struct {
+		topic     string
+		condition string
+		matched   bool
+	}{
+		{topic: "/battery", condition: "voltage >= 12", matched: true},
+		{topic: "/battery", condition: "voltage != 12", matched: false},
+		{topic: "/battery", condition: "|voltage| = 12", matched: true},
+		{topic: "/imu", condition: "linear_acceleration.y<=30", matched: true},
+		{topic: "/imu", condition: "|linear_acceleration| < 30", matched: false},
+	}
+
+	for _, testCase := range testCases {
+		condition, err := ParseTriggerCondition(testCase.condition)
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.condition, err)
+		}
+
+		events, err := runTestTriggers(t, raw, []Trigger{{Name: "test", Topic: testCase.topic, Condition: condition}})
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.condition, err)
+		}
+
+		if len(events) > 0 != testCase.matched {
+			t.Fatalf("%s: expected matched to be %v, but got %d events", testCase.condition, testCase.matched, len(events))
+		}
+	}
+
+	invalid := []string{"voltage", "voltage => 1", "voltage < low", "voltage[ < 1"}
+	for _, condition := range invalid {
+		_, err := ParseTriggerCondition(condition)
+		if err == nil {
+			t.Fatalf("expected %q to be invalid", condition)
+		}
+	}
+
+	_, err := runTestTriggers(t, raw, []Trigger{{Name: "test", Topic: "/battery", Condition: func(msg *LazyMessage) (bool, error) {
+		_, err := msg.Float64("current")
+		return false, err
+	}}})
+	if err == nil {
+		t.Fatal("expected an error for a missing field")
+	}
+
+	err = NewTriggerEngine().Register(Trigger{Name: "test", Topic: "/battery"}, nil)
+	if err == nil {
+		t.Fatal("expected an error for a trigger without a condition")
+	}
+}
@@ -0,0 +1,119 @@
+package rosbag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrTruncated is matched by the TruncatedError that Read returns when the bag ends in the
+	// middle of a record, e.g. when the recorder lost power.
+	ErrTruncated = errors.New("bag is truncated")
+)
+
+// TruncatedError describes where a truncated bag stops being readable. It matches ErrTruncated
+// and io.ErrUnexpectedEOF with errors.Is.
+type TruncatedError struct {
+	// Offset is the offset in bytes from the beginning of the bag to the end of the last complete
+	// top-level record. A chunk only counts as complete when all of its records have been read.
+	Offset int64
+	// Time is the record time of the last message that has been read. It's zero when no message
+	// has been read.
+	Time time.Time
+}
+
+func (err *TruncatedError) Error() string {
+	msg := fmt.Sprintf("%s, the last complete record ends at offset %d", ErrTruncated, err.Offset)
+	if !err.Time.IsZero() {
+		msg += fmt.Sprintf(", the last message was recorded at %s", err.Time)
+	}
+	return msg
+}
+
+// Is reports whether target is ErrTruncated.
+func (err *TruncatedError) Is(target error) bool {
+	return target == ErrTruncated
+}
+
+// Unwrap returns io.ErrUnexpectedEOF, which is the underlying cause of the truncation.
+func (err *TruncatedError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// Truncated returns the truncation of the bag that has been found by Read, or nil when the bag
+// is not truncated, or it hasn't been read to the end. It's mostly useful with
+// WithTruncationTolerance, which hides the error from Read.
+func (decoder *Decoder) Truncated() *TruncatedError {
+	return decoder.truncation
+}
+
+// truncate records that the bag ends in the middle of a record, and returns the error that Read
+// reports from now on.
+func (decoder *Decoder) truncate() error {
+	decoder.truncation = &TruncatedError{
+		Offset: decoder.goodOffset,
+		Time:   decoder.lastTime,
+	}
+
+	if decoder.tolerateTruncation {
+		decoder.err = io.EOF
+	} else {
+		decoder.err = decoder.truncation
+	}
+	return decoder.err
+}
+
+// track keeps the position of the last complete record up to date so that it can be reported
+// when the bag turns out to be truncated.
+func (decoder *Decoder) track(record Record, topLevel bool) {
+	decoder.telemetry.read(record, topLevel)
+
+	if topLevel {
+		size := 2*lenInBytes + len(record.Header())
+		chunk, isChunk := record.(*RecordChunk)
+		if isChunk {
+			size += int(chunk.DataLen)
+		} else {
+			size += len(record.Data())
+		}
+
+		decoder.offset += int64(size)
+		// a chunk is complete after its last record, see endChunk
+		if !isChunk {
+			decoder.goodOffset = decoder.offset
+		}
+	}
+
+	if msg, ok := record.(*RecordMessageData); ok {
+		if t, err := msg.Time(); err == nil {
+			decoder.lastTime = t
+		}
+	}
+}
+
+// skipTopLevel tracks a top-level record that is skipped by the RecordFilter like track.
+func (decoder *Decoder) skipTopLevel(record *RecordBase) {
+	decoder.offset += int64(2*lenInBytes) + int64(record.HeaderLen) + int64(record.DataLen)
+	decoder.goodOffset = decoder.offset
+	if decoder.prefetcher != nil && decoder.prefetchedChunk != nil {
+		// the skipped chunk has been decompressed by the prefetcher anyway
+		decoder.prefetcher.release(decoder.prefetchedChunk)
+		decoder.prefetchedChunk = nil
+	}
+}
+
+// endChunk marks that all records of the current chunk have been read.
+func (decoder *Decoder) endChunk() {
+	decoder.goodOffset = decoder.offset
+	decoder.telemetry.endChunk(nil)
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF for reads in the middle of a record.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// findTestChunk returns the offset and the length of the nth chunk record of raw.
+func findTestChunk(t *testing.T, raw []byte, n int) (int, int) {
+	versionLen := len(fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor))
+	r := bytes.NewReader(raw[versionLen:])
+	for {
+		offset := len(raw) - r.Len()
+		record, op, err := readRawRecord(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if op == OpChunk {
+			n--
+			if n == 0 {
+				return offset, len(record.Raw)
+			}
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	raw := writeTestBag(t, withTestValues(1, 2, 3), withTestWriter(WithChunkSize(1)))
+	// the bags are cut in the third chunk, like a recording that stopped while the chunk was being
+	// written, so the index data of the second chunk is the last complete record
+	complete, chunkLen := findTestChunk(t, raw, 3)
+
+	decoders := []struct {
+		Name string
+		New  func(raw []byte, opts ...DecoderOption) *Decoder
+	}{
+		{
+			Name: "Stream",
+			New: func(raw []byte, opts ...DecoderOption) *Decoder {
+				return NewDecoder(bytes.NewReader(raw), opts...)
+			},
+		},
+		{
+			Name: "Prefetch",
+			New: func(raw []byte, opts ...DecoderOption) *Decoder {
+				return NewDecoder(bytes.NewReader(raw), append(opts, WithPrefetch())...)
+			},
+		},
+		{
+			Name: "Bytes",
+			New:  NewDecoderBytes,
+		},
+	}
+
+	cuts := []struct {
+		Name string
+		Len  int
+	}{
+		{"Chunk Header", complete + 10},
+		{"Chunk Data", complete + chunkLen - 1},
+	}
+
+	for _, d := range decoders {
+		for _, cut := range cuts {
+			for _, tolerate := range []bool{false, true} {
+				d, cut, tolerate := d, cut, tolerate
+				name := d.Name + "/" + cut.Name
+				if tolerate {
+					name += "/Tolerate"
+				}
+
+				t.Run(name, func(t *testing.T) {
+					var opts []DecoderOption
+					if tolerate {
+						opts = append(opts, WithTruncationTolerance())
+					}
+
+					decoder := d.New(raw[:cut.Len], opts...)
+					defer decoder.Close()
+
+					var messages int
+					var err error
+					for {
+						var record Record
+						record, err = decoder.Read()
+						if err != nil {
+							break
+						}
+
+						if _, ok := record.(*RecordMessageData); ok {
+							messages++
+						}
+						record.Close()
+					}
+
+					if messages != 2 {
+						t.Fatalf("expected the 2 complete messages to be read, but got %d", messages)
+					}
+
+					if tolerate && err != io.EOF {
+						t.Fatalf("expected %v, but got %v", io.EOF, err)
+					}
+
+					if !tolerate && !errors.Is(err, ErrTruncated) {
+						t.Fatalf("expected %v, but got %v", ErrTruncated, err)
+					}
+
+					truncation := decoder.Truncated()
+					if truncation == nil {
+						t.Fatal("expected the truncation to be reported")
+					}
+
+					if truncation.Offset != int64(complete) {
+						t.Fatalf("expected the last complete record to end at %d, but got %d", complete, truncation.Offset)
+					}
+
+					if !truncation.Time.Equal(time.Unix(2, 0)) {
+						t.Fatalf("expected the last message time to be %v, but got %v", time.Unix(2, 0), truncation.Time)
+					}
+
+					if _, err = decoder.Read(); err != decoder.err {
+						t.Fatalf("expected Read to keep returning %v, but got %v", decoder.err, err)
+					}
+				})
+			}
+		}
+	}
+
+	decoder := NewDecoderBytes(raw)
+	readAllRecords(t, decoder)
+	if decoder.Truncated() != nil {
+		t.Fatalf("expected a complete bag not to be truncated, but got %v", decoder.Truncated())
+	}
+}
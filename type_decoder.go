@@ -2,9 +2,8 @@ package rosbag
 
 import (
 	"math"
-	"reflect"
 	"time"
-	"unsafe"
+	"unicode/utf16"
 )
 
 type fieldDecodeFunc func(raw []byte, length int) (v interface{}, off int, ok bool)
@@ -22,46 +21,37 @@ var fieldDecodeBasicHelper = map[MessageFieldType]fieldDecodeFunc{
 	MessageFieldTypeFloat32:  fieldDecodeFloat32,
 	MessageFieldTypeFloat64:  fieldDecodeFloat64,
 	MessageFieldTypeString:   fieldDecodeString,
+	MessageFieldTypeWString:  fieldDecodeWString,
 	MessageFieldTypeTime:     fieldDecodeTime,
 	MessageFieldTypeDuration: fieldDecodeDuration,
 }
 
 var fieldDecodeSliceHelper map[MessageFieldType]fieldDecodeFunc
 
+// initFieldSliceDecoder picks the slice decoders. In fast mode, the numeric slices share the memory of
+// the raw buffer, which is only possible when the host and the bag have the same byte order.
 func initFieldSliceDecoder(fastMode bool) {
+	fieldDecodeSliceHelper = map[MessageFieldType]fieldDecodeFunc{
+		MessageFieldTypeBool:     fieldDecodeBoolSlice,
+		MessageFieldTypeInt8:     fieldDecodeInt8Slice,
+		MessageFieldTypeUint8:    fieldDecodeUint8Slice,
+		MessageFieldTypeInt16:    fieldDecodeInt16SliceSlow,
+		MessageFieldTypeUint16:   fieldDecodeUint16SliceSlow,
+		MessageFieldTypeInt32:    fieldDecodeInt32SliceSlow,
+		MessageFieldTypeUint32:   fieldDecodeUint32SliceSlow,
+		MessageFieldTypeInt64:    fieldDecodeInt64SliceSlow,
+		MessageFieldTypeUint64:   fieldDecodeUint64SliceSlow,
+		MessageFieldTypeFloat32:  fieldDecodeFloat32SliceSlow,
+		MessageFieldTypeFloat64:  fieldDecodeFloat64SliceSlow,
+		MessageFieldTypeString:   fieldDecodeStringSlice,
+		MessageFieldTypeWString:  fieldDecodeWStringSlice,
+		MessageFieldTypeTime:     fieldDecodeTimeSlice,
+		MessageFieldTypeDuration: fieldDecodeDurationSlice,
+	}
+
 	if fastMode {
-		fieldDecodeSliceHelper = map[MessageFieldType]fieldDecodeFunc{
-			MessageFieldTypeBool:     fieldDecodeBoolSlice,
-			MessageFieldTypeInt8:     fieldDecodeInt8Slice,
-			MessageFieldTypeUint8:    fieldDecodeUint8Slice,
-			MessageFieldTypeInt16:    fieldDecodeInt16Slice,
-			MessageFieldTypeUint16:   fieldDecodeUint16Slice,
-			MessageFieldTypeInt32:    fieldDecodeInt32Slice,
-			MessageFieldTypeUint32:   fieldDecodeUint32Slice,
-			MessageFieldTypeInt64:    fieldDecodeInt64Slice,
-			MessageFieldTypeUint64:   fieldDecodeUint64Slice,
-			MessageFieldTypeFloat32:  fieldDecodeFloat32Slice,
-			MessageFieldTypeFloat64:  fieldDecodeFloat64Slice,
-			MessageFieldTypeString:   fieldDecodeStringSlice,
-			MessageFieldTypeTime:     fieldDecodeTimeSlice,
-			MessageFieldTypeDuration: fieldDecodeDurationSlice,
-		}
-	} else {
-		fieldDecodeSliceHelper = map[MessageFieldType]fieldDecodeFunc{
-			MessageFieldTypeBool:     fieldDecodeBoolSlice,
-			MessageFieldTypeInt8:     fieldDecodeInt8Slice,
-			MessageFieldTypeUint8:    fieldDecodeUint8Slice,
-			MessageFieldTypeInt16:    fieldDecodeInt16SliceSlow,
-			MessageFieldTypeUint16:   fieldDecodeUint16SliceSlow,
-			MessageFieldTypeInt32:    fieldDecodeInt32SliceSlow,
-			MessageFieldTypeUint32:   fieldDecodeUint32SliceSlow,
-			MessageFieldTypeInt64:    fieldDecodeInt64SliceSlow,
-			MessageFieldTypeUint64:   fieldDecodeUint64SliceSlow,
-			MessageFieldTypeFloat32:  fieldDecodeFloat32SliceSlow,
-			MessageFieldTypeFloat64:  fieldDecodeFloat64SliceSlow,
-			MessageFieldTypeString:   fieldDecodeStringSlice,
-			MessageFieldTypeTime:     fieldDecodeTimeSlice,
-			MessageFieldTypeDuration: fieldDecodeDurationSlice,
+		for typ, fn := range fieldDecodeSliceFastHelper {
+			fieldDecodeSliceHelper[typ] = fn
 		}
 	}
 }
@@ -77,16 +67,29 @@ func fieldDecodeLength(raw []byte, fixedLength int) (length int, off int, ok boo
 		return
 	}
 
-	length = int(endian.Uint32(raw))
-	if len(raw) < lenInBytes+length {
+	// the length is compared before the conversion, so it can't overflow int on 32-bit platforms
+	n := endian.Uint32(raw)
+	if uint64(len(raw)) < uint64(lenInBytes)+uint64(n) {
 		return
 	}
+	length = int(n)
 
 	ok = true
 	off = lenInBytes
 	return
 }
 
+// fieldDecodeSliceLength decodes the length of a slice like fieldDecodeLength, and also checks that
+// raw has all of the elements of size bytes.
+func fieldDecodeSliceLength(raw []byte, fixedLength int, size int) (length int, off int, ok bool) {
+	length, off, ok = fieldDecodeLength(raw, fixedLength)
+	// length*size can overflow on 32-bit platforms
+	if ok && len(raw[off:])/size < length {
+		ok = false
+	}
+	return
+}
+
 func fieldDecodeBool(raw []byte, length int) (v interface{}, off int, ok bool) {
 	off = 1
 	if len(raw) < off {
@@ -211,8 +214,6 @@ func fieldDecodeFloat64(raw []byte, length int) (v interface{}, off int, ok bool
 }
 
 func fieldDecodeString(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var s string
-
 	length, off, ok = fieldDecodeLength(raw, length)
 	if !ok {
 		return
@@ -230,97 +231,61 @@ func fieldDecodeString(raw []byte, length int) (v interface{}, off int, ok bool)
 		return
 	}
 
-	sl := (*reflect.StringHeader)(unsafe.Pointer(&s))
-	sl.Data = uintptr(unsafe.Pointer(&raw[0]))
-	sl.Len = length
 	off += length
 	ok = true
-	v = s
+	v = viewString(raw[:length])
 	return
 }
 
-func fieldDecodeTime(raw []byte, length int) (v interface{}, off int, ok bool) {
-	off = 8
-	if len(raw) < off {
+// fieldDecodeWString decodes a wide string that is serialized as the number of UTF-16 code units
+// followed by the code units. Unlike string, wstring can't be viewed without a copy.
+func fieldDecodeWString(raw []byte, length int) (v interface{}, off int, ok bool) {
+	length, off, ok = fieldDecodeLength(raw, length)
+	if !ok {
 		return
 	}
 
-	v = extractTime(raw)
+	raw = raw[off:]
+	if len(raw) < length*2 {
+		ok = false
+		return
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = endian.Uint16(raw[i*2:])
+	}
+
+	off += length * 2
 	ok = true
+	v = string(utf16.Decode(units))
 	return
 }
 
-func fieldDecodeDuration(raw []byte, length int) (v interface{}, off int, ok bool) {
+func fieldDecodeTime(raw []byte, length int) (v interface{}, off int, ok bool) {
 	off = 8
 	if len(raw) < off {
 		return
 	}
 
-	v = extractDuration(raw)
+	v = extractTime(raw)
 	ok = true
 	return
 }
 
-func fieldDecodeBasicSlice(raw []byte, ptr unsafe.Pointer, length int, size int) (off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
-	if !ok {
-		return
-	}
-
-	if length == 0 {
-		ok = true
-		return
-	}
-
-	raw = raw[off:]
-	if len(raw) < length*size {
-		ok = false
+func fieldDecodeDuration(raw []byte, length int) (v interface{}, off int, ok bool) {
+	off = 8
+	if len(raw) < off {
 		return
 	}
 
-	s := (*reflect.SliceHeader)(ptr)
-	s.Data = uintptr(unsafe.Pointer(&raw[0]))
-	s.Len = length
-	s.Cap = length
-	off += length * size
+	v = extractDuration(raw)
 	ok = true
 	return
 }
 
-func fieldDecodeBoolSlice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []bool
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
-	v = b
-	return
-}
-
-func fieldDecodeInt8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []int8
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
-	v = b
-	return
-}
-
-func fieldDecodeUint8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []uint8
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
-	v = b
-	return
-}
-
-func fieldDecodeInt16Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []int16
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 2)
-	v = b
-	return
-}
-
 func fieldDecodeInt16SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 2)
 	if !ok {
 		return
 	}
@@ -334,16 +299,8 @@ func fieldDecodeInt16SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeUint16Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []uint16
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 2)
-	v = b
-	return
-}
-
 func fieldDecodeUint16SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 2)
 	if !ok {
 		return
 	}
@@ -357,16 +314,8 @@ func fieldDecodeUint16SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeInt32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []int32
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
-	v = b
-	return
-}
-
 func fieldDecodeInt32SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 4)
 	if !ok {
 		return
 	}
@@ -380,16 +329,8 @@ func fieldDecodeInt32SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeUint32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []uint32
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
-	v = b
-	return
-}
-
 func fieldDecodeUint32SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 4)
 	if !ok {
 		return
 	}
@@ -403,16 +344,8 @@ func fieldDecodeUint32SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeInt64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []int64
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
-	v = b
-	return
-}
-
 func fieldDecodeInt64SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 8)
 	if !ok {
 		return
 	}
@@ -426,16 +359,8 @@ func fieldDecodeInt64SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeUint64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []uint64
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
-	v = b
-	return
-}
-
 func fieldDecodeUint64SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 8)
 	if !ok {
 		return
 	}
@@ -449,16 +374,8 @@ func fieldDecodeUint64SliceSlow(raw []byte, length int) (v interface{}, off int,
 	return
 }
 
-func fieldDecodeFloat32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []float32
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
-	v = b
-	return
-}
-
 func fieldDecodeFloat32SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 4)
 	if !ok {
 		return
 	}
@@ -472,16 +389,8 @@ func fieldDecodeFloat32SliceSlow(raw []byte, length int) (v interface{}, off int
 	return
 }
 
-func fieldDecodeFloat64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
-	var b []float64
-
-	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
-	v = b
-	return
-}
-
 func fieldDecodeFloat64SliceSlow(raw []byte, length int) (v interface{}, off int, ok bool) {
-	length, off, ok = fieldDecodeLength(raw, length)
+	length, off, ok = fieldDecodeSliceLength(raw, length, 8)
 	if !ok {
 		return
 	}
@@ -576,3 +485,35 @@ func fieldDecodeDurationSlice(raw []byte, length int) (v interface{}, off int, o
 	ok = true
 	return
 }
+
+func fieldDecodeWStringSlice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	length, off, ok = fieldDecodeLength(raw, length)
+	if !ok {
+		return
+	}
+
+	if length == 0 {
+		var s []string
+		v = s
+		ok = true
+		return
+	}
+
+	s := make([]string, length)
+	totalOff := off
+	for i := 0; i < length; i++ {
+		v, off, ok = fieldDecodeWString(raw[totalOff:], -1)
+		if !ok {
+			off = 0
+			return
+		}
+
+		s[i] = v.(string)
+		totalOff += off
+	}
+
+	v = s
+	off = totalOff
+	ok = true
+	return
+}
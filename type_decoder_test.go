@@ -0,0 +1,116 @@
+package rosbag
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// largeArrayLength is big enough that the array lengths in bytes need more than 16 bits, while
+// staying small enough for the tests to run on 32-bit platforms, e.g. GOARCH=386.
+const largeArrayLength = 1 << 18
+
+func TestFieldDecodeLargeSlices(t *testing.T) {
+	int16s := make([]int16, largeArrayLength)
+	uint16s := make([]uint16, largeArrayLength)
+	int32s := make([]int32, largeArrayLength)
+	uint32s := make([]uint32, largeArrayLength)
+	int64s := make([]int64, largeArrayLength)
+	uint64s := make([]uint64, largeArrayLength)
+	float32s := make([]float32, largeArrayLength)
+	float64s := make([]float64, largeArrayLength)
+	uint8s := make([]uint8, largeArrayLength)
+	for i := 0; i < largeArrayLength; i++ {
+		int16s[i] = int16(-i)
+		uint16s[i] = uint16(i)
+		int32s[i] = int32(-i)
+		uint32s[i] = uint32(i) * 7
+		int64s[i] = -int64(i) << 32
+		uint64s[i] = uint64(i) << 32
+		float32s[i] = float32(i) / 3
+		float64s[i] = math.Sqrt(float64(i))
+		uint8s[i] = uint8(i)
+	}
+
+	testCases := []struct {
+		typ   MessageFieldType
+		value interface{}
+		size  int
+	}{
+		{MessageFieldTypeUint8, uint8s, 1},
+		{MessageFieldTypeInt16, int16s, 2},
+		{MessageFieldTypeUint16, uint16s, 2},
+		{MessageFieldTypeInt32, int32s, 4},
+		{MessageFieldTypeUint32, uint32s, 4},
+		{MessageFieldTypeInt64, int64s, 8},
+		{MessageFieldTypeUint64, uint64s, 8},
+		{MessageFieldTypeFloat32, float32s, 4},
+		{MessageFieldTypeFloat64, float64s, 8},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.typ.String(), func(t *testing.T) {
+			raw := addDataMulti(nil, tc.value, true)
+			// trailing data must not be consumed
+			raw = append(raw, 0xff)
+
+			v, off, ok := fieldDecodeSliceHelper[tc.typ](raw, -1)
+			if !ok {
+				t.Fatal("expected the slice to be decoded")
+			}
+
+			if expected := lenInBytes + largeArrayLength*tc.size; off != expected {
+				t.Fatalf("expected offset to be %d, but got %d", expected, off)
+			}
+
+			if diff := cmp.Diff(tc.value, v); diff != "" {
+				t.Fatal(diff)
+			}
+
+			// fixed-length arrays don't have the length prefix
+			v, off, ok = fieldDecodeSliceHelper[tc.typ](raw[lenInBytes:], largeArrayLength)
+			if !ok || off != largeArrayLength*tc.size {
+				t.Fatalf("expected the fixed-length slice to be decoded, but got ok=%v and offset %d", ok, off)
+			}
+
+			if diff := cmp.Diff(tc.value, v); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestFieldDecodeLengthOverflow(t *testing.T) {
+	// the maximum length would be negative if it's converted to int on 32-bit platforms
+	for _, length := range []uint32{math.MaxUint32, math.MaxInt32 + 1, largeArrayLength} {
+		raw := append(uint32Bytes(length), 1, 2, 3, 4, 5, 6, 7, 8)
+
+		for typ, decode := range fieldDecodeSliceHelper {
+			_, _, ok := decode(raw, -1)
+			if ok {
+				t.Fatalf("expected %s[] with a length of %d to fail", typ, length)
+			}
+		}
+
+		for _, typ := range []MessageFieldType{MessageFieldTypeString, MessageFieldTypeWString} {
+			_, _, ok := fieldDecodeBasicHelper[typ](raw, -1)
+			if ok {
+				t.Fatalf("expected %s with a length of %d to fail", typ, length)
+			}
+		}
+	}
+}
+
+func TestFieldDecodeShortSlice(t *testing.T) {
+	// the length fits in the data, but not the elements
+	raw := append(uint32Bytes(3), 1, 2, 3, 4, 5)
+
+	for _, typ := range []MessageFieldType{MessageFieldTypeInt16, MessageFieldTypeUint32, MessageFieldTypeFloat64} {
+		_, _, ok := fieldDecodeSliceHelper[typ](raw, -1)
+		if ok {
+			t.Fatalf("expected %s[] to fail", typ)
+		}
+	}
+}
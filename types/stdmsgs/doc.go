@@ -0,0 +1,13 @@
+// Package stdmsgs has the structs of the std_msgs message types with rosbag struct tags, so the
+// common messages can be viewed without writing the structs, e.g.
+//
+//	var s stdmsgs.String
+//	err := record.ViewAs(&s)
+//
+// The structs are generated by rosbaggen from the message definitions in testdata/std_msgs/msg:
+//
+//	go run ../../cmd/rosbaggen -pkg stdmsgs -o stdmsgs.go testdata/std_msgs/msg/*.msg
+//
+// Strings and arrays share the memory of the record like any struct that is passed to ViewAs. The
+// structs can be registered with rosbag.RegisterType to be returned by RecordMessageData.Decode.
+package stdmsgs
@@ -0,0 +1,251 @@
+// Code generated by rosbaggen. DO NOT EDIT.
+
+package stdmsgs
+
+import (
+	"time"
+)
+
+// Bool is the ROS message type std_msgs/Bool.
+type Bool struct {
+	Data bool `rosbag:"data"`
+}
+
+// Byte is the ROS message type std_msgs/Byte.
+type Byte struct {
+	Data int8 `rosbag:"data"`
+}
+
+// ByteMultiArray is the ROS message type std_msgs/ByteMultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type ByteMultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []int8           `rosbag:"data"`   // array of data
+}
+
+// Char is the ROS message type std_msgs/Char.
+type Char struct {
+	Data uint8 `rosbag:"data"`
+}
+
+// ColorRGBA is the ROS message type std_msgs/ColorRGBA.
+type ColorRGBA struct {
+	R float32 `rosbag:"r"`
+	G float32 `rosbag:"g"`
+	B float32 `rosbag:"b"`
+	A float32 `rosbag:"a"`
+}
+
+// Duration is the ROS message type std_msgs/Duration.
+type Duration struct {
+	Data time.Duration `rosbag:"data"`
+}
+
+// Empty is the ROS message type std_msgs/Empty.
+type Empty struct {
+}
+
+// Float32 is the ROS message type std_msgs/Float32.
+type Float32 struct {
+	Data float32 `rosbag:"data"`
+}
+
+// Float32MultiArray is the ROS message type std_msgs/Float32MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Float32MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []float32        `rosbag:"data"`   // array of data
+}
+
+// Float64 is the ROS message type std_msgs/Float64.
+type Float64 struct {
+	Data float64 `rosbag:"data"`
+}
+
+// Float64MultiArray is the ROS message type std_msgs/Float64MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Float64MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []float64        `rosbag:"data"`   // array of data
+}
+
+// Header is the ROS message type std_msgs/Header.
+//
+// Standard metadata for higher-level stamped data types.
+// This is generally used to communicate timestamped data
+// in a particular coordinate frame.
+type Header struct {
+	// sequence ID: consecutively increasing ID
+	Seq uint32 `rosbag:"seq"`
+	// Two-integer timestamp that is expressed as:
+	// * stamp.sec: seconds (stamp_secs) since epoch (in Python the variable is called 'secs')
+	// * stamp.nsec: nanoseconds since stamp_secs (in Python the variable is called 'nsecs')
+	// time-handling sugar is provided by the client library
+	Stamp time.Time `rosbag:"stamp"`
+	// Frame this data is associated with
+	FrameID string `rosbag:"frame_id"`
+}
+
+// Int16 is the ROS message type std_msgs/Int16.
+type Int16 struct {
+	Data int16 `rosbag:"data"`
+}
+
+// Int16MultiArray is the ROS message type std_msgs/Int16MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Int16MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []int16          `rosbag:"data"`   // array of data
+}
+
+// Int32 is the ROS message type std_msgs/Int32.
+type Int32 struct {
+	Data int32 `rosbag:"data"`
+}
+
+// Int32MultiArray is the ROS message type std_msgs/Int32MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Int32MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []int32          `rosbag:"data"`   // array of data
+}
+
+// Int64 is the ROS message type std_msgs/Int64.
+type Int64 struct {
+	Data int64 `rosbag:"data"`
+}
+
+// Int64MultiArray is the ROS message type std_msgs/Int64MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Int64MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []int64          `rosbag:"data"`   // array of data
+}
+
+// Int8 is the ROS message type std_msgs/Int8.
+type Int8 struct {
+	Data int8 `rosbag:"data"`
+}
+
+// Int8MultiArray is the ROS message type std_msgs/Int8MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type Int8MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []int8           `rosbag:"data"`   // array of data
+}
+
+// MultiArrayDimension is the ROS message type std_msgs/MultiArrayDimension.
+type MultiArrayDimension struct {
+	Label  string `rosbag:"label"`  // label of given dimension
+	Size   uint32 `rosbag:"size"`   // size of given dimension (in type units)
+	Stride uint32 `rosbag:"stride"` // stride of given dimension
+}
+
+// MultiArrayLayout is the ROS message type std_msgs/MultiArrayLayout.
+//
+// The multiarray declares a generic multi-dimensional array of a
+// particular data type.  Dimensions are ordered from outer most
+// to inner most.
+// Accessors should ALWAYS be written in terms of dimension stride
+// and specified outer-most dimension first.
+//
+// multiarray(i,j,k) = data[data_offset + dim_stride[1]*i + dim_stride[2]*j + k]
+//
+// A standard, 3-channel 640x480 image with interleaved color channels
+// would be specified as:
+//
+// dim[0].label  = "height"
+// dim[0].size   = 480
+// dim[0].stride = 3*640*480 = 921600  (note dim[0] stride is just size of image)
+// dim[1].label  = "width"
+// dim[1].size   = 640
+// dim[1].stride = 3*640 = 1920
+// dim[2].label  = "channel"
+// dim[2].size   = 3
+// dim[2].stride = 3
+//
+// multiarray(i,j,k) refers to the ith row, jth column, and kth channel.
+type MultiArrayLayout struct {
+	Dim        []MultiArrayDimension `rosbag:"dim"`         // Array of dimension properties
+	DataOffset uint32                `rosbag:"data_offset"` // padding elements at front of data
+}
+
+// String is the ROS message type std_msgs/String.
+type String struct {
+	Data string `rosbag:"data"`
+}
+
+// Time is the ROS message type std_msgs/Time.
+type Time struct {
+	Data time.Time `rosbag:"data"`
+}
+
+// UInt16 is the ROS message type std_msgs/UInt16.
+type UInt16 struct {
+	Data uint16 `rosbag:"data"`
+}
+
+// UInt16MultiArray is the ROS message type std_msgs/UInt16MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type UInt16MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []uint16         `rosbag:"data"`   // array of data
+}
+
+// UInt32 is the ROS message type std_msgs/UInt32.
+type UInt32 struct {
+	Data uint32 `rosbag:"data"`
+}
+
+// UInt32MultiArray is the ROS message type std_msgs/UInt32MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type UInt32MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []uint32         `rosbag:"data"`   // array of data
+}
+
+// UInt64 is the ROS message type std_msgs/UInt64.
+type UInt64 struct {
+	Data uint64 `rosbag:"data"`
+}
+
+// UInt64MultiArray is the ROS message type std_msgs/UInt64MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type UInt64MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []uint64         `rosbag:"data"`   // array of data
+}
+
+// UInt8 is the ROS message type std_msgs/UInt8.
+type UInt8 struct {
+	Data uint8 `rosbag:"data"`
+}
+
+// UInt8MultiArray is the ROS message type std_msgs/UInt8MultiArray.
+//
+// Please look at the MultiArrayLayout message definition for
+// documentation on all multiarrays.
+type UInt8MultiArray struct {
+	Layout MultiArrayLayout `rosbag:"layout"` // specification of data layout
+	Data   []uint8          `rosbag:"data"`   // array of data
+}
@@ -0,0 +1,146 @@
+package stdmsgs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lherman-cs/go-rosbag"
+)
+
+var structs = map[string]interface{}{
+	"Bool": Bool{}, "Byte": Byte{}, "ByteMultiArray": ByteMultiArray{}, "Char": Char{},
+	"ColorRGBA": ColorRGBA{}, "Duration": Duration{}, "Empty": Empty{}, "Float32": Float32{},
+	"Float32MultiArray": Float32MultiArray{}, "Float64": Float64{}, "Float64MultiArray": Float64MultiArray{},
+	"Header": Header{}, "Int16": Int16{}, "Int16MultiArray": Int16MultiArray{}, "Int32": Int32{},
+	"Int32MultiArray": Int32MultiArray{}, "Int64": Int64{}, "Int64MultiArray": Int64MultiArray{},
+	"Int8": Int8{}, "Int8MultiArray": Int8MultiArray{}, "MultiArrayDimension": MultiArrayDimension{},
+	"MultiArrayLayout": MultiArrayLayout{}, "String": String{}, "Time": Time{}, "UInt16": UInt16{},
+	"UInt16MultiArray": UInt16MultiArray{}, "UInt32": UInt32{}, "UInt32MultiArray": UInt32MultiArray{},
+	"UInt64": UInt64{}, "UInt64MultiArray": UInt64MultiArray{}, "UInt8": UInt8{},
+	"UInt8MultiArray": UInt8MultiArray{},
+}
+
+// readDefinition returns the message definition of std_msgs/name from testdata with the nested
+// types of the multiarrays.
+func readDefinition(t *testing.T, name string) string {
+	var raw strings.Builder
+	for i, msgName := range []string{name, "MultiArrayLayout", "MultiArrayDimension"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "std_msgs", "msg", msgName+".msg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i > 0 {
+			raw.WriteString("\n" + strings.Repeat("=", 80) + "\nMSG: std_msgs/" + msgName + "\n")
+		}
+		raw.Write(b)
+	}
+	return raw.String()
+}
+
+func TestStructs(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "std_msgs", "msg", "*.msg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != len(structs) {
+		t.Fatalf("expected a struct for each of the %d message types, but got %d", len(paths), len(structs))
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".msg")
+		v, ok := structs[name]
+		if !ok {
+			t.Fatalf("std_msgs/%s doesn't have a struct", name)
+		}
+
+		def, err := rosbag.ParseMessageDefinition("std_msgs/"+name, readDefinition(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = rosbag.CompilePlan(def, reflect.TypeOf(v))
+		if err != nil {
+			t.Fatalf("std_msgs/%s: %v", name, err)
+		}
+	}
+}
+
+func TestViewAs(t *testing.T) {
+	messages := map[string]interface{}{
+		"String": &String{Data: "hello"},
+		"Header": &Header{Seq: 1, Stamp: time.Unix(2, 3), FrameID: "map"},
+		"Float64MultiArray": &Float64MultiArray{
+			Layout: MultiArrayLayout{
+				Dim:        []MultiArrayDimension{{Label: "rows", Size: 2, Stride: 4}, {Label: "cols", Size: 2, Stride: 2}},
+				DataOffset: 0,
+			},
+			Data: []float64{1, 2, 3, 4},
+		},
+	}
+
+	var buf bytes.Buffer
+	writer := rosbag.NewWriter(&buf)
+	for name, msg := range messages {
+		conn, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+			Topic:                "/" + name,
+			Type:                 "std_msgs/" + name,
+			RawMessageDefinition: readDefinition(t, name),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.WriteMessageFrom(conn, time.Unix(1, 0), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := rosbag.NewDecoderBytes(buf.Bytes())
+	var viewed int
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok {
+			continue
+		}
+
+		expected := messages[strings.TrimPrefix(msg.ConnectionHeader().Type, "std_msgs/")]
+		actual := reflect.New(reflect.TypeOf(expected).Elem()).Interface()
+		err = msg.ViewAs(actual)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("%s is not matched:\n\n%s", msg.ConnectionHeader().Type, diff)
+		}
+		viewed++
+	}
+
+	if viewed != len(messages) {
+		t.Fatalf("expected %d messages, but got %d", len(messages), viewed)
+	}
+}
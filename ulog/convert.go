@@ -0,0 +1,724 @@
+package ulog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const (
+	// TypePackage is the package of the ROS types of the topics that are converted from ULog, e.g.
+	// vehicle_status becomes px4_msgs/VehicleStatus.
+	TypePackage = "px4_msgs"
+	// LogTopic is the topic of the logging messages, which are rosgraph_msgs/Log messages in bags.
+	LogTopic = "/rosout"
+	logType  = "rosgraph_msgs/Log"
+	// logNode is the name of the node of the logging messages that are converted from ULog
+	logNode = "px4"
+)
+
+const logDefinition = `byte DEBUG=1
+byte INFO=2
+byte WARN=4
+byte ERROR=8
+byte FATAL=16
+Header header
+byte level
+string name
+string msg
+string file
+string function
+uint32 line
+string[] topics
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+// The levels of rosgraph_msgs/Log.
+const (
+	rosDebug uint8 = 1
+	rosInfo  uint8 = 2
+	rosWarn  uint8 = 4
+	rosError uint8 = 8
+	rosFatal uint8 = 16
+)
+
+// The formats of time and duration fields of ROS messages, which have the same serialization.
+var (
+	timeFormat     = &Format{Name: "ros_time", Fields: []Field{{Type: "uint32_t", Name: "sec"}, {Type: "uint32_t", Name: "nsec"}}}
+	durationFormat = &Format{Name: "ros_duration", Fields: []Field{{Type: "int32_t", Name: "sec"}, {Type: "int32_t", Name: "nsec"}}}
+)
+
+// rosTypes are the ROS types of the primitive ULog types.
+var rosTypes = map[string]string{
+	"int8_t":   "int8",
+	"uint8_t":  "uint8",
+	"int16_t":  "int16",
+	"uint16_t": "uint16",
+	"int32_t":  "int32",
+	"uint32_t": "uint32",
+	"int64_t":  "int64",
+	"uint64_t": "uint64",
+	"float":    "float32",
+	"double":   "float64",
+	"bool":     "bool",
+	"char":     "char",
+}
+
+// ulogTypes are the ULog types of the primitive ROS types.
+var ulogTypes = map[rosbag.MessageFieldType]string{
+	rosbag.MessageFieldTypeBool:    "bool",
+	rosbag.MessageFieldTypeInt8:    "int8_t",
+	rosbag.MessageFieldTypeUint8:   "uint8_t",
+	rosbag.MessageFieldTypeInt16:   "int16_t",
+	rosbag.MessageFieldTypeUint16:  "uint16_t",
+	rosbag.MessageFieldTypeInt32:   "int32_t",
+	rosbag.MessageFieldTypeUint32:  "uint32_t",
+	rosbag.MessageFieldTypeInt64:   "int64_t",
+	rosbag.MessageFieldTypeUint64:  "uint64_t",
+	rosbag.MessageFieldTypeFloat32: "float",
+	rosbag.MessageFieldTypeFloat64: "double",
+}
+
+// rosSizes are the sizes of the fixed-size ROS types.
+var rosSizes = map[rosbag.MessageFieldType]int{
+	rosbag.MessageFieldTypeBool:     1,
+	rosbag.MessageFieldTypeInt8:     1,
+	rosbag.MessageFieldTypeUint8:    1,
+	rosbag.MessageFieldTypeInt16:    2,
+	rosbag.MessageFieldTypeUint16:   2,
+	rosbag.MessageFieldTypeInt32:    4,
+	rosbag.MessageFieldTypeUint32:   4,
+	rosbag.MessageFieldTypeInt64:    8,
+	rosbag.MessageFieldTypeUint64:   8,
+	rosbag.MessageFieldTypeFloat32:  4,
+	rosbag.MessageFieldTypeFloat64:  8,
+	rosbag.MessageFieldTypeTime:     8,
+	rosbag.MessageFieldTypeDuration: 8,
+}
+
+var (
+	errVariableArray = errors.New("ULog doesn't have variable-length arrays")
+	errShortROSData  = errors.New("message data is shorter than its definition")
+)
+
+type config struct {
+	startTime *time.Time
+}
+
+// Option configures a conversion.
+type Option func(*config)
+
+// WithStartTime sets the time of the start of a ULog file that is converted to a bag. The
+// timestamps of ULog files are from the boot of the flight controller, so by default they're
+// converted to times from the Unix epoch, and WithStartTime aligns them with the recordings of the
+// other computers, e.g. with the UTC time of the GPS at boot.
+func WithStartTime(t time.Time) Option {
+	return func(cfg *config) {
+		cfg.startTime = &t
+	}
+}
+
+// Stats describes a conversion.
+type Stats struct {
+	Topics   int
+	Messages int
+	Logs     int
+	// SkippedTopics are the topics of a bag that can't be converted to ULog, since they have
+	// variable-length arrays
+	SkippedTopics []string
+}
+
+// TopicName returns the topic of a ULog subscription in a bag, e.g. /vehicle_status, and
+// /vehicle_status_1 for the second instance.
+func TopicName(sub *Subscription) string {
+	if sub.MultiID == 0 {
+		return "/" + sub.Format.Name
+	}
+	return fmt.Sprintf("/%s_%d", sub.Format.Name, sub.MultiID)
+}
+
+// TypeName returns the ROS type of a ULog format, e.g. vehicle_status becomes
+// px4_msgs/VehicleStatus.
+func TypeName(formatName string) string {
+	var b strings.Builder
+	b.WriteString(TypePackage + "/")
+	for _, part := range strings.Split(formatName, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// formatName returns the ULog format of a ROS type, which is the snake case of its name, e.g.
+// sensor_msgs/NavSatFix becomes nav_sat_fix, and px4_msgs/VehicleStatus becomes vehicle_status.
+func formatName(msgType string) string {
+	name := []rune(msgType[strings.LastIndexByte(msgType, '/')+1:])
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := name[i-1]
+			nextLower := i+1 < len(name) && unicode.IsLower(name[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Definition returns the ROS message definition of a ULog format. The padding fields are dropped,
+// and the nested formats are appended in sections that start with "MSG: <type>".
+func Definition(formats map[string]*Format, format *Format) (string, error) {
+	var b strings.Builder
+	queue := []*Format{format}
+	seen := map[string]bool{format.Name: true}
+	for i := 0; i < len(queue); i++ {
+		if i > 0 {
+			fmt.Fprintf(&b, "%s\nMSG: %s\n", strings.Repeat("=", 80), TypeName(queue[i].Name))
+		}
+
+		for _, field := range queue[i].Fields {
+			if field.isPadding() {
+				continue
+			}
+
+			typ, ok := rosTypes[field.Type]
+			if !ok {
+				nested, ok := formats[field.Type]
+				if !ok {
+					return "", fmt.Errorf("field %s of %s has an unknown type %s", field.Name, queue[i].Name, field.Type)
+				}
+
+				typ = TypeName(nested.Name)
+				if !seen[nested.Name] {
+					seen[nested.Name] = true
+					queue = append(queue, nested)
+				}
+			}
+
+			if field.ArraySize > 0 {
+				typ += fmt.Sprintf("[%d]", field.ArraySize)
+			}
+			fmt.Fprintf(&b, "%s %s\n", typ, field.Name)
+		}
+	}
+	return b.String(), nil
+}
+
+// ToBag converts the ULog file in r to a bag, which is written to w. Every subscription is a topic,
+// see TopicName and TypeName, and the logging messages are rosgraph_msgs/Log messages on /rosout.
+// The record times of the messages are their timestamps, see WithStartTime. The padding fields of
+// the formats are dropped.
+func ToBag(w io.Writer, r io.Reader, opts ...Option) (*Stats, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(0, int64(reader.StartTime)*int64(time.Microsecond))
+	if cfg.startTime != nil {
+		start = *cfg.startTime
+	}
+
+	toTime := func(timestamp uint64) time.Time {
+		return start.Add(time.Duration(int64(timestamp)-int64(reader.StartTime)) * time.Microsecond)
+	}
+
+	type bagTopic struct {
+		conn  uint32
+		spans []span
+	}
+
+	var stats Stats
+	writer := rosbag.NewWriter(w)
+	topics := make(map[*Subscription]*bagTopic)
+	logConn := -1
+	var buf []byte
+	for {
+		msg, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg := msg.(type) {
+		case *Subscription:
+			_, spans, err := layout(reader.Formats, msg.Format, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			def, err := Definition(reader.Formats, msg.Format)
+			if err != nil {
+				return nil, err
+			}
+
+			conn, err := writeConnection(writer, TopicName(msg), TypeName(msg.Format.Name), def)
+			if err != nil {
+				return nil, err
+			}
+
+			topics[msg] = &bagTopic{conn: conn, spans: spans}
+			stats.Topics++
+		case *Data:
+			topic := topics[msg.Subscription]
+			timestamp, err := msg.Timestamp()
+			if err != nil {
+				return nil, err
+			}
+
+			buf = buf[:0]
+			for _, s := range topic.spans {
+				buf = append(buf, msg.Data[s.off:s.off+s.size]...)
+			}
+
+			err = writer.WriteMessage(topic.conn, toTime(timestamp), buf)
+			if err != nil {
+				return nil, err
+			}
+			stats.Messages++
+		case *Logging:
+			if logConn == -1 {
+				conn, err := writeConnection(writer, LogTopic, logType, logDefinition)
+				if err != nil {
+					return nil, err
+				}
+				logConn = int(conn)
+			}
+
+			t := toTime(msg.Timestamp)
+			err = writer.WriteMessage(uint32(logConn), t, encodeLog(t, rosLevel(msg.Level), msg.Message))
+			if err != nil {
+				return nil, err
+			}
+			stats.Logs++
+		}
+	}
+	return &stats, writer.Close()
+}
+
+func writeConnection(writer *rosbag.Writer, topic, msgType, def string) (uint32, error) {
+	hdr := rosbag.ConnectionHeader{Topic: topic, Type: msgType, RawMessageDefinition: def}
+	conn, err := writer.WriteConnection(&hdr)
+	if err != nil {
+		return 0, err
+	}
+
+	// the definition has been parsed by WriteConnection
+	hdr.MD5Sum = hdr.MessageDefinition.MD5Sum()
+	return conn, nil
+}
+
+// encodeLog serializes a rosgraph_msgs/Log message.
+func encodeLog(t time.Time, level uint8, msg string) []byte {
+	var b []byte
+	appendUint32 := func(v uint32) {
+		var raw [4]byte
+		binary.LittleEndian.PutUint32(raw[:], v)
+		b = append(b, raw[:]...)
+	}
+	appendString := func(s string) {
+		appendUint32(uint32(len(s)))
+		b = append(b, s...)
+	}
+
+	appendUint32(0)
+	appendUint32(uint32(t.Unix()))
+	appendUint32(uint32(t.Nanosecond()))
+	appendString("")
+	b = append(b, level)
+	appendString(logNode)
+	appendString(msg)
+	appendString("")
+	appendString("")
+	appendUint32(0)
+	appendUint32(0)
+	return b
+}
+
+// rosLevel returns the rosgraph_msgs/Log level of a syslog level.
+func rosLevel(level uint8) uint8 {
+	switch {
+	case level <= LevelCritical:
+		return rosFatal
+	case level == LevelError:
+		return rosError
+	case level == LevelWarning:
+		return rosWarn
+	case level == LevelDebug:
+		return rosDebug
+	default:
+		return rosInfo
+	}
+}
+
+// syslogLevel returns the syslog level of a rosgraph_msgs/Log level.
+func syslogLevel(level uint8) uint8 {
+	switch {
+	case level >= rosFatal:
+		return LevelCritical
+	case level >= rosError:
+		return LevelError
+	case level >= rosWarn:
+		return LevelWarning
+	case level >= rosInfo:
+		return LevelInfo
+	default:
+		return LevelDebug
+	}
+}
+
+type logMessage struct {
+	// Level is a byte, which is an int8 in ROS1
+	Level int8   `rosbag:"level"`
+	Msg   string `rosbag:"msg"`
+}
+
+// ulogTopic is a topic of a bag that is converted to a subscription.
+type ulogTopic struct {
+	sub *Subscription
+	def *rosbag.MessageDefinition
+	// timestamp is true when the record times are prepended as the timestamps, since the messages
+	// don't start with a uint64 timestamp field
+	timestamp bool
+}
+
+type bagConverter struct {
+	formats map[string]*Format
+	// order is the order that the formats have been created in
+	order   []*Format
+	multi   map[string]int
+	topics  map[string]*ulogTopic
+	skipped map[string]bool
+}
+
+// FromBag runs decoder to the end, and writes the messages of the bag that can be converted to
+// ULog to w. Every topic is a subscription with the format of its type, see formatName, and the
+// topics of the same type are the instances of the subscription. String fields are dropped, since
+// ULog doesn't have strings, e.g. the frame of std_msgs/Header, and the topics with variable-length
+// arrays are skipped. Messages that don't start with a uint64 timestamp field, like the messages
+// of PX4, get their record times in microseconds as their timestamps. rosgraph_msgs/Log messages
+// are converted to logging messages.
+//
+// The formats of ULog must be written before the messages, so the messages are buffered in a
+// temporary file until the bag has been read.
+func FromBag(w io.Writer, decoder *rosbag.Decoder) (*Stats, error) {
+	tmp, err := ioutil.TempFile("", "rosbag-ulog-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	conv := bagConverter{
+		formats: make(map[string]*Format),
+		multi:   make(map[string]int),
+		topics:  make(map[string]*ulogTopic),
+		skipped: make(map[string]bool),
+	}
+
+	// the data section is written without the header, which is written with the formats
+	data := &Writer{w: bufio.NewWriterSize(tmp, 64*1024), formats: conv.formats, inData: true}
+	var stats Stats
+	var start uint64
+	var buf []byte
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := record.(*rosbag.RecordMessageData)
+		if !ok {
+			record.Close()
+			continue
+		}
+
+		buf, err = conv.add(data, msg, buf, &stats)
+		if err == nil && (start == 0 || micros(msg) < start) {
+			start = micros(msg)
+		}
+		msg.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = data.Close()
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	writer := NewWriter(w, start)
+	for _, format := range conv.order {
+		err = writer.WriteFormat(format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(w, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	for topic := range conv.skipped {
+		stats.SkippedTopics = append(stats.SkippedTopics, topic)
+	}
+	sort.Strings(stats.SkippedTopics)
+	return &stats, nil
+}
+
+// micros returns the record time of msg in microseconds.
+func micros(msg *rosbag.RecordMessageData) uint64 {
+	t, _ := msg.Time()
+	return uint64(t.UnixNano() / int64(time.Microsecond))
+}
+
+func (conv *bagConverter) add(data *Writer, msg *rosbag.RecordMessageData, buf []byte, stats *Stats) ([]byte, error) {
+	hdr := msg.ConnectionHeader()
+	if hdr.Type == logType {
+		var log logMessage
+		err := msg.ViewAs(&log)
+		if err != nil {
+			return buf, err
+		}
+
+		stats.Logs++
+		return buf, data.WriteLogging(syslogLevel(uint8(log.Level)), micros(msg), log.Msg)
+	}
+
+	if conv.skipped[hdr.Topic] {
+		return buf, nil
+	}
+
+	topic, ok := conv.topics[hdr.Topic]
+	if !ok {
+		var err error
+		topic, err = conv.addTopic(data, hdr)
+		if err == errVariableArray {
+			conv.skipped[hdr.Topic] = true
+			return buf, nil
+		}
+
+		if err != nil {
+			return buf, err
+		}
+		stats.Topics++
+	}
+
+	buf = buf[:0]
+	if topic.timestamp {
+		var raw [8]byte
+		binary.LittleEndian.PutUint64(raw[:], micros(msg))
+		buf = append(buf, raw[:]...)
+	}
+
+	p := packer{src: msg.Data(), dst: buf}
+	err := p.message(topic.def)
+	if err != nil {
+		return p.dst, err
+	}
+
+	stats.Messages++
+	return p.dst, data.WriteData(topic.sub, p.dst)
+}
+
+func (conv *bagConverter) addTopic(data *Writer, hdr *rosbag.ConnectionHeader) (*ulogTopic, error) {
+	def := &hdr.MessageDefinition
+	fields, err := conv.fields(def)
+	if err != nil {
+		return nil, err
+	}
+
+	topic := ulogTopic{def: def}
+	if len(def.Fields) == 0 || def.Fields[0].Name != timestampField || def.Fields[0].Type != rosbag.MessageFieldTypeUint64 || def.Fields[0].IsArray {
+		topic.timestamp = true
+		fields = append([]Field{{Type: "uint64_t", Name: timestampField}}, fields...)
+	}
+
+	format, err := conv.addFormat(&Format{Name: formatName(hdr.Type), Fields: fields})
+	if err != nil {
+		return nil, err
+	}
+
+	multiID := conv.multi[format.Name]
+	if multiID > 255 {
+		return nil, fmt.Errorf("%s has more than 256 topics", hdr.Type)
+	}
+	conv.multi[format.Name]++
+
+	topic.sub, err = data.AddSubscription(format.Name, uint8(multiID))
+	if err != nil {
+		return nil, err
+	}
+
+	conv.topics[hdr.Topic] = &topic
+	return &topic, nil
+}
+
+// addFormat adds format when it's new. Formats with the same name must have the same fields, e.g.
+// a type can't be a topic without a timestamp field, and a nested type of another topic.
+func (conv *bagConverter) addFormat(format *Format) (*Format, error) {
+	existing, ok := conv.formats[format.Name]
+	if !ok {
+		conv.formats[format.Name] = format
+		conv.order = append(conv.order, format)
+		return format, nil
+	}
+
+	if existing.String() != format.String() {
+		return nil, fmt.Errorf("format %s is defined differently by more than one type, %q and %q", format.Name, existing, format)
+	}
+	return existing, nil
+}
+
+// fields returns the ULog fields of the fields of def, and adds the formats of the nested types.
+// Strings are dropped, and so are the nested types that only have strings.
+func (conv *bagConverter) fields(def *rosbag.MessageDefinition) ([]Field, error) {
+	var fields []Field
+	for _, field := range def.Fields {
+		if field.IsArray && field.ArraySize < 0 {
+			return nil, errVariableArray
+		}
+
+		ulogField := Field{Name: field.Name}
+		if field.IsArray {
+			ulogField.ArraySize = field.ArraySize
+		}
+
+		switch field.Type {
+		case rosbag.MessageFieldTypeString, rosbag.MessageFieldTypeWString:
+			continue
+		case rosbag.MessageFieldTypeTime, rosbag.MessageFieldTypeDuration:
+			format := timeFormat
+			if field.Type == rosbag.MessageFieldTypeDuration {
+				format = durationFormat
+			}
+
+			_, err := conv.addFormat(format)
+			if err != nil {
+				return nil, err
+			}
+			ulogField.Type = format.Name
+		case rosbag.MessageFieldTypeComplex:
+			nestedFields, err := conv.fields(field.MsgType)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(nestedFields) == 0 {
+				continue
+			}
+
+			format, err := conv.addFormat(&Format{Name: formatName(field.MsgType.Type), Fields: nestedFields})
+			if err != nil {
+				return nil, err
+			}
+			ulogField.Type = format.Name
+		default:
+			ulogField.Type = ulogTypes[field.Type]
+			if field.DeclaredType == "char" {
+				ulogField.Type = "char"
+			}
+		}
+		fields = append(fields, ulogField)
+	}
+	return fields, nil
+}
+
+// packer copies the fixed-size fields of ROS1 serialized messages without variable-length
+// arrays, and drops the strings.
+type packer struct {
+	src []byte
+	dst []byte
+}
+
+func (p *packer) next(n int) ([]byte, error) {
+	if n < 0 || len(p.src) < n {
+		return nil, errShortROSData
+	}
+
+	b := p.src[:n]
+	p.src = p.src[n:]
+	return b, nil
+}
+
+func (p *packer) message(def *rosbag.MessageDefinition) error {
+	for _, field := range def.Fields {
+		count := 1
+		if field.IsArray {
+			count = field.ArraySize
+		}
+
+		if size, ok := rosSizes[field.Type]; ok {
+			b, err := p.next(size * count)
+			if err != nil {
+				return err
+			}
+			p.dst = append(p.dst, b...)
+			continue
+		}
+
+		for i := 0; i < count; i++ {
+			switch field.Type {
+			case rosbag.MessageFieldTypeComplex:
+				err := p.message(field.MsgType)
+				if err != nil {
+					return err
+				}
+			case rosbag.MessageFieldTypeString, rosbag.MessageFieldTypeWString:
+				b, err := p.next(4)
+				if err != nil {
+					return err
+				}
+
+				_, err = p.next(int(binary.LittleEndian.Uint32(b)))
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("field %s has an unsupported type %s", field.Name, field.Type)
+			}
+		}
+	}
+	return nil
+}
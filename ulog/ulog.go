@@ -0,0 +1,510 @@
+// Package ulog reads and writes PX4 ULog files, and converts them to and from bags, so that PX4
+// flight logs and ROS recordings of the same vehicle can be lined up with the same tools.
+//
+// ULog messages are packed little endian structs, which is the same serialization as ROS1 messages
+// with fixed-size fields, so the data is copied between the formats without decoding it. Only the
+// messages without strings and variable-length arrays can be converted from bags.
+//
+// Reference: https://docs.px4.io/main/en/dev_log/ulog_file_format.html
+package ulog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// magic is the start of every ULog file, which is followed by the version and the timestamp.
+var magic = []byte{'U', 'L', 'o', 'g', 0x01, 0x12, 0x35}
+
+const (
+	fileHeaderSize    = 16
+	messageHeaderSize = 3
+	flagBitsSize      = 40
+	paddingPrefix     = "_padding"
+	timestampField    = "timestamp"
+	// incompatDataAppended is the only incompatible flag, which marks data that is appended after
+	// the end of the log, and can be read in order
+	incompatDataAppended = 1
+)
+
+// The types of the ULog messages.
+const (
+	msgFlagBits         = 'B'
+	msgFormat           = 'F'
+	msgInfo             = 'I'
+	msgInfoMultiple     = 'M'
+	msgParameter        = 'P'
+	msgParameterDefault = 'Q'
+	msgAddLogged        = 'A'
+	msgRemoveLogged     = 'R'
+	msgData             = 'D'
+	msgLogging          = 'L'
+	msgLoggingTagged    = 'C'
+	msgSync             = 'S'
+	msgDropout          = 'O'
+)
+
+// typeSizes are the sizes of the primitive types of ULog.
+var typeSizes = map[string]int{
+	"int8_t":   1,
+	"uint8_t":  1,
+	"int16_t":  2,
+	"uint16_t": 2,
+	"int32_t":  4,
+	"uint32_t": 4,
+	"int64_t":  8,
+	"uint64_t": 8,
+	"float":    4,
+	"double":   8,
+	"bool":     1,
+	"char":     1,
+}
+
+var (
+	errNotULog          = errors.New("file is not a ULog, the magic bytes don't match")
+	errIncompatible     = errors.New("ULog has incompatible flags that are not supported")
+	errShortMessage     = errors.New("ULog message is shorter than its fields")
+	errNoTimestamp      = errors.New("data is shorter than its timestamp")
+	errUnknownMessageID = errors.New("data has a message ID that hasn't been added")
+)
+
+// Field is a field of a Format.
+type Field struct {
+	// Type is a primitive type, e.g. uint64_t or float, or the name of another format
+	Type string
+	Name string
+	// ArraySize is the length of a fixed-size array, it's 0 when the field is not an array
+	ArraySize int
+}
+
+// Format is the definition of the messages of a topic, or of a nested type.
+type Format struct {
+	Name   string
+	Fields []Field
+}
+
+// ParseFormat parses a format of the form "name:type field;type[n] field;...".
+func ParseFormat(s string) (*Format, error) {
+	colon := strings.IndexByte(s, ':')
+	if colon <= 0 {
+		return nil, fmt.Errorf("format must be name:fields, but got %q", s)
+	}
+
+	format := Format{Name: s[:colon]}
+	for _, raw := range strings.Split(s[colon+1:], ";") {
+		if raw == "" {
+			continue
+		}
+
+		space := strings.IndexByte(raw, ' ')
+		if space <= 0 {
+			return nil, fmt.Errorf("field of %s must be \"type name\", but got %q", format.Name, raw)
+		}
+
+		field := Field{Type: raw[:space], Name: raw[space+1:]}
+		if open := strings.IndexByte(field.Type, '['); open != -1 {
+			size, err := strconv.Atoi(strings.TrimSuffix(field.Type[open+1:], "]"))
+			if err != nil || size <= 0 || !strings.HasSuffix(field.Type, "]") {
+				return nil, fmt.Errorf("field %s of %s has an invalid array type %s", field.Name, format.Name, field.Type)
+			}
+			field.Type, field.ArraySize = field.Type[:open], size
+		}
+		format.Fields = append(format.Fields, field)
+	}
+	return &format, nil
+}
+
+// String returns format in the form of ParseFormat.
+func (format *Format) String() string {
+	var b strings.Builder
+	b.WriteString(format.Name)
+	b.WriteByte(':')
+	for _, field := range format.Fields {
+		b.WriteString(field.Type)
+		if field.ArraySize > 0 {
+			fmt.Fprintf(&b, "[%d]", field.ArraySize)
+		}
+		fmt.Fprintf(&b, " %s;", field.Name)
+	}
+	return b.String()
+}
+
+// isPadding reports whether field only aligns the next field, and doesn't have data.
+func (field *Field) isPadding() bool {
+	return strings.HasPrefix(field.Name, paddingPrefix)
+}
+
+// span is a range of the data of a format.
+type span struct {
+	off, size int
+}
+
+// layout returns the size of the messages of format, and the spans of the fields that are not
+// padding, where adjacent spans are merged. formats are the formats of the nested types, and depth
+// is the nesting depth of format.
+func layout(formats map[string]*Format, format *Format, depth int) (int, []span, error) {
+	// a format can't contain itself, so the nesting is at most as deep as the number of formats
+	if depth > len(formats) {
+		return 0, nil, fmt.Errorf("format %s contains itself", format.Name)
+	}
+
+	var size int
+	var spans []span
+	add := func(s span) {
+		if n := len(spans); n > 0 && spans[n-1].off+spans[n-1].size == s.off {
+			spans[n-1].size += s.size
+			return
+		}
+		spans = append(spans, s)
+	}
+
+	for _, field := range format.Fields {
+		count := field.ArraySize
+		if count == 0 {
+			count = 1
+		}
+
+		if elemSize, ok := typeSizes[field.Type]; ok {
+			if !field.isPadding() {
+				add(span{off: size, size: elemSize * count})
+			}
+			size += elemSize * count
+			continue
+		}
+
+		nested, ok := formats[field.Type]
+		if !ok {
+			return 0, nil, fmt.Errorf("field %s of %s has an unknown type %s", field.Name, format.Name, field.Type)
+		}
+
+		elemSize, elemSpans, err := layout(formats, nested, depth+1)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for i := 0; i < count; i++ {
+			for _, s := range elemSpans {
+				if !field.isPadding() {
+					add(span{off: size + s.off, size: s.size})
+				}
+			}
+			size += elemSize
+		}
+	}
+	return size, spans, nil
+}
+
+// Subscription is a topic that is logged, which is a format with an instance number.
+type Subscription struct {
+	MsgID uint16
+	// MultiID is the instance of the topic when there are more than one, e.g. for 2 GPS receivers
+	MultiID uint8
+	Format  *Format
+	// size is the size of the data of the format
+	size int
+}
+
+// Data is a logged message of a subscription.
+type Data struct {
+	Subscription *Subscription
+	// Data is the serialized message, which starts with the timestamp field. It's only valid until
+	// the next Read
+	Data []byte
+}
+
+// Timestamp returns the timestamp of the message in microseconds, which is the first field of
+// every topic.
+func (data *Data) Timestamp() (uint64, error) {
+	if len(data.Data) < 8 {
+		return 0, errNoTimestamp
+	}
+	return binary.LittleEndian.Uint64(data.Data), nil
+}
+
+// Logging is a string message of the log, like a ROS log message.
+type Logging struct {
+	// Level is the syslog level from 0 (emergency) to 7 (debug)
+	Level uint8
+	// Tag is the tag of a tagged logging message, it's 0 for plain logging messages
+	Tag uint16
+	// Timestamp is in microseconds
+	Timestamp uint64
+	Message   string
+}
+
+// The syslog levels of Logging.
+const (
+	LevelEmergency uint8 = iota
+	LevelAlert
+	LevelCritical
+	LevelError
+	LevelWarning
+	LevelNotice
+	LevelInfo
+	LevelDebug
+)
+
+// Reader reads the messages of a ULog file in order.
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+	// Version is the version of the file format
+	Version uint8
+	// StartTime is the timestamp of the start of the log in microseconds, which is on the same
+	// clock as the timestamps of the messages
+	StartTime uint64
+	// Formats are the formats of the definitions section by their names
+	Formats map[string]*Format
+	// Info and Params are the information messages and the parameters by their names, they're
+	// updated when they change in the data section. The values have the Go types of the ULog types,
+	// char arrays are strings, and the values of other arrays are []byte
+	Info   map[string]interface{}
+	Params map[string]interface{}
+	// subscriptions are the added subscriptions by their message IDs
+	subscriptions map[uint16]*Subscription
+	// pending is the first message of the data section, which ends the definitions section
+	pending interface{}
+}
+
+// NewReader reads the header and the definitions section of the ULog file in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := Reader{
+		r:             bufio.NewReaderSize(r, 64*1024),
+		Formats:       make(map[string]*Format),
+		Info:          make(map[string]interface{}),
+		Params:        make(map[string]interface{}),
+		subscriptions: make(map[uint16]*Subscription),
+	}
+
+	var hdr [fileHeaderSize]byte
+	_, err := io.ReadFull(reader.r, hdr[:])
+	if err != nil || !bytes.Equal(hdr[:len(magic)], magic) {
+		return nil, errNotULog
+	}
+	reader.Version = hdr[len(magic)]
+	reader.StartTime = binary.LittleEndian.Uint64(hdr[8:])
+
+	for {
+		msg, err := reader.next()
+		// a log can end in the definitions section
+		if err == io.EOF {
+			return &reader, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg != nil {
+			reader.pending = msg
+			return &reader, nil
+		}
+	}
+}
+
+// Read returns the next message of the data section, which is a *Subscription when a topic is
+// added, a *Data, or a *Logging. Parameter and information changes are applied to Params and Info.
+// Read returns io.EOF at the end of the log, including when the last message is truncated, which
+// happens when the logger is stopped by a power loss.
+func (reader *Reader) Read() (interface{}, error) {
+	if reader.pending != nil {
+		msg := reader.pending
+		reader.pending = nil
+		return msg, nil
+	}
+
+	for {
+		msg, err := reader.next()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+	}
+}
+
+// next reads the next ULog message. It returns nil when the message only updates the reader.
+func (reader *Reader) next() (interface{}, error) {
+	var hdr [messageHeaderSize]byte
+	_, err := io.ReadFull(reader.r, hdr[:])
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint16(hdr[:]))
+	if cap(reader.buf) < size {
+		reader.buf = make([]byte, size)
+	}
+	payload := reader.buf[:size]
+	_, err = io.ReadFull(reader.r, payload)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, io.EOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch msgType := hdr[2]; msgType {
+	case msgFlagBits:
+		if len(payload) < flagBitsSize {
+			return nil, errShortMessage
+		}
+
+		incompat := payload[8:16]
+		if incompat[0]&^incompatDataAppended != 0 || !allZero(incompat[1:]) {
+			return nil, errIncompatible
+		}
+	case msgFormat:
+		format, err := ParseFormat(string(payload))
+		if err != nil {
+			return nil, err
+		}
+		reader.Formats[format.Name] = format
+	case msgInfo, msgParameter:
+		key, value, err := decodeKeyValue(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if msgType == msgInfo {
+			reader.Info[key] = value
+		} else {
+			reader.Params[key] = value
+		}
+	case msgAddLogged:
+		if len(payload) < 3 {
+			return nil, errShortMessage
+		}
+
+		name := string(payload[3:])
+		format, ok := reader.Formats[name]
+		if !ok {
+			return nil, fmt.Errorf("topic %s doesn't have a format", name)
+		}
+
+		size, _, err := layout(reader.Formats, format, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		sub := &Subscription{MultiID: payload[0], MsgID: binary.LittleEndian.Uint16(payload[1:]), Format: format, size: size}
+		reader.subscriptions[sub.MsgID] = sub
+		return sub, nil
+	case msgData:
+		if len(payload) < 2 {
+			return nil, errShortMessage
+		}
+
+		sub, ok := reader.subscriptions[binary.LittleEndian.Uint16(payload)]
+		if !ok {
+			return nil, errUnknownMessageID
+		}
+
+		if len(payload)-2 < sub.size {
+			return nil, errShortMessage
+		}
+		return &Data{Subscription: sub, Data: payload[2 : 2+sub.size]}, nil
+	case msgLogging:
+		if len(payload) < 9 {
+			return nil, errShortMessage
+		}
+		return &Logging{Level: logLevel(payload[0]), Timestamp: binary.LittleEndian.Uint64(payload[1:]), Message: string(payload[9:])}, nil
+	case msgLoggingTagged:
+		if len(payload) < 11 {
+			return nil, errShortMessage
+		}
+
+		return &Logging{
+			Level:     logLevel(payload[0]),
+			Tag:       binary.LittleEndian.Uint16(payload[1:]),
+			Timestamp: binary.LittleEndian.Uint64(payload[3:]),
+			Message:   string(payload[11:]),
+		}, nil
+	case msgRemoveLogged:
+		if len(payload) >= 2 {
+			delete(reader.subscriptions, binary.LittleEndian.Uint16(payload))
+		}
+	}
+
+	// the other messages, e.g. sync and dropout messages, and the messages of newer versions of the
+	// format, are skipped
+	return nil, nil
+}
+
+// logLevel returns the syslog level of a logging message, which is written as an ASCII digit.
+func logLevel(level byte) uint8 {
+	if level >= '0' && level <= '7' {
+		return level - '0'
+	}
+	return level
+}
+
+func allZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeKeyValue decodes the payload of an information or a parameter message, whose key is the
+// type and the name of the value, e.g. "char[5] sys_name".
+func decodeKeyValue(payload []byte) (string, interface{}, error) {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return "", nil, errShortMessage
+	}
+
+	key := string(payload[1 : 1+payload[0]])
+	raw := payload[1+payload[0]:]
+	space := strings.IndexByte(key, ' ')
+	if space <= 0 {
+		return "", nil, fmt.Errorf("key must be \"type name\", but got %q", key)
+	}
+
+	typ, name := key[:space], key[space+1:]
+	if strings.HasPrefix(typ, "char[") {
+		return name, string(raw), nil
+	}
+
+	if size, ok := typeSizes[typ]; !ok || len(raw) < size {
+		return name, append([]byte(nil), raw...), nil
+	}
+
+	var value interface{}
+	switch typ {
+	case "int8_t":
+		value = int8(raw[0])
+	case "uint8_t", "char":
+		value = raw[0]
+	case "bool":
+		value = raw[0] != 0
+	case "int16_t":
+		value = int16(binary.LittleEndian.Uint16(raw))
+	case "uint16_t":
+		value = binary.LittleEndian.Uint16(raw)
+	case "int32_t":
+		value = int32(binary.LittleEndian.Uint32(raw))
+	case "uint32_t":
+		value = binary.LittleEndian.Uint32(raw)
+	case "int64_t":
+		value = int64(binary.LittleEndian.Uint64(raw))
+	case "uint64_t":
+		value = binary.LittleEndian.Uint64(raw)
+	case "float":
+		value = math.Float32frombits(binary.LittleEndian.Uint32(raw))
+	case "double":
+		value = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+	}
+	return name, value, nil
+}
@@ -0,0 +1,498 @@
+package ulog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lherman-cs/go-rosbag"
+)
+
+const testStartTime = 1000000
+
+var testFormats = []string{
+	"vec3:float x;float y;float z;",
+	"vehicle_status:uint64_t timestamp;uint8_t arming_state;uint8_t[3] _padding0;vec3 position;int16_t[2] rpm;",
+}
+
+type testEncoder struct {
+	b []byte
+}
+
+func (enc *testEncoder) uint64(v uint64) *testEncoder {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	enc.b = append(enc.b, b[:]...)
+	return enc
+}
+
+func (enc *testEncoder) uint32(v uint32) *testEncoder {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	enc.b = append(enc.b, b[:]...)
+	return enc
+}
+
+func (enc *testEncoder) string(s string) *testEncoder {
+	enc.uint32(uint32(len(s)))
+	enc.b = append(enc.b, s...)
+	return enc
+}
+
+func (enc *testEncoder) float32s(vs ...float32) *testEncoder {
+	for _, v := range vs {
+		enc.uint32(math.Float32bits(v))
+	}
+	return enc
+}
+
+func (enc *testEncoder) float64s(vs ...float64) *testEncoder {
+	for _, v := range vs {
+		enc.uint64(math.Float64bits(v))
+	}
+	return enc
+}
+
+// vehicleStatus encodes a vehicle_status message, with the padding when padding is true.
+func vehicleStatus(timestamp uint64, armingState uint8, padding bool) []byte {
+	enc := new(testEncoder).uint64(timestamp)
+	enc.b = append(enc.b, armingState)
+	if padding {
+		enc.b = append(enc.b, 0xaa, 0xaa, 0xaa)
+	}
+	enc.float32s(1, 2, float32(armingState))
+	enc.b = append(enc.b, byte(armingState), 0, 0xff, 0xff)
+	return enc.b
+}
+
+func writeTestULog(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, testStartTime)
+	for _, s := range testFormats {
+		format, err := ParseFormat(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.WriteFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.WriteInfo("sys_name", "PX4")
+	if err == nil {
+		err = writer.WriteInfo("ver_hw_rev", uint32(3))
+	}
+	if err == nil {
+		err = writer.WriteParam("MAV_SYS_ID", int32(1))
+	}
+	if err == nil {
+		err = writer.WriteParam("MPC_XY_VEL_MAX", float32(12.5))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub0, err := writer.AddSubscription("vehicle_status", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub1, err := writer.AddSubscription("vehicle_status", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.WriteData(sub0, vehicleStatus(testStartTime+10, 1, true))
+	if err == nil {
+		err = writer.WriteLogging(LevelWarning, testStartTime+20, "low battery")
+	}
+	if err == nil {
+		err = writer.WriteData(sub1, vehicleStatus(testStartTime+30, 2, true))
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, reader *Reader) []interface{} {
+	var msgs []interface{}
+	for {
+		msg, err := reader.Read()
+		if err == io.EOF {
+			return msgs
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data, ok := msg.(*Data); ok {
+			data.Data = append([]byte(nil), data.Data...)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	reader, err := NewReader(bytes.NewReader(writeTestULog(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Version != 1 || reader.StartTime != testStartTime {
+		t.Fatalf("expected version 1 at %d, but got %d at %d", testStartTime, reader.Version, reader.StartTime)
+	}
+
+	for _, s := range testFormats {
+		format, _ := ParseFormat(s)
+		if reader.Formats[format.Name].String() != s {
+			t.Fatalf("expected format %s, but got %s", s, reader.Formats[format.Name])
+		}
+	}
+
+	expectedInfo := map[string]interface{}{"sys_name": "PX4", "ver_hw_rev": uint32(3)}
+	if diff := cmp.Diff(expectedInfo, reader.Info); diff != "" {
+		t.Fatal(diff)
+	}
+
+	expectedParams := map[string]interface{}{"MAV_SYS_ID": int32(1), "MPC_XY_VEL_MAX": float32(12.5)}
+	if diff := cmp.Diff(expectedParams, reader.Params); diff != "" {
+		t.Fatal(diff)
+	}
+
+	status := reader.Formats["vehicle_status"]
+	sub0 := &Subscription{MsgID: 0, MultiID: 0, Format: status, size: 28}
+	sub1 := &Subscription{MsgID: 1, MultiID: 1, Format: status, size: 28}
+	expected := []interface{}{
+		sub0,
+		sub1,
+		&Data{Subscription: sub0, Data: vehicleStatus(testStartTime+10, 1, true)},
+		&Logging{Level: LevelWarning, Timestamp: testStartTime + 20, Message: "low battery"},
+		&Data{Subscription: sub1, Data: vehicleStatus(testStartTime+30, 2, true)},
+	}
+	if diff := cmp.Diff(expected, readAll(t, reader), cmp.AllowUnexported(Subscription{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	b := writeTestULog(t)
+	reader, err := NewReader(bytes.NewReader(b[:len(b)-5]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last data message is cut off by the power loss
+	msgs := readAll(t, reader)
+	if len(msgs) != 4 {
+		t.Fatalf("expected 4 messages, but got %d", len(msgs))
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("#ROSBAG V2.0\n")))
+	if err != errNotULog {
+		t.Fatalf("expected %v, but got %v", errNotULog, err)
+	}
+
+	b := writeTestULog(t)
+	// the incompat flags follow the compat flags, after the file and the message headers
+	b[fileHeaderSize+messageHeaderSize+8] = 2
+	_, err = NewReader(bytes.NewReader(b))
+	if err != errIncompatible {
+		t.Fatalf("expected %v, but got %v", errIncompatible, err)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	writer := NewWriter(ioutil.Discard, 0)
+	format, _ := ParseFormat(testFormats[0])
+	err := writer.WriteFormat(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = writer.AddSubscription("vehicle_status", 0)
+	if err == nil {
+		t.Fatal("expected an error for a subscription without a format")
+	}
+
+	sub, err := writer.AddSubscription("vec3", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.WriteFormat(format)
+	if err != errDefinitionsWritten {
+		t.Fatalf("expected %v, but got %v", errDefinitionsWritten, err)
+	}
+
+	err = writer.WriteData(sub, make([]byte, 8))
+	if err == nil {
+		t.Fatal("expected an error for data with the wrong size")
+	}
+}
+
+func TestToBag(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	stats, err := ToBag(&buf, bytes.NewReader(writeTestULog(t)), WithStartTime(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Stats{Topics: 2, Messages: 2, Logs: 1}, stats); diff != "" {
+		t.Fatal(diff)
+	}
+
+	findings, err := rosbag.Check(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected a valid bag, but got %v", findings)
+	}
+
+	type message struct {
+		Topic string
+		Type  string
+		Time  time.Time
+		Data  []byte
+	}
+
+	var msgs []message
+	var log struct {
+		Level int8   `rosbag:"level"`
+		Msg   string `rosbag:"msg,copy"`
+	}
+	decoder := rosbag.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*rosbag.RecordMessageData); ok {
+			hdr := msg.ConnectionHeader()
+			if hdr.Type == logType {
+				err = msg.ViewAs(&log)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			msgTime, _ := msg.Time()
+			msgs = append(msgs, message{hdr.Topic, hdr.Type, msgTime, append([]byte(nil), msg.Data()...)})
+		}
+		record.Close()
+	}
+
+	// the log message is compared by its fields
+	msgs[1].Data = nil
+	expected := []message{
+		{"/vehicle_status", "px4_msgs/VehicleStatus", start.Add(10 * time.Microsecond), vehicleStatus(testStartTime+10, 1, false)},
+		{"/rosout", "rosgraph_msgs/Log", start.Add(20 * time.Microsecond), nil},
+		{"/vehicle_status_1", "px4_msgs/VehicleStatus", start.Add(30 * time.Microsecond), vehicleStatus(testStartTime+30, 2, false)},
+	}
+	if diff := cmp.Diff(expected, msgs, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if log.Level != int8(rosWarn) || log.Msg != "low battery" {
+		t.Fatalf("expected a warning \"low battery\", but got %d %q", log.Level, log.Msg)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	formats := make(map[string]*Format)
+	for _, s := range testFormats {
+		format, _ := ParseFormat(s)
+		formats[format.Name] = format
+	}
+
+	def, err := Definition(formats, formats["vehicle_status"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `uint64 timestamp
+uint8 arming_state
+px4_msgs/Vec3 position
+int16[2] rpm
+================================================================================
+MSG: px4_msgs/Vec3
+float32 x
+float32 y
+float32 z
+`
+	if diff := cmp.Diff(expected, def); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	testCases := map[string]string{
+		"px4_msgs/VehicleStatus":  "vehicle_status",
+		"sensor_msgs/NavSatFix":   "nav_sat_fix",
+		"px4_msgs/Px4ioStatus":    "px4io_status",
+		"px4_msgs/VehicleGPSData": "vehicle_gps_data",
+		"std_msgs/Header":         "header",
+	}
+	for msgType, expected := range testCases {
+		if name := formatName(msgType); name != expected {
+			t.Errorf("expected %s for %s, but got %s", expected, msgType, name)
+		}
+	}
+}
+
+func TestFromBag(t *testing.T) {
+	var bag bytes.Buffer
+	_, err := ToBag(&bag, bytes.NewReader(writeTestULog(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	stats, err := FromBag(&buf, rosbag.NewDecoder(&bag))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Stats{Topics: 2, Messages: 2, Logs: 1}, stats); diff != "" {
+		t.Fatal(diff)
+	}
+
+	reader, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.StartTime != testStartTime+10 {
+		t.Fatalf("expected the start time %d, but got %d", testStartTime+10, reader.StartTime)
+	}
+
+	// the padding has been dropped
+	status := &Format{Name: "vehicle_status", Fields: []Field{
+		{Type: "uint64_t", Name: "timestamp"},
+		{Type: "uint8_t", Name: "arming_state"},
+		{Type: "vec3", Name: "position"},
+		{Type: "int16_t", Name: "rpm", ArraySize: 2},
+	}}
+	sub0 := &Subscription{MsgID: 0, MultiID: 0, Format: status, size: 25}
+	sub1 := &Subscription{MsgID: 1, MultiID: 1, Format: status, size: 25}
+	expected := []interface{}{
+		sub0,
+		&Data{Subscription: sub0, Data: vehicleStatus(testStartTime+10, 1, false)},
+		&Logging{Level: LevelWarning, Timestamp: testStartTime + 20, Message: "low battery"},
+		sub1,
+		&Data{Subscription: sub1, Data: vehicleStatus(testStartTime+30, 2, false)},
+	}
+	if diff := cmp.Diff(expected, readAll(t, reader), cmp.AllowUnexported(Subscription{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestFromBagROSTypes(t *testing.T) {
+	definitions := map[string]string{
+		"geometry_msgs/PointStamped": `std_msgs/Header header
+geometry_msgs/Point point
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+================================================================================
+MSG: geometry_msgs/Point
+float64 x
+float64 y
+float64 z
+`,
+		"sensor_msgs/PointCloud": "float32[] points\n",
+	}
+
+	var bag bytes.Buffer
+	writer := rosbag.NewWriter(&bag)
+	write := func(topic, msgType string, t0 time.Time, data []byte) {
+		conn, err := writer.WriteConnection(&rosbag.ConnectionHeader{
+			Topic:                topic,
+			Type:                 msgType,
+			RawMessageDefinition: definitions[msgType],
+		})
+		if err == nil {
+			err = writer.WriteMessage(conn, t0, data)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t0 := time.Unix(5, 2000)
+	point := new(testEncoder).uint32(7).uint32(5).uint32(1000).string("map").float64s(1, 2, 3)
+	write("/point", "geometry_msgs/PointStamped", t0, point.b)
+	write("/cloud", "sensor_msgs/PointCloud", t0, new(testEncoder).uint32(1).float32s(1).b)
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	stats, err := FromBag(&buf, rosbag.NewDecoder(&bag))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Stats{Topics: 1, Messages: 1, SkippedTopics: []string{"/cloud"}}, stats); diff != "" {
+		t.Fatal(diff)
+	}
+
+	reader, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var formats []string
+	for _, format := range reader.Formats {
+		formats = append(formats, format.String())
+	}
+
+	expectedFormats := []string{
+		"header:uint32_t seq;ros_time stamp;",
+		"point:double x;double y;double z;",
+		"point_stamped:uint64_t timestamp;header header;point point;",
+		"ros_time:uint32_t sec;uint32_t nsec;",
+	}
+	if diff := cmp.Diff(expectedFormats, formats, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Fatal(diff)
+	}
+
+	msgs := readAll(t, reader)
+	if len(msgs) != 2 {
+		t.Fatalf("expected a subscription and a message, but got %d messages", len(msgs))
+	}
+
+	// the frame is dropped, and the record time is prepended as the timestamp
+	expectedData := new(testEncoder).uint64(5000002).uint32(7).uint32(5).uint32(1000).float64s(1, 2, 3).b
+	if diff := cmp.Diff(expectedData, msgs[1].(*Data).Data); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,221 @@
+package ulog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var (
+	errDefinitionsWritten = errors.New("formats and parameters must be written before the first subscription")
+	errMessageTooLong     = errors.New("ULog message is longer than 65535 bytes")
+)
+
+// Writer writes a ULog file. The formats, the information messages, and the parameters are the
+// definitions section, so they must be written before the first subscription is added.
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+	// inData is true after the first message of the data section has been written
+	inData  bool
+	formats map[string]*Format
+	subs    []*Subscription
+	err     error
+}
+
+// NewWriter writes the header of a ULog file with the start time of the log in microseconds to w.
+// The writer must be closed to flush the messages.
+func NewWriter(w io.Writer, startTime uint64) *Writer {
+	writer := Writer{
+		w:       bufio.NewWriterSize(w, 64*1024),
+		formats: make(map[string]*Format),
+	}
+
+	var hdr [fileHeaderSize]byte
+	copy(hdr[:], magic)
+	hdr[len(magic)] = 1
+	binary.LittleEndian.PutUint64(hdr[8:], startTime)
+	_, writer.err = writer.w.Write(hdr[:])
+
+	// the flag bits are written without flags, so that readers can check them
+	writer.writeMessage(msgFlagBits, make([]byte, flagBitsSize))
+	return &writer
+}
+
+func (writer *Writer) writeMessage(msgType byte, payload []byte) error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	if len(payload) > math.MaxUint16 {
+		return errMessageTooLong
+	}
+
+	var hdr [messageHeaderSize]byte
+	binary.LittleEndian.PutUint16(hdr[:], uint16(len(payload)))
+	hdr[2] = msgType
+	_, writer.err = writer.w.Write(hdr[:])
+	if writer.err == nil {
+		_, writer.err = writer.w.Write(payload)
+	}
+	return writer.err
+}
+
+func (writer *Writer) definition(msgType byte, payload []byte) error {
+	if writer.inData {
+		return errDefinitionsWritten
+	}
+	return writer.writeMessage(msgType, payload)
+}
+
+// WriteFormat writes the format of a topic, or of a nested type.
+func (writer *Writer) WriteFormat(format *Format) error {
+	err := writer.definition(msgFormat, []byte(format.String()))
+	if err != nil {
+		return err
+	}
+
+	writer.formats[format.Name] = format
+	return nil
+}
+
+// WriteInfo writes an information message, e.g. WriteInfo("sys_name", "PX4"). value must be a
+// string, or a value of a primitive Go type that matches a ULog type, e.g. uint32 or float32.
+func (writer *Writer) WriteInfo(name string, value interface{}) error {
+	payload, err := encodeKeyValue(name, value)
+	if err != nil {
+		return err
+	}
+	return writer.definition(msgInfo, payload)
+}
+
+// WriteParam writes the value of a parameter, which is an int32 or a float32.
+func (writer *Writer) WriteParam(name string, value interface{}) error {
+	switch value.(type) {
+	case int32, float32:
+	default:
+		return fmt.Errorf("parameter %s must be an int32 or a float32, but got %T", name, value)
+	}
+
+	payload, err := encodeKeyValue(name, value)
+	if err != nil {
+		return err
+	}
+	return writer.definition(msgParameter, payload)
+}
+
+// AddSubscription adds a topic with the format name, and returns its subscription, which is
+// passed to WriteData.
+func (writer *Writer) AddSubscription(name string, multiID uint8) (*Subscription, error) {
+	format, ok := writer.formats[name]
+	if !ok {
+		return nil, fmt.Errorf("topic %s doesn't have a format", name)
+	}
+
+	size, _, err := layout(writer.formats, format, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(writer.subs) > math.MaxUint16 {
+		return nil, errors.New("ULog can't have more than 65536 subscriptions")
+	}
+
+	sub := &Subscription{MsgID: uint16(len(writer.subs)), MultiID: multiID, Format: format, size: size}
+	payload := append([]byte{multiID, 0, 0}, name...)
+	binary.LittleEndian.PutUint16(payload[1:], sub.MsgID)
+	writer.inData = true
+	err = writer.writeMessage(msgAddLogged, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	writer.subs = append(writer.subs, sub)
+	return sub, nil
+}
+
+// WriteData writes a message of sub, data must be serialized with its format, and start with the
+// timestamp in microseconds.
+func (writer *Writer) WriteData(sub *Subscription, data []byte) error {
+	if len(data) != sub.size {
+		return fmt.Errorf("data of %s must have %d bytes, but got %d", sub.Format.Name, sub.size, len(data))
+	}
+
+	writer.buf = append(writer.buf[:0], 0, 0)
+	binary.LittleEndian.PutUint16(writer.buf, sub.MsgID)
+	writer.buf = append(writer.buf, data...)
+	return writer.writeMessage(msgData, writer.buf)
+}
+
+// WriteLogging writes a logging message at the syslog level at timestamp in microseconds.
+func (writer *Writer) WriteLogging(level uint8, timestamp uint64, message string) error {
+	writer.buf = append(writer.buf[:0], '0'+level, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(writer.buf[1:], timestamp)
+	writer.buf = append(writer.buf, message...)
+	writer.inData = true
+	return writer.writeMessage(msgLogging, writer.buf)
+}
+
+// Close flushes the buffered messages to the underlying writer.
+func (writer *Writer) Close() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	return writer.w.Flush()
+}
+
+// encodeKeyValue encodes the payload of an information or a parameter message.
+func encodeKeyValue(name string, value interface{}) ([]byte, error) {
+	var typ string
+	var bits uint64
+	switch v := value.(type) {
+	case string:
+		typ = fmt.Sprintf("char[%d]", len(v))
+	case bool:
+		typ = "bool"
+		if v {
+			bits = 1
+		}
+	case int8:
+		typ, bits = "int8_t", uint64(v)
+	case uint8:
+		typ, bits = "uint8_t", uint64(v)
+	case int16:
+		typ, bits = "int16_t", uint64(v)
+	case uint16:
+		typ, bits = "uint16_t", uint64(v)
+	case int32:
+		typ, bits = "int32_t", uint64(v)
+	case uint32:
+		typ, bits = "uint32_t", uint64(v)
+	case int64:
+		typ, bits = "int64_t", uint64(v)
+	case uint64:
+		typ, bits = "uint64_t", v
+	case float32:
+		typ, bits = "float", uint64(math.Float32bits(v))
+	case float64:
+		typ, bits = "double", math.Float64bits(v)
+	default:
+		return nil, fmt.Errorf("value of %s has an unsupported type %T", name, value)
+	}
+
+	raw, ok := value.(string)
+	if !ok {
+		// the little endian bytes of bits are the value truncated to the size of the type
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], bits)
+		raw = string(b[:typeSizes[typ]])
+	}
+
+	key := typ + " " + name
+	if len(key) > math.MaxUint8 {
+		return nil, fmt.Errorf("key %q is longer than 255 bytes", key)
+	}
+
+	payload := append([]byte{byte(len(key))}, key...)
+	return append(payload, raw...), nil
+}
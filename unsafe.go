@@ -0,0 +1,149 @@
+//go:build !purego && !safe
+// +build !purego,!safe
+
+package rosbag
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// fieldDecodeSliceFastHelper decodes numeric slices without a copy by pointing them to the raw
+// buffer. They're only used when the host has the same byte order as the bag.
+var fieldDecodeSliceFastHelper = map[MessageFieldType]fieldDecodeFunc{
+	MessageFieldTypeInt16:   fieldDecodeInt16Slice,
+	MessageFieldTypeUint16:  fieldDecodeUint16Slice,
+	MessageFieldTypeInt32:   fieldDecodeInt32Slice,
+	MessageFieldTypeUint32:  fieldDecodeUint32Slice,
+	MessageFieldTypeInt64:   fieldDecodeInt64Slice,
+	MessageFieldTypeUint64:  fieldDecodeUint64Slice,
+	MessageFieldTypeFloat32: fieldDecodeFloat32Slice,
+	MessageFieldTypeFloat64: fieldDecodeFloat64Slice,
+}
+
+// detectHostEndian reads a known uint16 through its memory to find out the byte order of the host.
+// It returns nil when the byte order is unknown.
+func detectHostEndian() binary.ByteOrder {
+	switch *(*uint16)(unsafe.Pointer(&([]byte{0x12, 0x34}[0]))) {
+	case 0x1234:
+		return binary.BigEndian
+	case 0x3412:
+		return binary.LittleEndian
+	}
+	return nil
+}
+
+// viewString returns a string that shares the memory of raw.
+func viewString(raw []byte) string {
+	return unsafe.String(&raw[0], len(raw))
+}
+
+// viewSlice returns a slice that starts at raw[0] with a len and cap of length. raw must have at
+// least length bytes.
+func viewSlice(raw []byte, length int) []byte {
+	return unsafe.Slice(&raw[0], length)
+}
+
+func fieldDecodeBasicSlice(raw []byte, ptr unsafe.Pointer, length int, size int) (off int, ok bool) {
+	length, off, ok = fieldDecodeSliceLength(raw, length, size)
+	if !ok || length == 0 {
+		return
+	}
+
+	raw = raw[off:]
+
+	// ptr points to a slice of an element type that has the same layout as size bytes, so it can
+	// be set through a []byte that has the same len and cap
+	*(*[]byte)(ptr) = viewSlice(raw, length)
+	off += length * size
+	ok = true
+	return
+}
+
+func fieldDecodeBoolSlice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []bool
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
+	v = b
+	return
+}
+
+func fieldDecodeInt8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []int8
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
+	v = b
+	return
+}
+
+func fieldDecodeUint8Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []uint8
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 1)
+	v = b
+	return
+}
+
+func fieldDecodeInt16Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []int16
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 2)
+	v = b
+	return
+}
+
+func fieldDecodeUint16Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []uint16
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 2)
+	v = b
+	return
+}
+
+func fieldDecodeInt32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []int32
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
+	v = b
+	return
+}
+
+func fieldDecodeUint32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []uint32
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
+	v = b
+	return
+}
+
+func fieldDecodeInt64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []int64
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
+	v = b
+	return
+}
+
+func fieldDecodeUint64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []uint64
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
+	v = b
+	return
+}
+
+func fieldDecodeFloat32Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []float32
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 4)
+	v = b
+	return
+}
+
+func fieldDecodeFloat64Slice(raw []byte, length int) (v interface{}, off int, ok bool) {
+	var b []float64
+
+	off, ok = fieldDecodeBasicSlice(raw, unsafe.Pointer(&b), length, 8)
+	v = b
+	return
+}
@@ -0,0 +1,644 @@
+package rosbag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	defaultChunkSize = 768 * 1024
+	// bagHeaderLen is the total length of the bag header record. The bag header is padded so that
+	// it can be rewritten in place.
+	bagHeaderLen = 4096
+)
+
+var (
+	errUnknownConnection = errors.New("unknown connection, the connection must be written first")
+	errWriterClosed      = errors.New("writer is closed")
+	errBagHeaderTooLong  = fmt.Errorf("bag header fields are longer than %d bytes", bagHeaderLen)
+)
+
+// WriterOption configures an optional behavior of a Writer.
+type WriterOption func(*writerConfig)
+
+type writerConfig struct {
+	chunkSize       int
+	compression     Compression
+	headerStampTime bool
+	encryptor       Encryptor
+	clock           *ClockCorrection
+	topicClocks     map[string]*ClockCorrection
+}
+
+// WithChunkSize sets the size of the uncompressed chunk data in bytes after which the current
+// chunk is written out, and a new chunk is started. The default is 768 KB.
+func WithChunkSize(size int) WriterOption {
+	return func(config *writerConfig) {
+		config.chunkSize = size
+	}
+}
+
+// WithCompression compresses the chunks with compression, which is CompressionNone,
+// CompressionLZ4, CompressionBZ2, CompressionSnappy, or a compression that's registered with
+// RegisterCompression. The chunks are uncompressed by default. Other compressions fail the first
+// write.
+func WithCompression(compression Compression) WriterOption {
+	return func(config *writerConfig) {
+		config.compression = compression
+	}
+}
+
+// WithEncryptor encrypts the chunks with encryptor, and writes its name and fields to the bag
+// header. NewAESCBCEncryptor creates the stock encryptor of rosbag, so that the bag can be
+// decrypted by rosbag with the GPG key of the user.
+func WithEncryptor(encryptor Encryptor) WriterOption {
+	return func(config *writerConfig) {
+		config.encryptor = encryptor
+	}
+}
+
+// WithHeaderStampTime makes the writer use the std_msgs/Header stamp of a message as its record
+// time instead of the time passed to WriteMessage. It only applies to messages whose first field
+// is a std_msgs/Header with a non-zero stamp, other messages keep the given time. This keeps
+// rewritten bags aligned with the sensor timestamps on playback.
+func WithHeaderStampTime() WriterOption {
+	return func(config *writerConfig) {
+		config.headerStampTime = true
+	}
+}
+
+type writerConnection struct {
+	header *ConnectionHeader
+	// hasHeader marks that the message starts with a std_msgs/Header
+	hasHeader bool
+	// clock corrects the times of the messages, it's nil when they're not corrected
+	clock *ClockCorrection
+	// written marks that the connection record has been written to a chunk
+	written bool
+}
+
+// Writer writes a rosbag to an underlying writer. Connections are registered with
+// WriteConnection, and their messages are written with WriteMessage. The records are grouped in
+// chunks, which are compressed when a compression is given, and then encrypted when an Encryptor
+// is given. Every chunk is followed by the index data records of its messages.
+//
+// Close must be called to write out the last chunk and the index section. When the underlying
+// writer is an io.WriteSeeker, e.g. an *os.File, Close also rewrites the bag header with the
+// position of the index section, so the bag is indexed like one that's written by rosbag.
+// Otherwise, the bag header keeps an index_pos of 0, like a bag that's still being recorded.
+type Writer struct {
+	writer          io.Writer
+	chunkSize       int
+	compression     Compression
+	headerStampTime bool
+	encryptor       Encryptor
+	clock           *ClockCorrection
+	topicClocks     map[string]*ClockCorrection
+	conns           []*writerConnection
+	chunk           bytes.Buffer
+	index           *chunkIndex
+	// chunkInfos are the chunk info records of the index section
+	chunkInfos [][]byte
+	// offset is the number of bytes that have been written to writer
+	offset       int64
+	wroteVersion bool
+	closed       bool
+	err          error
+}
+
+// NewWriter creates a writer that writes a rosbag to w. opts can be used to enable optional
+// behaviors, see WriterOption. Nothing is written to w until the first record is written.
+func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
+	config := writerConfig{
+		chunkSize:   defaultChunkSize,
+		compression: CompressionNone,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	writer := &Writer{
+		writer:          w,
+		chunkSize:       config.chunkSize,
+		compression:     config.compression,
+		headerStampTime: config.headerStampTime,
+		encryptor:       config.encryptor,
+		clock:           config.clock,
+		topicClocks:     config.topicClocks,
+		index:           newChunkIndex(),
+	}
+
+	_, err := newChunkWriter(config.compression, nil)
+	if err != nil {
+		writer.err = err
+	}
+	return writer
+}
+
+// WriteConnection registers a connection, and returns its ID that's used by WriteMessage. Topic,
+// Type, MD5Sum, and RawMessageDefinition of hdr are written to the bag. When MessageDefinition
+// hasn't been parsed, it's parsed from RawMessageDefinition. An empty MD5Sum is computed from the
+// message definition, see MessageDefinition.MD5Sum.
+func (writer *Writer) WriteConnection(hdr *ConnectionHeader) (uint32, error) {
+	if writer.closed {
+		return 0, errWriterClosed
+	}
+
+	if len(hdr.MessageDefinition.Fields) == 0 && len(hdr.MessageDefinition.Constants) == 0 && hdr.RawMessageDefinition != "" {
+		err := hdr.MessageDefinition.unmarshall([]byte(hdr.RawMessageDefinition))
+		if err != nil {
+			return 0, err
+		}
+	}
+	hdr.MessageDefinition.normalizeConstants()
+
+	if hdr.MD5Sum == "" && (len(hdr.MessageDefinition.Fields) > 0 || len(hdr.MessageDefinition.Constants) > 0) {
+		hdr.MD5Sum = hdr.MessageDefinition.MD5Sum()
+	}
+
+	clock, ok := writer.topicClocks[hdr.Topic]
+	if !ok {
+		clock = writer.clock
+	}
+
+	conn := uint32(len(writer.conns))
+	writer.conns = append(writer.conns, &writerConnection{
+		header:    hdr,
+		hasHeader: startsWithHeader(&hdr.MessageDefinition),
+		clock:     clock,
+	})
+	return conn, nil
+}
+
+// WriteMessage writes a message data record with the serialized message data on conn. t is the
+// record time, usually the time when the message was received.
+func (writer *Writer) WriteMessage(conn uint32, t time.Time, data []byte) error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	if writer.closed {
+		return errWriterClosed
+	}
+
+	if conn >= uint32(len(writer.conns)) {
+		return errUnknownConnection
+	}
+
+	wconn := writer.conns[conn]
+	if !wconn.written {
+		writer.chunk.Write(encodeConnectionRecord(conn, wconn.header))
+		wconn.written = true
+	}
+
+	if wconn.clock != nil {
+		t = wconn.clock.Apply(t)
+		if wconn.hasHeader {
+			data = wconn.clock.correctHeaderStamp(data)
+		}
+	}
+
+	if writer.headerStampTime && wconn.hasHeader {
+		t = headerStamp(data, t)
+	}
+
+	writer.index.add(conn, t, writer.chunk.Len())
+	header := appendHeaderField(nil, "op", []byte{byte(OpMessageData)})
+	header = appendHeaderField(header, "conn", uint32Field(conn))
+	header = appendHeaderField(header, "time", timeField(t))
+	writer.chunk.Write(appendRecord(nil, header, data))
+
+	if writer.chunk.Len() >= writer.chunkSize {
+		return writer.flushChunk()
+	}
+	return nil
+}
+
+// WriteMessageFrom encodes v with the message definition of conn, see EncodeMessage, and writes
+// it like WriteMessage.
+func (writer *Writer) WriteMessageFrom(conn uint32, t time.Time, v interface{}) error {
+	if conn >= uint32(len(writer.conns)) {
+		return errUnknownConnection
+	}
+
+	data, err := EncodeMessage(&writer.conns[conn].header.MessageDefinition, v)
+	if err != nil {
+		return err
+	}
+	return writer.WriteMessage(conn, t, data)
+}
+
+// WriteRecord writes a record of a custom op, e.g. a vendor extension record, with the header
+// fields and data to the current chunk, so it's kept in order with the messages. The header
+// fields are written sorted by their keys after the op field. Ops that are defined by the format
+// can't be written with WriteRecord. Decoders read the record with the decode that's registered
+// with RegisterOp, or as a *RecordUnknown with UnknownOpsReturn.
+func (writer *Writer) WriteRecord(op Op, fields map[string][]byte, data []byte) error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	if writer.closed {
+		return errWriterClosed
+	}
+
+	record, err := encodeCustomRecord(op, fields, data)
+	if err != nil {
+		return err
+	}
+
+	writer.chunk.Write(record)
+	if writer.chunk.Len() >= writer.chunkSize {
+		return writer.flushChunk()
+	}
+	return nil
+}
+
+// Close writes out the pending chunk and the index section, and rewrites the bag header when the
+// underlying writer is seekable. Close doesn't close the underlying writer. The writer must not be
+// used after Close.
+func (writer *Writer) Close() error {
+	if writer.closed {
+		return writer.err
+	}
+
+	writer.closed = true
+	err := writer.flushChunk()
+	if err != nil {
+		return err
+	}
+
+	err = writer.writeIndex()
+	if err != nil {
+		return writer.fail(err)
+	}
+	return nil
+}
+
+// writeIndex writes the index section, which has the connection records of the connections with
+// messages, and the chunk infos, and rewrites the bag header to point to it.
+func (writer *Writer) writeIndex() error {
+	indexPos := writer.offset
+	var connCount uint32
+	for id, conn := range writer.conns {
+		if !conn.written {
+			continue
+		}
+
+		record := encodeConnectionRecord(uint32(id), conn.header)
+		if writer.encryptor != nil {
+			// like rosbag, only the connection header in the data is encrypted
+			var err error
+			record, err = encryptConnectionRecord(writer.encryptor, record)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := writer.write(record)
+		if err != nil {
+			return err
+		}
+		connCount++
+	}
+
+	err := writer.write(writer.chunkInfos...)
+	if err != nil {
+		return err
+	}
+
+	seeker, ok := writer.writer.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+
+	header, err := encodeBagHeader(uint64(indexPos), connCount, uint32(len(writer.chunkInfos)), writer.bagHeaderFields())
+	if err != nil {
+		return err
+	}
+
+	// the bag header follows the version line, and it has been padded to be rewritten in place
+	headerPos := int64(len(writer.versionLine()))
+	_, err = seeker.Seek(headerPos-writer.offset, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = seeker.Write(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = seeker.Seek(writer.offset-headerPos-bagHeaderLen, io.SeekCurrent)
+	return err
+}
+
+// encryptConnectionRecord returns record with its data encrypted by encryptor.
+func encryptConnectionRecord(encryptor Encryptor, record []byte) ([]byte, error) {
+	headerLen := endian.Uint32(record)
+	header := record[lenInBytes : lenInBytes+headerLen]
+	data, err := encryptor.EncryptHeader(record[2*lenInBytes+headerLen:])
+	if err != nil {
+		return nil, err
+	}
+	return appendRecord(nil, header, data), nil
+}
+
+func (writer *Writer) flushChunk() error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	err := writer.writeVersion()
+	if err != nil {
+		return writer.fail(err)
+	}
+
+	if writer.chunk.Len() == 0 {
+		return nil
+	}
+
+	// size is always the length of the plain chunk data
+	header := appendHeaderField(nil, "op", []byte{byte(OpChunk)})
+	header = appendHeaderField(header, "compression", []byte(writer.compression))
+	header = appendHeaderField(header, "size", uint32Field(uint32(writer.chunk.Len())))
+
+	data := writer.chunk.Bytes()
+	index := writer.index
+	if writer.compression != CompressionNone {
+		data, err = compressData(writer.compression, data)
+		if err != nil {
+			return writer.fail(err)
+		}
+	}
+
+	if writer.encryptor != nil {
+		data, err = writer.encryptor.EncryptChunk(data)
+		if err != nil {
+			return writer.fail(err)
+		}
+	}
+
+	pos := writer.offset
+	err = writer.write(appendRecord(nil, header, data), index.appendIndexData(nil))
+	if err != nil {
+		return writer.fail(err)
+	}
+
+	// a chunk with only custom records has no messages to take the times from
+	start, end := index.start, index.end
+	if len(index.conns) == 0 {
+		start, end = time.Unix(0, 0), time.Unix(0, 0)
+	}
+	writer.chunkInfos = append(writer.chunkInfos, encodeChunkInfoRecord(uint64(pos), start, end, index.counts()))
+
+	writer.chunk.Reset()
+	writer.index = newChunkIndex()
+	return nil
+}
+
+// write writes records to the underlying writer, and counts their bytes.
+func (writer *Writer) write(records ...[]byte) error {
+	for _, record := range records {
+		n, err := writer.writer.Write(record)
+		writer.offset += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (writer *Writer) versionLine() string {
+	return fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor)
+}
+
+func (writer *Writer) bagHeaderFields() map[string][]byte {
+	if writer.encryptor == nil {
+		return nil
+	}
+
+	fields := map[string][]byte{"encryptor": []byte(writer.encryptor.Name())}
+	for key, value := range writer.encryptor.HeaderFields() {
+		fields[key] = value
+	}
+	return fields
+}
+
+// writeVersion writes the version line and the bag header before the first chunk.
+func (writer *Writer) writeVersion() error {
+	if writer.wroteVersion {
+		return nil
+	}
+
+	// the bag header is padded, so it can be rewritten with the index_pos at the end
+	header, err := encodeBagHeader(0, 0, 0, writer.bagHeaderFields())
+	if err != nil {
+		return err
+	}
+
+	err = writer.write([]byte(writer.versionLine()), header)
+	if err != nil {
+		return err
+	}
+
+	writer.wroteVersion = true
+	return nil
+}
+
+// fail stores err so that the following writes return the same error.
+func (writer *Writer) fail(err error) error {
+	writer.err = err
+	return err
+}
+
+// startsWithHeader returns true when the first field of def is a std_msgs/Header.
+func startsWithHeader(def *MessageDefinition) bool {
+	if len(def.Fields) == 0 {
+		return false
+	}
+
+	field := def.Fields[0]
+	return field.Type == MessageFieldTypeComplex && !field.IsArray && field.MsgType != nil &&
+		field.MsgType.Type == "std_msgs/Header"
+}
+
+// headerStamp returns the stamp of the std_msgs/Header at the beginning of data, which is
+// <seq:uint32><stamp:time>. fallback is returned when data is too short or the stamp is zero.
+func headerStamp(data []byte, fallback time.Time) time.Time {
+	if len(data) < 12 {
+		return fallback
+	}
+
+	if endian.Uint32(data[4:]) == 0 && endian.Uint32(data[8:]) == 0 {
+		return fallback
+	}
+	return extractTime(data[4:])
+}
+
+// encodeBagHeader encodes the bag header with the extra fields, e.g. the fields of the encryptor,
+// which are sorted by their keys.
+func encodeBagHeader(indexPos uint64, connCount, chunkCount uint32, fields map[string][]byte) ([]byte, error) {
+	indexPosField := make([]byte, 8)
+	endian.PutUint64(indexPosField, indexPos)
+
+	header := appendHeaderField(nil, "op", []byte{byte(OpBagHeader)})
+	header = appendHeaderField(header, "index_pos", indexPosField)
+	header = appendHeaderField(header, "conn_count", uint32Field(connCount))
+	header = appendHeaderField(header, "chunk_count", uint32Field(chunkCount))
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		header = appendHeaderField(header, key, fields[key])
+	}
+
+	if len(header)+2*lenInBytes > bagHeaderLen {
+		return nil, errBagHeaderTooLong
+	}
+
+	// the data is padded with spaces so that the whole record is bagHeaderLen long
+	padding := bytes.Repeat([]byte{' '}, bagHeaderLen-len(header)-2*lenInBytes)
+	return appendRecord(nil, header, padding), nil
+}
+
+// bagHeaderExtraFields returns the fields of the bag header record besides the ones that are
+// rewritten by encodeBagHeader, e.g. the fields of the encryptor.
+func bagHeaderExtraFields(record *RecordBase) (map[string][]byte, error) {
+	fields := make(map[string][]byte)
+	err := iterateHeaderFields(record.Header(), func(key, value []byte) bool {
+		switch string(key) {
+		case "op", "index_pos", "conn_count", "chunk_count":
+		default:
+			fields[string(key)] = value
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func encodeConnectionRecord(conn uint32, hdr *ConnectionHeader) []byte {
+	header := appendHeaderField(nil, "op", []byte{byte(OpConnection)})
+	header = appendHeaderField(header, "conn", uint32Field(conn))
+	header = appendHeaderField(header, "topic", []byte(hdr.Topic))
+
+	data := appendHeaderField(nil, "topic", []byte(hdr.Topic))
+	data = appendHeaderField(data, "type", []byte(hdr.Type))
+	data = appendHeaderField(data, "md5sum", []byte(hdr.MD5Sum))
+	data = appendHeaderField(data, "message_definition", []byte(hdr.RawMessageDefinition))
+	return appendRecord(nil, header, data)
+}
+
+// appendHeaderField appends <field_len><key>=<value> to b.
+func appendHeaderField(b []byte, key string, value []byte) []byte {
+	b = append(b, uint32Field(uint32(len(key)+1+len(value)))...)
+	b = append(b, key...)
+	b = append(b, headerFieldDelimiter)
+	return append(b, value...)
+}
+
+// appendRecord appends <header_len><header><data_len><data> to b.
+func appendRecord(b []byte, header, data []byte) []byte {
+	b = append(b, uint32Field(uint32(len(header)))...)
+	b = append(b, header...)
+	b = append(b, uint32Field(uint32(len(data)))...)
+	return append(b, data...)
+}
+
+func uint32Field(v uint32) []byte {
+	b := make([]byte, lenInBytes)
+	endian.PutUint32(b, v)
+	return b
+}
+
+// chunkIndex collects the index entries of the messages of a chunk, which are written as the index
+// data records after the chunk, and summarized by the chunk info in the index section.
+type chunkIndex struct {
+	// conns are in the order of their first messages in the chunk
+	conns   []uint32
+	entries map[uint32][]byte
+	start   time.Time
+	end     time.Time
+}
+
+func newChunkIndex() *chunkIndex {
+	return &chunkIndex{entries: make(map[uint32][]byte)}
+}
+
+// add adds the message on conn at t, which starts at offset in the chunk data.
+func (index *chunkIndex) add(conn uint32, t time.Time, offset int) {
+	entries, ok := index.entries[conn]
+	if !ok {
+		index.conns = append(index.conns, conn)
+	}
+
+	entries = append(entries, timeField(t)...)
+	index.entries[conn] = append(entries, uint32Field(uint32(offset))...)
+	if index.start.IsZero() || t.Before(index.start) {
+		index.start = t
+	}
+	if t.After(index.end) {
+		index.end = t
+	}
+}
+
+// counts returns the number of messages of every connection.
+func (index *chunkIndex) counts() map[uint32]uint32 {
+	counts := make(map[uint32]uint32, len(index.conns))
+	for _, conn := range index.conns {
+		counts[conn] = uint32(len(index.entries[conn]) / indexEntrySize)
+	}
+	return counts
+}
+
+// appendIndexData appends the index data record of every connection to b.
+func (index *chunkIndex) appendIndexData(b []byte) []byte {
+	for _, conn := range index.conns {
+		entries := index.entries[conn]
+		header := appendHeaderField(nil, "op", []byte{byte(OpIndexData)})
+		header = appendHeaderField(header, "ver", uint32Field(1))
+		header = appendHeaderField(header, "conn", uint32Field(conn))
+		header = appendHeaderField(header, "count", uint32Field(uint32(len(entries)/indexEntrySize)))
+		b = appendRecord(b, header, entries)
+	}
+	return b
+}
+
+// encodeChunkInfoRecord encodes the chunk info of the chunk at chunkPos with the message counts of
+// its connections, which are sorted by their IDs.
+func encodeChunkInfoRecord(chunkPos uint64, start, end time.Time, counts map[uint32]uint32) []byte {
+	conns := make([]uint32, 0, len(counts))
+	for conn := range counts {
+		conns = append(conns, conn)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i] < conns[j]
+	})
+
+	posField := make([]byte, 8)
+	endian.PutUint64(posField, chunkPos)
+	header := appendHeaderField(nil, "op", []byte{byte(OpChunkInfo)})
+	header = appendHeaderField(header, "ver", uint32Field(1))
+	header = appendHeaderField(header, "chunk_pos", posField)
+	header = appendHeaderField(header, "start_time", timeField(start))
+	header = appendHeaderField(header, "end_time", timeField(end))
+	header = appendHeaderField(header, "count", uint32Field(uint32(len(conns))))
+
+	data := make([]byte, 0, len(conns)*2*lenInBytes)
+	for _, conn := range conns {
+		data = append(data, uint32Field(conn)...)
+		data = append(data, uint32Field(counts[conn])...)
+	}
+	return appendRecord(nil, header, data)
+}
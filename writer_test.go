@@ -0,0 +1,524 @@
+package rosbag
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHeaderMessageDefinition = `Header header
+uint8 x
+
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+`
+
+func encodeTestHeaderMessage(stamp time.Time, x uint8) []byte {
+	data := uint32Bytes(0)
+	data = append(data, timeField(stamp)...)
+	data = append(data, uint32Bytes(0)...)
+	return append(data, x)
+}
+
+type writtenMessage struct {
+	Topic string
+	Time  time.Time
+	X     uint8
+}
+
+func readWrittenMessages(t *testing.T, raw []byte) []writtenMessage {
+	var messages []writtenMessage
+	decoder := NewDecoder(bytes.NewReader(raw))
+	for {
+		record, err := decoder.Read()
+		if err == io.EOF {
+			return messages
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, ok := record.(*RecordMessageData); ok {
+			recordTime, err := msg.Time()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := make(map[string]interface{})
+			err = msg.ViewAs(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages = append(messages, writtenMessage{
+				Topic: msg.ConnectionHeader().Topic,
+				Time:  recordTime,
+				X:     v["x"].(uint8),
+			})
+		}
+		record.Close()
+	}
+}
+
+func TestWriter(t *testing.T) {
+	receive := time.Unix(100, 0)
+	stamp := time.Unix(50, 500)
+
+	testCases := []struct {
+		Name     string
+		Opts     []WriterOption
+		Expected []time.Time
+	}{
+		{
+			Name:     "Receive Time",
+			Expected: []time.Time{receive, receive, receive},
+		},
+		{
+			Name: "Header Stamp Time",
+			Opts: []WriterOption{WithHeaderStampTime()},
+			// the stamp of the second message is zero, and the third message has no header
+			Expected: []time.Time{stamp, receive, receive},
+		},
+		{
+			Name:     "Small Chunks",
+			Opts:     []WriterOption{WithChunkSize(1)},
+			Expected: []time.Time{receive, receive, receive},
+		},
+		{
+			Name:     "LZ4 Chunks",
+			Opts:     []WriterOption{WithCompression(CompressionLZ4), WithChunkSize(64)},
+			Expected: []time.Time{receive, receive, receive},
+		},
+		{
+			Name:     "BZ2 Chunks",
+			Opts:     []WriterOption{WithCompression(CompressionBZ2), WithChunkSize(64)},
+			Expected: []time.Time{receive, receive, receive},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := NewWriter(&buf, testCase.Opts...)
+
+			withHeader, err := writer.WriteConnection(&ConnectionHeader{
+				Topic:                "/header",
+				Type:                 "test_msgs/Stamped",
+				RawMessageDefinition: testHeaderMessageDefinition,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			withoutHeader, err := writer.WriteConnection(&ConnectionHeader{
+				Topic:                "/test",
+				Type:                 "test_msgs/Test",
+				RawMessageDefinition: "uint8 x",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writes := []struct {
+				Conn uint32
+				Data []byte
+			}{
+				{withHeader, encodeTestHeaderMessage(stamp, 1)},
+				{withHeader, encodeTestHeaderMessage(time.Unix(0, 0), 2)},
+				{withoutHeader, []byte{3}},
+			}
+			for _, write := range writes {
+				err = writer.WriteMessage(write.Conn, receive, write.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := readWrittenMessages(t, buf.Bytes())
+			if len(actual) != len(writes) {
+				t.Fatalf("expected %d messages, but got %d", len(writes), len(actual))
+			}
+
+			for i, msg := range actual {
+				if msg.X != uint8(i+1) {
+					t.Fatalf("expected message %d to be %d, but got %d", i, i+1, msg.X)
+				}
+
+				if !msg.Time.Equal(testCase.Expected[i]) {
+					t.Fatalf("expected message %d time to be %v, but got %v", i, testCase.Expected[i], msg.Time)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterClockCorrection(t *testing.T) {
+	receive := time.Unix(100, 0)
+	stamp := time.Unix(50, 500)
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf,
+		WithHeaderStampTime(),
+		WithClockCorrection(ClockCorrection{Offset: time.Second}),
+		WithTopicClockCorrection("/test", ClockCorrection{Offset: -time.Second, Drift: 0.5, Reference: time.Unix(90, 0)}),
+	)
+
+	withHeader, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/header",
+		Type:                 "test_msgs/Stamped",
+		RawMessageDefinition: testHeaderMessageDefinition,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withoutHeader, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := encodeTestHeaderMessage(stamp, 1)
+	writes := []struct {
+		Conn uint32
+		Data []byte
+	}{
+		{withHeader, data},
+		{withHeader, encodeTestHeaderMessage(time.Unix(0, 0), 2)},
+		{withoutHeader, []byte{3}},
+	}
+	for _, write := range writes {
+		err = writer.WriteMessage(write.Conn, receive, write.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, encodeTestHeaderMessage(stamp, 1)) {
+		t.Fatal("expected the data of WriteMessage to be kept as is")
+	}
+
+	expected := []time.Time{
+		// the corrected stamp is the record time
+		stamp.Add(time.Second),
+		// the zero stamp is kept, so the corrected receive time is the record time
+		receive.Add(time.Second),
+		// 100s is offset to 99s, and drifts by 0.5 * 10s since the reference
+		time.Unix(104, 0),
+	}
+
+	actual := readWrittenMessages(t, buf.Bytes())
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d messages, but got %d", len(expected), len(actual))
+	}
+
+	for i, msg := range actual {
+		if !msg.Time.Equal(expected[i]) {
+			t.Fatalf("expected message %d time to be %v, but got %v", i, expected[i], msg.Time)
+		}
+	}
+}
+
+func TestWriterUnknownConnection(t *testing.T) {
+	writer := NewWriter(ioutil.Discard)
+	err := writer.WriteMessage(0, time.Now(), nil)
+	if err != errUnknownConnection {
+		t.Fatalf("expected %v, but got %v", errUnknownConnection, err)
+	}
+}
+
+func TestWriterIndex(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.bag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	writer := NewWriter(f, WithCompression(CompressionLZ4), WithChunkSize(64))
+	var conns []uint32
+	for _, topic := range []string{"/a", "/unused", "/b"} {
+		conn, err := writer.WriteConnection(&ConnectionHeader{
+			Topic:                topic,
+			Type:                 "test_msgs/Test",
+			MD5Sum:               "b7b8b5ba5a046619082c001d6588d6d8",
+			RawMessageDefinition: "uint8 x",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	for i := 0; i < 20; i++ {
+		conn := conns[0]
+		if i%3 == 0 {
+			conn = conns[2]
+		}
+
+		err = writer.WriteMessage(conn, time.Unix(int64(i+1), 0), []byte{uint8(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := Check(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 0 {
+		t.Fatalf("expected the bag to be valid, but got %v", findings)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(f)
+	err = decoder.Preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := decoder.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer record.Close()
+
+	bagHeader := record.(*RecordBagHeader)
+	indexPos, err := bagHeader.IndexPos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connCount, err := bagHeader.ConnCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunkCount, err := bagHeader.ChunkCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unused connection is not in the bag
+	if indexPos == 0 || connCount != 2 || int(chunkCount) != len(decoder.ChunkInfos()) || chunkCount < 2 {
+		t.Fatalf("expected the bag header to point to 2 connections and %d chunks, but got index_pos %d, %d connections, and %d chunks",
+			len(decoder.ChunkInfos()), indexPos, connCount, chunkCount)
+	}
+
+	var messages uint32
+	for _, info := range decoder.ChunkInfos() {
+		counts, err := info.MessageCounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, count := range counts {
+			messages += count
+		}
+	}
+
+	if messages != 20 {
+		t.Fatalf("expected the chunk infos to count 20 messages, but got %d", messages)
+	}
+}
+
+func TestWriterInvalidCompression(t *testing.T) {
+	writer := NewWriter(ioutil.Discard, WithCompression(Compression("unknown")))
+	conn, err := writer.WriteConnection(&ConnectionHeader{Topic: "/test", Type: "test_msgs/Test", RawMessageDefinition: "uint8 x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.WriteMessage(conn, time.Now(), []byte{1})
+	if err != errCompressionNotWritable {
+		t.Fatalf("expected %v, but got %v", errCompressionNotWritable, err)
+	}
+
+	err = writer.Close()
+	if err != errCompressionNotWritable {
+		t.Fatalf("expected %v from Close, but got %v", errCompressionNotWritable, err)
+	}
+}
+
+// decryptTestChunk decrypts the data of a chunk that's encrypted by the AES-CBC encryptor.
+func decryptTestChunk(t *testing.T, key, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("expected an IV and whole blocks, but got %d bytes", len(data))
+	}
+
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		t.Fatalf("invalid PKCS#7 padding: %v", plain[len(plain)-aes.BlockSize:])
+	}
+	return plain[:len(plain)-padding]
+}
+
+func TestWriterEncryptor(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	encryptor, err := NewAESCBCEncryptor(key, "Robot <robot@example.com>", []byte("gpg encrypted key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, WithEncryptor(encryptor))
+	conn, err := writer.WriteConnection(&ConnectionHeader{
+		Topic:                "/test",
+		Type:                 "test_msgs/Test",
+		RawMessageDefinition: "uint8 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = writer.WriteMessage(conn, time.Unix(int64(i), 0), []byte{uint8(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := buf.Bytes()
+	decoder := NewDecoder(bytes.NewReader(raw))
+	record, err := decoder.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bagHeader, ok := record.(*RecordBagHeader)
+	if !ok {
+		t.Fatalf("expected a bag header, but got %T", record)
+	}
+
+	fields := map[string]string{
+		"encryptor":     EncryptorAESCBC,
+		"gpg_key_user":  "Robot <robot@example.com>",
+		"encrypted_key": "gpg encrypted key",
+	}
+	for key, expected := range fields {
+		value, err := bagHeader.findField([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != expected {
+			t.Fatalf("expected %s to be %q, but got %q", key, expected, value)
+		}
+	}
+
+	// the chunk follows the padded bag header
+	versionLen := len(fmt.Sprintf(versionFormat, supportedVersion.Major, supportedVersion.Minor))
+	chunk := raw[versionLen+bagHeaderLen:]
+	headerLen := endian.Uint32(chunk)
+	header := chunk[lenInBytes : lenInBytes+headerLen]
+	dataLen := endian.Uint32(chunk[lenInBytes+headerLen:])
+	data := chunk[2*lenInBytes+headerLen:][:dataLen]
+
+	plain := decryptTestChunk(t, key, data)
+
+	// the connection record of the index section follows the index data of the chunk, and its
+	// connection header is encrypted like a chunk
+	rest := chunk[2*lenInBytes+headerLen+dataLen:]
+	indexDataLen := 2*lenInBytes + endian.Uint32(rest) + endian.Uint32(rest[lenInBytes+endian.Uint32(rest):])
+	connRecord := rest[indexDataLen:]
+	connHeaderLen := endian.Uint32(connRecord)
+	connDataLen := endian.Uint32(connRecord[lenInBytes+connHeaderLen:])
+	connData := decryptTestChunk(t, key, connRecord[2*lenInBytes+connHeaderLen:][:connDataLen])
+	if !bytes.Contains(connData, []byte("topic=/test")) {
+		t.Fatalf("expected the decrypted connection header to have the topic, but got %q", connData)
+	}
+	chunkRecord := RecordChunk{RecordBase: &RecordBase{Raw: chunk, HeaderLen: headerLen}}
+	size, err := chunkRecord.findFieldUint32([]byte("size"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int(size) != len(plain) {
+		t.Fatalf("expected the chunk size to be %d, but got %d", len(plain), size)
+	}
+
+	// the decrypted chunk makes a plain bag with the same messages
+	decrypted := append([]byte(nil), raw[:versionLen]...)
+	bagHeaderRecord, err := encodeBagHeader(0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted = append(decrypted, bagHeaderRecord...)
+	decrypted = appendRecord(decrypted, header, plain)
+
+	messages := readWrittenMessages(t, decrypted)
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, but got %d", len(messages))
+	}
+
+	for i, msg := range messages {
+		if msg.X != uint8(i+1) || !msg.Time.Equal(time.Unix(int64(i+1), 0)) {
+			t.Fatalf("expected message %d to be %d at %ds, but got %+v", i, i+1, i+1, msg)
+		}
+	}
+}
+
+func TestNewAESCBCEncryptorInvalidKey(t *testing.T) {
+	_, err := NewAESCBCEncryptor(make([]byte, 32), "robot", nil)
+	if err == nil {
+		t.Fatal("expected a key that's not 16 bytes to fail")
+	}
+}
+
+func TestEncodeBagHeaderTooLong(t *testing.T) {
+	_, err := encodeBagHeader(0, 0, 0, map[string][]byte{"encrypted_key": make([]byte, bagHeaderLen)})
+	if err != errBagHeaderTooLong {
+		t.Fatalf("expected %v, but got %v", errBagHeaderTooLong, err)
+	}
+}